@@ -0,0 +1,608 @@
+// Package auth validates JWT bearer tokens issued by the identity provider and
+// maps their role claim, and their OAuth2 scopes, to the roles this service
+// authorizes on. Keys come from the provider's JWKS and are refreshed in the
+// background, so key rotation needs no restart. Opaque OAuth2 access tokens
+// are checked with the authorization server's introspection endpoint.
+// Integrations can instead use API keys, which can be scoped to a set of
+// accounts. Internal services authenticate with their SPIFFE ID over mutual
+// TLS or with short-lived service tokens they sign themselves.
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"sub-balance-demo/internal/tenant"
+
+	"github.com/MicahParks/keyfunc/v3"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+)
+
+// Role yang dipakai untuk otorisasi. Setiap role mencakup role di bawahnya:
+// admin > service > read-only.
+const (
+	RoleReadOnly = "read-only" // route baca: balance dan pending
+	RoleService  = "service"   // route transaksi
+	RoleAdmin    = "admin"     // /admin dan /test: settlement, consistency, retention, quarantine
+)
+
+// Public marks a route in a Policy that needs no token
+const Public = "public"
+
+var roleRank = map[string]int{RoleReadOnly: 1, RoleService: 2, RoleAdmin: 3}
+
+// Policy maps "METHOD /route/template" to the role the route requires, or
+// Public. Routes of a protected group missing from the policy are denied, so
+// a new route cannot be exposed by forgetting to classify it.
+type Policy map[string]string
+
+// Route is the Policy key of a route
+func Route(method string, path string) string {
+	return method + " " + path
+}
+
+// clockSkew tolerates small clock differences with the identity provider on
+// exp/nbf/iat
+const clockSkew = 30 * time.Second
+
+// Principal is the authenticated caller of a request. Accounts and
+// AccountPrefixes restrict which accounts it may act on; both empty means
+// every account. Tenant binds it to one tenant with multi-tenancy; Internal
+// marks service-to-service callers (mutual TLS, service tokens).
+type Principal struct {
+	Subject         string
+	Roles           []string
+	Accounts        []string
+	AccountPrefixes []string
+	Tenant          string
+	Internal        bool
+}
+
+// ErrTenantNotPermitted is returned when a request names a tenant its
+// credential is not bound to
+var ErrTenantNotPermitted = errors.New("tenant not permitted for this credential")
+
+// ResolveTenant returns the tenant a request of p acts on, given the tenant
+// the request names (empty if none). A credential bound to a tenant acts on
+// that tenant and may only name it. An unbound credential may name any tenant
+// only if it is an admin or an internal service; for other callers the
+// request cannot pick the tenant, so they are refused.
+func (p Principal) ResolveTenant(requested string) (string, error) {
+	switch {
+	case p.Tenant != "":
+		if requested != "" && requested != p.Tenant {
+			return "", ErrTenantNotPermitted
+		}
+		return p.Tenant, nil
+	case p.Internal || p.HasRole(RoleAdmin):
+		return requested, nil
+	default:
+		return "", ErrTenantNotPermitted
+	}
+}
+
+// HasRole reports whether p holds role or a role that includes it
+func (p Principal) HasRole(role string) bool {
+	for _, held := range p.Roles {
+		if roleRank[held] >= roleRank[role] && roleRank[role] > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+type contextKey struct{}
+
+// WithPrincipal returns a context carrying p
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+// FromContext returns the principal of an authenticated request, if any
+func FromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(contextKey{}).(Principal)
+	return p, ok
+}
+
+// Options configures an Authenticator
+type Options struct {
+	Issuer string
+	// JWKSURL defaults to the jwks_uri of the issuer's OpenID configuration
+	JWKSURL    string
+	Audience   string
+	Algorithms []string
+	// RolesClaim is the claim holding the caller's roles, either a list or a
+	// space separated string. Dots address nested claims, e.g.
+	// realm_access.roles.
+	RolesClaim string
+	// RoleMapping maps identity provider roles to service roles; without it
+	// claim values are used as service roles directly
+	RoleMapping map[string]string
+	// AccountsClaim is the claim listing the accounts a token's caller owns.
+	// A token with accounts can only act on those, like a scoped API key;
+	// empty or a token without the claim means every account.
+	AccountsClaim string
+	// TenantClaim is the claim naming the tenant a token is bound to; empty
+	// or a token without the claim is not bound to a tenant
+	TenantClaim string
+	// ScopeMapping maps OAuth2 scopes (the scope or scp claim) to service
+	// roles, e.g. transaction:write to service; unmapped scopes grant nothing
+	ScopeMapping map[string]string
+
+	// IntrospectionURL validates tokens that are not JWTs, or every token when
+	// Issuer is empty, with RFC 7662 token introspection. The service
+	// authenticates to it as OAuth2 client ClientID.
+	IntrospectionURL      string
+	ClientID              string
+	ClientSecret          string
+	IntrospectionCacheTTL time.Duration
+
+	// APIKeys are accepted in APIKeyHeader, next to or instead of JWTs
+	APIKeys      []APIKey
+	APIKeyHeader string
+	// SignatureWindow is how far the timestamp of a signed request may be from
+	// server time; Nonces rejects a second request with the same nonce. Both
+	// are required when an API key has a signing secret.
+	SignatureWindow time.Duration
+	Nonces          *NonceCache
+
+	// SPIFFEIDs maps the SPIFFE IDs of internal callers, taken from verified
+	// mutual TLS client certificates, to service roles
+	SPIFFEIDs map[string]string
+	// ServiceKeys verify service tokens: JWTs an internal caller signs with
+	// its own key, for ServiceTokenAudience and valid at most
+	// ServiceTokenMaxTTL
+	ServiceKeys          []ServiceKey
+	ServiceTokenAudience string
+	ServiceTokenMaxTTL   time.Duration
+
+	// Failures counts failed authentications and blocks abusive IPs; nil
+	// disables both
+	Failures *FailureTracker
+	// AdminLockout locks IPs out of admin routes after repeated failed
+	// authentications on them, across instances; nil disables it
+	AdminLockout *AdminLockout
+}
+
+// Authenticator validates bearer tokens and enforces a Policy. A nil
+// *Authenticator lets every request through, so routes can be wired the same
+// with auth disabled.
+type Authenticator struct {
+	options      Options
+	keys         keyfunc.Keyfunc // nil tanpa JWT (hanya API key)
+	parser       *jwt.Parser
+	introspector *introspector         // nil tanpa introspection
+	apiKeys      map[string]APIKey     // by key_sha256
+	serviceKeys  map[string]ServiceKey // by name (iss)
+}
+
+// New sets up API keys, token introspection when options.IntrospectionURL is
+// set and, when options.Issuer is set, JWT validation; the JWKS is fetched and
+// kept refreshed until ctx is done
+func New(ctx context.Context, options Options) (*Authenticator, error) {
+	a := &Authenticator{
+		options:     options,
+		apiKeys:     make(map[string]APIKey, len(options.APIKeys)),
+		serviceKeys: make(map[string]ServiceKey, len(options.ServiceKeys)),
+	}
+	for _, key := range options.APIKeys {
+		if key.SigningSecret != "" {
+			switch {
+			case len(key.SigningSecret) < minSigningSecretLength:
+				return nil, fmt.Errorf("API key %q: signing_secret must be at least %d bytes", key.Name, minSigningSecretLength)
+			case options.Nonces == nil || options.SignatureWindow <= 0:
+				return nil, fmt.Errorf("API key %q: signed requests need a nonce cache and a signature window", key.Name)
+			}
+		}
+		a.apiKeys[key.KeySHA256] = key
+	}
+	if len(options.ServiceKeys) > 0 && options.ServiceTokenAudience == "" {
+		return nil, errors.New("service token audience is required for service keys")
+	}
+	for _, key := range options.ServiceKeys {
+		a.serviceKeys[key.Name] = key
+	}
+	if options.IntrospectionURL != "" {
+		if options.ClientID == "" {
+			return nil, errors.New("client ID is required for token introspection")
+		}
+		a.introspector = newIntrospector(options)
+	}
+	if options.Issuer == "" {
+		if len(a.apiKeys) == 0 && a.introspector == nil && len(a.serviceKeys) == 0 && len(options.SPIFFEIDs) == 0 {
+			return nil, errors.New("jwt issuer, introspection URL, API keys or service identities are required")
+		}
+		return a, nil
+	}
+
+	if options.JWKSURL == "" {
+		jwksURL, err := discoverJWKS(ctx, options.Issuer)
+		if err != nil {
+			return nil, err
+		}
+		options.JWKSURL = jwksURL
+		a.options.JWKSURL = jwksURL
+	}
+
+	keys, err := keyfunc.NewDefaultCtx(ctx, []string{options.JWKSURL})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load JWKS from %s: %w", options.JWKSURL, err)
+	}
+
+	parserOptions := []jwt.ParserOption{
+		jwt.WithIssuer(options.Issuer),
+		jwt.WithValidMethods(options.Algorithms),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(clockSkew),
+	}
+	if options.Audience != "" {
+		parserOptions = append(parserOptions, jwt.WithAudience(options.Audience))
+	}
+
+	a.keys = keys
+	a.parser = jwt.NewParser(parserOptions...)
+	return a, nil
+}
+
+// JWKSURL is the key set the authenticator verifies against
+func (a *Authenticator) JWKSURL() string {
+	return a.options.JWKSURL
+}
+
+// discoverJWKS reads jwks_uri from the issuer's OpenID configuration
+func discoverJWKS(ctx context.Context, issuer string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	url := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to fetch %s: status %d", url, resp.StatusCode)
+	}
+
+	var document struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&document); err != nil {
+		return "", fmt.Errorf("failed to decode %s: %w", url, err)
+	}
+	if document.JWKSURI == "" {
+		return "", fmt.Errorf("%s has no jwks_uri", url)
+	}
+	return document.JWKSURI, nil
+}
+
+// Authenticate validates a raw token and returns its principal. Service
+// tokens are verified with the key of the service that issued them, other
+// JWTs locally against the JWKS when an issuer is configured; other tokens go
+// to the introspection endpoint.
+func (a *Authenticator) Authenticate(ctx context.Context, token string) (Principal, error) {
+	if key, ok := a.serviceKey(token); ok {
+		return a.authenticateService(token, key)
+	}
+	if a.parser != nil && strings.Count(token, ".") == 2 {
+		claims := jwt.MapClaims{}
+		_, err := a.parser.ParseWithClaims(token, claims, a.keys.KeyfuncCtx(ctx))
+		if err != nil {
+			return Principal{}, err
+		}
+		return a.principal(claims)
+	}
+	if a.introspector == nil {
+		return Principal{}, errors.New("token is not a JWT and introspection is not configured")
+	}
+
+	claims, err := a.introspector.introspect(ctx, token)
+	if err != nil {
+		return Principal{}, err
+	}
+	if err := a.checkIntrospected(claims); err != nil {
+		return Principal{}, err
+	}
+	return a.principal(claims)
+}
+
+// checkIntrospected applies the issuer and audience checks JWTs get to an
+// introspection response, for the claims the server returned
+func (a *Authenticator) checkIntrospected(claims jwt.MapClaims) error {
+	if issuer, _ := claims.GetIssuer(); a.options.Issuer != "" && issuer != "" && issuer != a.options.Issuer {
+		return fmt.Errorf("token issuer %q is not %q", issuer, a.options.Issuer)
+	}
+	if a.options.Audience == "" {
+		return nil
+	}
+	if audience, _ := claims.GetAudience(); !slices.Contains(audience, a.options.Audience) {
+		return fmt.Errorf("token audience does not include %q", a.options.Audience)
+	}
+	return nil
+}
+
+// principal builds the caller of a validated token. Client credentials tokens
+// often have no subject, their client_id identifies the caller instead.
+func (a *Authenticator) principal(claims jwt.MapClaims) (Principal, error) {
+	subject, _ := claims.GetSubject()
+	if subject == "" {
+		if clientID, _ := claims["client_id"].(string); clientID != "" {
+			subject = "client:" + clientID
+		}
+	}
+
+	roles := a.roles(claims)
+	for _, scope := range scopes(claims) {
+		role := a.options.ScopeMapping[scope]
+		if roleRank[role] > 0 && !slices.Contains(roles, role) {
+			roles = append(roles, role)
+		}
+	}
+
+	principal := Principal{Subject: subject, Roles: roles}
+	if a.options.AccountsClaim != "" {
+		principal.Accounts = claimStrings(claims, a.options.AccountsClaim)
+	}
+	if len(principal.Accounts) > 0 && slices.Contains(roles, RoleAdmin) {
+		return Principal{}, errors.New("account-scoped tokens cannot have the admin role")
+	}
+	if a.options.TenantClaim != "" {
+		if id, ok := claims[a.options.TenantClaim]; ok {
+			principal.Tenant, _ = id.(string)
+			if err := tenant.Validate(principal.Tenant); err != nil {
+				return Principal{}, fmt.Errorf("invalid %s claim: %w", a.options.TenantClaim, err)
+			}
+		}
+	}
+	return principal, nil
+}
+
+// scopes reads the OAuth2 scopes of a token: the space separated scope claim
+// (RFC 9068, introspection) or the scp claim some servers use
+func scopes(claims jwt.MapClaims) []string {
+	if _, ok := claims["scope"]; ok {
+		return claimStrings(claims, "scope")
+	}
+	return claimStrings(claims, "scp")
+}
+
+// claimStrings reads a claim holding a list or a space separated string. Dots
+// in path address nested claims, e.g. realm_access.roles.
+func claimStrings(claims jwt.MapClaims, path string) []string {
+	var value interface{} = map[string]interface{}(claims)
+	for _, part := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[part]
+	}
+
+	var result []string
+	switch v := value.(type) {
+	case string:
+		result = strings.Fields(v)
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				result = append(result, s)
+			}
+		}
+	}
+	return result
+}
+
+// roles maps the roles claim to service roles, dropping unknown ones
+func (a *Authenticator) roles(claims jwt.MapClaims) []string {
+	var roles []string
+	for _, role := range claimStrings(claims, a.options.RolesClaim) {
+		if len(a.options.RoleMapping) > 0 {
+			role = a.options.RoleMapping[role]
+		}
+		if roleRank[role] > 0 && !slices.Contains(roles, role) {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// Authorize enforces policy on the routes of a group: public routes pass,
+// IPs blocked by Failures (or, on admin routes, by AdminLockout) get 429,
+// others need a valid bearer token (401) holding the route's role (403).
+// Requests made with an API key that has a signing secret must also be
+// signed, with a fresh nonce (401 with a code, see verifySignature). An
+// account_id in the path or query the caller may not access is 403 on writes
+// and 404 on reads, so reads do not reveal which other accounts exist. The
+// principal is put in the request context.
+func (a *Authenticator) Authorize(policy Policy) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		if a == nil {
+			return next
+		}
+		return func(c echo.Context) error {
+			route := Route(c.Request().Method, c.Path())
+			role, ok := policy[route]
+			if !ok && strings.HasSuffix(c.Path(), "/*") {
+				return next(c) // catch-all group: path tidak dikenal, biarkan jadi 404
+			}
+			if !ok {
+				log.Printf("No authorization policy for %s, denying", route)
+				return forbidden(c, "Route not permitted")
+			}
+			if role == Public {
+				return next(c)
+			}
+
+			ip := c.RealIP()
+			if retryAfter, blocked := a.options.Failures.Blocked(ip); blocked {
+				c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				return c.JSON(http.StatusTooManyRequests, map[string]string{
+					"error": "Too many failed authentication attempts",
+				})
+			}
+			if role == RoleAdmin {
+				if retryAfter, locked := a.options.AdminLockout.Locked(c.Request().Context(), ip); locked {
+					c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+					return c.JSON(http.StatusTooManyRequests, map[string]string{
+						"error": "Locked out of admin endpoints after repeated failed authentication",
+					})
+				}
+			}
+
+			principal, failure := a.authenticateCredentials(c.Request().Context(), c.Request().Header.Get, c.Request().TLS)
+			if failure.reason == FailureIntrospectionUnavailable {
+				log.Printf("Authentication unavailable: ip=%s method=%s route=%s: %s",
+					ip, c.Request().Method, c.Path(), failure.message)
+				a.options.Failures.RecordFailure(ip, failure.reason)
+				return c.JSON(http.StatusServiceUnavailable, map[string]string{
+					"error": "Authorization server unavailable, please retry later",
+				})
+			}
+			if failure.reason != "" {
+				log.Printf("Authentication failed: reason=%s ip=%s method=%s route=%s credential=%s",
+					failure.reason, ip, c.Request().Method, c.Path(), failure.credential)
+				a.options.Failures.RecordFailure(ip, failure.reason)
+				if role == RoleAdmin && failure.reason != FailureMissingCredentials {
+					a.options.AdminLockout.RecordFailure(c.Request().Context(), ip)
+				}
+				return unauthorized(c, failure.message)
+			}
+
+			if key, ok := a.signingKey(principal, c.Request().Header.Get(a.options.APIKeyHeader)); ok {
+				if failure := a.verifySignature(c, key); failure != nil {
+					return a.rejectSignature(c, ip, role, key, failure)
+				}
+			}
+
+			ctx := WithPrincipal(c.Request().Context(), principal)
+			c.SetRequest(c.Request().WithContext(ctx))
+			if !principal.HasRole(role) {
+				return forbidden(c, "Role "+role+" required")
+			}
+			for _, accountID := range []string{c.Param("account_id"), c.QueryParam("account_id")} {
+				if accountID == "" || principal.CanAccessAccount(accountID) {
+					continue
+				}
+				if method := c.Request().Method; method == http.MethodGet || method == http.MethodHead {
+					return c.JSON(http.StatusNotFound, map[string]string{"error": "Account not found"})
+				}
+				return forbidden(c, "Account not permitted for this credential")
+			}
+			return next(c)
+		}
+	}
+}
+
+// authFailure describes a failed authentication; reason is empty on success
+type authFailure struct {
+	reason  string
+	message string // body 401
+	// credential identifies the rejected key or token in logs without
+	// revealing it: the first 12 hex digits of its SHA-256
+	credential string
+}
+
+// authenticateCredentials authenticates a mapped SPIFFE ID of the client
+// certificate, else the API key header if present, the bearer token otherwise.
+// header returns a request header by name.
+func (a *Authenticator) authenticateCredentials(ctx context.Context, header func(string) string, state *tls.ConnectionState) (Principal, authFailure) {
+	if principal, ok := a.spiffePrincipal(state); ok {
+		return principal, authFailure{}
+	}
+	if len(a.apiKeys) > 0 {
+		if raw := header(a.options.APIKeyHeader); raw != "" {
+			hash := HashAPIKey(raw)
+			key, ok := a.apiKeys[hash]
+			if !ok {
+				return Principal{}, authFailure{FailureInvalidAPIKey, "Invalid API key", hash[:12]}
+			}
+			return key.principal(), authFailure{}
+		}
+	}
+
+	token := bearerToken(header(echo.HeaderAuthorization))
+	if token == "" {
+		return Principal{}, authFailure{FailureMissingCredentials, "Missing credentials", ""}
+	}
+	principal, err := a.Authenticate(ctx, token)
+	if errors.Is(err, errIntrospectionUnavailable) {
+		return Principal{}, authFailure{FailureIntrospectionUnavailable, err.Error(), HashAPIKey(token)[:12]}
+	}
+	if err != nil {
+		return Principal{}, authFailure{FailureInvalidToken, "Invalid token", HashAPIKey(token)[:12]}
+	}
+	return principal, authFailure{}
+}
+
+// signingKey returns the API key principal was authenticated with from raw,
+// if that key signs its requests
+func (a *Authenticator) signingKey(principal Principal, raw string) (APIKey, bool) {
+	if raw == "" {
+		return APIKey{}, false
+	}
+	key, ok := a.apiKeys[HashAPIKey(raw)]
+	if !ok || key.SigningSecret == "" || principal.Subject != "apikey:"+key.Name {
+		return APIKey{}, false
+	}
+	return key, true
+}
+
+// rejectSignature answers a signed request that failed verification. Bad
+// signatures and replays count as failed authentications; an unreachable
+// nonce cache is 503 and blocks nobody.
+func (a *Authenticator) rejectSignature(c echo.Context, ip string, role string, key APIKey, failure *signatureError) error {
+	if failure.code == "" {
+		return c.JSON(failure.status, map[string]string{"error": failure.message})
+	}
+	reason := FailureInvalidSignature
+	switch failure.code {
+	case SignatureNonceReused:
+		reason = FailureReplayedRequest
+	case SignatureNonceUnavailable:
+		reason = FailureNonceCacheUnavailable
+	}
+	log.Printf("Signed request rejected: code=%s ip=%s method=%s route=%s key=%s",
+		failure.code, ip, c.Request().Method, c.Path(), key.Name)
+	a.options.Failures.RecordFailure(ip, reason)
+	if role == RoleAdmin && reason != FailureNonceCacheUnavailable {
+		a.options.AdminLockout.RecordFailure(c.Request().Context(), ip)
+	}
+	if failure.status == http.StatusUnauthorized {
+		c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Signature error="`+failure.code+`"`)
+	}
+	return c.JSON(failure.status, map[string]string{"error": failure.message, "code": failure.code})
+}
+
+// bearerToken extracts the token of a "Bearer <token>" header; the scheme is
+// case insensitive
+func bearerToken(header string) string {
+	scheme, token, found := strings.Cut(header, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+func unauthorized(c echo.Context, message string) error {
+	c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+	return c.JSON(http.StatusUnauthorized, map[string]string{"error": message})
+}
+
+func forbidden(c echo.Context, message string) error {
+	return c.JSON(http.StatusForbidden, map[string]string{"error": message})
+}
@@ -0,0 +1,270 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+)
+
+func TestPrincipalResolveTenant(t *testing.T) {
+	tests := []struct {
+		name      string
+		principal Principal
+		requested string
+		want      string
+		wantErr   error
+	}{
+		{"bound, no tenant named", Principal{Roles: []string{RoleService}, Tenant: "acme"}, "", "acme", nil},
+		{"bound, same tenant", Principal{Roles: []string{RoleService}, Tenant: "acme"}, "acme", "acme", nil},
+		{"bound, other tenant", Principal{Roles: []string{RoleService}, Tenant: "acme"}, "globex", "", ErrTenantNotPermitted},
+		{"bound admin, other tenant", Principal{Roles: []string{RoleAdmin}, Tenant: "acme"}, "globex", "", ErrTenantNotPermitted},
+		{"unbound admin", Principal{Roles: []string{RoleAdmin}}, "globex", "globex", nil},
+		{"unbound internal service", Principal{Roles: []string{RoleService}, Internal: true}, "globex", "globex", nil},
+		{"unbound service", Principal{Roles: []string{RoleService}}, "globex", "", ErrTenantNotPermitted},
+		{"unbound read-only", Principal{Roles: []string{RoleReadOnly}}, "", "", ErrTenantNotPermitted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.principal.ResolveTenant(tt.requested)
+			if got != tt.want || !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ResolveTenant(%q) = %q, %v; want %q, %v", tt.requested, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+// testIssuer serves an OpenID configuration and the JWKS of key, so New
+// discovers the key set the way it does with a real identity provider
+type testIssuer struct {
+	url string
+	key *rsa.PrivateKey
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	issuer := &testIssuer{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"issuer": issuer.url, "jwks_uri": issuer.url + "/jwks.json"})
+	})
+	mux.HandleFunc("/jwks.json", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "k1",
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	issuer.url = server.URL
+	return issuer
+}
+
+// token signs claims with the issuer's key; iss, aud and exp default to a
+// valid token unless claims sets them
+func (i *testIssuer) token(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+	valid := jwt.MapClaims{"iss": i.url, "aud": "sub-balance", "sub": "user-1", "exp": time.Now().Add(time.Hour).Unix()}
+	for name, value := range claims {
+		valid[name] = value
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, valid)
+	token.Header["kid"] = "k1"
+	signed, err := token.SignedString(i.key)
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+	return signed
+}
+
+func (i *testIssuer) authenticator(t *testing.T, options Options) *Authenticator {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	options.Issuer = i.url
+	options.Audience = "sub-balance"
+	options.Algorithms = []string{"RS256"}
+	if options.RolesClaim == "" {
+		options.RolesClaim = "roles"
+	}
+	authn, err := New(ctx, options)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return authn
+}
+
+func TestNewDiscoversJWKS(t *testing.T) {
+	issuer := newTestIssuer(t)
+	if got := issuer.authenticator(t, Options{}).JWKSURL(); got != issuer.url+"/jwks.json" {
+		t.Fatalf("JWKSURL = %q, want the jwks_uri of the OpenID configuration", got)
+	}
+
+	if _, err := New(context.Background(), Options{Issuer: issuer.url + "/missing", Algorithms: []string{"RS256"}}); err == nil {
+		t.Fatalf("New with an issuer without OpenID configuration succeeded")
+	}
+	if _, err := New(context.Background(), Options{}); err == nil {
+		t.Fatalf("New without any credential source succeeded")
+	}
+}
+
+func TestAuthenticateJWT(t *testing.T) {
+	issuer := newTestIssuer(t)
+	hmacToken, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"iss": issuer.url, "aud": "sub-balance", "exp": time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte("secret"))
+
+	tests := []struct {
+		name    string
+		options Options
+		token   string
+		want    Principal
+		wantErr bool
+	}{
+		{
+			name:  "roles list",
+			token: issuer.token(t, jwt.MapClaims{"roles": []string{RoleService, "unknown"}}),
+			want:  Principal{Subject: "user-1", Roles: []string{RoleService}},
+		},
+		{
+			name:    "nested space separated roles, mapped",
+			options: Options{RolesClaim: "realm_access.roles", RoleMapping: map[string]string{"balance-admin": RoleAdmin}},
+			token:   issuer.token(t, jwt.MapClaims{"realm_access": map[string]string{"roles": "balance-admin offline_access"}}),
+			want:    Principal{Subject: "user-1", Roles: []string{RoleAdmin}},
+		},
+		{
+			name:    "scopes of a client credentials token",
+			options: Options{ScopeMapping: map[string]string{"balance:read": RoleReadOnly, "transaction:write": RoleService}},
+			token:   issuer.token(t, jwt.MapClaims{"sub": nil, "client_id": "billing", "scope": "balance:read transaction:write email"}),
+			want:    Principal{Subject: "client:billing", Roles: []string{RoleReadOnly, RoleService}},
+		},
+		{
+			name:    "accounts and tenant claims",
+			options: Options{AccountsClaim: "accounts", TenantClaim: "tenant"},
+			token:   issuer.token(t, jwt.MapClaims{"roles": RoleService, "accounts": []string{"ACC001"}, "tenant": "acme"}),
+			want:    Principal{Subject: "user-1", Roles: []string{RoleService}, Accounts: []string{"ACC001"}, Tenant: "acme"},
+		},
+		{
+			name:    "account-scoped admin",
+			options: Options{AccountsClaim: "accounts"},
+			token:   issuer.token(t, jwt.MapClaims{"roles": RoleAdmin, "accounts": []string{"ACC001"}}),
+			wantErr: true,
+		},
+		{
+			name:    "invalid tenant claim",
+			options: Options{TenantClaim: "tenant"},
+			token:   issuer.token(t, jwt.MapClaims{"roles": RoleService, "tenant": "Not A Tenant!"}),
+			wantErr: true,
+		},
+		{"expired", Options{}, issuer.token(t, jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()}), Principal{}, true},
+		{"expired within clock skew", Options{}, issuer.token(t, jwt.MapClaims{"exp": time.Now().Add(-10 * time.Second).Unix()}), Principal{Subject: "user-1"}, false},
+		{"without exp", Options{}, issuer.token(t, jwt.MapClaims{"exp": nil}), Principal{}, true},
+		{"other issuer", Options{}, issuer.token(t, jwt.MapClaims{"iss": "https://evil.example"}), Principal{}, true},
+		{"other audience", Options{}, issuer.token(t, jwt.MapClaims{"aud": "other-service"}), Principal{}, true},
+		{"algorithm not allowed", Options{}, hmacToken, Principal{}, true},
+		{"tampered", Options{}, issuer.token(t, jwt.MapClaims{}) + "x", Principal{}, true},
+		{"opaque token without introspection", Options{}, "opaque-token", Principal{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := issuer.authenticator(t, tt.options).Authenticate(context.Background(), tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Authenticate error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && fmt.Sprintf("%+v", got) != fmt.Sprintf("%+v", tt.want) {
+				t.Fatalf("Authenticate = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthorizePolicy(t *testing.T) {
+	issuer := newTestIssuer(t)
+	authn := issuer.authenticator(t, Options{AccountsClaim: "accounts"})
+	e := echo.New()
+	api := e.Group("/api/v1", authn.Authorize(Policy{
+		Route(http.MethodGet, "/api/v1/health"):                 Public,
+		Route(http.MethodGet, "/api/v1/balance/:account_id"):    RoleReadOnly,
+		Route(http.MethodPost, "/api/v1/transaction"):           RoleService,
+		Route(http.MethodPost, "/api/v1/admin/settlement/run"):  RoleAdmin,
+		Route(http.MethodDelete, "/api/v1/balance/:account_id"): RoleService,
+	}))
+	ok := func(c echo.Context) error { return c.NoContent(http.StatusOK) }
+	api.GET("/health", ok)
+	api.GET("/balance/:account_id", ok)
+	api.DELETE("/balance/:account_id", ok)
+	api.POST("/transaction", ok)
+	api.POST("/admin/settlement/run", ok)
+	api.GET("/unclassified", ok)
+
+	readOnly := issuer.token(t, jwt.MapClaims{"roles": RoleReadOnly})
+	admin := issuer.token(t, jwt.MapClaims{"roles": RoleAdmin})
+	scoped := issuer.token(t, jwt.MapClaims{"roles": RoleService, "accounts": []string{"ACC001"}})
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		header string
+		want   int
+	}{
+		{"public route", http.MethodGet, "/api/v1/health", "", http.StatusOK},
+		{"route missing from policy", http.MethodGet, "/api/v1/unclassified", "Bearer " + admin, http.StatusForbidden},
+		{"missing token", http.MethodGet, "/api/v1/balance/ACC001", "", http.StatusUnauthorized},
+		{"other scheme", http.MethodGet, "/api/v1/balance/ACC001", "Basic " + readOnly, http.StatusUnauthorized},
+		{"invalid token", http.MethodGet, "/api/v1/balance/ACC001", "Bearer not-a-token", http.StatusUnauthorized},
+		{"role held", http.MethodGet, "/api/v1/balance/ACC001", "bearer " + readOnly, http.StatusOK},
+		{"role too low", http.MethodPost, "/api/v1/transaction", "Bearer " + readOnly, http.StatusForbidden},
+		{"admin includes lower roles", http.MethodPost, "/api/v1/transaction", "Bearer " + admin, http.StatusOK},
+		{"admin route", http.MethodPost, "/api/v1/admin/settlement/run", "Bearer " + admin, http.StatusOK},
+		{"own account", http.MethodGet, "/api/v1/balance/ACC001", "Bearer " + scoped, http.StatusOK},
+		{"read of another account", http.MethodGet, "/api/v1/balance/ACC002", "Bearer " + scoped, http.StatusNotFound},
+		{"write to another account", http.MethodDelete, "/api/v1/balance/ACC002", "Bearer " + scoped, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set(echo.HeaderAuthorization, tt.header)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("%s %s = %d, want %d: %s", tt.method, tt.path, rec.Code, tt.want, rec.Body)
+			}
+			if rec.Code == http.StatusUnauthorized && rec.Header().Get(echo.HeaderWWWAuthenticate) == "" {
+				t.Fatalf("401 without WWW-Authenticate")
+			}
+		})
+	}
+}
+
+func TestAuthorizeNilAuthenticator(t *testing.T) {
+	var authn *Authenticator
+	e := echo.New()
+	e.Group("/api/v1", authn.Authorize(Policy{})).GET("/anything", func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/anything", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("nil authenticator = %d, want every request let through", rec.Code)
+	}
+}
@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"log"
+	"time"
+)
+
+// CallError is why AuthenticateCall rejected a call
+type CallError struct {
+	Reason     string // Failure* reason; empty while the caller's IP is blocked
+	Message    string
+	RetryAfter time.Duration // how long the caller's IP stays blocked
+}
+
+func (e *CallError) Error() string {
+	return e.Message
+}
+
+// AuthenticateCall authenticates a call that does not go through Echo, such
+// as a gRPC stream, with the credentials Authorize accepts: header returns a
+// request header (API key header, Authorization) by name, state is the
+// connection's TLS state and method names the call in logs. Failures are
+// counted and IPs blocked as in Authorize. The caller checks roles and
+// accounts on the returned principal. A nil Authenticator returns an empty
+// principal and no error.
+func (a *Authenticator) AuthenticateCall(ctx context.Context, ip string, method string, header func(string) string, state *tls.ConnectionState) (Principal, error) {
+	if a == nil {
+		return Principal{}, nil
+	}
+	if retryAfter, blocked := a.options.Failures.Blocked(ip); blocked {
+		return Principal{}, &CallError{Message: "Too many failed authentication attempts", RetryAfter: retryAfter}
+	}
+
+	principal, failure := a.authenticateCredentials(ctx, header, state)
+	if failure.reason == FailureIntrospectionUnavailable {
+		log.Printf("Authentication unavailable: ip=%s method=%s: %s", ip, method, failure.message)
+		a.options.Failures.RecordFailure(ip, failure.reason)
+		return Principal{}, &CallError{Reason: failure.reason, Message: "Authorization server unavailable, please retry later"}
+	}
+	if failure.reason != "" {
+		log.Printf("Authentication failed: reason=%s ip=%s method=%s credential=%s",
+			failure.reason, ip, method, failure.credential)
+		a.options.Failures.RecordFailure(ip, failure.reason)
+		return Principal{}, &CallError{Reason: failure.reason, Message: failure.message}
+	}
+	return principal, nil
+}
@@ -0,0 +1,181 @@
+package auth
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// Alasan autentikasi gagal, dipakai sebagai label metric
+const (
+	FailureMissingCredentials = "missing_credentials"
+	FailureInvalidAPIKey      = "invalid_api_key"
+	FailureInvalidToken       = "invalid_token"
+	FailureInvalidSignature   = "invalid_signature"
+	FailureReplayedRequest    = "replayed_request"
+	// Introspection endpoint tidak bisa dihubungi: dijawab 503, tidak pernah memblokir
+	FailureIntrospectionUnavailable = "introspection_unavailable"
+	// Nonce cache (Redis) tidak bisa dihubungi: dijawab 503, tidak pernah memblokir
+	FailureNonceCacheUnavailable = "nonce_cache_unavailable"
+)
+
+// FailureTracker counts failed authentications per client IP and blocks an
+// IP once it presents invalid credentials threshold times within window. The
+// block starts at blockDuration and doubles with every further block, up to
+// maxBlock; an IP that stays clean for maxBlock starts over. Requests without
+// credentials, and tokens the introspection endpoint could not check, are
+// counted but never block, so a client that forgot its key cannot lock out
+// others sharing its IP. Counts are kept per instance. A nil
+// tracker records and blocks nothing.
+type FailureTracker struct {
+	threshold     int // 0 = hanya dihitung, tidak pernah diblokir
+	window        time.Duration
+	blockDuration time.Duration
+	maxBlock      time.Duration
+
+	mutex           sync.Mutex
+	sources         map[string]*failureSource
+	failures        map[string]int64 // by reason
+	blocks          int64
+	blockedRequests int64
+	lastPrune       time.Time
+}
+
+type failureSource struct {
+	count        int
+	windowStart  time.Time
+	lastFailure  time.Time
+	blockedUntil time.Time
+	blocks       int
+}
+
+// FailureStats are the counters exported as metrics
+type FailureStats struct {
+	Failures        map[string]int64 // by reason
+	Blocks          int64            // times an IP was blocked
+	BlockedRequests int64            // requests rejected while blocked
+	BlockedSources  int              // IPs blocked right now
+}
+
+func NewFailureTracker(threshold int, window time.Duration, blockDuration time.Duration, maxBlock time.Duration) *FailureTracker {
+	return &FailureTracker{
+		threshold:     threshold,
+		window:        window,
+		blockDuration: blockDuration,
+		maxBlock:      max(maxBlock, blockDuration),
+		sources:       make(map[string]*failureSource),
+		failures:      make(map[string]int64),
+	}
+}
+
+// Blocked reports whether ip is blocked and for how much longer, counting the
+// rejected request
+func (t *FailureTracker) Blocked(ip string) (time.Duration, bool) {
+	if t == nil || t.threshold <= 0 {
+		return 0, false
+	}
+	now := time.Now()
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	source, ok := t.sources[ip]
+	if !ok || !now.Before(source.blockedUntil) {
+		return 0, false
+	}
+	t.blockedRequests++
+	return source.blockedUntil.Sub(now), true
+}
+
+// RecordFailure counts a failed authentication of ip and returns how long ip
+// is blocked from now on, 0 if it is not
+func (t *FailureTracker) RecordFailure(ip string, reason string) time.Duration {
+	if t == nil {
+		return 0
+	}
+	now := time.Now()
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.failures[reason]++
+	if t.threshold <= 0 || reason == FailureMissingCredentials || reason == FailureIntrospectionUnavailable || reason == FailureNonceCacheUnavailable {
+		return 0
+	}
+	t.prune(now)
+
+	source, ok := t.sources[ip]
+	if !ok {
+		source = &failureSource{}
+		t.sources[ip] = source
+	}
+	if now.Sub(source.windowStart) >= t.window {
+		source.count, source.windowStart = 0, now
+	}
+	source.count++
+	source.lastFailure = now
+	if source.count < t.threshold {
+		return 0
+	}
+
+	block := t.blockDuration << source.blocks
+	if block > t.maxBlock || block <= 0 {
+		block = t.maxBlock
+	}
+	source.blocks++
+	source.count, source.windowStart = 0, now
+	source.blockedUntil = now.Add(block)
+	t.blocks++
+	log.Printf("WARNING: blocking authentication from %s for %s after %d failed attempts (block #%d)", ip, block, t.threshold, source.blocks)
+	return block
+}
+
+// Unblock lifts the block of ip on this instance and forgets its failures;
+// it reports whether ip had any
+func (t *FailureTracker) Unblock(ip string) bool {
+	if t == nil {
+		return false
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	_, ok := t.sources[ip]
+	delete(t.sources, ip)
+	return ok
+}
+
+// prune drops IPs that are not blocked and have not failed for maxBlock, so
+// their next block starts from blockDuration again and the map stays bounded.
+// It scans at most once per window.
+func (t *FailureTracker) prune(now time.Time) {
+	if now.Sub(t.lastPrune) < t.window {
+		return
+	}
+	t.lastPrune = now
+	for ip, source := range t.sources {
+		if !now.Before(source.blockedUntil) && now.Sub(source.lastFailure) >= t.maxBlock {
+			delete(t.sources, ip)
+		}
+	}
+}
+
+func (t *FailureTracker) Stats() FailureStats {
+	if t == nil {
+		return FailureStats{}
+	}
+	now := time.Now()
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	stats := FailureStats{
+		Failures:        make(map[string]int64, len(t.failures)),
+		Blocks:          t.blocks,
+		BlockedRequests: t.blockedRequests,
+	}
+	for reason, count := range t.failures {
+		stats.Failures[reason] = count
+	}
+	for _, source := range t.sources {
+		if now.Before(source.blockedUntil) {
+			stats.BlockedSources++
+		}
+	}
+	return stats
+}
@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// errIntrospectionUnavailable is returned when the authorization server
+// cannot answer, which says nothing about the caller's token
+var errIntrospectionUnavailable = errors.New("token introspection unavailable")
+
+// introspector validates opaque access tokens with the authorization
+// server's token introspection endpoint (RFC 7662), authenticating as the
+// service's own OAuth2 client. Active tokens are cached for cacheTTL, or until
+// they expire if that is sooner, so a busy client does not cost one
+// introspection call per request; a revoked token can therefore keep working
+// for up to cacheTTL.
+type introspector struct {
+	url          string
+	clientID     string
+	clientSecret string
+	cacheTTL     time.Duration
+	client       *http.Client
+
+	mutex     sync.Mutex
+	cache     map[string]cachedToken // by token SHA-256
+	lastPrune time.Time
+}
+
+type cachedToken struct {
+	claims  jwt.MapClaims
+	expires time.Time
+}
+
+func newIntrospector(options Options) *introspector {
+	return &introspector{
+		url:          options.IntrospectionURL,
+		clientID:     options.ClientID,
+		clientSecret: options.ClientSecret,
+		cacheTTL:     options.IntrospectionCacheTTL,
+		client:       &http.Client{Timeout: 5 * time.Second},
+		cache:        make(map[string]cachedToken),
+	}
+}
+
+// introspect returns the claims of an active token
+func (i *introspector) introspect(ctx context.Context, token string) (jwt.MapClaims, error) {
+	hash := HashAPIKey(token)
+	now := time.Now()
+
+	i.mutex.Lock()
+	cached, ok := i.cache[hash]
+	i.mutex.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.claims, nil
+	}
+
+	claims, err := i.request(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if active, _ := claims["active"].(bool); !active {
+		return nil, errors.New("token is not active")
+	}
+	expires := now.Add(i.cacheTTL)
+	if exp, ok := claims["exp"].(float64); ok {
+		if expiry := time.Unix(int64(exp), 0); expiry.Before(expires) {
+			expires = expiry
+		}
+	}
+	if !now.Before(expires.Add(clockSkew)) {
+		return nil, errors.New("token is expired")
+	}
+
+	i.mutex.Lock()
+	i.prune(now)
+	i.cache[hash] = cachedToken{claims: claims, expires: expires}
+	i.mutex.Unlock()
+	return claims, nil
+}
+
+func (i *introspector) request(ctx context.Context, token string) (jwt.MapClaims, error) {
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, i.url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(i.clientID), url.QueryEscape(i.clientSecret))
+
+	resp, err := i.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errIntrospectionUnavailable, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: status %d", errIntrospectionUnavailable, resp.StatusCode)
+	}
+
+	var claims jwt.MapClaims
+	if err := json.NewDecoder(resp.Body).Decode(&claims); err != nil {
+		return nil, fmt.Errorf("%w: failed to decode response: %v", errIntrospectionUnavailable, err)
+	}
+	return claims, nil
+}
+
+// prune drops expired tokens, scanning at most once per cacheTTL
+func (i *introspector) prune(now time.Time) {
+	if now.Sub(i.lastPrune) < i.cacheTTL {
+		return
+	}
+	i.lastPrune = now
+	for hash, cached := range i.cache {
+		if !now.Before(cached.expires) {
+			delete(i.cache, hash)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// AdminLockout locks a client IP out of the admin routes after threshold
+// failed authentications on them within window. The lockout starts at
+// lockDuration and doubles with every further lockout, up to maxLock; an IP
+// that stays clean for maxLock after its last lockout starts over. Unlike
+// FailureTracker the counters live in Redis, so attempts spread over several
+// instances add up and a lockout holds on every instance until it expires or
+// an operator lifts it. When Redis cannot be reached the lockout is not
+// enforced and FailureTracker still applies. A nil lockout locks nothing.
+type AdminLockout struct {
+	client       *redis.Client
+	prefix       string
+	threshold    int
+	window       time.Duration
+	lockDuration time.Duration
+	maxLock      time.Duration
+
+	lockouts       atomic.Int64
+	lockedRequests atomic.Int64
+}
+
+// Lockout is a client IP locked out of the admin routes
+type Lockout struct {
+	IP               string    `json:"ip"`
+	Level            int       `json:"level"` // lockout ke-n berturut-turut
+	ExpiresAt        time.Time `json:"expires_at"`
+	RemainingSeconds int64     `json:"remaining_seconds"`
+}
+
+// LockoutStats are the counters exported as metrics, for this instance
+type LockoutStats struct {
+	Lockouts       int64 // times an IP was locked out
+	LockedRequests int64 // admin requests rejected while locked out
+}
+
+// ErrLockoutNotFound is returned by Unlock for an IP that is not locked out
+var ErrLockoutNotFound = errors.New("ip is not locked out")
+
+func NewAdminLockout(client *redis.Client, prefix string, threshold int, window time.Duration, lockDuration time.Duration, maxLock time.Duration) *AdminLockout {
+	return &AdminLockout{
+		client:       client,
+		prefix:       prefix + ":admin_lockout:",
+		threshold:    threshold,
+		window:       window,
+		lockDuration: lockDuration,
+		maxLock:      max(maxLock, lockDuration),
+	}
+}
+
+func (l *AdminLockout) failuresKey(ip string) string { return l.prefix + "failures:" + ip }
+func (l *AdminLockout) levelKey(ip string) string    { return l.prefix + "level:" + ip }
+func (l *AdminLockout) lockKey(ip string) string     { return l.prefix + "lock:" + ip }
+
+// Locked reports whether ip is locked out and for how much longer, counting
+// the rejected request
+func (l *AdminLockout) Locked(ctx context.Context, ip string) (time.Duration, bool) {
+	if l == nil {
+		return 0, false
+	}
+	remaining, err := l.client.PTTL(ctx, l.lockKey(ip)).Result()
+	if err != nil {
+		log.Printf("Failed to check admin lockout of %s, not enforced: %v", ip, err)
+		return 0, false
+	}
+	if remaining <= 0 {
+		return 0, false
+	}
+	l.lockedRequests.Add(1)
+	return remaining, true
+}
+
+// recordFailureScript counts a failure in KEYS[1] (expiring after the window
+// ARGV[1] ms) and, at ARGV[2] failures, locks out for ARGV[3] ms doubled per
+// level in KEYS[2], capped at ARGV[4] ms. Returns the lockout in ms, 0 if none.
+var recordFailureScript = redis.NewScript(`
+local count = redis.call('INCR', KEYS[1])
+if count == 1 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+if count < tonumber(ARGV[2]) then
+	return 0
+end
+redis.call('DEL', KEYS[1])
+local level = redis.call('INCR', KEYS[2])
+local maxLock = tonumber(ARGV[4])
+local lock = math.min(tonumber(ARGV[3]) * 2 ^ (level - 1), maxLock)
+redis.call('SET', KEYS[3], level, 'PX', lock)
+redis.call('PEXPIRE', KEYS[2], lock + maxLock)
+return lock
+`)
+
+// RecordFailure counts a failed admin authentication of ip and returns how
+// long ip is locked out from now on, 0 if it is not
+func (l *AdminLockout) RecordFailure(ctx context.Context, ip string) time.Duration {
+	if l == nil || l.threshold <= 0 {
+		return 0
+	}
+	keys := []string{l.failuresKey(ip), l.levelKey(ip), l.lockKey(ip)}
+	lock, err := recordFailureScript.Run(ctx, l.client, keys,
+		l.window.Milliseconds(), l.threshold, l.lockDuration.Milliseconds(), l.maxLock.Milliseconds()).Int64()
+	if err != nil {
+		log.Printf("Failed to record admin authentication failure of %s: %v", ip, err)
+		return 0
+	}
+	if lock == 0 {
+		return 0
+	}
+	l.lockouts.Add(1)
+	duration := time.Duration(lock) * time.Millisecond
+	log.Printf("WARNING: locking %s out of admin endpoints for %s after %d failed attempts", ip, duration, l.threshold)
+	return duration
+}
+
+// List returns the IPs locked out right now
+func (l *AdminLockout) List(ctx context.Context) ([]Lockout, error) {
+	lockouts := []Lockout{}
+	if l == nil {
+		return lockouts, nil
+	}
+	prefix := l.lockKey("")
+	iter := l.client.Scan(ctx, 0, prefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		level, err := l.client.Get(ctx, key).Int()
+		if errors.Is(err, redis.Nil) {
+			continue // kedaluwarsa di antara SCAN dan GET
+		}
+		if err != nil {
+			return nil, err
+		}
+		remaining, err := l.client.PTTL(ctx, key).Result()
+		if err != nil {
+			return nil, err
+		}
+		if remaining <= 0 {
+			continue
+		}
+		lockouts = append(lockouts, Lockout{
+			IP:               strings.TrimPrefix(key, prefix),
+			Level:            level,
+			ExpiresAt:        time.Now().Add(remaining).Truncate(time.Second),
+			RemainingSeconds: int64(remaining.Seconds()),
+		})
+	}
+	return lockouts, iter.Err()
+}
+
+// Unlock lifts the lockout of ip and forgets its failures, so its next
+// lockout starts from lockDuration again
+func (l *AdminLockout) Unlock(ctx context.Context, ip string) error {
+	if l == nil {
+		return ErrLockoutNotFound
+	}
+	deleted, err := l.client.Del(ctx, l.lockKey(ip), l.levelKey(ip), l.failuresKey(ip)).Result()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrLockoutNotFound
+	}
+	log.Printf("Admin lockout of %s lifted", ip)
+	return nil
+}
+
+func (l *AdminLockout) Stats() LockoutStats {
+	if l == nil {
+		return LockoutStats{}
+	}
+	return LockoutStats{Lockouts: l.lockouts.Load(), LockedRequests: l.lockedRequests.Load()}
+}
@@ -0,0 +1,254 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+)
+
+// newTestLockout locks out after 3 failures within a minute, for a minute
+// doubling up to 4 minutes
+func newTestLockout(t *testing.T) (*AdminLockout, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr(), MaxRetries: -1})
+	t.Cleanup(func() { client.Close() })
+	return NewAdminLockout(client, "test", 3, time.Minute, time.Minute, 4*time.Minute), server
+}
+
+func failTimes(lockout *AdminLockout, ip string, n int) time.Duration {
+	var lock time.Duration
+	for i := 0; i < n; i++ {
+		lock = lockout.RecordFailure(context.Background(), ip)
+	}
+	return lock
+}
+
+func TestAdminLockoutRecordFailure(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps func(lockout *AdminLockout, server *miniredis.Miniredis) time.Duration
+		want  time.Duration
+	}{
+		{
+			name:  "below threshold",
+			steps: func(l *AdminLockout, _ *miniredis.Miniredis) time.Duration { return failTimes(l, "10.0.0.1", 2) },
+			want:  0,
+		},
+		{
+			name:  "threshold reached",
+			steps: func(l *AdminLockout, _ *miniredis.Miniredis) time.Duration { return failTimes(l, "10.0.0.1", 3) },
+			want:  time.Minute,
+		},
+		{
+			name: "failures outside the window",
+			steps: func(l *AdminLockout, server *miniredis.Miniredis) time.Duration {
+				failTimes(l, "10.0.0.1", 2)
+				server.FastForward(time.Minute)
+				return failTimes(l, "10.0.0.1", 1)
+			},
+			want: 0,
+		},
+		{
+			name: "other IPs count apart",
+			steps: func(l *AdminLockout, _ *miniredis.Miniredis) time.Duration {
+				failTimes(l, "10.0.0.2", 2)
+				return failTimes(l, "10.0.0.1", 1)
+			},
+			want: 0,
+		},
+		{
+			name: "repeat lockout doubles",
+			steps: func(l *AdminLockout, server *miniredis.Miniredis) time.Duration {
+				failTimes(l, "10.0.0.1", 3)
+				server.FastForward(time.Minute)
+				return failTimes(l, "10.0.0.1", 3)
+			},
+			want: 2 * time.Minute,
+		},
+		{
+			name: "capped at max lock",
+			steps: func(l *AdminLockout, server *miniredis.Miniredis) time.Duration {
+				for i := 0; i < 3; i++ {
+					failTimes(l, "10.0.0.1", 3)
+					server.FastForward(time.Second)
+				}
+				return failTimes(l, "10.0.0.1", 3)
+			},
+			want: 4 * time.Minute,
+		},
+		{
+			name: "clean for max lock starts over",
+			steps: func(l *AdminLockout, server *miniredis.Miniredis) time.Duration {
+				failTimes(l, "10.0.0.1", 3)
+				server.FastForward(time.Minute + 4*time.Minute)
+				return failTimes(l, "10.0.0.1", 3)
+			},
+			want: time.Minute,
+		},
+		{
+			name: "redis down",
+			steps: func(l *AdminLockout, server *miniredis.Miniredis) time.Duration {
+				server.Close()
+				return failTimes(l, "10.0.0.1", 3)
+			},
+			want: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lockout, server := newTestLockout(t)
+			if got := tt.steps(lockout, server); got != tt.want {
+				t.Fatalf("RecordFailure = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAdminLockoutLocked(t *testing.T) {
+	ctx := context.Background()
+	lockout, server := newTestLockout(t)
+	if _, locked := lockout.Locked(ctx, "10.0.0.1"); locked {
+		t.Fatalf("IP locked before any failure")
+	}
+
+	failTimes(lockout, "10.0.0.1", 3)
+	if remaining, locked := lockout.Locked(ctx, "10.0.0.1"); !locked || remaining != time.Minute {
+		t.Fatalf("Locked = %s, %t; want 1m0s, true", remaining, locked)
+	}
+	if _, locked := lockout.Locked(ctx, "10.0.0.2"); locked {
+		t.Fatalf("other IP locked")
+	}
+	server.FastForward(time.Minute)
+	if _, locked := lockout.Locked(ctx, "10.0.0.1"); locked {
+		t.Fatalf("IP still locked after the lockout expired")
+	}
+	if stats := lockout.Stats(); stats != (LockoutStats{Lockouts: 1, LockedRequests: 1}) {
+		t.Fatalf("Stats = %+v", stats)
+	}
+
+	// Redis down: lockout tidak ditegakkan
+	failTimes(lockout, "10.0.0.1", 3)
+	server.Close()
+	if _, locked := lockout.Locked(ctx, "10.0.0.1"); locked {
+		t.Fatalf("Locked with Redis down = true, want not enforced")
+	}
+}
+
+func TestAdminLockoutListAndUnlock(t *testing.T) {
+	ctx := context.Background()
+	lockout, server := newTestLockout(t)
+	failTimes(lockout, "10.0.0.1", 3)
+	server.FastForward(time.Minute)
+	failTimes(lockout, "10.0.0.1", 3)
+	failTimes(lockout, "10.0.0.2", 2)
+
+	lockouts, err := lockout.List(ctx)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(lockouts) != 1 || lockouts[0].IP != "10.0.0.1" || lockouts[0].Level != 2 || lockouts[0].RemainingSeconds != 120 {
+		t.Fatalf("List = %+v, want 10.0.0.1 at level 2 for 120s", lockouts)
+	}
+
+	if err := lockout.Unlock(ctx, "10.0.0.1"); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+	if _, locked := lockout.Locked(ctx, "10.0.0.1"); locked {
+		t.Fatalf("IP still locked after Unlock")
+	}
+	if err := lockout.Unlock(ctx, "10.0.0.1"); !errors.Is(err, ErrLockoutNotFound) {
+		t.Fatalf("second Unlock = %v, want %v", err, ErrLockoutNotFound)
+	}
+	// Level ikut dihapus: lockout berikutnya mulai dari durasi awal
+	if lock := failTimes(lockout, "10.0.0.1", 3); lock != time.Minute {
+		t.Fatalf("lockout after Unlock = %s, want 1m0s", lock)
+	}
+}
+
+func TestAdminLockoutDisabled(t *testing.T) {
+	ctx := context.Background()
+	var lockout *AdminLockout
+	if lockout.RecordFailure(ctx, "10.0.0.1") != 0 || lockout.Stats() != (LockoutStats{}) {
+		t.Fatalf("nil lockout recorded a failure")
+	}
+	if _, locked := lockout.Locked(ctx, "10.0.0.1"); locked {
+		t.Fatalf("nil lockout locked an IP")
+	}
+	if lockouts, err := lockout.List(ctx); err != nil || len(lockouts) != 0 {
+		t.Fatalf("List on nil lockout = %v, %v", lockouts, err)
+	}
+	if err := lockout.Unlock(ctx, "10.0.0.1"); !errors.Is(err, ErrLockoutNotFound) {
+		t.Fatalf("Unlock on nil lockout = %v", err)
+	}
+
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	lockout = NewAdminLockout(client, "test", 0, time.Minute, time.Minute, time.Minute)
+	if lock := failTimes(lockout, "10.0.0.1", 10); lock != 0 {
+		t.Fatalf("threshold 0 locked out for %s", lock)
+	}
+}
+
+func TestAuthorizeAdminLockout(t *testing.T) {
+	lockout, _ := newTestLockout(t)
+	authn, err := New(context.Background(), Options{
+		APIKeys: []APIKey{
+			{Name: "admin", KeySHA256: HashAPIKey("admin-key"), Roles: []string{RoleAdmin}},
+		},
+		APIKeyHeader: "X-API-Key",
+		AdminLockout: lockout,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	e := echo.New()
+	api := e.Group("/api/v1", authn.Authorize(Policy{
+		Route(http.MethodGet, "/api/v1/admin/lockouts"): RoleAdmin,
+		Route(http.MethodGet, "/api/v1/balance"):        RoleReadOnly,
+	}))
+	ok := func(c echo.Context) error { return c.NoContent(http.StatusOK) }
+	api.GET("/admin/lockouts", ok)
+	api.GET("/balance", ok)
+
+	request := func(path string, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Request tanpa credential dan kegagalan di route non-admin tidak dihitung
+	for i := 0; i < 3; i++ {
+		request("/api/v1/admin/lockouts", "")
+		request("/api/v1/balance", "wrong-key")
+	}
+	if rec := request("/api/v1/admin/lockouts", "admin-key"); rec.Code != http.StatusOK {
+		t.Fatalf("admin request = %d, want 200 before any counted failure", rec.Code)
+	}
+
+	for i := 0; i < 3; i++ {
+		if rec := request("/api/v1/admin/lockouts", "wrong-key"); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("failure %d = %d, want 401", i, rec.Code)
+		}
+	}
+	rec := request("/api/v1/admin/lockouts", "admin-key")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "60" {
+		t.Fatalf("locked out admin request = %d, Retry-After %q; want 429, 60", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := request("/api/v1/balance", "admin-key"); rec.Code != http.StatusOK {
+		t.Fatalf("non-admin route while locked out = %d, want 200", rec.Code)
+	}
+}
@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ClientIPExtractor is the connection's address, or the X-Forwarded-For entry
+// appended by the nearest proxy listed in trustedProxies; unlike echo's
+// default it cannot be spoofed by sending the header directly
+func ClientIPExtractor(trustedProxies []string) (echo.IPExtractor, error) {
+	if len(trustedProxies) == 0 {
+		return echo.ExtractIPDirect(), nil
+	}
+	proxies, err := ParseCIDRs("trusted proxies", trustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	options := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+	for _, proxy := range proxies {
+		options = append(options, echo.TrustIPRange(proxy))
+	}
+	return echo.ExtractIPFromXFFHeader(options...), nil
+}
+
+// IPAllowlist rejects with 403 callers whose c.RealIP() is outside cidrs
+func IPAllowlist(name string, cidrs []string) (echo.MiddlewareFunc, error) {
+	allowed, err := ParseCIDRs(name+" allowlist", cidrs)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			ip := net.ParseIP(c.RealIP())
+			for _, network := range allowed {
+				if ip != nil && network.Contains(ip) {
+					return next(c)
+				}
+			}
+			log.Printf("Rejected %s %s from %s: not in %s allowlist", c.Request().Method, c.Request().URL.Path, ip, name)
+			return c.JSON(http.StatusForbidden, map[string]string{
+				"error": "Forbidden",
+			})
+		}
+	}, nil
+}
+
+// ParseCIDRs parses cidrs; name says where they come from in the error
+func ParseCIDRs(name string, cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q in %s: %w", cidr, name, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
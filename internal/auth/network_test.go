@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestIPAllowlist(t *testing.T) {
+	allowlist, err := IPAllowlist("admin", []string{"10.0.0.0/8", "192.168.1.5/32"})
+	if err != nil {
+		t.Fatalf("IPAllowlist: %v", err)
+	}
+	e := echo.New()
+	e.IPExtractor = echo.ExtractIPDirect()
+	e.GET("/admin", func(c echo.Context) error { return c.NoContent(http.StatusOK) }, allowlist)
+
+	tests := []struct {
+		remoteAddr string
+		want       int
+	}{
+		{"10.1.2.3:1234", http.StatusOK},
+		{"192.168.1.5:1234", http.StatusOK},
+		{"192.168.1.6:1234", http.StatusForbidden},
+		{"203.0.113.7:1234", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/admin", nil)
+		req.RemoteAddr = tt.remoteAddr
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.remoteAddr, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestClientIPExtractor(t *testing.T) {
+	tests := []struct {
+		name           string
+		trustedProxies []string
+		remoteAddr     string
+		want           string
+	}{
+		{"no trusted proxies", nil, "10.0.0.1:1234", "10.0.0.1"},
+		{"trusted proxy", []string{"10.0.0.0/8"}, "10.0.0.1:1234", "203.0.113.7"},
+		{"untrusted proxy", []string{"10.0.0.0/8"}, "198.51.100.1:1234", "198.51.100.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			extract, err := ClientIPExtractor(tt.trustedProxies)
+			if err != nil {
+				t.Fatalf("ClientIPExtractor: %v", err)
+			}
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Header.Set(echo.HeaderXForwardedFor, "203.0.113.7")
+			if got := extract(req); got != tt.want {
+				t.Fatalf("client IP = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInvalidCIDRs(t *testing.T) {
+	if _, err := IPAllowlist("admin", []string{"10.0.0.0/8", "not-a-cidr"}); err == nil {
+		t.Error("IPAllowlist accepted an invalid CIDR")
+	}
+	if _, err := ClientIPExtractor([]string{"10.0.0.300/8"}); err == nil {
+		t.Error("ClientIPExtractor accepted an invalid CIDR")
+	}
+}
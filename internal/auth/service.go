@@ -0,0 +1,160 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ServiceKey is one internal caller from SERVICE_TOKEN_KEYS_FILE. The caller
+// signs its own short-lived service tokens (JWTs with iss = Name) with the
+// private half of PublicKey, so no shared secret is stored on either side.
+// The roles come from here, not from the token.
+type ServiceKey struct {
+	Name      string   `json:"name"`
+	PublicKey string   `json:"public_key"` // PEM, Ed25519, ECDSA atau RSA
+	Roles     []string `json:"roles"`
+
+	key     interface{}
+	methods []string
+}
+
+// LoadServiceKeys reads a JSON array of ServiceKey
+func LoadServiceKeys(path string) ([]ServiceKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service keys file: %w", err)
+	}
+
+	var keys []ServiceKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("failed to decode service keys file: %w", err)
+	}
+
+	names := make(map[string]bool, len(keys))
+	for i, key := range keys {
+		switch {
+		case key.Name == "":
+			return nil, fmt.Errorf("service key %d has no name", i)
+		case names[key.Name]:
+			return nil, fmt.Errorf("duplicate service key name %q", key.Name)
+		case len(key.Roles) == 0:
+			return nil, fmt.Errorf("service key %q has no roles", key.Name)
+		}
+		for _, role := range key.Roles {
+			if roleRank[role] == 0 {
+				return nil, fmt.Errorf("service key %q: unknown role %q", key.Name, role)
+			}
+		}
+		if err := keys[i].parse(); err != nil {
+			return nil, fmt.Errorf("service key %q: %w", key.Name, err)
+		}
+		names[key.Name] = true
+	}
+	return keys, nil
+}
+
+// parse decodes PublicKey and picks the signing methods it verifies
+func (k *ServiceKey) parse() error {
+	block, _ := pem.Decode([]byte(k.PublicKey))
+	if block == nil {
+		return errors.New("public_key is not PEM")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("invalid public_key: %w", err)
+	}
+	switch key.(type) {
+	case ed25519.PublicKey:
+		k.methods = []string{"EdDSA"}
+	case *ecdsa.PublicKey:
+		k.methods = []string{"ES256", "ES384", "ES512"}
+	case *rsa.PublicKey:
+		k.methods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512"}
+	default:
+		return fmt.Errorf("unsupported public key type %T", key)
+	}
+	k.key = key
+	return nil
+}
+
+// serviceKey returns the key of the service that issued token, if token is a
+// JWT issued by a known service. The signature is not checked here.
+func (a *Authenticator) serviceKey(token string) (ServiceKey, bool) {
+	if len(a.serviceKeys) == 0 {
+		return ServiceKey{}, false
+	}
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(token, claims); err != nil {
+		return ServiceKey{}, false
+	}
+	issuer, _ := claims.GetIssuer()
+	key, ok := a.serviceKeys[issuer]
+	return key, ok
+}
+
+// authenticateService verifies a service token signed by key: it must be for
+// ServiceTokenAudience, carry iat and exp, and live at most
+// ServiceTokenMaxTTL, so a leaked token is only good for minutes
+func (a *Authenticator) authenticateService(token string, key ServiceKey) (Principal, error) {
+	parser := jwt.NewParser(
+		jwt.WithValidMethods(key.methods),
+		jwt.WithAudience(a.options.ServiceTokenAudience),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(clockSkew),
+	)
+	claims := jwt.MapClaims{}
+	_, err := parser.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+		return key.key, nil
+	})
+	if err != nil {
+		return Principal{}, err
+	}
+
+	issuedAt, err := claims.GetIssuedAt()
+	if err != nil || issuedAt == nil {
+		return Principal{}, errors.New("service token has no iat")
+	}
+	expiresAt, _ := claims.GetExpirationTime()
+	if ttl := expiresAt.Sub(issuedAt.Time); ttl > a.options.ServiceTokenMaxTTL {
+		return Principal{}, fmt.Errorf("service token lifetime %s exceeds %s", ttl, a.options.ServiceTokenMaxTTL)
+	}
+	return Principal{Subject: "service:" + key.Name, Roles: slices.Clone(key.Roles), Internal: true}, nil
+}
+
+// spiffeID returns the SPIFFE ID of the client certificate of a mutual TLS
+// connection, its spiffe:// URI SAN, once the certificate has been verified
+// against SERVER_TLS_CLIENT_CA_FILE
+func spiffeID(state *tls.ConnectionState) string {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	for _, uri := range state.VerifiedChains[0][0].URIs {
+		if uri.Scheme == "spiffe" {
+			return uri.String()
+		}
+	}
+	return ""
+}
+
+// spiffePrincipal maps the SPIFFE ID of a verified client certificate to its
+// role in SPIFFEIDs; a certificate without a mapped ID authenticates nothing
+func (a *Authenticator) spiffePrincipal(state *tls.ConnectionState) (Principal, bool) {
+	id := spiffeID(state)
+	role := a.options.SPIFFEIDs[id]
+	if id == "" || roleRank[role] == 0 {
+		return Principal{}, false
+	}
+	return Principal{Subject: id, Roles: []string{role}, Internal: true}, true
+}
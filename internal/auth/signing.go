@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+)
+
+// Header request yang ditandatangani
+const (
+	SignatureHeader          = "X-Signature"
+	SignatureTimestampHeader = "X-Signature-Timestamp"
+	SignatureNonceHeader     = "X-Signature-Nonce"
+)
+
+// Kode error request yang ditandatangani, di field "code" body error
+const (
+	SignatureMissing          = "signature_missing"
+	SignatureInvalid          = "signature_invalid"
+	SignatureTimestampInvalid = "timestamp_invalid"
+	SignatureTimestampSkew    = "timestamp_out_of_window"
+	SignatureNonceInvalid     = "nonce_invalid"
+	SignatureNonceReused      = "nonce_reused"
+	SignatureNonceUnavailable = "nonce_cache_unavailable"
+)
+
+// minSigningSecretLength is the shortest signing_secret accepted, in bytes
+const minSigningSecretLength = 32
+
+var noncePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{16,128}$`)
+
+// NonceCache remembers the nonces of signed requests in Redis, so a request
+// is accepted once across every instance
+type NonceCache struct {
+	client *redis.Client
+	prefix string
+}
+
+func NewNonceCache(client *redis.Client, prefix string) *NonceCache {
+	return &NonceCache{client: client, prefix: prefix + ":request_nonce:"}
+}
+
+// Claim records nonce of the named key for ttl and reports whether it was
+// unused
+func (n *NonceCache) Claim(ctx context.Context, keyName string, nonce string, ttl time.Duration) (bool, error) {
+	return n.client.SetNX(ctx, n.prefix+keyName+":"+nonce, 1, ttl).Result()
+}
+
+// signatureError is a rejected signed request; code is one of the Signature*
+// codes
+type signatureError struct {
+	status  int
+	code    string
+	message string
+}
+
+// SigningString is what a request is signed over: method, path with query,
+// timestamp, nonce and the hex SHA-256 of the body, separated by newlines.
+// The signature is the hex HMAC-SHA256 of it under the key's signing_secret.
+func SigningString(method string, requestURI string, timestamp string, nonce string, body []byte) string {
+	sum := sha256.Sum256(body)
+	return method + "\n" + requestURI + "\n" + timestamp + "\n" + nonce + "\n" + hex.EncodeToString(sum[:])
+}
+
+// Sign returns the X-Signature of a signing string
+func Sign(secret string, signingString string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signingString))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifySignature checks the signature of a request made with key: the
+// timestamp within the window, the HMAC over the request, then the nonce
+// unused. The body is read and put back for the handler.
+func (a *Authenticator) verifySignature(c echo.Context, key APIKey) *signatureError {
+	req := c.Request()
+	signature := req.Header.Get(SignatureHeader)
+	timestamp := req.Header.Get(SignatureTimestampHeader)
+	nonce := req.Header.Get(SignatureNonceHeader)
+	if signature == "" || timestamp == "" || nonce == "" {
+		return &signatureError{http.StatusUnauthorized, SignatureMissing,
+			"Signed requests need " + SignatureHeader + ", " + SignatureTimestampHeader + " and " + SignatureNonceHeader}
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return &signatureError{http.StatusUnauthorized, SignatureTimestampInvalid, SignatureTimestampHeader + " must be Unix seconds"}
+	}
+	window := a.options.SignatureWindow
+	if skew := time.Since(time.Unix(seconds, 0)); skew > window || skew < -window {
+		return &signatureError{http.StatusUnauthorized, SignatureTimestampSkew,
+			"Request timestamp is more than " + window.String() + " from server time"}
+	}
+	if !noncePattern.MatchString(nonce) {
+		return &signatureError{http.StatusUnauthorized, SignatureNonceInvalid, SignatureNonceHeader + " must be 16-128 characters of A-Z, a-z, 0-9, _ or -"}
+	}
+
+	var body []byte
+	if req.Body != nil {
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return &signatureError{http.StatusRequestEntityTooLarge, "", "Request body too large, limit is " + strconv.FormatInt(tooLarge.Limit, 10) + " bytes"}
+		}
+		if err != nil {
+			return &signatureError{http.StatusBadRequest, "", "Failed to read request body"}
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	expected := Sign(key.SigningSecret, SigningString(req.Method, req.URL.RequestURI(), timestamp, nonce, body))
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return &signatureError{http.StatusUnauthorized, SignatureInvalid, "Invalid request signature"}
+	}
+
+	// Nonce disimpan selama timestamp-nya masih bisa diterima (±window)
+	unused, err := a.options.Nonces.Claim(req.Context(), key.Name, nonce, 2*window)
+	if err != nil {
+		return &signatureError{http.StatusServiceUnavailable, SignatureNonceUnavailable, "Replay protection unavailable, please retry later"}
+	}
+	if !unused {
+		return &signatureError{http.StatusUnauthorized, SignatureNonceReused, "Request nonce was already used"}
+	}
+	return nil
+}
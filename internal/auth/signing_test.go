@@ -0,0 +1,191 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+)
+
+const testSigningSecret = "0123456789abcdef0123456789abcdef"
+
+// newSigningTestServer serves POST /api/v1/transaction for the service role,
+// echoing the body it received. "signed-key" signs its requests,
+// "plain-key" does not.
+func newSigningTestServer(t *testing.T) (*echo.Echo, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr(), MaxRetries: -1})
+	t.Cleanup(func() { client.Close() })
+
+	authn, err := New(context.Background(), Options{
+		APIKeys: []APIKey{
+			{Name: "signed", KeySHA256: HashAPIKey("signed-key"), Roles: []string{RoleService}, SigningSecret: testSigningSecret},
+			{Name: "plain", KeySHA256: HashAPIKey("plain-key"), Roles: []string{RoleService}},
+		},
+		APIKeyHeader:    "X-API-Key",
+		SignatureWindow: 5 * time.Minute,
+		Nonces:          NewNonceCache(client, "test"),
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	e := echo.New()
+	api := e.Group("/api/v1", authn.Authorize(Policy{Route(http.MethodPost, "/api/v1/transaction"): RoleService}))
+	api.POST("/transaction", func(c echo.Context) error {
+		body, _ := io.ReadAll(c.Request().Body)
+		return c.String(http.StatusOK, string(body))
+	})
+	return e, server
+}
+
+type signedRequest struct {
+	key       string
+	body      string
+	sentBody  string // body actually sent, when it differs from the signed one
+	timestamp string
+	nonce     string
+	signature string // overrides the computed signature
+	unsigned  bool
+}
+
+func (r signedRequest) do(e *echo.Echo) *httptest.ResponseRecorder {
+	if r.timestamp == "" {
+		r.timestamp = strconv.FormatInt(time.Now().Unix(), 10)
+	}
+	if r.nonce == "" {
+		r.nonce = "nonce-0000000000000001"
+	}
+	sent := r.body
+	if r.sentBody != "" {
+		sent = r.sentBody
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/transaction?dry=1", strings.NewReader(sent))
+	req.Header.Set("X-API-Key", r.key)
+	if !r.unsigned {
+		signature := r.signature
+		if signature == "" {
+			signature = Sign(testSigningSecret, SigningString(http.MethodPost, "/api/v1/transaction?dry=1", r.timestamp, r.nonce, []byte(r.body)))
+		}
+		req.Header.Set(SignatureHeader, signature)
+		req.Header.Set(SignatureTimestampHeader, r.timestamp)
+		req.Header.Set(SignatureNonceHeader, r.nonce)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func errorCode(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	var body map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode %q: %v", rec.Body.String(), err)
+	}
+	return body["code"]
+}
+
+func TestSignedRequests(t *testing.T) {
+	body := `{"account_id":"ACC001","amount":"10","type":"debit"}`
+	old := strconv.FormatInt(time.Now().Add(-6*time.Minute).Unix(), 10)
+	future := strconv.FormatInt(time.Now().Add(6*time.Minute).Unix(), 10)
+
+	tests := []struct {
+		name       string
+		request    signedRequest
+		wantStatus int
+		wantCode   string
+	}{
+		{"valid", signedRequest{key: "signed-key", body: body}, http.StatusOK, ""},
+		{"unsigned key", signedRequest{key: "plain-key", body: body, unsigned: true}, http.StatusOK, ""},
+		{"missing signature", signedRequest{key: "signed-key", body: body, unsigned: true}, http.StatusUnauthorized, SignatureMissing},
+		{"timestamp not a number", signedRequest{key: "signed-key", body: body, timestamp: "yesterday"}, http.StatusUnauthorized, SignatureTimestampInvalid},
+		{"timestamp too old", signedRequest{key: "signed-key", body: body, timestamp: old}, http.StatusUnauthorized, SignatureTimestampSkew},
+		{"timestamp in the future", signedRequest{key: "signed-key", body: body, timestamp: future}, http.StatusUnauthorized, SignatureTimestampSkew},
+		{"short nonce", signedRequest{key: "signed-key", body: body, nonce: "abc"}, http.StatusUnauthorized, SignatureNonceInvalid},
+		{"wrong signature", signedRequest{key: "signed-key", body: body, signature: strings.Repeat("0", 64)}, http.StatusUnauthorized, SignatureInvalid},
+		{"body changed", signedRequest{key: "signed-key", body: body, sentBody: strings.Replace(body, `"10"`, `"10000"`, 1)}, http.StatusUnauthorized, SignatureInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, _ := newSigningTestServer(t)
+			rec := tt.request.do(e)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantCode == "" {
+				if rec.Body.String() != tt.request.body {
+					t.Fatalf("handler got body %q, want %q", rec.Body.String(), tt.request.body)
+				}
+				return
+			}
+			if code := errorCode(t, rec); code != tt.wantCode {
+				t.Fatalf("code = %q, want %q", code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestSignedRequestReplay(t *testing.T) {
+	e, _ := newSigningTestServer(t)
+	request := signedRequest{key: "signed-key", body: `{"account_id":"ACC001","amount":"10","type":"debit"}`}
+	if rec := request.do(e); rec.Code != http.StatusOK {
+		t.Fatalf("first request: status %d: %s", rec.Code, rec.Body.String())
+	}
+	rec := request.do(e)
+	if rec.Code != http.StatusUnauthorized || errorCode(t, rec) != SignatureNonceReused {
+		t.Fatalf("replayed request: status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Nonce baru dengan signature baru diterima lagi
+	request.nonce = "nonce-0000000000000002"
+	if rec := request.do(e); rec.Code != http.StatusOK {
+		t.Fatalf("request with a new nonce: status %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestSignedRequestNonceCacheDown(t *testing.T) {
+	e, server := newSigningTestServer(t)
+	server.Close()
+	rec := signedRequest{key: "signed-key", body: "{}"}.do(e)
+	if rec.Code != http.StatusServiceUnavailable || errorCode(t, rec) != SignatureNonceUnavailable {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestNewRejectsSigningKeysWithoutReplayProtection(t *testing.T) {
+	client := redis.NewClient(&redis.Options{Addr: "localhost:0"})
+	defer client.Close()
+	tests := []struct {
+		name    string
+		options Options
+	}{
+		{"short secret", Options{
+			APIKeys:         []APIKey{{Name: "k", KeySHA256: HashAPIKey("k"), Roles: []string{RoleService}, SigningSecret: "short"}},
+			SignatureWindow: time.Minute, Nonces: NewNonceCache(client, "test"),
+		}},
+		{"no nonce cache", Options{
+			APIKeys:         []APIKey{{Name: "k", KeySHA256: HashAPIKey("k"), Roles: []string{RoleService}, SigningSecret: testSigningSecret}},
+			SignatureWindow: time.Minute,
+		}},
+		{"no window", Options{
+			APIKeys: []APIKey{{Name: "k", KeySHA256: HashAPIKey("k"), Roles: []string{RoleService}, SigningSecret: testSigningSecret}},
+			Nonces:  NewNonceCache(client, "test"),
+		}},
+	}
+	for _, tt := range tests {
+		if _, err := New(context.Background(), tt.options); err == nil {
+			t.Errorf("%s: New succeeded, want an error", tt.name)
+		}
+	}
+}
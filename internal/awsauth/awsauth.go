@@ -0,0 +1,235 @@
+// Package awsauth signs requests to AWS APIs with Signature Version 4, without
+// the AWS SDK. Credentials come from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY
+// and AWS_SESSION_TOKEN, or on EKS (IRSA) from exchanging the web identity
+// token in AWS_WEB_IDENTITY_TOKEN_FILE for AWS_ROLE_ARN.
+package awsauth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNoCredentials is returned by FromEnv when neither static credentials nor
+// a web identity role are configured
+var ErrNoCredentials = errors.New("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, or AWS_ROLE_ARN and AWS_WEB_IDENTITY_TOKEN_FILE, are required")
+
+// Credentials sign requests. The xml tags match the Credentials element of
+// an STS response, whose names differ in case from the fields.
+type Credentials struct {
+	AccessKeyID     string    `xml:"AccessKeyId"`
+	SecretAccessKey string    `xml:"SecretAccessKey"`
+	SessionToken    string    `xml:"SessionToken"`
+	Expiration      time.Time `xml:"Expiration"` // zero = kredensial statis
+}
+
+// Provider returns the current credentials, assuming the web identity role
+// again shortly before the previous credentials expire
+type Provider struct {
+	stsEndpoint string
+	roleARN     string
+	tokenFile   string
+	client      *http.Client
+
+	mutex       sync.Mutex
+	credentials Credentials
+}
+
+// FromEnv reads the credentials configuration from the environment.
+// stsEndpoint may be empty to use the regional STS endpoint.
+func FromEnv(region string, stsEndpoint string, client *http.Client) (*Provider, error) {
+	p := &Provider{
+		stsEndpoint: stsEndpoint,
+		roleARN:     os.Getenv("AWS_ROLE_ARN"),
+		tokenFile:   os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"),
+		client:      client,
+		credentials: Credentials{
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		},
+	}
+	staticCredentials := p.credentials.AccessKeyID != "" && p.credentials.SecretAccessKey != ""
+	if !staticCredentials && (p.roleARN == "" || p.tokenFile == "") {
+		return nil, ErrNoCredentials
+	}
+	if staticCredentials {
+		p.roleARN = "" // kredensial eksplisit menang
+	}
+	if p.stsEndpoint == "" {
+		p.stsEndpoint = "https://sts." + region + ".amazonaws.com"
+	}
+	return p, nil
+}
+
+// Region returns AWS_REGION, or AWS_DEFAULT_REGION when it is not set
+func Region() string {
+	if region := os.Getenv("AWS_REGION"); region != "" {
+		return region
+	}
+	return os.Getenv("AWS_DEFAULT_REGION")
+}
+
+// Current returns the static credentials, or assumes the web identity role
+// again when the previous credentials expire within 5 minutes
+func (p *Provider) Current(ctx context.Context) (Credentials, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.roleARN == "" || time.Now().Add(5*time.Minute).Before(p.credentials.Expiration) {
+		return p.credentials, nil
+	}
+
+	token, err := os.ReadFile(p.tokenFile)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("failed to read web identity token: %w", err)
+	}
+	query := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {p.roleARN},
+		"RoleSessionName":  {"sub-balance-demo"},
+		"WebIdentityToken": {strings.TrimSpace(string(token))},
+	}
+	// AssumeRoleWithWebIdentity tidak perlu ditandatangani
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.stsEndpoint+"/", strings.NewReader(query.Encode()))
+	if err != nil {
+		return Credentials{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return Credentials{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Credentials{}, fmt.Errorf("AssumeRoleWithWebIdentity returned status %d", resp.StatusCode)
+	}
+
+	var response struct {
+		Credentials Credentials `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return Credentials{}, fmt.Errorf("failed to decode AssumeRoleWithWebIdentity response: %w", err)
+	}
+	p.credentials = response.Credentials
+	return p.credentials, nil
+}
+
+// Sign adds the Signature Version 4 headers to req. The host, Content-Type
+// and every X-Amz-* header are signed; payloadHash is the hex SHA-256 of the
+// body (see PayloadHash).
+func Sign(req *http.Request, payloadHash string, service string, region string, credentials Credentials, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if credentials.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", credentials.SessionToken)
+	}
+
+	// Header yang ditandatangani, urut nama
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if name == "content-type" || strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := req.Method + "\n" + canonicalURI(req.URL) + "\n" + canonicalQuery(req.URL) + "\n" +
+		canonicalHeaders.String() + "\n" + signedHeaders + "\n" + payloadHash
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + PayloadHash([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+credentials.SecretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+credentials.AccessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// PayloadHash returns the hex SHA-256 of a request body
+func PayloadHash(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// EscapePath URI-encodes every segment of path once, as the signature does.
+// Use it as the URL's RawPath so the path sent is the path signed.
+func EscapePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = encode(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// EncodeQuery encodes query like the signature does: sorted, with every name
+// and value URI-encoded. Use it as the URL's RawQuery.
+func EncodeQuery(query url.Values) string {
+	pairs := make([]string, 0, len(query))
+	for name, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, encode(name)+"="+encode(value))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// canonicalURI is the path with every segment URI-encoded once, as S3 expects
+func canonicalURI(u *url.URL) string {
+	if u.Path == "" {
+		return "/"
+	}
+	return EscapePath(u.Path)
+}
+
+// canonicalQuery is the query with encoded names and values, sorted
+func canonicalQuery(u *url.URL) string {
+	return EncodeQuery(u.Query())
+}
+
+// encode percent-encodes everything except the RFC 3986 unreserved characters
+func encode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
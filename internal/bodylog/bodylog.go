@@ -0,0 +1,226 @@
+// Package bodylog logs request and response bodies for debugging
+// integrations, with sensitive fields redacted or hashed before anything is
+// written. It is meant to be switched on per environment, never by default.
+package bodylog
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const redacted = "[REDACTED]"
+
+// Redactor rewrites JSON documents, replacing the values of redact fields with
+// [REDACTED] and hashing the values of hash fields. Field names match case
+// insensitively at any depth; a redacted object or array is dropped entirely.
+type Redactor struct {
+	redact map[string]bool
+	hash   map[string]bool
+}
+
+func NewRedactor(redactFields []string, hashFields []string) *Redactor {
+	r := &Redactor{redact: make(map[string]bool), hash: make(map[string]bool)}
+	for _, field := range redactFields {
+		r.redact[strings.ToLower(field)] = true
+	}
+	for _, field := range hashFields {
+		r.hash[strings.ToLower(field)] = true
+	}
+	return r
+}
+
+// Body returns the redacted body. Anything that is not JSON cannot be
+// redacted reliably, so only its size is reported.
+func (r *Redactor) Body(body []byte) interface{} {
+	if len(body) == 0 {
+		return nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return fmt.Sprintf("[non-JSON body, %d bytes]", len(body))
+	}
+	return r.value("", document)
+}
+
+// Field redacts a single named value, e.g. a path or query parameter
+func (r *Redactor) Field(name string, value string) string {
+	key := strings.ToLower(name)
+	switch {
+	case r.redact[key]:
+		return redacted
+	case r.hash[key]:
+		return Hash(value)
+	}
+	return value
+}
+
+func (r *Redactor) value(name string, value interface{}) interface{} {
+	key := strings.ToLower(name)
+	if r.redact[key] {
+		return redacted
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for field, child := range v {
+			v[field] = r.value(field, child)
+		}
+		return v
+	case []interface{}:
+		for i, child := range v {
+			v[i] = r.value(name, child)
+		}
+		return v
+	case nil:
+		return nil
+	}
+
+	if r.hash[key] {
+		return Hash(fmt.Sprint(value))
+	}
+	return value
+}
+
+// Hash is a short SHA-256 of value: the same account always hashes the same,
+// so log lines can still be correlated
+func Hash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return "sha256:" + hex.EncodeToString(sum[:6])
+}
+
+// Middleware logs method, route, redacted parameters, status and the redacted
+// request and response bodies of every request. Bodies over maxBytes are not
+// logged, only their size. format is LOG_FORMAT: json or text.
+func Middleware(redactor *Redactor, maxBytes int, format string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			var requestBody []byte
+			if req.Body != nil {
+				// Error baca (mis. body di atas MAX_REQUEST_BODY_BYTES) tetap
+				// sampai ke handler setelah bagian yang sempat terbaca
+				var readErr error
+				requestBody, readErr = io.ReadAll(req.Body)
+				req.Body = io.NopCloser(io.MultiReader(bytes.NewReader(requestBody), failingReader{readErr}))
+			}
+
+			recorder := &bodyRecorder{ResponseWriter: c.Response().Writer, limit: maxBytes}
+			c.Response().Writer = recorder
+
+			start := time.Now()
+			err := next(c)
+
+			status := c.Response().Status
+			if err != nil && !c.Response().Committed {
+				status = http.StatusInternalServerError
+				if he, ok := err.(*echo.HTTPError); ok {
+					status = he.Code
+				}
+			}
+
+			entry := map[string]interface{}{
+				"msg":         "http body",
+				"method":      req.Method,
+				"route":       c.Path(),
+				"status":      status,
+				"duration_ms": float64(time.Since(start)) / float64(time.Millisecond),
+			}
+			if params := redactedParams(c, redactor); len(params) > 0 {
+				entry["params"] = params
+			}
+			if body := limitedBody(redactor, requestBody, len(requestBody), maxBytes); body != nil {
+				entry["request_body"] = body
+			}
+			if body := limitedBody(redactor, recorder.body.Bytes(), recorder.size, maxBytes); body != nil {
+				entry["response_body"] = body
+			}
+			write(entry, format)
+			return err
+		}
+	}
+}
+
+// redactedParams collects path and query parameters, redacted by name
+func redactedParams(c echo.Context, redactor *Redactor) map[string]string {
+	params := make(map[string]string)
+	for _, name := range c.ParamNames() {
+		params[name] = redactor.Field(name, c.Param(name))
+	}
+	for name, values := range c.QueryParams() {
+		params[name] = redactor.Field(name, strings.Join(values, ","))
+	}
+	return params
+}
+
+func limitedBody(redactor *Redactor, body []byte, size int, maxBytes int) interface{} {
+	if size > maxBytes {
+		return fmt.Sprintf("[%d bytes, over BODY_LOG_MAX_BYTES]", size)
+	}
+	return redactor.Body(body)
+}
+
+func write(entry map[string]interface{}, format string) {
+	if format == "json" {
+		entry["level"] = "debug"
+		encoded, err := json.Marshal(entry)
+		if err != nil {
+			log.Printf("Failed to encode body log entry: %v", err)
+			return
+		}
+		fmt.Fprintln(os.Stdout, string(encoded))
+		return
+	}
+
+	line := fmt.Sprintf("level=debug msg=\"http body\" method=%s route=%s status=%d duration_ms=%.2f",
+		entry["method"], entry["route"], entry["status"], entry["duration_ms"])
+	for _, key := range []string{"params", "request_body", "response_body"} {
+		if value, ok := entry[key]; ok {
+			encoded, _ := json.Marshal(value)
+			line += " " + key + "=" + string(encoded)
+		}
+	}
+	log.Print(line)
+}
+
+// bodyRecorder keeps the response body while it fits in limit; size counts
+// every byte written
+type bodyRecorder struct {
+	http.ResponseWriter
+	body  bytes.Buffer
+	limit int
+	size  int
+}
+
+func (w *bodyRecorder) Write(b []byte) (int, error) {
+	w.size += len(b)
+	if w.size <= w.limit {
+		w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// failingReader returns err, or io.EOF when err is nil
+type failingReader struct {
+	err error
+}
+
+func (r failingReader) Read([]byte) (int, error) {
+	if r.err == nil {
+		return 0, io.EOF
+	}
+	return 0, r.err
+}
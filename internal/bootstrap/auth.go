@@ -0,0 +1,216 @@
+// Package bootstrap builds the service's components from the configuration
+// at startup. Misconfiguration is returned as an error for main to stop on;
+// optional values that are invalid are logged and replaced by their default.
+package bootstrap
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"sub-balance-demo/internal/auth"
+	"sub-balance-demo/internal/config"
+	"sub-balance-demo/internal/secrets"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// AuthEnabled reports whether any way to authenticate callers is configured
+func AuthEnabled(cfg *config.Config) bool {
+	return cfg.EnableJWTAuth || cfg.APIKeysFile != "" || cfg.ServiceTokenKeysFile != "" || len(cfg.ServiceSPIFFEIDs) > 0
+}
+
+// Authenticator returns nil (every request allowed) unless JWT auth, API keys
+// or service identities are enabled. A misconfigured or unreachable identity
+// provider or a broken API or service keys file is an error: running without
+// authentication is not a safe fallback.
+func Authenticator(cfg *config.Config, failures *auth.FailureTracker, adminLockout *auth.AdminLockout, nonces *auth.NonceCache) (*auth.Authenticator, error) {
+	if !AuthEnabled(cfg) {
+		return nil, nil
+	}
+
+	options := auth.Options{APIKeyHeader: cfg.APIKeyHeader, Failures: failures, AdminLockout: adminLockout}
+	if cfg.EnableJWTAuth {
+		options.Issuer = cfg.JWTIssuer
+		options.JWKSURL = cfg.JWTJWKSURL
+		options.Audience = cfg.JWTAudience
+		options.Algorithms = cfg.JWTAlgorithms
+		options.RolesClaim = cfg.JWTRolesClaim
+		options.RoleMapping = parseRoleMapping("JWT role mapping", "idp_role", cfg.JWTRoleMapping)
+		options.ScopeMapping = parseRoleMapping("JWT scope mapping", "scope", cfg.JWTScopeMapping)
+		options.AccountsClaim = cfg.JWTAccountsClaim
+		options.TenantClaim = cfg.JWTTenantClaim
+
+		// Access token opaque divalidasi lewat introspection endpoint
+		if cfg.OAuth2IntrospectionURL != "" {
+			cacheTTL, err := time.ParseDuration(cfg.OAuth2IntrospectionCacheTTL)
+			if err != nil {
+				log.Printf("Invalid OAuth2 introspection cache TTL, using default 1m: %v", err)
+				cacheTTL = time.Minute
+			}
+			options.IntrospectionURL = cfg.OAuth2IntrospectionURL
+			options.ClientID = cfg.OAuth2ClientID
+			options.ClientSecret = cfg.OAuth2ClientSecret
+			options.IntrospectionCacheTTL = cacheTTL
+		}
+	}
+	if cfg.APIKeysFile != "" {
+		keys, err := auth.LoadAPIKeys(cfg.APIKeysFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load API keys: %w", err)
+		}
+		if options.APIKeys, err = decryptSigningSecrets(keys); err != nil {
+			return nil, err
+		}
+		window, err := time.ParseDuration(cfg.RequestSignatureWindow)
+		if err == nil && window <= 0 {
+			err = fmt.Errorf("%s is not positive", window)
+		}
+		if err != nil {
+			log.Printf("Invalid request signature window, using default 5m: %v", err)
+			window = 5 * time.Minute
+		}
+		options.SignatureWindow = window
+		options.Nonces = nonces
+	}
+	// Caller internal: SPIFFE ID lewat mTLS atau service token yang ditandatangani sendiri
+	options.SPIFFEIDs = parseRoleMapping("SPIFFE ID mapping", "spiffe_id", cfg.ServiceSPIFFEIDs)
+	if len(options.SPIFFEIDs) > 0 && cfg.ServerTLSClientCAFile == "" {
+		log.Printf("WARNING: SERVICE_SPIFFE_IDS set but SERVER_TLS_CLIENT_CA_FILE is empty, client certificates are not verified so no SPIFFE ID will authenticate")
+	}
+	if cfg.ServiceTokenKeysFile != "" {
+		keys, err := auth.LoadServiceKeys(cfg.ServiceTokenKeysFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load service token keys: %w", err)
+		}
+		maxTTL, err := time.ParseDuration(cfg.ServiceTokenMaxTTL)
+		if err == nil && maxTTL <= 0 {
+			err = fmt.Errorf("%s is not positive", maxTTL)
+		}
+		if err != nil {
+			log.Printf("Invalid service token max TTL, using default 5m: %v", err)
+			maxTTL = 5 * time.Minute
+		}
+		options.ServiceKeys = keys
+		options.ServiceTokenAudience = cfg.ServiceTokenAudience
+		options.ServiceTokenMaxTTL = maxTTL
+	}
+
+	authenticator, err := auth.New(context.Background(), options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize authentication: %w", err)
+	}
+	if cfg.EnableJWTAuth && cfg.JWTIssuer != "" {
+		log.Printf("JWT authentication enabled (issuer %s, JWKS %s)", cfg.JWTIssuer, authenticator.JWKSURL())
+	}
+	if options.IntrospectionURL != "" {
+		log.Printf("OAuth2 token introspection enabled (%s, client %s)", options.IntrospectionURL, options.ClientID)
+	}
+	if cfg.APIKeysFile != "" {
+		log.Printf("API key authentication enabled (%d keys, header %s)", len(options.APIKeys), cfg.APIKeyHeader)
+		signed := 0
+		for _, key := range options.APIKeys {
+			if key.SigningSecret != "" {
+				signed++
+			}
+		}
+		if signed > 0 {
+			log.Printf("Request signing required for %d API keys (window %s)", signed, options.SignatureWindow)
+		}
+	}
+	if len(options.SPIFFEIDs) > 0 {
+		log.Printf("SPIFFE authentication enabled (%d IDs)", len(options.SPIFFEIDs))
+	}
+	if len(options.ServiceKeys) > 0 {
+		log.Printf("Service token authentication enabled (%d services, audience %s, max TTL %s)",
+			len(options.ServiceKeys), options.ServiceTokenAudience, options.ServiceTokenMaxTTL)
+	}
+	return authenticator, nil
+}
+
+// decryptSigningSecrets decrypts enc: signing secrets of API keys with the
+// master key, bound to "API_KEY_SIGNING_SECRET:<name>" (encrypt them with
+// encrypt-config under that name)
+func decryptSigningSecrets(keys []auth.APIKey) ([]auth.APIKey, error) {
+	var masterKey []byte
+	for i, key := range keys {
+		if !strings.HasPrefix(key.SigningSecret, secrets.EncryptedPrefix) {
+			continue
+		}
+		if masterKey == nil {
+			var err error
+			masterKey, err = secrets.MasterKey(context.Background())
+			if err != nil {
+				return nil, fmt.Errorf("failed to load master key for API key signing secrets: %w", err)
+			}
+		}
+		secret, err := secrets.Decrypt(masterKey, "API_KEY_SIGNING_SECRET:"+key.Name, key.SigningSecret)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt signing secret of API key %q: %w", key.Name, err)
+		}
+		keys[i].SigningSecret = secret
+	}
+	return keys, nil
+}
+
+// parseRoleMapping parses "from=service_role" entries; what names the left
+// side in the log of an invalid entry
+func parseRoleMapping(name string, what string, entries []string) map[string]string {
+	mapping := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		from, to, found := strings.Cut(entry, "=")
+		if !found {
+			log.Printf("Invalid %s %q, expected %s=service_role, ignoring", name, entry, what)
+			continue
+		}
+		mapping[strings.TrimSpace(from)] = strings.TrimSpace(to)
+	}
+	return mapping
+}
+
+// AuthFailureTracker counts failed authentications and blocks IPs that keep
+// presenting invalid credentials
+func AuthFailureTracker(cfg *config.Config) *auth.FailureTracker {
+	window, err := time.ParseDuration(cfg.AuthFailureWindow)
+	if err != nil {
+		log.Printf("Invalid auth failure window, using default 5m: %v", err)
+		window = 5 * time.Minute
+	}
+	blockDuration, err := time.ParseDuration(cfg.AuthBlockDuration)
+	if err != nil {
+		log.Printf("Invalid auth block duration, using default 1m: %v", err)
+		blockDuration = time.Minute
+	}
+	maxBlock, err := time.ParseDuration(cfg.AuthBlockMaxDuration)
+	if err != nil {
+		log.Printf("Invalid auth block max duration, using default 1h: %v", err)
+		maxBlock = time.Hour
+	}
+	return auth.NewFailureTracker(cfg.AuthFailureThreshold, window, blockDuration, maxBlock)
+}
+
+// AdminLockout locks IPs out of the admin endpoints after repeated failed
+// authentications, with the counters in Redis; nil when disabled
+func AdminLockout(cfg *config.Config, rdb *redis.Client) *auth.AdminLockout {
+	if cfg.AdminLockoutThreshold <= 0 {
+		return nil
+	}
+	window, err := time.ParseDuration(cfg.AdminLockoutWindow)
+	if err != nil {
+		log.Printf("Invalid admin lockout window, using default 15m: %v", err)
+		window = 15 * time.Minute
+	}
+	lockDuration, err := time.ParseDuration(cfg.AdminLockoutDuration)
+	if err != nil {
+		log.Printf("Invalid admin lockout duration, using default 5m: %v", err)
+		lockDuration = 5 * time.Minute
+	}
+	maxLock, err := time.ParseDuration(cfg.AdminLockoutMaxDuration)
+	if err != nil {
+		log.Printf("Invalid admin lockout max duration, using default 24h: %v", err)
+		maxLock = 24 * time.Hour
+	}
+	return auth.NewAdminLockout(rdb, cfg.RedisNamespace(), cfg.AdminLockoutThreshold, window, lockDuration, maxLock)
+}
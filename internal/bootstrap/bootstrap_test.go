@@ -0,0 +1,208 @@
+package bootstrap
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"sub-balance-demo/internal/auth"
+	"sub-balance-demo/internal/config"
+	"sub-balance-demo/internal/secrets"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func writeFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// withMasterKey points CONFIG_MASTER_KEY_FILE at a fresh key for the test
+func withMasterKey(t *testing.T) []byte {
+	t.Helper()
+	key := bytes.Repeat([]byte{7}, 32)
+	t.Setenv("CONFIG_MASTER_KEY_KMS", "")
+	t.Setenv("CONFIG_MASTER_KEY_FILE", writeFile(t, "master.key", []byte(base64.StdEncoding.EncodeToString(key))))
+	return key
+}
+
+func apiKeysFile(t *testing.T, keys []auth.APIKey) string {
+	t.Helper()
+	data, err := json.Marshal(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writeFile(t, "api_keys.json", data)
+}
+
+func TestAuthenticator(t *testing.T) {
+	t.Setenv("CONFIG_MASTER_KEY_FILE", "")
+	t.Setenv("CONFIG_MASTER_KEY_KMS", "")
+	nonces := auth.NewNonceCache(redis.NewClient(&redis.Options{Addr: "127.0.0.1:1"}), "test")
+	signed := auth.APIKey{Name: "signed", KeySHA256: auth.HashAPIKey("signed-key"), Roles: []string{auth.RoleService}, SigningSecret: "0123456789abcdef0123456789abcdef"}
+	encrypted := signed
+	encrypted.SigningSecret = secrets.EncryptedPrefix + "AAAA"
+
+	tests := []struct {
+		name    string
+		cfg     *config.Config
+		wantNil bool
+		wantErr bool
+	}{
+		{"disabled", &config.Config{}, true, false},
+		{"missing API keys file", &config.Config{APIKeysFile: filepath.Join(t.TempDir(), "missing.json")}, false, true},
+		{"API keys", &config.Config{APIKeysFile: apiKeysFile(t, []auth.APIKey{signed}), APIKeyHeader: "X-API-Key", RequestSignatureWindow: "5m"}, false, false},
+		{"invalid signature window", &config.Config{APIKeysFile: apiKeysFile(t, []auth.APIKey{signed}), APIKeyHeader: "X-API-Key", RequestSignatureWindow: "-1m"}, false, false},
+		{"encrypted secret without master key", &config.Config{APIKeysFile: apiKeysFile(t, []auth.APIKey{encrypted}), APIKeyHeader: "X-API-Key", RequestSignatureWindow: "5m"}, false, true},
+		{"missing service keys file", &config.Config{ServiceTokenKeysFile: filepath.Join(t.TempDir(), "missing.json")}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if AuthEnabled(tt.cfg) == tt.wantNil {
+				t.Fatalf("AuthEnabled = %v", !tt.wantNil)
+			}
+			authn, err := Authenticator(tt.cfg, nil, nil, nonces)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Authenticator error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (authn == nil) != tt.wantNil {
+				t.Fatalf("Authenticator = %v, want nil %v", authn, tt.wantNil)
+			}
+		})
+	}
+}
+
+func TestDecryptSigningSecrets(t *testing.T) {
+	key := withMasterKey(t)
+	secret := "0123456789abcdef0123456789abcdef"
+	encrypted, err := secrets.Encrypt(key, "API_KEY_SIGNING_SECRET:partner-a", secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := decryptSigningSecrets([]auth.APIKey{{Name: "partner-a", SigningSecret: encrypted}, {Name: "plain", SigningSecret: secret}})
+	if err != nil {
+		t.Fatalf("decryptSigningSecrets: %v", err)
+	}
+	if keys[0].SigningSecret != secret || keys[1].SigningSecret != secret {
+		t.Fatalf("signing secrets = %q, %q", keys[0].SigningSecret, keys[1].SigningSecret)
+	}
+
+	// Secret terikat ke nama key: disalin ke key lain tidak bisa didekripsi
+	if _, err := decryptSigningSecrets([]auth.APIKey{{Name: "partner-b", SigningSecret: encrypted}}); err == nil {
+		t.Fatal("decrypted a signing secret encrypted for another key")
+	}
+}
+
+func TestParseRoleMapping(t *testing.T) {
+	mapping := parseRoleMapping("JWT role mapping", "idp_role", []string{"ops = admin", "invalid", "partner=service"})
+	if len(mapping) != 2 || mapping["ops"] != auth.RoleAdmin || mapping["partner"] != auth.RoleService {
+		t.Fatalf("parseRoleMapping = %v", mapping)
+	}
+}
+
+func TestMetadataCipher(t *testing.T) {
+	t.Setenv("CONFIG_MASTER_KEY_FILE", "")
+	t.Setenv("CONFIG_MASTER_KEY_KMS", "")
+	if cipher, err := MetadataCipher(&config.Config{}); cipher != nil || err != nil {
+		t.Fatalf("without fields or key = %v, %v; want nil, nil", cipher, err)
+	}
+	if _, err := MetadataCipher(&config.Config{MetadataEncryptedFields: []string{"card_number"}}); err == nil {
+		t.Fatal("encrypted fields without a master key did not fail")
+	}
+
+	withMasterKey(t)
+	if cipher, err := MetadataCipher(&config.Config{}); cipher == nil || err != nil {
+		t.Fatalf("with a master key = %v, %v; want a cipher", cipher, err)
+	}
+}
+
+func TestIngestionRequiresSource(t *testing.T) {
+	if _, err := Ingestion(&config.Config{}, nil, nil); err == nil {
+		t.Fatal("Ingestion without INGEST_SOURCE did not fail")
+	}
+}
+
+func TestCORSRules(t *testing.T) {
+	rules, err := CORSRules(&config.Config{CORSOrigins: []string{"https://app.example.com"}, CORSMethods: []string{"GET"}})
+	if err != nil || len(rules) != 1 || rules[0].Path != "/" || rules[0].Origins[0] != "https://app.example.com" {
+		t.Fatalf("CORSRules without CORS_RULES = %v, %v", rules, err)
+	}
+	if _, err := CORSRules(&config.Config{CORSRules: "not json"}); err == nil {
+		t.Fatal("invalid CORS_RULES did not fail")
+	}
+}
+
+func TestServerTLSConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     *config.Config
+		wantErr bool
+	}{
+		{"plain HTTP", &config.Config{}, false},
+		{"client CA without certificate", &config.Config{ServerTLSClientCAFile: "ca.pem"}, true},
+		{"invalid client auth", &config.Config{ServerTLSCertFile: "cert.pem", ServerTLSKeyFile: "key.pem", ServerTLSClientCAFile: "ca.pem", ServerTLSClientAuth: "maybe"}, true},
+		{"missing certificate", &config.Config{ServerTLSCertFile: filepath.Join(t.TempDir(), "cert.pem"), ServerTLSKeyFile: filepath.Join(t.TempDir(), "key.pem")}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tlsConfig, err := ServerTLSConfig(context.Background(), tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ServerTLSConfig error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && tlsConfig != nil {
+				t.Fatal("ServerTLSConfig without a certificate returned a TLS config")
+			}
+		})
+	}
+}
+
+func TestRedisEventStream(t *testing.T) {
+	tests := []struct {
+		enabled   bool
+		transport string
+		want      bool
+	}{
+		{false, "redis", false},
+		{true, "redis", true},
+		{true, "", true},
+		{true, "nats", false},
+		{true, "kafka", false},
+	}
+	for _, tt := range tests {
+		if got := RedisEventStream(&config.Config{EnableEventStream: tt.enabled, EventStreamTransport: tt.transport}); got != tt.want {
+			t.Errorf("RedisEventStream(enabled=%v, %q) = %v, want %v", tt.enabled, tt.transport, got, tt.want)
+		}
+	}
+}
+
+func TestKafkaPublisher(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.Config
+		wantErr bool
+	}{
+		{"without outbox", config.Config{KafkaBrokers: []string{"localhost:9092"}}, true},
+		{"without brokers", config.Config{EnableOutbox: true}, true},
+		{"outbox", config.Config{EnableOutbox: true, KafkaBrokers: []string{"localhost:9092"}, KafkaTopics: []string{"transaction.settled=ledger", "invalid"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			publisher, err := KafkaPublisher(&tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("KafkaPublisher err = %v, want error %v", err, tt.wantErr)
+			}
+			if publisher != nil {
+				publisher.Close()
+			}
+		})
+	}
+}
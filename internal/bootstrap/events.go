@@ -0,0 +1,171 @@
+package bootstrap
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"sub-balance-demo/internal/config"
+	"sub-balance-demo/internal/eventstream"
+	"sub-balance-demo/internal/repository"
+	"sub-balance-demo/internal/secrets"
+	"sub-balance-demo/internal/service"
+	"sub-balance-demo/internal/startup"
+
+	"github.com/nats-io/nats.go"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+// Webhooks builds the webhook worker. It reads the event stream as the
+// "webhooks" consumer group under the host name, so a restarted pod picks up
+// the entries it had read but not yet queued.
+func Webhooks(cfg *config.Config, db *gorm.DB, rdb *redis.Client) (*service.WebhookService, error) {
+	timeout, err := time.ParseDuration(cfg.WebhookTimeout)
+	if err != nil {
+		log.Printf("Invalid webhook timeout, using default 10s: %v", err)
+		timeout = 10 * time.Second
+	}
+	retryBaseDelay, err := time.ParseDuration(cfg.WebhookRetryBaseDelay)
+	if err != nil {
+		log.Printf("Invalid webhook retry base delay, using default 10s: %v", err)
+		retryBaseDelay = 10 * time.Second
+	}
+	retryMaxDelay, err := time.ParseDuration(cfg.WebhookRetryMaxDelay)
+	if err != nil {
+		log.Printf("Invalid webhook retry max delay, using default 1h: %v", err)
+		retryMaxDelay = time.Hour
+	}
+	secretOverlap, err := time.ParseDuration(cfg.WebhookSecretOverlap)
+	if err != nil {
+		log.Printf("Invalid webhook secret overlap, using default 24h: %v", err)
+		secretOverlap = 24 * time.Hour
+	}
+
+	// Secret subscription dienkripsi dengan master key yang sama dengan nilai
+	// enc: di konfigurasi; tanpa master key secret disimpan apa adanya
+	var secretKey []byte
+	if secrets.MasterKeyConfigured() {
+		secretKey, err = secrets.MasterKey(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("failed to load master key for webhook secrets: %w", err)
+		}
+	} else {
+		log.Println("WARNING: no CONFIG_MASTER_KEY_FILE or CONFIG_MASTER_KEY_KMS, webhook secrets are stored unencrypted")
+	}
+	if cfg.WebhookAllowPrivate {
+		log.Println("WARNING: WEBHOOK_ALLOW_PRIVATE_TARGETS is set, webhooks may reach internal addresses")
+	}
+
+	consumer := eventstream.NewConsumer(rdb, cfg.RedisNamespace()+":events", "webhooks", consumerName())
+	return service.NewWebhookService(db, repository.NewWebhookRepository(db), consumer, service.WebhookOptions{
+		BatchSize:           cfg.WebhookBatchSize,
+		MaxAttempts:         cfg.WebhookMaxAttempts,
+		RetryBaseDelay:      retryBaseDelay,
+		RetryMaxDelay:       retryMaxDelay,
+		Timeout:             timeout,
+		SecretKey:           secretKey,
+		AllowPrivateTargets: cfg.WebhookAllowPrivate,
+		SecretOverlap:       secretOverlap,
+	}), nil
+}
+
+// consumerName names this instance in the event stream consumer groups
+func consumerName() string {
+	name, err := os.Hostname()
+	if err != nil || name == "" {
+		return "sub-balance"
+	}
+	return name
+}
+
+// RedisEventStream reports whether events go to the Redis Stream, which the
+// webhooks and the RabbitMQ notifier consume
+func RedisEventStream(cfg *config.Config) bool {
+	return cfg.EnableEventStream && cfg.EventStreamTransport != "nats" && cfg.EventStreamTransport != "kafka"
+}
+
+// JetStreamPublisher connects to NATS, retrying like the database and Redis,
+// and publishes events to JetStream. The connection reconnects on its own for
+// as long as the service runs.
+func JetStreamPublisher(cfg *config.Config) (*eventstream.JetStreamPublisher, *nats.Conn, error) {
+	var nc *nats.Conn
+	err := startup.ConnectWithRetry(cfg, "NATS", func() error {
+		var err error
+		nc, err = nats.Connect(cfg.NATSURL,
+			nats.Name("sub-balance"),
+			nats.MaxReconnects(-1),
+			nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+				if err != nil {
+					log.Printf("NATS disconnected, reconnecting: %v", err)
+				}
+			}),
+			nats.ReconnectHandler(func(nc *nats.Conn) {
+				log.Printf("NATS reconnected to %s", nc.ConnectedUrlRedacted())
+			}),
+		)
+		return err
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	publisher, err := eventstream.NewJetStreamPublisher(ctx, nc, cfg.NATSStream, cfg.NATSSubjectPrefix)
+	if err != nil {
+		nc.Close()
+		return nil, nil, fmt.Errorf("failed to initialize JetStream: %w", err)
+	}
+	log.Printf("Event stream on NATS JetStream (stream %s, subjects %s.<type>)", cfg.NATSStream, cfg.NATSSubjectPrefix)
+	return publisher, nc, nil
+}
+
+// KafkaPublisher publishes events to Kafka. Only the outbox relay publishes
+// to it, so an event is never lost between the state change and the broker's
+// ack: the transport requires ENABLE_OUTBOX.
+func KafkaPublisher(cfg *config.Config) (*eventstream.KafkaPublisher, error) {
+	if !cfg.EnableOutbox {
+		return nil, fmt.Errorf("EVENT_STREAM_TRANSPORT=kafka requires ENABLE_OUTBOX")
+	}
+	if len(cfg.KafkaBrokers) == 0 {
+		return nil, fmt.Errorf("EVENT_STREAM_TRANSPORT=kafka requires KAFKA_BROKERS")
+	}
+	topics := make(map[string]string, len(cfg.KafkaTopics))
+	for _, entry := range cfg.KafkaTopics {
+		eventType, topic, found := strings.Cut(entry, "=")
+		if !found || strings.TrimSpace(topic) == "" {
+			log.Printf("Invalid KAFKA_TOPICS entry %q, expected event_type=topic, ignoring", entry)
+			continue
+		}
+		topics[strings.TrimSpace(eventType)] = strings.TrimSpace(topic)
+	}
+	log.Printf("Event stream on Kafka %v (topic %s, %d per-type topics)", cfg.KafkaBrokers, cfg.KafkaTopic, len(topics))
+	return eventstream.NewKafkaPublisher(cfg.KafkaBrokers, cfg.KafkaTopic, topics), nil
+}
+
+// SettlementNotifier forwards settlement.completed events to the RabbitMQ
+// exchange, as the "rabbitmq" consumer group of the event stream
+func SettlementNotifier(cfg *config.Config, rdb *redis.Client) *service.SettlementNotifier {
+	retryBaseDelay, err := time.ParseDuration(cfg.RabbitMQRetryBaseDelay)
+	if err != nil || retryBaseDelay <= 0 {
+		log.Printf("Invalid RabbitMQ retry base delay, using default 1s: %v", err)
+		retryBaseDelay = time.Second
+	}
+	retryMaxDelay, err := time.ParseDuration(cfg.RabbitMQRetryMaxDelay)
+	if err != nil || retryMaxDelay < retryBaseDelay {
+		log.Printf("Invalid RabbitMQ retry max delay, using default 1m: %v", err)
+		retryMaxDelay = time.Minute
+	}
+	if !cfg.EnableOutbox {
+		log.Println("WARNING: ENABLE_RABBITMQ without ENABLE_OUTBOX, settlement notifications can be lost if the event stream write fails")
+	}
+
+	consumer := eventstream.NewConsumer(rdb, cfg.RedisNamespace()+":events", "rabbitmq", consumerName())
+	publisher := eventstream.NewAMQPPublisher(cfg.RabbitMQURL, cfg.RabbitMQExchange)
+	log.Printf("RabbitMQ settlement notifications enabled (exchange %s)", cfg.RabbitMQExchange)
+	return service.NewSettlementNotifier(consumer, publisher, retryBaseDelay, retryMaxDelay)
+}
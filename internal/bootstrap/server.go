@@ -0,0 +1,74 @@
+package bootstrap
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"sub-balance-demo/internal/config"
+	"sub-balance-demo/internal/cors"
+	"sub-balance-demo/internal/tlsreload"
+)
+
+// ServerTLSConfig builds the HTTP listener's TLS config, or nil to serve plain
+// HTTP. With a client CA bundle callers must present a certificate signed by
+// it (SERVER_TLS_CLIENT_AUTH=require) or have a presented one verified
+// (verify_if_given). Certificate and CA are reloaded when their files change
+// or on SIGHUP until ctx is done.
+func ServerTLSConfig(ctx context.Context, cfg *config.Config) (*tls.Config, error) {
+	if cfg.ServerTLSCertFile == "" && cfg.ServerTLSKeyFile == "" {
+		if cfg.ServerTLSClientCAFile != "" {
+			return nil, errors.New("SERVER_TLS_CLIENT_CA_FILE requires SERVER_TLS_CERT_FILE and SERVER_TLS_KEY_FILE")
+		}
+		return nil, nil
+	}
+
+	base := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.ServerTLSClientCAFile != "" {
+		switch cfg.ServerTLSClientAuth {
+		case "require":
+			base.ClientAuth = tls.RequireAndVerifyClientCert
+		case "verify_if_given":
+			base.ClientAuth = tls.VerifyClientCertIfGiven
+		default:
+			return nil, fmt.Errorf("invalid SERVER_TLS_CLIENT_AUTH %q, expected require or verify_if_given", cfg.ServerTLSClientAuth)
+		}
+	}
+
+	reloader, err := tlsreload.New(cfg.ServerTLSCertFile, cfg.ServerTLSKeyFile, cfg.ServerTLSClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+
+	reloadInterval, err := time.ParseDuration(cfg.ServerTLSReloadInterval)
+	if err != nil {
+		log.Printf("Invalid server TLS reload interval, using default 30s: %v", err)
+		reloadInterval = 30 * time.Second
+	}
+	go reloader.Watch(ctx, reloadInterval)
+
+	if cfg.ServerTLSClientCAFile == "" {
+		log.Printf("Serving HTTPS on :%s (client certificates not verified), certificate expires %s",
+			cfg.Port, reloader.Expiry().Format(time.RFC3339))
+	} else {
+		log.Printf("Serving HTTPS on :%s with mutual TLS (client auth: %s), certificate expires %s",
+			cfg.Port, cfg.ServerTLSClientAuth, reloader.Expiry().Format(time.RFC3339))
+	}
+	return reloader.Config(base), nil
+}
+
+// CORSRules are the per-route CORS_RULES, or one rule for every route built
+// from CORS_ORIGINS, CORS_METHODS and CORS_HEADERS
+func CORSRules(cfg *config.Config) ([]cors.Rule, error) {
+	if cfg.CORSRules == "" {
+		return []cors.Rule{{Path: "/", Origins: cfg.CORSOrigins, Methods: cfg.CORSMethods, Headers: cfg.CORSHeaders}}, nil
+	}
+	rules, err := cors.ParseRules(cfg.CORSRules)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CORS_RULES: %w", err)
+	}
+	return rules, nil
+}
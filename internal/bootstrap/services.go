@@ -0,0 +1,178 @@
+package bootstrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"sub-balance-demo/internal/config"
+	"sub-balance-demo/internal/ingest"
+	"sub-balance-demo/internal/logmask"
+	"sub-balance-demo/internal/repository"
+	"sub-balance-demo/internal/secrets"
+	"sub-balance-demo/internal/service"
+	"sub-balance-demo/internal/tenant"
+
+	"github.com/segmentio/kafka-go"
+	"gorm.io/gorm"
+)
+
+// MetadataCipher loads the master key for transaction metadata. Encrypted
+// fields without a master key are an error; without fields the key is still
+// loaded when configured, so values encrypted before are readable.
+func MetadataCipher(cfg *config.Config) (*service.MetadataCipher, error) {
+	if !secrets.MasterKeyConfigured() {
+		if len(cfg.MetadataEncryptedFields) > 0 {
+			return nil, errors.New("METADATA_ENCRYPTED_FIELDS requires CONFIG_MASTER_KEY_FILE or CONFIG_MASTER_KEY_KMS")
+		}
+		return nil, nil
+	}
+	key, err := secrets.MasterKey(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to load master key for transaction metadata: %w", err)
+	}
+	return service.NewMetadataCipher(key, cfg.MetadataEncryptedFields), nil
+}
+
+// Ingestion builds the partner file ingestion worker. A source that cannot
+// be opened is an error.
+func Ingestion(cfg *config.Config, db *gorm.DB, transactionService service.TransactionService) (*service.IngestionWorker, error) {
+	if cfg.IngestSource == "" {
+		return nil, errors.New("ENABLE_INGESTION requires INGEST_SOURCE")
+	}
+	source, err := ingest.NewSource(cfg.IngestSource, ingest.Options{
+		ReportPrefix: cfg.IngestReportPrefix,
+		S3Endpoint:   cfg.IngestS3Endpoint,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid INGEST_SOURCE: %w", err)
+	}
+	if cfg.IngestTenant != "" {
+		if err := tenant.Validate(cfg.IngestTenant); err != nil {
+			return nil, fmt.Errorf("invalid INGEST_TENANT: %w", err)
+		}
+	}
+
+	pain001 := ingest.Pain001Mapping{
+		Side:           cfg.IngestPain001Side,
+		Currency:       cfg.IngestPain001Currency,
+		Accounts:       make(map[string]string, len(cfg.IngestPain001Accounts)),
+		StrictAccounts: cfg.IngestPain001StrictAccounts,
+	}
+	for _, entry := range cfg.IngestPain001Accounts {
+		from, to, found := strings.Cut(entry, "=")
+		if !found {
+			log.Printf("Invalid pain.001 account mapping %q, expected partner_account=account_id, ignoring", entry)
+			continue
+		}
+		pain001.Accounts[strings.TrimSpace(from)] = strings.TrimSpace(to)
+	}
+	if err := pain001.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid pain.001 mapping: %w", err)
+	}
+
+	minFileAge, err := time.ParseDuration(cfg.IngestMinFileAge)
+	if err != nil {
+		log.Printf("Invalid ingestion min file age, using default 1m: %v", err)
+		minFileAge = time.Minute
+	}
+	return service.NewIngestionWorker(source, repository.NewIngestRepository(db), transactionService, service.IngestionOptions{
+		MinFileAge: minFileAge,
+		MaxRows:    cfg.IngestMaxRows,
+		Tenant:     cfg.IngestTenant,
+		Pain001:    pain001,
+	}), nil
+}
+
+// KafkaIngestion builds the consumer of the Kafka ingestion topic, in the
+// KAFKA_INGEST_GROUP_ID consumer group with offsets committed synchronously
+// after each message
+func KafkaIngestion(cfg *config.Config, db *gorm.DB, transactionService service.TransactionService) (*service.KafkaIngestion, error) {
+	if len(cfg.KafkaBrokers) == 0 {
+		return nil, errors.New("ENABLE_KAFKA_INGESTION requires KAFKA_BROKERS")
+	}
+	if cfg.KafkaIngestTopic == "" || cfg.KafkaIngestGroupID == "" || cfg.KafkaIngestDLQTopic == "" {
+		return nil, errors.New("ENABLE_KAFKA_INGESTION requires KAFKA_INGEST_TOPIC, KAFKA_INGEST_GROUP_ID and KAFKA_INGEST_DLQ_TOPIC")
+	}
+	if cfg.KafkaIngestDLQTopic == cfg.KafkaIngestTopic {
+		return nil, errors.New("KAFKA_INGEST_DLQ_TOPIC must differ from KAFKA_INGEST_TOPIC")
+	}
+	if cfg.KafkaIngestTenant != "" {
+		if err := tenant.Validate(cfg.KafkaIngestTenant); err != nil {
+			return nil, fmt.Errorf("invalid KAFKA_INGEST_TENANT: %w", err)
+		}
+	}
+	retryBaseDelay, err := time.ParseDuration(cfg.KafkaIngestRetryBaseDelay)
+	if err != nil || retryBaseDelay <= 0 {
+		log.Printf("Invalid Kafka ingestion retry base delay, using default 1s: %v", err)
+		retryBaseDelay = time.Second
+	}
+	retryMaxDelay, err := time.ParseDuration(cfg.KafkaIngestRetryMaxDelay)
+	if err != nil || retryMaxDelay < retryBaseDelay {
+		log.Printf("Invalid Kafka ingestion retry max delay, using default 1m: %v", err)
+		retryMaxDelay = time.Minute
+	}
+
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers: cfg.KafkaBrokers,
+		GroupID: cfg.KafkaIngestGroupID,
+		Topic:   cfg.KafkaIngestTopic,
+		MaxWait: time.Second,
+	})
+	dlq := kafka.NewWriter(kafka.WriterConfig{
+		Brokers:      cfg.KafkaBrokers,
+		Topic:        cfg.KafkaIngestDLQTopic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: -1,
+		BatchTimeout: 10 * time.Millisecond,
+	})
+	return service.NewKafkaIngestion(reader, dlq, repository.NewIngestRepository(db), transactionService,
+		cfg.KafkaIngestTopic, cfg.KafkaIngestTenant, retryBaseDelay, retryMaxDelay), nil
+}
+
+// LogMasking applies LOG_ACCOUNT_IDS and LOG_AMOUNTS. Plain account IDs are
+// only allowed in LOG_PLAIN_ENVIRONMENTS; an invalid policy masks everything
+// rather than nothing.
+func LogMasking(cfg *config.Config) {
+	accountMode := cfg.LogAccountIDs
+	if accountMode == logmask.AccountsPlain && !slices.Contains(cfg.LogPlainEnvironments, cfg.AppEnv) {
+		log.Printf("Plain account IDs in logs are not allowed in APP_ENV=%s (LOG_PLAIN_ENVIRONMENTS=%v), hashing them", cfg.AppEnv, cfg.LogPlainEnvironments)
+		accountMode = logmask.AccountsHash
+	}
+	if err := logmask.Configure(accountMode, cfg.LogAmounts); err != nil {
+		log.Printf("Invalid log masking policy, hashing account IDs and redacting amounts: %v", err)
+		logmask.Configure(logmask.AccountsHash, logmask.AmountsRedact)
+		return
+	}
+	log.Printf("Log masking: account_ids=%s, amounts=%s", accountMode, cfg.LogAmounts)
+}
+
+// ThresholdAlerter sends threshold alerts to the alert webhook and, when
+// configured, to Slack
+func ThresholdAlerter(cfg *config.Config, alertNotifier *service.WebhookNotifier) *service.ThresholdAlerter {
+	breakerOpenDuration, err := time.ParseDuration(cfg.AlertBreakerOpenDuration)
+	if err != nil {
+		log.Printf("Invalid alert breaker open duration, using default 1m: %v", err)
+		breakerOpenDuration = time.Minute
+	}
+	cooldown, err := time.ParseDuration(cfg.AlertCooldown)
+	if err != nil {
+		log.Printf("Invalid alert cooldown, using default 15m: %v", err)
+		cooldown = 15 * time.Minute
+	}
+
+	notifiers := []*service.WebhookNotifier{alertNotifier}
+	if cfg.AlertSlackWebhookURL != "" {
+		notifiers = append(notifiers, service.NewSlackNotifier(cfg.AlertSlackWebhookURL))
+	}
+	return service.NewThresholdAlerter(service.AlertThresholds{
+		RepairsPerRun:            cfg.AlertRepairsPerRun,
+		SettlementFailuresPerRun: cfg.AlertSettlementFailuresPerRun,
+		BreakerOpenDuration:      breakerOpenDuration,
+		Cooldown:                 cooldown,
+	}, notifiers...)
+}
@@ -0,0 +1,67 @@
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// Diisi saat build lewat ldflags, lihat target build di Makefile:
+//
+//	go build -ldflags "-X sub-balance-demo/internal/buildinfo.Version=v1.2.3 \
+//	  -X sub-balance-demo/internal/buildinfo.Commit=abc1234 \
+//	  -X sub-balance-demo/internal/buildinfo.BuildDate=2024-01-01T00:00:00Z"
+var (
+	Version   = ""
+	Commit    = ""
+	BuildDate = ""
+)
+
+// startTime is captured when the package is initialized, i.e. at process start
+var startTime = time.Now()
+
+// Info describes the running binary
+type Info struct {
+	Version   string    `json:"version"`
+	Commit    string    `json:"commit"`
+	BuildDate string    `json:"build_date"`
+	GoVersion string    `json:"go_version"`
+	StartTime time.Time `json:"start_time"`
+}
+
+// Get returns the build info, falling back to defaultVersion (APP_VERSION)
+// when the binary was built without ldflags, and to the VCS revision Go embeds
+// in the binary when no commit was given
+func Get(defaultVersion string) Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+		StartTime: startTime,
+	}
+	if info.Version == "" {
+		info.Version = defaultVersion
+	}
+	if info.Commit == "" {
+		if bi, ok := debug.ReadBuildInfo(); ok {
+			for _, setting := range bi.Settings {
+				if setting.Key == "vcs.revision" {
+					info.Commit = setting.Value
+				}
+			}
+		}
+	}
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	if info.BuildDate == "" {
+		info.BuildDate = "unknown"
+	}
+	return info
+}
+
+// Uptime is the time since the process started
+func Uptime() time.Duration {
+	return time.Since(startTime)
+}
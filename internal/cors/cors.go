@@ -0,0 +1,109 @@
+// Package cors applies a different CORS policy per route, so e.g. a dashboard
+// origin may read balances while no origin may post transactions
+// cross-origin. A request matching no rule gets no CORS headers, which makes
+// the browser refuse the cross-origin call.
+package cors
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+// Rule is the CORS policy of the routes under Path for Methods
+type Rule struct {
+	// Path is a path prefix matched on segment boundaries: /api/v1/balance
+	// covers /api/v1/balance/ACC001 but not /api/v1/balances
+	Path string `json:"path"`
+	// Methods the rule covers and allows cross-origin; empty means all
+	Methods          []string `json:"methods"`
+	Origins          []string `json:"origins"`
+	Headers          []string `json:"headers"`
+	AllowCredentials bool     `json:"allow_credentials"`
+	MaxAge           int      `json:"max_age"` // seconds the browser may cache a preflight
+}
+
+// ParseRules decodes a JSON array of Rule. Rules must have a path and at
+// least one origin, and cannot combine credentials with the "*" origin.
+func ParseRules(data string) ([]Rule, error) {
+	var rules []Rule
+	if err := json.Unmarshal([]byte(data), &rules); err != nil {
+		return nil, fmt.Errorf("failed to decode CORS rules: %w", err)
+	}
+	for i, rule := range rules {
+		if err := rule.validate(); err != nil {
+			return nil, fmt.Errorf("CORS rule %d (%s): %w", i, rule.Path, err)
+		}
+	}
+	return rules, nil
+}
+
+func (r Rule) validate() error {
+	switch {
+	case !strings.HasPrefix(r.Path, "/"):
+		return fmt.Errorf("path must start with /")
+	case len(r.Origins) == 0:
+		return fmt.Errorf("no origins")
+	case r.AllowCredentials && slices.Contains(r.Origins, "*"):
+		return fmt.Errorf("allow_credentials cannot be used with origin *")
+	}
+	return nil
+}
+
+// matches reports whether the rule covers a request for method on path
+func (r Rule) matches(method string, path string) bool {
+	prefix := strings.TrimSuffix(r.Path, "/")
+	if path != prefix && !strings.HasPrefix(path, prefix+"/") {
+		return false
+	}
+	return len(r.Methods) == 0 || slices.ContainsFunc(r.Methods, func(m string) bool {
+		return strings.EqualFold(m, method)
+	})
+}
+
+// Middleware applies the first rule matching each request. A preflight is
+// matched by the method it asks for (Access-Control-Request-Method).
+func Middleware(rules []Rule) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		handlers := make([]echo.HandlerFunc, len(rules))
+		for i, rule := range rules {
+			handlers[i] = middleware.CORSWithConfig(middleware.CORSConfig{
+				AllowOrigins:     rule.Origins,
+				AllowMethods:     rule.allowedMethods(),
+				AllowHeaders:     rule.Headers,
+				AllowCredentials: rule.AllowCredentials,
+				MaxAge:           rule.MaxAge,
+			})(next)
+		}
+
+		return func(c echo.Context) error {
+			req := c.Request()
+			method := req.Method
+			if requested := req.Header.Get(echo.HeaderAccessControlRequestMethod); method == http.MethodOptions && requested != "" {
+				method = requested
+			}
+			for i, rule := range rules {
+				if rule.matches(method, req.URL.Path) {
+					return handlers[i](c)
+				}
+			}
+			return next(c)
+		}
+	}
+}
+
+func (r Rule) allowedMethods() []string {
+	if len(r.Methods) == 0 {
+		return []string{http.MethodGet, http.MethodHead, http.MethodPut, http.MethodPatch, http.MethodPost, http.MethodDelete}
+	}
+	methods := make([]string, len(r.Methods))
+	for i, method := range r.Methods {
+		methods[i] = strings.ToUpper(method)
+	}
+	return methods
+}
@@ -0,0 +1,263 @@
+// Package database opens the service's GORM connections and the native pgx
+// pools used by the hot-path repositories.
+package database
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"sub-balance-demo/internal/config"
+	"sub-balance-demo/internal/repository"
+	"sub-balance-demo/internal/startup"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Open connects to the configured database (waiting for it while it starts),
+// sizes its pool and migrates the schema. gormLogger logs the queries.
+func Open(cfg *config.Config, gormLogger logger.Interface) (*gorm.DB, error) {
+	var dialector gorm.Dialector
+	switch cfg.DatabaseDriver {
+	case "postgres", "":
+		var err error
+		dialector, err = postgresDialector(cfg, cfg.DatabaseURL, "DATABASE_URL")
+		if err != nil {
+			return nil, err
+		}
+	case "sqlite":
+		log.Printf("WARNING: using embedded SQLite database %s; for local development and tests only", cfg.SQLitePath)
+		dialector = sqlite.Open(sqliteDSN(cfg.SQLitePath))
+
+		// Set-based settlement memakai CTE UPDATE ... RETURNING khas Postgres,
+		// jadi SETTLEMENT_SET_BASED_THRESHOLD diabaikan dan semua account
+		// disettle per baris
+		if cfg.SettlementSetBasedThreshold > 0 {
+			log.Printf("SETTLEMENT_SET_BASED_THRESHOLD=%d ignored: set-based settlement is Postgres only", cfg.SettlementSetBasedThreshold)
+		}
+		cfg.SettlementSetBasedThreshold = 0
+	default:
+		return nil, fmt.Errorf("unsupported DB_DRIVER %q", cfg.DatabaseDriver)
+	}
+
+	// gorm.Open melakukan ping, jadi database yang belum siap dicoba ulang
+	var db *gorm.DB
+	err := startup.ConnectWithRetry(cfg, "database", func() error {
+		var err error
+		db, err = gorm.Open(dialector, gormConfig(cfg, gormLogger))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Configure connection pool
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse connection max lifetime
+	connMaxLifetime, err := time.ParseDuration(cfg.DBConnMaxLifetime)
+	if err != nil {
+		log.Printf("Invalid DB connection max lifetime, using default 5m: %v", err)
+		connMaxLifetime = 5 * time.Minute
+	}
+
+	sqlDB.SetMaxOpenConns(cfg.DBMaxOpenConns)
+	sqlDB.SetMaxIdleConns(cfg.DBMaxIdleConns)
+	sqlDB.SetConnMaxLifetime(connMaxLifetime)
+
+	// Auto migrate
+	err = db.AutoMigrate(repository.Models()...)
+	if err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// queryExecModes maps DB_QUERY_EXEC_MODE to pgx's default_query_exec_mode
+var queryExecModes = map[string]pgx.QueryExecMode{
+	"cache_statement": pgx.QueryExecModeCacheStatement,
+	"cache_describe":  pgx.QueryExecModeCacheDescribe,
+	"describe_exec":   pgx.QueryExecModeDescribeExec,
+	"exec":            pgx.QueryExecModeExec,
+	"simple_protocol": pgx.QueryExecModeSimpleProtocol,
+}
+
+// usesServerStatements reports whether the configured pgx mode keeps named
+// prepared statements on the server connection. exec dan simple_protocol tidak,
+// sehingga aman di belakang PgBouncer transaction pooling.
+func usesServerStatements(cfg *config.Config) bool {
+	mode := strings.ToLower(cfg.DBQueryExecMode)
+	return mode != "exec" && mode != "simple_protocol"
+}
+
+// gormConfig returns the GORM settings shared by the primary and the replica
+func gormConfig(cfg *config.Config, gormLogger logger.Interface) *gorm.Config {
+	prepareStmt := cfg.DBPrepareStatements
+	if prepareStmt && !usesServerStatements(cfg) && cfg.DatabaseDriver != "sqlite" {
+		log.Printf("WARNING: DB_PREPARE_STATEMENTS ignored with DB_QUERY_EXEC_MODE=%s", cfg.DBQueryExecMode)
+		prepareStmt = false
+	}
+	return &gorm.Config{PrepareStmt: prepareStmt, Logger: gormLogger}
+}
+
+// applyStatementCache sets pgx's query exec mode and cache sizes on a
+// connection config, used by both the GORM connections and the pgx pools
+func applyStatementCache(cfg *config.Config, connConfig *pgx.ConnConfig) {
+	mode, ok := queryExecModes[strings.ToLower(cfg.DBQueryExecMode)]
+	if !ok {
+		log.Printf("Invalid DB query exec mode %q, using default cache_statement", cfg.DBQueryExecMode)
+		mode = pgx.QueryExecModeCacheStatement
+	}
+	connConfig.DefaultQueryExecMode = mode
+
+	if cfg.DBStatementCacheCapacity > 0 {
+		connConfig.StatementCacheCapacity = cfg.DBStatementCacheCapacity
+		connConfig.DescriptionCacheCapacity = cfg.DBStatementCacheCapacity
+	}
+}
+
+// postgresDialector opens url through pgx's database/sql driver with the
+// configured statement cache instead of the driver defaults. urlEnv names the
+// variable url came from, for credentials rotated in a secret manager.
+func postgresDialector(cfg *config.Config, url string, urlEnv string) (gorm.Dialector, error) {
+	connConfig, err := pgx.ParseConfig(url)
+	if err != nil {
+		return nil, err
+	}
+	applyStatementCache(cfg, connConfig)
+	db := stdlib.OpenDB(*connConfig, stdlib.OptionBeforeConnect(rotatedCredentials(cfg, urlEnv)))
+	return postgres.New(postgres.Config{Conn: db}), nil
+}
+
+// rotatedCredentials gives each new connection the user and password of the
+// current value of urlEnv when it comes from a secret manager, so a rotated
+// database password is picked up without a restart. Open connections keep
+// the old one until DB_CONN_MAX_LIFETIME.
+func rotatedCredentials(cfg *config.Config, urlEnv string) func(context.Context, *pgx.ConnConfig) error {
+	return func(_ context.Context, connConfig *pgx.ConnConfig) error {
+		url, ok := cfg.Secrets.Lookup(urlEnv)
+		if !ok {
+			return nil
+		}
+		current, err := pgx.ParseConfig(url)
+		if err != nil {
+			return fmt.Errorf("invalid %s from secret manager: %w", urlEnv, err)
+		}
+		connConfig.User = current.User
+		connConfig.Password = current.Password
+		return nil
+	}
+}
+
+// OpenReplica opens the optional read replica with the primary's pool
+// settings. It returns nil (reads stay on the primary) when no replica is
+// configured or it cannot be reached, so a replica outage never blocks startup.
+func OpenReplica(cfg *config.Config, gormLogger logger.Interface) *gorm.DB {
+	if cfg.DatabaseReplicaURL == "" || cfg.DatabaseDriver == "sqlite" {
+		return nil
+	}
+
+	dialector, err := postgresDialector(cfg, cfg.DatabaseReplicaURL, "DATABASE_REPLICA_URL")
+	if err != nil {
+		log.Printf("Invalid DATABASE_REPLICA_URL, serving reads from primary: %v", err)
+		return nil
+	}
+
+	replica, err := gorm.Open(dialector, gormConfig(cfg, gormLogger))
+	if err != nil {
+		log.Printf("Database replica unreachable, serving reads from primary: %v", err)
+		return nil
+	}
+
+	sqlDB, err := replica.DB()
+	if err != nil {
+		log.Printf("Database replica unusable, serving reads from primary: %v", err)
+		return nil
+	}
+
+	connMaxLifetime, err := time.ParseDuration(cfg.DBConnMaxLifetime)
+	if err != nil {
+		connMaxLifetime = 5 * time.Minute
+	}
+	sqlDB.SetMaxOpenConns(cfg.DBMaxOpenConns)
+	sqlDB.SetMaxIdleConns(cfg.DBMaxIdleConns)
+	sqlDB.SetConnMaxLifetime(connMaxLifetime)
+
+	log.Println("Using database read replica for balance and history reads")
+	return replica
+}
+
+// OpenPgxPools opens the native pgx pools used by the hot-path repositories,
+// sized like the GORM pool. It returns nil pools (GORM keeps serving the hot
+// path) when the driver is not Postgres or the pool cannot be opened; the
+// replica pool is nil unless withReplica is set and the replica is reachable.
+func OpenPgxPools(cfg *config.Config, withReplica bool) (*pgxpool.Pool, *pgxpool.Pool) {
+	if cfg.DatabaseDriver != "postgres" && cfg.DatabaseDriver != "" {
+		log.Printf("WARNING: DB_HOT_PATH_DRIVER=pgx requires DB_DRIVER=postgres, using GORM")
+		return nil, nil
+	}
+
+	pool, err := newPgxPool(cfg, cfg.DatabaseURL, "DATABASE_URL")
+	if err != nil {
+		log.Printf("Failed to open pgx pool, using GORM for the hot path: %v", err)
+		return nil, nil
+	}
+	if !withReplica {
+		return pool, nil
+	}
+
+	replica, err := newPgxPool(cfg, cfg.DatabaseReplicaURL, "DATABASE_REPLICA_URL")
+	if err != nil {
+		log.Printf("Failed to open pgx replica pool, serving pgx reads from primary: %v", err)
+		return pool, nil
+	}
+	return pool, replica
+}
+
+func newPgxPool(cfg *config.Config, url string, urlEnv string) (*pgxpool.Pool, error) {
+	poolConfig, err := pgxpool.ParseConfig(url)
+	if err != nil {
+		return nil, err
+	}
+	poolConfig.BeforeConnect = rotatedCredentials(cfg, urlEnv)
+	if cfg.DBMaxOpenConns > 0 {
+		poolConfig.MaxConns = int32(cfg.DBMaxOpenConns)
+	}
+	if connMaxLifetime, err := time.ParseDuration(cfg.DBConnMaxLifetime); err == nil {
+		poolConfig.MaxConnLifetime = connMaxLifetime
+	}
+	applyStatementCache(cfg, poolConfig.ConnConfig)
+
+	pool, err := pgxpool.NewWithConfig(context.Background(), poolConfig)
+	if err != nil {
+		return nil, err
+	}
+	if err := pool.Ping(context.Background()); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	return pool, nil
+}
+
+// sqliteDSN adds the connection options the service relies on: writers wait
+// for the lock instead of failing with SQLITE_BUSY, and every transaction takes
+// the write lock up front (BEGIN IMMEDIATE), standing in for the account locks
+func sqliteDSN(path string) string {
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	return path + separator + "_busy_timeout=5000&_journal_mode=WAL&_txlock=immediate"
+}
@@ -0,0 +1,100 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+
+	"sub-balance-demo/internal/config"
+
+	"github.com/jackc/pgx/v5"
+	"gorm.io/gorm/logger"
+)
+
+func TestSQLiteDSN(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"data.db", "data.db?_busy_timeout=5000&_journal_mode=WAL&_txlock=immediate"},
+		{"file:data.db?cache=shared", "file:data.db?cache=shared&_busy_timeout=5000&_journal_mode=WAL&_txlock=immediate"},
+	}
+	for _, tt := range tests {
+		if got := sqliteDSN(tt.path); got != tt.want {
+			t.Errorf("sqliteDSN(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestStatementCache(t *testing.T) {
+	tests := []struct {
+		mode             string
+		wantMode         pgx.QueryExecMode
+		serverStatements bool
+	}{
+		{"cache_statement", pgx.QueryExecModeCacheStatement, true},
+		{"CACHE_DESCRIBE", pgx.QueryExecModeCacheDescribe, true},
+		{"exec", pgx.QueryExecModeExec, false},
+		{"simple_protocol", pgx.QueryExecModeSimpleProtocol, false},
+		{"bogus", pgx.QueryExecModeCacheStatement, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			cfg := &config.Config{DBQueryExecMode: tt.mode, DBStatementCacheCapacity: 64}
+			connConfig := &pgx.ConnConfig{}
+			applyStatementCache(cfg, connConfig)
+			if connConfig.DefaultQueryExecMode != tt.wantMode {
+				t.Fatalf("exec mode = %v, want %v", connConfig.DefaultQueryExecMode, tt.wantMode)
+			}
+			if connConfig.StatementCacheCapacity != 64 || connConfig.DescriptionCacheCapacity != 64 {
+				t.Fatalf("cache capacity = %d/%d, want 64", connConfig.StatementCacheCapacity, connConfig.DescriptionCacheCapacity)
+			}
+			if tt.mode != "bogus" && usesServerStatements(cfg) != tt.serverStatements {
+				t.Fatalf("usesServerStatements = %v, want %v", !tt.serverStatements, tt.serverStatements)
+			}
+
+			// PrepareStmt GORM memakai statement server, jadi dimatikan tanpa itu
+			cfg.DBPrepareStatements = true
+			if got := gormConfig(cfg, logger.Discard).PrepareStmt; got != usesServerStatements(cfg) {
+				t.Fatalf("PrepareStmt = %v with %s", got, tt.mode)
+			}
+		})
+	}
+}
+
+func TestOpenSQLite(t *testing.T) {
+	cfg := &config.Config{
+		DatabaseDriver:               "sqlite",
+		SQLitePath:                   filepath.Join(t.TempDir(), "test.db"),
+		DBMaxOpenConns:               1,
+		DBConnMaxLifetime:            "5m",
+		SettlementSetBasedThreshold:  100,
+		StartupConnectMaxWait:        "1s",
+		StartupConnectInitialBackoff: "10ms",
+		StartupConnectMaxBackoff:     "10ms",
+	}
+	db, err := Open(cfg, logger.Discard)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	sqlDB, _ := db.DB()
+	t.Cleanup(func() { sqlDB.Close() })
+
+	if !db.Migrator().HasTable("sub_balances") {
+		t.Fatal("schema not migrated")
+	}
+	if cfg.SettlementSetBasedThreshold != 0 {
+		t.Fatalf("SettlementSetBasedThreshold = %d, want 0 on SQLite", cfg.SettlementSetBasedThreshold)
+	}
+	if OpenReplica(cfg, logger.Discard) != nil {
+		t.Fatal("OpenReplica returned a replica for SQLite")
+	}
+	if pool, replica := OpenPgxPools(cfg, true); pool != nil || replica != nil {
+		t.Fatal("OpenPgxPools returned pools for SQLite")
+	}
+}
+
+func TestOpenUnsupportedDriver(t *testing.T) {
+	if _, err := Open(&config.Config{DatabaseDriver: "mysql"}, logger.Discard); err == nil {
+		t.Fatal("Open succeeded with DB_DRIVER=mysql")
+	}
+}
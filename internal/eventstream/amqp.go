@@ -0,0 +1,147 @@
+package eventstream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// amqpDialTimeout bounds connecting to the broker
+const amqpDialTimeout = 10 * time.Second
+
+// AMQPPublisher publishes events to a RabbitMQ topic exchange in confirm
+// mode: Publish returns nil only once the broker has confirmed the message.
+// Routing keys are "<type>.<account_id>", so a queue can bind to
+// settlement.completed.# or to one account. The connection is opened on first
+// use and re-opened on the next Publish after the broker closed it; while the
+// broker is down Publish fails instead of buffering, and the caller retries.
+type AMQPPublisher struct {
+	url      string
+	exchange string
+
+	// mu serializes publishes: one channel, one confirm outstanding at a time
+	mu      sync.Mutex
+	conn    *amqp.Connection
+	channel *amqp.Channel
+}
+
+// NewAMQPPublisher creates a publisher; nothing is dialed until Publish
+func NewAMQPPublisher(url string, exchange string) *AMQPPublisher {
+	return &AMQPPublisher{url: url, exchange: exchange}
+}
+
+func (p *AMQPPublisher) Publish(ctx context.Context, event Event) error {
+	message, err := amqpMessage(event)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	channel, err := p.connect()
+	if err != nil {
+		return err
+	}
+	confirmation, err := channel.PublishWithDeferredConfirmWithContext(ctx, p.exchange, amqpRoutingKey(event), false, false, message)
+	if err != nil {
+		p.reset()
+		return fmt.Errorf("failed to publish %s to exchange %s: %w", event.Type, p.exchange, err)
+	}
+	acked, err := confirmation.WaitContext(ctx)
+	if err != nil {
+		// Tanpa confirm status pesan tidak diketahui; channel dibuka ulang
+		// supaya confirm yang datang terlambat tidak tertukar
+		p.reset()
+		return fmt.Errorf("no confirm for %s from exchange %s: %w", event.Type, p.exchange, err)
+	}
+	if !acked {
+		return fmt.Errorf("broker nacked %s on exchange %s", event.Type, p.exchange)
+	}
+	return nil
+}
+
+// connect returns the open channel, dialing and declaring the exchange again
+// when the connection or channel was closed
+func (p *AMQPPublisher) connect() (*amqp.Channel, error) {
+	if p.channel != nil && !p.channel.IsClosed() && !p.conn.IsClosed() {
+		return p.channel, nil
+	}
+	p.reset()
+
+	conn, err := amqp.DialConfig(p.url, amqp.Config{Dial: amqp.DefaultDial(amqpDialTimeout)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to RabbitMQ: %w", err)
+	}
+	channel, err := conn.Channel()
+	if err == nil {
+		err = channel.Confirm(false)
+	}
+	if err == nil {
+		err = channel.ExchangeDeclare(p.exchange, amqp.ExchangeTopic, true, false, false, false, nil)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to open RabbitMQ channel on exchange %s: %w", p.exchange, err)
+	}
+
+	closed := conn.NotifyClose(make(chan *amqp.Error, 1))
+	go func() {
+		if reason, ok := <-closed; ok && reason != nil {
+			log.Printf("RabbitMQ connection closed, reconnecting on next publish: %v", reason)
+		}
+	}()
+
+	p.conn = conn
+	p.channel = channel
+	return channel, nil
+}
+
+// reset drops the connection so the next Publish dials again
+func (p *AMQPPublisher) reset() {
+	if p.conn != nil {
+		if err := p.conn.Close(); err != nil && !errors.Is(err, amqp.ErrClosed) {
+			log.Printf("Failed to close RabbitMQ connection: %v", err)
+		}
+	}
+	p.conn = nil
+	p.channel = nil
+}
+
+// Close closes the connection to the broker
+func (p *AMQPPublisher) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.reset()
+}
+
+func amqpRoutingKey(event Event) string {
+	return event.Type + "." + event.AccountID
+}
+
+// amqpMessage encodes event as a persistent JSON message. event_id, when
+// set, is the message ID consumers dedupe on.
+func amqpMessage(event Event) (amqp.Publishing, error) {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+	event.ID = ""
+	body, err := json.Marshal(event)
+	if err != nil {
+		return amqp.Publishing{}, fmt.Errorf("failed to encode %s event: %w", event.Type, err)
+	}
+	return amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		MessageId:    event.EventID,
+		Type:         event.Type,
+		Timestamp:    event.Timestamp,
+		Body:         body,
+	}, nil
+}
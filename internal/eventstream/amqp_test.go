@@ -0,0 +1,125 @@
+package eventstream
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestAMQPMessage(t *testing.T) {
+	timestamp := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	event := Event{
+		ID:           "1-0",
+		EventID:      "42",
+		Type:         SettlementCompleted,
+		AccountID:    "ACC001",
+		Amount:       "-12.5",
+		Status:       "SETTLED",
+		SettlementID: "s-1",
+		Transactions: 3,
+		Balance:      "87.5",
+		Timestamp:    timestamp,
+	}
+	if got := amqpRoutingKey(event); got != "settlement.completed.ACC001" {
+		t.Fatalf("routing key = %q", got)
+	}
+
+	message, err := amqpMessage(event)
+	if err != nil {
+		t.Fatalf("amqpMessage: %v", err)
+	}
+	if message.MessageId != "42" || message.Type != SettlementCompleted || message.DeliveryMode != amqp.Persistent || !message.Timestamp.Equal(timestamp) {
+		t.Fatalf("message properties = %+v", message)
+	}
+
+	var body Event
+	if err := json.Unmarshal(message.Body, &body); err != nil {
+		t.Fatalf("body is not JSON: %v", err)
+	}
+	event.ID = "" // ID entry stream tidak ikut dikirim
+	if body != event {
+		t.Fatalf("body = %+v, want %+v", body, event)
+	}
+}
+
+func TestEventValuesRoundTrip(t *testing.T) {
+	event := Event{
+		EventID:      "42",
+		Type:         SettlementCompleted,
+		AccountID:    "ACC001",
+		Amount:       "10",
+		SettlementID: "s-1",
+		Transactions: 2,
+		Balance:      "110",
+		Timestamp:    time.Date(2026, 1, 2, 3, 4, 5, 6, time.UTC),
+	}
+	values := make(map[string]interface{})
+	for key, value := range event.values() {
+		values[key] = value
+	}
+
+	got, err := eventFromValues("1-0", values)
+	if err != nil {
+		t.Fatalf("eventFromValues: %v", err)
+	}
+	event.ID = "1-0"
+	if got != event {
+		t.Fatalf("round trip = %+v, want %+v", got, event)
+	}
+}
+
+// TestAMQPPublisherReconnects publishes to a real broker at TEST_RABBITMQ_URL,
+// drops the connection and publishes again
+func TestAMQPPublisherReconnects(t *testing.T) {
+	url := os.Getenv("TEST_RABBITMQ_URL")
+	if url == "" {
+		t.Skip("TEST_RABBITMQ_URL not set, skipping RabbitMQ test")
+	}
+	exchange := "sub-balance-test-" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	publisher := NewAMQPPublisher(url, exchange)
+	defer publisher.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Exchange dideklarasikan publisher saat connect, queue test diikat sesudahnya
+	if err := publisher.Publish(ctx, Event{Type: SettlementCompleted, AccountID: "warmup"}); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	conn, err := amqp.Dial(url)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	channel, err := conn.Channel()
+	if err != nil {
+		t.Fatalf("Channel: %v", err)
+	}
+	defer channel.ExchangeDelete(exchange, false, false)
+	queue, err := channel.QueueDeclare("", false, true, true, false, nil)
+	if err != nil {
+		t.Fatalf("QueueDeclare: %v", err)
+	}
+	if err := channel.QueueBind(queue.Name, "settlement.completed.#", exchange, false, nil); err != nil {
+		t.Fatalf("QueueBind: %v", err)
+	}
+
+	publisher.mu.Lock()
+	publisher.conn.Close() // seolah broker memutus koneksi
+	publisher.mu.Unlock()
+
+	if err := publisher.Publish(ctx, Event{EventID: "7", Type: SettlementCompleted, AccountID: "ACC001"}); err != nil {
+		t.Fatalf("Publish after the connection closed: %v", err)
+	}
+	delivery, ok, err := channel.Get(queue.Name, true)
+	if err != nil || !ok {
+		t.Fatalf("Get: ok=%v err=%v", ok, err)
+	}
+	if delivery.RoutingKey != "settlement.completed.ACC001" || delivery.MessageId != "7" {
+		t.Fatalf("delivery routing_key=%q message_id=%q", delivery.RoutingKey, delivery.MessageId)
+	}
+}
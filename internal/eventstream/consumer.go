@@ -0,0 +1,121 @@
+package eventstream
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Handler processes one event. Returning an error leaves the entry pending in
+// the consumer group so it is redelivered to this consumer on restart.
+type Handler func(ctx context.Context, event Event) error
+
+// Consumer reads the event stream as a member of a consumer group, so several
+// instances of a subscribing system share the work and each entry is handled once
+type Consumer struct {
+	client *redis.Client
+	stream string
+	group  string
+	name   string
+	count  int64
+	block  time.Duration
+}
+
+func NewConsumer(client *redis.Client, stream, group, name string) *Consumer {
+	return &Consumer{
+		client: client,
+		stream: stream,
+		group:  group,
+		name:   name,
+		count:  100,
+		block:  5 * time.Second,
+	}
+}
+
+// EnsureGroup creates the consumer group (and the stream) if it does not exist.
+// New groups start at the end of the stream.
+func (c *Consumer) EnsureGroup(ctx context.Context) error {
+	err := c.client.XGroupCreateMkStream(ctx, c.stream, c.group, "$").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return fmt.Errorf("failed to create consumer group %s: %w", c.group, err)
+	}
+	return nil
+}
+
+// Run first replays entries delivered to this consumer but never acknowledged,
+// then reads new entries until ctx is cancelled. Entries are acknowledged only
+// after handler succeeds.
+func (c *Consumer) Run(ctx context.Context, handler Handler) error {
+	if err := c.EnsureGroup(ctx); err != nil {
+		return err
+	}
+
+	// "0" membaca ulang pending entries milik consumer ini, ">" entry baru
+	start := "0"
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		streams, err := c.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    c.group,
+			Consumer: c.name,
+			Streams:  []string{c.stream, start},
+			Count:    c.count,
+			Block:    c.block,
+		}).Result()
+		if err == redis.Nil {
+			continue // Block timeout tanpa entry baru
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to read from stream %s: %w", c.stream, err)
+		}
+
+		lastID := ""
+		for _, stream := range streams {
+			for _, message := range stream.Messages {
+				lastID = message.ID
+				c.handle(ctx, handler, message)
+			}
+		}
+
+		// Pending backlog dibaca sekali per halaman; entry yang gagal lagi
+		// tetap pending sampai restart berikutnya
+		if start != ">" {
+			if lastID == "" {
+				start = ">"
+			} else {
+				start = lastID
+			}
+		}
+	}
+}
+
+func (c *Consumer) handle(ctx context.Context, handler Handler, message redis.XMessage) {
+	event, err := eventFromValues(message.ID, message.Values)
+	if err != nil {
+		// Entry rusak tidak akan pernah berhasil diproses, ack supaya tidak diulang terus
+		log.Printf("Dropping malformed event %s: %v", message.ID, err)
+		c.ack(ctx, message.ID)
+		return
+	}
+
+	if err := handler(ctx, event); err != nil {
+		log.Printf("Failed to handle event %s (%s): %v", message.ID, event.Type, err)
+		return
+	}
+	c.ack(ctx, message.ID)
+}
+
+func (c *Consumer) ack(ctx context.Context, id string) {
+	if err := c.client.XAck(ctx, c.stream, c.group, id).Err(); err != nil {
+		log.Printf("Failed to ack event %s: %v", id, err)
+	}
+}
@@ -0,0 +1,97 @@
+package eventstream
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Event types emitted by the transaction service
+const (
+	TransactionAccepted = "transaction.accepted"
+	TransactionSettled  = "transaction.settled"
+	TransactionRejected = "transaction.rejected"
+	AccountRepaired     = "account.repaired"
+	// SettlementCompleted is emitted once per account and settlement run,
+	// after its transaction.settled events
+	SettlementCompleted = "settlement.completed"
+)
+
+// Event is a single entry on the transaction event stream. Fields are stored
+// flat in the stream entry so consumers in other languages can read them
+// without decoding a nested payload.
+type Event struct {
+	ID            string    `json:"id,omitempty"`       // stream entry ID, set when reading
+	EventID       string    `json:"event_id,omitempty"` // stable across redeliveries, for consumer-side dedupe
+	Type          string    `json:"type"`
+	AccountID     string    `json:"account_id"`
+	TransactionID string    `json:"transaction_id,omitempty"`
+	TxType        string    `json:"tx_type,omitempty"`
+	Amount        string    `json:"amount,omitempty"`
+	Status        string    `json:"status,omitempty"`
+	SettlementID  string    `json:"settlement_id,omitempty"`
+	Message       string    `json:"message,omitempty"`
+	Timestamp     time.Time `json:"timestamp"`
+
+	// Settlement summary, set on settlement.completed: Amount is the delta
+	// applied, Balance the settled balance after it
+	Transactions int    `json:"transactions,omitempty"`
+	Balance      string `json:"balance,omitempty"`
+}
+
+func (e Event) values() map[string]interface{} {
+	return map[string]interface{}{
+		"event_id":       e.EventID,
+		"type":           e.Type,
+		"account_id":     e.AccountID,
+		"transaction_id": e.TransactionID,
+		"tx_type":        e.TxType,
+		"amount":         e.Amount,
+		"status":         e.Status,
+		"settlement_id":  e.SettlementID,
+		"message":        e.Message,
+		"timestamp":      e.Timestamp.UTC().Format(time.RFC3339Nano),
+		"transactions":   strconv.Itoa(e.Transactions),
+		"balance":        e.Balance,
+	}
+}
+
+func eventFromValues(id string, values map[string]interface{}) (Event, error) {
+	str := func(key string) string {
+		v, _ := values[key].(string)
+		return v
+	}
+
+	event := Event{
+		ID:            id,
+		EventID:       str("event_id"),
+		Type:          str("type"),
+		AccountID:     str("account_id"),
+		TransactionID: str("transaction_id"),
+		TxType:        str("tx_type"),
+		Amount:        str("amount"),
+		Status:        str("status"),
+		SettlementID:  str("settlement_id"),
+		Message:       str("message"),
+		Balance:       str("balance"),
+	}
+	if event.Type == "" {
+		return Event{}, fmt.Errorf("stream entry %s has no event type", id)
+	}
+
+	if transactions := str("transactions"); transactions != "" {
+		parsed, err := strconv.Atoi(transactions)
+		if err != nil {
+			return Event{}, fmt.Errorf("stream entry %s has invalid transactions: %w", id, err)
+		}
+		event.Transactions = parsed
+	}
+	if ts := str("timestamp"); ts != "" {
+		parsed, err := time.Parse(time.RFC3339Nano, ts)
+		if err != nil {
+			return Event{}, fmt.Errorf("stream entry %s has invalid timestamp: %w", id, err)
+		}
+		event.Timestamp = parsed
+	}
+	return event, nil
+}
@@ -0,0 +1,82 @@
+package eventstream
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// jetStreamPublishTimeout bounds waiting for the stream's ack when ctx has no
+// deadline
+const jetStreamPublishTimeout = 10 * time.Second
+
+// JetStreamPublisher publishes events to NATS JetStream, one subject per
+// event type: <prefix>.<type>, e.g. subbalance.events.transaction.settled.
+// Publish returns nil only once the stream has acknowledged the message, so
+// the outbox relay retries the rest (at-least-once). event_id is sent as
+// Nats-Msg-Id, which the stream dedupes within its duplicate window.
+type JetStreamPublisher struct {
+	js     jetstream.JetStream
+	prefix string
+}
+
+// NewJetStreamPublisher creates the stream, or updates it, to capture
+// <prefix>.> and returns a publisher to it. The connection reconnects on its
+// own; publishes while disconnected fail once ctx or the publish timeout ends.
+func NewJetStreamPublisher(ctx context.Context, nc *nats.Conn, stream string, prefix string) (*JetStreamPublisher, error) {
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open JetStream: %w", err)
+	}
+	_, err = js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:     stream,
+		Subjects: []string{prefix + ".>"},
+		Storage:  jetstream.FileStorage,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create JetStream stream %s: %w", stream, err)
+	}
+	return &JetStreamPublisher{js: js, prefix: prefix}, nil
+}
+
+func (p *JetStreamPublisher) Publish(ctx context.Context, event Event) error {
+	message, err := jetStreamMessage(p.prefix, event)
+	if err != nil {
+		return err
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, jetStreamPublishTimeout)
+		defer cancel()
+	}
+
+	_, err = p.js.PublishMsg(ctx, message)
+	if err != nil {
+		return fmt.Errorf("failed to publish %s to %s: %w", event.Type, message.Subject, err)
+	}
+	return nil
+}
+
+// jetStreamMessage encodes event as JSON on its type's subject
+func jetStreamMessage(prefix string, event Event) (*nats.Msg, error) {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+	event.ID = ""
+	body, err := json.Marshal(event)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s event: %w", event.Type, err)
+	}
+
+	message := nats.NewMsg(prefix + "." + event.Type)
+	message.Data = body
+	message.Header.Set("Content-Type", "application/json")
+	if event.EventID != "" {
+		message.Header.Set(jetstream.MsgIDHeader, event.EventID)
+	}
+	return message, nil
+}
@@ -0,0 +1,92 @@
+package eventstream
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestJetStreamMessage(t *testing.T) {
+	event := Event{ID: "1-0", EventID: "42", Type: TransactionSettled, AccountID: "ACC001", Amount: "10"}
+	message, err := jetStreamMessage("subbalance.events", event)
+	if err != nil {
+		t.Fatalf("jetStreamMessage: %v", err)
+	}
+	if message.Subject != "subbalance.events.transaction.settled" {
+		t.Fatalf("subject = %q", message.Subject)
+	}
+	if got := message.Header.Get(jetstream.MsgIDHeader); got != "42" {
+		t.Fatalf("%s = %q, want the event_id", jetstream.MsgIDHeader, got)
+	}
+
+	var body Event
+	if err := json.Unmarshal(message.Data, &body); err != nil {
+		t.Fatalf("body is not JSON: %v", err)
+	}
+	if body.ID != "" || body.EventID != "42" || body.Timestamp.IsZero() {
+		t.Fatalf("body = %+v", body)
+	}
+
+	// Event tanpa event_id (tanpa outbox) tidak didedupe oleh stream
+	message, _ = jetStreamMessage("subbalance.events", Event{Type: AccountRepaired, AccountID: "ACC001"})
+	if got := message.Header.Get(jetstream.MsgIDHeader); got != "" {
+		t.Fatalf("%s = %q, want none", jetstream.MsgIDHeader, got)
+	}
+}
+
+// TestJetStreamPublisherDedupes publishes to a real server at TEST_NATS_URL:
+// a redelivered event_id is stored once, each type on its own subject
+func TestJetStreamPublisherDedupes(t *testing.T) {
+	url := os.Getenv("TEST_NATS_URL")
+	if url == "" {
+		t.Skip("TEST_NATS_URL not set, skipping NATS test")
+	}
+	nc, err := nats.Connect(url)
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer nc.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 10)
+	stream := "SUBBALANCE_TEST_" + suffix
+	prefix := "subbalance.test" + suffix
+	publisher, err := NewJetStreamPublisher(ctx, nc, stream, prefix)
+	if err != nil {
+		t.Fatalf("NewJetStreamPublisher: %v", err)
+	}
+	defer publisher.js.DeleteStream(context.Background(), stream)
+
+	events := []Event{
+		{EventID: "1", Type: TransactionAccepted, AccountID: "ACC001"},
+		{EventID: "1", Type: TransactionAccepted, AccountID: "ACC001"}, // retry relay
+		{EventID: "2", Type: TransactionSettled, AccountID: "ACC001"},
+	}
+	for _, event := range events {
+		if err := publisher.Publish(ctx, event); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+
+	s, err := publisher.js.Stream(ctx, stream)
+	if err != nil {
+		t.Fatalf("Stream: %v", err)
+	}
+	info, err := s.Info(ctx, jetstream.WithSubjectFilter(prefix+".>"))
+	if err != nil {
+		t.Fatalf("Info: %v", err)
+	}
+	if info.State.Msgs != 2 {
+		t.Fatalf("stream holds %d messages, want 2", info.State.Msgs)
+	}
+	if info.State.Subjects[prefix+".transaction.accepted"] != 1 || info.State.Subjects[prefix+".transaction.settled"] != 1 {
+		t.Fatalf("messages per subject = %v", info.State.Subjects)
+	}
+}
@@ -0,0 +1,136 @@
+package eventstream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// kafkaPublishTimeout bounds waiting for the brokers' ack when ctx has no
+// deadline
+const kafkaPublishTimeout = 10 * time.Second
+
+// kafkaWriter is the part of *kafka.Writer the publisher uses
+type kafkaWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// KafkaPublisher publishes events to Kafka, keyed by account_id so every
+// event of an account lands on the same partition and keeps its order. Each
+// event type goes to its own topic from topics, or to defaultTopic. Publish
+// returns nil only once all in-sync replicas have acknowledged the write, so
+// the outbox relay retries the rest (at-least-once); consumers dedupe on the
+// event_id header.
+type KafkaPublisher struct {
+	defaultTopic string
+	topics       map[string]string
+	newWriter    func(topic string) kafkaWriter
+
+	mu      sync.Mutex
+	writers map[string]kafkaWriter
+}
+
+// NewKafkaPublisher returns a publisher to brokers. Writers are opened per
+// topic on first use and reconnect on their own.
+func NewKafkaPublisher(brokers []string, defaultTopic string, topics map[string]string) *KafkaPublisher {
+	return newKafkaPublisher(defaultTopic, topics, func(topic string) kafkaWriter {
+		return kafka.NewWriter(kafka.WriterConfig{
+			Brokers:      brokers,
+			Topic:        topic,
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: -1,
+			BatchTimeout: 10 * time.Millisecond,
+		})
+	})
+}
+
+func newKafkaPublisher(defaultTopic string, topics map[string]string, newWriter func(topic string) kafkaWriter) *KafkaPublisher {
+	return &KafkaPublisher{
+		defaultTopic: defaultTopic,
+		topics:       topics,
+		newWriter:    newWriter,
+		writers:      make(map[string]kafkaWriter),
+	}
+}
+
+func (p *KafkaPublisher) Publish(ctx context.Context, event Event) error {
+	topic := p.topic(event.Type)
+	message, err := kafkaMessage(event)
+	if err != nil {
+		return err
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, kafkaPublishTimeout)
+		defer cancel()
+	}
+
+	if err := p.writer(topic).WriteMessages(ctx, message); err != nil {
+		return fmt.Errorf("failed to publish %s to %s: %w", event.Type, topic, err)
+	}
+	return nil
+}
+
+// Close flushes and closes every writer
+func (p *KafkaPublisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var errs []error
+	for topic, w := range p.writers {
+		if err := w.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close Kafka writer for %s: %w", topic, err))
+		}
+		delete(p.writers, topic)
+	}
+	return errors.Join(errs...)
+}
+
+func (p *KafkaPublisher) topic(eventType string) string {
+	if topic, ok := p.topics[eventType]; ok && topic != "" {
+		return topic
+	}
+	return p.defaultTopic
+}
+
+func (p *KafkaPublisher) writer(topic string) kafkaWriter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	w, ok := p.writers[topic]
+	if !ok {
+		w = p.newWriter(topic)
+		p.writers[topic] = w
+	}
+	return w
+}
+
+// kafkaMessage encodes event as JSON, keyed by its account
+func kafkaMessage(event Event) (kafka.Message, error) {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+	event.ID = ""
+	body, err := json.Marshal(event)
+	if err != nil {
+		return kafka.Message{}, fmt.Errorf("failed to encode %s event: %w", event.Type, err)
+	}
+
+	headers := []kafka.Header{
+		{Key: "content-type", Value: []byte("application/json")},
+		{Key: "event_type", Value: []byte(event.Type)},
+	}
+	if event.EventID != "" {
+		headers = append(headers, kafka.Header{Key: "event_id", Value: []byte(event.EventID)})
+	}
+	return kafka.Message{
+		Key:     []byte(event.AccountID),
+		Value:   body,
+		Headers: headers,
+		Time:    event.Timestamp,
+	}, nil
+}
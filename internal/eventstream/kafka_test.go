@@ -0,0 +1,135 @@
+package eventstream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+)
+
+type fakeKafkaWriter struct {
+	topic    string
+	err      error
+	messages []kafka.Message
+	closed   bool
+}
+
+func (w *fakeKafkaWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.messages = append(w.messages, msgs...)
+	return nil
+}
+
+func (w *fakeKafkaWriter) Close() error {
+	w.closed = true
+	return nil
+}
+
+func newFakeKafkaPublisher(topics map[string]string) (*KafkaPublisher, map[string]*fakeKafkaWriter) {
+	writers := make(map[string]*fakeKafkaWriter)
+	publisher := newKafkaPublisher("subbalance.events", topics, func(topic string) kafkaWriter {
+		w := &fakeKafkaWriter{topic: topic}
+		writers[topic] = w
+		return w
+	})
+	return publisher, writers
+}
+
+func header(message kafka.Message, key string) string {
+	for _, h := range message.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func TestKafkaMessage(t *testing.T) {
+	event := Event{ID: "1-0", EventID: "42", Type: TransactionSettled, AccountID: "ACC001", Amount: "10"}
+	message, err := kafkaMessage(event)
+	if err != nil {
+		t.Fatalf("kafkaMessage: %v", err)
+	}
+	if string(message.Key) != "ACC001" {
+		t.Fatalf("key = %q, want the account_id", message.Key)
+	}
+	if header(message, "event_id") != "42" || header(message, "event_type") != TransactionSettled {
+		t.Fatalf("headers = %+v", message.Headers)
+	}
+
+	var body Event
+	if err := json.Unmarshal(message.Value, &body); err != nil {
+		t.Fatalf("body is not JSON: %v", err)
+	}
+	if body.ID != "" || body.EventID != "42" || body.Timestamp.IsZero() {
+		t.Fatalf("body = %+v", body)
+	}
+
+	// Event tanpa event_id (tanpa outbox) tidak punya header dedupe
+	message, _ = kafkaMessage(Event{Type: TransactionRejected, AccountID: "ACC001"})
+	if got := header(message, "event_id"); got != "" {
+		t.Fatalf("event_id header = %q, want none", got)
+	}
+}
+
+func TestKafkaPublisherRoutesByType(t *testing.T) {
+	tests := []struct {
+		name      string
+		eventType string
+		wantTopic string
+	}{
+		{"per-type topic", TransactionSettled, "ledger.settled"},
+		{"default topic", TransactionAccepted, "subbalance.events"},
+		{"empty override", AccountRepaired, "subbalance.events"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			publisher, writers := newFakeKafkaPublisher(map[string]string{TransactionSettled: "ledger.settled", AccountRepaired: ""})
+			if err := publisher.Publish(context.Background(), Event{EventID: "1", Type: tt.eventType, AccountID: "ACC001"}); err != nil {
+				t.Fatalf("Publish: %v", err)
+			}
+			if len(writers) != 1 || writers[tt.wantTopic] == nil || len(writers[tt.wantTopic].messages) != 1 {
+				t.Fatalf("writers = %v, want one message on %s", writers, tt.wantTopic)
+			}
+		})
+	}
+}
+
+func TestKafkaPublisherKeepsOneWriterPerTopic(t *testing.T) {
+	publisher, writers := newFakeKafkaPublisher(nil)
+	ctx := context.Background()
+	for _, account := range []string{"ACC001", "ACC002", "ACC001"} {
+		if err := publisher.Publish(ctx, Event{Type: TransactionAccepted, AccountID: account}); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+	w := writers["subbalance.events"]
+	if len(writers) != 1 || len(w.messages) != 3 {
+		t.Fatalf("writers = %v", writers)
+	}
+	if string(w.messages[0].Key) != string(w.messages[2].Key) || string(w.messages[0].Key) == string(w.messages[1].Key) {
+		t.Fatalf("keys = %q %q %q, want one key per account", w.messages[0].Key, w.messages[1].Key, w.messages[2].Key)
+	}
+
+	if err := publisher.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if !w.closed {
+		t.Fatal("writer not closed")
+	}
+}
+
+func TestKafkaPublisherReturnsWriteError(t *testing.T) {
+	publisher := newKafkaPublisher("subbalance.events", nil, func(topic string) kafkaWriter {
+		return &fakeKafkaWriter{topic: topic, err: errors.New("not enough replicas")}
+	})
+	err := publisher.Publish(context.Background(), Event{EventID: "1", Type: TransactionSettled, AccountID: "ACC001"})
+	if err == nil || !strings.Contains(err.Error(), "not enough replicas") {
+		t.Fatalf("Publish = %v, want the write error so the outbox retries", err)
+	}
+}
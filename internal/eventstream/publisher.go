@@ -0,0 +1,50 @@
+package eventstream
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Publisher delivers events to a transport. Publish returns nil only once the
+// transport has accepted the event, so the outbox relay can retry the rest
+// (at-least-once); consumers dedupe on event_id. Implementations must be safe
+// for concurrent use.
+type Publisher interface {
+	Publish(ctx context.Context, event Event) error
+}
+
+// StreamPublisher appends events to a Redis Stream. A nil *StreamPublisher is
+// a no-op.
+type StreamPublisher struct {
+	client *redis.Client
+	stream string
+	maxLen int64
+}
+
+// NewStreamPublisher creates a publisher; maxLen caps the stream length
+// (approximately, via MAXLEN ~) so it cannot grow without bound
+func NewStreamPublisher(client *redis.Client, stream string, maxLen int64) *StreamPublisher {
+	return &StreamPublisher{
+		client: client,
+		stream: stream,
+		maxLen: maxLen,
+	}
+}
+
+func (p *StreamPublisher) Publish(ctx context.Context, event Event) error {
+	if p == nil {
+		return nil
+	}
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+
+	return p.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: p.stream,
+		MaxLen: p.maxLen,
+		Approx: true,
+		Values: event.values(),
+	}).Err()
+}
@@ -0,0 +1,231 @@
+// Package grpcapi serves the gRPC API for internal systems. WatchBalance
+// streams an account's balance as it changes, so risk systems do not poll
+// GET /api/v1/balance. Streams are driven by the balance invalidations every
+// instance publishes, so an update reaches a stream whichever instance
+// changed the balance.
+package grpcapi
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+
+	"sub-balance-demo/internal/auth"
+	"sub-balance-demo/internal/grpcapi/subbalancev1"
+	"sub-balance-demo/internal/logmask"
+	"sub-balance-demo/internal/service"
+	"sub-balance-demo/internal/tenant"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// reasonSnapshot marks the first update of a stream
+const reasonSnapshot = "snapshot"
+
+// Options configure BalanceServer
+type Options struct {
+	// Authenticator checks the same credentials as the REST API, sent as
+	// metadata; nil lets every call through
+	Authenticator *auth.Authenticator
+	// TenantHeader is the metadata key naming the tenant; empty when
+	// multi-tenancy is disabled
+	TenantHeader string
+}
+
+// BalanceServer implements subbalancev1.BalanceServiceServer
+type BalanceServer struct {
+	subbalancev1.UnimplementedBalanceServiceServer
+
+	ctx          context.Context // dibatalkan saat shutdown, menutup semua stream
+	transactions service.TransactionService
+	watchers     *service.BalanceWatchers
+	options      Options
+}
+
+// NewBalanceServer creates the server; open streams end when ctx is done so
+// a graceful stop does not wait for clients to hang up
+func NewBalanceServer(ctx context.Context, transactions service.TransactionService, watchers *service.BalanceWatchers, options Options) *BalanceServer {
+	return &BalanceServer{
+		ctx:          ctx,
+		transactions: transactions,
+		watchers:     watchers,
+		options:      options,
+	}
+}
+
+// Register adds the balance service to a new gRPC server; creds may be nil to
+// serve without TLS
+func (s *BalanceServer) Register(creds credentials.TransportCredentials) *grpc.Server {
+	var options []grpc.ServerOption
+	if creds != nil {
+		options = append(options, grpc.Creds(creds))
+	}
+	server := grpc.NewServer(options...)
+	subbalancev1.RegisterBalanceServiceServer(server, s)
+	return server
+}
+
+func (s *BalanceServer) WatchBalance(req *subbalancev1.WatchBalanceRequest, stream grpc.ServerStreamingServer[subbalancev1.BalanceUpdate]) error {
+	accountID := req.GetAccountId()
+	if accountID == "" {
+		return status.Error(codes.InvalidArgument, "account_id is required")
+	}
+	ctx, err := s.authorize(stream.Context(), subbalancev1.BalanceService_WatchBalance_FullMethodName, accountID)
+	if err != nil {
+		return err
+	}
+
+	// Watch dulu, baru snapshot, supaya perubahan di antaranya tidak hilang
+	watch, err := s.watchers.Watch(ctx, accountID)
+	if errors.Is(err, service.ErrTooManyWatchers) {
+		return status.Error(codes.ResourceExhausted, "Too many balance streams on this instance, retry another")
+	}
+	if err != nil {
+		return status.Error(codes.Internal, "Failed to watch balance")
+	}
+	defer watch.Close()
+
+	if err := s.send(ctx, stream, accountID, reasonSnapshot); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-watch.Changed():
+			// Send memblokir selama flow control window client penuh; perubahan
+			// selama itu digabung menjadi satu update
+			if err := s.send(ctx, stream, accountID, watch.Reason()); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-s.ctx.Done():
+			return status.Error(codes.Unavailable, "Server shutting down")
+		}
+	}
+}
+
+// send reads the balance and pending transactions of accountID and sends them
+func (s *BalanceServer) send(ctx context.Context, stream grpc.ServerStreamingServer[subbalancev1.BalanceUpdate], accountID string, reason string) error {
+	balance, err := s.transactions.GetBalance(ctx, accountID)
+	if err != nil {
+		return status.Error(codes.NotFound, "Account not found")
+	}
+	pending, err := s.transactions.GetPendingTransactions(ctx, accountID)
+	if err != nil {
+		log.Printf("Failed to read pending transactions of account %s for balance stream: %v", logmask.Account(accountID), err)
+		return status.Error(codes.Internal, "Failed to get pending transactions")
+	}
+
+	return stream.Send(&subbalancev1.BalanceUpdate{
+		AccountId:        balance.AccountID,
+		SettledBalance:   balance.SettledBalance.String(),
+		PendingDebit:     balance.PendingDebit.String(),
+		PendingCredit:    balance.PendingCredit.String(),
+		AvailableBalance: balance.AvailableBalance.String(),
+		PendingCount:     int32(pending.Count),
+		PendingTotal:     pending.Total.String(),
+		Reason:           reason,
+		LastUpdated:      timestamppb.New(balance.LastUpdated),
+	})
+}
+
+// authorize authenticates the call like the REST API authenticates
+// GET /api/v1/balance/:account_id: a read-only role that may access
+// accountID, and the tenant when multi-tenancy is enabled. It returns the
+// stream context bound to the caller and tenant.
+func (s *BalanceServer) authorize(ctx context.Context, method string, accountID string) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	header := func(name string) string {
+		if values := md.Get(strings.ToLower(name)); len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+
+	var tenantID string
+	if s.options.TenantHeader != "" {
+		tenantID = header(s.options.TenantHeader)
+		if tenantID != "" && tenant.Validate(tenantID) != nil {
+			return nil, status.Error(codes.InvalidArgument, "Invalid tenant")
+		}
+	}
+
+	if s.options.Authenticator == nil {
+		return s.bindTenant(ctx, tenantID)
+	}
+	ip, state := callPeer(ctx)
+	principal, err := s.options.Authenticator.AuthenticateCall(ctx, ip, method, header, state)
+	var callErr *auth.CallError
+	if errors.As(err, &callErr) {
+		switch {
+		case callErr.RetryAfter > 0:
+			_ = grpc.SetHeader(ctx, metadata.Pairs("retry-after", strconv.Itoa(int(math.Ceil(callErr.RetryAfter.Seconds())))))
+			return nil, status.Error(codes.ResourceExhausted, callErr.Message)
+		case callErr.Reason == auth.FailureIntrospectionUnavailable:
+			return nil, status.Error(codes.Unavailable, callErr.Message)
+		default:
+			return nil, status.Error(codes.Unauthenticated, callErr.Message)
+		}
+	}
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "Invalid credentials")
+	}
+
+	if !principal.HasRole(auth.RoleReadOnly) {
+		return nil, status.Error(codes.PermissionDenied, "Role "+auth.RoleReadOnly+" required")
+	}
+	if !principal.CanAccessAccount(accountID) {
+		return nil, status.Error(codes.NotFound, "Account not found")
+	}
+	if s.options.TenantHeader != "" {
+		// Tenant dari credential, seperti TenantResolver di REST API
+		tenantID, err = principal.ResolveTenant(tenantID)
+		if err != nil {
+			return nil, status.Error(codes.PermissionDenied, "Tenant not permitted for this credential")
+		}
+	}
+	ctx, err = s.bindTenant(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	return auth.WithPrincipal(ctx, principal), nil
+}
+
+// bindTenant binds ctx to tenantID when multi-tenancy is enabled
+func (s *BalanceServer) bindTenant(ctx context.Context, tenantID string) (context.Context, error) {
+	if s.options.TenantHeader == "" {
+		return ctx, nil
+	}
+	if tenantID == "" {
+		return nil, status.Error(codes.Unauthenticated, "Missing "+s.options.TenantHeader+" metadata")
+	}
+	return tenant.WithID(ctx, tenantID), nil
+}
+
+// callPeer returns the client IP and, over TLS, the connection state of the
+// call in ctx
+func callPeer(ctx context.Context) (string, *tls.ConnectionState) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "", nil
+	}
+	ip := p.Addr.String()
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+		return ip, &info.State
+	}
+	return ip, nil
+}
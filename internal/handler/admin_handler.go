@@ -0,0 +1,732 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"sub-balance-demo/internal/auth"
+	"sub-balance-demo/internal/pagination"
+	"sub-balance-demo/internal/repository"
+	"sub-balance-demo/internal/service"
+	"sub-balance-demo/internal/tenant"
+
+	"github.com/labstack/echo/v4"
+)
+
+type AdminHandler struct {
+	transactionService    service.TransactionService
+	reconciliationService *service.ReconciliationService
+	quarantineService     *service.QuarantineService
+	consistencyService    *service.DataConsistencyService
+	retentionService      *service.RetentionService
+	snapshotService       *service.BalanceSnapshotService
+	balanceAudit          *service.BalanceAuditTrail
+	adminAudit            *service.AdminAuditLog
+	domainEvents          *service.DomainEventLog
+	rateLimiter           *service.RateLimiter
+	adminLockout          *auth.AdminLockout
+	authFailures          *auth.FailureTracker
+	webhooks              *service.WebhookService
+}
+
+func NewAdminHandler(
+	transactionService service.TransactionService,
+	reconciliationService *service.ReconciliationService,
+	quarantineService *service.QuarantineService,
+	consistencyService *service.DataConsistencyService,
+	retentionService *service.RetentionService,
+	snapshotService *service.BalanceSnapshotService,
+	balanceAudit *service.BalanceAuditTrail,
+	adminAudit *service.AdminAuditLog,
+	domainEvents *service.DomainEventLog,
+	rateLimiter *service.RateLimiter,
+	adminLockout *auth.AdminLockout,
+	authFailures *auth.FailureTracker,
+	webhooks *service.WebhookService,
+) *AdminHandler {
+	return &AdminHandler{
+		transactionService:    transactionService,
+		reconciliationService: reconciliationService,
+		quarantineService:     quarantineService,
+		consistencyService:    consistencyService,
+		retentionService:      retentionService,
+		snapshotService:       snapshotService,
+		balanceAudit:          balanceAudit,
+		adminAudit:            adminAudit,
+		domainEvents:          domainEvents,
+		rateLimiter:           rateLimiter,
+		adminLockout:          adminLockout,
+		authFailures:          authFailures,
+		webhooks:              webhooks,
+	}
+}
+
+func (h *AdminHandler) GetReconciliation(c echo.Context) error {
+	filter := repository.ReconciliationFilter{
+		SettlementID: c.QueryParam("settlement_id"),
+		AccountID:    c.QueryParam("account_id"),
+		Cursor:       c.QueryParam("cursor"),
+	}
+	filter.DiscrepanciesOnly, _ = strconv.ParseBool(c.QueryParam("discrepancies_only"))
+	filter.Limit, _ = strconv.Atoi(c.QueryParam("limit"))
+
+	page, err := h.reconciliationService.List(c.Request().Context(), filter)
+	if errors.Is(err, pagination.ErrInvalidCursor) {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid cursor",
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to get reconciliation records",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"count":       len(page.Items),
+		"items":       page.Items,
+		"next_cursor": page.NextCursor,
+	})
+}
+
+func (h *AdminHandler) GetSettlementAudit(c echo.Context) error {
+	filter := repository.SettlementAuditFilter{
+		SettlementID: c.QueryParam("settlement_id"),
+		AccountID:    c.QueryParam("account_id"),
+		Cursor:       c.QueryParam("cursor"),
+	}
+	filter.Limit, _ = strconv.Atoi(c.QueryParam("limit"))
+
+	page, err := h.transactionService.GetSettlementAuditLogs(c.Request().Context(), filter)
+	if errors.Is(err, pagination.ErrInvalidCursor) {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid cursor",
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to get settlement audit logs",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"count":       len(page.Items),
+		"items":       page.Items,
+		"next_cursor": page.NextCursor,
+	})
+}
+
+// GetBalanceAudit lists account_balances changes, newest first, filtered by
+// account_id and source
+func (h *AdminHandler) GetBalanceAudit(c echo.Context) error {
+	filter := repository.BalanceAuditFilter{
+		AccountID: c.QueryParam("account_id"),
+		Source:    c.QueryParam("source"),
+		Cursor:    c.QueryParam("cursor"),
+	}
+	filter.Limit, _ = strconv.Atoi(c.QueryParam("limit"))
+
+	page, err := h.balanceAudit.List(c.Request().Context(), filter)
+	if errors.Is(err, pagination.ErrInvalidCursor) {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid cursor",
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to get balance audit trail",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"count":       len(page.Items),
+		"items":       page.Items,
+		"next_cursor": page.NextCursor,
+	})
+}
+
+// GetAdminAuditLog lists admin and test-mode calls, newest first, filtered by
+// actor, route, method and outcome
+func (h *AdminHandler) GetAdminAuditLog(c echo.Context) error {
+	filter := repository.AdminAuditFilter{
+		Actor:   c.QueryParam("actor"),
+		Route:   c.QueryParam("route"),
+		Method:  c.QueryParam("method"),
+		Outcome: c.QueryParam("outcome"),
+		Cursor:  c.QueryParam("cursor"),
+	}
+	filter.Limit, _ = strconv.Atoi(c.QueryParam("limit"))
+
+	page, err := h.adminAudit.List(c.Request().Context(), filter)
+	if errors.Is(err, pagination.ErrInvalidCursor) {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid cursor",
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to get admin audit log",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"count":       len(page.Items),
+		"items":       page.Items,
+		"next_cursor": page.NextCursor,
+	})
+}
+
+// GetDomainEvents lists domain events, newest first, filtered by account_id,
+// type and from (RFC3339, inclusive)
+func (h *AdminHandler) GetDomainEvents(c echo.Context) error {
+	if !h.domainEvents.Enabled() {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Domain event log is disabled",
+		})
+	}
+
+	filter := repository.DomainEventFilter{
+		AccountID: c.QueryParam("account_id"),
+		Type:      c.QueryParam("type"),
+		Cursor:    c.QueryParam("cursor"),
+	}
+	filter.Limit, _ = strconv.Atoi(c.QueryParam("limit"))
+
+	if from := c.QueryParam("from"); from != "" {
+		var err error
+		filter.From, err = time.Parse(time.RFC3339, from)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Invalid from, expected RFC3339 timestamp",
+			})
+		}
+	}
+
+	page, err := h.domainEvents.List(c.Request().Context(), filter)
+	if errors.Is(err, pagination.ErrInvalidCursor) {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid cursor",
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to get domain events",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"count":       len(page.Items),
+		"items":       page.Items,
+		"next_cursor": page.NextCursor,
+	})
+}
+
+func (h *AdminHandler) ListQuarantine(c echo.Context) error {
+	accounts, err := h.quarantineService.List(c.Request().Context())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to get quarantined accounts",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"count": len(accounts),
+		"items": accounts,
+	})
+}
+
+func (h *AdminHandler) ReleaseQuarantine(c echo.Context) error {
+	accountID := c.Param("account_id")
+	if accountID == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Account ID is required",
+		})
+	}
+
+	// Account ID hanya unik per tenant; tanpa query tenant = tenant default
+	tenantID := c.QueryParam("tenant")
+	if tenantID != "" && tenant.Validate(tenantID) != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid tenant",
+		})
+	}
+
+	released, err := h.quarantineService.Release(tenant.WithID(c.Request().Context(), tenantID), accountID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to release account",
+		})
+	}
+	if !released {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Account is not quarantined",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"success":    true,
+		"message":    "Account released from quarantine",
+		"account_id": accountID,
+	})
+}
+
+// ListPendingCounters returns one SCAN page of Redis pending counters with the
+// DB pending sums next to them; follow next_cursor until it is 0. The tenant
+// query parameter selects a tenant's counters (default tenant otherwise).
+// ListRateLimits lists the per-identity rate limit overrides
+func (h *AdminHandler) ListRateLimits(c echo.Context) error {
+	limits, err := h.rateLimiter.List(c.Request().Context())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to get rate limits",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"count": len(limits),
+		"items": limits,
+	})
+}
+
+// SetRateLimit creates or replaces the rate limit override of an identity
+// (apikey:<name>, a JWT subject, or account:<account_id>)
+func (h *AdminHandler) SetRateLimit(c echo.Context) error {
+	var req struct {
+		Requests      int `json:"requests"`
+		WindowSeconds int `json:"window_seconds"`
+		Burst         int `json:"burst"`
+	}
+	if err := bindJSON(c, &req); err != nil {
+		return invalidBody(c, "Invalid request format", err)
+	}
+
+	limit := &repository.RateLimit{
+		Identity:      c.Param("identity"),
+		Requests:      req.Requests,
+		WindowSeconds: req.WindowSeconds,
+		Burst:         req.Burst,
+	}
+	err := h.rateLimiter.Set(c.Request().Context(), limit)
+	if errors.Is(err, service.ErrInvalidRateLimit) || errors.Is(err, service.ErrRateLimitNeedsAuth) {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to set rate limit",
+		})
+	}
+
+	return c.JSON(http.StatusOK, limit)
+}
+
+// DeleteRateLimit removes an override; the identity gets the default limit
+func (h *AdminHandler) DeleteRateLimit(c echo.Context) error {
+	identity := c.Param("identity")
+	deleted, err := h.rateLimiter.Delete(c.Request().Context(), identity)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to delete rate limit",
+		})
+	}
+	if !deleted {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "No rate limit override for identity",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"success":  true,
+		"message":  "Rate limit override removed",
+		"identity": identity,
+	})
+}
+
+// ListLockouts lists the IPs locked out of the admin endpoints
+func (h *AdminHandler) ListLockouts(c echo.Context) error {
+	lockouts, err := h.adminLockout.List(c.Request().Context())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to list lockouts",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"count": len(lockouts),
+		"items": lockouts,
+	})
+}
+
+// Unlock lifts the admin lockout of an IP and its authentication block on
+// this instance (other instances' blocks expire on their own)
+func (h *AdminHandler) Unlock(c echo.Context) error {
+	ip := c.Param("ip")
+	err := h.adminLockout.Unlock(c.Request().Context(), ip)
+	unblocked := h.authFailures.Unblock(ip)
+	if err != nil && !errors.Is(err, auth.ErrLockoutNotFound) {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to lift lockout",
+		})
+	}
+	if err != nil && !unblocked {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "IP is not locked out",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": "Lockout lifted",
+		"ip":      ip,
+	})
+}
+
+func (h *AdminHandler) ListPendingCounters(c echo.Context) error {
+	cursor, _ := strconv.ParseUint(c.QueryParam("cursor"), 10, 64)
+	count, _ := strconv.ParseInt(c.QueryParam("count"), 10, 64)
+	if count <= 0 || count > 1000 {
+		count = 100
+	}
+	driftedOnly, _ := strconv.ParseBool(c.QueryParam("drifted_only"))
+
+	// Counter tenant lain ada di namespace Redis sendiri; sum database juga
+	// dibatasi ke tenant itu karena account ID hanya unik per tenant
+	tenantID := c.QueryParam("tenant")
+	if tenantID != "" && tenant.Validate(tenantID) != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid tenant",
+		})
+	}
+	ctx := tenant.WithID(c.Request().Context(), tenantID)
+
+	inspection, err := h.consistencyService.InspectCounters(ctx, cursor, count)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to inspect Redis counters",
+		})
+	}
+
+	items := inspection.Items
+	if driftedOnly {
+		items = make([]service.CounterReport, 0, len(inspection.Items))
+		for _, item := range inspection.Items {
+			if item.Drifted {
+				items = append(items, item)
+			}
+		}
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"next_cursor": inspection.NextCursor,
+		"count":       len(items),
+		"items":       items,
+	})
+}
+
+func (h *AdminHandler) GetRetentionPolicies(c echo.Context) error {
+	policies := h.retentionService.Policies()
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"count": len(policies),
+		"items": policies,
+	})
+}
+
+// PurgeRetention applies the retention policy now, to the table given in the
+// table query parameter or to every covered table when it is empty
+func (h *AdminHandler) PurgeRetention(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var results []service.RetentionResult
+	var err error
+	if table := c.QueryParam("table"); table != "" {
+		var result service.RetentionResult
+		result, err = h.retentionService.Purge(ctx, table)
+		results = []service.RetentionResult{result}
+	} else {
+		results, err = h.retentionService.PurgeAll(ctx)
+	}
+
+	switch {
+	case errors.Is(err, service.ErrUnknownRetentionTable):
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	case errors.Is(err, service.ErrRetentionRunning):
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error": "Retention purge already running",
+		})
+	case err != nil:
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to apply retention policy",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+		"items":   results,
+	})
+}
+
+// GetBalanceHistory lists daily balance snapshots, newest first. from and to
+// are inclusive YYYY-MM-DD dates; account_id narrows it to one account.
+func (h *AdminHandler) GetBalanceHistory(c echo.Context) error {
+	filter := repository.BalanceSnapshotFilter{
+		AccountID: c.QueryParam("account_id"),
+		Cursor:    c.QueryParam("cursor"),
+	}
+	filter.Limit, _ = strconv.Atoi(c.QueryParam("limit"))
+
+	var err error
+	if from := c.QueryParam("from"); from != "" {
+		filter.From, err = time.Parse("2006-01-02", from)
+	}
+	if to := c.QueryParam("to"); err == nil && to != "" {
+		filter.To, err = time.Parse("2006-01-02", to)
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid date, expected YYYY-MM-DD",
+		})
+	}
+
+	page, err := h.snapshotService.List(c.Request().Context(), filter)
+	if errors.Is(err, pagination.ErrInvalidCursor) {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid cursor",
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to get balance history",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"count":       len(page.Items),
+		"items":       page.Items,
+		"next_cursor": page.NextCursor,
+	})
+}
+
+// webhooksDisabled answers the webhook endpoints when ENABLE_WEBHOOKS is off
+func webhooksDisabled(c echo.Context) error {
+	return c.JSON(http.StatusNotFound, map[string]string{
+		"error": "Webhooks are disabled",
+	})
+}
+
+// ListWebhooks lists the webhook subscriptions; secrets are not included
+func (h *AdminHandler) ListWebhooks(c echo.Context) error {
+	if !h.webhooks.Enabled() {
+		return webhooksDisabled(c)
+	}
+
+	subscriptions, err := h.webhooks.ListSubscriptions(c.Request().Context())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to get webhook subscriptions",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"count": len(subscriptions),
+		"items": subscriptions,
+	})
+}
+
+// CreateWebhook subscribes a URL to the given event types (all when empty).
+// The response carries the signing secret, which is not shown again.
+func (h *AdminHandler) CreateWebhook(c echo.Context) error {
+	if !h.webhooks.Enabled() {
+		return webhooksDisabled(c)
+	}
+
+	var req struct {
+		URL        string   `json:"url"`
+		EventTypes []string `json:"event_types"`
+	}
+	if err := bindJSON(c, &req); err != nil {
+		return invalidBody(c, "Invalid request format", err)
+	}
+
+	subscription, err := h.webhooks.CreateSubscription(c.Request().Context(), req.URL, req.EventTypes)
+	if errors.Is(err, service.ErrInvalidWebhook) || errors.Is(err, service.ErrWebhookTargetNotAllowed) {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to create webhook subscription",
+		})
+	}
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"id":          subscription.ID,
+		"url":         subscription.URL,
+		"event_types": subscription.EventTypes,
+		"secret":      subscription.Secret,
+		"created_at":  subscription.CreatedAt,
+	})
+}
+
+// DeleteWebhook removes a subscription and cancels its queued deliveries;
+// its deliveries stay inspectable
+func (h *AdminHandler) DeleteWebhook(c echo.Context) error {
+	if !h.webhooks.Enabled() {
+		return webhooksDisabled(c)
+	}
+
+	id := c.Param("id")
+	deleted, err := h.webhooks.DeleteSubscription(c.Request().Context(), id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to delete webhook subscription",
+		})
+	}
+	if !deleted {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Webhook subscription not found",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": "Webhook subscription deleted",
+		"id":      id,
+	})
+}
+
+// RotateWebhookSecret gives a subscription a new signing secret, shown only
+// in this response; the old one keeps signing until previous_secret_expires_at
+func (h *AdminHandler) RotateWebhookSecret(c echo.Context) error {
+	if !h.webhooks.Enabled() {
+		return webhooksDisabled(c)
+	}
+
+	subscription, err := h.webhooks.RotateSecret(c.Request().Context(), c.Param("id"))
+	if errors.Is(err, service.ErrWebhookSubscriptionNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Webhook subscription not found",
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to rotate webhook secret",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"id":                         subscription.ID,
+		"secret":                     subscription.Secret,
+		"previous_secret_expires_at": subscription.PreviousSecretExpiresAt,
+	})
+}
+
+// ListWebhookDeliveries lists deliveries, newest first, filtered by
+// subscription_id, status (pending, delivered, failed, cancelled) and account_id
+func (h *AdminHandler) ListWebhookDeliveries(c echo.Context) error {
+	if !h.webhooks.Enabled() {
+		return webhooksDisabled(c)
+	}
+
+	filter := repository.WebhookDeliveryFilter{
+		SubscriptionID: c.QueryParam("subscription_id"),
+		Status:         c.QueryParam("status"),
+		AccountID:      c.QueryParam("account_id"),
+		Cursor:         c.QueryParam("cursor"),
+	}
+	filter.Limit, _ = strconv.Atoi(c.QueryParam("limit"))
+
+	page, err := h.webhooks.ListDeliveries(c.Request().Context(), filter)
+	if errors.Is(err, pagination.ErrInvalidCursor) {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid cursor",
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to get webhook deliveries",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"count":       len(page.Items),
+		"items":       page.Items,
+		"next_cursor": page.NextCursor,
+	})
+}
+
+// GetWebhookDelivery returns a delivery with every attempt made
+func (h *AdminHandler) GetWebhookDelivery(c echo.Context) error {
+	if !h.webhooks.Enabled() {
+		return webhooksDisabled(c)
+	}
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid delivery ID",
+		})
+	}
+
+	delivery, attempts, err := h.webhooks.GetDelivery(c.Request().Context(), id)
+	if errors.Is(err, service.ErrWebhookDeliveryNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Webhook delivery not found",
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to get webhook delivery",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"delivery": delivery,
+		"attempts": attempts,
+	})
+}
+
+// ReplayWebhookDelivery queues a delivered or dead-lettered delivery again
+func (h *AdminHandler) ReplayWebhookDelivery(c echo.Context) error {
+	if !h.webhooks.Enabled() {
+		return webhooksDisabled(c)
+	}
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid delivery ID",
+		})
+	}
+
+	err = h.webhooks.Replay(c.Request().Context(), id)
+	switch {
+	case errors.Is(err, service.ErrWebhookDeliveryNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Webhook delivery not found",
+		})
+	case errors.Is(err, service.ErrWebhookDeliveryPending):
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error": "Webhook delivery is still pending",
+		})
+	case errors.Is(err, service.ErrWebhookDeliveryCancelled):
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error": "Webhook subscription of the delivery was deleted",
+		})
+	case err != nil:
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to replay webhook delivery",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": "Webhook delivery queued for replay",
+		"id":      id,
+	})
+}
@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// bindJSON decodes the JSON request body into v. Unlike c.Bind it rejects
+// fields v does not declare and data after the JSON value, so a typo such as
+// "acount_id" fails loudly instead of silently becoming an empty field.
+func bindJSON(c echo.Context, v interface{}) error {
+	decoder := json.NewDecoder(c.Request().Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		if errors.Is(err, io.EOF) {
+			return errors.New("empty request body")
+		}
+		return err
+	}
+	_, err := decoder.Token()
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.Is(err, io.EOF):
+		return nil
+	case errors.As(err, &tooLarge):
+		return err
+	default:
+		return errors.New("unexpected data after JSON body")
+	}
+}
+
+// invalidBody answers a request whose body bindJSON rejected: 413 when the
+// body is over MAX_REQUEST_BODY_BYTES, 400 otherwise
+func invalidBody(c echo.Context, message string, err error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{
+			"error": fmt.Sprintf("Request body too large, limit is %d bytes", tooLarge.Limit),
+		})
+	}
+	return c.JSON(http.StatusBadRequest, map[string]string{
+		"error": message + ": " + err.Error(),
+	})
+}
@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"sub-balance-demo/internal/repository"
+	"sub-balance-demo/internal/service"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// Status per check pada response /health/ready
+const (
+	checkOK          = "ok"
+	checkUnavailable = "unavailable"
+	checkDegraded    = "degraded"
+)
+
+// HealthHandler serves the Kubernetes probes. Liveness only says the process
+// is serving HTTP; readiness says this instance can take traffic.
+type HealthHandler struct {
+	db                 *gorm.DB
+	transactionService service.TransactionService
+	redisHealth        *service.RedisHealthChecker
+	redisFallback      bool
+	started            *atomic.Bool
+	migrated           atomic.Bool
+	history            *service.HealthHistory
+}
+
+// NewHealthHandler builds the probe handler. started is flipped by main once
+// warm-up finishes and back to false when shutdown begins. With redisFallback
+// a Redis outage only degrades readiness, because transactions still go
+// through the database fallback path. history may be nil.
+func NewHealthHandler(db *gorm.DB, transactionService service.TransactionService, redisHealth *service.RedisHealthChecker, redisFallback bool, started *atomic.Bool, history *service.HealthHistory) *HealthHandler {
+	return &HealthHandler{
+		db:                 db,
+		transactionService: transactionService,
+		redisHealth:        redisHealth,
+		redisFallback:      redisFallback,
+		started:            started,
+		history:            history,
+	}
+}
+
+// Live always returns 200 while the process can serve requests. It does not
+// touch dependencies: a database outage must not get the pod restarted.
+func (h *HealthHandler) Live(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]string{"status": "alive"})
+}
+
+// Ready returns 503 while warming up, shutting down, or when the database,
+// the schema, Redis (without fallback) or the settlement worker is not usable
+func (h *HealthHandler) Ready(c echo.Context) error {
+	checks := map[string]string{}
+	ready := true
+
+	if !h.started.Load() {
+		return c.JSON(http.StatusServiceUnavailable, map[string]interface{}{
+			"status": "not_ready",
+			"checks": map[string]string{"startup": "warming_up_or_shutting_down"},
+		})
+	}
+	checks["startup"] = checkOK
+
+	ctx, cancel := context.WithTimeout(c.Request().Context(), 2*time.Second)
+	defer cancel()
+
+	checks["database"] = checkOK
+	if err := h.ping(ctx); err != nil {
+		checks["database"] = checkUnavailable
+		ready = false
+	}
+
+	checks["migrations"] = checkOK
+	if checks["database"] != checkOK {
+		checks["migrations"] = "unknown"
+	} else if !h.migrationsApplied(ctx) {
+		checks["migrations"] = "pending"
+		ready = false
+	}
+
+	checks["redis"] = checkOK
+	if h.redisHealth != nil && !h.redisHealth.IsHealthy() {
+		if h.redisFallback {
+			checks["redis"] = checkDegraded
+		} else {
+			checks["redis"] = checkUnavailable
+			ready = false
+		}
+	}
+
+	checks["settlement_worker"] = "running"
+	if !h.transactionService.SettlementWorkerRunning() {
+		checks["settlement_worker"] = "stopped"
+		ready = false
+	}
+
+	status, code := "ready", http.StatusOK
+	if !ready {
+		status, code = "not_ready", http.StatusServiceUnavailable
+	}
+	return c.JSON(code, map[string]interface{}{
+		"status": status,
+		"checks": checks,
+	})
+}
+
+// History returns the recorded Redis and database outages, newest first.
+// ?component=redis|database narrows the list. While Redis was down with
+// fallback enabled, transactions went through the database fallback path.
+func (h *HealthHandler) History(c echo.Context) error {
+	component := c.QueryParam("component")
+	switch component {
+	case "", service.HealthComponentRedis, service.HealthComponentDatabase:
+	default:
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "component must be redis or database"})
+	}
+
+	outages := h.history.List(component)
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"count":          len(outages),
+		"items":          outages,
+		"redis_fallback": h.redisFallback,
+	})
+}
+
+func (h *HealthHandler) ping(ctx context.Context) error {
+	sqlDB, err := h.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+// migrationsApplied checks that every AutoMigrate table exists. Once true the
+// result is cached, so steady-state probes only cost the ping.
+func (h *HealthHandler) migrationsApplied(ctx context.Context) bool {
+	if h.migrated.Load() {
+		return true
+	}
+	migrator := h.db.WithContext(ctx).Migrator()
+	for _, model := range repository.Models() {
+		if !migrator.HasTable(model) {
+			return false
+		}
+	}
+	h.migrated.Store(true)
+	return true
+}
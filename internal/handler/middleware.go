@@ -0,0 +1,321 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"sub-balance-demo/internal/auth"
+	"sub-balance-demo/internal/config"
+	"sub-balance-demo/internal/repository"
+	"sub-balance-demo/internal/service"
+	"sub-balance-demo/internal/tenant"
+
+	"github.com/labstack/echo/v4"
+)
+
+// TenantResolver binds the request context to the tenant of the caller's
+// credential (API key tenant, JWT tenant claim). The tenant header may only
+// repeat it, or name the tenant for an unbound admin or internal service
+// credential; any other tenant is refused with 403. Without authentication
+// the header is the only source.
+func TenantResolver(cfg *config.Config) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		if !cfg.EnableMultiTenancy {
+			return next
+		}
+		return func(c echo.Context) error {
+			tenantID := c.Request().Header.Get(cfg.TenantHeader)
+			if tenantID != "" {
+				if err := tenant.Validate(tenantID); err != nil {
+					return c.JSON(http.StatusBadRequest, map[string]string{
+						"error": "Invalid tenant",
+					})
+				}
+			}
+			if principal, ok := auth.FromContext(c.Request().Context()); ok {
+				var err error
+				tenantID, err = principal.ResolveTenant(tenantID)
+				if err != nil {
+					return c.JSON(http.StatusForbidden, map[string]string{
+						"error": "Tenant not permitted for this credential",
+					})
+				}
+			}
+			if tenantID == "" {
+				return c.JSON(http.StatusUnauthorized, map[string]string{
+					"error": "Missing " + cfg.TenantHeader + " header",
+				})
+			}
+
+			ctx := tenant.WithID(c.Request().Context(), tenantID)
+			c.SetRequest(c.Request().WithContext(ctx))
+			return next(c)
+		}
+	}
+}
+
+// adminAuditLimit caps how much of the request body and of an error response
+// is copied into the audit entry
+const adminAuditLimit = 8 << 10
+
+// AdminAuditor records every call to the group in the admin audit log: the
+// operator (the token subject with JWT auth, otherwise named in actorHeader),
+// path/query/body parameters, status and error.
+// The entry is written after the handler, so a failed audit write cannot
+// change the response; it is logged instead.
+func AdminAuditor(auditLog *service.AdminAuditLog, actorHeader string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			var body []byte
+			if req.Body != nil {
+				body, _ = io.ReadAll(req.Body)
+				req.Body = io.NopCloser(bytes.NewReader(body))
+			}
+
+			recorder := &auditResponseWriter{ResponseWriter: c.Response().Writer}
+			c.Response().Writer = recorder
+
+			start := time.Now()
+			err := next(c)
+
+			status := c.Response().Status
+			errorMessage := ""
+			if err != nil && !c.Response().Committed {
+				status = http.StatusInternalServerError
+				if he, ok := err.(*echo.HTTPError); ok {
+					status = he.Code
+				}
+				errorMessage = err.Error()
+			} else if status >= http.StatusBadRequest {
+				errorMessage = responseError(recorder.body.Bytes())
+			}
+
+			outcome := service.AdminAuditSuccess
+			if status >= http.StatusBadRequest {
+				outcome = service.AdminAuditFailure
+			}
+
+			// Dengan JWT auth, subject token menggantikan header yang bisa dipalsukan
+			actor := req.Header.Get(actorHeader)
+			if principal, ok := auth.FromContext(c.Request().Context()); ok {
+				actor = principal.Subject
+			}
+			if actor == "" {
+				actor = "anonymous"
+			}
+
+			auditErr := auditLog.Record(context.WithoutCancel(req.Context()), repository.AdminAuditEntry{
+				Actor:      actor,
+				RemoteIP:   c.RealIP(),
+				Method:     req.Method,
+				Route:      c.Path(),
+				Path:       req.URL.Path,
+				Params:     auditParams(c, body),
+				StatusCode: status,
+				Outcome:    outcome,
+				Error:      errorMessage,
+				DurationMs: time.Since(start).Milliseconds(),
+			})
+			if auditErr != nil {
+				log.Printf("AUDIT FAILURE: %s %s by %s not recorded: %v", req.Method, req.URL.Path, actor, auditErr)
+			}
+			return err
+		}
+	}
+}
+
+// auditParams encodes path params, query and request body as one JSON object
+func auditParams(c echo.Context, body []byte) string {
+	params := map[string]interface{}{}
+
+	if names := c.ParamNames(); len(names) > 0 {
+		path := make(map[string]string, len(names))
+		for _, name := range names {
+			path[name] = c.Param(name)
+		}
+		params["path"] = path
+	}
+	if query := c.QueryParams(); len(query) > 0 {
+		params["query"] = query
+	}
+	if len(body) > 0 {
+		if len(body) > adminAuditLimit {
+			params["body"] = string(body[:adminAuditLimit])
+			params["body_truncated"] = true
+		} else if json.Valid(body) {
+			params["body"] = json.RawMessage(body)
+		} else {
+			params["body"] = string(body)
+		}
+	}
+
+	encoded, err := json.Marshal(params)
+	if err != nil {
+		return "{}"
+	}
+	return string(encoded)
+}
+
+// responseError extracts the "error" field of a JSON error response, or the
+// raw body when it is not one
+func responseError(body []byte) string {
+	var payload struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &payload) == nil && payload.Error != "" {
+		return payload.Error
+	}
+	return strings.TrimSpace(string(body))
+}
+
+// auditResponseWriter keeps the first adminAuditLimit bytes of the response so
+// the error message of a failed call can be audited
+type auditResponseWriter struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *auditResponseWriter) Write(b []byte) (int, error) {
+	if remaining := adminAuditLimit - w.body.Len(); remaining > 0 {
+		if len(b) < remaining {
+			remaining = len(b)
+		}
+		w.body.Write(b[:remaining])
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// ConcurrentRequestLimiter answers 429 while maxConcurrent requests are in flight
+func ConcurrentRequestLimiter(maxConcurrent int) echo.MiddlewareFunc {
+	semaphore := make(chan struct{}, maxConcurrent)
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			select {
+			case semaphore <- struct{}{}:
+				defer func() { <-semaphore }()
+				return next(c)
+			default:
+				return c.JSON(http.StatusTooManyRequests, map[string]string{
+					"error": "Too many concurrent requests",
+				})
+			}
+		}
+	}
+}
+
+// RequestBodyLimit rejects a request whose Content-Length is over maxBytes
+// with 413, and caps the body of the others (chunked ones included) so a
+// handler reading past maxBytes gets an *http.MaxBytesError. routeLimits
+// replaces maxBytes for the listed route paths; a limit of 0 means none.
+func RequestBodyLimit(maxBytes int64, routeLimits map[string]int64) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			maxBytes := maxBytes
+			if limit, ok := routeLimits[c.Path()]; ok {
+				maxBytes = limit
+			}
+			if maxBytes <= 0 {
+				return next(c)
+			}
+			req := c.Request()
+			if req.ContentLength > maxBytes {
+				return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{
+					"error": fmt.Sprintf("Request body too large, limit is %d bytes", maxBytes),
+				})
+			}
+			if req.Body != nil {
+				req.Body = http.MaxBytesReader(c.Response(), req.Body, maxBytes)
+			}
+			return next(c)
+		}
+	}
+}
+
+// RateLimit answers 429 once the caller's identity used up its
+// limit. It runs after authorization so the principal is known. A nil limiter
+// disables rate limiting.
+func RateLimit(limiter *service.RateLimiter) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		if limiter == nil {
+			return next
+		}
+		return func(c echo.Context) error {
+			decision := limiter.Allow(rateLimitIdentity(c))
+			header := c.Response().Header()
+			header.Set("X-RateLimit-Limit", strconv.Itoa(decision.Limit))
+			header.Set("X-RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+			if !decision.Allowed {
+				header.Set("Retry-After", strconv.Itoa(int(math.Ceil(decision.ResetIn.Seconds()))))
+				return c.JSON(http.StatusTooManyRequests, map[string]string{
+					"error": "Rate limit exceeded",
+				})
+			}
+			return next(c)
+		}
+	}
+}
+
+// rateLimitBodyRoutes are the routes whose JSON body names the account the
+// request acts on
+var rateLimitBodyRoutes = map[string]bool{
+	"POST /api/v1/transaction": true,
+	"POST /test/accounts":      true,
+}
+
+// rateLimitIdentity is who a request counts against: the authenticated
+// principal (apikey:<name> or the JWT subject), else the account in the path,
+// query or, on transactional routes, body, else the client IP
+func rateLimitIdentity(c echo.Context) string {
+	if principal, ok := auth.FromContext(c.Request().Context()); ok && principal.Subject != "" {
+		return principal.Subject
+	}
+	if accountID := c.Param("account_id"); accountID != "" {
+		return "account:" + accountID
+	}
+	if accountID := c.QueryParam("account_id"); accountID != "" {
+		return "account:" + accountID
+	}
+	if rateLimitBodyRoutes[auth.Route(c.Request().Method, c.Path())] {
+		if accountID := bodyAccountID(c.Request()); accountID != "" {
+			return "account:" + accountID
+		}
+	}
+	return "ip:" + c.RealIP()
+}
+
+// bodyAccountID reads account_id from a JSON body and puts the body back for
+// the handler. The body is already capped by RequestBodyLimit; a body that is
+// not JSON has no account.
+func bodyAccountID(req *http.Request) string {
+	if req.Body == nil {
+		return ""
+	}
+	original := req.Body
+	body, err := io.ReadAll(original)
+	// Sisa body (atau error MaxBytesReader-nya) tetap sampai ke handler
+	req.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), original), original}
+	if err != nil {
+		return ""
+	}
+	var fields struct {
+		AccountID string `json:"account_id"`
+	}
+	if json.Unmarshal(body, &fields) != nil {
+		return ""
+	}
+	return fields.AccountID
+}
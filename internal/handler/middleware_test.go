@@ -0,0 +1,287 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"sub-balance-demo/internal/auth"
+	"sub-balance-demo/internal/config"
+	"sub-balance-demo/internal/pagination"
+	"sub-balance-demo/internal/repository"
+	"sub-balance-demo/internal/service"
+	"sub-balance-demo/internal/tenant"
+)
+
+func TestRateLimitIdentity(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    string
+		route     string
+		target    string
+		body      string
+		principal string
+		want      string
+	}{
+		{"principal", http.MethodPost, "/api/v1/transaction", "/api/v1/transaction", `{"account_id":"ACC001"}`, "apikey:partner-a", "apikey:partner-a"},
+		{"path account", http.MethodGet, "/api/v1/balance/:account_id", "/api/v1/balance/ACC001", "", "", "account:ACC001"},
+		{"query account", http.MethodGet, "/admin/quarantine", "/admin/quarantine?account_id=ACC002", "", "", "account:ACC002"},
+		{"body account", http.MethodPost, "/api/v1/transaction", "/api/v1/transaction", `{"account_id":"ACC003","amount":"1","type":"debit"}`, "", "account:ACC003"},
+		{"test account body", http.MethodPost, "/test/accounts", "/test/accounts", `{"account_id":"ACC004"}`, "", "account:ACC004"},
+		{"body not JSON", http.MethodPost, "/api/v1/transaction", "/api/v1/transaction", `account_id=ACC003`, "", "ip:192.0.2.1"},
+		{"body of other route", http.MethodPost, "/admin/settlement/trigger", "/admin/settlement/trigger", `{"account_id":"ACC005"}`, "", "ip:192.0.2.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			req.RemoteAddr = "192.0.2.1:1234"
+			if tt.principal != "" {
+				req = req.WithContext(auth.WithPrincipal(req.Context(), auth.Principal{Subject: tt.principal}))
+			}
+			c := e.NewContext(req, httptest.NewRecorder())
+			c.SetPath(tt.route)
+			if strings.Contains(tt.route, ":account_id") {
+				c.SetParamNames("account_id")
+				c.SetParamValues("ACC001")
+			}
+
+			if got := rateLimitIdentity(c); got != tt.want {
+				t.Fatalf("rateLimitIdentity = %q, want %q", got, tt.want)
+			}
+			if body, _ := io.ReadAll(c.Request().Body); string(body) != tt.body {
+				t.Fatalf("handler body = %q, want %q", body, tt.body)
+			}
+		})
+	}
+}
+
+func TestBodyAccountIDKeepsBodyLimitError(t *testing.T) {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/transaction", strings.NewReader(`{"account_id":"ACC001","amount":"1"}`))
+	req.Body = http.MaxBytesReader(rec, req.Body, 10)
+
+	if got := bodyAccountID(req); got != "" {
+		t.Fatalf("bodyAccountID = %q, want none for a body over the limit", got)
+	}
+	_, err := io.ReadAll(req.Body)
+	var tooLarge *http.MaxBytesError
+	if !errors.As(err, &tooLarge) {
+		t.Fatalf("handler read error = %v, want *http.MaxBytesError", err)
+	}
+}
+
+func TestRequestBodyLimit(t *testing.T) {
+	tests := []struct {
+		name        string
+		path        string
+		body        string
+		chunked     bool
+		wantStatus  int
+		wantHandler bool
+	}{
+		{"within limit", "/api/v1/transaction", `{"a":1}`, false, http.StatusOK, true},
+		{"content length over limit", "/api/v1/transaction", strings.Repeat("x", 20), false, http.StatusRequestEntityTooLarge, false},
+		{"chunked over limit", "/api/v1/transaction", strings.Repeat("x", 20), true, http.StatusRequestEntityTooLarge, true},
+		{"route limit", "/api/v1/transactions/import", strings.Repeat("x", 20), false, http.StatusOK, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			handlerCalled := false
+			read := func(c echo.Context) error {
+				handlerCalled = true
+				var tooLarge *http.MaxBytesError
+				if _, err := io.ReadAll(c.Request().Body); errors.As(err, &tooLarge) {
+					return c.NoContent(http.StatusRequestEntityTooLarge)
+				}
+				return c.NoContent(http.StatusOK)
+			}
+			limit := RequestBodyLimit(10, map[string]int64{"/api/v1/transactions/import": 100})
+			e.POST("/api/v1/transaction", read, limit)
+			e.POST("/api/v1/transactions/import", read, limit)
+
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			if tt.chunked {
+				req.ContentLength = -1
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus || handlerCalled != tt.wantHandler {
+				t.Fatalf("status %d, handler called %v; want %d, %v", rec.Code, handlerCalled, tt.wantStatus, tt.wantHandler)
+			}
+		})
+	}
+}
+
+func TestTenantResolver(t *testing.T) {
+	tests := []struct {
+		name       string
+		enabled    bool
+		header     string
+		principal  *auth.Principal
+		wantStatus int
+		wantTenant string
+	}{
+		{"disabled", false, "", nil, http.StatusOK, ""},
+		{"missing header", true, "", nil, http.StatusUnauthorized, ""},
+		{"invalid tenant", true, "../etc", nil, http.StatusBadRequest, ""},
+		{"tenant", true, "acme", nil, http.StatusOK, "acme"},
+		{"tenant of the credential", true, "", &auth.Principal{Subject: "apikey:acme", Roles: []string{auth.RoleService}, Tenant: "acme"}, http.StatusOK, "acme"},
+		{"header repeats the credential", true, "acme", &auth.Principal{Subject: "apikey:acme", Roles: []string{auth.RoleService}, Tenant: "acme"}, http.StatusOK, "acme"},
+		{"cross-tenant header", true, "globex", &auth.Principal{Subject: "apikey:acme", Roles: []string{auth.RoleService}, Tenant: "acme"}, http.StatusForbidden, ""},
+		{"unbound service credential", true, "globex", &auth.Principal{Subject: "apikey:partner", Roles: []string{auth.RoleService}}, http.StatusForbidden, ""},
+		{"admin picks the tenant", true, "globex", &auth.Principal{Subject: "apikey:ops", Roles: []string{auth.RoleAdmin}}, http.StatusOK, "globex"},
+		{"internal service picks the tenant", true, "globex", &auth.Principal{Subject: "service:billing", Roles: []string{auth.RoleService}, Internal: true}, http.StatusOK, "globex"},
+		{"admin without header", true, "", &auth.Principal{Subject: "apikey:ops", Roles: []string{auth.RoleAdmin}}, http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{EnableMultiTenancy: tt.enabled, TenantHeader: "X-Tenant-ID"}
+			e := echo.New()
+			var gotTenant string
+			e.GET("/", func(c echo.Context) error {
+				gotTenant, _ = tenant.FromContext(c.Request().Context())
+				return c.NoContent(http.StatusOK)
+			}, TenantResolver(cfg))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set("X-Tenant-ID", tt.header)
+			}
+			if tt.principal != nil {
+				req = req.WithContext(auth.WithPrincipal(req.Context(), *tt.principal))
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus || gotTenant != tt.wantTenant {
+				t.Fatalf("status %d, tenant %q; want %d, %q", rec.Code, gotTenant, tt.wantStatus, tt.wantTenant)
+			}
+		})
+	}
+}
+
+func TestConcurrentRequestLimiter(t *testing.T) {
+	e := echo.New()
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	e.GET("/", func(c echo.Context) error {
+		entered <- struct{}{}
+		<-release
+		return c.NoContent(http.StatusOK)
+	}, ConcurrentRequestLimiter(1))
+
+	done := make(chan int)
+	go func() {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		done <- rec.Code
+	}()
+	<-entered
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request: status %d, want 429", rec.Code)
+	}
+	close(release)
+	if code := <-done; code != http.StatusOK {
+		t.Fatalf("first request: status %d, want 200", code)
+	}
+}
+
+type memoryRateLimits struct{}
+
+func (memoryRateLimits) List(ctx context.Context) ([]repository.RateLimit, error) { return nil, nil }
+func (memoryRateLimits) Upsert(ctx context.Context, limit *repository.RateLimit) error {
+	return nil
+}
+func (memoryRateLimits) Delete(ctx context.Context, identity string) (bool, error) { return false, nil }
+
+func TestRateLimit(t *testing.T) {
+	limiter := service.NewRateLimiter(memoryRateLimits{}, service.RateLimitFixedWindow, 1, time.Minute, 0, true)
+	e := echo.New()
+	e.GET("/api/v1/balance/:account_id", func(c echo.Context) error { return c.NoContent(http.StatusOK) }, RateLimit(limiter))
+
+	get := func(accountID string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/balance/"+accountID, nil))
+		return rec
+	}
+	if rec := get("ACC001"); rec.Code != http.StatusOK || rec.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Fatalf("first request: status %d, remaining %q", rec.Code, rec.Header().Get("X-RateLimit-Remaining"))
+	}
+	rec := get("ACC001")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("second request: status %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	// Account lain punya kuota sendiri
+	if rec := get("ACC002"); rec.Code != http.StatusOK {
+		t.Fatalf("other account: status %d", rec.Code)
+	}
+
+	// Tanpa limiter semua request diteruskan
+	e.GET("/api/v1/pending/:account_id", func(c echo.Context) error { return c.NoContent(http.StatusOK) }, RateLimit(nil))
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/pending/ACC001", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d without a limiter: status %d", i, rec.Code)
+		}
+	}
+}
+
+type memoryAuditLog struct {
+	entries []repository.AdminAuditEntry
+}
+
+func (m *memoryAuditLog) Create(ctx context.Context, entry *repository.AdminAuditEntry) error {
+	m.entries = append(m.entries, *entry)
+	return nil
+}
+
+func (m *memoryAuditLog) List(ctx context.Context, filter repository.AdminAuditFilter) (pagination.Page[repository.AdminAuditEntry], error) {
+	return pagination.Page[repository.AdminAuditEntry]{}, nil
+}
+
+func TestAdminAuditor(t *testing.T) {
+	repo := &memoryAuditLog{}
+	e := echo.New()
+	e.IPExtractor = echo.ExtractIPDirect()
+	e.DELETE("/admin/quarantine/:account_id", func(c echo.Context) error {
+		body, _ := io.ReadAll(c.Request().Body)
+		if string(body) != `{"reason":"cleared"}` {
+			t.Errorf("handler body = %q", body)
+		}
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Account not quarantined"})
+	}, AdminAuditor(service.NewAdminAuditLog(repo), "X-Admin-Actor"))
+
+	req := httptest.NewRequest(http.MethodDelete, "/admin/quarantine/ACC001?force=1", strings.NewReader(`{"reason":"cleared"}`))
+	req.Header.Set("X-Admin-Actor", "alice")
+	req.RemoteAddr = "10.0.0.1:1234"
+	e.ServeHTTP(httptest.NewRecorder(), req)
+
+	if len(repo.entries) != 1 {
+		t.Fatalf("%d audit entries, want 1", len(repo.entries))
+	}
+	entry := repo.entries[0]
+	if entry.Actor != "alice" || entry.RemoteIP != "10.0.0.1" || entry.Route != "/admin/quarantine/:account_id" ||
+		entry.StatusCode != http.StatusNotFound || entry.Outcome != service.AdminAuditFailure || entry.Error != "Account not quarantined" {
+		t.Fatalf("audit entry = %+v", entry)
+	}
+	var params map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(entry.Params), &params); err != nil {
+		t.Fatalf("params %q: %v", entry.Params, err)
+	}
+	if string(params["path"]) != `{"account_id":"ACC001"}` || string(params["body"]) != `{"reason":"cleared"}` || string(params["query"]) != `{"force":["1"]}` {
+		t.Fatalf("params = %s", entry.Params)
+	}
+}
@@ -0,0 +1,172 @@
+package handler
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"time"
+
+	"sub-balance-demo/internal/auth"
+	"sub-balance-demo/internal/buildinfo"
+	"sub-balance-demo/internal/config"
+	"sub-balance-demo/internal/metrics"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// RoutePolicy is the role each route of /api/v1, /admin and /test requires
+// when JWT auth is enabled. Routes missing here are denied.
+var RoutePolicy = auth.Policy{
+	// Read-only
+	auth.Route(http.MethodGet, "/api/v1/balance/:account_id"): auth.RoleReadOnly,
+	auth.Route(http.MethodGet, "/api/v1/pending/:account_id"): auth.RoleReadOnly,
+	auth.Route(http.MethodGet, "/api/v1/health"):              auth.Public,
+
+	// Transaksi
+	auth.Route(http.MethodPost, "/api/v1/transaction"):         auth.RoleService,
+	auth.Route(http.MethodPost, "/api/v1/transactions/import"): auth.RoleService,
+
+	// Admin: settlement, consistency, quarantine, retention dan audit
+	auth.Route(http.MethodGet, "/admin/reconciliation"):                  auth.RoleAdmin,
+	auth.Route(http.MethodGet, "/admin/settlement-audit"):                auth.RoleAdmin,
+	auth.Route(http.MethodGet, "/admin/balance-audit"):                   auth.RoleAdmin,
+	auth.Route(http.MethodGet, "/admin/quarantine"):                      auth.RoleAdmin,
+	auth.Route(http.MethodDelete, "/admin/quarantine/:account_id"):       auth.RoleAdmin,
+	auth.Route(http.MethodGet, "/admin/redis/pending"):                   auth.RoleAdmin,
+	auth.Route(http.MethodGet, "/admin/retention"):                       auth.RoleAdmin,
+	auth.Route(http.MethodPost, "/admin/retention/purge"):                auth.RoleAdmin,
+	auth.Route(http.MethodGet, "/admin/balance-history"):                 auth.RoleAdmin,
+	auth.Route(http.MethodGet, "/admin/audit-log"):                       auth.RoleAdmin,
+	auth.Route(http.MethodGet, "/admin/events"):                          auth.RoleAdmin,
+	auth.Route(http.MethodGet, "/admin/rate-limits"):                     auth.RoleAdmin,
+	auth.Route(http.MethodPut, "/admin/rate-limits/:identity"):           auth.RoleAdmin,
+	auth.Route(http.MethodDelete, "/admin/rate-limits/:identity"):        auth.RoleAdmin,
+	auth.Route(http.MethodGet, "/admin/lockouts"):                        auth.RoleAdmin,
+	auth.Route(http.MethodDelete, "/admin/lockouts/:ip"):                 auth.RoleAdmin,
+	auth.Route(http.MethodGet, "/admin/webhooks"):                        auth.RoleAdmin,
+	auth.Route(http.MethodPost, "/admin/webhooks"):                       auth.RoleAdmin,
+	auth.Route(http.MethodDelete, "/admin/webhooks/:id"):                 auth.RoleAdmin,
+	auth.Route(http.MethodPost, "/admin/webhooks/:id/rotate-secret"):     auth.RoleAdmin,
+	auth.Route(http.MethodGet, "/admin/webhooks/deliveries"):             auth.RoleAdmin,
+	auth.Route(http.MethodGet, "/admin/webhooks/deliveries/:id"):         auth.RoleAdmin,
+	auth.Route(http.MethodPost, "/admin/webhooks/deliveries/:id/replay"): auth.RoleAdmin,
+	auth.Route(http.MethodPost, "/test/accounts"):                        auth.RoleAdmin,
+	auth.Route(http.MethodDelete, "/test/cleanup"):                       auth.RoleAdmin,
+}
+
+// RegisterRoutes mounts /api/v1
+func RegisterRoutes(e *echo.Echo, cfg *config.Config, h *TransactionHandler, authn *auth.Authenticator, rateLimited echo.MiddlewareFunc) {
+	tenantScoped := TenantResolver(cfg)
+
+	api := e.Group("/api/v1", authn.Authorize(RoutePolicy), rateLimited)
+	api.POST("/transaction", h.ProcessTransaction, tenantScoped)
+	api.POST("/transactions/import", h.ImportTransactions, tenantScoped)
+	api.GET("/balance/:account_id", h.GetBalance, tenantScoped)
+	api.GET("/pending/:account_id", h.GetPendingTransactions, tenantScoped)
+	api.GET("/health", h.HealthCheck)
+}
+
+// RegisterAdminRoutes mounts /admin. The audit middleware runs first so rejected
+// (401/403/429) calls are audited too.
+func RegisterAdminRoutes(e *echo.Echo, h *AdminHandler, audit echo.MiddlewareFunc, allowlist echo.MiddlewareFunc, authn *auth.Authenticator, rateLimited echo.MiddlewareFunc) {
+	admin := e.Group("/admin", audit, allowlist, authn.Authorize(RoutePolicy), rateLimited)
+	admin.GET("/reconciliation", h.GetReconciliation)
+	admin.GET("/settlement-audit", h.GetSettlementAudit)
+	admin.GET("/balance-audit", h.GetBalanceAudit)
+	admin.GET("/quarantine", h.ListQuarantine)
+	admin.DELETE("/quarantine/:account_id", h.ReleaseQuarantine)
+	admin.GET("/redis/pending", h.ListPendingCounters)
+	admin.GET("/retention", h.GetRetentionPolicies)
+	admin.POST("/retention/purge", h.PurgeRetention)
+	admin.GET("/balance-history", h.GetBalanceHistory)
+	admin.GET("/audit-log", h.GetAdminAuditLog)
+	admin.GET("/events", h.GetDomainEvents)
+	admin.GET("/rate-limits", h.ListRateLimits)
+	admin.PUT("/rate-limits/:identity", h.SetRateLimit)
+	admin.DELETE("/rate-limits/:identity", h.DeleteRateLimit)
+	admin.GET("/lockouts", h.ListLockouts)
+	admin.DELETE("/lockouts/:ip", h.Unlock)
+	admin.GET("/webhooks", h.ListWebhooks)
+	admin.POST("/webhooks", h.CreateWebhook)
+	admin.DELETE("/webhooks/:id", h.DeleteWebhook)
+	admin.POST("/webhooks/:id/rotate-secret", h.RotateWebhookSecret)
+	admin.GET("/webhooks/deliveries", h.ListWebhookDeliveries)
+	admin.GET("/webhooks/deliveries/:id", h.GetWebhookDelivery)
+	admin.POST("/webhooks/deliveries/:id/replay", h.ReplayWebhookDelivery)
+}
+
+// RegisterTestRoutes mounts /test, guarded like /admin
+func RegisterTestRoutes(e *echo.Echo, cfg *config.Config, transactionHandler *TransactionHandler, audit echo.MiddlewareFunc, allowlist echo.MiddlewareFunc, authn *auth.Authenticator, rateLimited echo.MiddlewareFunc) {
+	// Test routes for development/testing
+	test := e.Group("/test", audit, allowlist, authn.Authorize(RoutePolicy), rateLimited)
+
+	// Test account creation
+	test.POST("/accounts", transactionHandler.CreateAccount, TenantResolver(cfg))
+
+	// Test data cleanup
+	test.DELETE("/cleanup", func(c echo.Context) error {
+		if cfg.TestDataCleanup {
+			// This would clean up test data
+			response := map[string]interface{}{
+				"message": "Test data cleanup enabled",
+				"prefix":  cfg.TestAccountPrefix,
+			}
+			return c.JSON(http.StatusOK, response)
+		}
+		return c.JSON(http.StatusForbidden, map[string]string{
+			"error": "Test data cleanup is disabled",
+		})
+	})
+}
+
+// RegisterMonitoring records HTTP metrics of both the public and the admin
+// server (the same *echo.Echo when ADMIN_PORT is not separate)
+func RegisterMonitoring(e *echo.Echo, adminEcho *echo.Echo, cfg *config.Config, sources metrics.Sources, sqlDB *sql.DB, replicaDB *gorm.DB) {
+	var replicaSQL *sql.DB
+	if replicaDB != nil {
+		replicaSQL, _ = replicaDB.DB()
+	}
+	registry := metrics.NewRegistry(sources, sqlDB, replicaSQL)
+	e.Use(registry.Middleware())
+	if adminEcho != e {
+		adminEcho.Use(registry.Middleware())
+	}
+
+	// Prometheus endpoint: di port terpisah (METRICS_PORT) supaya tidak ikut
+	// terekspos lewat load balancer, atau di port utama jika sama/kosong
+	if cfg.MetricsPort == "" || cfg.MetricsPort == cfg.Port {
+		e.GET("/metrics", echo.WrapHandler(registry.Handler()))
+	} else {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", registry.Handler())
+		go func() {
+			log.Printf("Serving Prometheus metrics on :%s/metrics", cfg.MetricsPort)
+			if err := http.ListenAndServe(":"+cfg.MetricsPort, mux); err != nil {
+				log.Printf("Metrics server stopped: %v", err)
+			}
+		}()
+	}
+
+	// Health check with more details
+	e.GET("/health/detailed", func(c echo.Context) error {
+		health := map[string]interface{}{
+			"status":         "healthy",
+			"timestamp":      time.Now().Unix(),
+			"version":        sources.Build.Version,
+			"build":          sources.Build,
+			"uptime":         buildinfo.Uptime().Round(time.Second).String(),
+			"uptime_seconds": int64(buildinfo.Uptime().Seconds()),
+			"features": map[string]bool{
+				"redis_fallback":    cfg.EnableRedisFallback,
+				"circuit_breaker":   cfg.EnableCircuitBreaker,
+				"data_consistency":  cfg.EnableDataConsistencyCheck,
+				"auto_recovery":     cfg.EnableAutoRecovery,
+				"graceful_shutdown": cfg.EnableGracefulShutdown,
+				"rate_limiting":     cfg.EnableRateLimit,
+				"monitoring":        cfg.EnableMetrics,
+			},
+		}
+		return c.JSON(http.StatusOK, health)
+	})
+}
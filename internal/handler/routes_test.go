@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+
+	"sub-balance-demo/internal/auth"
+	"sub-balance-demo/internal/config"
+)
+
+// routeTestKeys are API keys holding a single role each
+var routeTestKeys = map[string]string{
+	auth.RoleReadOnly: "read-only-key",
+	auth.RoleService:  "service-key",
+	auth.RoleAdmin:    "admin-key",
+}
+
+// newPolicyTestServer mounts the protected groups with API key auth and the
+// other middleware as pass-through. Handlers run with empty dependencies and
+// may panic; that is recovered as 299, so any status but 401/403 means the
+// request got past authorization.
+func newPolicyTestServer(t *testing.T) *echo.Echo {
+	t.Helper()
+	var keys []auth.APIKey
+	for role, key := range routeTestKeys {
+		keys = append(keys, auth.APIKey{Name: role, KeySHA256: auth.HashAPIKey(key), Roles: []string{role}})
+	}
+	authn, err := auth.New(context.Background(), auth.Options{APIKeys: keys, APIKeyHeader: "X-API-Key"})
+	if err != nil {
+		t.Fatalf("auth.New: %v", err)
+	}
+
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	e := echo.New()
+	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) (err error) {
+			defer func() {
+				if recover() != nil {
+					err = c.NoContent(299)
+				}
+			}()
+			return next(c)
+		}
+	})
+	pass := func(next echo.HandlerFunc) echo.HandlerFunc { return next }
+	cfg := &config.Config{TestDataCleanup: true}
+	RegisterRoutes(e, cfg, &TransactionHandler{}, authn, pass)
+	RegisterAdminRoutes(e, &AdminHandler{}, pass, pass, authn, pass)
+	RegisterTestRoutes(e, cfg, &TransactionHandler{}, pass, pass, authn, pass)
+	return e
+}
+
+// expectedRole is the role a route of a protected group must require,
+// independent of RoutePolicy: admin for /admin and /test, service for writes
+// under /api/v1, read-only for its reads, except the public health check
+func expectedRole(method string, path string) string {
+	switch {
+	case strings.HasPrefix(path, "/admin/"), strings.HasPrefix(path, "/test/"):
+		return auth.RoleAdmin
+	case path == "/api/v1/health":
+		return auth.Public
+	case method == http.MethodGet:
+		return auth.RoleReadOnly
+	default:
+		return auth.RoleService
+	}
+}
+
+func TestRoutePolicy(t *testing.T) {
+	e := newPolicyTestServer(t)
+
+	registered := make(map[string]bool)
+	for _, route := range e.Routes() {
+		if route.Method == echo.RouteNotFound {
+			continue // catch-all group, lihat Authorize
+		}
+		if !strings.HasPrefix(route.Path, "/api/v1/") && !strings.HasPrefix(route.Path, "/admin/") && !strings.HasPrefix(route.Path, "/test/") {
+			continue
+		}
+		key := auth.Route(route.Method, route.Path)
+		registered[key] = true
+
+		t.Run(key, func(t *testing.T) {
+			role, ok := RoutePolicy[key]
+			if !ok {
+				t.Fatalf("%s has no RoutePolicy entry", key)
+			}
+			if want := expectedRole(route.Method, route.Path); role != want {
+				t.Fatalf("RoutePolicy[%s] = %s, want %s", key, role, want)
+			}
+
+			path := strings.NewReplacer(":account_id", "acc-1", ":identity", "id-1", ":ip", "10.0.0.1", ":id", "1").Replace(route.Path)
+			callers := []struct {
+				name string
+				role string // "" tanpa credential
+			}{
+				{"anonymous", ""},
+				{"read-only", auth.RoleReadOnly},
+				{"service", auth.RoleService},
+				{"admin", auth.RoleAdmin},
+			}
+			for _, caller := range callers {
+				req := httptest.NewRequest(route.Method, path, nil)
+				if caller.role != "" {
+					req.Header.Set("X-API-Key", routeTestKeys[caller.role])
+				}
+				rec := httptest.NewRecorder()
+				e.ServeHTTP(rec, req)
+
+				allowed := role == auth.Public || (caller.role != "" && auth.Principal{Roles: []string{caller.role}}.HasRole(role))
+				denied := rec.Code == http.StatusUnauthorized || rec.Code == http.StatusForbidden
+				if allowed == denied {
+					t.Errorf("%s as %s: status %d, want allowed=%v", key, caller.name, rec.Code, allowed)
+				}
+			}
+		})
+	}
+
+	// Entri policy tanpa route biasanya salah ketik path atau method
+	for key := range RoutePolicy {
+		if !registered[key] {
+			t.Errorf("RoutePolicy has %s, which is not a registered route", key)
+		}
+	}
+}
@@ -1,9 +1,14 @@
 package handler
 
 import (
+	"errors"
+	"log"
 	"net/http"
+	"time"
 
+	"sub-balance-demo/internal/auth"
 	"sub-balance-demo/internal/config"
+	"sub-balance-demo/internal/recovery"
 	"sub-balance-demo/internal/repository"
 	"sub-balance-demo/internal/service"
 
@@ -14,29 +19,38 @@ import (
 
 type TransactionHandler struct {
 	transactionService service.TransactionService
+	imports            repository.IngestRepository // claims of imported references
 	validator          *validator.Validate
 	config             *config.Config
+	importTimeout      time.Duration
 }
 
-func NewTransactionHandler(transactionService service.TransactionService, config *config.Config) *TransactionHandler {
+func NewTransactionHandler(transactionService service.TransactionService, imports repository.IngestRepository, config *config.Config) *TransactionHandler {
+	importTimeout, err := time.ParseDuration(config.TransactionImportTimeout)
+	if err != nil {
+		log.Printf("Invalid transaction import timeout, using default 5m: %v", err)
+		importTimeout = 5 * time.Minute
+	}
+
 	return &TransactionHandler{
 		transactionService: transactionService,
+		imports:            imports,
 		validator:          validator.New(),
 		config:             config,
+		importTimeout:      importTimeout,
 	}
 }
 
 func (h *TransactionHandler) CreateAccount(c echo.Context) error {
 	var req struct {
-		AccountID string `json:"account_id" validate:"required"`
-		Balance   string `json:"balance"`
+		AccountID string `json:"account_id" validate:"required,max=64"`
+		Balance   string `json:"balance" validate:"max=40"`
 	}
 
-	if err := c.Bind(&req); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "Invalid request body",
-		})
+	if err := bindJSON(c, &req); err != nil {
+		return invalidBody(c, "Invalid request body", err)
 	}
+	recovery.SetAccountID(c, req.AccountID)
 
 	if err := h.validator.Struct(&req); err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{
@@ -44,6 +58,12 @@ func (h *TransactionHandler) CreateAccount(c echo.Context) error {
 		})
 	}
 
+	if err := auth.CheckAccount(c.Request().Context(), req.AccountID); err != nil {
+		return c.JSON(http.StatusForbidden, map[string]string{
+			"error": "Account not permitted for this credential",
+		})
+	}
+
 	// Parse balance
 	balance, err := decimal.NewFromString(req.Balance)
 	if err != nil {
@@ -70,11 +90,10 @@ func (h *TransactionHandler) CreateAccount(c echo.Context) error {
 
 func (h *TransactionHandler) ProcessTransaction(c echo.Context) error {
 	var req repository.TransactionRequest
-	if err := c.Bind(&req); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "Invalid request format",
-		})
+	if err := bindJSON(c, &req); err != nil {
+		return invalidBody(c, "Invalid request format", err)
 	}
+	recovery.SetAccountID(c, req.AccountID)
 
 	// Validate request
 	if err := h.validator.Struct(&req); err != nil {
@@ -83,6 +102,13 @@ func (h *TransactionHandler) ProcessTransaction(c echo.Context) error {
 		})
 	}
 
+	// Key integrasi yang dibatasi ke account tertentu
+	if err := auth.CheckAccount(c.Request().Context(), req.AccountID); err != nil {
+		return c.JSON(http.StatusForbidden, map[string]string{
+			"error": "Account not permitted for this credential",
+		})
+	}
+
 	// Validate amount
 	if req.Amount.LessThanOrEqual(decimal.Zero) {
 		return c.JSON(http.StatusBadRequest, map[string]string{
@@ -92,6 +118,16 @@ func (h *TransactionHandler) ProcessTransaction(c echo.Context) error {
 
 	// Process transaction
 	response, err := h.transactionService.ProcessTransaction(c.Request().Context(), &req)
+	if errors.Is(err, service.ErrDatabaseUnavailable) {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{
+			"error": "Database unavailable, please retry later",
+		})
+	}
+	if errors.Is(err, service.ErrInvalidMetadata) {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error": "Internal server error: " + err.Error(),
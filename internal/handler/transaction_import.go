@@ -0,0 +1,248 @@
+package handler
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"sub-balance-demo/internal/auth"
+	"sub-balance-demo/internal/ingest"
+	"sub-balance-demo/internal/repository"
+	"sub-balance-demo/internal/service"
+	"sub-balance-demo/internal/tenant"
+
+	"github.com/labstack/echo/v4"
+)
+
+// importIngestSource is the ingest_rows source of imported references; the
+// file name is empty so a reference is claimed once per tenant, whatever file
+// it comes in
+const importIngestSource = "import"
+
+// importedTransaction is an accepted row
+type importedTransaction struct {
+	Line          int    `json:"line"`
+	Reference     string `json:"reference,omitempty"`
+	TransactionID string `json:"transaction_id"`
+	Status        string `json:"status"`
+}
+
+// ImportTransactions processes the transactions of a CSV uploaded in the
+// multipart field "file". Every row is validated on its own; valid rows are
+// processed as one batch and rejected rows, invalid or refused by
+// ProcessTransaction, are returned as an error report: JSON by default, a CSV
+// attachment with ?format=csv. A reference is claimed in ingest_rows before
+// its row is processed, so a re-uploaded row is rejected instead of creating
+// its transaction again; rows without a reference are not de-duplicated.
+func (h *TransactionHandler) ImportTransactions(c echo.Context) error {
+	format := c.QueryParam("format")
+	if format != "" && format != "json" && format != "csv" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid format, expected json or csv",
+		})
+	}
+
+	// Upload dan proses bisa lebih lama dari READ_TIMEOUT/WRITE_TIMEOUT
+	deadline := time.Now().Add(h.importTimeout)
+	controller := http.NewResponseController(c.Response())
+	_ = controller.SetReadDeadline(deadline)
+	_ = controller.SetWriteDeadline(deadline)
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		return invalidBody(c, "CSV file is required in form field \"file\"", err)
+	}
+	file, err := header.Open()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Failed to read uploaded file: " + err.Error(),
+		})
+	}
+	defer file.Close()
+
+	rows, rejected, err := ingest.ReadCSV(file, h.config.TransactionImportMaxRows)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	total := len(rows) + len(rejected)
+
+	ctx := c.Request().Context()
+	var valid []ingest.Row
+	var reqs []*repository.TransactionRequest
+	references := make(map[string]int) // reference -> line pertama
+	for _, row := range rows {
+		req, reason := h.validateImportRow(ctx, row, references)
+		if reason != "" {
+			rejected = append(rejected, ingest.RowError{Row: row, Error: reason})
+			continue
+		}
+		valid = append(valid, row)
+		reqs = append(reqs, req)
+	}
+
+	// Reference yang sudah diimport sebelumnya (file lain atau upload ulang) ditolak
+	claims, existing, err := h.claimImportReferences(ctx, valid)
+	if err != nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{
+			"error": "Database unavailable, please retry later",
+		})
+	}
+	var pending []ingest.Row
+	var pendingReqs []*repository.TransactionRequest
+	var pendingClaims []*repository.IngestRow
+	for i, row := range valid {
+		claim := claims[i]
+		if earlier, ok := existing[row.Reference]; ok && claim != nil {
+			rejected = append(rejected, ingest.RowError{Row: row, Error: importClaimError(earlier)})
+			continue
+		}
+		pending = append(pending, row)
+		pendingReqs = append(pendingReqs, reqs[i])
+		pendingClaims = append(pendingClaims, claim)
+	}
+
+	accepted := []importedTransaction{}
+	var recorded, released []repository.IngestRow
+	for i, result := range h.transactionService.ProcessBatch(ctx, pendingReqs) {
+		row := pending[i]
+		reason := ""
+		switch {
+		case errors.Is(result.Err, service.ErrDatabaseUnavailable):
+			reason = "Database unavailable, please retry later"
+		case errors.Is(result.Err, context.Canceled), errors.Is(result.Err, context.DeadlineExceeded):
+			reason = "Not processed, import interrupted"
+		case result.Err != nil:
+			reason = "Internal server error: " + result.Err.Error()
+		case !result.Response.Success:
+			reason = result.Response.Message
+		default:
+			accepted = append(accepted, importedTransaction{
+				Line:          row.Line,
+				Reference:     row.Reference,
+				TransactionID: result.Response.TransactionID,
+				Status:        result.Response.Status,
+			})
+		}
+		if reason != "" {
+			rejected = append(rejected, ingest.RowError{Row: row, Error: reason})
+		}
+		if claim := pendingClaims[i]; claim != nil {
+			if reason != "" {
+				// Baris yang ditolak boleh diimport ulang setelah diperbaiki
+				released = append(released, *claim)
+			} else {
+				claim.TransactionID = result.Response.TransactionID
+				recorded = append(recorded, *claim)
+			}
+		}
+	}
+	if len(recorded) > 0 || len(released) > 0 {
+		if err := h.imports.RecordRows(context.WithoutCancel(ctx), recorded, released); err != nil {
+			// Klaim tertinggal processing: upload ulang ditolak, tidak pernah dobel
+			log.Printf("Failed to record imported references: %v", err)
+		}
+	}
+	// Report urut baris file, apa pun alasan penolakannya
+	sort.Slice(rejected, func(i, j int) bool { return rejected[i].Line < rejected[j].Line })
+
+	if format == "csv" {
+		return writeImportReport(c, total, len(accepted), rejected)
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"total":        total,
+		"accepted":     len(accepted),
+		"rejected":     len(rejected),
+		"transactions": accepted,
+		"errors":       rejected,
+	})
+}
+
+// validateImportRow checks a row like ProcessTransaction checks its JSON body
+// and returns the request, or the reason the row is rejected. references
+// holds the line of every reference seen so far; a reference may only be
+// used once per file.
+func (h *TransactionHandler) validateImportRow(ctx context.Context, row ingest.Row, references map[string]int) (*repository.TransactionRequest, string) {
+	req, reason := row.Request()
+	if reason != "" {
+		return nil, reason
+	}
+	if row.Reference != "" {
+		if line, ok := references[row.Reference]; ok {
+			return nil, fmt.Sprintf("Duplicate reference, already used on line %d", line)
+		}
+		references[row.Reference] = row.Line
+	}
+	if err := auth.CheckAccount(ctx, row.AccountID); err != nil {
+		return nil, "Account not permitted for this credential"
+	}
+	return req, ""
+}
+
+// claimImportReferences claims the reference of every row that has one for
+// the tenant bound to ctx. It returns a claim per row, nil without a
+// reference, and the earlier claims of the references already imported.
+func (h *TransactionHandler) claimImportReferences(ctx context.Context, rows []ingest.Row) ([]*repository.IngestRow, map[string]repository.IngestRow, error) {
+	result := make([]*repository.IngestRow, len(rows))
+	if h.imports == nil {
+		return result, nil, nil
+	}
+
+	var claims []repository.IngestRow
+	var index []int
+	for i, row := range rows {
+		if row.Reference == "" {
+			continue
+		}
+		claims = append(claims, repository.IngestRow{TenantID: tenant.ID(ctx), Source: importIngestSource, Reference: row.Reference, Line: row.Line})
+		index = append(index, i)
+	}
+	if len(claims) == 0 {
+		return result, nil, nil
+	}
+	existing, err := h.imports.ClaimRows(ctx, claims)
+	if err != nil {
+		return nil, nil, err
+	}
+	for n := range claims {
+		result[index[n]] = &claims[n]
+	}
+	return result, existing, nil
+}
+
+// importClaimError is the reason a row is rejected because of the earlier
+// claim of its reference
+func importClaimError(claim repository.IngestRow) string {
+	if claim.Status == repository.IngestAccepted {
+		return fmt.Sprintf("Duplicate reference, already imported as transaction %s", claim.TransactionID)
+	}
+	return "An earlier import stopped while processing this reference; check the account before sending it again"
+}
+
+// writeImportReport sends the rejected rows as a CSV attachment, with the
+// counts in X-Import-* headers
+func writeImportReport(c echo.Context, total int, accepted int, rejected []ingest.RowError) error {
+	response := c.Response()
+	response.Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+	response.Header().Set(echo.HeaderContentDisposition, `attachment; filename="transaction-import-errors.csv"`)
+	response.Header().Set("X-Import-Total", strconv.Itoa(total))
+	response.Header().Set("X-Import-Accepted", strconv.Itoa(accepted))
+	response.Header().Set("X-Import-Rejected", strconv.Itoa(len(rejected)))
+	response.WriteHeader(http.StatusOK)
+
+	writer := csv.NewWriter(response)
+	_ = writer.Write(append([]string{"line"}, append(ingest.Columns, "error")...))
+	for _, row := range rejected {
+		_ = writer.Write([]string{strconv.Itoa(row.Line), row.AccountID, row.Amount, row.Type, row.Reference, row.Error})
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"sub-balance-demo/internal/config"
+	"sub-balance-demo/internal/repository"
+	"sub-balance-demo/internal/service"
+	"sub-balance-demo/internal/tenant"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// fakeBatch accepts every request of ProcessBatch except those of account
+// REJECT
+type fakeBatch struct {
+	service.TransactionService
+	processed []string // account_id per processed request
+}
+
+func (f *fakeBatch) ProcessBatch(ctx context.Context, reqs []*repository.TransactionRequest) []service.BatchResult {
+	results := make([]service.BatchResult, len(reqs))
+	for i, req := range reqs {
+		f.processed = append(f.processed, req.AccountID)
+		if req.AccountID == "REJECT" {
+			results[i].Response = &repository.TransactionResponse{Message: "Insufficient balance"}
+			continue
+		}
+		results[i].Response = &repository.TransactionResponse{Success: true, TransactionID: fmt.Sprintf("tx-%d", len(f.processed)), Status: "PENDING"}
+	}
+	return results
+}
+
+func newImportHandler(t *testing.T) (*TransactionHandler, *fakeBatch) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := db.AutoMigrate(&repository.IngestRow{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	transactions := &fakeBatch{}
+	return NewTransactionHandler(transactions, repository.NewIngestRepository(db), &config.Config{TransactionImportTimeout: "1m"}), transactions
+}
+
+// importCSV uploads body as tenantID and returns the JSON report
+func importCSV(t *testing.T, h *TransactionHandler, tenantID string, body string) (accepted int, errs []string) {
+	t.Helper()
+	var buf bytes.Buffer
+	form := multipart.NewWriter(&buf)
+	part, _ := form.CreateFormFile("file", "import.csv")
+	part.Write([]byte(body))
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/transactions/import", &buf)
+	req.Header.Set(echo.HeaderContentType, form.FormDataContentType())
+	req = req.WithContext(tenant.WithID(req.Context(), tenantID))
+	rec := httptest.NewRecorder()
+	if err := h.ImportTransactions(echo.New().NewContext(req, rec)); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("ImportTransactions = %v, status %d: %s", err, rec.Code, rec.Body)
+	}
+
+	var report struct {
+		Accepted int `json:"accepted"`
+		Errors   []struct {
+			Error string `json:"error"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatalf("decode report: %v", err)
+	}
+	for _, e := range report.Errors {
+		errs = append(errs, e.Error)
+	}
+	return report.Accepted, errs
+}
+
+func TestImportTransactionsRejectsImportedReference(t *testing.T) {
+	const file = "account_id,amount,type,reference\nACC001,10,credit,INV-1\nACC002,20,credit,INV-2\n"
+	tests := []struct {
+		name         string
+		tenant       string
+		body         string
+		wantAccepted int
+		wantErrs     []string
+	}{
+		{
+			name:     "same file again",
+			tenant:   "acme",
+			body:     file,
+			wantErrs: []string{"Duplicate reference, already imported as transaction tx-1", "Duplicate reference, already imported as transaction tx-2"},
+		},
+		{
+			name:         "reference in another file",
+			tenant:       "acme",
+			body:         "account_id,amount,type,reference\nACC003,5,credit,INV-2\nACC003,5,credit,INV-3\n",
+			wantAccepted: 1,
+			wantErrs:     []string{"Duplicate reference, already imported as transaction tx-2"},
+		},
+		{
+			name:         "same reference in another tenant",
+			tenant:       "globex",
+			body:         file,
+			wantAccepted: 2,
+		},
+		{
+			name:         "rows without reference",
+			tenant:       "acme",
+			body:         "account_id,amount,type\nACC001,10,credit\n",
+			wantAccepted: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, _ := newImportHandler(t)
+			if accepted, errs := importCSV(t, h, "acme", file); accepted != 2 || len(errs) != 0 {
+				t.Fatalf("first import accepted %d, errors %v", accepted, errs)
+			}
+
+			accepted, errs := importCSV(t, h, tt.tenant, tt.body)
+			if accepted != tt.wantAccepted || fmt.Sprint(errs) != fmt.Sprint(tt.wantErrs) {
+				t.Fatalf("accepted %d, errors %q; want %d, %q", accepted, errs, tt.wantAccepted, tt.wantErrs)
+			}
+		})
+	}
+}
+
+func TestImportTransactionsReleasesRejectedReference(t *testing.T) {
+	h, transactions := newImportHandler(t)
+	if accepted, errs := importCSV(t, h, "acme", "account_id,amount,type,reference\nREJECT,10,debit,INV-1\n"); accepted != 0 || len(errs) != 1 {
+		t.Fatalf("first import accepted %d, errors %v", accepted, errs)
+	}
+
+	// Baris yang sudah diperbaiki boleh memakai reference yang sama
+	if accepted, errs := importCSV(t, h, "acme", "account_id,amount,type,reference\nACC001,10,debit,INV-1\n"); accepted != 1 || len(errs) != 0 {
+		t.Fatalf("corrected import accepted %d, errors %v", accepted, errs)
+	}
+	if len(transactions.processed) != 2 {
+		t.Fatalf("processed %v, want both uploads", transactions.processed)
+	}
+}
@@ -0,0 +1,156 @@
+package ingest
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"sub-balance-demo/internal/repository"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/shopspring/decimal"
+)
+
+// Columns are the columns a transaction CSV may have, in report order;
+// reference is optional
+var Columns = []string{"account_id", "amount", "type", "reference"}
+
+// MaxReferenceLength bounds the reference column, like account_id
+const MaxReferenceLength = 64
+
+var validate = validator.New()
+
+// Row is a data row of a transaction CSV
+type Row struct {
+	Line      int    `json:"line"`
+	AccountID string `json:"account_id"`
+	Amount    string `json:"amount"`
+	Type      string `json:"type"`
+	Reference string `json:"reference,omitempty"`
+}
+
+// RowError is a rejected row and the reason
+type RowError struct {
+	Row
+	Error string `json:"error"`
+}
+
+// ReadCSV parses a transaction CSV. A header row names the columns, in any
+// order. Rows with the wrong number of fields are rejected on their own; a
+// CSV that cannot be parsed, has an unknown header or has more than maxRows
+// rows (0 = no limit) fails as a whole.
+func ReadCSV(r io.Reader, maxRows int) ([]Row, []RowError, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, nil, errors.New("CSV is empty")
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("Invalid CSV: %v", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if !slices.Contains(Columns, name) {
+			return nil, nil, fmt.Errorf("Unknown column %q, expected %s", name, strings.Join(Columns, ","))
+		}
+		if _, ok := columns[name]; ok {
+			return nil, nil, fmt.Errorf("Duplicate column %q", name)
+		}
+		columns[name] = i
+	}
+	for _, name := range Columns[:3] {
+		if _, ok := columns[name]; !ok {
+			return nil, nil, fmt.Errorf("Missing column %q", name)
+		}
+	}
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	var rows []Row
+	var rejected []RowError
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil && !errors.Is(err, csv.ErrFieldCount) {
+			return nil, nil, fmt.Errorf("Invalid CSV: %v", err)
+		}
+		if maxRows > 0 && len(rows)+len(rejected) >= maxRows {
+			return nil, nil, fmt.Errorf("CSV has more than %d rows, split it into smaller files", maxRows)
+		}
+		line, _ := reader.FieldPos(0)
+
+		row := Row{
+			Line:      line,
+			AccountID: field(record, "account_id"),
+			Amount:    field(record, "amount"),
+			Type:      field(record, "type"),
+			Reference: field(record, "reference"),
+		}
+		if err != nil {
+			rejected = append(rejected, RowError{
+				Row:   row,
+				Error: fmt.Sprintf("Expected %d columns, got %d", len(header), len(record)),
+			})
+			continue
+		}
+		rows = append(rows, row)
+	}
+	if len(rows)+len(rejected) == 0 {
+		return nil, nil, errors.New("CSV has no rows")
+	}
+	return rows, rejected, nil
+}
+
+// Request checks a row like POST /api/v1/transaction checks its JSON body
+// and returns the request, or the reason the row is rejected
+func (row Row) Request() (*repository.TransactionRequest, string) {
+	amount, err := decimal.NewFromString(row.Amount)
+	if err != nil {
+		return nil, "Invalid amount"
+	}
+	req := &repository.TransactionRequest{AccountID: row.AccountID, Amount: amount, Type: row.Type}
+	if err := validate.Struct(req); err != nil {
+		return nil, "Validation failed: " + err.Error()
+	}
+	if amount.LessThanOrEqual(decimal.Zero) {
+		return nil, "Amount must be greater than zero"
+	}
+	if len(row.Reference) > MaxReferenceLength {
+		return nil, fmt.Sprintf("Reference is longer than %d characters", MaxReferenceLength)
+	}
+	return req, ""
+}
+
+// ReportRow is one line of the processing report of a file
+type ReportRow struct {
+	Row
+	Status        string
+	TransactionID string
+	Error         string
+}
+
+// Report renders a processing report as CSV, one line per row
+func Report(rows []ReportRow) []byte {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	_ = writer.Write(append([]string{"line"}, append(Columns, "status", "transaction_id", "error")...))
+	for _, row := range rows {
+		_ = writer.Write([]string{strconv.Itoa(row.Line), row.AccountID, row.Amount, row.Type, row.Reference, row.Status, row.TransactionID, row.Error})
+	}
+	writer.Flush()
+	return buf.Bytes()
+}
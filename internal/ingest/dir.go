@@ -0,0 +1,74 @@
+package ingest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// dirSource reads input files from a local (or mounted) directory
+type dirSource struct {
+	dir       string
+	reportDir string
+}
+
+func newDirSource(dir string, options Options) (Source, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	return &dirSource{dir: dir, reportDir: filepath.Join(dir, options.ReportPrefix)}, nil
+}
+
+func (s *dirSource) String() string {
+	return "dir:" + s.dir
+}
+
+func (s *dirSource) List(ctx context.Context) ([]File, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []File
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !isInput(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue // dihapus setelah ReadDir
+		}
+		files = append(files, File{
+			Name:        entry.Name(),
+			Fingerprint: fmt.Sprintf("%d-%d", info.Size(), info.ModTime().UnixNano()),
+			ModTime:     info.ModTime(),
+		})
+	}
+	return files, nil
+}
+
+func (s *dirSource) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(s.dir, name))
+}
+
+// WriteReport writes the report next to a temporary file and renames it, so a
+// partner never reads half a report
+func (s *dirSource) WriteReport(ctx context.Context, name string, report []byte) error {
+	if err := os.MkdirAll(s.reportDir, 0o755); err != nil {
+		return err
+	}
+	path := filepath.Join(s.reportDir, reportName(name))
+	if err := os.WriteFile(path+".tmp", report, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
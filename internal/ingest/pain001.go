@@ -0,0 +1,203 @@
+package ingest
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+// Sides of a credit transfer a pain.001 file is booked on. Booking both
+// sides is not offered: the two transactions would not be atomic.
+const (
+	SideCreditor = "creditor" // credit ke account penerima
+	SideDebtor   = "debtor"   // debit dari account pengirim
+)
+
+// pain001NotProvided is the EndToEndId a debtor sends when it has none
+const pain001NotProvided = "NOTPROVIDED"
+
+// Pain001Mapping says how the credit transfers of an ISO 20022 pain.001
+// (customer credit transfer initiation) file become transactions
+type Pain001Mapping struct {
+	// Side is SideCreditor (default) or SideDebtor
+	Side string
+	// Currency rejects transfers in another currency; empty accepts any
+	Currency string
+	// Accounts maps the partner's account identifiers (IBAN, or Othr/Id) to
+	// account_id, ignoring case and spaces; identifiers missing from it are
+	// used as they are
+	Accounts map[string]string
+	// StrictAccounts rejects transfers with an account missing from Accounts
+	StrictAccounts bool
+}
+
+// Validate checks the mapping before any file is read
+func (m Pain001Mapping) Validate() error {
+	switch m.Side {
+	case "", SideCreditor, SideDebtor:
+	default:
+		return fmt.Errorf("invalid side %q, expected %s or %s", m.Side, SideCreditor, SideDebtor)
+	}
+	if m.StrictAccounts && len(m.Accounts) == 0 {
+		return errors.New("strict accounts needs an account mapping")
+	}
+	return nil
+}
+
+type pain001Account struct {
+	IBAN  string `xml:"Id>IBAN"`
+	Other string `xml:"Id>Othr>Id"`
+}
+
+// id is the account identifier, IBAN without spaces first
+func (a pain001Account) id() string {
+	if a.IBAN != "" {
+		return accountKey(a.IBAN)
+	}
+	return strings.TrimSpace(a.Other)
+}
+
+// accountKey normalizes an identifier for the Accounts lookup
+func accountKey(id string) string {
+	return strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(id), " ", ""))
+}
+
+type pain001Transfer struct {
+	InstrID    string `xml:"PmtId>InstrId"`
+	EndToEndID string `xml:"PmtId>EndToEndId"`
+	Amount     struct {
+		Value    string `xml:",chardata"`
+		Currency string `xml:"Ccy,attr"`
+	} `xml:"Amt>InstdAmt"`
+	CreditorAccount pain001Account `xml:"CdtrAcct"`
+}
+
+// ReadPain001 parses a pain.001 file (any version, namespaces are not
+// checked) into rows, one per CdtTrfTxInf. Line is the line of the
+// CdtTrfTxInf element and the reference is its EndToEndId, or
+// InstrId when the debtor did not provide one. A file that cannot be parsed,
+// is not a pain.001, has more than maxRows transfers (0 = no limit) or whose
+// group header NbOfTxs/CtrlSum do not match the transfers fails as a whole.
+func ReadPain001(r io.Reader, maxRows int, mapping Pain001Mapping) ([]Row, []RowError, error) {
+	accounts := make(map[string]string, len(mapping.Accounts))
+	for from, to := range mapping.Accounts {
+		accounts[accountKey(from)] = to
+	}
+	mapping.Accounts = accounts
+	decoder := xml.NewDecoder(r)
+
+	var header struct {
+		NbOfTxs string `xml:"NbOfTxs"`
+		CtrlSum string `xml:"CtrlSum"`
+	}
+	var debtor pain001Account
+	var rows []Row
+	var rejected []RowError
+	transfers := 0
+	sum := decimal.Zero
+	var path []string
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid XML: %v", err)
+		}
+		switch t := token.(type) {
+		case xml.EndElement:
+			path = path[:len(path)-1]
+			continue
+		case xml.StartElement:
+			parent := strings.Join(path, "/")
+			var err error
+			switch name := t.Name.Local; {
+			case parent == "" && name != "Document":
+				return nil, nil, fmt.Errorf("Not an ISO 20022 document, root element is %s", name)
+			case parent == "Document" && name != "CstmrCdtTrfInitn":
+				return nil, nil, fmt.Errorf("Not a pain.001 credit transfer initiation, found %s", name)
+			case parent == "Document/CstmrCdtTrfInitn" && name == "GrpHdr":
+				err = decoder.DecodeElement(&header, &t)
+			case parent == "Document/CstmrCdtTrfInitn" && name == "PmtInf":
+				debtor = pain001Account{}
+				path = append(path, name)
+			case parent == "Document/CstmrCdtTrfInitn/PmtInf" && name == "DbtrAcct":
+				err = decoder.DecodeElement(&debtor, &t)
+			case parent == "Document/CstmrCdtTrfInitn/PmtInf" && name == "CdtTrfTxInf":
+				if maxRows > 0 && transfers >= maxRows {
+					return nil, nil, fmt.Errorf("pain.001 file has more than %d transfers, split it into smaller files", maxRows)
+				}
+				line, _ := decoder.InputPos()
+				var transfer pain001Transfer
+				if err = decoder.DecodeElement(&transfer, &t); err != nil {
+					break
+				}
+				transfers++
+				if amount, err := decimal.NewFromString(strings.TrimSpace(transfer.Amount.Value)); err == nil {
+					sum = sum.Add(amount)
+				}
+				if row, reason := mapping.row(line, debtor, transfer); reason != "" {
+					rejected = append(rejected, RowError{Row: row, Error: reason})
+				} else {
+					rows = append(rows, row)
+				}
+			default:
+				path = append(path, name)
+			}
+			if err != nil {
+				return nil, nil, fmt.Errorf("Invalid XML: %v", err)
+			}
+		}
+	}
+
+	if header.NbOfTxs == "" {
+		return nil, nil, errors.New("Group header NbOfTxs is missing")
+	}
+	if transfers == 0 {
+		return nil, nil, errors.New("pain.001 file has no transfers")
+	}
+	if strings.TrimSpace(header.NbOfTxs) != fmt.Sprint(transfers) {
+		return nil, nil, fmt.Errorf("Group header NbOfTxs is %s but the file has %d transfers", strings.TrimSpace(header.NbOfTxs), transfers)
+	}
+	if header.CtrlSum != "" {
+		ctrlSum, err := decimal.NewFromString(strings.TrimSpace(header.CtrlSum))
+		if err != nil || !ctrlSum.Equal(sum) {
+			return nil, nil, fmt.Errorf("Group header CtrlSum is %s but the transfers add up to %s", strings.TrimSpace(header.CtrlSum), sum)
+		}
+	}
+	return rows, rejected, nil
+}
+
+// row maps one transfer to a row, or rejects it
+func (m Pain001Mapping) row(line int, debtor pain001Account, transfer pain001Transfer) (Row, string) {
+	row := Row{Line: line, Amount: strings.TrimSpace(transfer.Amount.Value), Type: "credit"}
+	row.Reference = strings.TrimSpace(transfer.EndToEndID)
+	if row.Reference == "" || row.Reference == pain001NotProvided {
+		row.Reference = strings.TrimSpace(transfer.InstrID)
+	}
+	account, party := transfer.CreditorAccount, "CdtrAcct"
+	if m.Side == SideDebtor {
+		account, party = debtor, "DbtrAcct"
+		row.Type = "debit"
+	}
+	id := account.id()
+	accountID, mapped := m.Accounts[accountKey(id)]
+	if !mapped {
+		accountID = id
+	}
+	row.AccountID = accountID
+
+	switch currency := transfer.Amount.Currency; {
+	case m.Currency != "" && !strings.EqualFold(currency, m.Currency):
+		return row, fmt.Sprintf("Currency %s, expected %s", currency, strings.ToUpper(m.Currency))
+	case id == "":
+		return row, party + " has no IBAN or Othr/Id"
+	case !mapped && m.StrictAccounts:
+		return row, fmt.Sprintf("%s %s is not mapped to an account", party, id)
+	}
+	return row, ""
+}
@@ -0,0 +1,171 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"sub-balance-demo/internal/awsauth"
+)
+
+// s3Timeout bounds list and upload calls; downloads are bounded by ctx only
+// because large files take longer
+const s3Timeout = 30 * time.Second
+
+// s3Source reads input files from an S3 prefix through the REST API, signed by
+// awsauth
+type s3Source struct {
+	bucket       string
+	prefix       string
+	reportPrefix string
+	region       string
+	endpoint     string // kosong = virtual-hosted AWS endpoint
+	client       *http.Client
+	credentials  *awsauth.Provider
+}
+
+func newS3Source(location string, options Options) (Source, error) {
+	u, err := url.Parse(location)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid S3 location %q, expected s3://bucket/prefix/", location)
+	}
+	s := &s3Source{
+		bucket:   u.Host,
+		prefix:   strings.TrimPrefix(u.Path, "/"),
+		region:   awsauth.Region(),
+		endpoint: strings.TrimSuffix(options.S3Endpoint, "/"),
+		client:   &http.Client{},
+	}
+	if s.prefix != "" && !strings.HasSuffix(s.prefix, "/") {
+		s.prefix += "/"
+	}
+	s.reportPrefix = s.prefix + options.ReportPrefix
+	if s.region == "" {
+		return nil, errors.New("AWS_REGION is required for S3 ingestion")
+	}
+	credentials, err := awsauth.FromEnv(s.region, "", &http.Client{Timeout: s3Timeout})
+	if err != nil {
+		return nil, fmt.Errorf("%w for S3 ingestion", err)
+	}
+	s.credentials = credentials
+	return s, nil
+}
+
+func (s *s3Source) String() string {
+	return "s3://" + s.bucket + "/" + s.prefix
+}
+
+// List calls ListObjectsV2 with delimiter "/", so objects under the report
+// prefix (or any other "sub-directory") are not listed
+func (s *s3Source) List(ctx context.Context) ([]File, error) {
+	ctx, cancel := context.WithTimeout(ctx, s3Timeout)
+	defer cancel()
+
+	var files []File
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {s.prefix}, "delimiter": {"/"}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil, "")
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Contents []struct {
+				Key          string    `xml:"Key"`
+				ETag         string    `xml:"ETag"`
+				LastModified time.Time `xml:"LastModified"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode ListObjectsV2 response: %w", err)
+		}
+		for _, object := range result.Contents {
+			name := strings.TrimPrefix(object.Key, s.prefix)
+			if !isInput(name) {
+				continue
+			}
+			files = append(files, File{Name: name, Fingerprint: strings.Trim(object.ETag, `"`), ModTime: object.LastModified})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return files, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (s *s3Source) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, s.prefix+name, nil, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *s3Source) WriteReport(ctx context.Context, name string, report []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, s3Timeout)
+	defer cancel()
+	resp, err := s.do(ctx, http.MethodPut, s.reportPrefix+reportName(name), nil, report, "text/csv")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// do sends a signed request for key (empty = the bucket) and returns the
+// response when it is 200, or an error with S3's error code
+func (s *s3Source) do(ctx context.Context, method string, key string, query url.Values, body []byte, contentType string) (*http.Response, error) {
+	u := &url.URL{Scheme: "https", Host: s.bucket + ".s3." + s.region + ".amazonaws.com", Path: "/" + key}
+	if s.endpoint != "" {
+		endpoint, err := url.Parse(s.endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("invalid S3 endpoint: %w", err)
+		}
+		u = &url.URL{Scheme: endpoint.Scheme, Host: endpoint.Host, Path: "/" + s.bucket + "/" + key}
+	}
+	u.RawPath = awsauth.EscapePath(u.Path)
+	u.RawQuery = awsauth.EncodeQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	payloadHash := awsauth.PayloadHash(body)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	credentials, err := s.credentials.Current(ctx)
+	if err != nil {
+		return nil, err
+	}
+	awsauth.Sign(req, payloadHash, "s3", s.region, credentials, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var failure struct {
+			Code string `xml:"Code"`
+		}
+		_ = xml.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&failure)
+		return nil, fmt.Errorf("S3 %s %s returned status %d %s", method, key, resp.StatusCode, failure.Code)
+	}
+	return resp, nil
+}
@@ -0,0 +1,68 @@
+// Package ingest reads transaction files: CSVs and ISO 20022 pain.001 XML
+// partners drop in a directory or S3 prefix for the ingestion worker, and the
+// CSV uploads of POST /api/v1/transactions/import.
+package ingest
+
+import (
+	"context"
+	"io"
+	"strings"
+	"time"
+)
+
+// File is an input file waiting in a source
+type File struct {
+	Name string // relatif terhadap directory/prefix source
+	// Fingerprint changes whenever the content may have changed: size and
+	// modification time of a local file, ETag of an S3 object
+	Fingerprint string
+	ModTime     time.Time
+}
+
+// Source is a location partners drop CSV and pain.001 files in. Only files directly in it
+// are listed; reports are written under a sub-directory/prefix so they are
+// never picked up as input.
+type Source interface {
+	// String identifies the source, e.g. "dir:/data/partner" or
+	// "s3://bucket/partner/"
+	String() string
+	List(ctx context.Context) ([]File, error)
+	Open(ctx context.Context, name string) (io.ReadCloser, error)
+	WriteReport(ctx context.Context, name string, report []byte) error
+}
+
+// Options configure NewSource
+type Options struct {
+	// ReportPrefix is where reports go, relative to the source
+	ReportPrefix string
+	// S3Endpoint replaces the AWS endpoint, with path-style URLs (MinIO,
+	// LocalStack); empty uses https://<bucket>.s3.<region>.amazonaws.com
+	S3Endpoint string
+}
+
+// NewSource opens location: s3://bucket/prefix/ or a directory path
+func NewSource(location string, options Options) (Source, error) {
+	if strings.HasPrefix(location, "s3://") {
+		return newS3Source(location, options)
+	}
+	return newDirSource(location, options)
+}
+
+// reportName is the report of the input file name
+func reportName(name string) string {
+	return strings.TrimSuffix(name, ".csv") + ".report.csv"
+}
+
+// isInput reports whether name is an input file
+func isInput(name string) bool {
+	return isCSV(name) || IsPain001(name)
+}
+
+func isCSV(name string) bool {
+	return strings.HasSuffix(strings.ToLower(name), ".csv")
+}
+
+// IsPain001 reports whether name is read as a pain.001 file rather than a CSV
+func IsPain001(name string) bool {
+	return strings.HasSuffix(strings.ToLower(name), ".xml")
+}
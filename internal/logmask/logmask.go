@@ -0,0 +1,114 @@
+// Package logmask keeps account identifiers, and optionally amounts, out of
+// the application logs. Log lines pass every account ID through Account and
+// every amount through Amount; what comes out depends on the policy set once
+// at startup with Configure, before any goroutine logs.
+package logmask
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"sub-balance-demo/internal/bodylog"
+
+	"github.com/shopspring/decimal"
+)
+
+// Mode akun ID di log (LOG_ACCOUNT_IDS)
+const (
+	AccountsHash  = "hash"  // short SHA-256, sama dengan BODY_LOG_HASH_FIELDS
+	AccountsMask  = "mask"  // hanya 4 karakter terakhir
+	AccountsPlain = "plain" // apa adanya
+)
+
+// Mode nominal di log (LOG_AMOUNTS)
+const (
+	AmountsPlain  = "plain"
+	AmountsRedact = "redact"
+)
+
+const redacted = "[REDACTED]"
+
+var (
+	accounts = AccountsPlain
+	amounts  = AmountsPlain
+)
+
+// Configure sets the policy. An unknown mode is an error and leaves the
+// policy unchanged.
+func Configure(accountMode string, amountMode string) error {
+	switch accountMode {
+	case AccountsHash, AccountsMask, AccountsPlain:
+	default:
+		return fmt.Errorf("unknown account ID mode %q", accountMode)
+	}
+	switch amountMode {
+	case AmountsPlain, AmountsRedact:
+	default:
+		return fmt.Errorf("unknown amount mode %q", amountMode)
+	}
+	accounts, amounts = accountMode, amountMode
+	return nil
+}
+
+// Enabled reports whether anything is masked, e.g. to decide whether SQL may
+// be logged with its parameters
+func Enabled() bool {
+	return accounts != AccountsPlain || amounts != AmountsPlain
+}
+
+// Account returns id as it may appear in a log line. Hashes match the ones
+// body logging writes, so both logs can be correlated.
+func Account(id string) string {
+	switch accounts {
+	case AccountsHash:
+		return bodylog.Hash(id)
+	case AccountsMask:
+		if len(id) <= 4 {
+			return "****"
+		}
+		return "****" + id[len(id)-4:]
+	}
+	return id
+}
+
+// Amount returns amount as it may appear in a log line
+func Amount(amount decimal.Decimal) string {
+	if amounts == AmountsRedact {
+		return redacted
+	}
+	return amount.String()
+}
+
+// URI returns the request URI of u with the account ID path parameter
+// pathAccountID (the last segment, as in /api/v1/balance/:account_id) and any
+// account_id query parameter passed through Account, for access logs
+func URI(u *url.URL, pathAccountID string) string {
+	if accounts == AccountsPlain {
+		return u.RequestURI()
+	}
+	uri := u.EscapedPath()
+	if escaped := url.PathEscape(pathAccountID); pathAccountID != "" && strings.HasSuffix(uri, "/"+escaped) {
+		uri = strings.TrimSuffix(uri, escaped) + escape(url.PathEscape, pathAccountID)
+	}
+	if u.RawQuery == "" {
+		return uri
+	}
+	params := strings.Split(u.RawQuery, "&")
+	for i, param := range params {
+		if value, ok := strings.CutPrefix(param, "account_id="); ok {
+			id, err := url.QueryUnescape(value)
+			if err != nil {
+				id = value
+			}
+			params[i] = "account_id=" + escape(url.QueryEscape, id)
+		}
+	}
+	return uri + "?" + strings.Join(params, "&")
+}
+
+// escape passes id through Account and escapes what is left of it, keeping
+// the mask readable
+func escape(escapeFunc func(string) string, id string) string {
+	return strings.ReplaceAll(escapeFunc(Account(id)), "%2A", "*")
+}
@@ -0,0 +1,259 @@
+package metrics
+
+import (
+	"time"
+
+	"sub-balance-demo/internal/buildinfo"
+	"sub-balance-demo/internal/service"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func desc(name, help string, labels ...string) *prometheus.Desc {
+	return prometheus.NewDesc(prometheus.BuildFQName(namespace, "", name), help, labels, nil)
+}
+
+var (
+	buildInfo     = desc("build_info", "Always 1; labels describe the running binary.", "version", "commit", "build_date", "go_version")
+	uptimeSeconds = desc("uptime_seconds", "Seconds since the process started.")
+
+	transactions       = desc("transactions_total", "Incoming transactions, by type, final status and processing path.", "type", "status", "path")
+	transactionAmount  = desc("transaction_amount_total", "Sum of accepted transaction amounts, by type.", "type")
+	transactionRejects = desc("transaction_rejections_total", "Rejected transactions, by reason.", "reason")
+	accountsCreated    = desc("accounts_created_total", "Accounts created.")
+	accountTxns        = desc("account_transactions_total", "Incoming transactions of the busiest accounts (METRICS_ACCOUNT_LABEL=top), by result; the rest are account_id=\"other\".", "account_id", "result")
+	accountTxnAmount   = desc("account_transaction_amount_total", "Sum of accepted amounts of the busiest accounts; the rest are account_id=\"other\".", "account_id")
+
+	settlementRuns         = desc("settlement_runs_total", "Settlement worker runs that found pending transactions.")
+	settlementRunErrors    = desc("settlement_run_errors_total", "Settlement runs that failed to read pending transactions or were interrupted.")
+	settlementRunDuration  = desc("settlement_run_duration_seconds", "Duration of settlement runs that found pending transactions.")
+	settlementAccounts     = desc("settlement_accounts_total", "Accounts processed by settlement, by result.", "result")
+	settlementTransactions = desc("settlement_transactions_settled_total", "Pending transactions settled.")
+	settlementRejected     = desc("settlement_transactions_rejected_total", "Pending transactions rejected because settling them would overdraw the account.")
+	settlementWorkers      = desc("settlement_workers", "Configured settlement workers.")
+	settlementBatchSize    = desc("settlement_batch_size", "Pending transactions read per settlement batch.")
+	settlementLastRun      = desc("settlement_last_run_timestamp_seconds", "Unix time the last settlement run finished.")
+	settlementLastSuccess  = desc("settlement_last_success_timestamp_seconds", "Unix time settlement last completed a run or found nothing pending.")
+	settlementLastDuration = desc("settlement_last_run_duration_seconds", "Duration of the last settlement run.")
+	settlementLastBatches  = desc("settlement_last_run_batches", "Batches read by the last settlement run.")
+	settlementLastTPS      = desc("settlement_last_run_tps", "Transactions settled per second in the last run.")
+
+	redisUp               = desc("redis_up", "1 while the Redis health check passes.")
+	redisCommands         = desc("redis_commands_total", "Redis commands sent, by command.", "command")
+	redisCommandErrors    = desc("redis_command_errors_total", "Redis commands that failed, by command.", "command")
+	redisCommandSeconds   = desc("redis_command_duration_seconds_total", "Time spent in Redis commands, by command.", "command")
+	redisCounterOps       = desc("redis_counter_operations_total", "Pending counter operations, by operation.", "operation")
+	redisCounterErrors    = desc("redis_counter_operation_errors_total", "Pending counter operations that failed, by operation.", "operation")
+	redisCounterSeconds   = desc("redis_counter_operation_duration_seconds_total", "Time spent in pending counter operations, by operation.", "operation")
+	redisOverspend        = desc("redis_counter_overspend_rejections_total", "Transactions rejected by the Redis overspend check.")
+	redisMemoryUsed       = desc("redis_memory_used_bytes", "Redis used_memory at the last memory guard check.")
+	redisMemoryMax        = desc("redis_memory_max_bytes", "Redis maxmemory (0 = no limit).")
+	redisMemoryPressure   = desc("redis_memory_under_pressure", "1 while new transactions are routed to the database because of Redis memory pressure.")
+	databaseUp            = desc("database_up", "1 while the database health check passes.")
+	slowQueries           = desc("database_slow_queries_total", "Queries at or above the slow-query threshold.")
+	slowQueryMax          = desc("database_slow_query_max_duration_seconds", "Slowest query seen since start.")
+	balanceCacheLookups   = desc("balance_cache_lookups_total", "Balance cache lookups, by result.", "result")
+	balanceCacheErrors    = desc("balance_cache_errors_total", "Redis errors while reading or writing the balance cache.")
+	circuitBreakerState   = desc("circuit_breaker_state", "1 for the current circuit breaker state.", "state")
+	circuitBreakerFailure = desc("circuit_breaker_failures", "Consecutive failures counted by the circuit breaker.")
+	circuitBreakerChanges = desc("circuit_breaker_transitions_total", "Circuit breaker state changes, by from/to state and reason.", "from", "to", "reason")
+	httpPanics            = desc("http_panics_total", "Handler panics recovered, by route template.", "route")
+	authFailures          = desc("auth_failures_total", "Failed authentications, by reason.", "reason")
+	authBlocks            = desc("auth_blocks_total", "Times a client IP was blocked for repeated invalid credentials.")
+	authBlockedRequests   = desc("auth_blocked_requests_total", "Requests rejected because their client IP was blocked.")
+	authBlockedSources    = desc("auth_blocked_sources", "Client IPs blocked right now.")
+	adminLockouts         = desc("admin_lockouts_total", "Times a client IP was locked out of the admin endpoints by this instance.")
+	adminLockedRequests   = desc("admin_locked_requests_total", "Admin requests rejected because their client IP was locked out.")
+	balanceWatchers       = desc("grpc_balance_watchers", "Open WatchBalance gRPC streams.")
+	webhookDeliveries     = desc("webhook_delivery_attempts_total", "Webhook delivery attempts made by this instance, by result (delivered, retry, dead_lettered).", "result")
+	ingestRows            = desc("ingest_rows_total", "Settlement file rows processed by the ingestion worker, by result (accepted, rejected, skipped, retry).", "result")
+	ingestFilesFailed     = desc("ingest_files_failed_total", "Settlement files that could not be parsed or still had failing rows after the last attempt.")
+	kafkaIngestMessages   = desc("kafka_ingest_messages_total", "Kafka ingestion messages, by result (accepted, duplicate, dead_lettered, retry).", "result")
+	lockFailOpen          = desc("distributed_lock_fail_open_total", "Account operations run without the distributed lock because Redis was unreachable.")
+)
+
+var circuitBreakerStates = []service.CircuitBreakerState{service.StateClosed, service.StateOpen, service.StateHalfOpen}
+
+// serviceCollector turns the stats the services already keep into metrics at
+// scrape time, so the hot path does not pay for a second set of counters
+type serviceCollector struct {
+	sources Sources
+}
+
+func newServiceCollector(sources Sources) *serviceCollector {
+	return &serviceCollector{sources: sources}
+}
+
+func (c *serviceCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(c, ch)
+}
+
+func (c *serviceCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.sources
+
+	ch <- gauge(buildInfo, 1, s.Build.Version, s.Build.Commit, s.Build.BuildDate, s.Build.GoVersion)
+	ch <- gauge(uptimeSeconds, buildinfo.Uptime().Seconds())
+
+	if s.Transactions != nil {
+		business := s.Transactions.GetTransactionStats()
+		for _, count := range business.Transactions {
+			ch <- counter(transactions, float64(count.Count), count.Type, count.Status, count.Path)
+		}
+		for txType, amount := range business.AcceptedAmount {
+			ch <- counter(transactionAmount, amount.InexactFloat64(), txType)
+		}
+		for reason, count := range business.Rejections {
+			ch <- counter(transactionRejects, float64(count), reason)
+		}
+		ch <- counter(accountsCreated, float64(business.AccountsCreated))
+		for _, account := range business.Accounts {
+			ch <- counter(accountTxns, float64(account.Accepted), account.AccountID, "accepted")
+			ch <- counter(accountTxns, float64(account.Rejected), account.AccountID, "rejected")
+			ch <- counter(accountTxnAmount, account.AcceptedAmount.InexactFloat64(), account.AccountID)
+		}
+
+		stats := s.Transactions.GetSettlementStats()
+		ch <- counter(settlementRuns, float64(stats.Runs))
+		ch <- counter(settlementRunErrors, float64(stats.RunErrors))
+		ch <- prometheus.MustNewConstSummary(settlementRunDuration, uint64(stats.Runs), stats.TotalRunSeconds, nil)
+		ch <- counter(settlementAccounts, float64(stats.AccountsSettled), "settled")
+		ch <- counter(settlementAccounts, float64(stats.AccountsFailed), "failed")
+		ch <- counter(settlementTransactions, float64(stats.TransactionsSettled))
+		ch <- counter(settlementRejected, float64(stats.TransactionsRejected))
+		ch <- gauge(settlementWorkers, float64(stats.Workers))
+		ch <- gauge(settlementBatchSize, float64(stats.BatchSize))
+		if !stats.LastRunAt.IsZero() {
+			ch <- gauge(settlementLastRun, float64(stats.LastRunAt.Unix()))
+		}
+		if !stats.LastSuccessAt.IsZero() {
+			ch <- gauge(settlementLastSuccess, float64(stats.LastSuccessAt.Unix()))
+		}
+		if duration, err := time.ParseDuration(stats.LastRunDuration); err == nil {
+			ch <- gauge(settlementLastDuration, duration.Seconds())
+		}
+		ch <- gauge(settlementLastBatches, float64(stats.LastRunBatches))
+		ch <- gauge(settlementLastTPS, stats.LastRunTPS)
+	}
+
+	if s.RedisHealth != nil {
+		ch <- gauge(redisUp, boolValue(s.RedisHealth.IsHealthy()))
+	}
+	if s.RedisCommands != nil {
+		for command, stats := range s.RedisCommands.Snapshot() {
+			ch <- counter(redisCommands, float64(stats.Calls), command)
+			ch <- counter(redisCommandErrors, float64(stats.Errors), command)
+			ch <- counter(redisCommandSeconds, stats.TotalLatencyMs/1000, command)
+		}
+	}
+	if s.RedisCounter != nil {
+		stats := s.RedisCounter.Stats()
+		for operation, op := range stats.Operations {
+			ch <- counter(redisCounterOps, float64(op.Calls), operation)
+			ch <- counter(redisCounterErrors, float64(op.Errors), operation)
+			ch <- counter(redisCounterSeconds, op.TotalLatencyMs/1000, operation)
+		}
+		ch <- counter(redisOverspend, float64(stats.OverspendRejections))
+	}
+	if s.RedisMemory != nil {
+		stats := s.RedisMemory.Stats()
+		ch <- gauge(redisMemoryUsed, float64(stats.UsedMemory))
+		ch <- gauge(redisMemoryMax, float64(stats.MaxMemory))
+		ch <- gauge(redisMemoryPressure, boolValue(stats.UnderPressure))
+	}
+
+	if s.DBHealth != nil {
+		ch <- gauge(databaseUp, boolValue(s.DBHealth.IsHealthy()))
+	}
+	if s.SlowQueries != nil {
+		stats := s.SlowQueries.Stats()
+		ch <- counter(slowQueries, float64(stats.Count))
+		ch <- gauge(slowQueryMax, stats.MaxDurationMs/1000)
+	}
+
+	if s.BalanceCache != nil {
+		stats := s.BalanceCache.Stats()
+		ch <- counter(balanceCacheLookups, float64(stats.Hits), "hit")
+		ch <- counter(balanceCacheLookups, float64(stats.Misses), "miss")
+		ch <- counter(balanceCacheErrors, float64(stats.Errors))
+	}
+
+	if s.CircuitBreaker != nil {
+		current := s.CircuitBreaker.GetState()
+		for _, state := range circuitBreakerStates {
+			ch <- gauge(circuitBreakerState, boolValue(state == current), string(state))
+		}
+		ch <- gauge(circuitBreakerFailure, float64(s.CircuitBreaker.GetFailureCount()))
+		for _, t := range s.CircuitBreaker.Transitions() {
+			ch <- counter(circuitBreakerChanges, float64(t.Count), string(t.From), string(t.To), t.Reason)
+		}
+	}
+
+	if s.Panics != nil {
+		for route, count := range s.Panics.Panics() {
+			ch <- counter(httpPanics, float64(count), route)
+		}
+	}
+
+	if s.AuthFailures != nil {
+		stats := s.AuthFailures.Stats()
+		for reason, count := range stats.Failures {
+			ch <- counter(authFailures, float64(count), reason)
+		}
+		ch <- counter(authBlocks, float64(stats.Blocks))
+		ch <- counter(authBlockedRequests, float64(stats.BlockedRequests))
+		ch <- gauge(authBlockedSources, float64(stats.BlockedSources))
+	}
+
+	if s.AdminLockout != nil {
+		stats := s.AdminLockout.Stats()
+		ch <- counter(adminLockouts, float64(stats.Lockouts))
+		ch <- counter(adminLockedRequests, float64(stats.LockedRequests))
+	}
+
+	if s.BalanceWatch != nil {
+		ch <- gauge(balanceWatchers, float64(s.BalanceWatch.Count()))
+	}
+
+	if s.Webhooks != nil {
+		stats := s.Webhooks.Stats()
+		ch <- counter(webhookDeliveries, float64(stats.Delivered), "delivered")
+		ch <- counter(webhookDeliveries, float64(stats.FailedTries), "retry")
+		ch <- counter(webhookDeliveries, float64(stats.DeadLettered), "dead_lettered")
+	}
+
+	if s.Ingestion != nil {
+		stats := s.Ingestion.Stats()
+		ch <- counter(ingestRows, float64(stats.Accepted), "accepted")
+		ch <- counter(ingestRows, float64(stats.Rejected), "rejected")
+		ch <- counter(ingestRows, float64(stats.Skipped), "skipped")
+		ch <- counter(ingestRows, float64(stats.Retried), "retry")
+		ch <- counter(ingestFilesFailed, float64(stats.Failed))
+	}
+
+	if s.KafkaIngestion != nil {
+		stats := s.KafkaIngestion.Stats()
+		ch <- counter(kafkaIngestMessages, float64(stats.Accepted), "accepted")
+		ch <- counter(kafkaIngestMessages, float64(stats.Duplicates), "duplicate")
+		ch <- counter(kafkaIngestMessages, float64(stats.DeadLettered), "dead_lettered")
+		ch <- counter(kafkaIngestMessages, float64(stats.Retried), "retry")
+	}
+
+	if s.AccountLock != nil {
+		ch <- counter(lockFailOpen, float64(s.AccountLock.FailOpenCount()))
+	}
+}
+
+func counter(desc *prometheus.Desc, value float64, labels ...string) prometheus.Metric {
+	return prometheus.MustNewConstMetric(desc, prometheus.CounterValue, value, labels...)
+}
+
+func gauge(desc *prometheus.Desc, value float64, labels ...string) prometheus.Metric {
+	return prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value, labels...)
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
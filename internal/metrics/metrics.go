@@ -0,0 +1,139 @@
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"sub-balance-demo/internal/auth"
+	"sub-balance-demo/internal/buildinfo"
+	"sub-balance-demo/internal/recovery"
+	"sub-balance-demo/internal/service"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/redis/go-redis/v9"
+)
+
+const namespace = "subbalance"
+
+// Sources are the components whose stats are read on every scrape. Nil fields
+// are skipped, so optional components (memory guard, balance cache) can be
+// left out.
+type Sources struct {
+	Transactions   service.TransactionService
+	RedisCommands  *service.RedisMetricsHook
+	RedisCounter   *service.InstrumentedCounter
+	RedisHealth    *service.RedisHealthChecker
+	RedisMemory    *service.RedisMemoryGuard
+	DBHealth       *service.DBHealthChecker
+	SlowQueries    *service.SlowQueryLogger
+	BalanceCache   *service.BalanceCache
+	CircuitBreaker *service.CircuitBreaker
+	Panics         *recovery.Stats
+	AuthFailures   *auth.FailureTracker
+	AdminLockout   *auth.AdminLockout
+	Webhooks       *service.WebhookService
+	Ingestion      *service.IngestionWorker
+	KafkaIngestion *service.KafkaIngestion
+	BalanceWatch   *service.BalanceWatchers
+	AccountLock    *service.DistributedLock
+	Build          buildinfo.Info
+	// Connection pools by name (primary, replica address)
+	RedisPools map[string]*redis.Client
+	PgxPools   map[string]*pgxpool.Pool
+}
+
+// Registry is the Prometheus registry of the service plus the HTTP metrics
+// recorded by Middleware
+type Registry struct {
+	registry        *prometheus.Registry
+	requestDuration *prometheus.HistogramVec
+	inFlight        *prometheus.GaugeVec
+}
+
+// httpBuckets start at 1ms: most GET /balance and Redis-path transactions
+// finish well below DefBuckets' first 5ms bucket
+var httpBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// unmatchedRoute labels requests that matched no route, so random paths
+// cannot blow up label cardinality
+const unmatchedRoute = "unmatched"
+
+// NewRegistry registers the Go runtime, process, connection pool and service
+// collectors. replica may be nil. Besides the go_memstats_* defaults, the Go
+// collector exports the runtime/metrics GC and scheduler series (GC pause and
+// scheduling latency histograms).
+func NewRegistry(sources Sources, primary *sql.DB, replica *sql.DB) *Registry {
+	r := &Registry{
+		registry: prometheus.NewRegistry(),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latency by method, route template and status code.",
+			Buckets:   httpBuckets,
+		}, []string{"method", "route", "code"}),
+		inFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "http_requests_in_flight",
+			Help:      "HTTP requests currently being served, by method and route template.",
+		}, []string{"method", "route"}),
+	}
+
+	r.registry.MustRegister(
+		collectors.NewGoCollector(collectors.WithGoCollectorRuntimeMetrics(collectors.MetricsGC, collectors.MetricsScheduler)),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		collectors.NewDBStatsCollector(primary, "primary"),
+		r.requestDuration,
+		r.inFlight,
+		newServiceCollector(sources),
+		&poolCollector{redisPools: sources.RedisPools, pgxPools: sources.PgxPools},
+	)
+	if replica != nil {
+		r.registry.MustRegister(collectors.NewDBStatsCollector(replica, "replica"))
+	}
+	return r
+}
+
+// Handler serves the registry in the Prometheus exposition format
+func (r *Registry) Handler() http.Handler {
+	return promhttp.HandlerFor(r.registry, promhttp.HandlerOpts{Registry: r.registry})
+}
+
+// Middleware observes every request's latency per route template
+// (/api/v1/balance/:account_id, not the raw path) and tracks in-flight requests
+func (r *Registry) Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			method := c.Request().Method
+			route := c.Path()
+			if route == "" {
+				route = unmatchedRoute
+			}
+
+			inFlight := r.inFlight.WithLabelValues(method, route)
+			inFlight.Inc()
+			defer inFlight.Dec()
+
+			start := time.Now()
+			err := next(c)
+
+			// Error yang belum ditulis ke response dirender belakangan oleh
+			// HTTPErrorHandler; ambil status code dari error-nya
+			status := c.Response().Status
+			if err != nil && !c.Response().Committed {
+				status = http.StatusInternalServerError
+				if he, ok := err.(*echo.HTTPError); ok {
+					status = he.Code
+				}
+			}
+
+			r.requestDuration.WithLabelValues(method, route, strconv.Itoa(status)).Observe(time.Since(start).Seconds())
+			return err
+		}
+	}
+}
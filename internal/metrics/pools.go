@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	redisPoolHits        = desc("redis_pool_hits_total", "Times a free connection was found in the Redis pool.", "pool")
+	redisPoolMisses      = desc("redis_pool_misses_total", "Times no free connection was found in the Redis pool.", "pool")
+	redisPoolTimeouts    = desc("redis_pool_timeouts_total", "Times waiting for a Redis pool connection timed out.", "pool")
+	redisPoolStale       = desc("redis_pool_stale_connections_total", "Stale connections removed from the Redis pool.", "pool")
+	redisPoolConns       = desc("redis_pool_connections", "Connections in the Redis pool.", "pool")
+	redisPoolIdle        = desc("redis_pool_idle_connections", "Idle connections in the Redis pool.", "pool")
+	redisPoolMax         = desc("redis_pool_max_connections", "Configured Redis pool size.", "pool")
+	pgxPoolConns         = desc("pgx_pool_connections", "Connections in the pgx hot-path pool, by state.", "pool", "state")
+	pgxPoolMax           = desc("pgx_pool_max_connections", "Configured pgx pool size.", "pool")
+	pgxPoolAcquires      = desc("pgx_pool_acquires_total", "Successful connection acquires from the pgx pool.", "pool")
+	pgxPoolEmptyAcquires = desc("pgx_pool_empty_acquires_total", "Acquires that had to wait because the pgx pool had no idle connection.", "pool")
+	pgxPoolCanceled      = desc("pgx_pool_canceled_acquires_total", "Acquires canceled by their context while waiting.", "pool")
+	pgxPoolAcquireWait   = desc("pgx_pool_acquire_duration_seconds_total", "Time spent acquiring pgx pool connections.", "pool")
+)
+
+// poolCollector exports the client-side stats of the Redis and pgx
+// connection pools, keyed by pool name (primary, replica address). The
+// database/sql pools are covered by the go_sql_* collector.
+type poolCollector struct {
+	redisPools map[string]*redis.Client
+	pgxPools   map[string]*pgxpool.Pool
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(c, ch)
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	for name, client := range c.redisPools {
+		stats := client.PoolStats()
+		ch <- counter(redisPoolHits, float64(stats.Hits), name)
+		ch <- counter(redisPoolMisses, float64(stats.Misses), name)
+		ch <- counter(redisPoolTimeouts, float64(stats.Timeouts), name)
+		ch <- counter(redisPoolStale, float64(stats.StaleConns), name)
+		ch <- gauge(redisPoolConns, float64(stats.TotalConns), name)
+		ch <- gauge(redisPoolIdle, float64(stats.IdleConns), name)
+		ch <- gauge(redisPoolMax, float64(client.Options().PoolSize), name)
+	}
+
+	for name, pool := range c.pgxPools {
+		stat := pool.Stat()
+		ch <- gauge(pgxPoolConns, float64(stat.AcquiredConns()), name, "acquired")
+		ch <- gauge(pgxPoolConns, float64(stat.IdleConns()), name, "idle")
+		ch <- gauge(pgxPoolConns, float64(stat.ConstructingConns()), name, "constructing")
+		ch <- gauge(pgxPoolMax, float64(stat.MaxConns()), name)
+		ch <- counter(pgxPoolAcquires, float64(stat.AcquireCount()), name)
+		ch <- counter(pgxPoolEmptyAcquires, float64(stat.EmptyAcquireCount()), name)
+		ch <- counter(pgxPoolCanceled, float64(stat.CanceledAcquireCount()), name)
+		ch <- counter(pgxPoolAcquireWait, stat.AcquireDuration().Seconds(), name)
+	}
+}
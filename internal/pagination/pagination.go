@@ -0,0 +1,118 @@
+// Package pagination implements keyset (cursor) pagination for repository
+// listings. A cursor is an opaque token holding the sort values of the last
+// row of a page; the next page continues strictly after that row, so pages
+// stay stable while rows are inserted, unlike OFFSET.
+package pagination
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+const (
+	DefaultLimit = 100
+	MaxLimit     = 1000
+)
+
+// ErrInvalidCursor is returned for tokens that were not produced by Encode
+// for the same sort
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Limit clamps a requested page size: non-positive or too large values fall
+// back to DefaultLimit
+func Limit(requested int) int {
+	if requested <= 0 || requested > MaxLimit {
+		return DefaultLimit
+	}
+	return requested
+}
+
+// Sort is a keyset ordering. The last column must be unique (usually id) so
+// every row has a distinct position.
+type Sort struct {
+	Columns []string
+	Desc    bool
+}
+
+// Apply orders db by the sort, skips rows up to the cursor and fetches one row
+// more than limit so NewPage can tell whether a next page exists. keys are
+// pointers, one per column, the cursor values are decoded into; their types
+// must match the columns (e.g. *time.Time for a timestamp).
+func (s Sort) Apply(db *gorm.DB, cursor string, limit int, keys ...interface{}) (*gorm.DB, error) {
+	if len(keys) != len(s.Columns) {
+		return nil, fmt.Errorf("pagination: %d cursor keys for %d sort columns", len(keys), len(s.Columns))
+	}
+
+	if cursor != "" {
+		values, err := decode(cursor, keys)
+		if err != nil {
+			return nil, err
+		}
+		op := ">"
+		if s.Desc {
+			op = "<"
+		}
+		db = db.Where(fmt.Sprintf("(%s) %s ?", strings.Join(s.Columns, ", "), op), values)
+	}
+
+	direction := " ASC"
+	if s.Desc {
+		direction = " DESC"
+	}
+	for _, column := range s.Columns {
+		db = db.Order(column + direction)
+	}
+	return db.Limit(limit + 1), nil
+}
+
+// Page is one page of a listing; NextCursor is empty on the last page
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// NewPage trims the extra row fetched by Apply and, when it was present,
+// encodes the sort values of the last returned row as the next cursor
+func NewPage[T any](rows []T, limit int, key func(T) []interface{}) Page[T] {
+	if len(rows) <= limit {
+		return Page[T]{Items: rows}
+	}
+	rows = rows[:limit]
+	return Page[T]{Items: rows, NextCursor: Encode(key(rows[limit-1])...)}
+}
+
+// Encode serializes sort values as a URL-safe token
+func Encode(values ...interface{}) string {
+	b, err := json.Marshal(values)
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decode(cursor string, keys []interface{}) ([]interface{}, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var raw []json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil || len(raw) != len(keys) {
+		return nil, ErrInvalidCursor
+	}
+
+	values := make([]interface{}, len(keys))
+	for i, key := range keys {
+		if err := json.Unmarshal(raw[i], key); err != nil {
+			return nil, ErrInvalidCursor
+		}
+		values[i] = reflect.ValueOf(key).Elem().Interface()
+	}
+	return values, nil
+}
@@ -0,0 +1,158 @@
+// Package recovery replaces echo's Recover middleware: a panicking handler is
+// logged with its stack and request context, counted per route, and answered
+// with the usual {"error": ...} body instead of an empty 500.
+package recovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"strings"
+	"sync"
+
+	"sub-balance-demo/internal/logmask"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+// accountIDKey is the echo.Context key handlers set with SetAccountID
+const accountIDKey = "recovery.account_id"
+
+// unmatchedRoute mirrors the metrics label of requests that matched no route
+const unmatchedRoute = "unmatched"
+
+// SetAccountID records the account a request is about, for handlers that read
+// it from the body: a panic log can no longer re-read the body
+func SetAccountID(c echo.Context, accountID string) {
+	c.Set(accountIDKey, accountID)
+}
+
+// Stats counts recovered panics per route template. A nil *Stats counts
+// nothing.
+type Stats struct {
+	panics map[string]int64
+	mutex  sync.Mutex
+}
+
+func NewStats() *Stats {
+	return &Stats{panics: make(map[string]int64)}
+}
+
+func (s *Stats) record(route string) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.panics[route]++
+}
+
+// Panics returns a copy of the panic counts by route
+func (s *Stats) Panics() map[string]int64 {
+	counts := make(map[string]int64)
+	if s == nil {
+		return counts
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for route, n := range s.panics {
+		counts[route] = n
+	}
+	return counts
+}
+
+// Middleware recovers panics from the handlers after it. format is
+// LOG_FORMAT: json or text.
+func Middleware(stats *Stats, format string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) (err error) {
+			defer func() {
+				recovered := recover()
+				if recovered == nil {
+					return
+				}
+				if recovered == http.ErrAbortHandler {
+					panic(recovered)
+				}
+
+				route := c.Path()
+				if route == "" {
+					route = unmatchedRoute
+				}
+				requestID := requestID(c)
+				stats.record(route)
+				write(map[string]interface{}{
+					"msg":        "panic recovered",
+					"panic":      fmt.Sprint(recovered),
+					"request_id": requestID,
+					"method":     c.Request().Method,
+					"route":      route,
+					"account_id": logmask.Account(accountID(c)),
+					"stack":      stackFrames(debug.Stack()),
+				}, format)
+
+				if c.Response().Committed {
+					return // header sudah terkirim, tidak ada yang bisa diperbaiki
+				}
+				err = c.JSON(http.StatusInternalServerError, map[string]string{
+					"error":      "Internal server error",
+					"request_id": requestID,
+				})
+			}()
+			return next(c)
+		}
+	}
+}
+
+// requestID is the caller's X-Request-ID, or a new one echoed back in the
+// response so the client can quote it
+func requestID(c echo.Context) string {
+	if id := c.Request().Header.Get(echo.HeaderXRequestID); id != "" {
+		return id
+	}
+	if id := c.Response().Header().Get(echo.HeaderXRequestID); id != "" {
+		return id
+	}
+	id := uuid.New().String()
+	c.Response().Header().Set(echo.HeaderXRequestID, id)
+	return id
+}
+
+func accountID(c echo.Context) string {
+	if id := c.Param("account_id"); id != "" {
+		return id
+	}
+	id, _ := c.Get(accountIDKey).(string)
+	return id
+}
+
+// stackFrames splits debug.Stack output into one entry per line, trimmed,
+// so JSON log viewers show it readably
+func stackFrames(stack []byte) []string {
+	lines := strings.Split(strings.TrimSpace(string(stack)), "\n")
+	frames := make([]string, 0, len(lines))
+	for _, line := range lines {
+		frames = append(frames, strings.TrimSpace(line))
+	}
+	return frames
+}
+
+func write(entry map[string]interface{}, format string) {
+	if format == "json" {
+		entry["level"] = "error"
+		encoded, err := json.Marshal(entry)
+		if err == nil {
+			fmt.Fprintln(os.Stdout, string(encoded))
+			return
+		}
+		log.Printf("Failed to encode panic log entry: %v", err)
+	}
+
+	log.Printf("level=error msg=\"panic recovered\" panic=%q request_id=%s method=%s route=%s account_id=%s stack=%q",
+		entry["panic"], entry["request_id"], entry["method"], entry["route"], entry["account_id"],
+		strings.Join(entry["stack"].([]string), "\n"))
+}
@@ -0,0 +1,192 @@
+// Package redisclient opens the service's Redis primary (directly or through
+// Sentinel) and its read replicas.
+package redisclient
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"sub-balance-demo/internal/config"
+	"sub-balance-demo/internal/service"
+	"sub-balance-demo/internal/startup"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Open connects to the Redis primary, through Sentinel when
+// REDIS_SENTINEL_MASTER_NAME is set, waiting for it while it starts
+func Open(cfg *config.Config) (*redis.Client, error) {
+	// Parse Redis timeouts
+	dialTimeout, err := time.ParseDuration(cfg.RedisDialTimeout)
+	if err != nil {
+		log.Printf("Invalid Redis dial timeout, using default 5s: %v", err)
+		dialTimeout = 5 * time.Second
+	}
+
+	readTimeout, err := time.ParseDuration(cfg.RedisReadTimeout)
+	if err != nil {
+		log.Printf("Invalid Redis read timeout, using default 3s: %v", err)
+		readTimeout = 3 * time.Second
+	}
+
+	writeTimeout, err := time.ParseDuration(cfg.RedisWriteTimeout)
+	if err != nil {
+		log.Printf("Invalid Redis write timeout, using default 3s: %v", err)
+		writeTimeout = 3 * time.Second
+	}
+
+	minRetryBackoff, maxRetryBackoff := service.RedisRetryBackoff(cfg)
+
+	tlsConfig, err := clientTLSConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis TLS configuration: %w", err)
+	}
+
+	var rdb *redis.Client
+	if cfg.RedisSentinelMasterName != "" {
+		// Sentinel: client mengikuti master baru saat failover tanpa restart
+		log.Printf("Using Redis Sentinel master %q via %v", cfg.RedisSentinelMasterName, cfg.RedisSentinelAddrs)
+		rdb = redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       cfg.RedisSentinelMasterName,
+			SentinelAddrs:    cfg.RedisSentinelAddrs,
+			SentinelPassword: cfg.RedisSentinelPassword,
+			Username:         cfg.RedisUsername,
+			Password:         cfg.RedisPassword,
+			TLSConfig:        tlsConfig,
+			PoolSize:         cfg.RedisPoolSize,
+			MinIdleConns:     cfg.RedisMinIdleConns,
+			MaxRetries:       cfg.RedisMaxRetries,
+			MinRetryBackoff:  minRetryBackoff,
+			MaxRetryBackoff:  maxRetryBackoff,
+			DialTimeout:      dialTimeout,
+			ReadTimeout:      readTimeout,
+			WriteTimeout:     writeTimeout,
+		})
+	} else {
+		rdb = redis.NewClient(&redis.Options{
+			Addr:                cfg.RedisURL,
+			Username:            cfg.RedisUsername,
+			Password:            cfg.RedisPassword,
+			TLSConfig:           tlsConfig,
+			PoolSize:            cfg.RedisPoolSize,
+			MinIdleConns:        cfg.RedisMinIdleConns,
+			MaxRetries:          cfg.RedisMaxRetries,
+			MinRetryBackoff:     minRetryBackoff,
+			MaxRetryBackoff:     maxRetryBackoff,
+			DialTimeout:         dialTimeout,
+			ReadTimeout:         readTimeout,
+			WriteTimeout:        writeTimeout,
+			CredentialsProvider: credentials(cfg),
+		})
+	}
+
+	// Test connection
+	err = startup.ConnectWithRetry(cfg, "Redis", func() error {
+		return rdb.Ping(context.Background()).Err()
+	})
+	if err != nil {
+		rdb.Close()
+		return nil, err
+	}
+
+	return rdb, nil
+}
+
+// credentials returns the current Redis username and password for each
+// new connection when either comes from a secret manager, nil otherwise. Redis
+// Sentinel does not support it; rotating its password needs a restart.
+func credentials(cfg *config.Config) func() (string, string) {
+	_, usernameSecret := cfg.Secrets.Lookup("REDIS_USERNAME")
+	_, passwordSecret := cfg.Secrets.Lookup("REDIS_PASSWORD")
+	if !usernameSecret && !passwordSecret {
+		return nil
+	}
+	return func() (string, string) {
+		username, password := cfg.RedisUsername, cfg.RedisPassword
+		if value, ok := cfg.Secrets.Lookup("REDIS_USERNAME"); ok {
+			username = value
+		}
+		if value, ok := cfg.Secrets.Lookup("REDIS_PASSWORD"); ok {
+			password = value
+		}
+		return username, password
+	}
+}
+
+// OpenReplicas connects to the configured read replicas with the same
+// auth, TLS, pool and timeout settings as the primary. Unreachable replicas are
+// skipped so a missing replica never blocks startup.
+func OpenReplicas(cfg *config.Config, primary *redis.Client, hook redis.Hook) []*redis.Client {
+	var replicas []*redis.Client
+	for _, addr := range cfg.RedisReplicaAddrs {
+		opts := *primary.Options()
+		opts.Addr = addr
+		opts.Dialer = nil // Sentinel dialer selalu ke master
+		opts.OnConnect = nil
+
+		replica := redis.NewClient(&opts)
+		replica.AddHook(hook)
+
+		ctx, cancel := context.WithTimeout(context.Background(), opts.DialTimeout)
+		err := replica.Ping(ctx).Err()
+		cancel()
+		if err != nil {
+			log.Printf("Redis replica %s unreachable, skipping: %v", addr, err)
+			replica.Close()
+			continue
+		}
+
+		log.Printf("Using Redis replica %s for pending reads", addr)
+		replicas = append(replicas, replica)
+	}
+	return replicas
+}
+
+// Pools names the Redis clients for the pool metrics: the primary and
+// every replica by address
+func Pools(primary *redis.Client, replicas []*redis.Client) map[string]*redis.Client {
+	pools := map[string]*redis.Client{"primary": primary}
+	for _, replica := range replicas {
+		pools["replica:"+replica.Options().Addr] = replica
+	}
+	return pools
+}
+
+// clientTLSConfig builds the client TLS config, or nil when TLS is disabled
+func clientTLSConfig(cfg *config.Config) (*tls.Config, error) {
+	if !cfg.RedisTLSEnabled {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: cfg.RedisTLSInsecureSkipVerify,
+	}
+
+	if cfg.RedisTLSCAFile != "" {
+		caCert, err := os.ReadFile(cfg.RedisTLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.RedisTLSCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.RedisTLSCertFile != "" || cfg.RedisTLSKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.RedisTLSCertFile, cfg.RedisTLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
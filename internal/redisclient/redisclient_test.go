@@ -0,0 +1,89 @@
+package redisclient
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"sub-balance-demo/internal/config"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func testConfig(addr string) *config.Config {
+	return &config.Config{
+		RedisURL:                     addr,
+		RedisDialTimeout:             "100ms",
+		RedisReadTimeout:             "100ms",
+		RedisWriteTimeout:            "100ms",
+		StartupConnectMaxWait:        "50ms",
+		StartupConnectInitialBackoff: "10ms",
+		StartupConnectMaxBackoff:     "10ms",
+	}
+}
+
+func TestOpenWithReplicas(t *testing.T) {
+	primary := miniredis.RunT(t)
+	replica := miniredis.RunT(t)
+	cfg := testConfig(primary.Addr())
+	// Replica yang tidak bisa dihubungi dilewati
+	cfg.RedisReplicaAddrs = []string{replica.Addr(), "127.0.0.1:1"}
+
+	rdb, err := Open(cfg)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { rdb.Close() })
+
+	replicas := OpenReplicas(cfg, rdb, noopHook{})
+	if len(replicas) != 1 || replicas[0].Options().Addr != replica.Addr() {
+		t.Fatalf("OpenReplicas = %d clients, want only %s", len(replicas), replica.Addr())
+	}
+	t.Cleanup(func() { replicas[0].Close() })
+
+	pools := Pools(rdb, replicas)
+	if pools["primary"] != rdb || pools["replica:"+replica.Addr()] != replicas[0] || len(pools) != 2 {
+		t.Fatalf("Pools = %v", pools)
+	}
+}
+
+func TestOpenFails(t *testing.T) {
+	badCA := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(badCA, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*config.Config)
+	}{
+		{"unreachable", func(cfg *config.Config) {}},
+		{"CA file missing", func(cfg *config.Config) {
+			cfg.RedisTLSEnabled = true
+			cfg.RedisTLSCAFile = filepath.Join(t.TempDir(), "missing.pem")
+		}},
+		{"CA file without certificates", func(cfg *config.Config) {
+			cfg.RedisTLSEnabled = true
+			cfg.RedisTLSCAFile = badCA
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig("127.0.0.1:1")
+			tt.modify(cfg)
+			if rdb, err := Open(cfg); err == nil {
+				rdb.Close()
+				t.Fatal("Open succeeded, want an error")
+			}
+		})
+	}
+}
+
+type noopHook struct{}
+
+func (noopHook) DialHook(next redis.DialHook) redis.DialHook          { return next }
+func (noopHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook { return next }
+func (noopHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
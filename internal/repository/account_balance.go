@@ -2,9 +2,12 @@ package repository
 
 import (
 	"context"
-	"time"
+	"errors"
+
+	"sub-balance-demo/internal/tenant"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type AccountBalanceRepository interface {
@@ -13,28 +16,84 @@ type AccountBalanceRepository interface {
 	Create(ctx context.Context, balance *AccountBalance) error
 	Update(ctx context.Context, balance *AccountBalance) error
 	UpdateBalance(ctx context.Context, balance *AccountBalance) error
+	LockAccount(ctx context.Context, id string) error
+	WithTx(tx *gorm.DB) AccountBalanceRepository
+	Reader() AccountBalanceRepository
+}
+
+// ErrVersionConflict is returned when an optimistic-locked update matched no row
+var ErrVersionConflict = errors.New("account balance version conflict")
+
+// ErrNotInTransaction is returned by row-locking reads outside a transaction,
+// where the lock would be released as soon as the statement finished
+var ErrNotInTransaction = errors.New("row lock requires a transaction")
+
+// ErrStaleFence is returned when a write carries an older fencing token than
+// the one last written to the account: the writer's distributed lock lease
+// expired and a newer holder has written since
+var ErrStaleFence = errors.New("stale fencing token")
+
+type fenceKey struct{}
+
+// WithFence returns ctx carrying the fencing token of the distributed lock
+// held on the account. Create and Update with it store the token, and an
+// update is refused with ErrStaleFence once the row holds a newer one. The
+// same holder may write several times, so an equal token is accepted.
+func WithFence(ctx context.Context, fence int64) context.Context {
+	return context.WithValue(ctx, fenceKey{}, fence)
+}
+
+// fenceFromContext returns the fencing token of ctx, 0 if it has none
+func fenceFromContext(ctx context.Context) int64 {
+	fence, _ := ctx.Value(fenceKey{}).(int64)
+	return fence
 }
 
 type accountBalanceRepository struct {
-	db *gorm.DB
+	db      *gorm.DB
+	replica *gorm.DB // optional read replica, see Reader
+}
+
+// NewAccountBalanceRepository creates the repository; replica may be nil
+func NewAccountBalanceRepository(db *gorm.DB, replica *gorm.DB) AccountBalanceRepository {
+	return &accountBalanceRepository{db: db, replica: replica}
+}
+
+// WithTx returns a repository bound to the given transaction
+func (r *accountBalanceRepository) WithTx(tx *gorm.DB) AccountBalanceRepository {
+	return &accountBalanceRepository{db: tx}
 }
 
-func NewAccountBalanceRepository(db *gorm.DB) AccountBalanceRepository {
-	return &accountBalanceRepository{db: db}
+// Reader returns a repository bound to the read replica (or the primary when
+// none is configured). Replica reads may lag: use it for display only, never
+// for validation or for a read followed by a write.
+func (r *accountBalanceRepository) Reader() AccountBalanceRepository {
+	if r.replica == nil {
+		return r
+	}
+	return &accountBalanceRepository{db: r.replica}
 }
 
 func (r *accountBalanceRepository) GetByID(ctx context.Context, id string) (*AccountBalance, error) {
 	var balance AccountBalance
-	err := r.db.WithContext(ctx).Where("id = ?", id).First(&balance).Error
+	err := r.db.WithContext(ctx).Scopes(tenant.Scope(ctx)).Where("id = ?", id).First(&balance).Error
 	if err != nil {
 		return nil, err
 	}
 	return &balance, nil
 }
 
+// GetByIDForUpdate reads the account with SELECT ... FOR UPDATE, blocking other
+// writers of the row until the surrounding transaction ends. It must be called
+// on a repository bound with WithTx.
 func (r *accountBalanceRepository) GetByIDForUpdate(ctx context.Context, id string) (*AccountBalance, error) {
+	if _, ok := r.db.Statement.ConnPool.(gorm.TxCommitter); !ok {
+		return nil, ErrNotInTransaction
+	}
+
 	var balance AccountBalance
-	err := r.db.WithContext(ctx).Set("gorm:query_option", "FOR UPDATE").
+	err := r.db.WithContext(ctx).Clauses(clause.Locking{Strength: "UPDATE"}).
+		Scopes(tenant.Scope(ctx)).
 		Where("id = ?", id).First(&balance).Error
 	if err != nil {
 		return nil, err
@@ -42,33 +101,85 @@ func (r *accountBalanceRepository) GetByIDForUpdate(ctx context.Context, id stri
 	return &balance, nil
 }
 
+// LockAccount takes a transaction-scoped Postgres advisory lock on the account,
+// serializing settlement, repair and the DB-fallback path. It must be called
+// inside a transaction; the lock is released on commit or rollback.
+func (r *accountBalanceRepository) LockAccount(ctx context.Context, id string) error {
+	// SQLite tidak punya advisory lock; transaksi dibuka dengan BEGIN IMMEDIATE
+	// sehingga semua writer sudah terserialisasi per database
+	if r.db.Dialector.Name() == "sqlite" {
+		return nil
+	}
+	return r.db.WithContext(ctx).Exec("SELECT pg_advisory_xact_lock(hashtext(?))", tenant.Qualify(ctx, id)).Error
+}
+
+// Create stores the account under the tenant bound to ctx unless TenantID is
+// set. Timestamps and the initial version are set by the model hooks.
 func (r *accountBalanceRepository) Create(ctx context.Context, balance *AccountBalance) error {
-	balance.CreatedAt = time.Now()
-	balance.UpdatedAt = time.Now()
+	if balance.TenantID == "" {
+		balance.TenantID = tenant.ID(ctx)
+	}
+	balance.Fence = fenceFromContext(ctx)
 	return r.db.WithContext(ctx).Create(balance).Error
 }
 
+// Update writes balance as given, available balance included, if the row is
+// still at balance.Version. On success balance holds the new version.
 func (r *accountBalanceRepository) Update(ctx context.Context, balance *AccountBalance) error {
-	balance.UpdatedAt = time.Now()
-	return r.db.WithContext(ctx).Save(balance).Error
+	return r.versionedUpdate(ctx, balance)
 }
 
+// UpdateBalance recomputes the available balance, then writes like Update
 func (r *accountBalanceRepository) UpdateBalance(ctx context.Context, balance *AccountBalance) error {
-	balance.UpdatedAt = time.Now()
-	balance.Version = balance.Version + 1
-
 	// Update available balance
 	balance.AvailableBalance = balance.SettledBalance.Add(balance.PendingCredit).Sub(balance.PendingDebit)
 
-	return r.db.WithContext(ctx).Model(balance).
-		Where("id = ? AND version = ?", balance.ID, balance.Version-1).
-		Updates(map[string]interface{}{
-			"settled_balance":    balance.SettledBalance,
-			"pending_debit":      balance.PendingDebit,
-			"pending_credit":     balance.PendingCredit,
-			"available_balance":  balance.AvailableBalance,
-			"version":            balance.Version,
-			"last_settlement_at": balance.LastSettlementAt,
-			"updated_at":         balance.UpdatedAt,
-		}).Error
+	return r.versionedUpdate(ctx, balance)
+}
+
+// balanceColumns are the columns an optimistic-locked update writes
+var balanceColumns = []string{
+	"settled_balance", "pending_debit", "pending_credit", "available_balance",
+	"version", "last_settlement_at", "last_settlement_id", "updated_at",
+}
+
+// versionedUpdate is the only GORM update the model hooks allow: BeforeUpdate
+// bumps Version and BeforeSave stamps UpdatedAt before the columns are written.
+// With a fencing token in ctx the row must not hold a newer one.
+func (r *accountBalanceRepository) versionedUpdate(ctx context.Context, balance *AccountBalance) error {
+	expected := balance.Version
+	query := r.db.WithContext(ctx).Set(versionedUpdateKey, true).
+		Model(balance).
+		Scopes(tenant.Scope(ctx)).
+		Where("version = ?", expected)
+	columns := balanceColumns
+	fence := fenceFromContext(ctx)
+	if fence > 0 {
+		balance.Fence = fence
+		query = query.Where("fence <= ?", fence)
+		columns = append(columns[:len(columns):len(columns)], "fence")
+	}
+
+	result := query.Select(columns).Updates(balance)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		if fence > 0 && r.staleFence(ctx, balance, fence) {
+			return ErrStaleFence
+		}
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+// staleFence reports whether the row of balance holds a newer fencing token
+// than fence
+func (r *accountBalanceRepository) staleFence(ctx context.Context, balance *AccountBalance, fence int64) bool {
+	var current int64
+	err := r.db.WithContext(ctx).Model(&AccountBalance{}).
+		Where("tenant_id = ? AND id = ?", balance.TenantID, balance.ID).
+		Select("fence").
+		Scan(&current).Error
+	return err == nil && current > fence
 }
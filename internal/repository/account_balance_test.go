@@ -0,0 +1,269 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"sub-balance-demo/internal/tenant"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// openTestPostgres connects to TEST_DATABASE_URL, skipping the test when it is
+// not set or the database cannot be reached
+func openTestPostgres(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set, skipping Postgres test")
+	}
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Skipf("Postgres unavailable, skipping: %v", err)
+	}
+	if err := db.AutoMigrate(&AccountBalance{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return db
+}
+
+func createTestAccount(t *testing.T, db *gorm.DB, repo AccountBalanceRepository) string {
+	t.Helper()
+	id := "test-lock-" + uuid.New().String()
+	err := repo.Create(context.Background(), &AccountBalance{ID: id, SettledBalance: decimal.Zero, AvailableBalance: decimal.Zero})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	t.Cleanup(func() { db.Exec("DELETE FROM account_balances WHERE id = ?", id) })
+	return id
+}
+
+// fakeTx stands in for a Postgres transaction in dry-run tests: the repository
+// only checks that its connection can commit, nothing is executed
+type fakeTx struct{ gorm.ConnPool }
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+func TestGetByIDForUpdateIssuesForUpdate(t *testing.T) {
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: fakeTx{}}), &gorm.Config{
+		DryRun:               true,
+		DisableAutomaticPing: true,
+		Logger:               logger.Discard,
+	})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	var sql string
+	db.Callback().Query().After("gorm:query").Register("test:capture", func(tx *gorm.DB) {
+		sql = tx.Statement.SQL.String()
+	})
+
+	tx := db.Session(&gorm.Session{})
+	tx.Statement.ConnPool = fakeTx{}
+	NewAccountBalanceRepository(db, nil).WithTx(tx).GetByIDForUpdate(context.Background(), "acc-1")
+	if !strings.HasSuffix(strings.TrimSpace(sql), "FOR UPDATE") {
+		t.Fatalf("GetByIDForUpdate SQL = %q, want a FOR UPDATE lock", sql)
+	}
+}
+
+func TestGetByIDForUpdateRequiresTransaction(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	_, err = NewAccountBalanceRepository(db, nil).GetByIDForUpdate(context.Background(), "acc-1")
+	if !errors.Is(err, ErrNotInTransaction) {
+		t.Fatalf("GetByIDForUpdate outside a transaction error = %v, want ErrNotInTransaction", err)
+	}
+}
+
+func TestSameAccountIDInTwoTenants(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	if err := db.AutoMigrate(&AccountBalance{}, &SubBalance{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	accounts := NewAccountBalanceRepository(db, nil)
+	subBalances := NewSubBalanceRepository(db, nil)
+	acme := tenant.WithID(context.Background(), "acme")
+	globex := tenant.WithID(context.Background(), "globex")
+
+	for _, ctx := range []context.Context{acme, globex} {
+		balance := decimal.NewFromInt(100)
+		if tenant.ID(ctx) == "globex" {
+			balance = decimal.NewFromInt(5)
+		}
+		err := accounts.Create(ctx, &AccountBalance{ID: "ACC001", SettledBalance: balance, AvailableBalance: balance})
+		if err != nil {
+			t.Fatalf("Create in %s: %v", tenant.ID(ctx), err)
+		}
+		for _, id := range []string{tenant.ID(ctx) + "-1", tenant.ID(ctx) + "-2"} {
+			err := subBalances.Create(ctx, &SubBalance{ID: id, AccountID: "ACC001", Amount: decimal.NewFromInt(1), Type: "debit"})
+			if err != nil {
+				t.Fatalf("Create(%s): %v", id, err)
+			}
+		}
+	}
+
+	// Update di satu tenant tidak menyentuh account tenant lain
+	account, err := accounts.GetByID(acme, "ACC001")
+	if err != nil {
+		t.Fatalf("GetByID(acme): %v", err)
+	}
+	account.SettledBalance = decimal.NewFromInt(90)
+	if err := accounts.UpdateBalance(acme, account); err != nil {
+		t.Fatalf("UpdateBalance(acme): %v", err)
+	}
+	for ctx, want := range map[context.Context]int64{acme: 90, globex: 5} {
+		account, err := accounts.GetByID(ctx, "ACC001")
+		if err != nil {
+			t.Fatalf("GetByID(%s): %v", tenant.ID(ctx), err)
+		}
+		if account.TenantID != tenant.ID(ctx) || !account.SettledBalance.Equal(decimal.NewFromInt(want)) {
+			t.Fatalf("account of %s = %s %s, want settled %d", tenant.ID(ctx), account.TenantID, account.SettledBalance, want)
+		}
+	}
+
+	// Batch settlement lintas tenant: tiap account utuh dan tidak tercampur
+	// walaupun batch dipotong di tengah account pertama
+	page, err := subBalances.GetPendingBatch(context.Background(), "", 1, time.Now())
+	if err != nil {
+		t.Fatalf("GetPendingBatch: %v", err)
+	}
+	if len(page.Items) != 2 || page.Items[0].TenantID != "acme" || page.Items[1].TenantID != "acme" || page.NextCursor == "" {
+		t.Fatalf("first batch = %+v, want both acme rows", page.Items)
+	}
+	page, err = subBalances.GetPendingBatch(context.Background(), page.NextCursor, 1, time.Now())
+	if err != nil {
+		t.Fatalf("GetPendingBatch: %v", err)
+	}
+	if len(page.Items) != 2 || page.Items[0].TenantID != "globex" || page.Items[1].TenantID != "globex" {
+		t.Fatalf("second batch = %+v, want both globex rows", page.Items)
+	}
+}
+
+// TestGetByIDForUpdateSerializesWriters runs read-modify-write increments
+// concurrently. Without the row lock two transactions read the same version
+// and one fails with ErrVersionConflict; with it every increment lands.
+func TestGetByIDForUpdateSerializesWriters(t *testing.T) {
+	db := openTestPostgres(t)
+	repo := NewAccountBalanceRepository(db, nil)
+	id := createTestAccount(t, db, repo)
+	ctx := context.Background()
+
+	const writers = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- db.Transaction(func(tx *gorm.DB) error {
+				txRepo := repo.WithTx(tx)
+				balance, err := txRepo.GetByIDForUpdate(ctx, id)
+				if err != nil {
+					return err
+				}
+				time.Sleep(5 * time.Millisecond) // perlebar jendela race
+				balance.SettledBalance = balance.SettledBalance.Add(decimal.NewFromInt(1))
+				return txRepo.UpdateBalance(ctx, balance)
+			})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("concurrent increment failed: %v", err)
+		}
+	}
+
+	balance, err := repo.GetByID(ctx, id)
+	if err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+	if !balance.SettledBalance.Equal(decimal.NewFromInt(writers)) {
+		t.Fatalf("settled balance = %s, want %d", balance.SettledBalance, writers)
+	}
+	if balance.Version != writers+1 {
+		t.Fatalf("version = %d, want %d", balance.Version, writers+1)
+	}
+}
+
+// TestGetByIDForUpdateBlocksUntilCommit checks that a second locking read
+// waits for the first transaction and then sees its committed write
+func TestGetByIDForUpdateBlocksUntilCommit(t *testing.T) {
+	db := openTestPostgres(t)
+	repo := NewAccountBalanceRepository(db, nil)
+	id := createTestAccount(t, db, repo)
+	ctx := context.Background()
+
+	locked := make(chan struct{})
+	release := make(chan struct{})
+	firstDone := make(chan error, 1)
+	go func() {
+		firstDone <- db.Transaction(func(tx *gorm.DB) error {
+			txRepo := repo.WithTx(tx)
+			balance, err := txRepo.GetByIDForUpdate(ctx, id)
+			if err != nil {
+				close(locked)
+				return err
+			}
+			close(locked)
+			<-release
+			balance.SettledBalance = decimal.NewFromInt(100)
+			return txRepo.UpdateBalance(ctx, balance)
+		})
+	}()
+	<-locked
+
+	acquired := make(chan decimal.Decimal, 1)
+	secondDone := make(chan error, 1)
+	go func() {
+		secondDone <- db.Transaction(func(tx *gorm.DB) error {
+			balance, err := repo.WithTx(tx).GetByIDForUpdate(ctx, id)
+			if err != nil {
+				return err
+			}
+			acquired <- balance.SettledBalance
+			return nil
+		})
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("second locking read did not wait for the first transaction")
+	case <-time.After(200 * time.Millisecond):
+	}
+	close(release)
+	if err := <-firstDone; err != nil {
+		t.Fatalf("first transaction: %v", err)
+	}
+
+	select {
+	case settled := <-acquired:
+		if !settled.Equal(decimal.NewFromInt(100)) {
+			t.Fatalf("second read saw %s, want the committed 100", settled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("second locking read never acquired the lock")
+	}
+	if err := <-secondDone; err != nil {
+		t.Fatalf("second transaction: %v", err)
+	}
+}
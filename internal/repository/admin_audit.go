@@ -0,0 +1,78 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"sub-balance-demo/internal/pagination"
+
+	"gorm.io/gorm"
+)
+
+// AdminAuditRepository is append-only: audit rows are never updated or deleted
+type AdminAuditRepository interface {
+	Create(ctx context.Context, entry *AdminAuditEntry) error
+	List(ctx context.Context, filter AdminAuditFilter) (pagination.Page[AdminAuditEntry], error)
+}
+
+// AdminAuditFilter narrows audit queries; zero values are ignored
+type AdminAuditFilter struct {
+	Actor   string
+	Route   string
+	Method  string
+	Outcome string
+	Limit   int
+	Cursor  string
+}
+
+type adminAuditRepository struct {
+	db      *gorm.DB
+	replica *gorm.DB // optional, serves List
+}
+
+// NewAdminAuditRepository creates the repository; replica may be nil
+func NewAdminAuditRepository(db *gorm.DB, replica *gorm.DB) AdminAuditRepository {
+	return &adminAuditRepository{db: db, replica: replica}
+}
+
+func (r *adminAuditRepository) Create(ctx context.Context, entry *AdminAuditEntry) error {
+	entry.CreatedAt = time.Now()
+	return r.db.WithContext(ctx).Create(entry).Error
+}
+
+// List reads from the replica when configured; audit history tolerates lag
+func (r *adminAuditRepository) List(ctx context.Context, filter AdminAuditFilter) (pagination.Page[AdminAuditEntry], error) {
+	db := r.db
+	if r.replica != nil {
+		db = r.replica
+	}
+
+	query := db.WithContext(ctx).Model(&AdminAuditEntry{})
+	if filter.Actor != "" {
+		query = query.Where("actor = ?", filter.Actor)
+	}
+	if filter.Route != "" {
+		query = query.Where("route = ?", filter.Route)
+	}
+	if filter.Method != "" {
+		query = query.Where("method = ?", filter.Method)
+	}
+	if filter.Outcome != "" {
+		query = query.Where("outcome = ?", filter.Outcome)
+	}
+
+	limit := pagination.Limit(filter.Limit)
+	query, err := newestFirst.Apply(query, filter.Cursor, limit, new(time.Time), new(string))
+	if err != nil {
+		return pagination.Page[AdminAuditEntry]{}, err
+	}
+
+	var entries []AdminAuditEntry
+	err = query.Find(&entries).Error
+	if err != nil {
+		return pagination.Page[AdminAuditEntry]{}, err
+	}
+	return pagination.NewPage(entries, limit, func(entry AdminAuditEntry) []interface{} {
+		return []interface{}{entry.CreatedAt, entry.ID}
+	}), nil
+}
@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"sub-balance-demo/internal/pagination"
+
+	"gorm.io/gorm"
+)
+
+// BalanceAuditRepository is append-only: audit rows are never updated or deleted
+type BalanceAuditRepository interface {
+	Create(ctx context.Context, entry *BalanceAuditEntry) error
+	List(ctx context.Context, filter BalanceAuditFilter) (pagination.Page[BalanceAuditEntry], error)
+	WithTx(tx *gorm.DB) BalanceAuditRepository
+}
+
+// BalanceAuditFilter narrows audit queries; zero values are ignored
+type BalanceAuditFilter struct {
+	AccountID string
+	Source    string
+	Limit     int
+	Cursor    string
+}
+
+type balanceAuditRepository struct {
+	db      *gorm.DB
+	replica *gorm.DB // optional, serves List
+}
+
+// NewBalanceAuditRepository creates the repository; replica may be nil
+func NewBalanceAuditRepository(db *gorm.DB, replica *gorm.DB) BalanceAuditRepository {
+	return &balanceAuditRepository{db: db, replica: replica}
+}
+
+// WithTx returns a repository bound to the given transaction
+func (r *balanceAuditRepository) WithTx(tx *gorm.DB) BalanceAuditRepository {
+	return &balanceAuditRepository{db: tx}
+}
+
+func (r *balanceAuditRepository) Create(ctx context.Context, entry *BalanceAuditEntry) error {
+	entry.CreatedAt = time.Now()
+	return r.db.WithContext(ctx).Create(entry).Error
+}
+
+// List reads from the replica when configured; audit history tolerates lag
+func (r *balanceAuditRepository) List(ctx context.Context, filter BalanceAuditFilter) (pagination.Page[BalanceAuditEntry], error) {
+	db := r.db
+	if r.replica != nil {
+		db = r.replica
+	}
+
+	query := db.WithContext(ctx).Model(&BalanceAuditEntry{})
+	if filter.AccountID != "" {
+		query = query.Where("account_id = ?", filter.AccountID)
+	}
+	if filter.Source != "" {
+		query = query.Where("source = ?", filter.Source)
+	}
+
+	limit := pagination.Limit(filter.Limit)
+	query, err := newestFirst.Apply(query, filter.Cursor, limit, new(time.Time), new(string))
+	if err != nil {
+		return pagination.Page[BalanceAuditEntry]{}, err
+	}
+
+	var entries []BalanceAuditEntry
+	err = query.Find(&entries).Error
+	if err != nil {
+		return pagination.Page[BalanceAuditEntry]{}, err
+	}
+	return pagination.NewPage(entries, limit, func(entry BalanceAuditEntry) []interface{} {
+		return []interface{}{entry.CreatedAt, entry.ID}
+	}), nil
+}
@@ -0,0 +1,116 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"sub-balance-demo/internal/pagination"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type BalanceSnapshotRepository interface {
+	Capture(ctx context.Context, date time.Time, after AccountKey, limit int) (AccountKey, int64, error)
+	List(ctx context.Context, filter BalanceSnapshotFilter) (pagination.Page[BalanceSnapshot], error)
+}
+
+// BalanceSnapshotFilter narrows snapshot queries; zero values are ignored
+type BalanceSnapshotFilter struct {
+	AccountID string
+	From      time.Time // inclusive
+	To        time.Time // inclusive
+	Limit     int
+	Cursor    string
+}
+
+type balanceSnapshotRepository struct {
+	db      *gorm.DB
+	replica *gorm.DB // optional, serves List
+}
+
+// NewBalanceSnapshotRepository creates the repository; replica may be nil
+func NewBalanceSnapshotRepository(db *gorm.DB, replica *gorm.DB) BalanceSnapshotRepository {
+	return &balanceSnapshotRepository{db: db, replica: replica}
+}
+
+// Capture snapshots up to limit accounts ordered by (tenant, ID) after after
+// and returns the last account it read (zero once every account is done) and
+// how many snapshots it wrote. Accounts already snapshotted for the date are
+// left as they are, so a rerun or a second instance never overwrites a
+// snapshot.
+func (r *balanceSnapshotRepository) Capture(ctx context.Context, date time.Time, after AccountKey, limit int) (AccountKey, int64, error) {
+	var accounts []AccountBalance
+	err := r.db.WithContext(ctx).
+		Select("tenant_id", "id", "settled_balance", "available_balance").
+		Where("(tenant_id, id) > (?, ?)", after.TenantID, after.AccountID).
+		Order("tenant_id, id").
+		Limit(limit).
+		Find(&accounts).Error
+	if err != nil || len(accounts) == 0 {
+		return AccountKey{}, 0, err
+	}
+
+	now := time.Now()
+	snapshots := make([]BalanceSnapshot, len(accounts))
+	for i, account := range accounts {
+		snapshots[i] = BalanceSnapshot{
+			TenantID:         account.TenantID,
+			AccountID:        account.ID,
+			SnapshotDate:     date,
+			SettledBalance:   account.SettledBalance,
+			AvailableBalance: account.AvailableBalance,
+			CreatedAt:        now,
+		}
+	}
+
+	result := r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&snapshots)
+	if result.Error != nil {
+		return AccountKey{}, 0, result.Error
+	}
+
+	last := accounts[len(accounts)-1]
+	if len(accounts) < limit {
+		return AccountKey{}, result.RowsAffected, nil
+	}
+	return AccountKey{TenantID: last.TenantID, AccountID: last.ID}, result.RowsAffected, nil
+}
+
+// snapshotsNewestFirst is the keyset ordering of snapshot history
+var snapshotsNewestFirst = pagination.Sort{Columns: []string{"snapshot_date", "account_id"}, Desc: true}
+
+// List reads from the replica when configured; history tolerates lag
+func (r *balanceSnapshotRepository) List(ctx context.Context, filter BalanceSnapshotFilter) (pagination.Page[BalanceSnapshot], error) {
+	db := r.db
+	if r.replica != nil {
+		db = r.replica
+	}
+
+	query := db.WithContext(ctx).Model(&BalanceSnapshot{})
+	if filter.AccountID != "" {
+		query = query.Where("account_id = ?", filter.AccountID)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("snapshot_date >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("snapshot_date <= ?", filter.To)
+	}
+
+	limit := pagination.Limit(filter.Limit)
+	query, err := snapshotsNewestFirst.Apply(query, filter.Cursor, limit, new(time.Time), new(string))
+	if err != nil {
+		return pagination.Page[BalanceSnapshot]{}, err
+	}
+
+	var snapshots []BalanceSnapshot
+	err = query.Find(&snapshots).Error
+	if err != nil {
+		return pagination.Page[BalanceSnapshot]{}, err
+	}
+	return pagination.NewPage(snapshots, limit, func(snapshot BalanceSnapshot) []interface{} {
+		return []interface{}{snapshot.SnapshotDate, snapshot.AccountID}
+	}), nil
+}
@@ -0,0 +1,131 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// openBenchDB opens the database the hot query benchmarks run on: Postgres
+// at TEST_DATABASE_URL when set, an in-memory SQLite otherwise. prepare sets
+// GORM's PrepareStmt, like DB_PREPARE_STATEMENTS.
+func openBenchDB(b *testing.B, prepare bool) *gorm.DB {
+	b.Helper()
+	config := &gorm.Config{PrepareStmt: prepare, Logger: logger.Discard}
+	var db *gorm.DB
+	var err error
+	if dsn := os.Getenv("TEST_DATABASE_URL"); dsn != "" {
+		db, err = gorm.Open(postgres.Open(dsn), config)
+	} else {
+		db, err = gorm.Open(sqlite.Open("file::memory:"), config)
+		if err == nil {
+			sqlDB, _ := db.DB()
+			sqlDB.SetMaxOpenConns(1)
+		}
+	}
+	if err != nil {
+		b.Fatalf("open: %v", err)
+	}
+	if err := db.AutoMigrate(&AccountBalance{}, &SubBalance{}); err != nil {
+		b.Fatalf("migrate: %v", err)
+	}
+	b.Cleanup(func() {
+		sqlDB, _ := db.DB()
+		sqlDB.Close()
+	})
+	return db
+}
+
+// benchAccount creates an account with a few pending rows, removed again when
+// the benchmark ends so a shared Postgres is left as it was
+func benchAccount(b *testing.B, db *gorm.DB) string {
+	b.Helper()
+	ctx := context.Background()
+	id := "bench-" + uuid.New().String()
+	if err := NewAccountBalanceRepository(db, nil).Create(ctx, &AccountBalance{ID: id}); err != nil {
+		b.Fatalf("Create account: %v", err)
+	}
+	subBalances := NewSubBalanceRepository(db, nil)
+	for i := 0; i < 10; i++ {
+		err := subBalances.Create(ctx, &SubBalance{ID: uuid.New().String(), AccountID: id, Amount: decimal.NewFromInt(10), Type: "credit"})
+		if err != nil {
+			b.Fatalf("Create sub balance: %v", err)
+		}
+	}
+	b.Cleanup(func() {
+		db.Exec("DELETE FROM sub_balances WHERE account_id = ?", id)
+		db.Exec("DELETE FROM account_balances WHERE id = ?", id)
+	})
+	return id
+}
+
+// BenchmarkHotQueries runs the queries of the transaction path (read balance,
+// optimistic-locked update, insert and sum of sub balances) with GORM's
+// prepared statement cache on and off:
+//
+//	TEST_DATABASE_URL=... go test ./internal/repository/ -run '^$' -bench HotQueries -benchmem
+func BenchmarkHotQueries(b *testing.B) {
+	queries := []struct {
+		name string
+		run  func(b *testing.B, db *gorm.DB, accountID string)
+	}{
+		{"GetByID", func(b *testing.B, db *gorm.DB, accountID string) {
+			repo := NewAccountBalanceRepository(db, nil)
+			for i := 0; i < b.N; i++ {
+				if _, err := repo.GetByID(context.Background(), accountID); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}},
+		{"UpdateBalance", func(b *testing.B, db *gorm.DB, accountID string) {
+			repo := NewAccountBalanceRepository(db, nil)
+			balance, err := repo.GetByID(context.Background(), accountID)
+			if err != nil {
+				b.Fatal(err)
+			}
+			for i := 0; i < b.N; i++ {
+				balance.PendingCredit = balance.PendingCredit.Add(decimal.NewFromInt(1))
+				if err := repo.UpdateBalance(context.Background(), balance); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}},
+		{"CreateSubBalance", func(b *testing.B, db *gorm.DB, accountID string) {
+			repo := NewSubBalanceRepository(db, nil)
+			for i := 0; i < b.N; i++ {
+				err := repo.Create(context.Background(), &SubBalance{ID: uuid.New().String(), AccountID: accountID, Amount: decimal.NewFromInt(1), Type: "credit"})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		}},
+		{"GetPendingTotal", func(b *testing.B, db *gorm.DB, accountID string) {
+			repo := NewSubBalanceRepository(db, nil)
+			for i := 0; i < b.N; i++ {
+				if _, err := repo.GetPendingTotalByAccountID(context.Background(), accountID); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}},
+	}
+
+	for _, prepare := range []bool{true, false} {
+		db := openBenchDB(b, prepare)
+		for _, query := range queries {
+			b.Run(fmt.Sprintf("%s/prepare=%t", query.name, prepare), func(b *testing.B) {
+				accountID := benchAccount(b, db)
+				b.ReportAllocs()
+				b.ResetTimer()
+				query.run(b, db, accountID)
+			})
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"sub-balance-demo/internal/pagination"
+
+	"gorm.io/gorm"
+)
+
+// DomainEventRepository is append-only: events are never updated or deleted
+type DomainEventRepository interface {
+	Create(ctx context.Context, events []DomainEvent) error
+	List(ctx context.Context, filter DomainEventFilter) (pagination.Page[DomainEvent], error)
+	WithTx(tx *gorm.DB) DomainEventRepository
+}
+
+// DomainEventFilter narrows event queries; zero values are ignored
+type DomainEventFilter struct {
+	AccountID string
+	Type      string
+	From      time.Time // inclusive
+	Limit     int
+	Cursor    string
+}
+
+type domainEventRepository struct {
+	db      *gorm.DB
+	replica *gorm.DB // optional, serves List
+}
+
+// NewDomainEventRepository creates the repository; replica may be nil
+func NewDomainEventRepository(db *gorm.DB, replica *gorm.DB) DomainEventRepository {
+	return &domainEventRepository{db: db, replica: replica}
+}
+
+// WithTx returns a repository bound to the given transaction
+func (r *domainEventRepository) WithTx(tx *gorm.DB) DomainEventRepository {
+	return &domainEventRepository{db: tx}
+}
+
+func (r *domainEventRepository) Create(ctx context.Context, events []DomainEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	now := time.Now()
+	for i := range events {
+		events[i].CreatedAt = now
+	}
+	return r.db.WithContext(ctx).Create(&events).Error
+}
+
+// List reads from the replica when configured; event history tolerates lag
+func (r *domainEventRepository) List(ctx context.Context, filter DomainEventFilter) (pagination.Page[DomainEvent], error) {
+	db := r.db
+	if r.replica != nil {
+		db = r.replica
+	}
+
+	query := db.WithContext(ctx).Model(&DomainEvent{})
+	if filter.AccountID != "" {
+		query = query.Where("account_id = ?", filter.AccountID)
+	}
+	if filter.Type != "" {
+		query = query.Where("type = ?", filter.Type)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("created_at >= ?", filter.From)
+	}
+
+	limit := pagination.Limit(filter.Limit)
+	query, err := newestFirst.Apply(query, filter.Cursor, limit, new(time.Time), new(string))
+	if err != nil {
+		return pagination.Page[DomainEvent]{}, err
+	}
+
+	var events []DomainEvent
+	err = query.Find(&events).Error
+	if err != nil {
+		return pagination.Page[DomainEvent]{}, err
+	}
+	return pagination.NewPage(events, limit, func(event DomainEvent) []interface{} {
+		return []interface{}{event.CreatedAt, event.ID}
+	}), nil
+}
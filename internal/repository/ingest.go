@@ -0,0 +1,148 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"sub-balance-demo/internal/tenant"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ingestRowChunk bounds the IDs and references per IN list
+const ingestRowChunk = 500
+
+type IngestRepository interface {
+	ClaimFile(ctx context.Context, file *IngestFile, now time.Time, lease time.Duration) (bool, error)
+	FinishFile(ctx context.Context, file *IngestFile) error
+	ClaimRows(ctx context.Context, rows []IngestRow) (map[string]IngestRow, error)
+	RecordRows(ctx context.Context, accepted []IngestRow, released []IngestRow) error
+}
+
+type ingestRepository struct {
+	db *gorm.DB
+}
+
+func NewIngestRepository(db *gorm.DB) IngestRepository {
+	return &ingestRepository{db: db}
+}
+
+// ClaimFile claims a file version until now+lease: a version seen for the
+// first time, or one whose earlier claim ran out while it was still
+// processing or waiting for a retry. On success file holds the stored row.
+func (r *ingestRepository) ClaimFile(ctx context.Context, file *IngestFile, now time.Time, lease time.Duration) (bool, error) {
+	file.Status = IngestProcessing
+	file.ClaimedUntil = now.Add(lease)
+	file.CreatedAt = now
+	file.UpdatedAt = now
+	result := r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(file)
+	if result.Error != nil || result.RowsAffected > 0 {
+		return result.Error == nil, result.Error
+	}
+
+	version := r.db.WithContext(ctx).Model(&IngestFile{}).
+		Where("source = ? AND name = ? AND fingerprint = ?", file.Source, file.Name, file.Fingerprint)
+	result = version.Session(&gorm.Session{}).
+		Where("status IN ? AND claimed_until < ?", []string{IngestProcessing, IngestRetry}, now).
+		Updates(map[string]interface{}{
+			"status":        IngestProcessing,
+			"claimed_until": now.Add(lease),
+			"updated_at":    now,
+		})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false, result.Error
+	}
+	return true, version.Session(&gorm.Session{}).First(file).Error
+}
+
+// FinishFile stores the outcome of processing a file version
+func (r *ingestRepository) FinishFile(ctx context.Context, file *IngestFile) error {
+	file.UpdatedAt = time.Now()
+	return r.db.WithContext(ctx).Model(&IngestFile{}).
+		Where("id = ?", file.ID).
+		Updates(map[string]interface{}{
+			"status":        file.Status,
+			"attempts":      file.Attempts,
+			"row_count":     file.Rows,
+			"accepted":      file.Accepted,
+			"rejected":      file.Rejected,
+			"skipped":       file.Skipped,
+			"error":         file.Error,
+			"claimed_until": file.ClaimedUntil,
+			"updated_at":    file.UpdatedAt,
+		}).Error
+}
+
+// ClaimRows inserts rows as processing, skipping the references already
+// claimed for the file. Rows without a TenantID are claimed for the tenant
+// bound to ctx. It returns the earlier claims by reference; every row missing
+// from the map is claimed now and has its ID set.
+func (r *ingestRepository) ClaimRows(ctx context.Context, rows []IngestRow) (map[string]IngestRow, error) {
+	existing := make(map[string]IngestRow)
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		var taken []string
+		for i := range rows {
+			if rows[i].TenantID == "" {
+				rows[i].TenantID = tenant.ID(ctx)
+			}
+			rows[i].Status = IngestProcessing
+			rows[i].CreatedAt = now
+			rows[i].UpdatedAt = now
+			result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&rows[i])
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				rows[i].ID = 0
+				taken = append(taken, rows[i].Reference)
+			}
+		}
+
+		for start := 0; start < len(taken); start += ingestRowChunk {
+			end := min(start+ingestRowChunk, len(taken))
+			var claims []IngestRow
+			err := tx.Where("tenant_id = ? AND source = ? AND file_name = ? AND reference IN ?", rows[0].TenantID, rows[0].Source, rows[0].FileName, taken[start:end]).
+				Find(&claims).Error
+			if err != nil {
+				return err
+			}
+			for _, claim := range claims {
+				existing[claim.Reference] = claim
+			}
+		}
+		return nil
+	})
+	return existing, err
+}
+
+// RecordRows marks the accepted rows with their transaction and deletes the
+// claims of released rows, so a later version of the file retries them
+func (r *ingestRepository) RecordRows(ctx context.Context, accepted []IngestRow, released []IngestRow) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		for _, row := range accepted {
+			err := tx.Model(&IngestRow{}).Where("id = ?", row.ID).Updates(map[string]interface{}{
+				"status":         IngestAccepted,
+				"transaction_id": row.TransactionID,
+				"updated_at":     now,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		ids := make([]int64, len(released))
+		for i, row := range released {
+			ids[i] = row.ID
+		}
+		for start := 0; start < len(ids); start += ingestRowChunk {
+			end := min(start+ingestRowChunk, len(ids))
+			if err := tx.Where("id IN ?", ids[start:end]).Delete(&IngestRow{}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -1,14 +1,88 @@
 package repository
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
 )
 
+// ErrImmutableRecord is returned when code tries to modify an append-only row
+var ErrImmutableRecord = errors.New("record is immutable")
+
+// StringList is a []string stored as a JSON array column
+type StringList []string
+
+func (l StringList) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+	b, err := json.Marshal(l)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (l *StringList) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, l)
+	case string:
+		return json.Unmarshal([]byte(v), l)
+	default:
+		return fmt.Errorf("unsupported type for StringList: %T", value)
+	}
+}
+
+// Metadata holds the caller-supplied key/value metadata of a transaction as a
+// JSON object. Fields listed in METADATA_ENCRYPTED_FIELDS are stored as enc:
+// values, see service.MetadataCipher.
+type Metadata map[string]string
+
+func (m Metadata) Value() (driver.Value, error) {
+	if len(m) == 0 {
+		return nil, nil
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (m *Metadata) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*m = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, m)
+	case string:
+		return json.Unmarshal([]byte(v), m)
+	default:
+		return fmt.Errorf("unsupported type for Metadata: %T", value)
+	}
+}
+
+// AccountKey identifies an account: account IDs are unique per tenant only,
+// so code that handles accounts of several tenants keys them by both
+type AccountKey struct {
+	TenantID  string
+	AccountID string
+}
+
 // AccountBalance represents the main account balance table
 type AccountBalance struct {
-	ID               string          `json:"id" gorm:"primaryKey;column:id"`
+	TenantID         string          `json:"tenant_id" gorm:"primaryKey;column:tenant_id;size:50;not null;default:'default'"`
+	ID               string          `json:"id" gorm:"primaryKey;column:id"` // unique per tenant
 	CreatedAt        time.Time       `json:"created_at" gorm:"column:created_at"`
 	UpdatedAt        time.Time       `json:"updated_at" gorm:"column:updated_at"`
 	SettledBalance   decimal.Decimal `json:"settled_balance" gorm:"column:settled_balance;type:decimal(20,2)"`
@@ -17,43 +91,451 @@ type AccountBalance struct {
 	AvailableBalance decimal.Decimal `json:"available_balance" gorm:"column:available_balance;type:decimal(20,2)"`
 	Version          int64           `json:"version" gorm:"column:version"`
 	LastSettlementAt *time.Time      `json:"last_settlement_at" gorm:"column:last_settlement_at"`
+	LastSettlementID string          `json:"last_settlement_id" gorm:"column:last_settlement_id"`
+	Fence            int64           `json:"-" gorm:"column:fence;not null;default:0"` // fencing token of the last distributed lock holder that wrote the row
 }
 
 func (AccountBalance) TableName() string {
 	return "account_balances"
 }
 
+// ErrUnversionedUpdate is returned when an account_balances row is updated
+// through GORM without going through Update or UpdateBalance, which would skip
+// the optimistic-lock version check
+var ErrUnversionedUpdate = errors.New("account balance must be updated through UpdateBalance")
+
+// versionedUpdateKey marks the statements issued by versionedUpdate
+const versionedUpdateKey = "account_balance:versioned_update"
+
+// BeforeSave stamps UpdatedAt (and CreatedAt on insert) so no write path can
+// forget them
+func (b *AccountBalance) BeforeSave(tx *gorm.DB) error {
+	now := time.Now()
+	if b.CreatedAt.IsZero() {
+		b.CreatedAt = now
+	}
+	b.UpdatedAt = now
+	return nil
+}
+
+// BeforeCreate starts every account at version 1
+func (b *AccountBalance) BeforeCreate(tx *gorm.DB) error {
+	if b.Version < 1 {
+		b.Version = 1
+	}
+	return nil
+}
+
+// BeforeUpdate rejects updates that bypass the repository (Save, Update,
+// Updates on the model) and bumps the version of those that don't. Raw SQL
+// (set-based settlement, the pgx hot path) skips hooks and must bump version
+// itself.
+func (b *AccountBalance) BeforeUpdate(tx *gorm.DB) error {
+	if _, ok := tx.Get(versionedUpdateKey); !ok {
+		return ErrUnversionedUpdate
+	}
+	b.Version++
+	return nil
+}
+
 // SubBalance represents the sub-balance (pending transactions) table
 type SubBalance struct {
-	ID        string          `json:"id" gorm:"primaryKey;column:id"`
-	AccountID string          `json:"account_id" gorm:"column:account_id;index"`
-	Amount    decimal.Decimal `json:"amount" gorm:"column:amount;type:decimal(20,2)"`
-	Type      string          `json:"type" gorm:"column:type;index"`     // debit or credit
-	Status    string          `json:"status" gorm:"column:status;index"` // PENDING, SETTLED, REJECTED
-	CreatedAt time.Time       `json:"created_at" gorm:"column:created_at;index"`
-	UpdatedAt time.Time       `json:"updated_at" gorm:"column:updated_at"`
+	ID            string          `json:"id" gorm:"primaryKey;column:id"`
+	AccountID     string          `json:"account_id" gorm:"column:account_id;index:idx_sub_balances_account_status,priority:1;index:idx_sub_balances_status_account_created,priority:2"`
+	TenantID      string          `json:"tenant_id" gorm:"column:tenant_id;size:50;not null;default:'default'"` // same as the account's; not indexed, queries always narrow by account_id first
+	Amount        decimal.Decimal `json:"amount" gorm:"column:amount;type:decimal(20,2)"`
+	Type          string          `json:"type" gorm:"column:type;index"`                                                                                                         // debit or credit
+	Status        string          `json:"status" gorm:"column:status;index:idx_sub_balances_account_status,priority:2;index:idx_sub_balances_status_account_created,priority:1"` // PENDING, SETTLED, REJECTED, FAILED
+	SettlementID  *string         `json:"settlement_id" gorm:"column:settlement_id;index"`                                                                                       // settlement run that settled/rejected this row
+	Attempts      int             `json:"attempts" gorm:"column:attempts;default:0"`                                                                                             // settlement attempts rejected so far
+	NextAttemptAt *time.Time      `json:"next_attempt_at,omitempty" gorm:"column:next_attempt_at"`                                                                               // rejected row is not settled again before this; NULL = due
+	TraceParent   string          `json:"trace_parent,omitempty" gorm:"column:trace_parent;size:55"`                                                                             // W3C traceparent of the request that created the row
+	Metadata      Metadata        `json:"metadata,omitempty" gorm:"column:metadata;type:text"`                                                                                   // caller metadata; configured fields encrypted at rest
+	CreatedAt     time.Time       `json:"created_at" gorm:"column:created_at;index;index:idx_sub_balances_status_account_created,priority:3"`
+	UpdatedAt     time.Time       `json:"updated_at" gorm:"column:updated_at"`
+	DeletedAt     gorm.DeletedAt  `json:"-" gorm:"column:deleted_at;index"` // soft-deleted by retention, purged after the grace period
 }
 
 func (SubBalance) TableName() string {
 	return "sub_balances"
 }
 
+// ReconciliationRecord compares, per account and settlement run, the delta
+// applied to the balance against the sum of the sub_balances the run settled
+type ReconciliationRecord struct {
+	ID            string          `json:"id" gorm:"primaryKey;column:id"`
+	SettlementID  string          `json:"settlement_id" gorm:"column:settlement_id;index"`
+	TenantID      string          `json:"tenant_id" gorm:"column:tenant_id;size:50;not null;default:'default'"`
+	AccountID     string          `json:"account_id" gorm:"column:account_id;index"`
+	ExpectedDelta decimal.Decimal `json:"expected_delta" gorm:"column:expected_delta;type:decimal(20,2)"`
+	AppliedDelta  decimal.Decimal `json:"applied_delta" gorm:"column:applied_delta;type:decimal(20,2)"`
+	Discrepancy   decimal.Decimal `json:"discrepancy" gorm:"column:discrepancy;type:decimal(20,2)"`
+	Matched       bool            `json:"matched" gorm:"column:matched;index"`
+	CreatedAt     time.Time       `json:"created_at" gorm:"column:created_at;index"`
+	DeletedAt     gorm.DeletedAt  `json:"-" gorm:"column:deleted_at;index"`
+}
+
+func (ReconciliationRecord) TableName() string {
+	return "settlement_reconciliations"
+}
+
+// SettlementAuditLog is an append-only snapshot of one account settlement:
+// the balance before, the delta applied, the balance after, and the
+// sub_balance IDs that produced the delta
+type SettlementAuditLog struct {
+	ID               string          `json:"id" gorm:"primaryKey;column:id"`
+	SettlementID     string          `json:"settlement_id" gorm:"column:settlement_id;index"`
+	AccountID        string          `json:"account_id" gorm:"column:account_id;index"`
+	PreviousBalance  decimal.Decimal `json:"previous_balance" gorm:"column:previous_balance;type:decimal(20,2)"`
+	Delta            decimal.Decimal `json:"delta" gorm:"column:delta;type:decimal(20,2)"`
+	ResultingBalance decimal.Decimal `json:"resulting_balance" gorm:"column:resulting_balance;type:decimal(20,2)"`
+	TransactionIDs   StringList      `json:"transaction_ids" gorm:"column:transaction_ids;type:text"`
+	CreatedAt        time.Time       `json:"created_at" gorm:"column:created_at;index"`
+}
+
+func (SettlementAuditLog) TableName() string {
+	return "settlement_audit_logs"
+}
+
+func (SettlementAuditLog) BeforeUpdate(tx *gorm.DB) error {
+	return ErrImmutableRecord
+}
+
+func (SettlementAuditLog) BeforeDelete(tx *gorm.DB) error {
+	return ErrImmutableRecord
+}
+
+// BalanceAuditEntry records one change to an account_balances row: what made
+// it and the balance before and after. Rows are append-only.
+type BalanceAuditEntry struct {
+	ID                  string          `json:"id" gorm:"primaryKey;column:id"`
+	AccountID           string          `json:"account_id" gorm:"column:account_id;index"`
+	Source              string          `json:"source" gorm:"column:source"`       // settlement, realtime_settlement, fallback, repair, account_create
+	Reference           string          `json:"reference" gorm:"column:reference"` // settlement or transaction ID, if any
+	OldSettledBalance   decimal.Decimal `json:"old_settled_balance" gorm:"column:old_settled_balance;type:decimal(20,2)"`
+	NewSettledBalance   decimal.Decimal `json:"new_settled_balance" gorm:"column:new_settled_balance;type:decimal(20,2)"`
+	OldPendingDebit     decimal.Decimal `json:"old_pending_debit" gorm:"column:old_pending_debit;type:decimal(20,2)"`
+	NewPendingDebit     decimal.Decimal `json:"new_pending_debit" gorm:"column:new_pending_debit;type:decimal(20,2)"`
+	OldPendingCredit    decimal.Decimal `json:"old_pending_credit" gorm:"column:old_pending_credit;type:decimal(20,2)"`
+	NewPendingCredit    decimal.Decimal `json:"new_pending_credit" gorm:"column:new_pending_credit;type:decimal(20,2)"`
+	OldAvailableBalance decimal.Decimal `json:"old_available_balance" gorm:"column:old_available_balance;type:decimal(20,2)"`
+	NewAvailableBalance decimal.Decimal `json:"new_available_balance" gorm:"column:new_available_balance;type:decimal(20,2)"`
+	OldVersion          int64           `json:"old_version" gorm:"column:old_version"`
+	NewVersion          int64           `json:"new_version" gorm:"column:new_version"`
+	CreatedAt           time.Time       `json:"created_at" gorm:"column:created_at;index"`
+}
+
+func (BalanceAuditEntry) TableName() string {
+	return "balance_audit_trail"
+}
+
+func (BalanceAuditEntry) BeforeUpdate(tx *gorm.DB) error {
+	return ErrImmutableRecord
+}
+
+func (BalanceAuditEntry) BeforeDelete(tx *gorm.DB) error {
+	return ErrImmutableRecord
+}
+
+// AdminAuditEntry records one call to an admin or test-mode endpoint: who
+// called it, with which parameters, and how it ended. Rows are append-only.
+type AdminAuditEntry struct {
+	ID         string    `json:"id" gorm:"primaryKey;column:id"`
+	Actor      string    `json:"actor" gorm:"column:actor;size:100;index"`
+	RemoteIP   string    `json:"remote_ip" gorm:"column:remote_ip;size:64"`
+	Method     string    `json:"method" gorm:"column:method;size:10"`
+	Route      string    `json:"route" gorm:"column:route;size:200;index"` // route template, e.g. /admin/quarantine/:account_id
+	Path       string    `json:"path" gorm:"column:path;size:500"`
+	Params     string    `json:"params" gorm:"column:params;type:text"` // JSON: path params, query and request body
+	StatusCode int       `json:"status_code" gorm:"column:status_code"`
+	Outcome    string    `json:"outcome" gorm:"column:outcome;size:20"` // success or failure
+	Error      string    `json:"error,omitempty" gorm:"column:error;type:text"`
+	DurationMs int64     `json:"duration_ms" gorm:"column:duration_ms"`
+	CreatedAt  time.Time `json:"created_at" gorm:"column:created_at;index"`
+}
+
+func (AdminAuditEntry) TableName() string {
+	return "admin_audit_log"
+}
+
+func (AdminAuditEntry) BeforeUpdate(tx *gorm.DB) error {
+	return ErrImmutableRecord
+}
+
+func (AdminAuditEntry) BeforeDelete(tx *gorm.DB) error {
+	return ErrImmutableRecord
+}
+
+// QuarantinedAccount is an account the settlement worker skips after repeated failures
+type QuarantinedAccount struct {
+	TenantID      string    `json:"tenant_id" gorm:"primaryKey;column:tenant_id;size:50;not null;default:'default'"`
+	AccountID     string    `json:"account_id" gorm:"primaryKey;column:account_id"`
+	Failures      int       `json:"failures" gorm:"column:failures"`
+	LastError     string    `json:"last_error" gorm:"column:last_error;type:text"`
+	QuarantinedAt time.Time `json:"quarantined_at" gorm:"column:quarantined_at"`
+}
+
+func (QuarantinedAccount) TableName() string {
+	return "settlement_quarantine"
+}
+
+// BalanceSnapshot is an account's balance as recorded by the daily snapshot
+// worker; one row per account and day
+type BalanceSnapshot struct {
+	TenantID         string          `json:"tenant_id" gorm:"primaryKey;column:tenant_id;size:50;not null;default:'default'"`
+	AccountID        string          `json:"account_id" gorm:"primaryKey;column:account_id"`
+	SnapshotDate     time.Time       `json:"snapshot_date" gorm:"primaryKey;column:snapshot_date;type:date;index"`
+	SettledBalance   decimal.Decimal `json:"settled_balance" gorm:"column:settled_balance;type:decimal(20,2)"`
+	AvailableBalance decimal.Decimal `json:"available_balance" gorm:"column:available_balance;type:decimal(20,2)"`
+	CreatedAt        time.Time       `json:"created_at" gorm:"column:created_at"`
+}
+
+func (BalanceSnapshot) TableName() string {
+	return "balance_snapshots"
+}
+
+// OutboxEvent is a domain event written in the same transaction as the state
+// change it describes; the outbox relay publishes it and stamps SentAt
+type OutboxEvent struct {
+	ID        int64          `json:"id" gorm:"primaryKey;autoIncrement;column:id"`
+	EventType string         `json:"event_type" gorm:"column:event_type"`
+	AccountID string         `json:"account_id" gorm:"column:account_id;index"`
+	Payload   string         `json:"payload" gorm:"column:payload;type:text"` // JSON encoded event
+	Attempts  int            `json:"attempts" gorm:"column:attempts;default:0"`
+	LastError string         `json:"last_error" gorm:"column:last_error;type:text"`
+	CreatedAt time.Time      `json:"created_at" gorm:"column:created_at;index"`
+	SentAt    *time.Time     `json:"sent_at" gorm:"column:sent_at;index"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"column:deleted_at;index"`
+}
+
+func (OutboxEvent) TableName() string {
+	return "outbox"
+}
+
+// DomainEvent is a significant event in an account's life (accepted through
+// Redis or a fallback, settled, repaired) or of the service itself (circuit
+// breaker opened), kept so support can reconstruct what happened without
+// reading raw logs. Rows are append-only.
+type DomainEvent struct {
+	ID        string    `json:"id" gorm:"primaryKey;column:id"`
+	Type      string    `json:"type" gorm:"column:type;size:50;index"`
+	AccountID string    `json:"account_id,omitempty" gorm:"column:account_id;index"` // empty for service-wide events
+	Reference string    `json:"reference,omitempty" gorm:"column:reference"`         // transaction or settlement ID, if any
+	Detail    string    `json:"detail" gorm:"column:detail;type:text"`               // JSON
+	CreatedAt time.Time `json:"created_at" gorm:"column:created_at;index"`
+}
+
+func (DomainEvent) TableName() string {
+	return "domain_events"
+}
+
+func (DomainEvent) BeforeUpdate(tx *gorm.DB) error {
+	return ErrImmutableRecord
+}
+
+func (DomainEvent) BeforeDelete(tx *gorm.DB) error {
+	return ErrImmutableRecord
+}
+
+// RateLimit overrides the default request rate of one caller identity:
+// apikey:<name>, a JWT subject, or account:<account_id>
+type RateLimit struct {
+	Identity      string    `json:"identity" gorm:"primaryKey;column:identity;size:200"`
+	Requests      int       `json:"requests" gorm:"column:requests"`
+	WindowSeconds int       `json:"window_seconds" gorm:"column:window_seconds"`
+	Burst         int       `json:"burst" gorm:"column:burst;not null;default:0"` // token_bucket; 0 = requests
+	UpdatedAt     time.Time `json:"updated_at" gorm:"column:updated_at"`
+}
+
+func (RateLimit) TableName() string {
+	return "rate_limits"
+}
+
+// Status pengiriman webhook
+const (
+	WebhookPending   = "pending"
+	WebhookDelivered = "delivered"
+	WebhookFailed    = "failed"    // dead-lettered setelah max attempts, bisa di-replay
+	WebhookCancelled = "cancelled" // masih antri saat subscription dihapus, tidak dikirim
+)
+
+// WebhookSubscription is an endpoint that receives the events of EventTypes
+// (every type when empty), signed with Secret. After a rotation deliveries are
+// also signed with PreviousSecret until PreviousSecretExpiresAt, so the
+// receiver can switch secrets without rejecting requests. Deleting one is
+// soft, so its deliveries can still be inspected.
+type WebhookSubscription struct {
+	ID                      string         `json:"id" gorm:"primaryKey;column:id"`
+	URL                     string         `json:"url" gorm:"column:url;size:500"`
+	EventTypes              []string       `json:"event_types" gorm:"column:event_types;type:text;serializer:json"`
+	Secret                  string         `json:"-" gorm:"column:secret;size:255"`          // enc: value when a master key is configured
+	PreviousSecret          string         `json:"-" gorm:"column:previous_secret;size:255"` // idem
+	PreviousSecretExpiresAt *time.Time     `json:"previous_secret_expires_at,omitempty" gorm:"column:previous_secret_expires_at"`
+	CreatedAt               time.Time      `json:"created_at" gorm:"column:created_at"`
+	DeletedAt               gorm.DeletedAt `json:"-" gorm:"column:deleted_at;index"`
+}
+
+// SigningSecrets are the secrets deliveries sent at now are signed with: the
+// current one, then the previous one while its overlap window is open
+func (s WebhookSubscription) SigningSecrets(now time.Time) []string {
+	secrets := []string{s.Secret}
+	if s.PreviousSecret != "" && s.PreviousSecretExpiresAt != nil && now.Before(*s.PreviousSecretExpiresAt) {
+		secrets = append(secrets, s.PreviousSecret)
+	}
+	return secrets
+}
+
+func (WebhookSubscription) TableName() string {
+	return "webhook_subscriptions"
+}
+
+// WebhookDelivery is one event queued for one subscription. Deliveries of a
+// subscription are sent in ID order; a delivery that keeps failing is retried
+// until it is delivered or dead-lettered (failed).
+type WebhookDelivery struct {
+	ID             int64      `json:"id" gorm:"primaryKey;autoIncrement;column:id"`
+	SubscriptionID string     `json:"subscription_id" gorm:"column:subscription_id;size:36;uniqueIndex:idx_webhook_deliveries_event"`
+	EventID        string     `json:"event_id" gorm:"column:event_id;size:100;uniqueIndex:idx_webhook_deliveries_event"`
+	EventType      string     `json:"event_type" gorm:"column:event_type;size:50"`
+	AccountID      string     `json:"account_id" gorm:"column:account_id;index"`
+	Payload        string     `json:"payload" gorm:"column:payload;type:text"` // JSON body yang dikirim
+	Status         string     `json:"status" gorm:"column:status;size:20;index"`
+	Attempts       int        `json:"attempts" gorm:"column:attempts;default:0"`
+	NextAttemptAt  time.Time  `json:"next_attempt_at" gorm:"column:next_attempt_at;index"`
+	LastStatusCode int        `json:"last_status_code,omitempty" gorm:"column:last_status_code"`
+	LastError      string     `json:"last_error,omitempty" gorm:"column:last_error;type:text"`
+	CreatedAt      time.Time  `json:"created_at" gorm:"column:created_at"`
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty" gorm:"column:delivered_at"`
+}
+
+func (WebhookDelivery) TableName() string {
+	return "webhook_deliveries"
+}
+
+// WebhookDeliveryAttempt records one HTTP call of a delivery. Rows are
+// append-only.
+type WebhookDeliveryAttempt struct {
+	ID         int64     `json:"id" gorm:"primaryKey;autoIncrement;column:id"`
+	DeliveryID int64     `json:"delivery_id" gorm:"column:delivery_id;index"`
+	Attempt    int       `json:"attempt" gorm:"column:attempt"`
+	StatusCode int       `json:"status_code,omitempty" gorm:"column:status_code"` // 0 = tidak ada response
+	Error      string    `json:"error,omitempty" gorm:"column:error;type:text"`
+	DurationMs int64     `json:"duration_ms" gorm:"column:duration_ms"`
+	CreatedAt  time.Time `json:"created_at" gorm:"column:created_at"`
+}
+
+func (WebhookDeliveryAttempt) TableName() string {
+	return "webhook_delivery_attempts"
+}
+
+func (WebhookDeliveryAttempt) BeforeUpdate(tx *gorm.DB) error {
+	return ErrImmutableRecord
+}
+
+func (WebhookDeliveryAttempt) BeforeDelete(tx *gorm.DB) error {
+	return ErrImmutableRecord
+}
+
+// Status file dan baris ingestion
+const (
+	IngestProcessing = "processing" // file/baris sedang diklaim worker
+	IngestDone       = "done"
+	IngestRetry      = "retry"  // sebagian baris gagal sementara, file diproses lagi
+	IngestFailed     = "failed" // file tidak bisa di-parse atau retry habis
+	IngestAccepted   = "accepted"
+)
+
+// IngestFile is one version (fingerprint) of a partner file. A worker claims
+// it until ClaimedUntil, so a version is processed by one instance at a time
+// and picked up again when that instance dies mid-file.
+type IngestFile struct {
+	ID           int64     `json:"id" gorm:"primaryKey;autoIncrement;column:id"`
+	Source       string    `json:"source" gorm:"column:source;size:300;uniqueIndex:idx_ingest_files_version"`
+	Name         string    `json:"name" gorm:"column:name;size:300;uniqueIndex:idx_ingest_files_version"`
+	Fingerprint  string    `json:"fingerprint" gorm:"column:fingerprint;size:100;uniqueIndex:idx_ingest_files_version"`
+	Status       string    `json:"status" gorm:"column:status;size:20;index"`
+	Attempts     int       `json:"attempts" gorm:"column:attempts;default:0"`
+	Rows         int       `json:"rows" gorm:"column:row_count"`
+	Accepted     int       `json:"accepted" gorm:"column:accepted"`
+	Rejected     int       `json:"rejected" gorm:"column:rejected"`
+	Skipped      int       `json:"skipped" gorm:"column:skipped"` // sudah diproses sebelumnya
+	Error        string    `json:"error,omitempty" gorm:"column:error;type:text"`
+	ClaimedUntil time.Time `json:"claimed_until" gorm:"column:claimed_until"`
+	CreatedAt    time.Time `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt    time.Time `json:"updated_at" gorm:"column:updated_at"`
+}
+
+func (IngestFile) TableName() string {
+	return "ingest_files"
+}
+
+// IngestRow claims a row of a partner file by file name and row reference
+// before its transaction is created, so no version of the file creates that
+// transaction twice. Rejected rows are released and retried by a later
+// version; a row left processing by a dead worker is never retried. References
+// are unique per tenant: two tenants may send the same one.
+type IngestRow struct {
+	ID            int64     `json:"id" gorm:"primaryKey;autoIncrement;column:id"`
+	TenantID      string    `json:"tenant_id" gorm:"column:tenant_id;size:50;not null;default:'default';uniqueIndex:idx_ingest_rows_reference,priority:1"`
+	Source        string    `json:"source" gorm:"column:source;size:300;uniqueIndex:idx_ingest_rows_reference"`
+	FileName      string    `json:"file_name" gorm:"column:file_name;size:300;uniqueIndex:idx_ingest_rows_reference"`
+	Reference     string    `json:"reference" gorm:"column:reference;size:100;uniqueIndex:idx_ingest_rows_reference"`
+	Line          int       `json:"line" gorm:"column:line"`
+	Status        string    `json:"status" gorm:"column:status;size:20"` // processing atau accepted
+	TransactionID string    `json:"transaction_id,omitempty" gorm:"column:transaction_id;size:36"`
+	CreatedAt     time.Time `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt     time.Time `json:"updated_at" gorm:"column:updated_at"`
+}
+
+func (IngestRow) TableName() string {
+	return "ingest_rows"
+}
+
+// Models lists every table managed by AutoMigrate, in migration order
+func Models() []interface{} {
+	return []interface{}{
+		&AccountBalance{},
+		&SubBalance{},
+		&ReconciliationRecord{},
+		&SettlementAuditLog{},
+		&BalanceAuditEntry{},
+		&QuarantinedAccount{},
+		&OutboxEvent{},
+		&BalanceSnapshot{},
+		&AdminAuditEntry{},
+		&DomainEvent{},
+		&RateLimit{},
+		&WebhookSubscription{},
+		&WebhookDelivery{},
+		&WebhookDeliveryAttempt{},
+		&IngestFile{},
+		&IngestRow{},
+	}
+}
+
 // TransactionRequest represents the request payload
 type TransactionRequest struct {
-	AccountID string          `json:"account_id" validate:"required"`
+	AccountID string          `json:"account_id" validate:"required,max=64"`
 	Amount    decimal.Decimal `json:"amount" validate:"required"`
 	Type      string          `json:"type" validate:"required,oneof=debit credit"` // debit or credit
+	// SettleImmediately opts a credit in (true) or out (false) of real-time
+	// settlement; nil follows the server default
+	SettleImmediately *bool `json:"settle_immediately,omitempty"`
+	// Metadata is stored with the transaction and returned with it, e.g. a
+	// partner reference; at most 20 keys
+	Metadata map[string]string `json:"metadata,omitempty" validate:"omitempty,max=20,dive,keys,min=1,max=64,endkeys,max=1024"`
 }
 
 // TransactionResponse represents the response payload
 type TransactionResponse struct {
-	Success   bool            `json:"success"`
-	Message   string          `json:"message"`
-	AccountID string          `json:"account_id"`
-	Amount    decimal.Decimal `json:"amount"`
-	Type      string          `json:"type"`
-	Status    string          `json:"status"`
-	Timestamp time.Time       `json:"timestamp"`
+	Success       bool            `json:"success"`
+	Message       string          `json:"message"`
+	TransactionID string          `json:"transaction_id,omitempty"`
+	AccountID     string          `json:"account_id"`
+	Amount        decimal.Decimal `json:"amount"`
+	Type          string          `json:"type"`
+	Status        string          `json:"status"`
+	Timestamp     time.Time       `json:"timestamp"`
 }
 
 // BalanceResponse represents the balance response
@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type OutboxRepository interface {
+	Create(ctx context.Context, events []OutboxEvent) error
+	ClaimUnsent(ctx context.Context, limit int) ([]OutboxEvent, error)
+	MarkSent(ctx context.Context, ids []int64) error
+	RecordFailure(ctx context.Context, id int64, reason string) error
+	WithTx(tx *gorm.DB) OutboxRepository
+}
+
+type outboxRepository struct {
+	db *gorm.DB
+}
+
+func NewOutboxRepository(db *gorm.DB) OutboxRepository {
+	return &outboxRepository{db: db}
+}
+
+// WithTx returns a repository bound to the given transaction
+func (r *outboxRepository) WithTx(tx *gorm.DB) OutboxRepository {
+	return &outboxRepository{db: tx}
+}
+
+func (r *outboxRepository) Create(ctx context.Context, events []OutboxEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	now := time.Now()
+	for i := range events {
+		events[i].CreatedAt = now
+	}
+	return r.db.WithContext(ctx).Create(&events).Error
+}
+
+// ClaimUnsent locks the oldest unsent events with FOR UPDATE SKIP LOCKED, so
+// relays on other instances pick different rows. It must be called on a
+// repository bound with WithTx; the claim ends with the transaction.
+func (r *outboxRepository) ClaimUnsent(ctx context.Context, limit int) ([]OutboxEvent, error) {
+	if _, ok := r.db.Statement.ConnPool.(gorm.TxCommitter); !ok {
+		return nil, ErrNotInTransaction
+	}
+
+	var events []OutboxEvent
+	err := r.db.WithContext(ctx).
+		Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("sent_at IS NULL").
+		Order("id").
+		Limit(limit).
+		Find(&events).Error
+	return events, err
+}
+
+func (r *outboxRepository) MarkSent(ctx context.Context, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return r.db.WithContext(ctx).Model(&OutboxEvent{}).
+		Where("id IN ?", ids).
+		Update("sent_at", time.Now()).Error
+}
+
+func (r *outboxRepository) RecordFailure(ctx context.Context, id int64, reason string) error {
+	return r.db.WithContext(ctx).Model(&OutboxEvent{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"attempts":   gorm.Expr("attempts + 1"),
+			"last_error": reason,
+		}).Error
+}
@@ -0,0 +1,6 @@
+package repository
+
+import "sub-balance-demo/internal/pagination"
+
+// newestFirst is the keyset ordering of history listings (audit, reconciliation)
+var newestFirst = pagination.Sort{Columns: []string{"created_at", "id"}, Desc: true}
@@ -0,0 +1,130 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"sub-balance-demo/internal/tenant"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"gorm.io/gorm"
+)
+
+// The pgx repositories run the hottest statements (balance read, optimistic
+// balance update, sub_balance insert) straight on a pgxpool, skipping GORM's
+// reflection and statement building. Every other method, and everything bound
+// to a transaction with WithTx, is delegated to the GORM repository: a GORM
+// transaction cannot be joined from another pool.
+
+const selectAccountBalanceSQL = `SELECT id, tenant_id, created_at, updated_at, settled_balance, pending_debit,
+	pending_credit, available_balance, version, last_settlement_at, COALESCE(last_settlement_id, '')
+FROM account_balances WHERE id = $1`
+
+type pgxAccountBalanceRepository struct {
+	AccountBalanceRepository
+	pool    *pgxpool.Pool
+	replica *pgxpool.Pool // optional, serves Reader
+}
+
+// NewPgxAccountBalanceRepository wraps the GORM repository; replica may be nil
+func NewPgxAccountBalanceRepository(gormRepo AccountBalanceRepository, pool *pgxpool.Pool, replica *pgxpool.Pool) AccountBalanceRepository {
+	return &pgxAccountBalanceRepository{AccountBalanceRepository: gormRepo, pool: pool, replica: replica}
+}
+
+func (r *pgxAccountBalanceRepository) WithTx(tx *gorm.DB) AccountBalanceRepository {
+	return r.AccountBalanceRepository.WithTx(tx)
+}
+
+func (r *pgxAccountBalanceRepository) Reader() AccountBalanceRepository {
+	if r.replica == nil {
+		return r
+	}
+	return &pgxAccountBalanceRepository{AccountBalanceRepository: r.AccountBalanceRepository.Reader(), pool: r.replica}
+}
+
+func (r *pgxAccountBalanceRepository) GetByID(ctx context.Context, id string) (*AccountBalance, error) {
+	query, args := withTenant(ctx, selectAccountBalanceSQL, id)
+
+	var balance AccountBalance
+	err := r.pool.QueryRow(ctx, query, args...).Scan(
+		&balance.ID, &balance.TenantID, &balance.CreatedAt, &balance.UpdatedAt,
+		&balance.SettledBalance, &balance.PendingDebit, &balance.PendingCredit, &balance.AvailableBalance,
+		&balance.Version, &balance.LastSettlementAt, &balance.LastSettlementID,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, gorm.ErrRecordNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &balance, nil
+}
+
+// UpdateBalance is the pgx twin of the GORM optimistic-locked update
+func (r *pgxAccountBalanceRepository) UpdateBalance(ctx context.Context, balance *AccountBalance) error {
+	balance.UpdatedAt = time.Now()
+	balance.Version = balance.Version + 1
+	balance.AvailableBalance = balance.SettledBalance.Add(balance.PendingCredit).Sub(balance.PendingDebit)
+
+	query, args := withTenant(ctx, `UPDATE account_balances
+SET settled_balance = $3, pending_debit = $4, pending_credit = $5, available_balance = $6,
+	version = $7, last_settlement_at = $8, last_settlement_id = $9, updated_at = $10
+WHERE id = $1 AND version = $2`,
+		balance.ID, balance.Version-1,
+		balance.SettledBalance, balance.PendingDebit, balance.PendingCredit, balance.AvailableBalance,
+		balance.Version, balance.LastSettlementAt, balance.LastSettlementID, balance.UpdatedAt,
+	)
+
+	tag, err := r.pool.Exec(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+type pgxSubBalanceRepository struct {
+	SubBalanceRepository
+	pool *pgxpool.Pool
+}
+
+// NewPgxSubBalanceRepository wraps the GORM repository
+func NewPgxSubBalanceRepository(gormRepo SubBalanceRepository, pool *pgxpool.Pool) SubBalanceRepository {
+	return &pgxSubBalanceRepository{SubBalanceRepository: gormRepo, pool: pool}
+}
+
+func (r *pgxSubBalanceRepository) WithTx(tx *gorm.DB) SubBalanceRepository {
+	return r.SubBalanceRepository.WithTx(tx)
+}
+
+func (r *pgxSubBalanceRepository) Create(ctx context.Context, subBalance *SubBalance) error {
+	if subBalance.TenantID == "" {
+		subBalance.TenantID = tenant.ID(ctx)
+	}
+	subBalance.CreatedAt = time.Now()
+	subBalance.UpdatedAt = subBalance.CreatedAt
+	subBalance.Status = "PENDING"
+
+	_, err := r.pool.Exec(ctx, `INSERT INTO sub_balances
+	(id, account_id, tenant_id, amount, type, status, attempts, trace_parent, metadata, created_at, updated_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		subBalance.ID, subBalance.AccountID, subBalance.TenantID, subBalance.Amount, subBalance.Type,
+		subBalance.Status, subBalance.Attempts, subBalance.TraceParent, subBalance.Metadata, subBalance.CreatedAt, subBalance.UpdatedAt,
+	)
+	return err
+}
+
+// withTenant appends the tenant condition of ctx, mirroring tenant.Scope
+func withTenant(ctx context.Context, query string, args ...interface{}) (string, []interface{}) {
+	id, ok := tenant.FromContext(ctx)
+	if !ok {
+		return query, args
+	}
+	args = append(args, id)
+	return query + " AND tenant_id = $" + strconv.Itoa(len(args)), args
+}
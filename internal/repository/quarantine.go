@@ -0,0 +1,53 @@
+package repository
+
+import (
+	"context"
+
+	"sub-balance-demo/internal/tenant"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type QuarantineRepository interface {
+	Add(ctx context.Context, account *QuarantinedAccount) error
+	List(ctx context.Context) ([]QuarantinedAccount, error)
+	ListKeys(ctx context.Context) ([]AccountKey, error)
+	Release(ctx context.Context, accountID string) (bool, error)
+}
+
+type quarantineRepository struct {
+	db *gorm.DB
+}
+
+func NewQuarantineRepository(db *gorm.DB) QuarantineRepository {
+	return &quarantineRepository{db: db}
+}
+
+func (r *quarantineRepository) Add(ctx context.Context, account *QuarantinedAccount) error {
+	return r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{UpdateAll: true}).
+		Create(account).Error
+}
+
+func (r *quarantineRepository) List(ctx context.Context) ([]QuarantinedAccount, error) {
+	var accounts []QuarantinedAccount
+	err := r.db.WithContext(ctx).Order("quarantined_at DESC").Find(&accounts).Error
+	return accounts, err
+}
+
+// ListKeys returns the quarantined accounts of every tenant
+func (r *quarantineRepository) ListKeys(ctx context.Context) ([]AccountKey, error) {
+	var keys []AccountKey
+	err := r.db.WithContext(ctx).Model(&QuarantinedAccount{}).
+		Select("tenant_id, account_id").
+		Scan(&keys).Error
+	return keys, err
+}
+
+// Release removes the account from quarantine, in the tenant bound to ctx
+// when there is one
+func (r *quarantineRepository) Release(ctx context.Context, accountID string) (bool, error) {
+	result := r.db.WithContext(ctx).Scopes(tenant.Scope(ctx)).Where("account_id = ?", accountID).Delete(&QuarantinedAccount{})
+	return result.RowsAffected > 0, result.Error
+}
@@ -0,0 +1,39 @@
+package repository
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type RateLimitRepository interface {
+	List(ctx context.Context) ([]RateLimit, error)
+	Upsert(ctx context.Context, limit *RateLimit) error
+	Delete(ctx context.Context, identity string) (bool, error)
+}
+
+type rateLimitRepository struct {
+	db *gorm.DB
+}
+
+func NewRateLimitRepository(db *gorm.DB) RateLimitRepository {
+	return &rateLimitRepository{db: db}
+}
+
+func (r *rateLimitRepository) List(ctx context.Context) ([]RateLimit, error) {
+	var limits []RateLimit
+	err := r.db.WithContext(ctx).Order("identity").Find(&limits).Error
+	return limits, err
+}
+
+func (r *rateLimitRepository) Upsert(ctx context.Context, limit *RateLimit) error {
+	return r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{UpdateAll: true}).
+		Create(limit).Error
+}
+
+func (r *rateLimitRepository) Delete(ctx context.Context, identity string) (bool, error) {
+	result := r.db.WithContext(ctx).Where("identity = ?", identity).Delete(&RateLimit{})
+	return result.RowsAffected > 0, result.Error
+}
@@ -0,0 +1,102 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"sub-balance-demo/internal/pagination"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+type ReconciliationRepository interface {
+	CreateBatch(ctx context.Context, records []ReconciliationRecord) error
+	List(ctx context.Context, filter ReconciliationFilter) (pagination.Page[ReconciliationRecord], error)
+	GetSettledDeltaBySettlementID(ctx context.Context, settlementID string) (map[AccountKey]decimal.Decimal, error)
+}
+
+// ReconciliationFilter narrows reconciliation queries; zero values are ignored
+type ReconciliationFilter struct {
+	SettlementID      string
+	AccountID         string
+	DiscrepanciesOnly bool
+	Limit             int
+	Cursor            string
+}
+
+type reconciliationRepository struct {
+	db      *gorm.DB
+	replica *gorm.DB // optional, serves List
+}
+
+// NewReconciliationRepository creates the repository; replica may be nil
+func NewReconciliationRepository(db *gorm.DB, replica *gorm.DB) ReconciliationRepository {
+	return &reconciliationRepository{db: db, replica: replica}
+}
+
+func (r *reconciliationRepository) CreateBatch(ctx context.Context, records []ReconciliationRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	return r.db.WithContext(ctx).Create(&records).Error
+}
+
+// List reads from the replica when configured; reports tolerate lag
+func (r *reconciliationRepository) List(ctx context.Context, filter ReconciliationFilter) (pagination.Page[ReconciliationRecord], error) {
+	db := r.db
+	if r.replica != nil {
+		db = r.replica
+	}
+
+	query := db.WithContext(ctx).Model(&ReconciliationRecord{})
+	if filter.SettlementID != "" {
+		query = query.Where("settlement_id = ?", filter.SettlementID)
+	}
+	if filter.AccountID != "" {
+		query = query.Where("account_id = ?", filter.AccountID)
+	}
+	if filter.DiscrepanciesOnly {
+		query = query.Where("matched = ?", false)
+	}
+
+	limit := pagination.Limit(filter.Limit)
+	query, err := newestFirst.Apply(query, filter.Cursor, limit, new(time.Time), new(string))
+	if err != nil {
+		return pagination.Page[ReconciliationRecord]{}, err
+	}
+
+	var records []ReconciliationRecord
+	err = query.Find(&records).Error
+	if err != nil {
+		return pagination.Page[ReconciliationRecord]{}, err
+	}
+	return pagination.NewPage(records, limit, func(record ReconciliationRecord) []interface{} {
+		return []interface{}{record.CreatedAt, record.ID}
+	}), nil
+}
+
+// GetSettledDeltaBySettlementID sums the signed amounts (credit positive, debit
+// negative) of rows settled by a run, grouped per account
+func (r *reconciliationRepository) GetSettledDeltaBySettlementID(ctx context.Context, settlementID string) (map[AccountKey]decimal.Decimal, error) {
+	var rows []struct {
+		TenantID  string          `gorm:"column:tenant_id"`
+		AccountID string          `gorm:"column:account_id"`
+		Delta     decimal.Decimal `gorm:"column:delta"`
+	}
+
+	err := r.db.WithContext(ctx).Model(&SubBalance{}).
+		Select("tenant_id, account_id, COALESCE(SUM(CASE WHEN type = 'credit' THEN amount ELSE -amount END), 0) as delta").
+		Where("settlement_id = ? AND status = ?", settlementID, "SETTLED").
+		Group("tenant_id, account_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	deltas := make(map[AccountKey]decimal.Decimal, len(rows))
+	for _, row := range rows {
+		deltas[AccountKey{TenantID: row.TenantID, AccountID: row.AccountID}] = row.Delta
+	}
+	return deltas, nil
+}
@@ -0,0 +1,98 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RetentionTable describes a table covered by the retention policy. Only rows
+// matching Condition may expire, so live data (e.g. PENDING sub_balances) is
+// never touched regardless of its age.
+type RetentionTable struct {
+	Name      string
+	model     interface{}
+	condition string
+	args      []interface{}
+}
+
+// RetentionTables lists every table that supports soft delete and purging.
+// Settlement audit logs are append-only and deliberately not listed.
+var RetentionTables = []RetentionTable{
+	{
+		Name:      SubBalance{}.TableName(),
+		model:     &SubBalance{},
+		condition: "status IN ?",
+		args:      []interface{}{[]string{"SETTLED", "FAILED", "REJECTED"}},
+	},
+	{
+		Name:  ReconciliationRecord{}.TableName(),
+		model: &ReconciliationRecord{},
+	},
+	{
+		Name:      OutboxEvent{}.TableName(),
+		model:     &OutboxEvent{},
+		condition: "sent_at IS NOT NULL",
+	},
+}
+
+// LookupRetentionTable returns the retention table with the given name
+func LookupRetentionTable(name string) (RetentionTable, bool) {
+	for _, table := range RetentionTables {
+		if table.Name == name {
+			return table, true
+		}
+	}
+	return RetentionTable{}, false
+}
+
+type RetentionRepository interface {
+	SoftDeleteExpired(ctx context.Context, table RetentionTable, before time.Time, limit int) (int64, error)
+	PurgeDeleted(ctx context.Context, table RetentionTable, before time.Time, limit int) (int64, error)
+}
+
+type retentionRepository struct {
+	db *gorm.DB
+}
+
+func NewRetentionRepository(db *gorm.DB) RetentionRepository {
+	return &retentionRepository{db: db}
+}
+
+// SoftDeleteExpired sets deleted_at on up to limit expirable rows created
+// before the cutoff. Soft-deleted rows disappear from every GORM query but stay
+// recoverable until PurgeDeleted removes them.
+func (r *retentionRepository) SoftDeleteExpired(ctx context.Context, table RetentionTable, before time.Time, limit int) (int64, error) {
+	db := r.db.WithContext(ctx)
+
+	// Postgres tidak mendukung UPDATE/DELETE ... LIMIT, jadi batch dipilih lewat subquery
+	expired := db.Model(table.model).Select("id").Where("created_at < ?", before)
+	if table.condition != "" {
+		expired = expired.Where(table.condition, table.args...)
+	}
+	expired = expired.Order("created_at").Limit(limit)
+
+	result := db.Where("id IN (?)", expired).Delete(table.model)
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to soft delete %s: %w", table.Name, result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+// PurgeDeleted permanently removes up to limit rows soft-deleted before the cutoff
+func (r *retentionRepository) PurgeDeleted(ctx context.Context, table RetentionTable, before time.Time, limit int) (int64, error) {
+	db := r.db.WithContext(ctx)
+
+	deleted := db.Unscoped().Model(table.model).Select("id").
+		Where("deleted_at IS NOT NULL AND deleted_at < ?", before).
+		Order("deleted_at").
+		Limit(limit)
+
+	result := db.Unscoped().Where("id IN (?)", deleted).Delete(table.model)
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to purge %s: %w", table.Name, result.Error)
+	}
+	return result.RowsAffected, nil
+}
@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"context"
+
+	"sub-balance-demo/internal/tenant"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// SetBasedSettlement is the outcome of settling an account in a single statement
+type SetBasedSettlement struct {
+	Transactions     int64           `gorm:"column:transactions"`
+	Delta            decimal.Decimal `gorm:"column:delta"`
+	ResultingBalance decimal.Decimal `gorm:"column:resulting_balance"`
+	Applied          bool            `gorm:"column:applied"`
+	TransactionIDs   StringList      `gorm:"column:transaction_ids"`
+}
+
+type SettlementRepository interface {
+	SettleAccount(ctx context.Context, accountID string, settlementID string, ids []string) (*SetBasedSettlement, error)
+}
+
+type settlementRepository struct {
+	db *gorm.DB
+}
+
+func NewSettlementRepository(db *gorm.DB) SettlementRepository {
+	return &settlementRepository{db: db}
+}
+
+// settleAccountSQL stamps the PENDING rows of the batch (never rows the batch
+// did not load, soft-deleted rows or rows of another tenant), sums the signed
+// deltas and applies them to the balance in one round trip. The balance is
+// only updated when the result stays non-negative; Applied=false with
+// Transactions>0 means the caller must roll back the stamps. It is Postgres
+// only (UPDATE ... RETURNING in a CTE, json_agg): under DB_DRIVER=sqlite the
+// set-based threshold is forced to 0 and every account is settled row by row.
+const settleAccountSQL = `
+WITH stamped AS (
+	UPDATE sub_balances
+	SET status = 'SETTLED', settlement_id = @settlement_id, updated_at = NOW()
+	WHERE id IN @ids
+		AND account_id = @account_id
+		AND status = 'PENDING'
+		AND deleted_at IS NULL
+		AND (CAST(@tenant_id AS text) = '' OR tenant_id = @tenant_id)
+	RETURNING id, type, amount
+), delta AS (
+	SELECT COUNT(*) AS transactions,
+		COALESCE(SUM(CASE WHEN type = 'credit' THEN amount ELSE -amount END), 0) AS delta,
+		COALESCE(json_agg(id), '[]')::text AS transaction_ids
+	FROM stamped
+), updated AS (
+	UPDATE account_balances ab
+	SET settled_balance = ab.settled_balance + delta.delta,
+		pending_debit = 0,
+		pending_credit = 0,
+		available_balance = ab.settled_balance + delta.delta,
+		version = ab.version + 1,
+		last_settlement_at = NOW(),
+		last_settlement_id = @settlement_id,
+		updated_at = NOW()
+	FROM delta
+	WHERE ab.id = @account_id
+		AND (CAST(@tenant_id AS text) = '' OR ab.tenant_id = @tenant_id)
+		AND delta.transactions > 0
+		AND ab.settled_balance + ab.pending_credit - ab.pending_debit + delta.delta >= 0
+	RETURNING ab.settled_balance
+)
+SELECT delta.transactions,
+	delta.delta,
+	COALESCE((SELECT settled_balance FROM updated), 0) AS resulting_balance,
+	EXISTS (SELECT 1 FROM updated) AS applied,
+	delta.transaction_ids
+FROM delta`
+
+func (r *settlementRepository) SettleAccount(ctx context.Context, accountID string, settlementID string, ids []string) (*SetBasedSettlement, error) {
+	var result SetBasedSettlement
+	if len(ids) == 0 {
+		return &result, nil
+	}
+	tenantID, _ := tenant.FromContext(ctx)
+	err := r.db.WithContext(ctx).Raw(settleAccountSQL, map[string]interface{}{
+		"ids":           ids,
+		"account_id":    accountID,
+		"settlement_id": settlementID,
+		"tenant_id":     tenantID,
+	}).Scan(&result).Error
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"sub-balance-demo/internal/pagination"
+
+	"gorm.io/gorm"
+)
+
+// SettlementAuditRepository is append-only: audit rows are never updated or deleted
+type SettlementAuditRepository interface {
+	Create(ctx context.Context, entry *SettlementAuditLog) error
+	List(ctx context.Context, filter SettlementAuditFilter) (pagination.Page[SettlementAuditLog], error)
+	WithTx(tx *gorm.DB) SettlementAuditRepository
+}
+
+// SettlementAuditFilter narrows audit queries; zero values are ignored
+type SettlementAuditFilter struct {
+	SettlementID string
+	AccountID    string
+	Limit        int
+	Cursor       string
+}
+
+type settlementAuditRepository struct {
+	db      *gorm.DB
+	replica *gorm.DB // optional, serves List
+}
+
+// NewSettlementAuditRepository creates the repository; replica may be nil
+func NewSettlementAuditRepository(db *gorm.DB, replica *gorm.DB) SettlementAuditRepository {
+	return &settlementAuditRepository{db: db, replica: replica}
+}
+
+// WithTx returns a repository bound to the given transaction
+func (r *settlementAuditRepository) WithTx(tx *gorm.DB) SettlementAuditRepository {
+	return &settlementAuditRepository{db: tx}
+}
+
+func (r *settlementAuditRepository) Create(ctx context.Context, entry *SettlementAuditLog) error {
+	entry.CreatedAt = time.Now()
+	return r.db.WithContext(ctx).Create(entry).Error
+}
+
+// List reads from the replica when configured; audit history tolerates lag
+func (r *settlementAuditRepository) List(ctx context.Context, filter SettlementAuditFilter) (pagination.Page[SettlementAuditLog], error) {
+	db := r.db
+	if r.replica != nil {
+		db = r.replica
+	}
+
+	query := db.WithContext(ctx).Model(&SettlementAuditLog{})
+	if filter.SettlementID != "" {
+		query = query.Where("settlement_id = ?", filter.SettlementID)
+	}
+	if filter.AccountID != "" {
+		query = query.Where("account_id = ?", filter.AccountID)
+	}
+
+	limit := pagination.Limit(filter.Limit)
+	query, err := newestFirst.Apply(query, filter.Cursor, limit, new(time.Time), new(string))
+	if err != nil {
+		return pagination.Page[SettlementAuditLog]{}, err
+	}
+
+	var entries []SettlementAuditLog
+	err = query.Find(&entries).Error
+	if err != nil {
+		return pagination.Page[SettlementAuditLog]{}, err
+	}
+	return pagination.NewPage(entries, limit, func(entry SettlementAuditLog) []interface{} {
+		return []interface{}{entry.CreatedAt, entry.ID}
+	}), nil
+}
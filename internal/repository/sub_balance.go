@@ -4,6 +4,9 @@ import (
 	"context"
 	"time"
 
+	"sub-balance-demo/internal/pagination"
+	"sub-balance-demo/internal/tenant"
+
 	"github.com/shopspring/decimal"
 	"gorm.io/gorm"
 )
@@ -11,23 +14,49 @@ import (
 type SubBalanceRepository interface {
 	Create(ctx context.Context, subBalance *SubBalance) error
 	GetPendingByAccountID(ctx context.Context, accountID string) ([]SubBalance, error)
-	GetAllPending(ctx context.Context) ([]SubBalance, error)
+	GetPendingBatch(ctx context.Context, cursor string, limit int, now time.Time) (pagination.Page[SubBalance], error)
 	UpdateStatus(ctx context.Context, id string, status string) error
 	UpdateStatusBatch(ctx context.Context, ids []string, status string) error
 	GetPendingCountByAccountID(ctx context.Context, accountID string) (int64, error)
 	GetPendingTotalByAccountID(ctx context.Context, accountID string) (decimal.Decimal, error)
 	GetTotalPendingByAccountID(ctx context.Context, accountID string, total *decimal.Decimal) error
+	StampSettlement(ctx context.Context, ids []string, status string, settlementID string) (int64, error)
+	GetBySettlementID(ctx context.Context, accountID string, settlementID string) ([]SubBalance, error)
+	IncrementAttempts(ctx context.Context, ids []string, nextAttemptAt time.Time) error
+	GetPendingSumsByAccountIDs(ctx context.Context, accountIDs []string) (map[string]PendingSums, error)
+	WithTx(tx *gorm.DB) SubBalanceRepository
+	Reader() SubBalanceRepository
 }
 
 type subBalanceRepository struct {
-	db *gorm.DB
+	db      *gorm.DB
+	replica *gorm.DB // optional read replica, see Reader
+}
+
+// NewSubBalanceRepository creates the repository; replica may be nil
+func NewSubBalanceRepository(db *gorm.DB, replica *gorm.DB) SubBalanceRepository {
+	return &subBalanceRepository{db: db, replica: replica}
 }
 
-func NewSubBalanceRepository(db *gorm.DB) SubBalanceRepository {
-	return &subBalanceRepository{db: db}
+// WithTx returns a repository bound to the given transaction
+func (r *subBalanceRepository) WithTx(tx *gorm.DB) SubBalanceRepository {
+	return &subBalanceRepository{db: tx}
 }
 
+// Reader returns a repository bound to the read replica (or the primary when
+// none is configured), for listings that tolerate replication lag
+func (r *subBalanceRepository) Reader() SubBalanceRepository {
+	if r.replica == nil {
+		return r
+	}
+	return &subBalanceRepository{db: r.replica}
+}
+
+// Create stores the row under the tenant bound to ctx unless TenantID is set
 func (r *subBalanceRepository) Create(ctx context.Context, subBalance *SubBalance) error {
+	if subBalance.TenantID == "" {
+		subBalance.TenantID = tenant.ID(ctx)
+	}
 	subBalance.CreatedAt = time.Now()
 	subBalance.UpdatedAt = time.Now()
 	subBalance.Status = "PENDING"
@@ -36,30 +65,73 @@ func (r *subBalanceRepository) Create(ctx context.Context, subBalance *SubBalanc
 
 func (r *subBalanceRepository) GetPendingByAccountID(ctx context.Context, accountID string) ([]SubBalance, error) {
 	var subBalances []SubBalance
-	err := r.db.WithContext(ctx).
+	err := r.db.WithContext(ctx).Scopes(tenant.Scope(ctx)).
 		Where("account_id = ? AND status = ?", accountID, "PENDING").
 		Order("created_at ASC").
 		Find(&subBalances).Error
 	return subBalances, err
 }
 
-func (r *subBalanceRepository) GetAllPending(ctx context.Context) ([]SubBalance, error) {
-	var subBalances []SubBalance
-	err := r.db.WithContext(ctx).
-		Where("status = ?", "PENDING").
-		Order("account_id, created_at ASC").
-		Find(&subBalances).Error
-	return subBalances, err
+// pendingByAccount orders pending rows so each account's rows are contiguous;
+// account IDs are unique per tenant only
+var pendingByAccount = pagination.Sort{Columns: []string{"tenant_id", "account_id", "id"}}
+
+func pendingCursorKey(row SubBalance) []interface{} {
+	return []interface{}{row.TenantID, row.AccountID, row.ID}
+}
+
+// dueAt narrows to rows whose retry delay has passed at now
+func dueAt(now time.Time) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("next_attempt_at IS NULL OR next_attempt_at <= ?", now)
+	}
+}
+
+// GetPendingBatch returns the next page of PENDING rows due at now, ordered by
+// account. The page is extended past limit so the last account is complete:
+// an account is never split across two batches.
+func (r *subBalanceRepository) GetPendingBatch(ctx context.Context, cursor string, limit int, now time.Time) (pagination.Page[SubBalance], error) {
+	query, err := pendingByAccount.Apply(r.db.WithContext(ctx).Scopes(tenant.Scope(ctx), dueAt(now)).Where("status = ?", "PENDING"), cursor, limit, new(string), new(string), new(string))
+	if err != nil {
+		return pagination.Page[SubBalance]{}, err
+	}
+
+	var rows []SubBalance
+	err = query.Find(&rows).Error
+	if err != nil {
+		return pagination.Page[SubBalance]{}, err
+	}
+
+	page := pagination.NewPage(rows, limit, pendingCursorKey)
+	if page.NextCursor == "" {
+		return page, nil
+	}
+
+	// Sisa row account terakhir ikut batch ini
+	last := page.Items[len(page.Items)-1]
+	var rest []SubBalance
+	err = r.db.WithContext(ctx).Scopes(tenant.Scope(ctx), dueAt(now)).
+		Where("tenant_id = ? AND account_id = ? AND status = ? AND id > ?", last.TenantID, last.AccountID, "PENDING", last.ID).
+		Order("id ASC").
+		Find(&rest).Error
+	if err != nil {
+		return pagination.Page[SubBalance]{}, err
+	}
+	if len(rest) > 0 {
+		page.Items = append(page.Items, rest...)
+		page.NextCursor = pagination.Encode(pendingCursorKey(rest[len(rest)-1])...)
+	}
+	return page, nil
 }
 
 func (r *subBalanceRepository) UpdateStatus(ctx context.Context, id string, status string) error {
-	return r.db.WithContext(ctx).Model(&SubBalance{}).
+	return r.db.WithContext(ctx).Model(&SubBalance{}).Scopes(tenant.Scope(ctx)).
 		Where("id = ?", id).
 		Update("status", status).Error
 }
 
 func (r *subBalanceRepository) UpdateStatusBatch(ctx context.Context, ids []string, status string) error {
-	return r.db.WithContext(ctx).Model(&SubBalance{}).
+	return r.db.WithContext(ctx).Model(&SubBalance{}).Scopes(tenant.Scope(ctx)).
 		Where("id IN ?", ids).
 		Updates(map[string]interface{}{
 			"status":     status,
@@ -69,7 +141,7 @@ func (r *subBalanceRepository) UpdateStatusBatch(ctx context.Context, ids []stri
 
 func (r *subBalanceRepository) GetPendingCountByAccountID(ctx context.Context, accountID string) (int64, error) {
 	var count int64
-	err := r.db.WithContext(ctx).Model(&SubBalance{}).
+	err := r.db.WithContext(ctx).Model(&SubBalance{}).Scopes(tenant.Scope(ctx)).
 		Where("account_id = ? AND status = ?", accountID, "PENDING").
 		Count(&count).Error
 	return count, err
@@ -80,7 +152,7 @@ func (r *subBalanceRepository) GetPendingTotalByAccountID(ctx context.Context, a
 		Total decimal.Decimal `gorm:"column:total"`
 	}
 
-	err := r.db.WithContext(ctx).Model(&SubBalance{}).
+	err := r.db.WithContext(ctx).Model(&SubBalance{}).Scopes(tenant.Scope(ctx)).
 		Select("COALESCE(SUM(amount), 0) as total").
 		Where("account_id = ? AND status = ?", accountID, "PENDING").
 		Scan(&result).Error
@@ -94,9 +166,77 @@ func (r *subBalanceRepository) GetPendingTotalByAccountID(ctx context.Context, a
 
 func (r *subBalanceRepository) GetTotalPendingByAccountID(ctx context.Context, accountID string, total *decimal.Decimal) error {
 	err := r.db.WithContext(ctx).
-		Model(&SubBalance{}).
+		Model(&SubBalance{}).Scopes(tenant.Scope(ctx)).
 		Where("account_id = ? AND status = ?", accountID, "PENDING").
 		Select("COALESCE(SUM(amount), 0)").
 		Scan(total).Error
 	return err
 }
+
+// StampSettlement moves still-PENDING rows to the given status and records the
+// settlement run that did it. Rows already claimed by another run are left
+// untouched, so the returned count is the number of rows this run owns.
+func (r *subBalanceRepository) StampSettlement(ctx context.Context, ids []string, status string, settlementID string) (int64, error) {
+	result := r.db.WithContext(ctx).Model(&SubBalance{}).Scopes(tenant.Scope(ctx)).
+		Where("id IN ? AND status = ?", ids, "PENDING").
+		Updates(map[string]interface{}{
+			"status":        status,
+			"settlement_id": settlementID,
+			"updated_at":    time.Now(),
+		})
+	return result.RowsAffected, result.Error
+}
+
+func (r *subBalanceRepository) GetBySettlementID(ctx context.Context, accountID string, settlementID string) ([]SubBalance, error) {
+	var subBalances []SubBalance
+	err := r.db.WithContext(ctx).Scopes(tenant.Scope(ctx)).
+		Where("account_id = ? AND settlement_id = ?", accountID, settlementID).
+		Order("created_at ASC").
+		Find(&subBalances).Error
+	return subBalances, err
+}
+
+// IncrementAttempts counts a rejected settlement attempt and keeps the rows
+// out of settlement batches until nextAttemptAt
+func (r *subBalanceRepository) IncrementAttempts(ctx context.Context, ids []string, nextAttemptAt time.Time) error {
+	return r.db.WithContext(ctx).Model(&SubBalance{}).Scopes(tenant.Scope(ctx)).
+		Where("id IN ? AND status = ?", ids, "PENDING").
+		Updates(map[string]interface{}{
+			"attempts":        gorm.Expr("attempts + 1"),
+			"next_attempt_at": nextAttemptAt,
+			"updated_at":      time.Now(),
+		}).Error
+}
+
+// PendingSums are the pending debit and credit totals of one account
+type PendingSums struct {
+	AccountID string          `gorm:"column:account_id"`
+	Debit     decimal.Decimal `gorm:"column:debit"`
+	Credit    decimal.Decimal `gorm:"column:credit"`
+}
+
+// GetPendingSumsByAccountIDs returns the pending sums per side for the given
+// accounts in one grouped query. Accounts without pending rows are omitted.
+func (r *subBalanceRepository) GetPendingSumsByAccountIDs(ctx context.Context, accountIDs []string) (map[string]PendingSums, error) {
+	result := make(map[string]PendingSums, len(accountIDs))
+	if len(accountIDs) == 0 {
+		return result, nil
+	}
+
+	var rows []PendingSums
+	err := r.db.WithContext(ctx).Model(&SubBalance{}).Scopes(tenant.Scope(ctx)).
+		Select(`account_id,
+			COALESCE(SUM(CASE WHEN type = 'debit' THEN amount ELSE 0 END), 0) AS debit,
+			COALESCE(SUM(CASE WHEN type = 'credit' THEN amount ELSE 0 END), 0) AS credit`).
+		Where("account_id IN ? AND status = ?", accountIDs, "PENDING").
+		Group("account_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		result[row.AccountID] = row
+	}
+	return result, nil
+}
@@ -0,0 +1,171 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestGetPendingBatchSkipsRowsNotDue(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	if err := db.AutoMigrate(&SubBalance{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	repo := NewSubBalanceRepository(db, nil)
+	ctx := context.Background()
+	for _, id := range []string{"a", "b", "c"} {
+		err := repo.Create(ctx, &SubBalance{ID: id, AccountID: "acc-1", Amount: decimal.NewFromInt(10), Type: "debit"})
+		if err != nil {
+			t.Fatalf("Create(%s): %v", id, err)
+		}
+	}
+
+	now := time.Now()
+	if err := repo.IncrementAttempts(ctx, []string{"b"}, now.Add(time.Minute)); err != nil {
+		t.Fatalf("IncrementAttempts: %v", err)
+	}
+
+	ids := func(at time.Time) []string {
+		page, err := repo.GetPendingBatch(ctx, "", 10, at)
+		if err != nil {
+			t.Fatalf("GetPendingBatch: %v", err)
+		}
+		var ids []string
+		for _, row := range page.Items {
+			ids = append(ids, row.ID)
+		}
+		return ids
+	}
+	if got := ids(now); len(got) != 2 || got[0] != "a" || got[1] != "c" {
+		t.Fatalf("batch before the retry delay = %v, want [a c]", got)
+	}
+	if got := ids(now.Add(2 * time.Minute)); len(got) != 3 {
+		t.Fatalf("batch after the retry delay = %v, want [a b c]", got)
+	}
+
+	var row SubBalance
+	db.First(&row, "id = ?", "b")
+	if row.Attempts != 1 || row.NextAttemptAt == nil {
+		t.Fatalf("row b attempts=%d next_attempt_at=%v", row.Attempts, row.NextAttemptAt)
+	}
+}
+
+func TestSubBalanceMetadataRoundTrip(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	if err := db.AutoMigrate(&SubBalance{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	repo := NewSubBalanceRepository(db, nil)
+	ctx := context.Background()
+	rows := []SubBalance{
+		{ID: "with", AccountID: "acc-1", Amount: decimal.NewFromInt(10), Type: "credit", Metadata: Metadata{"invoice": "INV-1"}},
+		{ID: "without", AccountID: "acc-1", Amount: decimal.NewFromInt(10), Type: "credit"},
+	}
+	for i := range rows {
+		if err := repo.Create(ctx, &rows[i]); err != nil {
+			t.Fatalf("Create(%s): %v", rows[i].ID, err)
+		}
+	}
+
+	var stored *string
+	db.Raw("SELECT metadata FROM sub_balances WHERE id = ?", "without").Scan(&stored)
+	if stored != nil {
+		t.Fatalf("empty metadata stored as %q, want NULL", *stored)
+	}
+
+	items, err := repo.GetPendingByAccountID(ctx, "acc-1")
+	if err != nil {
+		t.Fatalf("GetPendingByAccountID: %v", err)
+	}
+	got := map[string]Metadata{}
+	for _, item := range items {
+		got[item.ID] = item.Metadata
+	}
+	if got["with"]["invoice"] != "INV-1" || len(got["with"]) != 1 {
+		t.Fatalf("metadata = %v, want map[invoice:INV-1]", got["with"])
+	}
+	if got["without"] != nil {
+		t.Fatalf("metadata = %v, want nil", got["without"])
+	}
+}
+
+func TestGetPendingBatchKeepsAccountsWhole(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	if err := db.AutoMigrate(&SubBalance{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	repo := NewSubBalanceRepository(db, nil)
+	// ACC001 ada di dua tenant; setiap pasangan (tenant, account) satu grup
+	rows := []SubBalance{
+		{ID: "1", TenantID: "acme", AccountID: "ACC001"},
+		{ID: "2", TenantID: "acme", AccountID: "ACC001"},
+		{ID: "3", TenantID: "acme", AccountID: "ACC001"},
+		{ID: "4", TenantID: "acme", AccountID: "ACC002"},
+		{ID: "5", TenantID: "globex", AccountID: "ACC001"},
+		{ID: "6", TenantID: "globex", AccountID: "ACC001"},
+	}
+	for i := range rows {
+		rows[i].Amount, rows[i].Type, rows[i].Status = decimal.NewFromInt(1), "credit", "PENDING"
+		if err := db.Create(&rows[i]).Error; err != nil {
+			t.Fatalf("create %s: %v", rows[i].ID, err)
+		}
+	}
+
+	tests := []struct {
+		limit int
+		want  []string // id per batch
+	}{
+		{1, []string{"123", "4", "56"}},
+		{2, []string{"123", "456"}},
+		{4, []string{"1234", "56"}},
+		{10, []string{"123456"}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("limit %d", tt.limit), func(t *testing.T) {
+			var batches []string
+			cursor := ""
+			for {
+				page, err := repo.GetPendingBatch(context.Background(), cursor, tt.limit, time.Now())
+				if err != nil {
+					t.Fatalf("GetPendingBatch: %v", err)
+				}
+				batch := ""
+				for _, row := range page.Items {
+					batch += row.ID
+				}
+				// Halaman terakhir yang pas penuh diikuti satu halaman kosong
+				if batch != "" {
+					batches = append(batches, batch)
+				}
+				if page.NextCursor == "" {
+					break
+				}
+				cursor = page.NextCursor
+			}
+			if fmt.Sprint(batches) != fmt.Sprint(tt.want) {
+				t.Fatalf("batches = %v, want %v", batches, tt.want)
+			}
+		})
+	}
+}
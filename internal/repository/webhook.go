@@ -0,0 +1,233 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"sub-balance-demo/internal/pagination"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type WebhookRepository interface {
+	CreateSubscription(ctx context.Context, subscription *WebhookSubscription) error
+	ListSubscriptions(ctx context.Context) ([]WebhookSubscription, error)
+	DeleteSubscription(ctx context.Context, id string) (bool, error)
+	RotateSecret(ctx context.Context, id string, secret string, previousExpiresAt time.Time) (*WebhookSubscription, error)
+
+	Enqueue(ctx context.Context, deliveries []WebhookDelivery) error
+	ClaimDue(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]WebhookDelivery, error)
+	RecordAttempt(ctx context.Context, delivery *WebhookDelivery, attempt *WebhookDeliveryAttempt) error
+	GetDelivery(ctx context.Context, id int64) (*WebhookDelivery, []WebhookDeliveryAttempt, error)
+	ListDeliveries(ctx context.Context, filter WebhookDeliveryFilter) (pagination.Page[WebhookDelivery], error)
+	Replay(ctx context.Context, id int64, now time.Time) (bool, error)
+	WithTx(tx *gorm.DB) WebhookRepository
+}
+
+// WebhookDeliveryFilter narrows delivery queries; zero values are ignored
+type WebhookDeliveryFilter struct {
+	SubscriptionID string
+	Status         string
+	AccountID      string
+	Limit          int
+	Cursor         string
+}
+
+// newestDeliveryFirst orders deliveries by ID, which is their queue order
+var newestDeliveryFirst = pagination.Sort{Columns: []string{"id"}, Desc: true}
+
+type webhookRepository struct {
+	db *gorm.DB
+}
+
+func NewWebhookRepository(db *gorm.DB) WebhookRepository {
+	return &webhookRepository{db: db}
+}
+
+// WithTx returns a repository bound to the given transaction
+func (r *webhookRepository) WithTx(tx *gorm.DB) WebhookRepository {
+	return &webhookRepository{db: tx}
+}
+
+func (r *webhookRepository) CreateSubscription(ctx context.Context, subscription *WebhookSubscription) error {
+	subscription.CreatedAt = time.Now()
+	return r.db.WithContext(ctx).Create(subscription).Error
+}
+
+func (r *webhookRepository) ListSubscriptions(ctx context.Context) ([]WebhookSubscription, error) {
+	var subscriptions []WebhookSubscription
+	err := r.db.WithContext(ctx).Order("created_at, id").Find(&subscriptions).Error
+	return subscriptions, err
+}
+
+// DeleteSubscription soft-deletes a subscription and cancels its pending
+// deliveries in the same transaction; sent and dead-lettered ones are kept
+// as they are
+func (r *webhookRepository) DeleteSubscription(ctx context.Context, id string) (bool, error) {
+	deleted := false
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ?", id).Delete(&WebhookSubscription{})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		deleted = true
+		return tx.Model(&WebhookDelivery{}).
+			Where("subscription_id = ? AND status = ?", id, WebhookPending).
+			Update("status", WebhookCancelled).Error
+	})
+	return deleted, err
+}
+
+// RotateSecret makes secret the current secret of a subscription and keeps the
+// old one as previous secret until previousExpiresAt, replacing any previous
+// secret of an earlier rotation. It returns gorm.ErrRecordNotFound for an
+// unknown or deleted subscription.
+func (r *webhookRepository) RotateSecret(ctx context.Context, id string, secret string, previousExpiresAt time.Time) (*WebhookSubscription, error) {
+	// SET memakai nilai lama kolom secret, jadi previous_secret = secret lama
+	result := r.db.WithContext(ctx).Model(&WebhookSubscription{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"previous_secret":            gorm.Expr("secret"),
+			"previous_secret_expires_at": previousExpiresAt,
+			"secret":                     secret,
+		})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	var subscription WebhookSubscription
+	err := r.db.WithContext(ctx).Where("id = ?", id).First(&subscription).Error
+	return &subscription, err
+}
+
+// Enqueue inserts deliveries, skipping events already queued for the same
+// subscription, so a redelivered stream entry is not sent twice
+func (r *webhookRepository) Enqueue(ctx context.Context, deliveries []WebhookDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+	now := time.Now()
+	for i := range deliveries {
+		deliveries[i].CreatedAt = now
+	}
+	return r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&deliveries).Error
+}
+
+// ClaimDue claims the head of each subscription's queue, its oldest pending
+// delivery, when it is due: the row is locked with FOR UPDATE SKIP LOCKED and
+// leased by moving next_attempt_at lease ahead, so the HTTP call happens
+// after the claim commits and a worker that dies mid-call only delays the
+// delivery until the lease runs out. Later deliveries of a subscription wait
+// behind its head, so each endpoint receives events in order and a failing
+// endpoint only holds up its own queue. Deliveries of deleted subscriptions
+// are never claimed. It must be called on a repository bound with WithTx.
+func (r *webhookRepository) ClaimDue(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]WebhookDelivery, error) {
+	if _, ok := r.db.Statement.ConnPool.(gorm.TxCommitter); !ok {
+		return nil, ErrNotInTransaction
+	}
+
+	var deliveries []WebhookDelivery
+	err := r.db.WithContext(ctx).
+		Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("status = ? AND next_attempt_at <= ?", WebhookPending, now).
+		Where("subscription_id IN (SELECT id FROM webhook_subscriptions WHERE deleted_at IS NULL)").
+		Where(`NOT EXISTS (SELECT 1 FROM webhook_deliveries earlier
+			WHERE earlier.subscription_id = webhook_deliveries.subscription_id
+			AND earlier.status = ? AND earlier.id < webhook_deliveries.id)`, WebhookPending).
+		Order("id").
+		Limit(limit).
+		Find(&deliveries).Error
+	if err != nil || len(deliveries) == 0 {
+		return deliveries, err
+	}
+
+	ids := make([]int64, len(deliveries))
+	for i := range deliveries {
+		ids[i] = deliveries[i].ID
+	}
+	err = r.db.WithContext(ctx).Model(&WebhookDelivery{}).
+		Where("id IN ?", ids).
+		Update("next_attempt_at", now.Add(lease)).Error
+	return deliveries, err
+}
+
+// RecordAttempt stores the attempt and the delivery's new state. A delivery
+// cancelled while its attempt was in flight stays cancelled.
+func (r *webhookRepository) RecordAttempt(ctx context.Context, delivery *WebhookDelivery, attempt *WebhookDeliveryAttempt) error {
+	attempt.CreatedAt = time.Now()
+	if err := r.db.WithContext(ctx).Create(attempt).Error; err != nil {
+		return err
+	}
+	return r.db.WithContext(ctx).Model(&WebhookDelivery{}).
+		Where("id = ? AND status = ?", delivery.ID, WebhookPending).
+		Updates(map[string]interface{}{
+			"status":           delivery.Status,
+			"attempts":         delivery.Attempts,
+			"next_attempt_at":  delivery.NextAttemptAt,
+			"last_status_code": delivery.LastStatusCode,
+			"last_error":       delivery.LastError,
+			"delivered_at":     delivery.DeliveredAt,
+		}).Error
+}
+
+// GetDelivery returns a delivery with its attempts, oldest first, or
+// gorm.ErrRecordNotFound
+func (r *webhookRepository) GetDelivery(ctx context.Context, id int64) (*WebhookDelivery, []WebhookDeliveryAttempt, error) {
+	var delivery WebhookDelivery
+	if err := r.db.WithContext(ctx).Where("id = ?", id).First(&delivery).Error; err != nil {
+		return nil, nil, err
+	}
+	var attempts []WebhookDeliveryAttempt
+	err := r.db.WithContext(ctx).Where("delivery_id = ?", id).Order("id").Find(&attempts).Error
+	return &delivery, attempts, err
+}
+
+func (r *webhookRepository) ListDeliveries(ctx context.Context, filter WebhookDeliveryFilter) (pagination.Page[WebhookDelivery], error) {
+	query := r.db.WithContext(ctx).Model(&WebhookDelivery{})
+	if filter.SubscriptionID != "" {
+		query = query.Where("subscription_id = ?", filter.SubscriptionID)
+	}
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if filter.AccountID != "" {
+		query = query.Where("account_id = ?", filter.AccountID)
+	}
+
+	limit := pagination.Limit(filter.Limit)
+	query, err := newestDeliveryFirst.Apply(query, filter.Cursor, limit, new(int64))
+	if err != nil {
+		return pagination.Page[WebhookDelivery]{}, err
+	}
+
+	var deliveries []WebhookDelivery
+	err = query.Find(&deliveries).Error
+	if err != nil {
+		return pagination.Page[WebhookDelivery]{}, err
+	}
+	return pagination.NewPage(deliveries, limit, func(delivery WebhookDelivery) []interface{} {
+		return []interface{}{delivery.ID}
+	}), nil
+}
+
+// Replay queues a delivered or dead-lettered delivery of a subscription that
+// still exists again, due now, with a fresh attempt budget; its earlier
+// attempts are kept
+func (r *webhookRepository) Replay(ctx context.Context, id int64, now time.Time) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&WebhookDelivery{}).
+		Where("id = ? AND status IN ?", id, []string{WebhookDelivered, WebhookFailed}).
+		Where("subscription_id IN (SELECT id FROM webhook_subscriptions WHERE deleted_at IS NULL)").
+		Updates(map[string]interface{}{
+			"status":          WebhookPending,
+			"attempts":        0,
+			"next_attempt_at": now,
+			"delivered_at":    nil,
+		})
+	return result.RowsAffected > 0, result.Error
+}
@@ -0,0 +1,147 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newWebhookTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1) // satu koneksi, satu database in-memory
+	if err := db.AutoMigrate(&WebhookSubscription{}, &WebhookDelivery{}, &WebhookDeliveryAttempt{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return db
+}
+
+func seedWebhook(t *testing.T, repo WebhookRepository, id string, statuses ...string) []WebhookDelivery {
+	t.Helper()
+	ctx := context.Background()
+	if err := repo.CreateSubscription(ctx, &WebhookSubscription{ID: id, URL: "https://example.com", Secret: "whsec_old"}); err != nil {
+		t.Fatalf("CreateSubscription: %v", err)
+	}
+	deliveries := make([]WebhookDelivery, len(statuses))
+	for i, status := range statuses {
+		deliveries[i] = WebhookDelivery{
+			SubscriptionID: id,
+			EventID:        id + "-" + status + "-" + string(rune('a'+i)),
+			EventType:      "transaction.settled",
+			Payload:        "{}",
+			Status:         status,
+			NextAttemptAt:  time.Now(),
+		}
+	}
+	if err := repo.Enqueue(ctx, deliveries); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	return deliveries
+}
+
+func deliveryStatus(t *testing.T, db *gorm.DB, id int64) string {
+	t.Helper()
+	var delivery WebhookDelivery
+	if err := db.First(&delivery, id).Error; err != nil {
+		t.Fatalf("load delivery %d: %v", id, err)
+	}
+	return delivery.Status
+}
+
+func TestDeleteSubscriptionCancelsQueuedDeliveries(t *testing.T) {
+	db := newWebhookTestDB(t)
+	repo := NewWebhookRepository(db)
+	ctx := context.Background()
+	deleted := seedWebhook(t, repo, "sub-deleted", WebhookPending, WebhookPending, WebhookDelivered, WebhookFailed)
+	kept := seedWebhook(t, repo, "sub-kept", WebhookPending)
+
+	ok, err := repo.DeleteSubscription(ctx, "sub-deleted")
+	if err != nil || !ok {
+		t.Fatalf("DeleteSubscription = %v, %v", ok, err)
+	}
+	want := []string{WebhookCancelled, WebhookCancelled, WebhookDelivered, WebhookFailed}
+	for i, delivery := range deleted {
+		if got := deliveryStatus(t, db, delivery.ID); got != want[i] {
+			t.Errorf("delivery %d status = %s, want %s", i, got, want[i])
+		}
+	}
+	if got := deliveryStatus(t, db, kept[0].ID); got != WebhookPending {
+		t.Errorf("delivery of another subscription = %s, want pending", got)
+	}
+
+	if ok, err := repo.DeleteSubscription(ctx, "sub-deleted"); err != nil || ok {
+		t.Fatalf("second DeleteSubscription = %v, %v, want false", ok, err)
+	}
+
+	// Attempt yang sedang berjalan saat dihapus tidak menghidupkan delivery lagi
+	in := deleted[0]
+	in.Attempts, in.Status, in.LastError = 1, WebhookPending, "timeout"
+	if err := repo.RecordAttempt(ctx, &in, &WebhookDeliveryAttempt{DeliveryID: in.ID, Attempt: 1}); err != nil {
+		t.Fatalf("RecordAttempt: %v", err)
+	}
+	if got := deliveryStatus(t, db, in.ID); got != WebhookCancelled {
+		t.Errorf("status after in-flight attempt = %s, want cancelled", got)
+	}
+
+	// Delivery subscription yang sudah dihapus tidak bisa di-replay
+	for _, delivery := range deleted {
+		if replayed, err := repo.Replay(ctx, delivery.ID, time.Now()); err != nil || replayed {
+			t.Errorf("Replay(%d) of a deleted subscription = %v, %v", delivery.ID, replayed, err)
+		}
+	}
+}
+
+func TestReplayOnlyFinishedDeliveries(t *testing.T) {
+	db := newWebhookTestDB(t)
+	repo := NewWebhookRepository(db)
+	ctx := context.Background()
+	deliveries := seedWebhook(t, repo, "sub-1", WebhookPending, WebhookDelivered, WebhookFailed)
+
+	for i, want := range []bool{false, true, true} {
+		replayed, err := repo.Replay(ctx, deliveries[i].ID, time.Now())
+		if err != nil || replayed != want {
+			t.Errorf("Replay(%s) = %v, %v, want %v", deliveries[i].EventID, replayed, err, want)
+		}
+	}
+}
+
+func TestRotateSecretKeepsPreviousSecret(t *testing.T) {
+	db := newWebhookTestDB(t)
+	repo := NewWebhookRepository(db)
+	ctx := context.Background()
+	seedWebhook(t, repo, "sub-1")
+
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+	subscription, err := repo.RotateSecret(ctx, "sub-1", "whsec_new", expires)
+	if err != nil {
+		t.Fatalf("RotateSecret: %v", err)
+	}
+	if subscription.Secret != "whsec_new" || subscription.PreviousSecret != "whsec_old" {
+		t.Fatalf("secrets = %q/%q, want whsec_new/whsec_old", subscription.Secret, subscription.PreviousSecret)
+	}
+	if subscription.PreviousSecretExpiresAt == nil || !subscription.PreviousSecretExpiresAt.Equal(expires) {
+		t.Fatalf("previous secret expires at %v, want %v", subscription.PreviousSecretExpiresAt, expires)
+	}
+
+	// Rotasi kedua: secret sebelumnya diganti, bukan ditumpuk
+	subscription, err = repo.RotateSecret(ctx, "sub-1", "whsec_newer", expires)
+	if err != nil || subscription.PreviousSecret != "whsec_new" {
+		t.Fatalf("second RotateSecret previous = %q, %v, want whsec_new", subscription.PreviousSecret, err)
+	}
+
+	if _, err := repo.DeleteSubscription(ctx, "sub-1"); err != nil {
+		t.Fatalf("DeleteSubscription: %v", err)
+	}
+	if _, err := repo.RotateSecret(ctx, "sub-1", "whsec_x", expires); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("RotateSecret of a deleted subscription error = %v, want ErrRecordNotFound", err)
+	}
+}
@@ -0,0 +1,85 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"sub-balance-demo/internal/awsauth"
+)
+
+// awsProvider calls Secrets Manager's GetSecretValue, signed with Signature
+// Version 4 by awsauth
+type awsProvider struct {
+	endpoint    string
+	region      string
+	client      *http.Client
+	credentials *awsauth.Provider
+}
+
+func newAWSProvider() (Provider, error) {
+	p := &awsProvider{
+		region:   awsauth.Region(),
+		endpoint: os.Getenv("AWS_ENDPOINT_URL_SECRETS_MANAGER"),
+		client:   &http.Client{Timeout: fetchTimeout},
+	}
+	if p.region == "" {
+		return nil, errors.New("AWS_REGION is required for awssm:// secrets")
+	}
+	credentials, err := awsauth.FromEnv(p.region, os.Getenv("AWS_ENDPOINT_URL_STS"), p.client)
+	if err != nil {
+		return nil, fmt.Errorf("%w for awssm:// secrets", err)
+	}
+	p.credentials = credentials
+	if p.endpoint == "" {
+		p.endpoint = "https://secretsmanager." + p.region + ".amazonaws.com"
+	}
+	return p, nil
+}
+
+// Fetch returns the SecretString of the secret named or ARN'd by ref
+func (p *awsProvider) Fetch(ctx context.Context, ref string) (string, error) {
+	body, _ := json.Marshal(map[string]string{"SecretId": ref})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	credentials, err := p.credentials.Current(ctx)
+	if err != nil {
+		return "", err
+	}
+	awsauth.Sign(req, awsauth.PayloadHash(body), "secretsmanager", p.region, credentials, time.Now().UTC())
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var failure struct {
+			Type string `json:"__type"`
+		}
+		data, _ := io.ReadAll(resp.Body)
+		_ = json.Unmarshal(data, &failure)
+		return "", fmt.Errorf("secrets manager returned status %d %s", resp.StatusCode, failure.Type)
+	}
+
+	var secret struct {
+		SecretString *string `json:"SecretString"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return "", err
+	}
+	if secret.SecretString == nil {
+		return "", errors.New("secret has no SecretString (binary secrets are not supported)")
+	}
+	return *secret.SecretString, nil
+}
@@ -0,0 +1,195 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"sub-balance-demo/internal/awsauth"
+)
+
+// EncryptedPrefix marks an environment value encrypted with the master key:
+//
+//	REDIS_PASSWORD=enc:<base64 nonce+ciphertext>
+//
+// Values are AES-256-GCM with the variable name as additional data, so an
+// encrypted value cannot be moved to another variable. Encrypt them with
+// "sub-balance-demo encrypt-config NAME".
+const EncryptedPrefix = "enc:"
+
+// masterKeySize is the AES-256 key size
+const masterKeySize = 32
+
+// DecryptEnv replaces every enc: environment value with its plaintext. The
+// master key is only loaded when a value is encrypted; an error stops startup
+// like a secret that cannot be fetched.
+func DecryptEnv(ctx context.Context) error {
+	encrypted := make(map[string]string)
+	for _, entry := range os.Environ() {
+		name, value, _ := strings.Cut(entry, "=")
+		if strings.HasPrefix(value, EncryptedPrefix) {
+			encrypted[name] = value
+		}
+	}
+	if len(encrypted) == 0 {
+		return nil
+	}
+
+	key, err := MasterKey(ctx)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(encrypted))
+	for name, value := range encrypted {
+		plaintext, err := Decrypt(key, name, value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		os.Setenv(name, plaintext)
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	log.Printf("Decrypted %d configuration values: %s", len(names), strings.Join(names, ", "))
+	return nil
+}
+
+// Encrypt encrypts the value of variable name with key
+func Encrypt(key []byte, name string, value string) (string, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(value), []byte(name))
+	return EncryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt returns the plaintext of an enc: value of variable name
+func Decrypt(key []byte, name string, value string) (string, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, EncryptedPrefix))
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", errors.New("encrypted value is not valid base64 nonce+ciphertext")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(name))
+	if err != nil {
+		return "", errors.New("failed to decrypt value: wrong master key, or encrypted for another variable")
+	}
+	return string(plaintext), nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != masterKeySize {
+		return nil, fmt.Errorf("master key must be %d bytes, got %d", masterKeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// MasterKeyConfigured reports whether MasterKey has a key source to load from
+func MasterKeyConfigured() bool {
+	return os.Getenv("CONFIG_MASTER_KEY_FILE") != "" || os.Getenv("CONFIG_MASTER_KEY_KMS") != ""
+}
+
+// MasterKey loads the key of enc: values: from CONFIG_MASTER_KEY_FILE (the
+// key base64-encoded, e.g. "openssl rand -base64 32"), or by decrypting
+// CONFIG_MASTER_KEY_KMS (the base64 CiphertextBlob of an AES_256 data key
+// from "aws kms generate-data-key") with AWS KMS
+func MasterKey(ctx context.Context) ([]byte, error) {
+	file, kmsBlob := os.Getenv("CONFIG_MASTER_KEY_FILE"), os.Getenv("CONFIG_MASTER_KEY_KMS")
+	switch {
+	case file != "" && kmsBlob != "":
+		return nil, errors.New("set either CONFIG_MASTER_KEY_FILE or CONFIG_MASTER_KEY_KMS, not both")
+	case file != "":
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read master key: %w", err)
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil {
+			return nil, errors.New("master key file is not base64")
+		}
+		return key, nil
+	case kmsBlob != "":
+		ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+		defer cancel()
+		return kmsDecrypt(ctx, kmsBlob)
+	default:
+		return nil, errors.New("enc: values need CONFIG_MASTER_KEY_FILE or CONFIG_MASTER_KEY_KMS")
+	}
+}
+
+// kmsDecrypt calls KMS Decrypt on a base64 CiphertextBlob, signed by awsauth
+// like Secrets Manager calls
+func kmsDecrypt(ctx context.Context, blob string) ([]byte, error) {
+	region := awsauth.Region()
+	if region == "" {
+		return nil, errors.New("AWS_REGION is required for CONFIG_MASTER_KEY_KMS")
+	}
+	client := &http.Client{Timeout: fetchTimeout}
+	credentials, err := awsauth.FromEnv(region, os.Getenv("AWS_ENDPOINT_URL_STS"), client)
+	if err != nil {
+		return nil, fmt.Errorf("%w for CONFIG_MASTER_KEY_KMS", err)
+	}
+	endpoint := os.Getenv("AWS_ENDPOINT_URL_KMS")
+	if endpoint == "" {
+		endpoint = "https://kms." + region + ".amazonaws.com"
+	}
+
+	body, _ := json.Marshal(map[string]string{"CiphertextBlob": strings.TrimSpace(blob)})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService.Decrypt")
+	current, err := credentials.Current(ctx)
+	if err != nil {
+		return nil, err
+	}
+	awsauth.Sign(req, awsauth.PayloadHash(body), "kms", region, current, time.Now().UTC())
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var failure struct {
+			Type string `json:"__type"`
+		}
+		data, _ := io.ReadAll(resp.Body)
+		_ = json.Unmarshal(data, &failure)
+		return nil, fmt.Errorf("KMS Decrypt returned status %d %s", resp.StatusCode, failure.Type)
+	}
+	var result struct {
+		Plaintext []byte `json:"Plaintext"` // base64 di JSON
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return result.Plaintext, nil
+}
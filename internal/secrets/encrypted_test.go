@@ -0,0 +1,200 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testKey(fill byte) []byte {
+	return bytes.Repeat([]byte{fill}, masterKeySize)
+}
+
+func TestEncryptDecryptRoundTrip(t *testing.T) {
+	key := testKey(1)
+	for _, value := range []string{"s3cret", "", "postgres://user:p@ss=w0rd@db:5432/app?sslmode=require", "ünïcode ✓"} {
+		encrypted, err := Encrypt(key, "DATABASE_URL", value)
+		if err != nil {
+			t.Fatalf("Encrypt(%q): %v", value, err)
+		}
+		if !strings.HasPrefix(encrypted, EncryptedPrefix) {
+			t.Fatalf("Encrypt(%q) = %q, want %s prefix", value, encrypted, EncryptedPrefix)
+		}
+		if value != "" && strings.Contains(encrypted, value) {
+			t.Fatalf("Encrypt(%q) leaks the plaintext: %q", value, encrypted)
+		}
+		decrypted, err := Decrypt(key, "DATABASE_URL", encrypted)
+		if err != nil {
+			t.Fatalf("Decrypt(%q): %v", value, err)
+		}
+		if decrypted != value {
+			t.Fatalf("Decrypt = %q, want %q", decrypted, value)
+		}
+	}
+
+	// Nonce acak: nilai yang sama tidak menghasilkan ciphertext yang sama
+	first, _ := Encrypt(key, "REDIS_PASSWORD", "same")
+	second, _ := Encrypt(key, "REDIS_PASSWORD", "same")
+	if first == second {
+		t.Fatal("Encrypt reused a nonce")
+	}
+}
+
+func TestDecryptRejects(t *testing.T) {
+	key := testKey(1)
+	encrypted, err := Encrypt(key, "REDIS_PASSWORD", "s3cret")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	sealed, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(encrypted, EncryptedPrefix))
+	sealed[len(sealed)-1] ^= 0xff
+	tampered := EncryptedPrefix + base64.StdEncoding.EncodeToString(sealed)
+
+	tests := []struct {
+		name  string
+		key   []byte
+		env   string
+		value string
+		want  string
+	}{
+		{"wrong key", testKey(2), "REDIS_PASSWORD", encrypted, "wrong master key"},
+		{"moved to another variable", key, "DATABASE_PASSWORD", encrypted, "another variable"},
+		{"tampered ciphertext", key, "REDIS_PASSWORD", tampered, "failed to decrypt"},
+		{"not base64", key, "REDIS_PASSWORD", EncryptedPrefix + "not base64!", "not valid base64"},
+		{"shorter than the nonce", key, "REDIS_PASSWORD", EncryptedPrefix + base64.StdEncoding.EncodeToString([]byte("short")), "not valid base64"},
+		{"empty", key, "REDIS_PASSWORD", EncryptedPrefix, "not valid base64"},
+		{"short key", key[:16], "REDIS_PASSWORD", encrypted, "must be 32 bytes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plaintext, err := Decrypt(tt.key, tt.env, tt.value)
+			if err == nil {
+				t.Fatalf("Decrypt = %q, want error", plaintext)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Decrypt error = %q, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func writeKeyFile(t *testing.T, key []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "master.key")
+	if err := os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDecryptEnv(t *testing.T) {
+	key := testKey(3)
+	encrypted, err := Encrypt(key, "TEST_SECRETS_PASSWORD", "s3cret")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	t.Setenv("CONFIG_MASTER_KEY_FILE", writeKeyFile(t, key))
+	t.Setenv("CONFIG_MASTER_KEY_KMS", "")
+	t.Setenv("TEST_SECRETS_PASSWORD", encrypted)
+	t.Setenv("TEST_SECRETS_PLAIN", "plain")
+
+	if err := DecryptEnv(context.Background()); err != nil {
+		t.Fatalf("DecryptEnv: %v", err)
+	}
+	if got := os.Getenv("TEST_SECRETS_PASSWORD"); got != "s3cret" {
+		t.Fatalf("TEST_SECRETS_PASSWORD = %q, want s3cret", got)
+	}
+	if got := os.Getenv("TEST_SECRETS_PLAIN"); got != "plain" {
+		t.Fatalf("TEST_SECRETS_PLAIN = %q, want plain", got)
+	}
+}
+
+func TestDecryptEnvFailsOnMovedValue(t *testing.T) {
+	key := testKey(3)
+	encrypted, err := Encrypt(key, "TEST_SECRETS_PASSWORD", "s3cret")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	t.Setenv("CONFIG_MASTER_KEY_FILE", writeKeyFile(t, key))
+	t.Setenv("CONFIG_MASTER_KEY_KMS", "")
+	t.Setenv("TEST_SECRETS_OTHER", encrypted)
+
+	err = DecryptEnv(context.Background())
+	if err == nil || !strings.HasPrefix(err.Error(), "TEST_SECRETS_OTHER: ") {
+		t.Fatalf("DecryptEnv error = %v, want one naming TEST_SECRETS_OTHER", err)
+	}
+	if got := os.Getenv("TEST_SECRETS_OTHER"); got != encrypted {
+		t.Fatalf("TEST_SECRETS_OTHER = %q, want it left encrypted", got)
+	}
+}
+
+func TestMasterKeySources(t *testing.T) {
+	t.Run("none", func(t *testing.T) {
+		t.Setenv("CONFIG_MASTER_KEY_FILE", "")
+		t.Setenv("CONFIG_MASTER_KEY_KMS", "")
+		if _, err := MasterKey(context.Background()); err == nil {
+			t.Fatal("MasterKey without a source succeeded")
+		}
+	})
+	t.Run("both", func(t *testing.T) {
+		t.Setenv("CONFIG_MASTER_KEY_FILE", writeKeyFile(t, testKey(4)))
+		t.Setenv("CONFIG_MASTER_KEY_KMS", "blob")
+		if _, err := MasterKey(context.Background()); err == nil || !strings.Contains(err.Error(), "not both") {
+			t.Fatalf("MasterKey error = %v, want not both", err)
+		}
+	})
+	t.Run("file not base64", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "master.key")
+		os.WriteFile(path, []byte("not base64!"), 0o600)
+		t.Setenv("CONFIG_MASTER_KEY_FILE", path)
+		t.Setenv("CONFIG_MASTER_KEY_KMS", "")
+		if _, err := MasterKey(context.Background()); err == nil {
+			t.Fatal("MasterKey with an invalid key file succeeded")
+		}
+	})
+}
+
+func TestMasterKeyFromKMS(t *testing.T) {
+	key := testKey(5)
+	var target, authorization, blob string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target = r.Header.Get("X-Amz-Target")
+		authorization = r.Header.Get("Authorization")
+		var body struct {
+			CiphertextBlob string `json:"CiphertextBlob"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		blob = body.CiphertextBlob
+		json.NewEncoder(w).Encode(map[string][]byte{"Plaintext": key})
+	}))
+	defer server.Close()
+
+	t.Setenv("CONFIG_MASTER_KEY_FILE", "")
+	t.Setenv("CONFIG_MASTER_KEY_KMS", " Y2lwaGVydGV4dA== \n")
+	t.Setenv("AWS_REGION", "eu-west-1")
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+	t.Setenv("AWS_ENDPOINT_URL_KMS", server.URL)
+
+	got, err := MasterKey(context.Background())
+	if err != nil {
+		t.Fatalf("MasterKey: %v", err)
+	}
+	if !bytes.Equal(got, key) {
+		t.Fatalf("MasterKey = %x, want %x", got, key)
+	}
+	if target != "TrentService.Decrypt" || blob != "Y2lwaGVydGV4dA==" {
+		t.Fatalf("KMS request target=%q blob=%q", target, blob)
+	}
+	if !strings.Contains(authorization, "Credential=AKIDEXAMPLE/") || !strings.Contains(authorization, "/eu-west-1/kms/aws4_request") {
+		t.Fatalf("KMS request not signed for kms in eu-west-1: %q", authorization)
+	}
+}
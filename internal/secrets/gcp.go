@@ -0,0 +1,119 @@
+package secrets
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// gcpMetadataTokenURL returns an access token of the workload's service
+// account (GCE, GKE workload identity, Cloud Run)
+const gcpMetadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// gcpProvider reads Secret Manager versions with the metadata server's access
+// token, or GCP_ACCESS_TOKEN (e.g. from gcloud auth print-access-token) outside
+// Google Cloud
+type gcpProvider struct {
+	endpoint string
+	tokenURL string
+	client   *http.Client
+
+	mutex       sync.Mutex
+	token       string
+	tokenExpiry time.Time // zero = token statis
+}
+
+func newGCPProvider() (Provider, error) {
+	return &gcpProvider{
+		endpoint: "https://secretmanager.googleapis.com",
+		tokenURL: gcpMetadataTokenURL,
+		client:   &http.Client{Timeout: fetchTimeout},
+		token:    os.Getenv("GCP_ACCESS_TOKEN"),
+	}, nil
+}
+
+// Fetch reads "projects/<project>/secrets/<secret>[/versions/<version>]",
+// the latest version when none is given
+func (p *gcpProvider) Fetch(ctx context.Context, ref string) (string, error) {
+	parts := strings.Split(ref, "/")
+	if len(parts) == 4 {
+		ref += "/versions/latest"
+	} else if len(parts) != 6 {
+		return "", fmt.Errorf("invalid gcpsm reference %q, expected projects/<project>/secrets/<secret>[/versions/<version>]", ref)
+	}
+
+	token, err := p.currentToken(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.endpoint+"/v1/"+ref+":access", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("secret manager returned status %d", resp.StatusCode)
+	}
+
+	var version struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&version); err != nil {
+		return "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(version.Payload.Data)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode secret payload: %w", err)
+	}
+	return string(data), nil
+}
+
+// currentToken returns GCP_ACCESS_TOKEN, or a metadata server token renewed a
+// minute before it expires
+func (p *gcpProvider) currentToken(ctx context.Context) (string, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.token != "" && (p.tokenExpiry.IsZero() || time.Now().Add(time.Minute).Before(p.tokenExpiry)) {
+		return p.token, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.tokenURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get access token from metadata server: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("metadata server returned status %d", resp.StatusCode)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	p.token = token.AccessToken
+	p.tokenExpiry = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	return p.token, nil
+}
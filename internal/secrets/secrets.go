@@ -0,0 +1,204 @@
+// Package secrets replaces environment values that reference a secret in
+// Vault, AWS Secrets Manager or GCP Secret Manager with the secret itself, so
+// DATABASE_URL, Redis credentials and keys do not have to live in .env files.
+// A reference is the whole value of the variable:
+//
+//	DATABASE_URL=vault://secret/subbalance#database_url
+//	REDIS_PASSWORD=awssm://prod/subbalance#redis_password
+//	DATABASE_REPLICA_URL=gcpsm://projects/my-project/secrets/replica-url/versions/latest
+//
+// After "#" comes the field to read from a secret holding a JSON object (a
+// Vault KV entry always is one); without it the whole secret is the value.
+// Secret values are never logged. Values can also be stored encrypted in
+// place, see EncryptedPrefix.
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fetchTimeout bounds a single secret fetch
+const fetchTimeout = 10 * time.Second
+
+// Provider fetches a secret by its reference, without scheme and key. Vault
+// returns the KV entry's data as a JSON object.
+type Provider interface {
+	Fetch(ctx context.Context, ref string) (string, error)
+}
+
+// newProviders maps a reference scheme to the constructor of its provider.
+// Providers are only built, and their settings only required, when a
+// variable references them.
+var newProviders = map[string]func() (Provider, error){
+	"vault": newVaultProvider,
+	"awssm": newAWSProvider,
+	"gcpsm": newGCPProvider,
+}
+
+type reference struct {
+	scheme string
+	ref    string
+	key    string
+}
+
+// parseReference splits "scheme://ref#key"; ok is false for plain values
+func parseReference(value string) (reference, bool) {
+	scheme, rest, found := strings.Cut(value, "://")
+	if !found || newProviders[scheme] == nil {
+		return reference{}, false
+	}
+	ref, key, _ := strings.Cut(rest, "#")
+	return reference{scheme: scheme, ref: ref, key: key}, true
+}
+
+// Resolver holds the environment variables that reference a secret and their
+// current values. A nil *Resolver has no secrets.
+type Resolver struct {
+	providers map[string]Provider
+	refs      map[string]reference // by variable name
+
+	mutex  sync.RWMutex
+	values map[string]string
+}
+
+// ResolveEnv fetches every secret referenced by an environment variable and
+// replaces the variable's value with it. It returns nil when no variable
+// references a secret, and an error if any secret cannot be fetched: starting
+// with a reference in place of a password is never what the operator wants.
+func ResolveEnv(ctx context.Context) (*Resolver, error) {
+	r := &Resolver{
+		providers: make(map[string]Provider),
+		refs:      make(map[string]reference),
+		values:    make(map[string]string),
+	}
+	for _, entry := range os.Environ() {
+		name, value, _ := strings.Cut(entry, "=")
+		if ref, ok := parseReference(value); ok {
+			r.refs[name] = ref
+		}
+	}
+	if len(r.refs) == 0 {
+		return nil, nil
+	}
+
+	for name, ref := range r.refs {
+		if _, ok := r.providers[ref.scheme]; ok {
+			continue
+		}
+		provider, err := newProviders[ref.scheme]()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		r.providers[ref.scheme] = provider
+	}
+
+	for _, name := range r.Names() {
+		value, err := r.fetch(ctx, r.refs[name])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		r.values[name] = value
+		os.Setenv(name, value)
+	}
+	log.Printf("Loaded %d secrets from secret managers: %s", len(r.refs), strings.Join(r.Names(), ", "))
+	return r, nil
+}
+
+func (r *Resolver) fetch(ctx context.Context, ref reference) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+
+	value, err := r.providers[ref.scheme].Fetch(ctx, ref.ref)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch %s://%s: %w", ref.scheme, ref.ref, err)
+	}
+	if ref.key == "" {
+		return value, nil
+	}
+	return jsonField(value, ref.key)
+}
+
+// jsonField returns a string field of a JSON object secret
+func jsonField(secret string, key string) (string, error) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(secret), &fields); err != nil {
+		return "", fmt.Errorf("secret is not a JSON object, cannot read key %q", key)
+	}
+	value, ok := fields[key].(string)
+	if !ok {
+		return "", fmt.Errorf("secret has no string key %q", key)
+	}
+	return value, nil
+}
+
+// Names lists the variables backed by a secret
+func (r *Resolver) Names() []string {
+	if r == nil {
+		return nil
+	}
+	names := make([]string, 0, len(r.refs))
+	for name := range r.refs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Lookup returns the current value of a secret-backed variable; ok is false
+// when name does not reference a secret
+func (r *Resolver) Lookup(name string) (value string, ok bool) {
+	if r == nil {
+		return "", false
+	}
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	value, ok = r.values[name]
+	return value, ok
+}
+
+// Watch re-fetches the secrets every interval (0 = never) until ctx is done.
+// A rotated secret replaces the variable's value, and Lookup returns it; a
+// failed fetch keeps the previous value.
+func (r *Resolver) Watch(ctx context.Context, interval time.Duration) {
+	if r == nil || interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.refresh(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (r *Resolver) refresh(ctx context.Context) {
+	for _, name := range r.Names() {
+		value, err := r.fetch(ctx, r.refs[name])
+		if err != nil {
+			log.Printf("Failed to refresh secret %s, keeping current value: %v", name, err)
+			continue
+		}
+
+		r.mutex.Lock()
+		changed := r.values[name] != value
+		r.values[name] = value
+		r.mutex.Unlock()
+		if changed {
+			os.Setenv(name, value)
+			log.Printf("Secret %s rotated", name)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// kubernetesTokenFile is the service account token used for Vault's
+// Kubernetes auth method
+const kubernetesTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// vaultProvider reads KV v2 entries. It authenticates with VAULT_TOKEN (or
+// the file in VAULT_TOKEN_FILE), or with the pod's service account through the
+// Kubernetes auth method when VAULT_K8S_ROLE is set.
+type vaultProvider struct {
+	addr      string
+	namespace string
+	k8sRole   string
+	k8sMount  string
+	client    *http.Client
+
+	mutex       sync.Mutex
+	token       string
+	tokenExpiry time.Time // zero = tidak kedaluwarsa
+}
+
+func newVaultProvider() (Provider, error) {
+	p := &vaultProvider{
+		addr:      strings.TrimSuffix(os.Getenv("VAULT_ADDR"), "/"),
+		namespace: os.Getenv("VAULT_NAMESPACE"),
+		k8sRole:   os.Getenv("VAULT_K8S_ROLE"),
+		k8sMount:  os.Getenv("VAULT_K8S_MOUNT"),
+		client:    &http.Client{Timeout: fetchTimeout},
+		token:     os.Getenv("VAULT_TOKEN"),
+	}
+	if p.addr == "" {
+		return nil, errors.New("VAULT_ADDR is required for vault:// secrets")
+	}
+	if p.k8sMount == "" {
+		p.k8sMount = "kubernetes"
+	}
+	if file := os.Getenv("VAULT_TOKEN_FILE"); file != "" && p.token == "" {
+		token, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read VAULT_TOKEN_FILE: %w", err)
+		}
+		p.token = strings.TrimSpace(string(token))
+	}
+	if p.token == "" && p.k8sRole == "" {
+		return nil, errors.New("VAULT_TOKEN, VAULT_TOKEN_FILE or VAULT_K8S_ROLE is required for vault:// secrets")
+	}
+	return p, nil
+}
+
+// Fetch reads "<mount>/<path>" and returns the entry's data as JSON
+func (p *vaultProvider) Fetch(ctx context.Context, ref string) (string, error) {
+	mount, path, found := strings.Cut(ref, "/")
+	if !found || path == "" {
+		return "", fmt.Errorf("invalid vault reference %q, expected <mount>/<path>", ref)
+	}
+
+	token, err := p.currentToken(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	var response struct {
+		Data struct {
+			Data json.RawMessage `json:"data"`
+		} `json:"data"`
+	}
+	err = p.do(ctx, http.MethodGet, "/v1/"+mount+"/data/"+path, token, nil, &response)
+	if err != nil {
+		return "", err
+	}
+	if len(response.Data.Data) == 0 || string(response.Data.Data) == "null" {
+		return "", errors.New("vault entry has no data")
+	}
+	return string(response.Data.Data), nil
+}
+
+// currentToken returns the static token, or logs in with the service account
+// once the previous login's lease is mostly used up
+func (p *vaultProvider) currentToken(ctx context.Context) (string, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.token != "" && (p.tokenExpiry.IsZero() || time.Now().Before(p.tokenExpiry)) {
+		return p.token, nil
+	}
+
+	jwt, err := os.ReadFile(kubernetesTokenFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read service account token: %w", err)
+	}
+	body, _ := json.Marshal(map[string]string{"role": p.k8sRole, "jwt": strings.TrimSpace(string(jwt))})
+
+	var response struct {
+		Auth struct {
+			ClientToken   string `json:"client_token"`
+			LeaseDuration int    `json:"lease_duration"`
+		} `json:"auth"`
+	}
+	err = p.do(ctx, http.MethodPost, "/v1/auth/"+p.k8sMount+"/login", "", body, &response)
+	if err != nil {
+		return "", fmt.Errorf("vault kubernetes login failed: %w", err)
+	}
+
+	p.token = response.Auth.ClientToken
+	if response.Auth.LeaseDuration > 0 {
+		// Login ulang setelah 2/3 lease, sebelum token kedaluwarsa
+		p.tokenExpiry = time.Now().Add(time.Duration(response.Auth.LeaseDuration) * time.Second * 2 / 3)
+	}
+	return p.token, nil
+}
+
+func (p *vaultProvider) do(ctx context.Context, method string, path string, token string, body []byte, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, p.addr+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if p.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", p.namespace)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("vault returned status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"log"
+	"sort"
+
+	"sub-balance-demo/internal/config"
+
+	"github.com/shopspring/decimal"
+)
+
+// AccountOther is the account label of every account without its own series
+const AccountOther = "other"
+
+// AccountTransactionCount are the per-account transaction counters exported
+// when METRICS_ACCOUNT_LABEL=top
+type AccountTransactionCount struct {
+	AccountID      string          `json:"account_id"` // or AccountOther
+	Accepted       int64           `json:"accepted"`
+	Rejected       int64           `json:"rejected"`
+	AcceptedAmount decimal.Decimal `json:"accepted_amount"`
+}
+
+// accountMetrics gives at most topN accounts their own series and counts the
+// rest under AccountOther, so the number of series stays bounded however many
+// accounts transact. Slots are handed out at snapshot time to the busiest
+// accounts seen so far and kept until restart: a labelled account only counts
+// from its promotion on, so no series (other included) ever goes down.
+//
+// Candidates for a slot are tracked with the Space-Saving algorithm in a map
+// of at most candidateLimit entries, so memory is bounded too. Not safe for
+// concurrent use; transactionMetrics holds its mutex.
+type accountMetrics struct {
+	topN           int
+	candidateLimit int
+	labelled       map[string]*AccountTransactionCount
+	other          AccountTransactionCount
+	candidates     map[string]int64
+}
+
+// accountMetricsTopN is the number of per-account series METRICS_ACCOUNT_LABEL
+// allows; 0 disables per-account counters
+func accountMetricsTopN(cfg *config.Config) int {
+	switch cfg.MetricsAccountLabel {
+	case "off", "":
+		return 0
+	case "top":
+		return cfg.MetricsAccountTopN
+	}
+	log.Printf("Invalid metrics account label mode %q, using off", cfg.MetricsAccountLabel)
+	return 0
+}
+
+func newAccountMetrics(topN int) *accountMetrics {
+	return &accountMetrics{
+		topN:           topN,
+		candidateLimit: max(10*topN, 100),
+		labelled:       make(map[string]*AccountTransactionCount, topN),
+		other:          AccountTransactionCount{AccountID: AccountOther},
+		candidates:     make(map[string]int64),
+	}
+}
+
+func (m *accountMetrics) record(accountID string, accepted bool, amount decimal.Decimal) {
+	counts, ok := m.labelled[accountID]
+	if !ok {
+		counts = &m.other
+		if len(m.labelled) < m.topN {
+			m.trackCandidate(accountID)
+		}
+	}
+
+	if accepted {
+		counts.Accepted++
+		counts.AcceptedAmount = counts.AcceptedAmount.Add(amount)
+	} else {
+		counts.Rejected++
+	}
+}
+
+// trackCandidate counts accountID; when the map is full the least counted
+// candidate is replaced and its count inherited (Space-Saving), which keeps
+// heavy accounts in the map even among many light ones
+func (m *accountMetrics) trackCandidate(accountID string) {
+	if _, ok := m.candidates[accountID]; ok || len(m.candidates) < m.candidateLimit {
+		m.candidates[accountID]++
+		return
+	}
+
+	minID, minCount := "", int64(-1)
+	for id, count := range m.candidates {
+		if minCount < 0 || count < minCount {
+			minID, minCount = id, count
+		}
+	}
+	delete(m.candidates, minID)
+	m.candidates[accountID] = minCount + 1
+}
+
+// promote fills free slots with the busiest candidates
+func (m *accountMetrics) promote() {
+	free := m.topN - len(m.labelled)
+	if free <= 0 || len(m.candidates) == 0 {
+		return
+	}
+
+	ids := make([]string, 0, len(m.candidates))
+	for id := range m.candidates {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return m.candidates[ids[i]] > m.candidates[ids[j]] })
+	if len(ids) > free {
+		ids = ids[:free]
+	}
+	for _, id := range ids {
+		m.labelled[id] = &AccountTransactionCount{AccountID: id}
+		delete(m.candidates, id)
+	}
+	if len(m.labelled) >= m.topN {
+		m.candidates = nil // semua slot terisi, tidak perlu melacak kandidat lagi
+	}
+}
+
+func (m *accountMetrics) snapshot() []AccountTransactionCount {
+	m.promote()
+	counts := make([]AccountTransactionCount, 0, len(m.labelled)+1)
+	for _, c := range m.labelled {
+		counts = append(counts, *c)
+	}
+	return append(counts, m.other)
+}
@@ -0,0 +1,40 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"sub-balance-demo/internal/pagination"
+	"sub-balance-demo/internal/repository"
+
+	"github.com/google/uuid"
+)
+
+// Outcome admin audit entry
+const (
+	AdminAuditSuccess = "success"
+	AdminAuditFailure = "failure"
+)
+
+// AdminAuditLog persists every admin and test-mode call in the append-only
+// admin_audit_log table
+type AdminAuditLog struct {
+	repo repository.AdminAuditRepository
+}
+
+func NewAdminAuditLog(repo repository.AdminAuditRepository) *AdminAuditLog {
+	return &AdminAuditLog{repo: repo}
+}
+
+func (a *AdminAuditLog) Record(ctx context.Context, entry repository.AdminAuditEntry) error {
+	entry.ID = uuid.New().String()
+	err := a.repo.Create(ctx, &entry)
+	if err != nil {
+		return fmt.Errorf("failed to write admin audit log: %w", err)
+	}
+	return nil
+}
+
+func (a *AdminAuditLog) List(ctx context.Context, filter repository.AdminAuditFilter) (pagination.Page[repository.AdminAuditEntry], error) {
+	return a.repo.List(ctx, filter)
+}
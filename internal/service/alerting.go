@@ -0,0 +1,160 @@
+package service
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Event alert threshold yang dikirim ke webhook
+const (
+	AlertRepairsExceeded            = "consistency.repairs_exceeded"
+	AlertSettlementFailuresExceeded = "settlement.failures_exceeded"
+	AlertBreakerOpenTooLong         = "circuit_breaker.open_too_long"
+	AlertBreakerOpenResolved        = "circuit_breaker.open_resolved"
+)
+
+// AlertThresholds configures ThresholdAlerter; a zero threshold disables
+// that alert
+type AlertThresholds struct {
+	RepairsPerRun            int
+	SettlementFailuresPerRun int
+	BreakerOpenDuration      time.Duration
+	// Cooldown is the minimum time between two alerts of the same event
+	Cooldown time.Duration
+}
+
+// ThresholdAlerter sends an alert to every configured webhook when
+// consistency repairs per run, settlement failures per run or the time the
+// circuit breaker stays open exceed their thresholds. A nil *ThresholdAlerter
+// never alerts.
+type ThresholdAlerter struct {
+	notifiers  []*WebhookNotifier
+	thresholds AlertThresholds
+	lastSent   map[string]time.Time
+
+	// Episode breaker saat ini: dimulai ketika breaker meninggalkan CLOSED
+	breakerEpisode   int
+	breakerOpenSince time.Time
+	breakerAlerted   bool
+	breakerLastAt    time.Time
+
+	mutex sync.Mutex
+}
+
+func NewThresholdAlerter(thresholds AlertThresholds, notifiers ...*WebhookNotifier) *ThresholdAlerter {
+	return &ThresholdAlerter{
+		notifiers:  notifiers,
+		thresholds: thresholds,
+		lastSent:   make(map[string]time.Time),
+	}
+}
+
+// CheckRepairs alerts when a consistency run repaired more accounts than allowed
+func (a *ThresholdAlerter) CheckRepairs(ctx context.Context, repaired int) {
+	if a == nil || a.thresholds.RepairsPerRun <= 0 || repaired <= a.thresholds.RepairsPerRun {
+		return
+	}
+	a.send(ctx, AlertRepairsExceeded, map[string]interface{}{
+		"repaired_accounts": repaired,
+		"threshold":         a.thresholds.RepairsPerRun,
+	})
+}
+
+// CheckSettlementFailures alerts when a settlement run failed more accounts
+// than allowed
+func (a *ThresholdAlerter) CheckSettlementFailures(ctx context.Context, settlementID string, failed int64, settled int64) {
+	if a == nil || a.thresholds.SettlementFailuresPerRun <= 0 || failed <= int64(a.thresholds.SettlementFailuresPerRun) {
+		return
+	}
+	a.send(ctx, AlertSettlementFailuresExceeded, map[string]interface{}{
+		"settlement_id":    settlementID,
+		"accounts_failed":  failed,
+		"accounts_settled": settled,
+		"threshold":        a.thresholds.SettlementFailuresPerRun,
+	})
+}
+
+// WatchCircuitBreaker alerts when the breaker has not been CLOSED for longer
+// than BreakerOpenDuration, and again once it closes after such an alert
+func (a *ThresholdAlerter) WatchCircuitBreaker(cb *CircuitBreaker) {
+	if a == nil || a.thresholds.BreakerOpenDuration <= 0 {
+		return
+	}
+	cb.OnStateChange(a.onBreakerStateChange)
+}
+
+func (a *ThresholdAlerter) onBreakerStateChange(transition CircuitBreakerTransition) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	// Hook berjalan di goroutine sendiri; transition yang datang terlambat diabaikan
+	if transition.At.Before(a.breakerLastAt) {
+		return
+	}
+	a.breakerLastAt = transition.At
+
+	switch {
+	case transition.From == StateClosed:
+		a.breakerEpisode++
+		a.breakerOpenSince = transition.At
+		a.breakerAlerted = false
+		episode := a.breakerEpisode
+		time.AfterFunc(a.thresholds.BreakerOpenDuration, func() {
+			a.breakerOpenTooLong(episode)
+		})
+	case transition.To == StateClosed:
+		openFor := transition.At.Sub(a.breakerOpenSince)
+		alerted := a.breakerAlerted
+		a.breakerEpisode++
+		a.breakerAlerted = false
+		if alerted {
+			go a.notify(context.Background(), AlertBreakerOpenResolved, map[string]interface{}{
+				"open_for_seconds": openFor.Seconds(),
+			})
+		}
+	}
+}
+
+// breakerOpenTooLong fires if the breaker is still in the episode that
+// started the timer
+func (a *ThresholdAlerter) breakerOpenTooLong(episode int) {
+	a.mutex.Lock()
+	if episode != a.breakerEpisode {
+		a.mutex.Unlock()
+		return
+	}
+	a.breakerAlerted = true
+	openFor := time.Since(a.breakerOpenSince)
+	a.mutex.Unlock()
+
+	a.send(context.Background(), AlertBreakerOpenTooLong, map[string]interface{}{
+		"open_for_seconds":  openFor.Seconds(),
+		"threshold_seconds": a.thresholds.BreakerOpenDuration.Seconds(),
+	})
+}
+
+// send notifies unless the same event was sent within the cooldown
+func (a *ThresholdAlerter) send(ctx context.Context, event string, data map[string]interface{}) {
+	a.mutex.Lock()
+	if last, ok := a.lastSent[event]; ok && time.Since(last) < a.thresholds.Cooldown {
+		a.mutex.Unlock()
+		log.Printf("Alert %s suppressed, last sent %s ago", event, time.Since(last).Round(time.Second))
+		return
+	}
+	a.lastSent[event] = time.Now()
+	a.mutex.Unlock()
+
+	a.notify(ctx, event, data)
+}
+
+func (a *ThresholdAlerter) notify(ctx context.Context, event string, data map[string]interface{}) {
+	log.Printf("⚠️ Alert %s: %v", event, data)
+	for _, notifier := range a.notifiers {
+		err := notifier.Notify(ctx, event, data)
+		if err != nil {
+			log.Printf("Failed to send %s alert: %v", event, err)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"sub-balance-demo/internal/pagination"
+	"sub-balance-demo/internal/repository"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Sources of account_balances changes recorded in the balance audit trail
+const (
+	BalanceSourceSettlement         = "settlement"
+	BalanceSourceRealtimeSettlement = "realtime_settlement"
+	BalanceSourceFallback           = "fallback"
+	BalanceSourceRepair             = "repair"
+	BalanceSourceAccountCreate      = "account_create"
+)
+
+// BalanceAuditTrail records every account_balances change in the append-only
+// balance_audit_trail table, inside the transaction that makes the change
+type BalanceAuditTrail struct {
+	repo repository.BalanceAuditRepository
+}
+
+func NewBalanceAuditTrail(repo repository.BalanceAuditRepository) *BalanceAuditTrail {
+	return &BalanceAuditTrail{repo: repo}
+}
+
+// Record stores the change from before to after using tx. before is the zero
+// value for a newly created account.
+func (a *BalanceAuditTrail) Record(ctx context.Context, tx *gorm.DB, source string, reference string, before repository.AccountBalance, after repository.AccountBalance) error {
+	err := a.repo.WithTx(tx).Create(ctx, &repository.BalanceAuditEntry{
+		ID:                  uuid.New().String(),
+		AccountID:           after.ID,
+		Source:              source,
+		Reference:           reference,
+		OldSettledBalance:   before.SettledBalance,
+		NewSettledBalance:   after.SettledBalance,
+		OldPendingDebit:     before.PendingDebit,
+		NewPendingDebit:     after.PendingDebit,
+		OldPendingCredit:    before.PendingCredit,
+		NewPendingCredit:    after.PendingCredit,
+		OldAvailableBalance: before.AvailableBalance,
+		NewAvailableBalance: after.AvailableBalance,
+		OldVersion:          before.Version,
+		NewVersion:          after.Version,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write balance audit trail: %w", err)
+	}
+	return nil
+}
+
+func (a *BalanceAuditTrail) List(ctx context.Context, filter repository.BalanceAuditFilter) (pagination.Page[repository.BalanceAuditEntry], error) {
+	return a.repo.List(ctx, filter)
+}
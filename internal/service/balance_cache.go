@@ -0,0 +1,120 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"sub-balance-demo/internal/logmask"
+	"sub-balance-demo/internal/repository"
+	"sub-balance-demo/internal/tenant"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// BalanceCacheStats counts read-through cache lookups
+type BalanceCacheStats struct {
+	TTLMs  int64 `json:"ttl_ms"`
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+	Errors int64 `json:"errors"`
+}
+
+// BalanceCache keeps GetBalance responses in Redis for a short TTL. Entries are
+// deleted by BalanceInvalidator whenever settlement, repair or the database
+// fallback changes the balance; the TTL bounds staleness when a delete is lost
+// or a read that started before the change repopulates the entry.
+// A nil *BalanceCache is a no-op.
+type BalanceCache struct {
+	client    *redis.Client
+	keyPrefix string
+	ttl       time.Duration
+	hits      atomic.Int64
+	misses    atomic.Int64
+	errors    atomic.Int64
+}
+
+func NewBalanceCache(client *redis.Client, keyPrefix string, ttl time.Duration) *BalanceCache {
+	return &BalanceCache{
+		client:    client,
+		keyPrefix: keyPrefix,
+		ttl:       ttl,
+	}
+}
+
+// balanceKey follows the pending counter layout, so tenants never share entries
+func (c *BalanceCache) balanceKey(ctx context.Context, accountID string) string {
+	if id := tenant.ID(ctx); id != tenant.Default {
+		return fmt.Sprintf("%s:tenant:%s:balance:%s", c.keyPrefix, id, accountID)
+	}
+	return fmt.Sprintf("%s:balance:%s", c.keyPrefix, accountID)
+}
+
+// Get returns the cached balance; any Redis error counts as a miss
+func (c *BalanceCache) Get(ctx context.Context, accountID string) (*repository.BalanceResponse, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	payload, err := c.client.Get(ctx, c.balanceKey(ctx, accountID)).Bytes()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			c.errors.Add(1)
+		}
+		c.misses.Add(1)
+		return nil, false
+	}
+
+	var balance repository.BalanceResponse
+	if err := json.Unmarshal(payload, &balance); err != nil {
+		log.Printf("Ignoring malformed cached balance for account %s: %v", logmask.Account(accountID), err)
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.hits.Add(1)
+	return &balance, true
+}
+
+// Set stores balance for the cache TTL; failures only cost a later miss
+func (c *BalanceCache) Set(ctx context.Context, balance *repository.BalanceResponse) {
+	if c == nil {
+		return
+	}
+
+	payload, err := json.Marshal(balance)
+	if err != nil {
+		log.Printf("Failed to encode balance for account %s: %v", logmask.Account(balance.AccountID), err)
+		return
+	}
+	if err := c.client.Set(ctx, c.balanceKey(ctx, balance.AccountID), payload, c.ttl).Err(); err != nil {
+		c.errors.Add(1)
+	}
+}
+
+// Invalidate deletes the cached balance of accountID
+func (c *BalanceCache) Invalidate(ctx context.Context, accountID string) {
+	if c == nil {
+		return
+	}
+
+	if err := c.client.Del(ctx, c.balanceKey(ctx, accountID)).Err(); err != nil {
+		c.errors.Add(1)
+		log.Printf("Failed to invalidate cached balance for account %s: %v", logmask.Account(accountID), err)
+	}
+}
+
+func (c *BalanceCache) Stats() BalanceCacheStats {
+	if c == nil {
+		return BalanceCacheStats{}
+	}
+	return BalanceCacheStats{
+		TTLMs:  c.ttl.Milliseconds(),
+		Hits:   c.hits.Load(),
+		Misses: c.misses.Load(),
+		Errors: c.errors.Load(),
+	}
+}
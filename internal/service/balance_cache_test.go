@@ -0,0 +1,176 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"sub-balance-demo/internal/repository"
+	"sub-balance-demo/internal/tenant"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/shopspring/decimal"
+)
+
+func newTestBalanceCache(t *testing.T, ttl time.Duration) (*BalanceCache, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return NewBalanceCache(client, "test", ttl), server
+}
+
+func testBalance(accountID string, settled string) *repository.BalanceResponse {
+	return &repository.BalanceResponse{
+		AccountID:        accountID,
+		SettledBalance:   decimal.RequireFromString(settled),
+		AvailableBalance: decimal.RequireFromString(settled),
+	}
+}
+
+func TestBalanceCacheGet(t *testing.T) {
+	tests := []struct {
+		name    string
+		prepare func(ctx context.Context, cache *BalanceCache, server *miniredis.Miniredis)
+		wantOK  bool
+		want    BalanceCacheStats
+	}{
+		{
+			name: "hit",
+			prepare: func(ctx context.Context, cache *BalanceCache, _ *miniredis.Miniredis) {
+				cache.Set(ctx, testBalance("ACC001", "100.5"))
+			},
+			wantOK: true,
+			want:   BalanceCacheStats{Hits: 1},
+		},
+		{
+			name:    "miss",
+			prepare: func(context.Context, *BalanceCache, *miniredis.Miniredis) {},
+			want:    BalanceCacheStats{Misses: 1},
+		},
+		{
+			name: "malformed payload",
+			prepare: func(_ context.Context, _ *BalanceCache, server *miniredis.Miniredis) {
+				server.Set("test:balance:ACC001", "{not json")
+			},
+			want: BalanceCacheStats{Misses: 1},
+		},
+		{
+			name: "expired",
+			prepare: func(ctx context.Context, cache *BalanceCache, server *miniredis.Miniredis) {
+				cache.Set(ctx, testBalance("ACC001", "100.5"))
+				server.FastForward(time.Minute + time.Second)
+			},
+			want: BalanceCacheStats{Misses: 1},
+		},
+		{
+			name:    "redis down",
+			prepare: func(_ context.Context, _ *BalanceCache, server *miniredis.Miniredis) { server.Close() },
+			want:    BalanceCacheStats{Misses: 1, Errors: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			cache, server := newTestBalanceCache(t, time.Minute)
+			tt.prepare(ctx, cache, server)
+
+			got, ok := cache.Get(ctx, "ACC001")
+			if ok != tt.wantOK {
+				t.Fatalf("Get ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && (got.AccountID != "ACC001" || !got.SettledBalance.Equal(decimal.RequireFromString("100.5"))) {
+				t.Fatalf("Get = %+v", got)
+			}
+			tt.want.TTLMs = time.Minute.Milliseconds()
+			if stats := cache.Stats(); stats != tt.want {
+				t.Fatalf("Stats = %+v, want %+v", stats, tt.want)
+			}
+		})
+	}
+}
+
+func TestBalanceCacheKeysPerTenant(t *testing.T) {
+	cache, server := newTestBalanceCache(t, time.Minute)
+	acme := tenant.WithID(context.Background(), "acme")
+	cache.Set(context.Background(), testBalance("ACC001", "10"))
+	cache.Set(acme, testBalance("ACC001", "20"))
+
+	// Tenant default tetap memakai key lama
+	for _, key := range []string{"test:balance:ACC001", "test:tenant:acme:balance:ACC001"} {
+		if !server.Exists(key) {
+			t.Fatalf("key %s missing, have %v", key, server.Keys())
+		}
+	}
+
+	cache.Invalidate(acme, "ACC001")
+	if _, ok := cache.Get(acme, "ACC001"); ok {
+		t.Fatalf("acme entry still cached after Invalidate")
+	}
+	got, ok := cache.Get(context.Background(), "ACC001")
+	if !ok || !got.SettledBalance.Equal(decimal.RequireFromString("10")) {
+		t.Fatalf("default entry = %+v, %v; want 10 still cached", got, ok)
+	}
+}
+
+func TestBalanceCacheWriteErrors(t *testing.T) {
+	ctx := context.Background()
+	cache, server := newTestBalanceCache(t, time.Minute)
+	server.Close()
+
+	cache.Set(ctx, testBalance("ACC001", "10"))
+	cache.Invalidate(ctx, "ACC001")
+	if stats := cache.Stats(); stats.Errors != 2 || stats.Hits != 0 || stats.Misses != 0 {
+		t.Fatalf("Stats = %+v, want 2 errors", stats)
+	}
+}
+
+func TestBalanceCacheNil(t *testing.T) {
+	ctx := context.Background()
+	var cache *BalanceCache
+
+	cache.Set(ctx, testBalance("ACC001", "10"))
+	cache.Invalidate(ctx, "ACC001")
+	if got, ok := cache.Get(ctx, "ACC001"); ok || got != nil {
+		t.Fatalf("Get on nil cache = %+v, %v", got, ok)
+	}
+	if stats := cache.Stats(); stats != (BalanceCacheStats{}) {
+		t.Fatalf("Stats on nil cache = %+v", stats)
+	}
+}
+
+func TestBalanceInvalidatorPublishDeletesCachedBalance(t *testing.T) {
+	cache, server := newTestBalanceCache(t, time.Minute)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	invalidator := NewBalanceInvalidator(client, "test", cache)
+
+	ctx, cancel := context.WithTimeout(tenant.WithID(context.Background(), "acme"), 5*time.Second)
+	defer cancel()
+	received := make(chan BalanceInvalidation, 1)
+	subscribed := make(chan error, 1)
+	go func() { subscribed <- invalidator.Subscribe(ctx, func(msg BalanceInvalidation) { received <- msg }) }()
+	// Tunggu subscriber terdaftar sebelum publish; pub/sub tidak menyimpan pesan
+	for len(server.PubSubChannels("")) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	cache.Set(ctx, testBalance("ACC001", "10"))
+	invalidator.Publish(ctx, "ACC001", "settlement")
+	if server.Exists("test:tenant:acme:balance:ACC001") {
+		t.Fatalf("cached balance not deleted by Publish")
+	}
+	select {
+	case msg := <-received:
+		if msg.TenantID != "acme" || msg.AccountID != "ACC001" || msg.Reason != "settlement" {
+			t.Fatalf("received %+v", msg)
+		}
+	case <-ctx.Done():
+		t.Fatalf("no invalidation received")
+	}
+	cancel()
+	if err := <-subscribed; err != nil {
+		t.Fatalf("Subscribe = %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"sub-balance-demo/internal/logmask"
+	"sub-balance-demo/internal/tenant"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// BalanceInvalidation tells other instances that an account's balance changed.
+// TenantID is empty in messages of instances older than multi-tenant account
+// IDs, which only had the default tenant.
+type BalanceInvalidation struct {
+	TenantID  string    `json:"tenant_id,omitempty"`
+	AccountID string    `json:"account_id"`
+	Reason    string    `json:"reason"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// BalanceInvalidator publishes balance changes on a Redis pub/sub channel so
+// instances caching balances (or streaming them) can refresh immediately.
+// A nil *BalanceInvalidator is a no-op.
+type BalanceInvalidator struct {
+	client  *redis.Client
+	channel string
+	cache   *BalanceCache // optional shared read-through cache
+}
+
+// NewBalanceInvalidator creates the invalidator; cache may be nil
+func NewBalanceInvalidator(client *redis.Client, keyPrefix string, cache *BalanceCache) *BalanceInvalidator {
+	return &BalanceInvalidator{
+		client:  client,
+		channel: fmt.Sprintf("%s:balance-invalidations", keyPrefix),
+		cache:   cache,
+	}
+}
+
+// Publish deletes the shared cache entry, then notifies subscribers. Both are
+// best effort: pub/sub has no delivery guarantee, so failures are only logged
+// and caches must still expire on their own
+func (b *BalanceInvalidator) Publish(ctx context.Context, accountID string, reason string) {
+	if b == nil {
+		return
+	}
+
+	b.cache.Invalidate(ctx, accountID)
+
+	payload, err := json.Marshal(BalanceInvalidation{
+		TenantID:  tenant.ID(ctx),
+		AccountID: accountID,
+		Reason:    reason,
+		Timestamp: time.Now(),
+	})
+	if err != nil {
+		log.Printf("Failed to encode balance invalidation for account %s: %v", logmask.Account(accountID), err)
+		return
+	}
+
+	err = b.client.Publish(ctx, b.channel, payload).Err()
+	if err != nil {
+		log.Printf("Failed to publish balance invalidation for account %s: %v", logmask.Account(accountID), err)
+	}
+}
+
+// Subscribe calls handler for every invalidation until ctx is cancelled
+func (b *BalanceInvalidator) Subscribe(ctx context.Context, handler func(BalanceInvalidation)) error {
+	if b == nil {
+		return nil
+	}
+
+	pubsub := b.client.Subscribe(ctx, b.channel)
+	defer pubsub.Close()
+
+	// Tunggu konfirmasi subscribe supaya error koneksi langsung terlihat
+	if _, err := pubsub.Receive(ctx); err != nil {
+		return fmt.Errorf("failed to subscribe to %s: %w", b.channel, err)
+	}
+
+	messages := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-messages:
+			if !ok {
+				return nil
+			}
+			var invalidation BalanceInvalidation
+			if err := json.Unmarshal([]byte(msg.Payload), &invalidation); err != nil {
+				log.Printf("Ignoring malformed balance invalidation: %v", err)
+				continue
+			}
+			handler(invalidation)
+		}
+	}
+}
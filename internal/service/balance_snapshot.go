@@ -0,0 +1,98 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"sub-balance-demo/internal/pagination"
+	"sub-balance-demo/internal/repository"
+)
+
+// snapshotPageSize bounds how many accounts one snapshot statement covers
+const snapshotPageSize = 1000
+
+// BalanceSnapshotService records every account's settled and available
+// balance once a day, for trend reporting and point-in-time reconciliation
+type BalanceSnapshotService struct {
+	repo   repository.BalanceSnapshotRepository
+	hour   int
+	minute int
+}
+
+// NewBalanceSnapshotService schedules snapshots at at, a local "HH:MM" time
+func NewBalanceSnapshotService(repo repository.BalanceSnapshotRepository, at string) *BalanceSnapshotService {
+	scheduled, err := time.Parse("15:04", at)
+	if err != nil {
+		log.Printf("Invalid balance snapshot time, using default 00:00: %v", err)
+		scheduled = time.Time{}
+	}
+
+	return &BalanceSnapshotService{
+		repo:   repo,
+		hour:   scheduled.Hour(),
+		minute: scheduled.Minute(),
+	}
+}
+
+// Start takes a snapshot at the scheduled time every day until ctx is cancelled
+func (b *BalanceSnapshotService) Start(ctx context.Context) {
+	log.Printf("Balance snapshot worker started, daily at %02d:%02d", b.hour, b.minute)
+	for {
+		next := b.nextRun(time.Now())
+		timer := time.NewTimer(time.Until(next))
+
+		select {
+		case <-timer.C:
+			_, err := b.Capture(ctx, next)
+			if err != nil {
+				log.Printf("Balance snapshot failed: %v", err)
+			}
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+func (b *BalanceSnapshotService) nextRun(now time.Time) time.Time {
+	next := time.Date(now.Year(), now.Month(), now.Day(), b.hour, b.minute, 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// Capture snapshots every account for the calendar day of at, page by page.
+// Accounts that already have a snapshot for that day keep it.
+func (b *BalanceSnapshotService) Capture(ctx context.Context, at time.Time) (int64, error) {
+	date := SnapshotDate(at)
+	start := time.Now()
+
+	var written int64
+	var last repository.AccountKey
+	for {
+		next, n, err := b.repo.Capture(ctx, date, last, snapshotPageSize)
+		if err != nil {
+			return written, fmt.Errorf("failed to snapshot balances after account %q of tenant %q: %w", last.AccountID, last.TenantID, err)
+		}
+		written += n
+		if next == (repository.AccountKey{}) {
+			break
+		}
+		last = next
+	}
+
+	log.Printf("Balance snapshot for %s completed in %s: %d accounts", date.Format("2006-01-02"), time.Since(start), written)
+	return written, nil
+}
+
+func (b *BalanceSnapshotService) List(ctx context.Context, filter repository.BalanceSnapshotFilter) (pagination.Page[repository.BalanceSnapshot], error) {
+	return b.repo.List(ctx, filter)
+}
+
+// SnapshotDate is the calendar day of t as stored in snapshot_date
+func SnapshotDate(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,147 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"sub-balance-demo/internal/repository"
+	"sub-balance-demo/internal/tenant"
+)
+
+// ErrTooManyWatchers is returned by Watch when the instance already streams
+// balances to its maximum number of watchers
+var ErrTooManyWatchers = errors.New("too many balance watchers")
+
+// watchResubscribeDelay is the pause before subscribing again after the
+// invalidation subscription failed
+const watchResubscribeDelay = 5 * time.Second
+
+// BalanceWatchers fans the balance invalidations of all instances out to the
+// balance streams open on this one. It holds one pub/sub subscription however
+// many streams are open.
+type BalanceWatchers struct {
+	invalidator *BalanceInvalidator
+	max         int // 0 = tanpa batas
+
+	mutex    sync.Mutex
+	watchers map[repository.AccountKey]map[*BalanceWatch]struct{}
+	count    int
+}
+
+// BalanceWatch is one stream's subscription to an account's balance changes.
+// Changes coalesce while the stream is busy sending: Changed fires once and
+// Reason returns the latest reason, so a slow reader never queues updates.
+type BalanceWatch struct {
+	account repository.AccountKey
+	hub     *BalanceWatchers
+	changed chan struct{}
+
+	mutex  sync.Mutex
+	reason string
+}
+
+func NewBalanceWatchers(invalidator *BalanceInvalidator, max int) *BalanceWatchers {
+	return &BalanceWatchers{
+		invalidator: invalidator,
+		max:         max,
+		watchers:    make(map[repository.AccountKey]map[*BalanceWatch]struct{}),
+	}
+}
+
+// Start subscribes to balance invalidations until ctx is cancelled,
+// subscribing again after a pause when the subscription fails
+func (h *BalanceWatchers) Start(ctx context.Context) {
+	for {
+		err := h.invalidator.Subscribe(ctx, h.notify)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Balance watch subscription failed, retrying in %s: %v", watchResubscribeDelay, err)
+		select {
+		case <-time.After(watchResubscribeDelay):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Watch starts watching accountID of the tenant bound to ctx; the caller must
+// Close the watch
+func (h *BalanceWatchers) Watch(ctx context.Context, accountID string) (*BalanceWatch, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.max > 0 && h.count >= h.max {
+		return nil, ErrTooManyWatchers
+	}
+
+	account := repository.AccountKey{TenantID: tenant.ID(ctx), AccountID: accountID}
+	watch := &BalanceWatch{account: account, hub: h, changed: make(chan struct{}, 1)}
+	if h.watchers[account] == nil {
+		h.watchers[account] = make(map[*BalanceWatch]struct{})
+	}
+	h.watchers[account][watch] = struct{}{}
+	h.count++
+	return watch, nil
+}
+
+// Count is the number of open watches, exported as a metric
+func (h *BalanceWatchers) Count() int {
+	if h == nil {
+		return 0
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.count
+}
+
+func (h *BalanceWatchers) notify(invalidation BalanceInvalidation) {
+	account := repository.AccountKey{TenantID: invalidation.TenantID, AccountID: invalidation.AccountID}
+	if account.TenantID == "" {
+		account.TenantID = tenant.Default
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for watch := range h.watchers[account] {
+		watch.notify(invalidation.Reason)
+	}
+}
+
+// Changed fires when the balance changed since the last Reason call
+func (w *BalanceWatch) Changed() <-chan struct{} {
+	return w.changed
+}
+
+// Reason returns the reason of the latest change
+func (w *BalanceWatch) Reason() string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.reason
+}
+
+func (w *BalanceWatch) notify(reason string) {
+	w.mutex.Lock()
+	w.reason = reason
+	w.mutex.Unlock()
+	select {
+	case w.changed <- struct{}{}:
+	default: // perubahan sebelumnya belum dikirim, digabung
+	}
+}
+
+func (w *BalanceWatch) Close() {
+	h := w.hub
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if _, ok := h.watchers[w.account][w]; !ok {
+		return
+	}
+	delete(h.watchers[w.account], w)
+	if len(h.watchers[w.account]) == 0 {
+		delete(h.watchers, w.account)
+	}
+	h.count--
+}
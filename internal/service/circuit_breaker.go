@@ -2,6 +2,7 @@ package service
 
 import (
 	"errors"
+	"log"
 	"sync"
 	"time"
 )
@@ -14,12 +15,46 @@ const (
 	StateHalfOpen CircuitBreakerState = "HALF_OPEN"
 )
 
+// Alasan perpindahan state circuit breaker
+const (
+	ReasonFailureThreshold = "failure_threshold"
+	ReasonProbeFailed      = "probe_failed"
+	ReasonTimeoutElapsed   = "timeout_elapsed"
+	ReasonProbeSucceeded   = "probe_succeeded"
+)
+
+// CircuitBreakerTransition describes one state change of the breaker
+type CircuitBreakerTransition struct {
+	From         CircuitBreakerState `json:"from"`
+	To           CircuitBreakerState `json:"to"`
+	Reason       string              `json:"reason"`
+	FailureCount int                 `json:"failure_count"`
+	At           time.Time           `json:"at"`
+}
+
+// CircuitBreakerTransitionCount is the number of times the breaker moved
+// between two states for the same reason
+type CircuitBreakerTransitionCount struct {
+	From   CircuitBreakerState `json:"from"`
+	To     CircuitBreakerState `json:"to"`
+	Reason string              `json:"reason"`
+	Count  int64               `json:"count"`
+}
+
+type transitionKey struct {
+	from   CircuitBreakerState
+	to     CircuitBreakerState
+	reason string
+}
+
 type CircuitBreaker struct {
 	failureCount     int
 	failureThreshold int
 	timeout          time.Duration
 	lastFailureTime  time.Time
 	state            CircuitBreakerState
+	transitions      map[transitionKey]int64
+	stateHooks       []func(CircuitBreakerTransition)
 	mutex            sync.RWMutex
 }
 
@@ -28,9 +63,18 @@ func NewCircuitBreaker(failureThreshold int, timeout time.Duration) *CircuitBrea
 		failureThreshold: failureThreshold,
 		timeout:          timeout,
 		state:            StateClosed,
+		transitions:      make(map[transitionKey]int64),
 	}
 }
 
+// OnStateChange registers fn to run (in its own goroutine) on every state
+// change, e.g. to page ops when traffic switches to the database fallback
+func (cb *CircuitBreaker) OnStateChange(fn func(CircuitBreakerTransition)) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	cb.stateHooks = append(cb.stateHooks, fn)
+}
+
 func (cb *CircuitBreaker) Call(fn func() error) error {
 	cb.mutex.Lock()
 	defer cb.mutex.Unlock()
@@ -38,7 +82,7 @@ func (cb *CircuitBreaker) Call(fn func() error) error {
 	// Check if circuit is open
 	if cb.state == StateOpen {
 		if time.Since(cb.lastFailureTime) > cb.timeout {
-			cb.state = StateHalfOpen
+			cb.setState(StateHalfOpen, ReasonTimeoutElapsed)
 		} else {
 			return errors.New("circuit breaker is OPEN")
 		}
@@ -51,18 +95,41 @@ func (cb *CircuitBreaker) Call(fn func() error) error {
 		cb.failureCount++
 		cb.lastFailureTime = time.Now()
 
-		if cb.failureCount >= cb.failureThreshold {
-			cb.state = StateOpen
+		switch {
+		case cb.state == StateHalfOpen:
+			cb.setState(StateOpen, ReasonProbeFailed)
+		case cb.state == StateClosed && cb.failureCount >= cb.failureThreshold:
+			cb.setState(StateOpen, ReasonFailureThreshold)
 		}
 		return err
 	}
 
 	// Success - reset failure count and close circuit
 	cb.failureCount = 0
-	cb.state = StateClosed
+	if cb.state != StateClosed {
+		cb.setState(StateClosed, ReasonProbeSucceeded)
+	}
 	return nil
 }
 
+// setState records the transition and fires the state hooks; caller holds the lock
+func (cb *CircuitBreaker) setState(to CircuitBreakerState, reason string) {
+	transition := CircuitBreakerTransition{
+		From:         cb.state,
+		To:           to,
+		Reason:       reason,
+		FailureCount: cb.failureCount,
+		At:           time.Now(),
+	}
+	cb.state = to
+	cb.transitions[transitionKey{from: transition.From, to: to, reason: reason}]++
+
+	log.Printf("Circuit breaker %s -> %s (reason=%s, failures=%d)", transition.From, to, reason, transition.FailureCount)
+	for _, hook := range cb.stateHooks {
+		go hook(transition)
+	}
+}
+
 func (cb *CircuitBreaker) GetState() CircuitBreakerState {
 	cb.mutex.RLock()
 	defer cb.mutex.RUnlock()
@@ -74,3 +141,15 @@ func (cb *CircuitBreaker) GetFailureCount() int {
 	defer cb.mutex.RUnlock()
 	return cb.failureCount
 }
+
+// Transitions returns how often the breaker changed state, per from/to/reason
+func (cb *CircuitBreaker) Transitions() []CircuitBreakerTransitionCount {
+	cb.mutex.RLock()
+	defer cb.mutex.RUnlock()
+
+	counts := make([]CircuitBreakerTransitionCount, 0, len(cb.transitions))
+	for key, count := range cb.transitions {
+		counts = append(counts, CircuitBreakerTransitionCount{From: key.from, To: key.to, Reason: key.reason, Count: count})
+	}
+	return counts
+}
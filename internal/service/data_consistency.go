@@ -2,10 +2,15 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 
+	"sub-balance-demo/internal/config"
+	"sub-balance-demo/internal/eventstream"
+	"sub-balance-demo/internal/logmask"
 	"sub-balance-demo/internal/repository"
+	"sub-balance-demo/internal/tenant"
 
 	"github.com/shopspring/decimal"
 	"gorm.io/gorm"
@@ -16,6 +21,16 @@ type DataConsistencyService struct {
 	redisCounter   RedisCounter
 	accountRepo    repository.AccountBalanceRepository
 	subBalanceRepo repository.SubBalanceRepository
+	accountLock    *DistributedLock
+	invalidator    *BalanceInvalidator
+	events         eventstream.Publisher
+	outbox         *Outbox
+	balanceAudit   *BalanceAuditTrail
+	domainEvents   *DomainEventLog
+
+	// creditSpendable mirrors PENDING_CREDIT_SPENDABLE: pending credits only
+	// count toward the available balance when they can be spent
+	creditSpendable bool
 }
 
 func NewDataConsistencyService(
@@ -23,30 +38,94 @@ func NewDataConsistencyService(
 	redisCounter RedisCounter,
 	accountRepo repository.AccountBalanceRepository,
 	subBalanceRepo repository.SubBalanceRepository,
+	accountLock *DistributedLock,
+	invalidator *BalanceInvalidator,
+	events eventstream.Publisher,
+	outbox *Outbox,
+	balanceAudit *BalanceAuditTrail,
+	domainEvents *DomainEventLog,
+	config *config.Config,
 ) *DataConsistencyService {
 	return &DataConsistencyService{
-		db:             db,
-		redisCounter:   redisCounter,
-		accountRepo:    accountRepo,
-		subBalanceRepo: subBalanceRepo,
+		db:              db,
+		redisCounter:    redisCounter,
+		accountRepo:     accountRepo,
+		subBalanceRepo:  subBalanceRepo,
+		accountLock:     accountLock,
+		invalidator:     invalidator,
+		events:          events,
+		outbox:          outbox,
+		balanceAudit:    balanceAudit,
+		domainEvents:    domainEvents,
+		creditSpendable: config.PendingCreditSpendable,
+	}
+}
+
+// pendingTotals sums pending rows per side
+func pendingTotals(rows []repository.SubBalance) PendingTotals {
+	totals := PendingTotals{Debit: decimal.Zero, Credit: decimal.Zero}
+	for _, row := range rows {
+		if row.Type == "credit" {
+			totals.Credit = totals.Credit.Add(row.Amount)
+		} else {
+			totals.Debit = totals.Debit.Add(row.Amount)
+		}
+	}
+	return totals
+}
+
+// availableBalance is the settled balance minus pending debits; pending
+// credits are only added when they are spendable, as in balance validation
+func (d *DataConsistencyService) availableBalance(settled decimal.Decimal, pending PendingTotals) decimal.Decimal {
+	available := settled.Sub(pending.Debit)
+	if d.creditSpendable {
+		available = available.Add(pending.Credit)
 	}
+	return available
+}
+
+// ValidateAndRepair checks every account and repairs inconsistent ones. It
+// returns how many accounts were repaired.
+func (d *DataConsistencyService) ValidateAndRepair(ctx context.Context) (int, error) {
+	ctx, span := tracer.Start(ctx, "ValidateAndRepair")
+	repaired, err := d.validateAndRepair(ctx)
+	endSpan(span, err)
+	return repaired, err
 }
 
-func (d *DataConsistencyService) ValidateAndRepair(ctx context.Context) error {
+func (d *DataConsistencyService) validateAndRepair(ctx context.Context) (int, error) {
 	log.Println("Starting data consistency validation...")
 
 	// 1. Get all account balances
 	var accounts []repository.AccountBalance
 	err := d.db.Find(&accounts).Error
 	if err != nil {
-		return fmt.Errorf("failed to get accounts: %w", err)
+		return 0, fmt.Errorf("failed to get accounts: %w", err)
+	}
+
+	// 2. Fetch Redis totals in one pipelined round trip per tenant
+	redisTotals := make(map[repository.AccountKey]PendingTotals, len(accounts))
+	for tenantID, accountIDs := range accountIDsByTenant(accounts) {
+		totals, err := d.redisCounter.GetPendingBulk(tenant.WithID(ctx, tenantID), accountIDs)
+		if err != nil {
+			log.Printf("Redis unavailable for consistency check: %v", err)
+			redisTotals = nil // Continue with DB-only validation
+			break
+		}
+		for accountID, t := range totals {
+			redisTotals[repository.AccountKey{TenantID: tenantID, AccountID: accountID}] = t
+		}
 	}
 
 	repairCount := 0
 	for _, account := range accounts {
-		repaired, err := d.validateAccount(ctx, account)
+		var totals *PendingTotals
+		if t, ok := redisTotals[repository.AccountKey{TenantID: account.TenantID, AccountID: account.ID}]; ok {
+			totals = &t
+		}
+		repaired, err := d.validateAccount(tenant.WithID(ctx, account.TenantID), account, totals)
 		if err != nil {
-			log.Printf("Failed to validate account %s: %v", account.ID, err)
+			log.Printf("Failed to validate account %s: %v", logmask.Account(account.ID), err)
 			continue
 		}
 		if repaired {
@@ -55,49 +134,80 @@ func (d *DataConsistencyService) ValidateAndRepair(ctx context.Context) error {
 	}
 
 	log.Printf("Data consistency validation completed. Repaired %d accounts", repairCount)
-	return nil
+	return repairCount, nil
 }
 
-func (d *DataConsistencyService) validateAccount(ctx context.Context, account repository.AccountBalance) (bool, error) {
-	// 1. Calculate pending from sub-balance table
-	var pendingFromDB decimal.Decimal
-	err := d.db.Model(&repository.SubBalance{}).
-		Where("account_id = ? AND status = ?", account.ID, "PENDING").
-		Select("COALESCE(SUM(amount), 0)").
-		Scan(&pendingFromDB).Error
-	if err != nil {
-		return false, fmt.Errorf("failed to get pending from DB: %w", err)
+// accountIDsByTenant groups account IDs by tenant, since Redis counters are
+// namespaced per tenant
+func accountIDsByTenant(accounts []repository.AccountBalance) map[string][]string {
+	grouped := make(map[string][]string)
+	for _, account := range accounts {
+		grouped[account.TenantID] = append(grouped[account.TenantID], account.ID)
 	}
+	return grouped
+}
 
-	// 2. Get pending from Redis (if available)
-	pendingFromRedis, err := d.redisCounter.GetPending(ctx, account.ID)
+// validateAccount compares an account against its pending rows. redisTotals
+// holds the prefetched Redis totals, or nil when Redis is unavailable.
+func (d *DataConsistencyService) validateAccount(ctx context.Context, account repository.AccountBalance, redisTotals *PendingTotals) (bool, error) {
+	// 1. Get pending rows from sub-balance table
+	pendingRows, err := d.subBalanceRepo.GetPendingByAccountID(ctx, account.ID)
 	if err != nil {
-		log.Printf("Redis unavailable for consistency check on account %s", account.ID)
-		// Continue with DB-only validation
+		return false, fmt.Errorf("failed to get pending from DB: %w", err)
 	}
+	totalsFromDB := pendingTotals(pendingRows)
 
-	// 3. Calculate actual available balance
-	actualAvailable := account.SettledBalance.Sub(pendingFromDB)
+	// 2. Calculate actual available balance
+	actualAvailable := d.availableBalance(account.SettledBalance, totalsFromDB)
 
-	// 4. Check Redis consistency (if available)
+	// 3. Check Redis consistency per side (if available)
 	redisInconsistent := false
-	if err == nil && !pendingFromDB.Equal(pendingFromRedis) {
-		log.Printf("Redis inconsistency detected for account %s: DB=%s, Redis=%s",
-			account.ID, pendingFromDB.String(), pendingFromRedis.String())
-		redisInconsistent = true
+	if redisTotals != nil {
+		if !totalsFromDB.Debit.Equal(redisTotals.Debit) {
+			log.Printf("Redis debit inconsistency detected for account %s: DB=%s, Redis=%s",
+				logmask.Account(account.ID), logmask.Amount(totalsFromDB.Debit), logmask.Amount(redisTotals.Debit))
+			redisInconsistent = true
+		}
+		if !totalsFromDB.Credit.Equal(redisTotals.Credit) {
+			log.Printf("Redis credit inconsistency detected for account %s: DB=%s, Redis=%s",
+				logmask.Account(account.ID), logmask.Amount(totalsFromDB.Credit), logmask.Amount(redisTotals.Credit))
+			redisInconsistent = true
+		}
+	}
+
+	// 4. Totals match: compare row by row, only needed when something is pending
+	if redisTotals != nil && !redisInconsistent && len(pendingRows) > 0 {
+		redisEntries, err := d.redisCounter.GetPendingEntries(ctx, account.ID)
+		if err != nil {
+			log.Printf("Redis unavailable for consistency check on account %s", logmask.Account(account.ID))
+		} else {
+			mismatched := len(redisEntries) != len(pendingRows)
+			for _, row := range pendingRows {
+				entry, exists := redisEntries[row.ID]
+				if !exists || entry.Type != row.Type || !entry.Amount.Equal(row.Amount) {
+					mismatched = true
+					break
+				}
+			}
+			if mismatched {
+				log.Printf("Redis entry mismatch detected for account %s: DB=%d entries, Redis=%d entries",
+					logmask.Account(account.ID), len(pendingRows), len(redisEntries))
+				redisInconsistent = true
+			}
+		}
 	}
 
 	// 5. Check account balance calculation
 	balanceInconsistent := !account.AvailableBalance.Equal(actualAvailable)
 	if balanceInconsistent {
 		log.Printf("Account balance inconsistency for %s: stored=%s, calculated=%s",
-			account.ID, account.AvailableBalance.String(), actualAvailable.String())
+			logmask.Account(account.ID), logmask.Amount(account.AvailableBalance), logmask.Amount(actualAvailable))
 	}
 
 	// 6. Auto-repair if needed
 	repaired := false
 	if redisInconsistent || balanceInconsistent {
-		err := d.repairAccount(ctx, account, pendingFromDB, actualAvailable)
+		err := d.repairAccount(ctx, account.ID, redisTotals)
 		if err != nil {
 			return false, fmt.Errorf("failed to repair account: %w", err)
 		}
@@ -107,94 +217,303 @@ func (d *DataConsistencyService) validateAccount(ctx context.Context, account re
 	return repaired, nil
 }
 
-func (d *DataConsistencyService) repairAccount(ctx context.Context, account repository.AccountBalance, pendingFromDB, actualAvailable decimal.Decimal) error {
-	return d.db.Transaction(func(tx *gorm.DB) error {
-		// 1. Update account balance
+// repairAccount recomputes the account from its pending rows and rebuilds the
+// Redis counter. validated is the counter value the check compared against (nil
+// if Redis was not read); the counter is only overwritten if it still holds it.
+func (d *DataConsistencyService) repairAccount(ctx context.Context, accountID string, validated *PendingTotals) error {
+	// Repair membangun ulang Redis; tanpa lock antar instance dua repair bisa
+	// saling menimpa SetPending dengan snapshot yang berbeda
+	err := d.accountLock.WithAccountLock(ctx, accountID, func(ctx context.Context) error {
+		return d.repairAccountLocked(ctx, accountID, validated)
+	})
+	if err != nil {
+		return err
+	}
+
+	d.invalidator.Publish(ctx, accountID, "repair")
+	// Dengan outbox, event repair sudah ditulis bersama perbaikannya
+	if d.outbox != nil || d.events == nil {
+		return nil
+	}
+
+	err = d.events.Publish(ctx, eventstream.Event{Type: eventstream.AccountRepaired, AccountID: accountID})
+	if err != nil {
+		log.Printf("Failed to publish repair event for account %s: %v", logmask.Account(accountID), err)
+	}
+	return nil
+}
+
+func (d *DataConsistencyService) repairAccountLocked(ctx context.Context, accountID string, validated *PendingTotals) error {
+	// Tanpa nilai tervalidasi, version dibaca sebelum snapshot database; jika
+	// AddPending masuk di antaranya, rebuild ditolak alih-alih menghapus entry baru
+	redisVersion := int64(0)
+	var versionErr error
+	if validated == nil {
+		var versions map[string]int64
+		versions, versionErr = d.redisCounter.GetVersions(ctx, []string{accountID})
+		redisVersion = versions[accountID]
+	}
+
+	return d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		accountRepo := d.accountRepo.WithTx(tx)
+
+		// 1. Lock account, lalu hitung ulang di bawah lock supaya tidak menimpa
+		// hasil settlement atau fallback yang berjalan bersamaan
+		err := accountRepo.LockAccount(ctx, accountID)
+		if err != nil {
+			return fmt.Errorf("failed to lock account: %w", err)
+		}
+
+		account, err := accountRepo.GetByIDForUpdate(ctx, accountID)
+		if err != nil {
+			return fmt.Errorf("failed to get account: %w", err)
+		}
+
+		pendingRows, err := d.subBalanceRepo.WithTx(tx).GetPendingByAccountID(ctx, accountID)
+		if err != nil {
+			return fmt.Errorf("failed to get pending from DB: %w", err)
+		}
+		entries := make(map[string]PendingEntry, len(pendingRows))
+		for _, row := range pendingRows {
+			entries[row.ID] = PendingEntry{Type: row.Type, Amount: row.Amount}
+		}
+		totalsFromDB := pendingTotals(pendingRows)
+		actualAvailable := d.availableBalance(account.SettledBalance, totalsFromDB)
+
+		// 2. Update account balance
+		before := *account
 		account.AvailableBalance = actualAvailable
-		err := tx.Save(&account).Error
+		err = accountRepo.Update(ctx, account)
 		if err != nil {
 			return fmt.Errorf("failed to update account balance: %w", err)
 		}
 
-		// 2. Update Redis counter (if available)
-		err = d.redisCounter.ClearPending(ctx, account.ID)
+		err = d.balanceAudit.Record(ctx, tx, BalanceSourceRepair, "", before, *account)
 		if err != nil {
-			log.Printf("Failed to clear Redis counter for account %s: %v", account.ID, err)
+			return err
 		}
 
-		if !pendingFromDB.IsZero() {
-			// Re-add pending amount to Redis
-			_, _, err = d.redisCounter.AddPending(ctx, account.ID, pendingFromDB, account.SettledBalance)
-			if err != nil {
-				log.Printf("Failed to update Redis counter for account %s: %v", account.ID, err)
+		// 3. Rebuild Redis entries dari database (if available)
+		switch {
+		case validated != nil:
+			var swapped bool
+			swapped, err = d.redisCounter.SetPendingIfEquals(ctx, account.ID, *validated, entries)
+			if err == nil && !swapped {
+				err = ErrStaleCounter
 			}
+		case versionErr != nil:
+			err = versionErr
+		default:
+			err = d.redisCounter.SetPending(ctx, account.ID, redisVersion, entries)
+		}
+		if errors.Is(err, ErrStaleCounter) {
+			log.Printf("Redis counter for account %s changed during repair, leaving it for the next check", logmask.Account(account.ID))
+		} else if err != nil {
+			log.Printf("Failed to update Redis counter for account %s: %v", logmask.Account(account.ID), err)
 		}
 
-		log.Printf("Repaired account %s: available=%s, pending=%s",
-			account.ID, actualAvailable.String(), pendingFromDB.String())
-		return nil
+		log.Printf("Repaired account %s: available=%s, pending_debit=%s, pending_credit=%s",
+			logmask.Account(account.ID), logmask.Amount(actualAvailable), logmask.Amount(totalsFromDB.Debit), logmask.Amount(totalsFromDB.Credit))
+		err = d.domainEvents.Record(ctx, tx, NewDomainEvent(DomainEventRepaired, account.ID, "", map[string]interface{}{
+			"old_available_balance": before.AvailableBalance.String(),
+			"new_available_balance": actualAvailable.String(),
+			"pending_debit":         totalsFromDB.Debit.String(),
+			"pending_credit":        totalsFromDB.Credit.String(),
+		}))
+		if err != nil {
+			return err
+		}
+		return d.outbox.Write(ctx, tx, eventstream.Event{Type: eventstream.AccountRepaired, AccountID: account.ID})
 	})
 }
 
+// recoveryPageSize bounds how many accounts RecoverRedisFromDatabase rebuilds
+// per page, keeping memory flat regardless of the number of accounts
+const recoveryPageSize = 1000
+
+// RecoverRedisFromDatabase rebuilds every Redis counter from the pending rows
+// in the database, one page of accounts at a time
 func (d *DataConsistencyService) RecoverRedisFromDatabase(ctx context.Context) error {
 	log.Println("Starting Redis recovery from database...")
 
-	// 1. Get all pending transactions from database
+	var last repository.AccountKey
+	accounts, stale := 0, 0
+	for {
+		var page []repository.AccountBalance
+		err := d.db.WithContext(ctx).
+			Select("id", "tenant_id").
+			Where("(tenant_id, id) > (?, ?)", last.TenantID, last.AccountID).
+			Order("tenant_id, id").
+			Limit(recoveryPageSize).
+			Find(&page).Error
+		if err != nil {
+			return fmt.Errorf("failed to get accounts: %w", err)
+		}
+		if len(page) == 0 {
+			break
+		}
+
+		for tenantID, accountIDs := range accountIDsByTenant(page) {
+			pageStale, err := d.recoverAccounts(tenant.WithID(ctx, tenantID), accountIDs)
+			if err != nil {
+				return err
+			}
+			stale += pageStale
+		}
+		accounts += len(page)
+
+		if len(page) < recoveryPageSize {
+			break
+		}
+		last = repository.AccountKey{TenantID: page[len(page)-1].TenantID, AccountID: page[len(page)-1].ID}
+	}
+
+	if stale > 0 {
+		log.Printf("Skipped %d Redis counters written during recovery; the consistency check re-validates them", stale)
+	}
+	log.Printf("Redis recovery completed: %d accounts", accounts)
+	return nil
+}
+
+// recoverAccounts rebuilds the counters of accounts of the tenant bound to ctx
+// and returns how many were skipped because live traffic wrote them in the meantime
+func (d *DataConsistencyService) recoverAccounts(ctx context.Context, accountIDs []string) (int, error) {
+	// 1. Snapshot counter versions before reading the database, so counters
+	// written by live traffic in the meantime are not overwritten
+	versions, err := d.redisCounter.GetVersions(ctx, accountIDs)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read Redis counter versions: %w", err)
+	}
+
+	// 2. Get pending transactions of this page from database
 	var pendingTransactions []repository.SubBalance
-	err := d.db.Where("status = ?", "PENDING").Find(&pendingTransactions).Error
+	err = d.db.WithContext(ctx).Scopes(tenant.Scope(ctx)).
+		Where("account_id IN ? AND status = ?", accountIDs, "PENDING").
+		Find(&pendingTransactions).Error
 	if err != nil {
-		return fmt.Errorf("failed to get pending transactions: %w", err)
+		return 0, fmt.Errorf("failed to get pending transactions: %w", err)
 	}
 
-	// 2. Group by account and calculate total pending
-	accountPending := make(map[string]decimal.Decimal)
+	// 3. Group entries by account
+	accountPending := make(map[string]map[string]PendingEntry)
 	for _, tx := range pendingTransactions {
-		accountPending[tx.AccountID] = accountPending[tx.AccountID].Add(tx.Amount)
+		if accountPending[tx.AccountID] == nil {
+			accountPending[tx.AccountID] = make(map[string]PendingEntry)
+		}
+		accountPending[tx.AccountID][tx.ID] = PendingEntry{Type: tx.Type, Amount: tx.Amount}
 	}
 
-	// 3. Update Redis counter
-	for accountID, totalPending := range accountPending {
-		// Clear existing counter
-		err := d.redisCounter.ClearPending(ctx, accountID)
-		if err != nil {
-			log.Printf("Failed to clear Redis counter for account %s: %v", accountID, err)
+	// 4. Replace Redis entries per account
+	stale := 0
+	for accountID, entries := range accountPending {
+		err := d.redisCounter.SetPending(ctx, accountID, versions[accountID], entries)
+		if errors.Is(err, ErrStaleCounter) {
+			stale++
+		} else if err != nil {
+			log.Printf("Failed to set Redis counter for account %s: %v", logmask.Account(accountID), err)
+		} else {
+			log.Printf("Recovered Redis counter for account %s: %d entries", logmask.Account(accountID), len(entries))
 		}
+	}
 
-		// Set new counter
-		if !totalPending.IsZero() {
-			// Get account balance for max balance validation
-			account, err := d.accountRepo.GetByID(ctx, accountID)
-			if err != nil {
-				log.Printf("Failed to get account %s for Redis recovery: %v", accountID, err)
-				continue
-			}
+	// 5. Empty the Redis counter of accounts with no pending
+	idleAccounts := make([]string, 0, len(accountIDs))
+	for _, accountID := range accountIDs {
+		if _, exists := accountPending[accountID]; !exists {
+			idleAccounts = append(idleAccounts, accountID)
+		}
+	}
+
+	// Only touch counters that still hold something; if the bulk read fails,
+	// empty every idle account
+	redisTotals, err := d.redisCounter.GetPendingBulk(ctx, idleAccounts)
+	if err != nil {
+		log.Printf("Failed to read Redis counters in bulk: %v", err)
+	}
+	for _, accountID := range idleAccounts {
+		totals, known := redisTotals[accountID]
+		if known && totals.Debit.IsZero() && totals.Credit.IsZero() {
+			continue
+		}
 
-			maxBalance := account.SettledBalance.Add(account.PendingCredit).Sub(account.PendingDebit)
-			_, _, err = d.redisCounter.AddPending(ctx, accountID, totalPending, maxBalance)
+		// Kosongkan hanya jika counter masih berisi nilai yang baru dibaca
+		if known {
+			swapped, err := d.redisCounter.SetPendingIfEquals(ctx, accountID, totals, nil)
 			if err != nil {
-				log.Printf("Failed to set Redis counter for account %s: %v", accountID, err)
-			} else {
-				log.Printf("Recovered Redis counter for account %s: %s", accountID, totalPending.String())
+				log.Printf("Failed to clear Redis counter for account %s: %v", logmask.Account(accountID), err)
+			} else if !swapped {
+				stale++
 			}
+			continue
+		}
+
+		err := d.redisCounter.SetPending(ctx, accountID, versions[accountID], nil)
+		if errors.Is(err, ErrStaleCounter) {
+			stale++
+		} else if err != nil {
+			log.Printf("Failed to clear Redis counter for account %s: %v", logmask.Account(accountID), err)
 		}
 	}
 
-	// 4. Clear Redis counter for accounts with no pending
-	var allAccounts []string
-	err = d.db.Model(&repository.AccountBalance{}).Pluck("id", &allAccounts).Error
+	return stale, nil
+}
+
+// CounterReport compares one Redis pending counter with the DB pending sums
+type CounterReport struct {
+	AccountID    string        `json:"account_id"`
+	Redis        PendingTotals `json:"redis"`
+	RedisEntries int64         `json:"redis_entries"`
+	TTLSeconds   float64       `json:"ttl_seconds"` // -1 = no expiry
+	Database     PendingTotals `json:"database"`
+	Legacy       bool          `json:"legacy,omitempty"`
+	Drifted      bool          `json:"drifted"`
+}
+
+// CounterInspection is one SCAN page of counter reports; NextCursor is 0 when done
+type CounterInspection struct {
+	NextCursor uint64          `json:"next_cursor"`
+	Items      []CounterReport `json:"items"`
+}
+
+// InspectCounters scans one page of Redis pending counters and pairs each with
+// the matching DB pending sums, flagging counters that drifted
+func (d *DataConsistencyService) InspectCounters(ctx context.Context, cursor uint64, count int64) (*CounterInspection, error) {
+	counters, next, err := d.redisCounter.ScanPending(ctx, cursor, count)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan Redis counters: %w", err)
+	}
+
+	accountIDs := make([]string, len(counters))
+	for i, counter := range counters {
+		accountIDs[i] = counter.AccountID
+	}
+	sums, err := d.subBalanceRepo.GetPendingSumsByAccountIDs(ctx, accountIDs)
 	if err != nil {
-		return fmt.Errorf("failed to get all accounts: %w", err)
+		return nil, fmt.Errorf("failed to get pending from DB: %w", err)
 	}
 
-	for _, accountID := range allAccounts {
-		if _, exists := accountPending[accountID]; !exists {
-			err := d.redisCounter.ClearPending(ctx, accountID)
-			if err != nil {
-				log.Printf("Failed to clear Redis counter for account %s: %v", accountID, err)
-			}
+	inspection := &CounterInspection{NextCursor: next, Items: make([]CounterReport, 0, len(counters))}
+	for _, counter := range counters {
+		report := CounterReport{
+			AccountID:    counter.AccountID,
+			Redis:        counter.Totals,
+			RedisEntries: counter.Entries,
+			TTLSeconds:   -1,
+			Database:     PendingTotals{Debit: decimal.Zero, Credit: decimal.Zero},
+			Legacy:       counter.Legacy,
+		}
+		if counter.TTL > 0 {
+			report.TTLSeconds = counter.TTL.Seconds()
 		}
+		if sum, ok := sums[counter.AccountID]; ok {
+			report.Database = PendingTotals{Debit: sum.Debit, Credit: sum.Credit}
+		}
+		report.Drifted = counter.Legacy ||
+			!report.Redis.Debit.Equal(report.Database.Debit) ||
+			!report.Redis.Credit.Equal(report.Database.Credit)
+
+		inspection.Items = append(inspection.Items, report)
 	}
 
-	log.Println("Redis recovery completed")
-	return nil
+	return inspection, nil
 }
@@ -0,0 +1,101 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// ErrDatabaseUnavailable is returned instead of waiting on the connection pool
+// while the database health check is failing
+var ErrDatabaseUnavailable = errors.New("database unavailable")
+
+// DBHealthChecker pings the primary database periodically, like
+// RedisHealthChecker does for Redis. Saat database down tidak ada jalur
+// fallback: baik jalur Redis maupun database fallback menulis sub_balance.
+type DBHealthChecker struct {
+	db            *sql.DB
+	isHealthy     bool
+	mutex         sync.RWMutex
+	checkInterval time.Duration
+	recoveryHooks []func()
+	history       *HealthHistory
+}
+
+func NewDBHealthChecker(db *sql.DB, checkInterval time.Duration, history *HealthHistory) *DBHealthChecker {
+	return &DBHealthChecker{
+		db:            db,
+		isHealthy:     true,
+		checkInterval: checkInterval,
+		history:       history,
+	}
+}
+
+func (d *DBHealthChecker) IsHealthy() bool {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	return d.isHealthy
+}
+
+// OnRecovery registers fn to run (in its own goroutine) whenever the database
+// comes back online after being marked down
+func (d *DBHealthChecker) OnRecovery(fn func()) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.recoveryHooks = append(d.recoveryHooks, fn)
+}
+
+func (d *DBHealthChecker) StartHealthCheck(ctx context.Context) {
+	ticker := time.NewTicker(d.checkInterval)
+	defer ticker.Stop()
+
+	log.Println("Database health checker started")
+
+	for {
+		select {
+		case <-ticker.C:
+			d.checkHealth()
+		case <-ctx.Done():
+			log.Println("Database health checker stopped")
+			return
+		}
+	}
+}
+
+func (d *DBHealthChecker) checkHealth() {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	err := d.db.PingContext(ctx)
+
+	d.mutex.Lock()
+	wasHealthy := d.isHealthy
+	d.isHealthy = (err == nil)
+
+	recovered := !wasHealthy && d.isHealthy
+	if recovered {
+		log.Println("✅ Database is back online")
+		d.history.RecordUp(HealthComponentDatabase)
+	} else if wasHealthy && !d.isHealthy {
+		log.Printf("❌ Database is down, rejecting transactions: %v", err)
+		d.history.RecordDown(HealthComponentDatabase, err)
+	}
+	hooks := d.recoveryHooks
+	d.mutex.Unlock()
+
+	if recovered {
+		for _, hook := range hooks {
+			go hook()
+		}
+	}
+}
+
+func (d *DBHealthChecker) TestConnection(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	return d.db.PingContext(ctx)
+}
@@ -0,0 +1,163 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"sub-balance-demo/internal/logmask"
+	"sub-balance-demo/internal/repository"
+	"sub-balance-demo/internal/tenant"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrLockNotAcquired is returned when the lock is still held by someone else
+// after the wait timeout
+var ErrLockNotAcquired = errors.New("distributed lock not acquired")
+
+// DistributedLock is a Redis-based mutex (single-node Redlock) that serializes
+// operations on the same account across instances. Every acquisition returns a
+// monotonically increasing fencing token so downstream writes can reject a
+// holder whose lease already expired. A nil *DistributedLock is a no-op.
+type DistributedLock struct {
+	client        *redis.Client
+	keyPrefix     string
+	ttl           time.Duration
+	waitTimeout   time.Duration
+	retryInterval time.Duration
+
+	failOpen atomic.Int64 // WithAccountLock calls run without the lock, Redis unreachable
+}
+
+var (
+	// Set the lock and bump the fencing counter in one step. The fence is at
+	// least the Redis time in microseconds, so it keeps increasing even if
+	// Redis lost the counter: a smaller fence would be refused by every
+	// account that already stored a bigger one.
+	acquireLockScript = redis.NewScript(`
+		if redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then
+			local now = redis.call('TIME')
+			local floor = tonumber(now[1]) * 1000000 + tonumber(now[2])
+			local fence = math.max(tonumber(redis.call('GET', KEYS[2]) or '0') + 1, floor)
+			redis.call('SET', KEYS[2], string.format('%d', fence))
+			return fence
+		end
+		return 0
+	`)
+
+	// Delete the lock only if this holder still owns it
+	releaseLockScript = redis.NewScript(`
+		if redis.call('GET', KEYS[1]) == ARGV[1] then
+			return redis.call('DEL', KEYS[1])
+		end
+		return 0
+	`)
+)
+
+// Lock is a held lease on a resource
+type Lock struct {
+	key   string
+	token string
+	Fence int64
+}
+
+func NewDistributedLock(client *redis.Client, keyPrefix string, ttl, waitTimeout time.Duration) *DistributedLock {
+	return &DistributedLock{
+		client:        client,
+		keyPrefix:     keyPrefix,
+		ttl:           ttl,
+		waitTimeout:   waitTimeout,
+		retryInterval: 50 * time.Millisecond,
+	}
+}
+
+// Acquire sets the lock key with NX/PX and bumps the fencing counter in one
+// atomic step, retrying until the wait timeout
+func (d *DistributedLock) Acquire(ctx context.Context, resource string) (*Lock, error) {
+	key := fmt.Sprintf("%s:lock:%s", d.keyPrefix, resource)
+	fenceKey := fmt.Sprintf("%s:lock:fence:%s", d.keyPrefix, resource)
+	token := uuid.New().String()
+	deadline := time.Now().Add(d.waitTimeout)
+
+	for {
+		fence, err := acquireLockScript.Run(ctx, d.client, []string{key, fenceKey}, token, d.ttl.Milliseconds()).Int64()
+		if err != nil {
+			return nil, fmt.Errorf("failed to acquire lock %s: %w", resource, err)
+		}
+		if fence > 0 {
+			return &Lock{key: key, token: token, Fence: fence}, nil
+		}
+
+		if time.Now().After(deadline) {
+			return nil, ErrLockNotAcquired
+		}
+
+		select {
+		case <-time.After(d.retryInterval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Release deletes the lock only if it is still owned by this holder
+func (d *DistributedLock) Release(ctx context.Context, lock *Lock) error {
+	return releaseLockScript.Run(ctx, d.client, []string{lock.key}, lock.token).Err()
+}
+
+// WithLock runs fn while holding the lock on resource and passes it the fencing token
+func (d *DistributedLock) WithLock(ctx context.Context, resource string, fn func(fence int64) error) error {
+	if d == nil {
+		return fn(0)
+	}
+
+	lock, err := d.Acquire(ctx, resource)
+	if err != nil {
+		return err
+	}
+	defer d.Release(context.WithoutCancel(ctx), lock)
+
+	return fn(lock.Fence)
+}
+
+// WithAccountLock serializes fn with other instances operating on the same
+// account. fn gets ctx with the fencing token (repository.WithFence), so its
+// account_balances writes fail with repository.ErrStaleFence if the lease
+// expired and another holder wrote in the meantime. If Redis itself is
+// unreachable fn runs without the lock and without a fence, counted in
+// FailOpenCount: the Postgres advisory lock taken inside fn stays the
+// authoritative guard.
+func (d *DistributedLock) WithAccountLock(ctx context.Context, accountID string, fn func(ctx context.Context) error) error {
+	ran := false
+	err := d.WithLock(ctx, "account:"+tenant.Qualify(ctx, accountID), func(fence int64) error {
+		ran = true
+		if fence > 0 {
+			return fn(repository.WithFence(ctx, fence))
+		}
+		return fn(ctx)
+	})
+	if ran {
+		return err
+	}
+	if errors.Is(err, ErrLockNotAcquired) || ctx.Err() != nil {
+		return err
+	}
+
+	d.failOpen.Add(1)
+	log.Printf("Distributed lock unavailable for account %s, relying on database lock: %v", logmask.Account(accountID), err)
+	return fn(ctx)
+}
+
+// FailOpenCount is the number of WithAccountLock calls that ran without the
+// lock because Redis was unreachable
+func (d *DistributedLock) FailOpenCount() int64 {
+	if d == nil {
+		return 0
+	}
+	return d.failOpen.Load()
+}
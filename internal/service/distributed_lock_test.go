@@ -0,0 +1,163 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"sub-balance-demo/internal/repository"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/shopspring/decimal"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTestLock(t *testing.T, ttl time.Duration) (*DistributedLock, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr(), MaxRetries: -1})
+	t.Cleanup(func() { client.Close() })
+	return NewDistributedLock(client, "test", ttl, 20*time.Millisecond), server
+}
+
+func newTestAccounts(t *testing.T) repository.AccountBalanceRepository {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := db.AutoMigrate(&repository.AccountBalance{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	accounts := repository.NewAccountBalanceRepository(db, nil)
+	err = accounts.Create(context.Background(), &repository.AccountBalance{ID: "ACC001", SettledBalance: decimal.NewFromInt(100)})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	return accounts
+}
+
+// addSettled adds amount to the settled balance of ACC001 with ctx
+func addSettled(ctx context.Context, accounts repository.AccountBalanceRepository, amount int64) error {
+	balance, err := accounts.GetByID(ctx, "ACC001")
+	if err != nil {
+		return err
+	}
+	balance.SettledBalance = balance.SettledBalance.Add(decimal.NewFromInt(amount))
+	return accounts.UpdateBalance(ctx, balance)
+}
+
+func TestWithAccountLockRejectsExpiredHolder(t *testing.T) {
+	lock, server := newTestLock(t, time.Second)
+	accounts := newTestAccounts(t)
+	ctx := context.Background()
+
+	err := lock.WithAccountLock(ctx, "ACC001", func(stale context.Context) error {
+		// Lease habis di tengah fn (GC pause, query lambat); holder baru masuk dan menulis
+		server.FastForward(2 * time.Second)
+		err := lock.WithAccountLock(ctx, "ACC001", func(current context.Context) error {
+			return addSettled(current, accounts, 10)
+		})
+		if err != nil {
+			t.Fatalf("new holder: %v", err)
+		}
+		return addSettled(stale, accounts, 20)
+	})
+	if !errors.Is(err, repository.ErrStaleFence) {
+		t.Fatalf("expired holder write = %v, want ErrStaleFence", err)
+	}
+
+	balance, _ := accounts.GetByID(ctx, "ACC001")
+	if !balance.SettledBalance.Equal(decimal.NewFromInt(110)) {
+		t.Fatalf("settled balance = %s, want 110 (only the new holder's write)", balance.SettledBalance)
+	}
+
+	// Holder yang sama boleh menulis lebih dari sekali
+	err = lock.WithAccountLock(ctx, "ACC001", func(ctx context.Context) error {
+		if err := addSettled(ctx, accounts, 1); err != nil {
+			return err
+		}
+		return addSettled(ctx, accounts, 1)
+	})
+	if err != nil {
+		t.Fatalf("two writes of one holder: %v", err)
+	}
+}
+
+func TestDistributedLockFenceSurvivesRedisDataLoss(t *testing.T) {
+	lock, server := newTestLock(t, time.Second)
+	ctx := context.Background()
+	now := time.Now()
+
+	server.SetTime(now)
+	first, err := lock.Acquire(ctx, "account:ACC001")
+	if err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+	server.FlushAll()
+	server.SetTime(now.Add(time.Millisecond))
+	second, err := lock.Acquire(ctx, "account:ACC001")
+	if err != nil {
+		t.Fatalf("Acquire after FLUSHALL: %v", err)
+	}
+	if second.Fence <= first.Fence {
+		t.Fatalf("fence after FLUSHALL = %d, want more than %d", second.Fence, first.Fence)
+	}
+}
+
+func TestWithAccountLockFailOpen(t *testing.T) {
+	tests := []struct {
+		name         string
+		setup        func(lock *DistributedLock, server *miniredis.Miniredis)
+		wantErr      error
+		wantRan      bool
+		wantFailOpen int64
+	}{
+		{
+			name:    "lock held",
+			wantRan: true,
+		},
+		{
+			name: "held by another instance",
+			setup: func(lock *DistributedLock, server *miniredis.Miniredis) {
+				lock.Acquire(context.Background(), "account:ACC001")
+			},
+			wantErr: ErrLockNotAcquired,
+		},
+		{
+			name: "Redis unreachable",
+			setup: func(lock *DistributedLock, server *miniredis.Miniredis) {
+				server.Close()
+			},
+			wantRan:      true,
+			wantFailOpen: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lock, server := newTestLock(t, time.Second)
+			if tt.setup != nil {
+				tt.setup(lock, server)
+			}
+
+			ran := false
+			err := lock.WithAccountLock(context.Background(), "ACC001", func(ctx context.Context) error {
+				ran = true
+				return nil
+			})
+			if !errors.Is(err, tt.wantErr) || ran != tt.wantRan {
+				t.Fatalf("WithAccountLock = %v, ran %v; want %v, ran %v", err, ran, tt.wantErr, tt.wantRan)
+			}
+			if got := lock.FailOpenCount(); got != tt.wantFailOpen {
+				t.Fatalf("FailOpenCount = %d, want %d", got, tt.wantFailOpen)
+			}
+		})
+	}
+}
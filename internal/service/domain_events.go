@@ -0,0 +1,75 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"sub-balance-demo/internal/pagination"
+	"sub-balance-demo/internal/repository"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Tipe event pada tabel domain_events
+const (
+	DomainEventAcceptedRedis    = "transaction.accepted_redis"
+	DomainEventAcceptedFallback = "transaction.accepted_fallback" // local counter atau database fallback
+	DomainEventSettled          = "account.settled"
+	DomainEventRepaired         = "account.repaired"
+	DomainEventBreakerOpened    = "circuit_breaker.opened"
+)
+
+// DomainEventLog persists significant domain events in the domain_events
+// table. Account events are written inside the transaction that makes the
+// change, so an event exists if and only if the change committed. A nil
+// *DomainEventLog is disabled.
+type DomainEventLog struct {
+	repo repository.DomainEventRepository
+}
+
+func NewDomainEventLog(repo repository.DomainEventRepository) *DomainEventLog {
+	return &DomainEventLog{repo: repo}
+}
+
+// NewDomainEvent builds an event; detail is stored as JSON
+func NewDomainEvent(eventType string, accountID string, reference string, detail map[string]interface{}) repository.DomainEvent {
+	encoded, err := json.Marshal(detail)
+	if err != nil {
+		encoded = []byte(fmt.Sprintf(`{"error":%q}`, err.Error()))
+	}
+	return repository.DomainEvent{
+		ID:        uuid.New().String(),
+		Type:      eventType,
+		AccountID: accountID,
+		Reference: reference,
+		Detail:    string(encoded),
+	}
+}
+
+// Record stores the events using tx, or on its own when tx is nil. It is a
+// no-op on a nil log.
+func (l *DomainEventLog) Record(ctx context.Context, tx *gorm.DB, events ...repository.DomainEvent) error {
+	if l == nil || len(events) == 0 {
+		return nil
+	}
+	repo := l.repo
+	if tx != nil {
+		repo = repo.WithTx(tx)
+	}
+	err := repo.Create(ctx, events)
+	if err != nil {
+		return fmt.Errorf("failed to write domain events: %w", err)
+	}
+	return nil
+}
+
+// Enabled reports whether events are recorded
+func (l *DomainEventLog) Enabled() bool {
+	return l != nil
+}
+
+func (l *DomainEventLog) List(ctx context.Context, filter repository.DomainEventFilter) (pagination.Page[repository.DomainEvent], error) {
+	return l.repo.List(ctx, filter)
+}
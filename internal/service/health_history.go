@@ -0,0 +1,100 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// Komponen yang dicatat HealthHistory
+const (
+	HealthComponentRedis    = "redis"
+	HealthComponentDatabase = "database"
+)
+
+// HealthOutage is one period a dependency was marked down by its health
+// checker. UpAt is nil while the outage is ongoing.
+type HealthOutage struct {
+	Component       string     `json:"component"`
+	DownAt          time.Time  `json:"down_at"`
+	UpAt            *time.Time `json:"up_at"`
+	DurationSeconds float64    `json:"duration_seconds"` // so far, for an ongoing outage
+	Error           string     `json:"error,omitempty"`
+}
+
+// HealthHistory keeps the last outages of Redis and the database in a ring
+// buffer, for post-incident reviews of when fallback mode was active. It is
+// kept in memory on purpose: a database outage cannot be written to the
+// database it is about. A nil *HealthHistory records nothing.
+type HealthHistory struct {
+	outages []HealthOutage
+	next    int // slot yang ditimpa berikutnya saat buffer penuh
+	mutex   sync.Mutex
+}
+
+func NewHealthHistory(capacity int) *HealthHistory {
+	if capacity <= 0 {
+		capacity = 100
+	}
+	return &HealthHistory{outages: make([]HealthOutage, 0, capacity)}
+}
+
+// RecordDown starts an outage of component
+func (h *HealthHistory) RecordDown(component string, err error) {
+	if h == nil {
+		return
+	}
+	outage := HealthOutage{Component: component, DownAt: time.Now()}
+	if err != nil {
+		outage.Error = err.Error()
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if len(h.outages) < cap(h.outages) {
+		h.outages = append(h.outages, outage)
+		return
+	}
+	h.outages[h.next] = outage
+	h.next = (h.next + 1) % len(h.outages)
+}
+
+// RecordUp ends the ongoing outage of component, if any
+func (h *HealthHistory) RecordUp(component string) {
+	if h == nil {
+		return
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for i := range h.outages {
+		outage := &h.outages[i]
+		if outage.Component == component && outage.UpAt == nil {
+			now := time.Now()
+			outage.UpAt = &now
+			outage.DurationSeconds = now.Sub(outage.DownAt).Seconds()
+		}
+	}
+}
+
+// List returns the recorded outages, newest first, optionally narrowed to
+// one component
+func (h *HealthHistory) List(component string) []HealthOutage {
+	if h == nil {
+		return []HealthOutage{}
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	outages := make([]HealthOutage, 0, len(h.outages))
+	for i := len(h.outages) - 1; i >= 0; i-- {
+		outage := h.outages[(h.next+i)%len(h.outages)]
+		if component != "" && outage.Component != component {
+			continue
+		}
+		if outage.UpAt == nil {
+			outage.DurationSeconds = time.Since(outage.DownAt).Seconds()
+		}
+		outages = append(outages, outage)
+	}
+	return outages
+}
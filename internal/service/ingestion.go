@@ -0,0 +1,338 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"sub-balance-demo/internal/ingest"
+	"sub-balance-demo/internal/repository"
+	"sub-balance-demo/internal/tenant"
+)
+
+const (
+	// ingestFileLease is how long a worker holds a file version; a worker
+	// that dies mid-file delays the file this long
+	ingestFileLease = 30 * time.Minute
+	// ingestMaxAttempts bounds the passes over a file whose rows keep
+	// failing transiently (database down, internal errors)
+	ingestMaxAttempts = 5
+)
+
+// Status baris di report ingestion
+const (
+	ingestRowAccepted = "accepted"
+	ingestRowRejected = "rejected"
+	ingestRowSkipped  = "skipped" // sudah dibuat oleh versi file sebelumnya
+	ingestRowUnknown  = "unknown" // worker sebelumnya mati saat memproses baris ini
+	ingestRowRetry    = "retry"
+	ingestRowFailed   = "failed" // file tidak bisa di-parse
+)
+
+// IngestionOptions tune the worker; zero values get the defaults of
+// NewIngestionWorker
+type IngestionOptions struct {
+	// MinFileAge skips files modified more recently, so a file still being
+	// written or uploaded is not read half-way
+	MinFileAge time.Duration
+	MaxRows    int    // 0 = tanpa batas
+	Tenant     string // tenant semua transaksi; kosong = default
+	// Pain001 maps the transfers of .xml files (ISO 20022 pain.001)
+	Pain001 ingest.Pain001Mapping
+}
+
+// IngestionStats are the counters exported as metrics
+type IngestionStats struct {
+	Accepted int64 // rows that created a transaction
+	Rejected int64 // rows invalid or refused by ProcessTransaction
+	Skipped  int64 // rows already created by an earlier version of the file
+	Retried  int64 // rows that failed transiently and wait for the next pass
+	Failed   int64 // files that could not be parsed, or ran out of attempts
+}
+
+// IngestionWorker processes the settlement files partners drop in a
+// directory or S3 prefix: CSVs, and pain.001 XML for bank partners. Each row creates a transaction through
+// ProcessBatch; a row is claimed in ingest_rows by file name and reference
+// (the reference column, or the line number) before that, so a file that is
+// processed again, in the same or a corrected version, never creates a row's
+// transaction twice. After every pass over a file a report with the outcome
+// of each row is written under the source's report prefix.
+//
+// A nil *IngestionWorker is disabled.
+type IngestionWorker struct {
+	source       ingest.Source
+	repo         repository.IngestRepository
+	transactions TransactionService
+	options      IngestionOptions
+
+	// done holds the finished fingerprint per file name, so finished files
+	// are not claimed again every poll; only the worker goroutine uses it
+	done map[string]string
+
+	accepted atomic.Int64
+	rejected atomic.Int64
+	skipped  atomic.Int64
+	retried  atomic.Int64
+	failed   atomic.Int64
+}
+
+func NewIngestionWorker(source ingest.Source, repo repository.IngestRepository, transactions TransactionService, options IngestionOptions) *IngestionWorker {
+	if options.MinFileAge < 0 {
+		options.MinFileAge = 0
+	}
+	if options.Tenant == "" {
+		options.Tenant = tenant.Default
+	}
+	return &IngestionWorker{
+		source:       source,
+		repo:         repo,
+		transactions: transactions,
+		options:      options,
+		done:         make(map[string]string),
+	}
+}
+
+func (w *IngestionWorker) Stats() IngestionStats {
+	if w == nil {
+		return IngestionStats{}
+	}
+	return IngestionStats{
+		Accepted: w.accepted.Load(),
+		Rejected: w.rejected.Load(),
+		Skipped:  w.skipped.Load(),
+		Retried:  w.retried.Load(),
+		Failed:   w.failed.Load(),
+	}
+}
+
+// Start scans the source now and then on every interval until ctx is cancelled
+func (w *IngestionWorker) Start(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	log.Printf("Ingestion worker started, watching %s", w.source)
+	for {
+		if err := w.RunOnce(ctx); err != nil {
+			log.Printf("Ingestion scan of %s failed: %v", w.source, err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			log.Println("Ingestion worker stopped")
+			return
+		}
+	}
+}
+
+// RunOnce processes the files of the source that are old enough and not
+// finished yet, oldest first
+func (w *IngestionWorker) RunOnce(ctx context.Context) error {
+	files, err := w.source.List(ctx)
+	if err != nil {
+		return err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime.Before(files[j].ModTime) })
+
+	for _, file := range files {
+		if ctx.Err() != nil {
+			return nil
+		}
+		if w.done[file.Name] == file.Fingerprint || time.Since(file.ModTime) < w.options.MinFileAge {
+			continue
+		}
+		w.processFile(ctx, file)
+	}
+	return nil
+}
+
+// processFile claims a file version, processes its rows and records the
+// outcome. Bookkeeping after the rows ignores ctx cancellation, so a
+// shutdown mid-file still releases the rows that did not run.
+func (w *IngestionWorker) processFile(ctx context.Context, file ingest.File) {
+	record := &repository.IngestFile{Source: w.source.String(), Name: file.Name, Fingerprint: file.Fingerprint}
+	claimed, err := w.repo.ClaimFile(ctx, record, time.Now(), ingestFileLease)
+	if err != nil {
+		log.Printf("Failed to claim ingestion file %s: %v", file.Name, err)
+		return
+	}
+	if !claimed {
+		return // selesai, atau sedang diproses instance lain
+	}
+	record.Attempts++
+	start := time.Now()
+
+	report, retry, err := w.processRows(ctx, record, file.Name)
+	bookkeeping := context.WithoutCancel(ctx)
+	switch {
+	case err != nil:
+		record.Status = repository.IngestFailed
+		record.Error = err.Error()
+		report = []ingest.ReportRow{{Status: ingestRowFailed, Error: err.Error()}}
+	case retry && (record.Attempts < ingestMaxAttempts || ctx.Err() != nil):
+		// Shutdown di tengah file tidak menghabiskan attempt
+		record.Status = repository.IngestRetry
+	case retry:
+		record.Status = repository.IngestFailed
+		record.Error = fmt.Sprintf("rows still failing after %d attempts", record.Attempts)
+	default:
+		record.Status = repository.IngestDone
+		record.Error = ""
+	}
+	if record.Status == repository.IngestFailed {
+		w.failed.Add(1)
+	}
+	record.ClaimedUntil = time.Now() // retry: diklaim lagi di poll berikutnya
+
+	if err := w.source.WriteReport(bookkeeping, file.Name, ingest.Report(report)); err != nil {
+		log.Printf("Failed to write ingestion report of %s: %v", file.Name, err)
+	}
+	if err := w.repo.FinishFile(bookkeeping, record); err != nil {
+		log.Printf("Failed to record ingestion of %s: %v", file.Name, err)
+		return
+	}
+	if record.Status != repository.IngestRetry {
+		w.done[file.Name] = file.Fingerprint
+	}
+	log.Printf("Ingested %s (%s) in %s: rows=%d, accepted=%d, rejected=%d, skipped=%d, status=%s",
+		file.Name, file.Fingerprint, time.Since(start), record.Rows, record.Accepted, record.Rejected, record.Skipped, record.Status)
+}
+
+// processRows parses the file and processes its rows. It returns the report
+// rows in file order and whether some rows failed transiently, the whole
+// file when it could not be opened; err is set when the file cannot be
+// parsed.
+func (w *IngestionWorker) processRows(ctx context.Context, record *repository.IngestFile, name string) ([]ingest.ReportRow, bool, error) {
+	reader, err := w.source.Open(ctx, name)
+	if err != nil {
+		return []ingest.ReportRow{{Status: ingestRowRetry, Error: "Failed to open file: " + err.Error()}}, true, nil
+	}
+	var rows []ingest.Row
+	var invalid []ingest.RowError
+	if ingest.IsPain001(name) {
+		rows, invalid, err = ingest.ReadPain001(reader, w.options.MaxRows, w.options.Pain001)
+	} else {
+		rows, invalid, err = ingest.ReadCSV(reader, w.options.MaxRows)
+	}
+	reader.Close()
+	if err != nil {
+		return nil, false, err
+	}
+
+	report := make([]ingest.ReportRow, 0, len(rows)+len(invalid))
+	for _, row := range invalid {
+		report = append(report, ingest.ReportRow{Row: row.Row, Status: ingestRowRejected, Error: row.Error})
+	}
+
+	// Validasi dan klaim per reference
+	var claims []repository.IngestRow
+	var reqs []*repository.TransactionRequest
+	var claimedRows []ingest.Row
+	lines := make(map[string]int) // reference -> line pertama
+	for _, row := range rows {
+		req, reason := row.Request()
+		reference := row.Reference
+		if reference == "" {
+			reference = "line:" + strconv.Itoa(row.Line)
+		}
+		if line, ok := lines[reference]; ok && reason == "" {
+			reason = fmt.Sprintf("Duplicate reference, already used on line %d", line)
+		}
+		if reason != "" {
+			report = append(report, ingest.ReportRow{Row: row, Status: ingestRowRejected, Error: reason})
+			continue
+		}
+		lines[reference] = row.Line
+		claims = append(claims, repository.IngestRow{TenantID: w.options.Tenant, Source: record.Source, FileName: name, Reference: reference, Line: row.Line})
+		reqs = append(reqs, req)
+		claimedRows = append(claimedRows, row)
+	}
+
+	existing := map[string]repository.IngestRow{}
+	if len(claims) > 0 {
+		existing, err = w.repo.ClaimRows(ctx, claims)
+		if err != nil {
+			return []ingest.ReportRow{{Status: ingestRowRetry, Error: "Failed to claim rows: " + err.Error()}}, true, nil
+		}
+	}
+
+	// Baris yang sudah diklaim sebelumnya tidak diproses lagi
+	var pending []int
+	var pendingReqs []*repository.TransactionRequest
+	for i, claim := range claims {
+		earlier, ok := existing[claim.Reference]
+		switch {
+		case !ok:
+			pending = append(pending, i)
+			pendingReqs = append(pendingReqs, reqs[i])
+		case earlier.Status == repository.IngestAccepted:
+			report = append(report, ingest.ReportRow{Row: claimedRows[i], Status: ingestRowSkipped, TransactionID: earlier.TransactionID,
+				Error: fmt.Sprintf("Already created from line %d", earlier.Line)})
+		default:
+			report = append(report, ingest.ReportRow{Row: claimedRows[i], Status: ingestRowUnknown,
+				Error: "An earlier run stopped while processing this row; check the account before sending it again"})
+		}
+	}
+
+	batchCtx := tenant.WithID(ctx, w.options.Tenant)
+	results := w.transactions.ProcessBatch(batchCtx, pendingReqs)
+	var accepted, released []repository.IngestRow
+	retry := false
+	for n, result := range results {
+		i := pending[n]
+		claim := claims[i]
+		entry := ingest.ReportRow{Row: claimedRows[i]}
+		switch {
+		case result.Err != nil:
+			retry = true
+			entry.Status = ingestRowRetry
+			entry.Error = result.Err.Error()
+			if errors.Is(result.Err, ErrDatabaseUnavailable) {
+				entry.Error = "Database unavailable"
+			}
+			released = append(released, claim)
+		case !result.Response.Success:
+			entry.Status = ingestRowRejected
+			entry.Error = result.Response.Message
+			released = append(released, claim)
+		default:
+			entry.Status = ingestRowAccepted
+			entry.TransactionID = result.Response.TransactionID
+			claim.TransactionID = result.Response.TransactionID
+			accepted = append(accepted, claim)
+		}
+		report = append(report, entry)
+	}
+	if err := w.repo.RecordRows(context.WithoutCancel(ctx), accepted, released); err != nil {
+		// Klaim tertinggal processing: baris dilaporkan unknown, tidak pernah dobel
+		log.Printf("Failed to record ingested rows of %s: %v", name, err)
+	}
+
+	// Hitungan dari pass ini saja, bukan akumulasi attempt sebelumnya
+	record.Rows = len(report)
+	record.Accepted, record.Rejected, record.Skipped = 0, 0, 0
+	var retried int
+	for _, entry := range report {
+		switch entry.Status {
+		case ingestRowAccepted:
+			record.Accepted++
+		case ingestRowRejected:
+			record.Rejected++
+		case ingestRowSkipped:
+			record.Skipped++
+		case ingestRowRetry:
+			retried++
+		}
+	}
+	w.accepted.Add(int64(record.Accepted))
+	w.rejected.Add(int64(record.Rejected))
+	w.skipped.Add(int64(record.Skipped))
+	w.retried.Add(int64(retried))
+
+	sort.SliceStable(report, func(i, j int) bool { return report[i].Line < report[j].Line })
+	return report, retry, nil
+}
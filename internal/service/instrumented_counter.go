@@ -0,0 +1,122 @@
+package service
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// CounterStats are the RedisCounter operation metrics exported on /metrics
+type CounterStats struct {
+	Operations          map[string]RedisCommandStats `json:"operations"`
+	OverspendRejections int64                        `json:"overspend_rejections"`
+}
+
+// InstrumentedCounter wraps a RedisCounter and records duration and
+// success/failure of every operation, plus overspend rejections, so Redis
+// slowness shows up before the circuit breaker trips
+type InstrumentedCounter struct {
+	next       RedisCounter
+	operations *operationMetrics
+	overspend  int64
+}
+
+func NewInstrumentedCounter(next RedisCounter) *InstrumentedCounter {
+	return &InstrumentedCounter{
+		next:       next,
+		operations: newOperationMetrics(),
+	}
+}
+
+func (c *InstrumentedCounter) observe(name string, start time.Time, err error) {
+	latency := time.Since(start)
+	if latency == 0 {
+		latency = time.Nanosecond // record treats 0 as error-only
+	}
+	c.operations.record(name, latency, err)
+}
+
+// Stats returns a snapshot of the counter metrics
+func (c *InstrumentedCounter) Stats() CounterStats {
+	return CounterStats{
+		Operations:          c.operations.snapshot(),
+		OverspendRejections: atomic.LoadInt64(&c.overspend),
+	}
+}
+
+func (c *InstrumentedCounter) GetPending(ctx context.Context, accountID string) (PendingTotals, error) {
+	start := time.Now()
+	totals, err := c.next.GetPending(ctx, accountID)
+	c.observe("get_pending", start, err)
+	return totals, err
+}
+
+func (c *InstrumentedCounter) GetPendingBulk(ctx context.Context, accountIDs []string) (map[string]PendingTotals, error) {
+	start := time.Now()
+	totals, err := c.next.GetPendingBulk(ctx, accountIDs)
+	c.observe("get_pending_bulk", start, err)
+	return totals, err
+}
+
+func (c *InstrumentedCounter) GetPendingEntries(ctx context.Context, accountID string) (map[string]PendingEntry, error) {
+	start := time.Now()
+	entries, err := c.next.GetPendingEntries(ctx, accountID)
+	c.observe("get_pending_entries", start, err)
+	return entries, err
+}
+
+func (c *InstrumentedCounter) AddPending(ctx context.Context, accountID string, transactionID string, txType string, amount decimal.Decimal, maxBalance decimal.Decimal) (bool, PendingTotals, error) {
+	start := time.Now()
+	success, totals, err := c.next.AddPending(ctx, accountID, transactionID, txType, amount, maxBalance)
+	c.observe("add_pending", start, err)
+	if err == nil && !success {
+		atomic.AddInt64(&c.overspend, 1)
+	}
+	return success, totals, err
+}
+
+func (c *InstrumentedCounter) RemovePending(ctx context.Context, accountID string, transactionIDs ...string) error {
+	start := time.Now()
+	err := c.next.RemovePending(ctx, accountID, transactionIDs...)
+	c.observe("remove_pending", start, err)
+	return err
+}
+
+func (c *InstrumentedCounter) GetVersions(ctx context.Context, accountIDs []string) (map[string]int64, error) {
+	start := time.Now()
+	versions, err := c.next.GetVersions(ctx, accountIDs)
+	c.observe("get_versions", start, err)
+	return versions, err
+}
+
+func (c *InstrumentedCounter) SetPending(ctx context.Context, accountID string, expectedVersion int64, entries map[string]PendingEntry) error {
+	start := time.Now()
+	err := c.next.SetPending(ctx, accountID, expectedVersion, entries)
+	c.observe("set_pending", start, err)
+	return err
+}
+
+func (c *InstrumentedCounter) SetPendingIfEquals(ctx context.Context, accountID string, expected PendingTotals, entries map[string]PendingEntry) (bool, error) {
+	start := time.Now()
+	swapped, err := c.next.SetPendingIfEquals(ctx, accountID, expected, entries)
+	c.observe("set_pending_if_equals", start, err)
+	return swapped, err
+}
+
+func (c *InstrumentedCounter) ClearPending(ctx context.Context, accountID string) error {
+	start := time.Now()
+	err := c.next.ClearPending(ctx, accountID)
+	c.observe("clear_pending", start, err)
+	return err
+}
+
+func (c *InstrumentedCounter) ScanPending(ctx context.Context, cursor uint64, count int64) ([]PendingCounter, uint64, error) {
+	start := time.Now()
+	counters, next, err := c.next.ScanPending(ctx, cursor, count)
+	c.observe("scan_pending", start, err)
+	return counters, next, err
+}
+
+var _ RedisCounter = (*InstrumentedCounter)(nil)
@@ -0,0 +1,281 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"sub-balance-demo/internal/logmask"
+	"sub-balance-demo/internal/repository"
+	"sub-balance-demo/internal/tenant"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/segmentio/kafka-go"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	// kafkaIngestSource is the ingest_rows source of Kafka messages; the
+	// file name is the topic and the reference the transaction_id
+	kafkaIngestSource = "kafka"
+	// kafkaIngestMaxAttempts bounds the retries of a message that keeps
+	// failing with an internal error; a database outage is retried for as
+	// long as it lasts
+	kafkaIngestMaxAttempts = 5
+	// kafkaMaxTransactionID matches the reference column of ingest_rows
+	kafkaMaxTransactionID = 100
+)
+
+// KafkaMessageReader is the part of *kafka.Reader the ingestion uses. The
+// reader belongs to a consumer group with synchronous commits.
+type KafkaMessageReader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// KafkaMessageWriter is the part of *kafka.Writer the ingestion uses for its
+// dead letter topic
+type KafkaMessageWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// KafkaTransaction is the body of a message on the ingestion topic: a
+// TransactionRequest plus the producer's transaction_id, which makes a
+// redelivered message create its transaction only once
+type KafkaTransaction struct {
+	TransactionID string `json:"transaction_id" validate:"required"`
+	repository.TransactionRequest
+}
+
+// KafkaIngestionStats are the counters exported as metrics
+type KafkaIngestionStats struct {
+	Accepted     int64 // messages that created a transaction
+	Duplicates   int64 // transaction_id already created by an earlier delivery
+	DeadLettered int64 // invalid or rejected messages sent to the dead letter topic
+	Retried      int64 // transient failures, processed again
+}
+
+// KafkaIngestion feeds the TransactionRequest messages of a Kafka topic
+// through ProcessTransaction. Before processing, the message's transaction_id
+// is claimed in ingest_rows like a row of a partner file, so a message
+// redelivered after a crash or a rebalance is skipped. Invalid messages and
+// rejected transactions go to the dead letter topic with the reason in the
+// "error" header. The offset is committed only after that, so a message is
+// never dropped: transient failures are retried in place, which keeps the
+// partition in order.
+//
+// A nil *KafkaIngestion is disabled.
+type KafkaIngestion struct {
+	reader       KafkaMessageReader
+	dlq          KafkaMessageWriter
+	repo         repository.IngestRepository
+	transactions TransactionService
+	topic        string
+	tenant       string
+	retryDelay   time.Duration
+	maxDelay     time.Duration
+	validate     *validator.Validate
+
+	accepted     atomic.Int64
+	duplicates   atomic.Int64
+	deadLettered atomic.Int64
+	retried      atomic.Int64
+}
+
+// NewKafkaIngestion creates the consumer of topic; a transient failure is
+// retried after retryDelay, doubling up to maxDelay. An empty tenantID is the
+// default tenant.
+func NewKafkaIngestion(reader KafkaMessageReader, dlq KafkaMessageWriter, repo repository.IngestRepository, transactions TransactionService, topic string, tenantID string, retryDelay time.Duration, maxDelay time.Duration) *KafkaIngestion {
+	if tenantID == "" {
+		tenantID = tenant.Default
+	}
+	return &KafkaIngestion{
+		reader:       reader,
+		dlq:          dlq,
+		repo:         repo,
+		transactions: transactions,
+		topic:        topic,
+		tenant:       tenantID,
+		retryDelay:   retryDelay,
+		maxDelay:     maxDelay,
+		validate:     validator.New(),
+	}
+}
+
+func (k *KafkaIngestion) Stats() KafkaIngestionStats {
+	if k == nil {
+		return KafkaIngestionStats{}
+	}
+	return KafkaIngestionStats{
+		Accepted:     k.accepted.Load(),
+		Duplicates:   k.duplicates.Load(),
+		DeadLettered: k.deadLettered.Load(),
+		Retried:      k.retried.Load(),
+	}
+}
+
+// Start consumes the topic until ctx is cancelled, then closes the reader
+// and the dead letter writer
+func (k *KafkaIngestion) Start(ctx context.Context) {
+	defer func() {
+		if err := k.reader.Close(); err != nil {
+			log.Printf("Failed to close Kafka reader: %v", err)
+		}
+		if err := k.dlq.Close(); err != nil {
+			log.Printf("Failed to close Kafka dead letter writer: %v", err)
+		}
+	}()
+
+	log.Printf("Kafka ingestion started, consuming %s", k.topic)
+	for {
+		message, err := k.reader.FetchMessage(ctx)
+		if ctx.Err() != nil {
+			log.Println("Kafka ingestion stopped")
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to fetch from Kafka topic %s, retrying in %s: %v", k.topic, consumerRetryDelay, err)
+			select {
+			case <-time.After(consumerRetryDelay):
+			case <-ctx.Done():
+				log.Println("Kafka ingestion stopped")
+				return
+			}
+			continue
+		}
+		if err := k.handle(ctx, message); err != nil {
+			// Hanya saat shutdown: offset tidak di-commit, message dibaca lagi
+			log.Println("Kafka ingestion stopped")
+			return
+		}
+	}
+}
+
+// handle processes message until it is done, retrying transient failures,
+// and commits its offset. It returns an error only when ctx ends first.
+func (k *KafkaIngestion) handle(ctx context.Context, message kafka.Message) error {
+	delay := k.retryDelay
+	for attempt := 1; ; attempt++ {
+		err := k.process(ctx, message, attempt)
+		if err == nil {
+			err = k.reader.CommitMessages(ctx, message)
+			if err == nil {
+				return nil
+			}
+			// Commit gagal: process berikutnya menemukan klaim accepted
+			err = fmt.Errorf("failed to commit offset %d: %w", message.Offset, err)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		k.retried.Add(1)
+		log.Printf("Failed to ingest Kafka message %s/%d@%d, retrying in %s: %v", k.topic, message.Partition, message.Offset, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		delay = min(delay*2, k.maxDelay)
+	}
+}
+
+// process creates the transaction of message, or dead-letters it. A nil
+// error means the message is done and its offset can be committed.
+func (k *KafkaIngestion) process(ctx context.Context, message kafka.Message, attempt int) error {
+	txn, reason := k.decode(message)
+	if reason != "" {
+		return k.deadLetter(ctx, message, reason)
+	}
+
+	claims := []repository.IngestRow{{TenantID: k.tenant, Source: kafkaIngestSource, FileName: k.topic, Reference: txn.TransactionID, Line: int(message.Offset)}}
+	existing, err := k.repo.ClaimRows(ctx, claims)
+	claim := claims[0]
+	if err != nil {
+		return fmt.Errorf("failed to claim transaction_id: %w", err)
+	}
+	if earlier, ok := existing[claim.Reference]; ok {
+		if earlier.Status == repository.IngestAccepted {
+			k.duplicates.Add(1)
+			return nil
+		}
+		// Delivery sebelumnya berhenti di tengah ProcessTransaction
+		return k.deadLetter(ctx, message, "An earlier delivery stopped while processing this transaction; check the account before sending it again")
+	}
+
+	response, err := k.transactions.ProcessTransaction(tenant.WithID(ctx, k.tenant), &txn.TransactionRequest)
+	bookkeeping := context.WithoutCancel(ctx)
+	switch {
+	case errors.Is(err, ErrInvalidMetadata):
+		k.release(bookkeeping, claim)
+		return k.deadLetter(ctx, message, err.Error())
+	case err != nil && !errors.Is(err, ErrDatabaseUnavailable) && attempt >= kafkaIngestMaxAttempts:
+		k.release(bookkeeping, claim)
+		return k.deadLetter(ctx, message, fmt.Sprintf("Still failing after %d attempts: %v", attempt, err))
+	case err != nil:
+		k.release(bookkeeping, claim)
+		return err
+	case !response.Success:
+		k.release(bookkeeping, claim)
+		return k.deadLetter(ctx, message, response.Message)
+	}
+
+	claim.TransactionID = response.TransactionID
+	if err := k.repo.RecordRows(bookkeeping, []repository.IngestRow{claim}, nil); err != nil {
+		// Klaim tertinggal processing: delivery ulang masuk dead letter, tidak pernah dobel
+		log.Printf("Failed to record Kafka transaction %s of account %s: %v", txn.TransactionID, logmask.Account(txn.AccountID), err)
+	}
+	k.accepted.Add(1)
+	return nil
+}
+
+// decode parses and validates the body of message, returning the reason it
+// is invalid
+func (k *KafkaIngestion) decode(message kafka.Message) (*KafkaTransaction, string) {
+	var txn KafkaTransaction
+	if err := json.Unmarshal(message.Value, &txn); err != nil {
+		return nil, "Invalid JSON: " + err.Error()
+	}
+	if err := k.validate.Struct(&txn); err != nil {
+		return nil, "Validation failed: " + err.Error()
+	}
+	if len(txn.TransactionID) > kafkaMaxTransactionID {
+		return nil, fmt.Sprintf("transaction_id is longer than %d characters", kafkaMaxTransactionID)
+	}
+	if txn.Amount.LessThanOrEqual(decimal.Zero) {
+		return nil, "Amount must be greater than zero"
+	}
+	return &txn, ""
+}
+
+// release deletes the claim of a transaction that was not created, so a
+// corrected message with the same transaction_id can be sent again
+func (k *KafkaIngestion) release(ctx context.Context, claim repository.IngestRow) {
+	if err := k.repo.RecordRows(ctx, nil, []repository.IngestRow{claim}); err != nil {
+		log.Printf("Failed to release Kafka transaction %s: %v", claim.Reference, err)
+	}
+}
+
+// deadLetter copies message to the dead letter topic with the reason and
+// its origin in the headers
+func (k *KafkaIngestion) deadLetter(ctx context.Context, message kafka.Message, reason string) error {
+	headers := append([]kafka.Header{}, message.Headers...)
+	headers = append(headers,
+		kafka.Header{Key: "error", Value: []byte(reason)},
+		kafka.Header{Key: "source_topic", Value: []byte(k.topic)},
+		kafka.Header{Key: "source_partition", Value: []byte(strconv.Itoa(message.Partition))},
+		kafka.Header{Key: "source_offset", Value: []byte(strconv.FormatInt(message.Offset, 10))},
+	)
+	err := k.dlq.WriteMessages(ctx, kafka.Message{Key: message.Key, Value: message.Value, Headers: headers})
+	if err != nil {
+		return fmt.Errorf("failed to write to the dead letter topic: %w", err)
+	}
+	k.deadLettered.Add(1)
+	return nil
+}
@@ -0,0 +1,307 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"sub-balance-demo/internal/repository"
+	"sub-balance-demo/internal/tenant"
+
+	"github.com/segmentio/kafka-go"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type fakeKafkaReader struct {
+	messages  []kafka.Message
+	committed []int64
+	commitErr error
+	cancel    context.CancelFunc // dipanggil setelah message terakhir
+}
+
+func (r *fakeKafkaReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	if len(r.messages) == 0 {
+		r.cancel()
+		<-ctx.Done()
+		return kafka.Message{}, ctx.Err()
+	}
+	message := r.messages[0]
+	r.messages = r.messages[1:]
+	return message, nil
+}
+
+func (r *fakeKafkaReader) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	if r.commitErr != nil {
+		err := r.commitErr
+		r.commitErr = nil
+		return err
+	}
+	for _, message := range msgs {
+		r.committed = append(r.committed, message.Offset)
+	}
+	return nil
+}
+
+func (r *fakeKafkaReader) Close() error { return nil }
+
+type fakeDLQ struct {
+	messages []kafka.Message
+	err      error
+}
+
+func (w *fakeDLQ) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	if w.err != nil {
+		err := w.err
+		w.err = nil
+		return err
+	}
+	w.messages = append(w.messages, msgs...)
+	return nil
+}
+
+func (w *fakeDLQ) Close() error { return nil }
+
+// fakeTransactions accepts every request, failing the first calls with errs
+type fakeTransactions struct {
+	TransactionService
+	calls   []repository.TransactionRequest
+	tenants []string
+	errs    []error
+	reject  string
+}
+
+func (f *fakeTransactions) ProcessTransaction(ctx context.Context, req *repository.TransactionRequest) (*repository.TransactionResponse, error) {
+	f.calls = append(f.calls, *req)
+	id, _ := tenant.FromContext(ctx)
+	f.tenants = append(f.tenants, id)
+	if len(f.errs) > 0 {
+		err := f.errs[0]
+		f.errs = f.errs[1:]
+		return nil, err
+	}
+	if f.reject != "" {
+		return &repository.TransactionResponse{Success: false, Message: f.reject}, nil
+	}
+	return &repository.TransactionResponse{Success: true, TransactionID: fmt.Sprintf("tx-%d", len(f.calls)), AccountID: req.AccountID}, nil
+}
+
+func newIngestRepo(t *testing.T) (repository.IngestRepository, *gorm.DB) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := db.AutoMigrate(&repository.IngestRow{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return repository.NewIngestRepository(db), db
+}
+
+func kafkaMessages(values ...string) []kafka.Message {
+	messages := make([]kafka.Message, len(values))
+	for i, value := range values {
+		messages[i] = kafka.Message{Offset: int64(i), Key: []byte("ACC001"), Value: []byte(value)}
+	}
+	return messages
+}
+
+func runKafkaIngestion(t *testing.T, repo repository.IngestRepository, reader *fakeKafkaReader, dlq *fakeDLQ, transactions *fakeTransactions) *KafkaIngestion {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	reader.cancel = cancel
+	ingestion := NewKafkaIngestion(reader, dlq, repo, transactions, "subbalance.transactions", "acme", time.Millisecond, time.Millisecond)
+	ingestion.Start(ctx)
+	return ingestion
+}
+
+func TestKafkaIngestion(t *testing.T) {
+	valid := `{"transaction_id":"t1","account_id":"ACC001","amount":"10","type":"credit"}`
+	tests := []struct {
+		name         string
+		messages     []string
+		errs         []error
+		reject       string
+		commitErr    error
+		dlqErr       error
+		wantCalls    int
+		wantDLQ      []string // header error per dead letter
+		wantCommits  int
+		wantAccepted int64
+		wantDupes    int64
+	}{
+		{
+			name:         "accepted",
+			messages:     []string{valid},
+			wantCalls:    1,
+			wantCommits:  1,
+			wantAccepted: 1,
+		},
+		{
+			name:         "redelivered transaction_id",
+			messages:     []string{valid, valid},
+			wantCalls:    1,
+			wantCommits:  2,
+			wantAccepted: 1,
+			wantDupes:    1,
+		},
+		{
+			name:        "invalid JSON",
+			messages:    []string{`{"transaction_id":`},
+			wantDLQ:     []string{"Invalid JSON: unexpected end of JSON input"},
+			wantCommits: 1,
+		},
+		{
+			name:        "missing transaction_id",
+			messages:    []string{`{"account_id":"ACC001","amount":"10","type":"credit"}`},
+			wantDLQ:     []string{"Validation failed: Key: 'KafkaTransaction.TransactionID' Error:Field validation for 'TransactionID' failed on the 'required' tag"},
+			wantCommits: 1,
+		},
+		{
+			name:        "non-positive amount",
+			messages:    []string{`{"transaction_id":"t1","account_id":"ACC001","amount":"-1","type":"credit"}`},
+			wantDLQ:     []string{"Amount must be greater than zero"},
+			wantCommits: 1,
+		},
+		{
+			name:        "rejected",
+			messages:    []string{valid},
+			reject:      "Insufficient balance",
+			wantCalls:   1,
+			wantDLQ:     []string{"Insufficient balance"},
+			wantCommits: 1,
+		},
+		{
+			name:         "database unavailable is retried",
+			messages:     []string{valid},
+			errs:         []error{ErrDatabaseUnavailable, ErrDatabaseUnavailable, ErrDatabaseUnavailable, ErrDatabaseUnavailable, ErrDatabaseUnavailable, ErrDatabaseUnavailable},
+			wantCalls:    7,
+			wantCommits:  1,
+			wantAccepted: 1,
+		},
+		{
+			name:        "internal error dead-lettered after max attempts",
+			messages:    []string{valid},
+			errs:        []error{errors.New("boom"), errors.New("boom"), errors.New("boom"), errors.New("boom"), errors.New("boom")},
+			wantCalls:   5,
+			wantDLQ:     []string{"Still failing after 5 attempts: boom"},
+			wantCommits: 1,
+		},
+		{
+			name:        "invalid metadata",
+			messages:    []string{valid},
+			errs:        []error{ErrInvalidMetadata},
+			wantCalls:   1,
+			wantDLQ:     []string{ErrInvalidMetadata.Error()},
+			wantCommits: 1,
+		},
+		{
+			name:         "failed commit does not process twice",
+			messages:     []string{valid},
+			commitErr:    errors.New("rebalance in progress"),
+			wantCalls:    1,
+			wantCommits:  1,
+			wantAccepted: 1,
+			wantDupes:    1,
+		},
+		{
+			name:        "failed dead letter write is retried before commit",
+			messages:    []string{`not json`},
+			dlqErr:      errors.New("leader not available"),
+			wantDLQ:     []string{"Invalid JSON: invalid character 'o' in literal null (expecting 'u')"},
+			wantCommits: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, _ := newIngestRepo(t)
+			reader := &fakeKafkaReader{messages: kafkaMessages(tt.messages...), commitErr: tt.commitErr}
+			dlq := &fakeDLQ{err: tt.dlqErr}
+			transactions := &fakeTransactions{errs: tt.errs, reject: tt.reject}
+			ingestion := runKafkaIngestion(t, repo, reader, dlq, transactions)
+
+			if len(transactions.calls) != tt.wantCalls {
+				t.Fatalf("ProcessTransaction called %d times, want %d", len(transactions.calls), tt.wantCalls)
+			}
+			if len(reader.committed) != tt.wantCommits {
+				t.Fatalf("committed offsets %v, want %d", reader.committed, tt.wantCommits)
+			}
+			if len(dlq.messages) != len(tt.wantDLQ) {
+				t.Fatalf("%d dead letters, want %d", len(dlq.messages), len(tt.wantDLQ))
+			}
+			for i, message := range dlq.messages {
+				if got := kafkaHeader(message, "error"); got != tt.wantDLQ[i] {
+					t.Fatalf("dead letter error = %q, want %q", got, tt.wantDLQ[i])
+				}
+				if kafkaHeader(message, "source_topic") != "subbalance.transactions" || string(message.Value) != tt.messages[i] {
+					t.Fatalf("dead letter = %+v", message)
+				}
+			}
+			stats := ingestion.Stats()
+			if stats.Accepted != tt.wantAccepted || stats.Duplicates != tt.wantDupes || stats.DeadLettered != int64(len(tt.wantDLQ)) {
+				t.Fatalf("stats = %+v", stats)
+			}
+			for _, id := range transactions.tenants {
+				if id != "acme" {
+					t.Fatalf("tenant = %q, want acme", id)
+				}
+			}
+		})
+	}
+}
+
+func TestKafkaIngestionReleasesRejectedTransactionID(t *testing.T) {
+	repo, db := newIngestRepo(t)
+	valid := `{"transaction_id":"t1","account_id":"ACC001","amount":"10","type":"debit"}`
+
+	// Ditolak: transaction_id boleh dikirim ulang setelah diperbaiki
+	runKafkaIngestion(t, repo, &fakeKafkaReader{messages: kafkaMessages(valid)}, &fakeDLQ{}, &fakeTransactions{reject: "Insufficient balance"})
+	var count int64
+	db.Model(&repository.IngestRow{}).Count(&count)
+	if count != 0 {
+		t.Fatalf("%d claims left after a rejection, want 0", count)
+	}
+
+	transactions := &fakeTransactions{}
+	runKafkaIngestion(t, repo, &fakeKafkaReader{messages: kafkaMessages(valid)}, &fakeDLQ{}, transactions)
+	var row repository.IngestRow
+	if err := db.First(&row).Error; err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	if len(transactions.calls) != 1 || row.Status != repository.IngestAccepted || row.TransactionID != "tx-1" || row.Reference != "t1" {
+		t.Fatalf("calls = %d, claim = %+v", len(transactions.calls), row)
+	}
+}
+
+func TestKafkaIngestionDeadLettersInterruptedTransaction(t *testing.T) {
+	repo, _ := newIngestRepo(t)
+	// Klaim processing dari instance yang mati di tengah ProcessTransaction
+	claims := []repository.IngestRow{{TenantID: "acme", Source: kafkaIngestSource, FileName: "subbalance.transactions", Reference: "t1"}}
+	if _, err := repo.ClaimRows(context.Background(), claims); err != nil {
+		t.Fatalf("ClaimRows: %v", err)
+	}
+
+	dlq := &fakeDLQ{}
+	transactions := &fakeTransactions{}
+	reader := &fakeKafkaReader{messages: kafkaMessages(`{"transaction_id":"t1","account_id":"ACC001","amount":"10","type":"credit"}`)}
+	runKafkaIngestion(t, repo, reader, dlq, transactions)
+	if len(transactions.calls) != 0 || len(dlq.messages) != 1 || len(reader.committed) != 1 {
+		t.Fatalf("calls = %d, dead letters = %d, commits = %v", len(transactions.calls), len(dlq.messages), reader.committed)
+	}
+}
+
+func kafkaHeader(message kafka.Message, key string) string {
+	for _, h := range message.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,251 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"sub-balance-demo/internal/config"
+	"sub-balance-demo/internal/repository"
+	"sub-balance-demo/internal/tenant"
+
+	"github.com/shopspring/decimal"
+)
+
+// LocalCounter is a process-local RedisCounter used to absorb short Redis
+// outages without taking a row lock per request.
+//
+// WARNING: it is single-instance only. Every instance validates against its
+// own map, so with more than one instance behind a load balancer the
+// overspend protection is per instance, not per account. Pending rows are
+// still written to the database and Redis is rebuilt from them once it is
+// back, after which the local counter is reset.
+type LocalCounter struct {
+	accounts        map[repository.AccountKey]map[string]PendingEntry
+	versions        map[repository.AccountKey]int64
+	creditSpendable bool
+	mutex           sync.Mutex
+}
+
+func NewLocalCounter(config *config.Config) *LocalCounter {
+	return &LocalCounter{
+		accounts:        make(map[repository.AccountKey]map[string]PendingEntry),
+		versions:        make(map[repository.AccountKey]int64),
+		creditSpendable: config.PendingCreditSpendable,
+	}
+}
+
+func (l *LocalCounter) totals(key repository.AccountKey) PendingTotals {
+	totals := PendingTotals{Debit: decimal.Zero, Credit: decimal.Zero}
+	for _, entry := range l.accounts[key] {
+		if entry.Type == "credit" {
+			totals.Credit = totals.Credit.Add(entry.Amount)
+		} else {
+			totals.Debit = totals.Debit.Add(entry.Amount)
+		}
+	}
+	return totals
+}
+
+func (l *LocalCounter) GetPending(ctx context.Context, accountID string) (PendingTotals, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	key := accountKey(ctx, accountID)
+	return l.totals(key), nil
+}
+
+func (l *LocalCounter) GetPendingBulk(ctx context.Context, accountIDs []string) (map[string]PendingTotals, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	result := make(map[string]PendingTotals, len(accountIDs))
+	for _, accountID := range accountIDs {
+		result[accountID] = l.totals(accountKey(ctx, accountID))
+	}
+	return result, nil
+}
+
+func (l *LocalCounter) GetPendingEntries(ctx context.Context, accountID string) (map[string]PendingEntry, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	key := accountKey(ctx, accountID)
+
+	entries := make(map[string]PendingEntry, len(l.accounts[key]))
+	for id, entry := range l.accounts[key] {
+		entries[id] = entry
+	}
+	return entries, nil
+}
+
+// AddPending applies the same rules as the Redis script: duplicates are
+// idempotent and only debits are checked against the balance
+func (l *LocalCounter) AddPending(ctx context.Context, accountID string, transactionID string, txType string, amount decimal.Decimal, maxBalance decimal.Decimal) (bool, PendingTotals, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	key := accountKey(ctx, accountID)
+
+	totals := l.totals(key)
+	if _, exists := l.accounts[key][transactionID]; exists {
+		return true, totals, nil
+	}
+
+	if txType == "debit" {
+		spendable := maxBalance
+		if l.creditSpendable {
+			spendable = spendable.Add(totals.Credit)
+		}
+		newDebit := totals.Debit.Add(amount)
+		if newDebit.GreaterThan(spendable) || newDebit.IsNegative() {
+			return false, totals, nil
+		}
+	}
+
+	if l.accounts[key] == nil {
+		l.accounts[key] = make(map[string]PendingEntry)
+	}
+	l.accounts[key][transactionID] = PendingEntry{Type: txType, Amount: amount}
+	l.versions[key]++
+	return true, l.totals(key), nil
+}
+
+func (l *LocalCounter) RemovePending(ctx context.Context, accountID string, transactionIDs ...string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	key := accountKey(ctx, accountID)
+
+	// Map kosong tetap disimpan supaya account tidak di-seed ulang dari database
+	for _, id := range transactionIDs {
+		delete(l.accounts[key], id)
+	}
+	l.versions[key]++
+	return nil
+}
+
+func (l *LocalCounter) GetVersions(ctx context.Context, accountIDs []string) (map[string]int64, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	versions := make(map[string]int64, len(accountIDs))
+	for _, accountID := range accountIDs {
+		versions[accountID] = l.versions[accountKey(ctx, accountID)]
+	}
+	return versions, nil
+}
+
+func (l *LocalCounter) SetPending(ctx context.Context, accountID string, expectedVersion int64, entries map[string]PendingEntry) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	key := accountKey(ctx, accountID)
+
+	if expectedVersion != AnyVersion && l.versions[key] != expectedVersion {
+		return ErrStaleCounter
+	}
+	l.versions[key]++
+
+	if len(entries) == 0 {
+		delete(l.accounts, key)
+		return nil
+	}
+	copied := make(map[string]PendingEntry, len(entries))
+	for id, entry := range entries {
+		copied[id] = entry
+	}
+	l.accounts[key] = copied
+	return nil
+}
+
+func (l *LocalCounter) SetPendingIfEquals(ctx context.Context, accountID string, expected PendingTotals, entries map[string]PendingEntry) (bool, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	key := accountKey(ctx, accountID)
+
+	totals := l.totals(key)
+	if !totals.Debit.Equal(expected.Debit) || !totals.Credit.Equal(expected.Credit) {
+		return false, nil
+	}
+	l.versions[key]++
+
+	if len(entries) == 0 {
+		delete(l.accounts, key)
+		return true, nil
+	}
+	copied := make(map[string]PendingEntry, len(entries))
+	for id, entry := range entries {
+		copied[id] = entry
+	}
+	l.accounts[key] = copied
+	return true, nil
+}
+
+func (l *LocalCounter) ClearPending(ctx context.Context, accountID string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	key := accountKey(ctx, accountID)
+
+	delete(l.accounts, key)
+	return nil
+}
+
+// ScanPending returns every local counter of the tenant of ctx in a single page
+func (l *LocalCounter) ScanPending(ctx context.Context, cursor uint64, count int64) ([]PendingCounter, uint64, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	tenantID := tenant.ID(ctx)
+	counters := make([]PendingCounter, 0, len(l.accounts))
+	for key, entries := range l.accounts {
+		if key.TenantID != tenantID {
+			continue
+		}
+		counters = append(counters, PendingCounter{
+			AccountID: key.AccountID,
+			Totals:    l.totals(key),
+			Entries:   int64(len(entries)),
+			Version:   l.versions[key],
+			TTL:       -1,
+		})
+	}
+	sort.Slice(counters, func(i, j int) bool { return counters[i].AccountID < counters[j].AccountID })
+	return counters, 0, nil
+}
+
+// Seed loads an account's pending entries (from the database) unless the
+// account is already tracked, so rows accepted before the outage still count
+func (l *LocalCounter) Seed(ctx context.Context, accountID string, entries map[string]PendingEntry) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	key := accountKey(ctx, accountID)
+
+	if _, tracked := l.accounts[key]; tracked {
+		return
+	}
+	copied := make(map[string]PendingEntry, len(entries))
+	for id, entry := range entries {
+		copied[id] = entry
+	}
+	l.accounts[key] = copied
+}
+
+// Tracked reports whether the account already has local state
+func (l *LocalCounter) Tracked(ctx context.Context, accountID string) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	key := accountKey(ctx, accountID)
+	_, tracked := l.accounts[key]
+	return tracked
+}
+
+// Reset drops every local entry, used once Redis has been rebuilt
+func (l *LocalCounter) Reset() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.accounts = make(map[repository.AccountKey]map[string]PendingEntry)
+	l.versions = make(map[repository.AccountKey]int64)
+}
+
+// accountKey keys the local state by tenant, like the Redis keys
+func accountKey(ctx context.Context, accountID string) repository.AccountKey {
+	return repository.AccountKey{TenantID: tenant.ID(ctx), AccountID: accountID}
+}
+
+var _ RedisCounter = (*LocalCounter)(nil)
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// logSampler lets through 1 in every n calls, starting with the first. n = 1
+// logs everything, n <= 0 nothing. A nil sampler logs everything.
+type logSampler struct {
+	every int64
+	calls atomic.Int64
+}
+
+func newLogSampler(every int) *logSampler {
+	return &logSampler{every: int64(every)}
+}
+
+func (s *logSampler) sample() bool {
+	if s == nil {
+		return true
+	}
+	if s.every <= 0 {
+		return false
+	}
+	return (s.calls.Add(1)-1)%s.every == 0
+}
+
+// logRateLimiter allows at most limit lines per second. Lines dropped in a
+// window are reported by the first allowed call of a later window, so the
+// volume stays visible. limit <= 0 or a nil limiter allows everything.
+type logRateLimiter struct {
+	limit       int
+	windowStart time.Time
+	count       int
+	suppressed  int
+	mutex       sync.Mutex
+}
+
+func newLogRateLimiter(limit int) *logRateLimiter {
+	return &logRateLimiter{limit: limit}
+}
+
+// allow reports whether the caller may log, and how many lines were
+// suppressed since the last allowed line
+func (l *logRateLimiter) allow() (bool, int) {
+	if l == nil || l.limit <= 0 {
+		return true, 0
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.windowStart) >= time.Second {
+		l.windowStart = now
+		l.count = 0
+	}
+	if l.count >= l.limit {
+		l.suppressed++
+		return false, 0
+	}
+	l.count++
+	suppressed := l.suppressed
+	l.suppressed = 0
+	return true, suppressed
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"sub-balance-demo/internal/secrets"
+)
+
+var (
+	// ErrMetadataKeyMissing is returned when stored metadata is encrypted but
+	// no master key is configured to decrypt it
+	ErrMetadataKeyMissing = errors.New("transaction metadata is encrypted but no master key is configured")
+	// ErrInvalidMetadata is returned for a metadata value that looks like an
+	// encrypted one, which would be misread on the way out
+	ErrInvalidMetadata = errors.New("metadata values must not start with " + secrets.EncryptedPrefix)
+)
+
+// MetadataCipher encrypts the configured transaction metadata fields at rest
+// with AES-GCM under the master key (CONFIG_MASTER_KEY_FILE, or a data key
+// unwrapped by KMS from CONFIG_MASTER_KEY_KMS). Each value is bound to its
+// account and field, so a ciphertext copied to another row or field does not
+// decrypt. API callers send and read plaintext. A nil *MetadataCipher stores
+// metadata as given.
+type MetadataCipher struct {
+	key    []byte
+	fields map[string]bool
+}
+
+// NewMetadataCipher encrypts fields with key; key must be 32 bytes
+func NewMetadataCipher(key []byte, fields []string) *MetadataCipher {
+	c := &MetadataCipher{key: key, fields: make(map[string]bool, len(fields))}
+	for _, field := range fields {
+		c.fields[field] = true
+	}
+	return c
+}
+
+func metadataContext(accountID string, field string) string {
+	return "sub_balance_metadata:" + accountID + ":" + field
+}
+
+// Seal returns a copy of metadata with the configured fields encrypted
+func (c *MetadataCipher) Seal(accountID string, metadata map[string]string) (map[string]string, error) {
+	for _, value := range metadata {
+		if strings.HasPrefix(value, secrets.EncryptedPrefix) {
+			return nil, ErrInvalidMetadata
+		}
+	}
+	if c == nil || len(metadata) == 0 {
+		return metadata, nil
+	}
+	sealed := make(map[string]string, len(metadata))
+	for field, value := range metadata {
+		if c.fields[field] {
+			encrypted, err := secrets.Encrypt(c.key, metadataContext(accountID, field), value)
+			if err != nil {
+				return nil, fmt.Errorf("failed to encrypt metadata field %s: %w", field, err)
+			}
+			value = encrypted
+		}
+		sealed[field] = value
+	}
+	return sealed, nil
+}
+
+// Open returns a copy of metadata with every encrypted value decrypted, also
+// of fields that are no longer configured
+func (c *MetadataCipher) Open(accountID string, metadata map[string]string) (map[string]string, error) {
+	if len(metadata) == 0 {
+		return metadata, nil
+	}
+	opened := make(map[string]string, len(metadata))
+	for field, value := range metadata {
+		if strings.HasPrefix(value, secrets.EncryptedPrefix) {
+			if c == nil {
+				return nil, ErrMetadataKeyMissing
+			}
+			plaintext, err := secrets.Decrypt(c.key, metadataContext(accountID, field), value)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decrypt metadata field %s: %w", field, err)
+			}
+			value = plaintext
+		}
+		opened[field] = value
+	}
+	return opened, nil
+}
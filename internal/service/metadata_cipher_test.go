@@ -0,0 +1,93 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"sub-balance-demo/internal/secrets"
+)
+
+func TestMetadataCipherSealOpen(t *testing.T) {
+	cipher := NewMetadataCipher(bytes.Repeat([]byte{7}, 32), []string{"card_number"})
+	metadata := map[string]string{"card_number": "4111111111111111", "channel": "mobile"}
+
+	sealed, err := cipher.Seal("acc-1", metadata)
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	if !strings.HasPrefix(sealed["card_number"], secrets.EncryptedPrefix) || strings.Contains(sealed["card_number"], "4111") {
+		t.Fatalf("card_number stored as %q, want it encrypted", sealed["card_number"])
+	}
+	if sealed["channel"] != "mobile" {
+		t.Fatalf("channel stored as %q, want it as given", sealed["channel"])
+	}
+	if metadata["card_number"] != "4111111111111111" {
+		t.Fatal("Seal changed the caller's map")
+	}
+
+	opened, err := cipher.Open("acc-1", sealed)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if opened["card_number"] != "4111111111111111" || opened["channel"] != "mobile" {
+		t.Fatalf("Open = %v, want %v", opened, metadata)
+	}
+
+	// Field yang tidak lagi dikonfigurasi tetap dibuka
+	if opened, err := NewMetadataCipher(bytes.Repeat([]byte{7}, 32), nil).Open("acc-1", sealed); err != nil || opened["card_number"] != "4111111111111111" {
+		t.Fatalf("Open after the field was unconfigured = %v, %v", opened, err)
+	}
+}
+
+func TestMetadataCipherOpenFailsClosed(t *testing.T) {
+	cipher := NewMetadataCipher(bytes.Repeat([]byte{7}, 32), []string{"card_number", "iban"})
+	sealed, err := cipher.Seal("acc-1", map[string]string{"card_number": "4111111111111111"})
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		cipher    *MetadataCipher
+		accountID string
+		metadata  map[string]string
+		wantErr   error
+	}{
+		{"other account", cipher, "acc-2", sealed, nil},
+		{"other field", cipher, "acc-1", map[string]string{"iban": sealed["card_number"]}, nil},
+		{"other key", NewMetadataCipher(bytes.Repeat([]byte{8}, 32), nil), "acc-1", sealed, nil},
+		{"no key", nil, "acc-1", sealed, ErrMetadataKeyMissing},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opened, err := tt.cipher.Open(tt.accountID, tt.metadata)
+			if err == nil {
+				t.Fatalf("Open = %v, want an error", opened)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Open error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMetadataCipherRejectsEncryptedLookingValues(t *testing.T) {
+	metadata := map[string]string{"note": secrets.EncryptedPrefix + "abc"}
+	for _, cipher := range []*MetadataCipher{nil, NewMetadataCipher(bytes.Repeat([]byte{7}, 32), nil)} {
+		if _, err := cipher.Seal("acc-1", metadata); !errors.Is(err, ErrInvalidMetadata) {
+			t.Fatalf("Seal = %v, want %v", err, ErrInvalidMetadata)
+		}
+	}
+
+	// Tanpa cipher metadata disimpan apa adanya
+	plain := map[string]string{"note": "hello"}
+	sealed, err := (*MetadataCipher)(nil).Seal("acc-1", plain)
+	if err != nil || sealed["note"] != "hello" {
+		t.Fatalf("Seal without a cipher = %v, %v", sealed, err)
+	}
+	if opened, err := (*MetadataCipher)(nil).Open("acc-1", sealed); err != nil || opened["note"] != "hello" {
+		t.Fatalf("Open without a cipher = %v, %v", opened, err)
+	}
+}
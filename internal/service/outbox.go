@@ -0,0 +1,140 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"sub-balance-demo/internal/eventstream"
+	"sub-balance-demo/internal/repository"
+
+	"gorm.io/gorm"
+)
+
+// Outbox records domain events in the outbox table inside the caller's
+// transaction, so an event exists if and only if its state change committed.
+// A nil *Outbox is disabled: callers publish directly instead.
+type Outbox struct {
+	repo repository.OutboxRepository
+}
+
+func NewOutbox(repo repository.OutboxRepository) *Outbox {
+	return &Outbox{repo: repo}
+}
+
+// Write stores the events using tx; it is a no-op on a nil outbox
+func (o *Outbox) Write(ctx context.Context, tx *gorm.DB, events ...eventstream.Event) error {
+	if o == nil || len(events) == 0 {
+		return nil
+	}
+
+	rows := make([]repository.OutboxEvent, 0, len(events))
+	for _, event := range events {
+		if event.Timestamp.IsZero() {
+			event.Timestamp = time.Now()
+		}
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return fmt.Errorf("failed to encode %s event: %w", event.Type, err)
+		}
+		rows = append(rows, repository.OutboxEvent{
+			EventType: event.Type,
+			AccountID: event.AccountID,
+			Payload:   string(payload),
+		})
+	}
+
+	err := o.repo.WithTx(tx).Create(ctx, rows)
+	if err != nil {
+		return fmt.Errorf("failed to write outbox: %w", err)
+	}
+	return nil
+}
+
+// OutboxRelay publishes unsent outbox rows to the event stream in insertion
+// order and marks them sent. A crash between publish and commit republishes
+// the row, so consumers dedupe on the event_id field.
+type OutboxRelay struct {
+	db        *gorm.DB
+	repo      repository.OutboxRepository
+	publisher eventstream.Publisher
+	batchSize int
+}
+
+func NewOutboxRelay(db *gorm.DB, repo repository.OutboxRepository, publisher eventstream.Publisher, batchSize int) *OutboxRelay {
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+	return &OutboxRelay{
+		db:        db,
+		repo:      repo,
+		publisher: publisher,
+		batchSize: batchSize,
+	}
+}
+
+// Start relays on the given interval until ctx is cancelled. A full batch is
+// followed immediately by the next one so a backlog drains without waiting.
+func (r *OutboxRelay) Start(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	log.Println("Outbox relay started")
+	for {
+		select {
+		case <-ticker.C:
+			for ctx.Err() == nil {
+				sent, err := r.RelayOnce(ctx)
+				if err != nil {
+					log.Printf("Outbox relay failed: %v", err)
+					break
+				}
+				if sent < r.batchSize {
+					break
+				}
+			}
+		case <-ctx.Done():
+			log.Println("Outbox relay stopped")
+			return
+		}
+	}
+}
+
+// RelayOnce publishes one batch and returns how many events were sent. It
+// stops at the first publish failure so events are never delivered out of order.
+func (r *OutboxRelay) RelayOnce(ctx context.Context) (int, error) {
+	sent := 0
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		repo := r.repo.WithTx(tx)
+
+		rows, err := repo.ClaimUnsent(ctx, r.batchSize)
+		if err != nil {
+			return fmt.Errorf("failed to claim outbox events: %w", err)
+		}
+
+		sentIDs := make([]int64, 0, len(rows))
+		for _, row := range rows {
+			var event eventstream.Event
+			err := json.Unmarshal([]byte(row.Payload), &event)
+			if err == nil {
+				event.EventID = strconv.FormatInt(row.ID, 10)
+				err = r.publisher.Publish(ctx, event)
+			}
+			if err != nil {
+				log.Printf("Failed to relay outbox event %d (%s): %v", row.ID, row.EventType, err)
+				if recordErr := repo.RecordFailure(ctx, row.ID, err.Error()); recordErr != nil {
+					return recordErr
+				}
+				break
+			}
+			sentIDs = append(sentIDs, row.ID)
+		}
+
+		sent = len(sentIDs)
+		return repo.MarkSent(ctx, sentIDs)
+	})
+	return sent, err
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"sub-balance-demo/internal/logmask"
+	"sub-balance-demo/internal/repository"
+)
+
+// QuarantineService tracks consecutive settlement failures per account and
+// quarantines accounts that reach the threshold so the worker skips them
+// until an operator releases them. A threshold <= 0 disables quarantining.
+type QuarantineService struct {
+	repo      repository.QuarantineRepository
+	threshold int
+	failures  map[repository.AccountKey]int
+	mutex     sync.Mutex
+}
+
+func NewQuarantineService(repo repository.QuarantineRepository, threshold int) *QuarantineService {
+	return &QuarantineService{
+		repo:      repo,
+		threshold: threshold,
+		failures:  make(map[repository.AccountKey]int),
+	}
+}
+
+// Filter drops pending transactions that belong to quarantined accounts
+func (q *QuarantineService) Filter(ctx context.Context, transactions []repository.SubBalance) []repository.SubBalance {
+	if q.threshold <= 0 {
+		return transactions
+	}
+
+	keys, err := q.repo.ListKeys(ctx)
+	if err != nil {
+		log.Printf("Failed to load quarantined accounts: %v", err)
+		return transactions
+	}
+	if len(keys) == 0 {
+		return transactions
+	}
+
+	quarantined := make(map[repository.AccountKey]struct{}, len(keys))
+	for _, key := range keys {
+		quarantined[key] = struct{}{}
+	}
+
+	filtered := transactions[:0]
+	for _, txn := range transactions {
+		if _, skip := quarantined[repository.AccountKey{TenantID: txn.TenantID, AccountID: txn.AccountID}]; !skip {
+			filtered = append(filtered, txn)
+		}
+	}
+	return filtered
+}
+
+func (q *QuarantineService) RecordFailure(ctx context.Context, account repository.AccountKey, reason error) {
+	if q.threshold <= 0 {
+		return
+	}
+
+	q.mutex.Lock()
+	q.failures[account]++
+	failures := q.failures[account]
+	if failures >= q.threshold {
+		delete(q.failures, account)
+	}
+	q.mutex.Unlock()
+
+	if failures < q.threshold {
+		return
+	}
+
+	err := q.repo.Add(ctx, &repository.QuarantinedAccount{
+		TenantID:      account.TenantID,
+		AccountID:     account.AccountID,
+		Failures:      failures,
+		LastError:     reason.Error(),
+		QuarantinedAt: time.Now(),
+	})
+	if err != nil {
+		log.Printf("Failed to quarantine account %s: %v", logmask.Account(account.AccountID), err)
+		return
+	}
+	log.Printf("Account %s quarantined after %d consecutive settlement failures", logmask.Account(account.AccountID), failures)
+}
+
+func (q *QuarantineService) RecordSuccess(account repository.AccountKey) {
+	q.mutex.Lock()
+	delete(q.failures, account)
+	q.mutex.Unlock()
+}
+
+func (q *QuarantineService) List(ctx context.Context) ([]repository.QuarantinedAccount, error) {
+	return q.repo.List(ctx)
+}
+
+// Release removes an account from quarantine; it is picked up by the next settlement run
+func (q *QuarantineService) Release(ctx context.Context, accountID string) (bool, error) {
+	released, err := q.repo.Release(ctx, accountID)
+	if err == nil && released {
+		log.Printf("Account %s released from settlement quarantine", logmask.Account(accountID))
+	}
+	return released, err
+}
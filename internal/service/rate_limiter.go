@@ -0,0 +1,276 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"sub-balance-demo/internal/repository"
+)
+
+// ErrInvalidRateLimit is returned for a rate limit override without a
+// positive request count and window, or with a negative burst
+var ErrInvalidRateLimit = errors.New("requests and window_seconds must be positive, burst must not be negative")
+
+// ErrRateLimitNeedsAuth is returned for an override of an identity other than
+// an account while authentication is disabled: such identities are never
+// established, or only by the client IP
+var ErrRateLimitNeedsAuth = errors.New("authentication is disabled, only account:<account_id> rate limits apply")
+
+// Algoritma rate limit (RATE_LIMIT_ALGORITHM)
+const (
+	RateLimitFixedWindow = "fixed_window"
+	RateLimitTokenBucket = "token_bucket"
+)
+
+// RateLimitDecision is the outcome of one RateLimiter.Allow call
+type RateLimitDecision struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	ResetIn   time.Duration
+}
+
+type rateLimit struct {
+	requests int
+	window   time.Duration
+	burst    int // token bucket: kapasitas; 0 = requests
+}
+
+func newRateLimit(requests int, window time.Duration, burst int) rateLimit {
+	return rateLimit{requests: requests, window: window, burst: burst}
+}
+
+// capacity is the most requests the token bucket lets through at once
+func (r rateLimit) capacity() int {
+	if r.burst > 0 {
+		return r.burst
+	}
+	return r.requests
+}
+
+// refillInterval is how long the token bucket takes to earn one token back
+func (r rateLimit) refillInterval() time.Duration {
+	return r.window / time.Duration(r.requests)
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+	limit rateLimit
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	limit  rateLimit
+}
+
+// RateLimiter limits requests per caller identity, with fixed windows or
+// token buckets. A fixed window lets requests through per window; a token
+// bucket allows bursts of up to burst requests and earns requests back
+// continuously at requests per window, so a client that sends its traffic in
+// bursts is not rejected while its average stays within the limit. Every
+// identity gets the default limit unless the rate_limits table overrides it;
+// overrides are reloaded periodically, so a change made on another instance
+// applies here within the refresh interval. Counters are kept per instance.
+// With accountsOnly (authentication disabled) only account overrides can be
+// set.
+type RateLimiter struct {
+	repo         repository.RateLimitRepository
+	algorithm    string
+	defaultLimit rateLimit
+	accountsOnly bool
+
+	mutex     sync.Mutex
+	overrides map[string]rateLimit
+	windows   map[string]*rateWindow
+	buckets   map[string]*tokenBucket
+}
+
+func NewRateLimiter(repo repository.RateLimitRepository, algorithm string, requests int, window time.Duration, burst int, accountsOnly bool) *RateLimiter {
+	return &RateLimiter{
+		repo:         repo,
+		algorithm:    algorithm,
+		defaultLimit: newRateLimit(requests, window, burst),
+		accountsOnly: accountsOnly,
+		overrides:    make(map[string]rateLimit),
+		windows:      make(map[string]*rateWindow),
+		buckets:      make(map[string]*tokenBucket),
+	}
+}
+
+// Allow counts a request of identity and reports whether it is within the limit
+func (l *RateLimiter) Allow(identity string) RateLimitDecision {
+	now := time.Now()
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	limit, ok := l.overrides[identity]
+	if !ok {
+		limit = l.defaultLimit
+	}
+	if l.algorithm == RateLimitTokenBucket {
+		return l.takeToken(identity, limit, now)
+	}
+
+	w, ok := l.windows[identity]
+	if !ok || w.limit != limit || now.Sub(w.start) >= limit.window {
+		w = &rateWindow{start: now, limit: limit}
+		l.windows[identity] = w
+	}
+
+	decision := RateLimitDecision{Limit: limit.requests, ResetIn: limit.window - now.Sub(w.start)}
+	if w.count >= limit.requests {
+		return decision
+	}
+	w.count++
+	decision.Allowed = true
+	decision.Remaining = limit.requests - w.count
+	return decision
+}
+
+// takeToken refills the identity's bucket for the time since its last request
+// and takes a token from it. ResetIn is how long until the next token when
+// the request is rejected, and until the bucket is full otherwise.
+func (l *RateLimiter) takeToken(identity string, limit rateLimit, now time.Time) RateLimitDecision {
+	capacity := float64(limit.capacity())
+	refill := limit.refillInterval()
+
+	b, ok := l.buckets[identity]
+	if !ok || b.limit != limit {
+		b = &tokenBucket{tokens: capacity, last: now, limit: limit}
+		l.buckets[identity] = b
+	}
+	b.tokens = min(capacity, b.tokens+float64(now.Sub(b.last))/float64(refill))
+	b.last = now
+
+	decision := RateLimitDecision{Limit: limit.capacity()}
+	if b.tokens < 1 {
+		decision.ResetIn = time.Duration((1 - b.tokens) * float64(refill))
+		return decision
+	}
+	b.tokens--
+	decision.Allowed = true
+	decision.Remaining = int(b.tokens)
+	decision.ResetIn = time.Duration((capacity - b.tokens) * float64(refill))
+	return decision
+}
+
+// Start reloads the overrides now and every interval, and drops expired
+// windows, until ctx is done
+func (l *RateLimiter) Start(ctx context.Context, interval time.Duration) {
+	if err := l.Refresh(ctx); err != nil {
+		log.Printf("Failed to load rate limits: %v", err)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := l.Refresh(ctx); err != nil {
+				log.Printf("Failed to reload rate limits, keeping current: %v", err)
+			}
+			l.pruneWindows()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Refresh replaces the overrides with the rate_limits table
+func (l *RateLimiter) Refresh(ctx context.Context) error {
+	limits, err := l.repo.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	overrides := make(map[string]rateLimit, len(limits))
+	for _, limit := range limits {
+		if !validRateLimit(&limit) {
+			log.Printf("Ignoring invalid rate limit for %s: %d requests per %ds, burst %d", limit.Identity, limit.Requests, limit.WindowSeconds, limit.Burst)
+			continue
+		}
+		overrides[limit.Identity] = overrideLimit(&limit)
+	}
+
+	l.mutex.Lock()
+	l.overrides = overrides
+	l.mutex.Unlock()
+	return nil
+}
+
+func validRateLimit(limit *repository.RateLimit) bool {
+	return limit.Requests > 0 && limit.WindowSeconds > 0 && limit.Burst >= 0
+}
+
+func overrideLimit(limit *repository.RateLimit) rateLimit {
+	return newRateLimit(limit.Requests, time.Duration(limit.WindowSeconds)*time.Second, limit.Burst)
+}
+
+// pruneWindows drops windows that have ended and buckets that have filled up
+// again, so identities that stopped calling do not accumulate
+func (l *RateLimiter) pruneWindows() {
+	now := time.Now()
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for identity, w := range l.windows {
+		if now.Sub(w.start) >= w.limit.window {
+			delete(l.windows, identity)
+		}
+	}
+	for identity, b := range l.buckets {
+		missing := float64(b.limit.capacity()) - b.tokens
+		if now.Sub(b.last) >= time.Duration(missing*float64(b.limit.refillInterval())) {
+			delete(l.buckets, identity)
+		}
+	}
+}
+
+func (l *RateLimiter) List(ctx context.Context) ([]repository.RateLimit, error) {
+	return l.repo.List(ctx)
+}
+
+// Set stores an override; it applies on this instance immediately and on the
+// others at their next refresh
+func (l *RateLimiter) Set(ctx context.Context, limit *repository.RateLimit) error {
+	if !validRateLimit(limit) {
+		return ErrInvalidRateLimit
+	}
+	if l.accountsOnly && !strings.HasPrefix(limit.Identity, "account:") {
+		return ErrRateLimitNeedsAuth
+	}
+	limit.UpdatedAt = time.Now()
+	if err := l.repo.Upsert(ctx, limit); err != nil {
+		return err
+	}
+
+	l.mutex.Lock()
+	l.overrides[limit.Identity] = overrideLimit(limit)
+	l.mutex.Unlock()
+	log.Printf("Rate limit for %s set to %d requests per %ds, burst %d", limit.Identity, limit.Requests, limit.WindowSeconds, limit.Burst)
+	return nil
+}
+
+// Delete removes an override; the identity falls back to the default limit
+func (l *RateLimiter) Delete(ctx context.Context, identity string) (bool, error) {
+	deleted, err := l.repo.Delete(ctx, identity)
+	if err != nil {
+		return false, err
+	}
+
+	l.mutex.Lock()
+	delete(l.overrides, identity)
+	l.mutex.Unlock()
+	if deleted {
+		log.Printf("Rate limit override for %s removed", identity)
+	}
+	return deleted, nil
+}
@@ -0,0 +1,175 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"sub-balance-demo/internal/repository"
+)
+
+type memoryRateLimits struct {
+	limits map[string]repository.RateLimit
+}
+
+func (m *memoryRateLimits) List(ctx context.Context) ([]repository.RateLimit, error) {
+	var limits []repository.RateLimit
+	for _, limit := range m.limits {
+		limits = append(limits, limit)
+	}
+	return limits, nil
+}
+
+func (m *memoryRateLimits) Upsert(ctx context.Context, limit *repository.RateLimit) error {
+	m.limits[limit.Identity] = *limit
+	return nil
+}
+
+func (m *memoryRateLimits) Delete(ctx context.Context, identity string) (bool, error) {
+	_, ok := m.limits[identity]
+	delete(m.limits, identity)
+	return ok, nil
+}
+
+func TestRateLimiterSetAccountsOnly(t *testing.T) {
+	tests := []struct {
+		identity     string
+		accountsOnly bool
+		wantErr      error
+	}{
+		{"account:ACC001", true, nil},
+		{"apikey:partner-a", true, ErrRateLimitNeedsAuth},
+		{"ip:10.0.0.1", true, ErrRateLimitNeedsAuth},
+		{"apikey:partner-a", false, nil},
+	}
+	for _, tt := range tests {
+		limiter := NewRateLimiter(&memoryRateLimits{limits: map[string]repository.RateLimit{}}, RateLimitFixedWindow, 10, time.Minute, 0, tt.accountsOnly)
+		err := limiter.Set(context.Background(), &repository.RateLimit{Identity: tt.identity, Requests: 1, WindowSeconds: 60})
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("Set(%s) accountsOnly=%t = %v, want %v", tt.identity, tt.accountsOnly, err, tt.wantErr)
+		}
+	}
+}
+
+func TestRateLimiterTokenBucket(t *testing.T) {
+	// 60 request per menit = satu token per detik
+	tests := []struct {
+		name    string
+		burst   int
+		steps   []time.Duration // offset tiap request dari request pertama
+		allowed []bool
+		resetIn time.Duration // ResetIn request terakhir
+	}{
+		{
+			name:    "burst of capacity then rejected",
+			burst:   3,
+			steps:   []time.Duration{0, 0, 0, 0},
+			allowed: []bool{true, true, true, false},
+			resetIn: time.Second,
+		},
+		{
+			name:    "burst defaults to requests",
+			steps:   []time.Duration{0, 0},
+			allowed: []bool{true, true},
+			resetIn: 2 * time.Second,
+		},
+		{
+			name:    "earns a token back per refill interval",
+			burst:   2,
+			steps:   []time.Duration{0, 0, 500 * time.Millisecond, time.Second, time.Second},
+			allowed: []bool{true, true, false, true, false},
+			resetIn: time.Second,
+		},
+		{
+			name:    "refill stops at capacity",
+			burst:   2,
+			steps:   []time.Duration{0, time.Hour, time.Hour, time.Hour},
+			allowed: []bool{true, true, true, false},
+			resetIn: time.Second,
+		},
+		{
+			name:    "partial token counts toward the next",
+			burst:   1,
+			steps:   []time.Duration{0, 250 * time.Millisecond},
+			allowed: []bool{true, false},
+			resetIn: 750 * time.Millisecond,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := NewRateLimiter(&memoryRateLimits{limits: map[string]repository.RateLimit{}}, RateLimitTokenBucket, 60, time.Minute, tt.burst, false)
+			start := time.Now()
+			var decision RateLimitDecision
+			for i, step := range tt.steps {
+				decision = limiter.takeToken("ip:10.0.0.1", limiter.defaultLimit, start.Add(step))
+				if decision.Allowed != tt.allowed[i] {
+					t.Fatalf("request %d at +%s allowed = %t, want %t", i, step, decision.Allowed, tt.allowed[i])
+				}
+			}
+			if decision.ResetIn != tt.resetIn {
+				t.Fatalf("ResetIn = %s, want %s", decision.ResetIn, tt.resetIn)
+			}
+		})
+	}
+}
+
+func TestRateLimiterTokenBucketOverride(t *testing.T) {
+	repo := &memoryRateLimits{limits: map[string]repository.RateLimit{}}
+	limiter := NewRateLimiter(repo, RateLimitTokenBucket, 1, time.Hour, 0, false)
+	if !limiter.Allow("apikey:partner-a").Allowed || limiter.Allow("apikey:partner-a").Allowed {
+		t.Fatalf("default bucket of 1 did not allow exactly one request")
+	}
+
+	// Override baru memulai bucket penuh dengan kapasitas burst
+	if err := limiter.Set(context.Background(), &repository.RateLimit{Identity: "apikey:partner-a", Requests: 10, WindowSeconds: 60, Burst: 5}); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		if decision := limiter.Allow("apikey:partner-a"); !decision.Allowed || decision.Limit != 5 || decision.Remaining != 4-i {
+			t.Fatalf("request %d = %+v, want allowed with limit 5, %d remaining", i, decision, 4-i)
+		}
+	}
+	if limiter.Allow("apikey:partner-a").Allowed {
+		t.Fatalf("request beyond burst allowed")
+	}
+}
+
+func TestRateLimiterRejectsNegativeBurst(t *testing.T) {
+	repo := &memoryRateLimits{limits: map[string]repository.RateLimit{
+		"apikey:partner-a": {Identity: "apikey:partner-a", Requests: 10, WindowSeconds: 60, Burst: -1},
+		"apikey:partner-b": {Identity: "apikey:partner-b", Requests: 10, WindowSeconds: 60, Burst: 20},
+	}}
+	limiter := NewRateLimiter(repo, RateLimitTokenBucket, 1, time.Minute, 0, false)
+
+	err := limiter.Set(context.Background(), &repository.RateLimit{Identity: "apikey:partner-c", Requests: 10, WindowSeconds: 60, Burst: -1})
+	if !errors.Is(err, ErrInvalidRateLimit) {
+		t.Fatalf("Set with negative burst = %v, want %v", err, ErrInvalidRateLimit)
+	}
+	if err := limiter.Refresh(context.Background()); err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if _, ok := limiter.overrides["apikey:partner-a"]; ok {
+		t.Fatalf("override with negative burst loaded")
+	}
+	if got := limiter.overrides["apikey:partner-b"]; got.capacity() != 20 {
+		t.Fatalf("override capacity = %d, want 20", got.capacity())
+	}
+}
+
+func TestRateLimiterPrunesFullBuckets(t *testing.T) {
+	limiter := NewRateLimiter(&memoryRateLimits{limits: map[string]repository.RateLimit{}}, RateLimitTokenBucket, 60, time.Minute, 10, false)
+	now := time.Now()
+	limiter.takeToken("refilled", limiter.defaultLimit, now.Add(-11*time.Second))
+	limiter.takeToken("draining", limiter.defaultLimit, now.Add(-time.Second))
+	limiter.takeToken("draining", limiter.defaultLimit, now.Add(-time.Second))
+	limiter.takeToken("draining", limiter.defaultLimit, now.Add(-time.Second))
+
+	limiter.pruneWindows()
+	if _, ok := limiter.buckets["refilled"]; ok {
+		t.Fatalf("full bucket not pruned")
+	}
+	if _, ok := limiter.buckets["draining"]; !ok {
+		t.Fatalf("bucket still refilling was pruned")
+	}
+}
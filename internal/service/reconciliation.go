@@ -0,0 +1,79 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"sub-balance-demo/internal/logmask"
+	"sub-balance-demo/internal/pagination"
+	"sub-balance-demo/internal/repository"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+type ReconciliationService struct {
+	reconciliationRepo repository.ReconciliationRepository
+}
+
+func NewReconciliationService(reconciliationRepo repository.ReconciliationRepository) *ReconciliationService {
+	return &ReconciliationService{
+		reconciliationRepo: reconciliationRepo,
+	}
+}
+
+// Reconcile compares the balance delta applied per account during a settlement
+// run with the sum of sub_balances stamped by that run, and persists the result
+func (r *ReconciliationService) Reconcile(ctx context.Context, settlementID string, applied map[repository.AccountKey]decimal.Decimal) error {
+	expected, err := r.reconciliationRepo.GetSettledDeltaBySettlementID(ctx, settlementID)
+	if err != nil {
+		return fmt.Errorf("failed to get settled delta: %w", err)
+	}
+
+	accounts := make(map[repository.AccountKey]struct{}, len(applied))
+	for account := range applied {
+		accounts[account] = struct{}{}
+	}
+	for account := range expected {
+		accounts[account] = struct{}{}
+	}
+
+	now := time.Now()
+	records := make([]repository.ReconciliationRecord, 0, len(accounts))
+	discrepancies := 0
+	for account := range accounts {
+		discrepancy := applied[account].Sub(expected[account])
+		matched := discrepancy.IsZero()
+		if !matched {
+			discrepancies++
+			log.Printf("Reconciliation discrepancy: settlement=%s, account=%s, expected=%s, applied=%s",
+				settlementID, logmask.Account(account.AccountID), logmask.Amount(expected[account]), logmask.Amount(applied[account]))
+		}
+
+		records = append(records, repository.ReconciliationRecord{
+			ID:            uuid.New().String(),
+			SettlementID:  settlementID,
+			TenantID:      account.TenantID,
+			AccountID:     account.AccountID,
+			ExpectedDelta: expected[account],
+			AppliedDelta:  applied[account],
+			Discrepancy:   discrepancy,
+			Matched:       matched,
+			CreatedAt:     now,
+		})
+	}
+
+	err = r.reconciliationRepo.CreateBatch(ctx, records)
+	if err != nil {
+		return fmt.Errorf("failed to save reconciliation: %w", err)
+	}
+
+	log.Printf("Reconciliation for settlement %s completed: accounts=%d, discrepancies=%d", settlementID, len(records), discrepancies)
+	return nil
+}
+
+func (r *ReconciliationService) List(ctx context.Context, filter repository.ReconciliationFilter) (pagination.Page[repository.ReconciliationRecord], error) {
+	return r.reconciliationRepo.List(ctx, filter)
+}
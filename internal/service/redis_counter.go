@@ -2,150 +2,706 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"sub-balance-demo/internal/config"
+	"sub-balance-demo/internal/tenant"
 
-	"github.com/go-redis/redis/v8"
+	"github.com/redis/go-redis/v9"
 	"github.com/shopspring/decimal"
 )
 
+// RedisCounter keeps, per account, a Redis hash of pending entries keyed by
+// side and transaction ID ("d:<id>" / "c:<id>") plus running debit and credit
+// totals, so entries can be removed exactly and each side compared against the
+// sub_balances table independently. Every write bumps a per-account version so
+// a rebuild from a stale database snapshot can be detected and rejected.
 type RedisCounter interface {
-	GetPending(ctx context.Context, accountID string) (decimal.Decimal, error)
-	AddPending(ctx context.Context, accountID string, amount decimal.Decimal, maxBalance decimal.Decimal) (bool, decimal.Decimal, error)
-	RemovePending(ctx context.Context, accountID string, amount decimal.Decimal) error
+	GetPending(ctx context.Context, accountID string) (PendingTotals, error)
+	GetPendingBulk(ctx context.Context, accountIDs []string) (map[string]PendingTotals, error)
+	GetPendingEntries(ctx context.Context, accountID string) (map[string]PendingEntry, error)
+	AddPending(ctx context.Context, accountID string, transactionID string, txType string, amount decimal.Decimal, maxBalance decimal.Decimal) (bool, PendingTotals, error)
+	RemovePending(ctx context.Context, accountID string, transactionIDs ...string) error
+	GetVersions(ctx context.Context, accountIDs []string) (map[string]int64, error)
+	SetPending(ctx context.Context, accountID string, expectedVersion int64, entries map[string]PendingEntry) error
+	SetPendingIfEquals(ctx context.Context, accountID string, expected PendingTotals, entries map[string]PendingEntry) (bool, error)
 	ClearPending(ctx context.Context, accountID string) error
+	ScanPending(ctx context.Context, cursor uint64, count int64) ([]PendingCounter, uint64, error)
 }
 
+// PendingTotals are the pending debit and credit sums of an account
+type PendingTotals struct {
+	Debit  decimal.Decimal `json:"debit"`
+	Credit decimal.Decimal `json:"credit"`
+}
+
+// PendingEntry is a single pending transaction tracked in Redis
+type PendingEntry struct {
+	Type   string          `json:"type"` // debit or credit
+	Amount decimal.Decimal `json:"amount"`
+}
+
+// PendingCounter describes one counter key as found by ScanPending
+type PendingCounter struct {
+	AccountID string        `json:"account_id"`
+	Totals    PendingTotals `json:"totals"`
+	Entries   int64         `json:"entries"`
+	Version   int64         `json:"version"`
+	TTL       time.Duration `json:"-"`
+	Legacy    bool          `json:"legacy,omitempty"` // pre-hash string counter, not parsed
+}
+
+const (
+	pendingDebitField   = "_debit"
+	pendingCreditField  = "_credit"
+	pendingVersionField = "_version"
+)
+
+// AnyVersion makes SetPending overwrite regardless of the current version
+const AnyVersion int64 = -1
+
+// ErrStaleCounter is returned by SetPending when the counter was written after
+// the caller read its version, i.e. the snapshot being written is stale
+var ErrStaleCounter = errors.New("pending counter changed since snapshot")
+
 type redisCounter struct {
-	client    *redis.Client
-	keyPrefix string
-	keyExpiry int
+	client          *redis.Client
+	replicas        []*redis.Client
+	nextReplica     uint64
+	keyPrefix       string
+	keyExpiry       time.Duration // 0 = counters never expire
+	creditSpendable bool
+	retry           retryPolicy
 }
 
-func NewRedisCounter(client *redis.Client, config *config.Config) RedisCounter {
+// NewRedisCounter creates the counter; replicas, when given, serve GetPending
+// and GetPendingBulk reads while every write stays on client (the primary)
+func NewRedisCounter(client *redis.Client, replicas []*redis.Client, config *config.Config) RedisCounter {
+	keyExpiry, err := parseKeyExpiry(config.RedisKeyExpiry)
+	if err != nil {
+		log.Printf("Invalid Redis key expiry, using default 24h: %v", err)
+		keyExpiry = 24 * time.Hour
+	}
+	if keyExpiry == 0 {
+		log.Println("Redis counter expiry disabled, stale counters are cleaned up by the consistency check")
+	}
+
 	return &redisCounter{
-		client:    client,
-		keyPrefix: config.RedisKeyPrefix,
-		keyExpiry: config.RedisKeyExpiry,
+		client:          client,
+		replicas:        replicas,
+		keyPrefix:       config.RedisNamespace(),
+		keyExpiry:       keyExpiry,
+		creditSpendable: config.PendingCreditSpendable,
+		retry:           newRetryPolicy(config),
 	}
 }
 
-func (r *redisCounter) GetPending(ctx context.Context, accountID string) (decimal.Decimal, error) {
-	key := fmt.Sprintf("%s:pending:%s", r.keyPrefix, accountID)
-
-	result := r.client.Get(ctx, key)
-	if result.Err() != nil {
-		if result.Err() == redis.Nil {
-			return decimal.Zero, nil
+// parseKeyExpiry accepts a Go duration ("24h"), "0" to disable expiry, or a
+// bare integer, which older configs used for seconds
+func parseKeyExpiry(raw string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(raw); err == nil {
+		if seconds < 0 {
+			return 0, fmt.Errorf("negative expiry %q", raw)
 		}
-		return decimal.Zero, result.Err()
+		return time.Duration(seconds) * time.Second, nil
 	}
 
-	value, err := decimal.NewFromString(result.Val())
+	expiry, err := time.ParseDuration(raw)
 	if err != nil {
-		return decimal.Zero, err
+		return 0, err
 	}
+	if expiry < 0 {
+		return 0, fmt.Errorf("negative expiry %q", raw)
+	}
+	return expiry, nil
+}
 
-	return value, nil
+// expiryMillis is passed to the Lua scripts, which apply it via expireKey
+func (r *redisCounter) expiryMillis() int64 {
+	return r.keyExpiry.Milliseconds()
 }
 
-func (r *redisCounter) AddPending(ctx context.Context, accountID string, amount decimal.Decimal, maxBalance decimal.Decimal) (bool, decimal.Decimal, error) {
-	key := fmt.Sprintf("%s:pending:%s", r.keyPrefix, accountID)
+// refreshExpiry queues a TTL refresh so a counter that is still being read
+// does not expire while its transactions are pending
+func (r *redisCounter) refreshExpiry(ctx context.Context, pipe redis.Pipeliner, key string) {
+	if r.keyExpiry > 0 {
+		pipe.PExpire(ctx, key, r.keyExpiry)
+	}
+}
 
-	// Atomic script untuk validation + update
-	script := `
-		local key = KEYS[1]
-		local amount = tonumber(ARGV[1])
-		local maxBalance = tonumber(ARGV[2])
-		
-		-- Get current pending amount
-		local current = redis.call('GET', key)
-		if current == false then
-			current = 0
+// expireKey is the Lua counterpart of refreshExpiry: PEXPIRE, or PERSIST when
+// expiry is disabled so keys written under an older TTL stop expiring
+const expireKey = `
+	local function expireKey(key, millis)
+		if tonumber(millis) > 0 then
+			redis.call('PEXPIRE', key, millis)
 		else
-			current = tonumber(current)
+			redis.call('PERSIST', key)
+		end
+	end
+`
+
+// pendingScale is the number of minor-unit digits kept in Redis. Counters are
+// stored as integer minor units (matching the decimal(20,2) columns) so the Lua
+// scripts never touch floating point; HINCRBY is an exact 64-bit op and Lua
+// comparisons stay exact up to 2^53 minor units.
+const pendingScale = 2
+
+func toMinorUnits(amount decimal.Decimal) string {
+	return amount.Shift(pendingScale).Round(0).String()
+}
+
+func fromMinorUnits(value int64) decimal.Decimal {
+	return decimal.New(value, -pendingScale)
+}
+
+// parsePending parses a stored amount. Values written before the switch to
+// minor units contain a decimal point and are read as major units.
+func parsePending(raw string) (decimal.Decimal, error) {
+	value, err := decimal.NewFromString(raw)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	if strings.Contains(raw, ".") {
+		return value, nil
+	}
+	return value.Shift(-pendingScale), nil
+}
+
+// Counter scripts are sent with EVALSHA; redis.Script falls back to EVAL (which
+// also caches the script) on NOSCRIPT, e.g. after a restart or failover.
+var (
+	// Atomic script untuk validation + update, semua nilai dalam minor units (integer).
+	// Hanya debit yang divalidasi terhadap saldo; credit opsional ikut menambah
+	// saldo yang bisa dipakai (PENDING_CREDIT_SPENDABLE).
+	addPendingScript = redis.NewScript(expireKey + `
+	local key = KEYS[1]
+	local txId = ARGV[1]
+	local side = ARGV[2]
+	local amount = tonumber(ARGV[3])
+	local maxBalance = tonumber(ARGV[4])
+	local creditSpendable = ARGV[6] == '1'
+
+	-- Counter lama (string) dibuang; recovery membangun ulang dari database
+	if redis.call('TYPE', key).ok == 'string' then
+		redis.call('DEL', key)
+	end
+
+	-- Get current pending amounts
+	local debit = tonumber(redis.call('HGET', key, '_debit') or '0')
+	local credit = tonumber(redis.call('HGET', key, '_credit') or '0')
+
+	-- Entry yang sama sudah tercatat (retry): idempotent
+	local field = string.sub(side, 1, 1) .. ':' .. txId
+	if redis.call('HEXISTS', key, field) == 1 then
+		return {1, debit, credit, "duplicate"}
+	end
+
+	if side == 'debit' then
+		local spendable = maxBalance
+		if creditSpendable then
+			spendable = spendable + credit
 		end
-		
-		-- Calculate new total
-		local newTotal = current + amount
-		
+
 		-- Validation: tidak boleh overspend
-		if newTotal > maxBalance then
-			return {0, current, "overspend protection"}
+		if debit + amount > spendable then
+			return {0, debit, credit, "overspend protection"}
 		end
-		
+
 		-- Validation: tidak boleh minus
-		if newTotal < 0 then
-			return {0, current, "negative balance"}
+		if debit + amount < 0 then
+			return {0, debit, credit, "negative balance"}
 		end
-		
-		-- Atomic update
-		redis.call('SET', key, newTotal)
-		redis.call('EXPIRE', key, ARGV[3])
-		
-		return {1, newTotal, "success"}
-	`
-
-	result := r.client.Eval(ctx, script, []string{key}, amount.InexactFloat64(), maxBalance.InexactFloat64(), r.keyExpiry)
-	if result.Err() != nil {
-		return false, decimal.Zero, result.Err()
-	}
-
-	values := result.Val().([]interface{})
-	success := values[0].(int64)
-
-	// Handle both int64 and float64 return types from Redis
-	var newTotal decimal.Decimal
-	switch v := values[1].(type) {
-	case float64:
-		newTotal = decimal.NewFromFloat(v)
-	case int64:
-		newTotal = decimal.NewFromInt(v)
-	case string:
-		var err error
-		newTotal, err = decimal.NewFromString(v)
+	end
+
+	-- Atomic update
+	redis.call('HSET', key, field, ARGV[3])
+	redis.call('HINCRBY', key, '_version', 1)
+	local newTotal = redis.call('HINCRBY', key, '_' .. side, ARGV[3])
+	if side == 'debit' then
+		debit = newTotal
+	else
+		credit = newTotal
+	end
+	expireKey(key, ARGV[5])
+
+	return {1, debit, credit, "success"}
+`)
+
+	// RemovePending: ARGV[1] expiry, ARGV[2..] transaction IDs. The stored
+	// amount is negated as a string: -tonumber() would be formatted with %.14g
+	// ("-1e+14") and loses precision above 2^53, both rejected or drifting in
+	// HINCRBY.
+	removePendingScript = redis.NewScript(expireKey + `
+	local key = KEYS[1]
+
+	for i = 2, #ARGV do
+		for _, side in ipairs({'debit', 'credit'}) do
+			local field = string.sub(side, 1, 1) .. ':' .. ARGV[i]
+			local amount = redis.call('HGET', key, field)
+			if amount then
+				redis.call('HDEL', key, field)
+				local negated = '-' .. amount
+				if string.sub(amount, 1, 1) == '-' then
+					negated = string.sub(amount, 2)
+				end
+				redis.call('HINCRBY', key, '_' .. side, negated)
+			end
+		end
+	end
+
+	if redis.call('EXISTS', key) == 0 then
+		return 0
+	end
+	redis.call('HINCRBY', key, '_version', 1)
+
+	-- Tidak ada pending lagi di kedua sisi: hanya version yang disimpan, supaya
+	-- rebuild dengan snapshot lama tetap terdeteksi
+	local debit = tonumber(redis.call('HGET', key, '_debit') or '0')
+	local credit = tonumber(redis.call('HGET', key, '_credit') or '0')
+	if debit == 0 and credit == 0 then
+		redis.call('HDEL', key, '_debit', '_credit')
+	end
+
+	expireKey(key, ARGV[1])
+	return 1
+`)
+
+	// SetPending: ARGV[1] expiry, ARGV[2] expected version (-1 = any), then
+	// (id, side, amount) triples. Returns the new version, or -1 when stale.
+	setPendingScript = redis.NewScript(expireKey + `
+	local key = KEYS[1]
+	local expected = tonumber(ARGV[2])
+
+	local current = 0
+	if redis.call('TYPE', key).ok == 'hash' then
+		current = tonumber(redis.call('HGET', key, '_version') or '0')
+	end
+	if expected >= 0 and current ~= expected then
+		return -1
+	end
+
+	redis.call('DEL', key)
+	for i = 3, #ARGV, 3 do
+		local side = ARGV[i + 1]
+		redis.call('HSET', key, string.sub(side, 1, 1) .. ':' .. ARGV[i], ARGV[i + 2])
+		redis.call('HINCRBY', key, '_' .. side, ARGV[i + 2])
+	end
+	redis.call('HSET', key, '_version', current + 1)
+	expireKey(key, ARGV[1])
+
+	return current + 1
+`)
+
+	// SetPendingIfEquals: ARGV[1] expiry, ARGV[2]/ARGV[3] expected debit/credit
+	// totals, then (id, side, amount) triples. Returns the new version, or -1
+	// when the totals no longer match.
+	setPendingIfEqualsScript = redis.NewScript(expireKey + `
+	local key = KEYS[1]
+
+	local debit, credit, version = 0, 0, 0
+	local keyType = redis.call('TYPE', key).ok
+	if keyType == 'hash' then
+		debit = tonumber(redis.call('HGET', key, '_debit') or '0')
+		credit = tonumber(redis.call('HGET', key, '_credit') or '0')
+		version = tonumber(redis.call('HGET', key, '_version') or '0')
+	elseif keyType ~= 'none' then
+		return -1
+	end
+	if debit ~= tonumber(ARGV[2]) or credit ~= tonumber(ARGV[3]) then
+		return -1
+	end
+
+	redis.call('DEL', key)
+	for i = 4, #ARGV, 3 do
+		local side = ARGV[i + 1]
+		redis.call('HSET', key, string.sub(side, 1, 1) .. ':' .. ARGV[i], ARGV[i + 2])
+		redis.call('HINCRBY', key, '_' .. side, ARGV[i + 2])
+	end
+	redis.call('HSET', key, '_version', version + 1)
+	expireKey(key, ARGV[1])
+
+	return version + 1
+`)
+)
+
+// LoadScripts preloads the counter scripts with SCRIPT LOAD so the first
+// transactions already hit the EVALSHA fast path
+func LoadScripts(ctx context.Context, client *redis.Client) error {
+	for _, script := range []*redis.Script{addPendingScript, removePendingScript, setPendingScript, setPendingIfEqualsScript} {
+		if err := script.Load(ctx, client).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pendingKey is the counter key of an account in the tenant bound to ctx. The
+// default tenant keeps the original key layout so existing counters stay valid.
+func (r *redisCounter) pendingKey(ctx context.Context, accountID string) string {
+	if id := tenant.ID(ctx); id != tenant.Default {
+		return fmt.Sprintf("%s:tenant:%s:pending:%s", r.keyPrefix, id, accountID)
+	}
+	return fmt.Sprintf("%s:pending:%s", r.keyPrefix, accountID)
+}
+
+// replica picks the next read replica round-robin, or nil without replicas
+func (r *redisCounter) replica() *redis.Client {
+	if len(r.replicas) == 0 {
+		return nil
+	}
+	n := atomic.AddUint64(&r.nextReplica, 1)
+	return r.replicas[n%uint64(len(r.replicas))]
+}
+
+func (r *redisCounter) GetPending(ctx context.Context, accountID string) (PendingTotals, error) {
+	key := r.pendingKey(ctx, accountID)
+
+	// Replica read-only: TTL tidak di-refresh, write berikutnya yang memperpanjang
+	if replica := r.replica(); replica != nil {
+		values, err := replica.HMGet(ctx, key, pendingDebitField, pendingCreditField).Result()
+		if err == nil {
+			return parsePendingTotals(values)
+		}
+		log.Printf("Redis replica read failed, using primary: %v", err)
+	}
+
+	var cmd *redis.SliceCmd
+	err := r.retry.do(ctx, func() error {
+		pipe := r.client.Pipeline()
+		cmd = pipe.HMGet(ctx, key, pendingDebitField, pendingCreditField)
+		r.refreshExpiry(ctx, pipe, key)
+		_, err := pipe.Exec(ctx)
+		return err
+	})
+	if err != nil {
+		return PendingTotals{}, err
+	}
+	return parsePendingTotals(cmd.Val())
+}
+
+// pendingBulkChunk bounds the number of commands sent in one pipeline
+const pendingBulkChunk = 1000
+
+// GetPendingBulk fetches the pending totals of many accounts with pipelined
+// HMGETs, one round trip per chunk. Accounts without a counter get zero totals;
+// accounts still holding a legacy string counter are left out of the result.
+func (r *redisCounter) GetPendingBulk(ctx context.Context, accountIDs []string) (map[string]PendingTotals, error) {
+	if replica := r.replica(); replica != nil {
+		result, err := r.getPendingBulk(ctx, replica, accountIDs, false)
+		if err == nil {
+			return result, nil
+		}
+		log.Printf("Redis replica bulk read failed, using primary: %v", err)
+	}
+	return r.getPendingBulk(ctx, r.client, accountIDs, true)
+}
+
+func (r *redisCounter) getPendingBulk(ctx context.Context, client *redis.Client, accountIDs []string, refresh bool) (map[string]PendingTotals, error) {
+	result := make(map[string]PendingTotals, len(accountIDs))
+
+	for start := 0; start < len(accountIDs); start += pendingBulkChunk {
+		end := start + pendingBulkChunk
+		if end > len(accountIDs) {
+			end = len(accountIDs)
+		}
+		chunk := accountIDs[start:end]
+
+		pipe := client.Pipeline()
+		cmds := make([]*redis.SliceCmd, len(chunk))
+		for i, accountID := range chunk {
+			key := r.pendingKey(ctx, accountID)
+			cmds[i] = pipe.HMGet(ctx, key, pendingDebitField, pendingCreditField)
+			if refresh {
+				r.refreshExpiry(ctx, pipe, key)
+			}
+		}
+		// Exec reports the first failed command; per-command errors are checked below
+		if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil && !isWrongType(err) {
+			return nil, err
+		}
+
+		for i, accountID := range chunk {
+			if err := cmds[i].Err(); err != nil {
+				if isWrongType(err) {
+					continue // legacy string counter, left to recovery
+				}
+				return nil, err
+			}
+			totals, err := parsePendingTotals(cmds[i].Val())
+			if err != nil {
+				return nil, fmt.Errorf("invalid pending totals for account %s: %w", accountID, err)
+			}
+			result[accountID] = totals
+		}
+	}
+
+	return result, nil
+}
+
+func isWrongType(err error) bool {
+	return strings.HasPrefix(err.Error(), "WRONGTYPE")
+}
+
+func parsePendingTotals(values []interface{}) (PendingTotals, error) {
+	var err error
+	totals := PendingTotals{Debit: decimal.Zero, Credit: decimal.Zero}
+	if raw, ok := values[0].(string); ok {
+		if totals.Debit, err = parsePending(raw); err != nil {
+			return PendingTotals{}, err
+		}
+	}
+	if raw, ok := values[1].(string); ok {
+		if totals.Credit, err = parsePending(raw); err != nil {
+			return PendingTotals{}, err
+		}
+	}
+	return totals, nil
+}
+
+func (r *redisCounter) GetPendingEntries(ctx context.Context, accountID string) (map[string]PendingEntry, error) {
+	key := r.pendingKey(ctx, accountID)
+	var cmd *redis.MapStringStringCmd
+	err := r.retry.do(ctx, func() error {
+		pipe := r.client.Pipeline()
+		cmd = pipe.HGetAll(ctx, key)
+		r.refreshExpiry(ctx, pipe, key)
+		_, err := pipe.Exec(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	values := cmd.Val()
+
+	entries := make(map[string]PendingEntry, len(values))
+	for field, raw := range values {
+		var txType string
+		switch {
+		case strings.HasPrefix(field, "d:"):
+			txType = "debit"
+		case strings.HasPrefix(field, "c:"):
+			txType = "credit"
+		default:
+			continue // totals
+		}
+		amount, err := parsePending(raw)
 		if err != nil {
-			return false, decimal.Zero, fmt.Errorf("failed to parse total from Redis: %v", err)
+			return nil, fmt.Errorf("invalid pending entry %s: %w", field, err)
 		}
-	default:
-		return false, decimal.Zero, fmt.Errorf("unexpected type for total from Redis: %T", v)
+		entries[field[2:]] = PendingEntry{Type: txType, Amount: amount}
+	}
+	return entries, nil
+}
+
+func (r *redisCounter) AddPending(ctx context.Context, accountID string, transactionID string, txType string, amount decimal.Decimal, maxBalance decimal.Decimal) (bool, PendingTotals, error) {
+	creditSpendable := "0"
+	if r.creditSpendable {
+		creditSpendable = "1"
+	}
+
+	// Retry aman: entry yang sudah tercatat dikenali sebagai duplicate
+	var result *redis.Cmd
+	err := r.retry.do(ctx, func() error {
+		result = addPendingScript.Run(ctx, r.client, []string{r.pendingKey(ctx, accountID)},
+			transactionID, txType, toMinorUnits(amount), toMinorUnits(maxBalance), r.expiryMillis(), creditSpendable)
+		return result.Err()
+	})
+	if err != nil {
+		return false, PendingTotals{}, err
 	}
 
-	return success == 1, newTotal, nil
+	values, ok := result.Val().([]interface{})
+	if !ok || len(values) < 3 {
+		return false, PendingTotals{}, fmt.Errorf("unexpected reply from Redis: %v", result.Val())
+	}
+
+	success, ok := values[0].(int64)
+	if !ok {
+		return false, PendingTotals{}, fmt.Errorf("unexpected type for status from Redis: %T", values[0])
+	}
+	debit, ok := values[1].(int64)
+	if !ok {
+		return false, PendingTotals{}, fmt.Errorf("unexpected type for debit total from Redis: %T", values[1])
+	}
+	credit, ok := values[2].(int64)
+	if !ok {
+		return false, PendingTotals{}, fmt.Errorf("unexpected type for credit total from Redis: %T", values[2])
+	}
+
+	return success == 1, PendingTotals{Debit: fromMinorUnits(debit), Credit: fromMinorUnits(credit)}, nil
 }
 
-func (r *redisCounter) RemovePending(ctx context.Context, accountID string, amount decimal.Decimal) error {
-	key := fmt.Sprintf("%s:pending:%s", r.keyPrefix, accountID)
+// RemovePending drops the given transaction entries and subtracts exactly their
+// recorded amounts from their side. Unknown IDs are ignored, so repeated
+// rollbacks cannot drift.
+func (r *redisCounter) RemovePending(ctx context.Context, accountID string, transactionIDs ...string) error {
+	if len(transactionIDs) == 0 {
+		return nil
+	}
 
-	// Atomic decrement
-	script := `
-		local key = KEYS[1]
-		local amount = tonumber(ARGV[1])
-		
-		local current = redis.call('GET', key)
-		if current == false then
-			current = 0
-		else
-			current = tonumber(current)
-		end
-		
-		local newTotal = current - amount
-		if newTotal < 0 then
-			newTotal = 0
-		end
-		
-		redis.call('SET', key, newTotal)
-		redis.call('EXPIRE', key, ARGV[2])
-		
-		return newTotal
-	`
+	args := make([]interface{}, 0, len(transactionIDs)+1)
+	args = append(args, r.expiryMillis())
+	for _, id := range transactionIDs {
+		args = append(args, id)
+	}
 
-	_, err := r.client.Eval(ctx, script, []string{key}, amount.InexactFloat64(), r.keyExpiry).Result()
-	return err
+	return r.retry.do(ctx, func() error {
+		return removePendingScript.Run(ctx, r.client, []string{r.pendingKey(ctx, accountID)}, args...).Err()
+	})
+}
+
+// SetPending atomically replaces all pending entries of an account, e.g. when
+// rebuilding Redis from the sub_balances table. expectedVersion must be the
+// version read before the database snapshot was taken (or AnyVersion); if any
+// write happened since, nothing is changed and ErrStaleCounter is returned.
+func (r *redisCounter) SetPending(ctx context.Context, accountID string, expectedVersion int64, entries map[string]PendingEntry) error {
+	args := make([]interface{}, 0, len(entries)*3+2)
+	args = append(args, r.expiryMillis(), expectedVersion)
+	for transactionID, entry := range entries {
+		args = append(args, transactionID, entry.Type, toMinorUnits(entry.Amount))
+	}
+
+	var version int64
+	err := r.retry.do(ctx, func() error {
+		var err error
+		version, err = setPendingScript.Run(ctx, r.client, []string{r.pendingKey(ctx, accountID)}, args...).Int64()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if version < 0 {
+		return ErrStaleCounter
+	}
+	return nil
+}
+
+// SetPendingIfEquals replaces the entries only if the counter totals still
+// equal expected, the value a consistency check validated. It reports false
+// (and changes nothing) when the counter moved on in the meantime.
+func (r *redisCounter) SetPendingIfEquals(ctx context.Context, accountID string, expected PendingTotals, entries map[string]PendingEntry) (bool, error) {
+	args := make([]interface{}, 0, len(entries)*3+3)
+	args = append(args, r.expiryMillis(), toMinorUnits(expected.Debit), toMinorUnits(expected.Credit))
+	for transactionID, entry := range entries {
+		args = append(args, transactionID, entry.Type, toMinorUnits(entry.Amount))
+	}
+
+	var version int64
+	err := r.retry.do(ctx, func() error {
+		var err error
+		version, err = setPendingIfEqualsScript.Run(ctx, r.client, []string{r.pendingKey(ctx, accountID)}, args...).Int64()
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	return version >= 0, nil
+}
+
+// GetVersions reads the counter version of each account; accounts without a
+// counter (or with a legacy string counter) are at version 0
+func (r *redisCounter) GetVersions(ctx context.Context, accountIDs []string) (map[string]int64, error) {
+	versions := make(map[string]int64, len(accountIDs))
+
+	for start := 0; start < len(accountIDs); start += pendingBulkChunk {
+		end := start + pendingBulkChunk
+		if end > len(accountIDs) {
+			end = len(accountIDs)
+		}
+		chunk := accountIDs[start:end]
+
+		pipe := r.client.Pipeline()
+		cmds := make([]*redis.StringCmd, len(chunk))
+		for i, accountID := range chunk {
+			cmds[i] = pipe.HGet(ctx, r.pendingKey(ctx, accountID), pendingVersionField)
+		}
+		if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil && !isWrongType(err) {
+			return nil, err
+		}
+
+		for i, accountID := range chunk {
+			version, err := cmds[i].Int64()
+			if err != nil && err != redis.Nil && !isWrongType(err) {
+				return nil, fmt.Errorf("invalid counter version for account %s: %w", accountID, err)
+			}
+			versions[accountID] = version
+		}
+	}
+
+	return versions, nil
 }
 
 func (r *redisCounter) ClearPending(ctx context.Context, accountID string) error {
-	key := fmt.Sprintf("%s:pending:%s", r.keyPrefix, accountID)
-	return r.client.Del(ctx, key).Err()
+	return r.retry.do(ctx, func() error {
+		return r.client.Del(ctx, r.pendingKey(ctx, accountID)).Err()
+	})
+}
+
+// ScanPending walks the counter keys of the tenant bound to ctx with SCAN, one
+// page per call; pass the returned cursor back in until it is 0. TTL is -1 for
+// keys without expiry.
+func (r *redisCounter) ScanPending(ctx context.Context, cursor uint64, count int64) ([]PendingCounter, uint64, error) {
+	prefix := r.pendingKey(ctx, "")
+	keys, next, err := r.client.Scan(ctx, cursor, prefix+"*", count).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(keys) == 0 {
+		return nil, next, nil
+	}
+
+	pipe := r.client.Pipeline()
+	totalsCmds := make([]*redis.SliceCmd, len(keys))
+	entriesCmds := make([]*redis.IntCmd, len(keys))
+	ttlCmds := make([]*redis.DurationCmd, len(keys))
+	for i, key := range keys {
+		totalsCmds[i] = pipe.HMGet(ctx, key, pendingDebitField, pendingCreditField, pendingVersionField)
+		entriesCmds[i] = pipe.HLen(ctx, key)
+		ttlCmds[i] = pipe.PTTL(ctx, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil && !isWrongType(err) {
+		return nil, 0, err
+	}
+
+	counters := make([]PendingCounter, 0, len(keys))
+	for i, key := range keys {
+		counter := PendingCounter{
+			AccountID: strings.TrimPrefix(key, prefix),
+			TTL:       ttlCmds[i].Val(),
+		}
+
+		if err := totalsCmds[i].Err(); err != nil {
+			if !isWrongType(err) {
+				return nil, 0, err
+			}
+			counter.Legacy = true
+			counters = append(counters, counter)
+			continue
+		}
+
+		counter.Totals, err = parsePendingTotals(totalsCmds[i].Val())
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid pending totals in %s: %w", key, err)
+		}
+		if raw, ok := totalsCmds[i].Val()[2].(string); ok {
+			counter.Version, _ = strconv.ParseInt(raw, 10, 64)
+		}
+		// Field _debit, _credit dan _version bukan entry
+		counter.Entries = entriesCmds[i].Val()
+		for _, v := range totalsCmds[i].Val() {
+			if v != nil {
+				counter.Entries--
+			}
+		}
+		counters = append(counters, counter)
+	}
+
+	return counters, next, nil
 }
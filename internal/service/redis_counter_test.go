@@ -0,0 +1,420 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"sub-balance-demo/internal/config"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/shopspring/decimal"
+)
+
+func newTestCounter(t *testing.T) (RedisCounter, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	counter := NewRedisCounter(client, nil, &config.Config{
+		RedisKeyPrefix:       "test",
+		AppEnv:               "test",
+		RedisKeyExpiry:       "24h",
+		RedisMinRetryBackoff: "1ms",
+		RedisMaxRetryBackoff: "2ms",
+	})
+	return counter, server
+}
+
+func addPending(t *testing.T, counter RedisCounter, accountID string, id string, side string, amount string) {
+	t.Helper()
+	ok, _, err := counter.AddPending(context.Background(), accountID, id, side, decimal.RequireFromString(amount), decimal.RequireFromString("1000000000000000"))
+	if err != nil || !ok {
+		t.Fatalf("AddPending(%s %s %s) = %v, %v", id, side, amount, ok, err)
+	}
+}
+
+func TestRedisCounterRemovePendingIsExact(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries [][3]string // id, side, amount
+		remove  []string
+		debit   string
+		credit  string
+	}{
+		{
+			name:    "fractional amounts",
+			entries: [][3]string{{"a", "debit", "0.1"}, {"b", "debit", "0.2"}, {"c", "debit", "0.3"}},
+			remove:  []string{"a", "b"},
+			debit:   "0.3",
+			credit:  "0",
+		},
+		{
+			name:    "0.1 + 0.2 - 0.3",
+			entries: [][3]string{{"a", "credit", "0.1"}, {"b", "credit", "0.2"}, {"c", "debit", "0.3"}},
+			remove:  []string{"c"},
+			debit:   "0",
+			credit:  "0.3",
+		},
+		{
+			// 1e14 minor units: Redis formats -tonumber() as "-1e+14", which HINCRBY rejects
+			name:    "large amount",
+			entries: [][3]string{{"a", "debit", "1000000000000"}, {"b", "debit", "0.01"}},
+			remove:  []string{"a"},
+			debit:   "0.01",
+			credit:  "0",
+		},
+		{
+			// 2^53+1 minor units, not representable as a Lua number
+			name:    "amount above 2^53",
+			entries: [][3]string{{"a", "credit", "90071992547409.93"}},
+			remove:  []string{"a"},
+			debit:   "0",
+			credit:  "0",
+		},
+		{
+			name:    "both sides of the same id",
+			entries: [][3]string{{"a", "debit", "12.34"}, {"a", "credit", "56.78"}, {"b", "credit", "0.02"}},
+			remove:  []string{"a"},
+			debit:   "0",
+			credit:  "0.02",
+		},
+		{
+			name:    "unknown id",
+			entries: [][3]string{{"a", "debit", "5"}},
+			remove:  []string{"missing", "missing"},
+			debit:   "5",
+			credit:  "0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter, _ := newTestCounter(t)
+			ctx := context.Background()
+			for _, entry := range tt.entries {
+				addPending(t, counter, "acc-1", entry[0], entry[1], entry[2])
+			}
+
+			if err := counter.RemovePending(ctx, "acc-1", tt.remove...); err != nil {
+				t.Fatalf("RemovePending: %v", err)
+			}
+			totals, err := counter.GetPending(ctx, "acc-1")
+			if err != nil {
+				t.Fatalf("GetPending: %v", err)
+			}
+			if !totals.Debit.Equal(decimal.RequireFromString(tt.debit)) || !totals.Credit.Equal(decimal.RequireFromString(tt.credit)) {
+				t.Fatalf("totals = %s/%s, want %s/%s", totals.Debit, totals.Credit, tt.debit, tt.credit)
+			}
+		})
+	}
+}
+
+func TestRedisCounterRemovePendingKeepsVersionWhenEmpty(t *testing.T) {
+	counter, server := newTestCounter(t)
+	ctx := context.Background()
+	addPending(t, counter, "acc-1", "a", "debit", "0.1")
+	addPending(t, counter, "acc-1", "b", "credit", "0.2")
+
+	if err := counter.RemovePending(ctx, "acc-1", "a", "b"); err != nil {
+		t.Fatalf("RemovePending: %v", err)
+	}
+
+	key := "test:test:pending:acc-1"
+	fields, err := server.HKeys(key)
+	if err != nil {
+		t.Fatalf("HKeys: %v", err)
+	}
+	if len(fields) != 1 || fields[0] != pendingVersionField {
+		t.Fatalf("fields = %v, want only %s", fields, pendingVersionField)
+	}
+	versions, err := counter.GetVersions(ctx, []string{"acc-1"})
+	if err != nil {
+		t.Fatalf("GetVersions: %v", err)
+	}
+	if versions["acc-1"] != 3 {
+		t.Fatalf("version = %d, want 3", versions["acc-1"])
+	}
+}
+
+func TestRedisCounterAddPendingRejectsOverspend(t *testing.T) {
+	counter, _ := newTestCounter(t)
+	ctx := context.Background()
+	max := decimal.RequireFromString("0.3")
+
+	for _, id := range []string{"a", "b"} {
+		ok, _, err := counter.AddPending(ctx, "acc-1", id, "debit", decimal.RequireFromString("0.15"), max)
+		if err != nil || !ok {
+			t.Fatalf("AddPending(%s) = %v, %v", id, ok, err)
+		}
+	}
+	ok, totals, err := counter.AddPending(ctx, "acc-1", "c", "debit", decimal.RequireFromString("0.01"), max)
+	if err != nil {
+		t.Fatalf("AddPending: %v", err)
+	}
+	if ok {
+		t.Fatal("AddPending accepted a debit above the balance")
+	}
+	if !totals.Debit.Equal(max) {
+		t.Fatalf("debit = %s, want %s", totals.Debit, max)
+	}
+}
+
+func TestRedisCounterPendingEntries(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries [][3]string // id, side, amount
+		want    map[string]PendingEntry
+		debit   string
+		credit  string
+	}{
+		{
+			name:    "one entry per transaction",
+			entries: [][3]string{{"a", "debit", "1.5"}, {"b", "credit", "2"}},
+			want: map[string]PendingEntry{
+				"a": {Type: "debit", Amount: decimal.RequireFromString("1.5")},
+				"b": {Type: "credit", Amount: decimal.RequireFromString("2")},
+			},
+			debit:  "1.5",
+			credit: "2",
+		},
+		{
+			name:    "retried AddPending counted once",
+			entries: [][3]string{{"a", "debit", "1.5"}, {"a", "debit", "1.5"}},
+			want:    map[string]PendingEntry{"a": {Type: "debit", Amount: decimal.RequireFromString("1.5")}},
+			debit:   "1.5",
+			credit:  "0",
+		},
+		{
+			name:   "no counter",
+			want:   map[string]PendingEntry{},
+			debit:  "0",
+			credit: "0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter, _ := newTestCounter(t)
+			ctx := context.Background()
+			for _, entry := range tt.entries {
+				addPending(t, counter, "acc-1", entry[0], entry[1], entry[2])
+			}
+
+			entries, err := counter.GetPendingEntries(ctx, "acc-1")
+			if err != nil {
+				t.Fatalf("GetPendingEntries: %v", err)
+			}
+			if len(entries) != len(tt.want) {
+				t.Fatalf("entries = %v, want %v", entries, tt.want)
+			}
+			for id, want := range tt.want {
+				if got := entries[id]; got.Type != want.Type || !got.Amount.Equal(want.Amount) {
+					t.Fatalf("entry %s = %+v, want %+v", id, got, want)
+				}
+			}
+			totals, err := counter.GetPending(ctx, "acc-1")
+			if err != nil {
+				t.Fatalf("GetPending: %v", err)
+			}
+			if !totals.Debit.Equal(decimal.RequireFromString(tt.debit)) || !totals.Credit.Equal(decimal.RequireFromString(tt.credit)) {
+				t.Fatalf("totals = %s/%s, want %s/%s", totals.Debit, totals.Credit, tt.debit, tt.credit)
+			}
+		})
+	}
+}
+
+func TestRedisCounterLegacyStringCounter(t *testing.T) {
+	counter, server := newTestCounter(t)
+	ctx := context.Background()
+	server.Set("test:test:pending:legacy", "12.5")
+	addPending(t, counter, "acc-1", "a", "credit", "1")
+
+	// Bulk read melewati counter lama, recovery yang membangunnya ulang
+	bulk, err := counter.GetPendingBulk(ctx, []string{"legacy", "acc-1", "missing"})
+	if err != nil {
+		t.Fatalf("GetPendingBulk: %v", err)
+	}
+	if _, ok := bulk["legacy"]; ok || len(bulk) != 2 || !bulk["acc-1"].Credit.Equal(decimal.NewFromInt(1)) || !bulk["missing"].Debit.IsZero() {
+		t.Fatalf("GetPendingBulk = %v", bulk)
+	}
+
+	// AddPending membuang counter lama dan mulai dari hash baru
+	addPending(t, counter, "legacy", "b", "debit", "3")
+	totals, err := counter.GetPending(ctx, "legacy")
+	if err != nil {
+		t.Fatalf("GetPending: %v", err)
+	}
+	if !totals.Debit.Equal(decimal.NewFromInt(3)) || !totals.Credit.IsZero() {
+		t.Fatalf("totals = %s/%s, want 3/0", totals.Debit, totals.Credit)
+	}
+}
+
+func TestRedisCounterSetPendingVersion(t *testing.T) {
+	rebuilt := map[string]PendingEntry{"x": {Type: "debit", Amount: decimal.RequireFromString("7")}}
+	tests := []struct {
+		name        string
+		expected    func(snapshot int64) int64
+		wantErr     error
+		wantDebit   string
+		wantVersion int64
+	}{
+		{
+			name:        "snapshot still current",
+			expected:    func(snapshot int64) int64 { return snapshot + 1 },
+			wantDebit:   "7",
+			wantVersion: 3,
+		},
+		{
+			// AddPending setelah snapshot diambil: rebuild ditolak
+			name:        "write after snapshot",
+			expected:    func(snapshot int64) int64 { return snapshot },
+			wantErr:     ErrStaleCounter,
+			wantDebit:   "1",
+			wantVersion: 2,
+		},
+		{
+			name:        "any version",
+			expected:    func(snapshot int64) int64 { return AnyVersion },
+			wantDebit:   "7",
+			wantVersion: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter, _ := newTestCounter(t)
+			ctx := context.Background()
+			addPending(t, counter, "acc-1", "a", "credit", "5")
+			versions, err := counter.GetVersions(ctx, []string{"acc-1"})
+			if err != nil {
+				t.Fatalf("GetVersions: %v", err)
+			}
+			addPending(t, counter, "acc-1", "b", "debit", "1")
+
+			err = counter.SetPending(ctx, "acc-1", tt.expected(versions["acc-1"]), rebuilt)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("SetPending = %v, want %v", err, tt.wantErr)
+			}
+			totals, _ := counter.GetPending(ctx, "acc-1")
+			versions, _ = counter.GetVersions(ctx, []string{"acc-1"})
+			if !totals.Debit.Equal(decimal.RequireFromString(tt.wantDebit)) || versions["acc-1"] != tt.wantVersion {
+				t.Fatalf("debit = %s, version = %d; want %s, %d", totals.Debit, versions["acc-1"], tt.wantDebit, tt.wantVersion)
+			}
+		})
+	}
+}
+
+func TestRedisCounterGetVersions(t *testing.T) {
+	counter, server := newTestCounter(t)
+	ctx := context.Background()
+	server.Set("test:test:pending:legacy", "12.5")
+	addPending(t, counter, "acc-1", "a", "credit", "1")
+	addPending(t, counter, "acc-1", "b", "credit", "1")
+	if err := counter.SetPending(ctx, "fresh", 0, map[string]PendingEntry{"c": {Type: "debit", Amount: decimal.NewFromInt(1)}}); err != nil {
+		t.Fatalf("SetPending on a missing counter at version 0: %v", err)
+	}
+
+	versions, err := counter.GetVersions(ctx, []string{"acc-1", "fresh", "legacy", "missing"})
+	if err != nil {
+		t.Fatalf("GetVersions: %v", err)
+	}
+	want := map[string]int64{"acc-1": 2, "fresh": 1, "legacy": 0, "missing": 0}
+	for id, version := range want {
+		if versions[id] != version {
+			t.Fatalf("versions = %v, want %v", versions, want)
+		}
+	}
+}
+
+func TestRedisCounterSetPendingIfEquals(t *testing.T) {
+	repaired := map[string]PendingEntry{"x": {Type: "credit", Amount: decimal.RequireFromString("9")}}
+	tests := []struct {
+		name       string
+		setup      func(t *testing.T, counter RedisCounter, server *miniredis.Miniredis)
+		expected   PendingTotals
+		wantOK     bool
+		wantCredit string
+	}{
+		{
+			name: "totals still validated",
+			setup: func(t *testing.T, counter RedisCounter, server *miniredis.Miniredis) {
+				addPending(t, counter, "acc-1", "a", "credit", "0.1")
+			},
+			expected:   PendingTotals{Debit: decimal.Zero, Credit: decimal.RequireFromString("0.1")},
+			wantOK:     true,
+			wantCredit: "9",
+		},
+		{
+			// Transaksi baru masuk di antara check dan repair
+			name: "counter moved on",
+			setup: func(t *testing.T, counter RedisCounter, server *miniredis.Miniredis) {
+				addPending(t, counter, "acc-1", "a", "credit", "0.1")
+				addPending(t, counter, "acc-1", "b", "credit", "0.2")
+			},
+			expected:   PendingTotals{Debit: decimal.Zero, Credit: decimal.RequireFromString("0.1")},
+			wantCredit: "0.3",
+		},
+		{
+			name:       "missing counter validated as zero",
+			expected:   PendingTotals{Debit: decimal.Zero, Credit: decimal.Zero},
+			wantOK:     true,
+			wantCredit: "9",
+		},
+		{
+			name:       "missing counter validated as non-zero",
+			expected:   PendingTotals{Debit: decimal.Zero, Credit: decimal.RequireFromString("0.1")},
+			wantCredit: "0",
+		},
+		{
+			name: "legacy string counter",
+			setup: func(t *testing.T, counter RedisCounter, server *miniredis.Miniredis) {
+				server.Set("test:test:pending:acc-1", "0")
+			},
+			expected: PendingTotals{Debit: decimal.Zero, Credit: decimal.Zero},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter, server := newTestCounter(t)
+			ctx := context.Background()
+			if tt.setup != nil {
+				tt.setup(t, counter, server)
+			}
+
+			ok, err := counter.SetPendingIfEquals(ctx, "acc-1", tt.expected, repaired)
+			if err != nil || ok != tt.wantOK {
+				t.Fatalf("SetPendingIfEquals = %v, %v; want %v", ok, err, tt.wantOK)
+			}
+			if tt.wantCredit == "" {
+				return
+			}
+			totals, err := counter.GetPending(ctx, "acc-1")
+			if err != nil {
+				t.Fatalf("GetPending: %v", err)
+			}
+			if !totals.Credit.Equal(decimal.RequireFromString(tt.wantCredit)) {
+				t.Fatalf("credit = %s, want %s", totals.Credit, tt.wantCredit)
+			}
+		})
+	}
+}
+
+func TestRedisCounterSetPendingIfEqualsBumpsVersion(t *testing.T) {
+	counter, _ := newTestCounter(t)
+	ctx := context.Background()
+	addPending(t, counter, "acc-1", "a", "debit", "2")
+	before, _ := counter.GetVersions(ctx, []string{"acc-1"})
+
+	ok, err := counter.SetPendingIfEquals(ctx, "acc-1", PendingTotals{Debit: decimal.NewFromInt(2), Credit: decimal.Zero}, nil)
+	if err != nil || !ok {
+		t.Fatalf("SetPendingIfEquals = %v, %v", ok, err)
+	}
+	// Repair juga menaikkan version, jadi rebuild dari snapshot sebelumnya ditolak
+	if err := counter.SetPending(ctx, "acc-1", before["acc-1"], nil); !errors.Is(err, ErrStaleCounter) {
+		t.Fatalf("SetPending with the version before the repair = %v, want ErrStaleCounter", err)
+	}
+	entries, _ := counter.GetPendingEntries(ctx, "acc-1")
+	if len(entries) != 0 {
+		t.Fatalf("entries = %v, want none after repairing to an empty set", entries)
+	}
+}
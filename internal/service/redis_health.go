@@ -6,7 +6,7 @@ import (
 	"sync"
 	"time"
 
-	"github.com/go-redis/redis/v8"
+	"github.com/redis/go-redis/v9"
 )
 
 type RedisHealthChecker struct {
@@ -14,13 +14,16 @@ type RedisHealthChecker struct {
 	isHealthy     bool
 	mutex         sync.RWMutex
 	checkInterval time.Duration
+	recoveryHooks []func()
+	history       *HealthHistory
 }
 
-func NewRedisHealthChecker(client *redis.Client, checkInterval time.Duration) *RedisHealthChecker {
+func NewRedisHealthChecker(client *redis.Client, checkInterval time.Duration, history *HealthHistory) *RedisHealthChecker {
 	return &RedisHealthChecker{
 		client:        client,
 		isHealthy:     true,
 		checkInterval: checkInterval,
+		history:       history,
 	}
 }
 
@@ -30,6 +33,14 @@ func (r *RedisHealthChecker) IsHealthy() bool {
 	return r.isHealthy
 }
 
+// OnRecovery registers fn to run (in its own goroutine) whenever Redis comes
+// back online after being marked down
+func (r *RedisHealthChecker) OnRecovery(fn func()) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.recoveryHooks = append(r.recoveryHooks, fn)
+}
+
 func (r *RedisHealthChecker) StartHealthCheck(ctx context.Context) {
 	ticker := time.NewTicker(r.checkInterval)
 	defer ticker.Stop()
@@ -57,12 +68,22 @@ func (r *RedisHealthChecker) checkHealth() {
 	wasHealthy := r.isHealthy
 	r.isHealthy = (err == nil)
 
-	if !wasHealthy && r.isHealthy {
+	recovered := !wasHealthy && r.isHealthy
+	if recovered {
 		log.Println("✅ Redis is back online")
+		r.history.RecordUp(HealthComponentRedis)
 	} else if wasHealthy && !r.isHealthy {
 		log.Printf("❌ Redis is down: %v", err)
+		r.history.RecordDown(HealthComponentRedis, err)
 	}
+	hooks := r.recoveryHooks
 	r.mutex.Unlock()
+
+	if recovered {
+		for _, hook := range hooks {
+			go hook()
+		}
+	}
 }
 
 func (r *RedisHealthChecker) TestConnection(ctx context.Context) error {
@@ -0,0 +1,158 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RedisCommandStats summarizes one Redis command (or "dial" / "pipeline")
+type RedisCommandStats struct {
+	Calls          int64   `json:"calls"`
+	Errors         int64   `json:"errors"`
+	AvgLatencyMs   float64 `json:"avg_latency_ms"`
+	MaxLatencyMs   float64 `json:"max_latency_ms"`
+	TotalLatencyMs float64 `json:"total_latency_ms"`
+}
+
+type redisCommandMetrics struct {
+	calls        int64
+	errors       int64
+	totalLatency time.Duration
+	maxLatency   time.Duration
+}
+
+// operationMetrics aggregates latency and errors per operation name
+type operationMetrics struct {
+	operations map[string]*redisCommandMetrics
+	mutex      sync.Mutex
+}
+
+func newOperationMetrics() *operationMetrics {
+	return &operationMetrics{operations: make(map[string]*redisCommandMetrics)}
+}
+
+// record counts a call; a zero latency only counts the error
+func (m *operationMetrics) record(name string, latency time.Duration, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	metrics, ok := m.operations[name]
+	if !ok {
+		metrics = &redisCommandMetrics{}
+		m.operations[name] = metrics
+	}
+
+	if isRedisError(err) {
+		metrics.errors++
+	}
+	if latency == 0 {
+		return
+	}
+	metrics.calls++
+	metrics.totalLatency += latency
+	if latency > metrics.maxLatency {
+		metrics.maxLatency = latency
+	}
+}
+
+func (m *operationMetrics) snapshot() map[string]RedisCommandStats {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	snapshot := make(map[string]RedisCommandStats, len(m.operations))
+	for name, metrics := range m.operations {
+		stats := RedisCommandStats{
+			Calls:          metrics.calls,
+			Errors:         metrics.errors,
+			MaxLatencyMs:   float64(metrics.maxLatency) / float64(time.Millisecond),
+			TotalLatencyMs: float64(metrics.totalLatency) / float64(time.Millisecond),
+		}
+		if metrics.calls > 0 {
+			stats.AvgLatencyMs = float64(metrics.totalLatency) / float64(metrics.calls) / float64(time.Millisecond)
+		}
+		snapshot[name] = stats
+	}
+	return snapshot
+}
+
+// RedisMetricsHook is a go-redis hook that records latency and errors per
+// command. It wraps every command the client sends, so it is also the place
+// to open tracing spans around counter operations.
+type RedisMetricsHook struct {
+	commands *operationMetrics
+}
+
+func NewRedisMetricsHook() *RedisMetricsHook {
+	return &RedisMetricsHook{commands: newOperationMetrics()}
+}
+
+func (h *RedisMetricsHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		start := time.Now()
+		conn, err := next(ctx, network, addr)
+		h.commands.record("dial", time.Since(start), err)
+		return conn, err
+	}
+}
+
+func (h *RedisMetricsHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		ctx, span := tracer.Start(ctx, "redis."+cmd.Name(),
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(semconv.DBSystemRedis),
+		)
+		start := time.Now()
+		err := next(ctx, cmd)
+		h.commands.record(cmd.Name(), time.Since(start), err)
+		endSpan(span, redisError(err))
+		return err
+	}
+}
+
+// ProcessPipelineHook records the pipeline round trip as a whole, plus an
+// error for every command in it that failed
+func (h *RedisMetricsHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		ctx, span := tracer.Start(ctx, "redis.pipeline",
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(semconv.DBSystemRedis, attribute.Int("redis.pipeline_length", len(cmds))),
+		)
+		start := time.Now()
+		err := next(ctx, cmds)
+		h.commands.record("pipeline", time.Since(start), err)
+		endSpan(span, redisError(err))
+
+		for _, cmd := range cmds {
+			if isRedisError(cmd.Err()) {
+				h.commands.record(cmd.Name(), 0, cmd.Err())
+			}
+		}
+		return err
+	}
+}
+
+// isRedisError ignores redis.Nil, which only means "no value"
+func isRedisError(err error) bool {
+	return err != nil && !errors.Is(err, redis.Nil)
+}
+
+// redisError returns err unless it is redis.Nil
+func redisError(err error) error {
+	if !isRedisError(err) {
+		return nil
+	}
+	return err
+}
+
+// Snapshot returns the stats per command name
+func (h *RedisMetricsHook) Snapshot() map[string]RedisCommandStats {
+	return h.commands.snapshot()
+}
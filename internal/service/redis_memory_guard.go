@@ -0,0 +1,155 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisMemoryStats is the last memory reading of the guard
+type RedisMemoryStats struct {
+	UsedMemory    int64     `json:"used_memory"`
+	MaxMemory     int64     `json:"max_memory"` // 0 = no limit configured
+	UsagePercent  float64   `json:"usage_percent"`
+	UnderPressure bool      `json:"under_pressure"`
+	CheckedAt     time.Time `json:"checked_at"`
+}
+
+// RedisMemoryGuard periodically compares INFO memory against maxmemory. Close
+// to the limit Redis starts evicting keys, and an evicted pending counter
+// silently allows overspend, so new transactions are routed to the DB-fallback
+// path until usage drops again. A nil *RedisMemoryGuard never reports pressure.
+type RedisMemoryGuard struct {
+	client        *redis.Client
+	thresholdPct  float64
+	checkInterval time.Duration
+	notifier      *WebhookNotifier
+	stats         RedisMemoryStats
+	mutex         sync.RWMutex
+}
+
+func NewRedisMemoryGuard(client *redis.Client, thresholdPct int, checkInterval time.Duration, notifier *WebhookNotifier) *RedisMemoryGuard {
+	return &RedisMemoryGuard{
+		client:        client,
+		thresholdPct:  float64(thresholdPct),
+		checkInterval: checkInterval,
+		notifier:      notifier,
+	}
+}
+
+func (g *RedisMemoryGuard) UnderPressure() bool {
+	if g == nil {
+		return false
+	}
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+	return g.stats.UnderPressure
+}
+
+func (g *RedisMemoryGuard) Stats() RedisMemoryStats {
+	if g == nil {
+		return RedisMemoryStats{}
+	}
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+	return g.stats
+}
+
+func (g *RedisMemoryGuard) Start(ctx context.Context) {
+	ticker := time.NewTicker(g.checkInterval)
+	defer ticker.Stop()
+
+	log.Println("Redis memory guard started")
+	g.check(ctx)
+
+	for {
+		select {
+		case <-ticker.C:
+			g.check(ctx)
+		case <-ctx.Done():
+			log.Println("Redis memory guard stopped")
+			return
+		}
+	}
+}
+
+func (g *RedisMemoryGuard) check(ctx context.Context) {
+	checkCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	info, err := g.client.Info(checkCtx, "memory").Result()
+	if err != nil {
+		// Redis down ditangani health checker, status pressure dibiarkan
+		log.Printf("Redis memory check failed: %v", err)
+		return
+	}
+
+	stats, err := parseMemoryInfo(info)
+	if err != nil {
+		log.Printf("Redis memory check failed: %v", err)
+		return
+	}
+	stats.CheckedAt = time.Now()
+	if stats.MaxMemory > 0 {
+		stats.UsagePercent = float64(stats.UsedMemory) / float64(stats.MaxMemory) * 100
+		stats.UnderPressure = stats.UsagePercent >= g.thresholdPct
+	}
+
+	g.mutex.Lock()
+	wasUnderPressure := g.stats.UnderPressure
+	g.stats = stats
+	g.mutex.Unlock()
+
+	if stats.UnderPressure && !wasUnderPressure {
+		log.Printf("⚠️ Redis memory at %.1f%% of maxmemory, routing new transactions to database fallback", stats.UsagePercent)
+		err := g.notifier.Notify(ctx, "redis.memory_pressure", stats)
+		if err != nil {
+			log.Printf("Failed to send Redis memory pressure alert: %v", err)
+		}
+	} else if !stats.UnderPressure && wasUnderPressure {
+		log.Printf("✅ Redis memory back to %.1f%% of maxmemory", stats.UsagePercent)
+		err := g.notifier.Notify(ctx, "redis.memory_recovered", stats)
+		if err != nil {
+			log.Printf("Failed to send Redis memory recovery alert: %v", err)
+		}
+	}
+}
+
+func parseMemoryInfo(info string) (RedisMemoryStats, error) {
+	var stats RedisMemoryStats
+	found := 0
+	for _, line := range strings.Split(info, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if !ok {
+			continue
+		}
+
+		var target *int64
+		switch key {
+		case "used_memory":
+			target = &stats.UsedMemory
+		case "maxmemory":
+			target = &stats.MaxMemory
+		default:
+			continue
+		}
+
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return RedisMemoryStats{}, fmt.Errorf("invalid %s value %q", key, value)
+		}
+		*target = parsed
+		found++
+	}
+
+	if found < 2 {
+		return RedisMemoryStats{}, fmt.Errorf("INFO memory missing used_memory or maxmemory")
+	}
+	return stats, nil
+}
@@ -0,0 +1,36 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrNamespaceOwned is returned when the key namespace belongs to another APP_ENV
+var ErrNamespaceOwned = errors.New("redis key namespace owned by another environment")
+
+// ClaimRedisNamespace records appEnv as the owner of namespace, or verifies the
+// existing owner matches. It keeps e.g. staging from clobbering production
+// counters when both point at a shared Redis with the same namespace.
+func ClaimRedisNamespace(ctx context.Context, client *redis.Client, namespace, appEnv string) error {
+	key := fmt.Sprintf("%s:_owner", namespace)
+
+	claimed, err := client.SetNX(ctx, key, appEnv, 0).Result()
+	if err != nil {
+		return fmt.Errorf("failed to claim redis namespace %s: %w", namespace, err)
+	}
+	if claimed {
+		return nil
+	}
+
+	owner, err := client.Get(ctx, key).Result()
+	if err != nil {
+		return fmt.Errorf("failed to read owner of redis namespace %s: %w", namespace, err)
+	}
+	if owner != appEnv {
+		return fmt.Errorf("%w: %s is owned by %q, this instance runs as %q", ErrNamespaceOwned, namespace, owner, appEnv)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	"sub-balance-demo/internal/config"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisRetryBackoff parses the configured min/max retry backoff, shared by the
+// Redis client options and the counter-level retries
+func RedisRetryBackoff(cfg *config.Config) (time.Duration, time.Duration) {
+	minBackoff, err := time.ParseDuration(cfg.RedisMinRetryBackoff)
+	if err != nil || minBackoff < 0 {
+		log.Printf("Invalid Redis min retry backoff %q, using default 8ms", cfg.RedisMinRetryBackoff)
+		minBackoff = 8 * time.Millisecond
+	}
+
+	maxBackoff, err := time.ParseDuration(cfg.RedisMaxRetryBackoff)
+	if err != nil || maxBackoff < minBackoff {
+		log.Printf("Invalid Redis max retry backoff %q, using default 512ms", cfg.RedisMaxRetryBackoff)
+		maxBackoff = 512 * time.Millisecond
+		if maxBackoff < minBackoff {
+			maxBackoff = minBackoff
+		}
+	}
+
+	return minBackoff, maxBackoff
+}
+
+// IsRetryableRedisError reports whether err is a transient failure worth
+// retrying: network errors and timeouts, an exhausted pool, and the replies
+// Redis sends while loading, failing over or resharding. Script errors, nil
+// replies and cancelled contexts are final.
+func IsRetryableRedisError(err error) bool {
+	if err == nil || err == redis.Nil {
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	// go-redis tidak mengekspor ErrPoolTimeout, cukup dicocokkan pesannya
+	msg := err.Error()
+	if msg == "redis: connection pool timeout" || msg == "ERR max number of clients reached" {
+		return true
+	}
+	for _, prefix := range []string{"LOADING ", "READONLY ", "MASTERDOWN ", "TRYAGAIN ", "CLUSTERDOWN ", "BUSY "} {
+		if strings.HasPrefix(msg, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// retryPolicy retries transient Redis errors with exponential backoff and
+// jitter, so a single network blip is absorbed before it reaches the circuit
+// breaker. Counter operations are idempotent (entries are keyed by
+// transaction ID, rebuilds are versioned), which makes repeating them safe.
+type retryPolicy struct {
+	retries    int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	jitter     float64 // fraction of the backoff, 0..1
+}
+
+func newRetryPolicy(cfg *config.Config) retryPolicy {
+	minBackoff, maxBackoff := RedisRetryBackoff(cfg)
+
+	jitterPercent := cfg.RedisRetryJitterPercent
+	if jitterPercent < 0 || jitterPercent > 100 {
+		log.Printf("Invalid Redis retry jitter %d%%, using default 20%%", jitterPercent)
+		jitterPercent = 20
+	}
+
+	retries := cfg.RedisCounterRetries
+	if retries < 0 {
+		retries = 0
+	}
+
+	return retryPolicy{
+		retries:    retries,
+		minBackoff: minBackoff,
+		maxBackoff: maxBackoff,
+		jitter:     float64(jitterPercent) / 100,
+	}
+}
+
+// backoff returns the wait before retry n (0-based): min doubled per attempt,
+// capped at max, then spread by +/- jitter
+func (p retryPolicy) backoff(n int) time.Duration {
+	d := p.minBackoff
+	for i := 0; i < n && d < p.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.maxBackoff {
+		d = p.maxBackoff
+	}
+
+	if p.jitter > 0 && d > 0 {
+		spread := float64(d) * p.jitter
+		d += time.Duration(spread * (2*rand.Float64() - 1))
+	}
+	return d
+}
+
+// do runs fn, repeating it while it fails with a retryable error
+func (p retryPolicy) do(ctx context.Context, fn func() error) error {
+	err := fn()
+	for attempt := 0; attempt < p.retries && IsRetryableRedisError(err); attempt++ {
+		timer := time.NewTimer(p.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		err = fn()
+	}
+	return err
+}
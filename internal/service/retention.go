@@ -0,0 +1,185 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"sub-balance-demo/internal/config"
+	"sub-balance-demo/internal/repository"
+)
+
+// ErrRetentionRunning is returned when a purge is requested while another one is in progress
+var ErrRetentionRunning = errors.New("retention purge already running")
+
+// ErrUnknownRetentionTable is returned for tables not covered by the retention policy
+var ErrUnknownRetentionTable = errors.New("table is not covered by the retention policy")
+
+// RetentionPolicy is the retention configured for one table
+type RetentionPolicy struct {
+	Table     string `json:"table"`
+	Retention string `json:"retention"`
+	Enabled   bool   `json:"enabled"`
+	retention time.Duration
+}
+
+// RetentionResult reports what one purge did to a table
+type RetentionResult struct {
+	Table       string `json:"table"`
+	SoftDeleted int64  `json:"soft_deleted"`
+	Purged      int64  `json:"purged"`
+}
+
+// RetentionService expires old rows in two steps: rows past their table's
+// retention are soft-deleted (hidden but recoverable), and soft-deleted rows
+// older than the grace period are purged for good.
+type RetentionService struct {
+	repo        repository.RetentionRepository
+	policies    map[string]RetentionPolicy
+	gracePeriod time.Duration
+	batchSize   int
+	running     sync.Mutex
+}
+
+func NewRetentionService(repo repository.RetentionRepository, cfg *config.Config) *RetentionService {
+	gracePeriod, err := time.ParseDuration(cfg.RetentionPurgeGracePeriod)
+	if err != nil {
+		log.Printf("Invalid retention purge grace period, using default 168h: %v", err)
+		gracePeriod = 168 * time.Hour
+	}
+
+	batchSize := cfg.RetentionBatchSize
+	if batchSize <= 0 {
+		batchSize = 1000
+	}
+
+	configured := map[string]string{
+		repository.SubBalance{}.TableName():           cfg.RetentionSubBalances,
+		repository.ReconciliationRecord{}.TableName(): cfg.RetentionReconciliations,
+		repository.OutboxEvent{}.TableName():          cfg.RetentionOutbox,
+	}
+
+	policies := make(map[string]RetentionPolicy, len(repository.RetentionTables))
+	for _, table := range repository.RetentionTables {
+		retention, err := time.ParseDuration(configured[table.Name])
+		if err != nil {
+			log.Printf("Invalid retention for %s, keeping rows forever: %v", table.Name, err)
+			retention = 0
+		}
+		policies[table.Name] = RetentionPolicy{
+			Table:     table.Name,
+			Retention: retention.String(),
+			Enabled:   retention > 0,
+			retention: retention,
+		}
+	}
+
+	return &RetentionService{
+		repo:        repo,
+		policies:    policies,
+		gracePeriod: gracePeriod,
+		batchSize:   batchSize,
+	}
+}
+
+// Policies returns the retention of every covered table
+func (r *RetentionService) Policies() []RetentionPolicy {
+	policies := make([]RetentionPolicy, 0, len(repository.RetentionTables))
+	for _, table := range repository.RetentionTables {
+		policies = append(policies, r.policies[table.Name])
+	}
+	return policies
+}
+
+// Start runs the purge for every table on the given interval until ctx is cancelled
+func (r *RetentionService) Start(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			_, err := r.PurgeAll(ctx)
+			if err != nil && !errors.Is(err, ErrRetentionRunning) {
+				log.Printf("Retention purge failed: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// PurgeAll applies the retention policy to every covered table
+func (r *RetentionService) PurgeAll(ctx context.Context) ([]RetentionResult, error) {
+	if !r.running.TryLock() {
+		return nil, ErrRetentionRunning
+	}
+	defer r.running.Unlock()
+
+	results := make([]RetentionResult, 0, len(repository.RetentionTables))
+	for _, table := range repository.RetentionTables {
+		result, err := r.purgeTable(ctx, table)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// Purge applies the retention policy to a single table
+func (r *RetentionService) Purge(ctx context.Context, tableName string) (RetentionResult, error) {
+	table, ok := repository.LookupRetentionTable(tableName)
+	if !ok {
+		return RetentionResult{}, fmt.Errorf("%w: %s", ErrUnknownRetentionTable, tableName)
+	}
+
+	if !r.running.TryLock() {
+		return RetentionResult{}, ErrRetentionRunning
+	}
+	defer r.running.Unlock()
+
+	return r.purgeTable(ctx, table)
+}
+
+func (r *RetentionService) purgeTable(ctx context.Context, table repository.RetentionTable) (RetentionResult, error) {
+	result := RetentionResult{Table: table.Name}
+	now := time.Now()
+
+	// 1. Soft delete row yang melewati retention, per batch supaya lock tetap pendek
+	if policy := r.policies[table.Name]; policy.Enabled {
+		before := now.Add(-policy.retention)
+		for {
+			affected, err := r.repo.SoftDeleteExpired(ctx, table, before, r.batchSize)
+			if err != nil {
+				return result, err
+			}
+			result.SoftDeleted += affected
+			if affected < int64(r.batchSize) {
+				break
+			}
+		}
+	}
+
+	// 2. Purge row yang sudah soft-deleted lebih lama dari grace period; juga
+	// berlaku untuk row yang di-soft-delete manual meskipun retention nonaktif
+	before := now.Add(-r.gracePeriod)
+	for {
+		affected, err := r.repo.PurgeDeleted(ctx, table, before, r.batchSize)
+		if err != nil {
+			return result, err
+		}
+		result.Purged += affected
+		if affected < int64(r.batchSize) {
+			break
+		}
+	}
+
+	if result.SoftDeleted > 0 || result.Purged > 0 {
+		log.Printf("Retention for %s: soft_deleted=%d, purged=%d", table.Name, result.SoftDeleted, result.Purged)
+	}
+	return result, nil
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// SettlementStats summarizes settlement worker throughput
+type SettlementStats struct {
+	Workers              int       `json:"workers"`
+	BatchSize            int       `json:"batch_size"`
+	Runs                 int64     `json:"runs"`
+	RunErrors            int64     `json:"run_errors"`
+	TotalRunSeconds      float64   `json:"total_run_seconds"`
+	AccountsSettled      int64     `json:"accounts_settled"`
+	AccountsFailed       int64     `json:"accounts_failed"`
+	TransactionsSettled  int64     `json:"transactions_settled"`
+	TransactionsRejected int64     `json:"transactions_rejected"`
+	LastRunAt            time.Time `json:"last_run_at"`
+	LastRunDuration      string    `json:"last_run_duration"`
+	LastRunBatches       int       `json:"last_run_batches"`
+	LastRunTPS           float64   `json:"last_run_tps"`
+	// LastSuccessAt is the last time the worker drained the pending queue, either
+	// by completing a run or by finding nothing to settle. It only stops moving
+	// when settlement is stuck or failing.
+	LastSuccessAt time.Time `json:"last_success_at"`
+}
+
+// settlementRun is the outcome of one processSettlement call that found work
+type settlementRun struct {
+	// Counter di awal struct supaya tetap 64-bit aligned untuk sync/atomic
+	accountsSettled      int64
+	accountsFailed       int64
+	transactionsSettled  int64
+	transactionsRejected int64
+	duration             time.Duration
+	batches              int
+	// completed is false when the run was interrupted by shutdown or stopped
+	// because the next batch could not be read
+	completed bool
+}
+
+type settlementMetrics struct {
+	stats SettlementStats
+	mutex sync.RWMutex
+}
+
+func newSettlementMetrics(workers int, batchSize int) *settlementMetrics {
+	if workers <= 0 {
+		workers = 1
+	}
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+	return &settlementMetrics{stats: SettlementStats{Workers: workers, BatchSize: batchSize}}
+}
+
+func (m *settlementMetrics) record(run settlementRun) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+	m.stats.Runs++
+	m.stats.TotalRunSeconds += run.duration.Seconds()
+	m.stats.AccountsSettled += run.accountsSettled
+	m.stats.AccountsFailed += run.accountsFailed
+	m.stats.TransactionsSettled += run.transactionsSettled
+	m.stats.TransactionsRejected += run.transactionsRejected
+	m.stats.LastRunAt = now
+	m.stats.LastRunDuration = run.duration.String()
+	m.stats.LastRunBatches = run.batches
+	m.stats.LastRunTPS = 0
+	if run.duration > 0 {
+		m.stats.LastRunTPS = float64(run.transactionsSettled) / run.duration.Seconds()
+	}
+	if run.completed {
+		m.stats.LastSuccessAt = now
+	} else {
+		m.stats.RunErrors++
+	}
+}
+
+// recordIdle marks a poll that found no pending transactions as successful, so
+// an idle system does not look like a stuck worker
+func (m *settlementMetrics) recordIdle() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.stats.LastSuccessAt = time.Now()
+}
+
+// recordError counts a run that failed before settling anything
+func (m *settlementMetrics) recordError() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.stats.RunErrors++
+}
+
+func (m *settlementMetrics) snapshot() SettlementStats {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.stats
+}
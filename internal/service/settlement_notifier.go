@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"sub-balance-demo/internal/eventstream"
+	"sub-balance-demo/internal/logmask"
+)
+
+// SettlementNotifier forwards settlement.completed events from the event
+// stream to another transport (RabbitMQ), as its own consumer group so it
+// neither slows down nor is slowed down by the webhooks
+type SettlementNotifier struct {
+	consumer   *eventstream.Consumer
+	publisher  eventstream.Publisher
+	retryDelay time.Duration
+	maxDelay   time.Duration
+}
+
+// NewSettlementNotifier creates a notifier; a failed publish is retried after
+// retryDelay, doubling up to maxDelay
+func NewSettlementNotifier(consumer *eventstream.Consumer, publisher eventstream.Publisher, retryDelay time.Duration, maxDelay time.Duration) *SettlementNotifier {
+	return &SettlementNotifier{
+		consumer:   consumer,
+		publisher:  publisher,
+		retryDelay: retryDelay,
+		maxDelay:   maxDelay,
+	}
+}
+
+// Start consumes the event stream until ctx is cancelled, reading again after
+// a pause whenever the stream cannot be read
+func (n *SettlementNotifier) Start(ctx context.Context) {
+	if closer, ok := n.publisher.(interface{ Close() }); ok {
+		defer closer.Close()
+	}
+	log.Println("Settlement notifier started")
+	for {
+		err := n.consumer.Run(ctx, n.forward)
+		if ctx.Err() != nil {
+			log.Println("Settlement notifier stopped")
+			return
+		}
+		log.Printf("Settlement notifier consumer failed, retrying in %s: %v", consumerRetryDelay, err)
+		select {
+		case <-time.After(consumerRetryDelay):
+		case <-ctx.Done():
+			log.Println("Settlement notifier stopped")
+			return
+		}
+	}
+}
+
+// forward publishes a settlement.completed event, retrying until the
+// transport confirms it. Blocking keeps notifications in order and the entry
+// unacknowledged in the stream until then; other event types are skipped.
+func (n *SettlementNotifier) forward(ctx context.Context, event eventstream.Event) error {
+	if event.Type != eventstream.SettlementCompleted {
+		return nil
+	}
+	if event.EventID == "" {
+		event.EventID = event.ID
+	}
+
+	delay := n.retryDelay
+	for {
+		err := n.publisher.Publish(ctx, event)
+		if err == nil {
+			return nil
+		}
+		log.Printf("Failed to publish settlement %s of account %s, retrying in %s: %v",
+			event.SettlementID, logmask.Account(event.AccountID), delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		delay = min(delay*2, n.maxDelay)
+	}
+}
@@ -0,0 +1,62 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"sub-balance-demo/internal/eventstream"
+)
+
+// flakyPublisher fails the first failures publishes
+type flakyPublisher struct {
+	failures  int
+	published []eventstream.Event
+	attempts  int
+}
+
+func (p *flakyPublisher) Publish(ctx context.Context, event eventstream.Event) error {
+	p.attempts++
+	if p.attempts <= p.failures {
+		return errors.New("broker unavailable")
+	}
+	p.published = append(p.published, event)
+	return nil
+}
+
+func TestSettlementNotifierForward(t *testing.T) {
+	publisher := &flakyPublisher{failures: 2}
+	notifier := NewSettlementNotifier(nil, publisher, time.Millisecond, 2*time.Millisecond)
+	ctx := context.Background()
+
+	if err := notifier.forward(ctx, eventstream.Event{ID: "1-0", Type: eventstream.TransactionSettled, AccountID: "acc-1"}); err != nil {
+		t.Fatalf("forward transaction.settled: %v", err)
+	}
+	if publisher.attempts != 0 {
+		t.Fatalf("transaction.settled was published")
+	}
+
+	event := eventstream.Event{ID: "2-0", Type: eventstream.SettlementCompleted, AccountID: "acc-1", SettlementID: "s-1", Transactions: 3}
+	if err := notifier.forward(ctx, event); err != nil {
+		t.Fatalf("forward settlement.completed: %v", err)
+	}
+	if publisher.attempts != 3 || len(publisher.published) != 1 {
+		t.Fatalf("attempts=%d published=%d, want 3 attempts and 1 message", publisher.attempts, len(publisher.published))
+	}
+	// Tanpa event_id dari outbox, stream entry ID dipakai untuk dedupe
+	if got := publisher.published[0].EventID; got != "2-0" {
+		t.Fatalf("event_id = %q, want the stream entry ID", got)
+	}
+}
+
+func TestSettlementNotifierForwardStopsOnCancel(t *testing.T) {
+	notifier := NewSettlementNotifier(nil, &flakyPublisher{failures: 1 << 30}, time.Millisecond, time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	err := notifier.forward(ctx, eventstream.Event{Type: eventstream.SettlementCompleted, AccountID: "acc-1"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("forward error = %v, want the context error so the entry stays pending", err)
+	}
+}
@@ -0,0 +1,167 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"sub-balance-demo/internal/logmask"
+
+	"gorm.io/gorm/logger"
+)
+
+// SlowQueryStats summarizes the queries that crossed the slow-query threshold
+type SlowQueryStats struct {
+	ThresholdMs   float64 `json:"threshold_ms"`
+	Count         int64   `json:"count"`
+	MaxDurationMs float64 `json:"max_duration_ms"`
+	LastSQL       string  `json:"last_sql,omitempty"`
+}
+
+type slowQueryMetrics struct {
+	count       int64
+	maxDuration time.Duration
+	lastSQL     string
+	mutex       sync.Mutex
+}
+
+// SlowQueryLogger is a GORM logger that writes one structured entry for every
+// query slower than the threshold and counts them. Failed queries (and every
+// query in Debug mode) are logged like GORM's default logger does.
+type SlowQueryLogger struct {
+	logger.Interface
+	level      logger.LogLevel
+	threshold  time.Duration
+	jsonFormat bool
+	metrics    *slowQueryMetrics
+}
+
+// NewSlowQueryLogger creates the logger; threshold 0 disables slow-query
+// entries. jsonFormat selects JSON lines over key=value text.
+func NewSlowQueryLogger(threshold time.Duration, jsonFormat bool) *SlowQueryLogger {
+	return &SlowQueryLogger{
+		Interface: logger.New(log.New(os.Stdout, "\r\n", log.LstdFlags), logger.Config{
+			LogLevel: logger.Warn,
+			Colorful: true,
+		}),
+		level:      logger.Warn,
+		threshold:  threshold,
+		jsonFormat: jsonFormat,
+		metrics:    &slowQueryMetrics{},
+	}
+}
+
+// LogMode keeps the threshold and shares the counters with the original
+func (l *SlowQueryLogger) LogMode(level logger.LogLevel) logger.Interface {
+	clone := *l
+	clone.Interface = l.Interface.LogMode(level)
+	clone.level = level
+	return &clone
+}
+
+// ParamsFilter drops the query parameters while log masking is enabled, so
+// logged SQL shows placeholders instead of account IDs and amounts
+func (l *SlowQueryLogger) ParamsFilter(ctx context.Context, sql string, params ...interface{}) (string, []interface{}) {
+	if logmask.Enabled() {
+		return sql, nil
+	}
+	return sql, params
+}
+
+// Trace is implemented here rather than delegated, so the caller reported is
+// the repository code and not this wrapper
+func (l *SlowQueryLogger) Trace(ctx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
+	if l.level <= logger.Silent {
+		return
+	}
+
+	elapsed := time.Since(begin)
+	slow := l.threshold > 0 && elapsed >= l.threshold
+	failed := err != nil && l.level >= logger.Error
+	if !slow && !failed && l.level < logger.Info {
+		return
+	}
+
+	sql, rows := fc()
+	caller := queryCaller()
+	if slow {
+		l.record(sql, elapsed)
+		l.logSlowQuery(sql, rows, elapsed, caller)
+	}
+	if failed {
+		log.Printf("%s %v [%.3fms] [rows:%d] %s", caller, err, float64(elapsed)/float64(time.Millisecond), rows, sql)
+	} else if !slow && l.level >= logger.Info {
+		log.Printf("%s [%.3fms] [rows:%d] %s", caller, float64(elapsed)/float64(time.Millisecond), rows, sql)
+	}
+}
+
+// queryCaller returns the first frame outside GORM and this file
+func queryCaller() string {
+	for i := 2; i < 20; i++ {
+		_, file, line, ok := runtime.Caller(i)
+		if !ok {
+			break
+		}
+		if strings.Contains(file, "gorm.io/") || strings.HasSuffix(file, "slow_query_logger.go") {
+			continue
+		}
+		return file + ":" + strconv.Itoa(line)
+	}
+	return ""
+}
+
+func (l *SlowQueryLogger) record(sql string, elapsed time.Duration) {
+	l.metrics.mutex.Lock()
+	defer l.metrics.mutex.Unlock()
+
+	l.metrics.count++
+	l.metrics.lastSQL = sql
+	if elapsed > l.metrics.maxDuration {
+		l.metrics.maxDuration = elapsed
+	}
+}
+
+func (l *SlowQueryLogger) logSlowQuery(sql string, rows int64, elapsed time.Duration, caller string) {
+	durationMs := float64(elapsed) / float64(time.Millisecond)
+	thresholdMs := float64(l.threshold) / float64(time.Millisecond)
+
+	if !l.jsonFormat {
+		log.Printf("level=warn msg=\"slow query\" duration_ms=%.2f threshold_ms=%.0f rows=%d caller=%s sql=%q", durationMs, thresholdMs, rows, caller, sql)
+		return
+	}
+
+	entry, err := json.Marshal(map[string]interface{}{
+		"level":        "warn",
+		"msg":          "slow query",
+		"duration_ms":  durationMs,
+		"threshold_ms": thresholdMs,
+		"rows":         rows,
+		"caller":       caller,
+		"sql":          sql,
+	})
+	if err != nil {
+		log.Printf("Failed to encode slow query entry: %v", err)
+		return
+	}
+	fmt.Fprintln(os.Stdout, string(entry))
+}
+
+// Stats returns the slow-query count and the slowest duration seen so far
+func (l *SlowQueryLogger) Stats() SlowQueryStats {
+	l.metrics.mutex.Lock()
+	defer l.metrics.mutex.Unlock()
+
+	return SlowQueryStats{
+		ThresholdMs:   float64(l.threshold) / float64(time.Millisecond),
+		Count:         l.metrics.count,
+		MaxDurationMs: float64(l.metrics.maxDuration) / float64(time.Millisecond),
+		LastSQL:       l.metrics.lastSQL,
+	}
+}
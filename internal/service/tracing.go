@@ -0,0 +1,90 @@
+package service
+
+import (
+	"context"
+
+	"sub-balance-demo/internal/repository"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer resolves to the global provider installed by tracing.Setup; without
+// it spans are no-ops
+var tracer = otel.Tracer("sub-balance-demo/service")
+
+// maxSettlementLinks caps the links on one settleAccount span; hot accounts
+// can settle thousands of rows per run
+const maxSettlementLinks = 128
+
+// endSpan records err (if any) on span and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// traceParent is the W3C traceparent of the span in ctx, stored on the
+// sub_balance so settlement can be traced back to the request. Empty when the
+// request is not traced.
+func traceParent(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	return carrier.Get("traceparent")
+}
+
+// storedSpanContext parses a traceparent stored by traceParent
+func storedSpanContext(traceparent string) trace.SpanContext {
+	if traceparent == "" {
+		return trace.SpanContext{}
+	}
+	ctx := propagation.TraceContext{}.Extract(context.Background(), propagation.MapCarrier{"traceparent": traceparent})
+	return trace.SpanContextFromContext(ctx)
+}
+
+// settlementLinks links a settleAccount span to the requests that created
+// the transactions it settles
+func settlementLinks(transactions []repository.SubBalance) []trace.Link {
+	var links []trace.Link
+	for _, txn := range transactions {
+		if len(links) == maxSettlementLinks {
+			break
+		}
+		sc := storedSpanContext(txn.TraceParent)
+		if !sc.IsValid() {
+			continue
+		}
+		links = append(links, trace.Link{
+			SpanContext: sc,
+			Attributes:  []attribute.KeyValue{attribute.String("transaction.id", txn.ID)},
+		})
+	}
+	return links
+}
+
+// traceSettlementResult adds a span to the trace of the request that created
+// txn, so that trace ends with the transaction's settlement. The span links
+// back to the settlement span in ctx.
+func traceSettlementResult(ctx context.Context, settlementID string, txn repository.SubBalance, status string) {
+	sc := storedSpanContext(txn.TraceParent)
+	if !sc.IsValid() {
+		return
+	}
+
+	parent := trace.ContextWithRemoteSpanContext(context.Background(), sc)
+	_, span := tracer.Start(parent, "settleTransaction",
+		trace.WithLinks(trace.LinkFromContext(ctx)),
+		trace.WithAttributes(
+			attribute.String("transaction.id", txn.ID),
+			attribute.String("account.id", txn.AccountID),
+			attribute.String("settlement.id", settlementID),
+			attribute.String("transaction.status", status),
+		),
+	)
+	span.End()
+}
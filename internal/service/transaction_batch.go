@@ -0,0 +1,62 @@
+package service
+
+import (
+	"context"
+	"sync"
+
+	"sub-balance-demo/internal/repository"
+)
+
+// BatchResult is the outcome of one request of a batch: the response and
+// error ProcessTransaction returned for it
+type BatchResult struct {
+	Response *repository.TransactionResponse
+	Err      error
+}
+
+// ProcessBatch processes reqs with ProcessTransaction and returns their
+// results in the same order. Accounts are processed concurrently by
+// TRANSACTION_BATCH_WORKERS workers while the requests of one account run one
+// after another in batch order, so a debit sees the credits before it.
+// Requests not yet started when ctx is done fail with ctx's error.
+func (s *transactionService) ProcessBatch(ctx context.Context, reqs []*repository.TransactionRequest) []BatchResult {
+	results := make([]BatchResult, len(reqs))
+
+	// Group by account, dengan urutan account sesuai kemunculan pertamanya
+	var accounts []string
+	groups := make(map[string][]int)
+	for i, req := range reqs {
+		if _, ok := groups[req.AccountID]; !ok {
+			accounts = append(accounts, req.AccountID)
+		}
+		groups[req.AccountID] = append(groups[req.AccountID], i)
+	}
+
+	workers := s.config.TransactionBatchWorkers
+	if workers <= 0 {
+		workers = 1
+	}
+	jobs := make(chan []int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for indexes := range jobs {
+				for _, i := range indexes {
+					if err := ctx.Err(); err != nil {
+						results[i].Err = err
+						continue
+					}
+					results[i].Response, results[i].Err = s.ProcessTransaction(ctx, reqs[i])
+				}
+			}
+		}()
+	}
+	for _, accountID := range accounts {
+		jobs <- groups[accountID]
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
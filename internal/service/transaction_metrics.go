@@ -0,0 +1,131 @@
+package service
+
+import (
+	"sync"
+
+	"sub-balance-demo/internal/repository"
+
+	"github.com/shopspring/decimal"
+)
+
+// Alasan penolakan transaksi pada TransactionStats.Rejections
+const (
+	RejectInsufficientFunds = "insufficient_funds"
+	RejectAccountNotFound   = "account_not_found"
+	RejectRedisUnavailable  = "redis_unavailable"
+	RejectValidationFailed  = "validation_failed"
+)
+
+// TransactionCount is the number of incoming transactions of one type that
+// ended in one status through one path
+type TransactionCount struct {
+	Type   string `json:"type"`
+	Status string `json:"status"` // PENDING, SETTLED, REJECTED or ERROR
+	Path   string `json:"path"`   // redis, local_counter, database_fallback, realtime, none
+	Count  int64  `json:"count"`
+}
+
+// TransactionStats are business counters of incoming transactions since start
+type TransactionStats struct {
+	Transactions    []TransactionCount         `json:"transactions"`
+	AcceptedAmount  map[string]decimal.Decimal `json:"accepted_amount"` // by type
+	Rejections      map[string]int64           `json:"rejections"`      // by reason
+	AccountsCreated int64                      `json:"accounts_created"`
+	// Per-account counters, only with METRICS_ACCOUNT_LABEL=top
+	Accounts []AccountTransactionCount `json:"accounts,omitempty"`
+}
+
+type transactionCountKey struct {
+	txType string
+	status string
+	path   string
+}
+
+type transactionMetrics struct {
+	counts          map[transactionCountKey]int64
+	acceptedAmount  map[string]decimal.Decimal
+	rejections      map[string]int64
+	accountsCreated int64
+	accounts        *accountMetrics // nil: tanpa label per account
+	mutex           sync.Mutex
+}
+
+// newTransactionMetrics creates the counters; accountTopN > 0 also keeps
+// per-account counters for at most that many accounts
+func newTransactionMetrics(accountTopN int) *transactionMetrics {
+	m := &transactionMetrics{
+		counts:         make(map[transactionCountKey]int64),
+		acceptedAmount: make(map[string]decimal.Decimal),
+		rejections:     make(map[string]int64),
+	}
+	if accountTopN > 0 {
+		m.accounts = newAccountMetrics(accountTopN)
+	}
+	return m
+}
+
+func (m *transactionMetrics) record(req *repository.TransactionRequest, response *repository.TransactionResponse, err error, timing *transactionTiming) {
+	status := "ERROR"
+	switch {
+	case err != nil || response == nil:
+	case response.Success:
+		status = response.Status
+	default:
+		status = "REJECTED"
+	}
+
+	// Ditolak sebelum memilih jalur, mis. database down
+	path := timing.path
+	if path == "" {
+		path = "none"
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.counts[transactionCountKey{txType: req.Type, status: status, path: path}]++
+	if status == "REJECTED" {
+		reason := timing.rejectReason
+		if reason == "" {
+			reason = RejectValidationFailed
+		}
+		m.rejections[reason]++
+	}
+	if err == nil && response != nil && response.Success {
+		m.acceptedAmount[req.Type] = m.acceptedAmount[req.Type].Add(req.Amount)
+	}
+	if m.accounts != nil && (status == "REJECTED" || err == nil && response != nil && response.Success) {
+		m.accounts.record(req.AccountID, status != "REJECTED", req.Amount)
+	}
+}
+
+func (m *transactionMetrics) recordAccountCreated() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.accountsCreated++
+}
+
+func (m *transactionMetrics) snapshot() TransactionStats {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	stats := TransactionStats{
+		Transactions:    make([]TransactionCount, 0, len(m.counts)),
+		AcceptedAmount:  make(map[string]decimal.Decimal, len(m.acceptedAmount)),
+		Rejections:      make(map[string]int64, len(m.rejections)),
+		AccountsCreated: m.accountsCreated,
+	}
+	for key, count := range m.counts {
+		stats.Transactions = append(stats.Transactions, TransactionCount{Type: key.txType, Status: key.status, Path: key.path, Count: count})
+	}
+	for txType, amount := range m.acceptedAmount {
+		stats.AcceptedAmount[txType] = amount
+	}
+	for reason, count := range m.rejections {
+		stats.Rejections[reason] = count
+	}
+	if m.accounts != nil {
+		stats.Accounts = m.accounts.snapshot()
+	}
+	return stats
+}
@@ -2,69 +2,307 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"sub-balance-demo/internal/config"
+	"sub-balance-demo/internal/eventstream"
+	"sub-balance-demo/internal/logmask"
+	"sub-balance-demo/internal/pagination"
 	"sub-balance-demo/internal/repository"
+	"sub-balance-demo/internal/tenant"
 
 	"github.com/google/uuid"
 	"github.com/shopspring/decimal"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
 )
 
+// accountSettlement is the outcome of settling one account in a run
+type accountSettlement struct {
+	transactions int
+	appliedDelta decimal.Decimal
+	// rejected is the number of transactions left unsettled because applying
+	// them would overdraw the account
+	rejected int
+}
+
+// Hasil quickValidateBalance; pesannya dikembalikan apa adanya ke client
+var (
+	errAccountNotFound   = errors.New("account not found")
+	errInsufficientFunds = errors.New("saldo tidak mencukupi")
+)
+
+// errSettlementRejected signals that applying a settlement batch would overdraw the account
+var errSettlementRejected = errors.New("settlement rejected")
+
 type TransactionService interface {
 	ProcessTransaction(ctx context.Context, req *repository.TransactionRequest) (*repository.TransactionResponse, error)
+	ProcessBatch(ctx context.Context, reqs []*repository.TransactionRequest) []BatchResult
 	GetBalance(ctx context.Context, accountID string) (*repository.BalanceResponse, error)
 	GetPendingTransactions(ctx context.Context, accountID string) (*repository.PendingTransactionsResponse, error)
 	CreateAccount(ctx context.Context, accountID string, initialBalance decimal.Decimal) error
 	StartSettlementWorker(ctx context.Context)
+	WaitForSettlement(ctx context.Context) error
+	SettlementWorkerRunning() bool
+	GetSettlementStats() SettlementStats
+	GetTransactionStats() TransactionStats
+	GetSettlementAuditLogs(ctx context.Context, filter repository.SettlementAuditFilter) (pagination.Page[repository.SettlementAuditLog], error)
 }
 
 type transactionService struct {
+	db                 *gorm.DB
 	accountBalanceRepo repository.AccountBalanceRepository
 	subBalanceRepo     repository.SubBalanceRepository
+	settlementAudit    repository.SettlementAuditRepository
 	redisCounter       RedisCounter
 	config             *config.Config
 	healthChecker      *RedisHealthChecker
+	dbHealth           *DBHealthChecker
 	circuitBreaker     *CircuitBreaker
 	consistencyService *DataConsistencyService
+	reconciliation     *ReconciliationService
+	quarantine         *QuarantineService
+	accountLock        *DistributedLock
+	invalidator        *BalanceInvalidator
+	balanceCache       *BalanceCache
+	events             eventstream.Publisher
+	outbox             *Outbox
+	balanceAudit       *BalanceAuditTrail
+	localCounter       *LocalCounter
+	memoryGuard        *RedisMemoryGuard
+	domainEvents       *DomainEventLog
+	alerts             *ThresholdAlerter
+	metadata           *MetadataCipher
+	settlementDone     chan struct{}
+	settlementRunning  atomic.Bool
+	settlementMetrics  *settlementMetrics
+	notifier           *WebhookNotifier
+	realtimeMaxAmount  decimal.Decimal
+	latencyBudget      time.Duration
+	// Backoff transaksi yang ditolak settlement, lihat settlementRetryDelay
+	retryBaseDelay     time.Duration
+	retryMaxDelay      time.Duration
+	transactionMetrics *transactionMetrics
+	// Hot path settlement: log per transaksi/per account di-sample, log error dibatasi
+	transactionLogs     *logSampler
+	accountLogs         *logSampler
+	settlementErrorLogs *logRateLimiter
+	// settle settles the transactions of one account in a run; settleAccount,
+	// replaced in tests of the worker pool
+	settle func(ctx context.Context, settlementID string, accountID string, transactions []repository.SubBalance) (accountSettlement, error)
+	// settlementRepo opens the set-based settlement statement on a transaction
+	settlementRepo func(tx *gorm.DB) repository.SettlementRepository
 }
 
 func NewTransactionService(
+	db *gorm.DB,
 	accountBalanceRepo repository.AccountBalanceRepository,
 	subBalanceRepo repository.SubBalanceRepository,
+	settlementAudit repository.SettlementAuditRepository,
 	redisCounter RedisCounter,
 	config *config.Config,
 	healthChecker *RedisHealthChecker,
+	dbHealth *DBHealthChecker,
 	circuitBreaker *CircuitBreaker,
 	consistencyService *DataConsistencyService,
+	reconciliation *ReconciliationService,
+	quarantine *QuarantineService,
+	accountLock *DistributedLock,
+	invalidator *BalanceInvalidator,
+	balanceCache *BalanceCache,
+	events eventstream.Publisher,
+	outbox *Outbox,
+	balanceAudit *BalanceAuditTrail,
+	localCounter *LocalCounter,
+	memoryGuard *RedisMemoryGuard,
+	domainEvents *DomainEventLog,
+	alerts *ThresholdAlerter,
+	metadata *MetadataCipher,
 ) TransactionService {
-	return &transactionService{
-		accountBalanceRepo: accountBalanceRepo,
-		subBalanceRepo:     subBalanceRepo,
-		redisCounter:       redisCounter,
-		config:             config,
-		healthChecker:      healthChecker,
-		circuitBreaker:     circuitBreaker,
-		consistencyService: consistencyService,
+	realtimeMaxAmount, err := decimal.NewFromString(config.RealtimeSettlementMaxAmount)
+	if err != nil {
+		log.Printf("Invalid real-time settlement max amount, using default 1000000: %v", err)
+		realtimeMaxAmount = decimal.NewFromInt(1000000)
+	}
+
+	latencyBudget, err := time.ParseDuration(config.TransactionLatencyBudget)
+	if err != nil {
+		log.Printf("Invalid transaction latency budget, using default 250ms: %v", err)
+		latencyBudget = 250 * time.Millisecond
+	}
+
+	retryBaseDelay, err := time.ParseDuration(config.SettlementRetryBaseDelay)
+	if err != nil || retryBaseDelay < 0 {
+		log.Printf("Invalid settlement retry base delay, using default 30s: %v", err)
+		retryBaseDelay = 30 * time.Second
 	}
+	retryMaxDelay, err := time.ParseDuration(config.SettlementRetryMaxDelay)
+	if err != nil || retryMaxDelay < retryBaseDelay {
+		log.Printf("Invalid settlement retry max delay, using default 10m: %v", err)
+		retryMaxDelay = max(10*time.Minute, retryBaseDelay)
+	}
+
+	s := &transactionService{
+		db:                  db,
+		accountBalanceRepo:  accountBalanceRepo,
+		subBalanceRepo:      subBalanceRepo,
+		settlementAudit:     settlementAudit,
+		redisCounter:        redisCounter,
+		config:              config,
+		healthChecker:       healthChecker,
+		dbHealth:            dbHealth,
+		circuitBreaker:      circuitBreaker,
+		consistencyService:  consistencyService,
+		reconciliation:      reconciliation,
+		quarantine:          quarantine,
+		accountLock:         accountLock,
+		invalidator:         invalidator,
+		balanceCache:        balanceCache,
+		events:              events,
+		outbox:              outbox,
+		balanceAudit:        balanceAudit,
+		localCounter:        localCounter,
+		memoryGuard:         memoryGuard,
+		domainEvents:        domainEvents,
+		alerts:              alerts,
+		metadata:            metadata,
+		settlementDone:      make(chan struct{}),
+		settlementMetrics:   newSettlementMetrics(config.SettlementWorkers, config.SettlementBatchSize),
+		notifier:            NewWebhookNotifier(config.SettlementFailureWebhookURL),
+		realtimeMaxAmount:   realtimeMaxAmount,
+		latencyBudget:       latencyBudget,
+		retryBaseDelay:      retryBaseDelay,
+		retryMaxDelay:       retryMaxDelay,
+		transactionMetrics:  newTransactionMetrics(accountMetricsTopN(config)),
+		transactionLogs:     newLogSampler(config.SettlementLogSampleEvery),
+		accountLogs:         newLogSampler(config.SettlementLogSampleEvery),
+		settlementErrorLogs: newLogRateLimiter(config.SettlementErrorLogLimit),
+	}
+	s.settle = s.settleAccount
+	s.settlementRepo = repository.NewSettlementRepository
+	return s
 }
 
 func (s *transactionService) ProcessTransaction(ctx context.Context, req *repository.TransactionRequest) (*repository.TransactionResponse, error) {
-	// Strategy 1: Try Redis first (if healthy)
+	ctx, span := tracer.Start(ctx, "ProcessTransaction", trace.WithAttributes(
+		attribute.String("account.id", req.AccountID),
+		attribute.String("transaction.type", req.Type),
+	))
+
+	start := time.Now()
+	ctx, timing := withTransactionTiming(ctx)
+	response, err := s.processTransaction(ctx, req)
+	if elapsed := time.Since(start); s.latencyBudget > 0 && elapsed > s.latencyBudget {
+		s.flagSlowTransaction(span, req, response, err, timing, elapsed)
+	}
+	s.transactionMetrics.record(req, response, err, timing)
+	if err == nil && response != nil {
+		span.SetAttributes(attribute.Bool("transaction.success", response.Success))
+		s.emitTransactionResult(ctx, response)
+	}
+	endSpan(span, err)
+	return response, err
+}
+
+func (s *transactionService) processTransaction(ctx context.Context, req *repository.TransactionRequest) (*repository.TransactionResponse, error) {
+	// Semua jalur butuh database; tolak langsung daripada antre di connection pool
+	if !s.dbHealth.IsHealthy() {
+		return nil, ErrDatabaseUnavailable
+	}
+
+	// Metadata dienkripsi sekali di sini, jadi semua jalur menyimpan versi yang sama
+	if len(req.Metadata) > 0 {
+		metadata, err := s.metadata.Seal(req.AccountID, req.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		sealed := *req
+		sealed.Metadata = metadata
+		req = &sealed
+	}
+
+	// Strategy 0: credit kecil langsung disettle (real-time mode)
+	if s.shouldSettleImmediately(req) {
+		return s.processRealtimeCredit(ctx, req)
+	}
+
+	// Strategy 1: Try Redis first (if healthy). Mendekati maxmemory counter bisa
+	// di-evict, jadi transaksi baru lewat database sampai memory turun lagi.
 	if s.healthChecker.IsHealthy() {
+		if s.memoryGuard.UnderPressure() {
+			return s.processWithDatabaseFallback(ctx, req)
+		}
 		return s.processWithRedis(ctx, req)
 	}
 
-	// Strategy 2: Fallback to database lock
+	// Strategy 2: Local in-memory counter (optional, single instance only)
+	if s.localCounter != nil {
+		return s.processWithLocalCounter(ctx, req)
+	}
+
+	// Strategy 3: Fallback to database lock
 	return s.processWithDatabaseFallback(ctx, req)
 }
 
+// emitTransactionResult publishes the outcome of an incoming transaction.
+// Accepted and settled transactions already went through the outbox when it
+// is enabled; only rejections, which change no state, are published here.
+func (s *transactionService) emitTransactionResult(ctx context.Context, response *repository.TransactionResponse) {
+	if s.outbox != nil && response.Success {
+		return
+	}
+
+	eventType := eventstream.TransactionAccepted
+	switch {
+	case !response.Success:
+		eventType = eventstream.TransactionRejected
+	case response.Status == "SETTLED":
+		eventType = eventstream.TransactionSettled
+	}
+
+	s.emit(ctx, eventstream.Event{
+		Type:          eventType,
+		AccountID:     response.AccountID,
+		TransactionID: response.TransactionID,
+		TxType:        response.Type,
+		Amount:        response.Amount.String(),
+		Status:        response.Status,
+		Message:       response.Message,
+	})
+}
+
+// emit is best effort: the stream is a notification feed, the database stays
+// the source of truth
+func (s *transactionService) emit(ctx context.Context, event eventstream.Event) {
+	if s.events == nil {
+		return
+	}
+	err := s.events.Publish(ctx, event)
+	if err != nil {
+		log.Printf("Failed to publish %s event for account %s: %v", event.Type, logmask.Account(event.AccountID), err)
+	}
+}
+
 func (s *transactionService) processWithRedis(ctx context.Context, req *repository.TransactionRequest) (*repository.TransactionResponse, error) {
+	setTransactionPath(ctx, transactionPathRedis)
+
 	// 1. Quick validation (tanpa lock)
+	done := timePhase(ctx, "validation")
 	err := s.quickValidateBalance(ctx, req.AccountID, req.Amount)
+	done()
 	if err != nil {
+		switch {
+		case errors.Is(err, errInsufficientFunds):
+			setRejectReason(ctx, RejectInsufficientFunds)
+		case errors.Is(err, errAccountNotFound):
+			setRejectReason(ctx, RejectAccountNotFound)
+		}
 		return &repository.TransactionResponse{
 			Success:   false,
 			Message:   err.Error(),
@@ -77,34 +315,45 @@ func (s *transactionService) processWithRedis(ctx context.Context, req *reposito
 	}
 
 	// 2. Baca balance untuk max balance
+	done = timePhase(ctx, "balance_read")
 	balance, err := s.accountBalanceRepo.GetByID(ctx, req.AccountID)
+	done()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get account balance: %w", err)
 	}
 
 	maxBalance := balance.SettledBalance.Add(balance.PendingCredit).Sub(balance.PendingDebit)
 
-	// 3. Atomic Redis counter update dengan validation (with circuit breaker)
+	// 3. Atomic Redis counter update dengan validation (with circuit breaker).
+	// Entry di Redis memakai ID sub_balance supaya rollback dan settlement exact.
+	subBalanceID := uuid.New().String()
 	var success bool
+	done = timePhase(ctx, "redis")
 	if s.config.EnableCircuitBreaker && s.circuitBreaker != nil {
 		err = s.circuitBreaker.Call(func() error {
 			var cbErr error
-			success, _, cbErr = s.redisCounter.AddPending(ctx, req.AccountID, req.Amount, maxBalance)
+			success, _, cbErr = s.redisCounter.AddPending(ctx, req.AccountID, subBalanceID, req.Type, req.Amount, maxBalance)
 			return cbErr
 		})
 	} else {
 		// Direct call without circuit breaker
 		var cbErr error
-		success, _, cbErr = s.redisCounter.AddPending(ctx, req.AccountID, req.Amount, maxBalance)
+		success, _, cbErr = s.redisCounter.AddPending(ctx, req.AccountID, subBalanceID, req.Type, req.Amount, maxBalance)
 		err = cbErr
 	}
+	done()
 
 	if err != nil {
 		// Redis failed, fallback to database (if enabled)
 		if s.config.EnableRedisFallback {
+			if s.localCounter != nil {
+				log.Printf("Redis failed, falling back to local counter: %v", err)
+				return s.processWithLocalCounter(ctx, req)
+			}
 			log.Printf("Redis failed, falling back to database: %v", err)
 			return s.processWithDatabaseFallback(ctx, req)
 		} else {
+			setRejectReason(ctx, RejectRedisUnavailable)
 			return &repository.TransactionResponse{
 				Success:   false,
 				Message:   "Redis unavailable and fallback disabled",
@@ -116,6 +365,7 @@ func (s *transactionService) processWithRedis(ctx context.Context, req *reposito
 	}
 
 	if !success {
+		setRejectReason(ctx, RejectInsufficientFunds)
 		return &repository.TransactionResponse{
 			Success:   false,
 			Message:   "saldo tidak mencukupi (overspend protection)",
@@ -129,52 +379,79 @@ func (s *transactionService) processWithRedis(ctx context.Context, req *reposito
 
 	// 4. Insert ke sub_balance
 	subBalance := &repository.SubBalance{
-		ID:        uuid.New().String(),
-		AccountID: req.AccountID,
-		Amount:    req.Amount,
-		Type:      req.Type,
-		Status:    "PENDING",
+		ID:          subBalanceID,
+		AccountID:   req.AccountID,
+		Amount:      req.Amount,
+		Type:        req.Type,
+		Status:      "PENDING",
+		TraceParent: traceParent(ctx),
+		Metadata:    repository.Metadata(req.Metadata),
 	}
 
-	err = s.subBalanceRepo.Create(ctx, subBalance)
+	done = timePhase(ctx, "db_insert")
+	err = s.createSubBalance(ctx, subBalance)
+	done()
 	if err != nil {
 		// Rollback Redis counter
-		s.redisCounter.RemovePending(ctx, req.AccountID, req.Amount)
+		s.redisCounter.RemovePending(ctx, req.AccountID, subBalanceID)
 		return nil, fmt.Errorf("failed to create sub balance: %w", err)
 	}
+	s.publishPending(ctx, req.AccountID)
 
 	return &repository.TransactionResponse{
-		Success:   true,
-		Message:   "Transaksi berhasil diproses (Redis)",
-		AccountID: req.AccountID,
-		Amount:    req.Amount,
-		Type:      req.Type,
-		Status:    "PENDING",
-		Timestamp: time.Now(),
+		Success:       true,
+		Message:       "Transaksi berhasil diproses (Redis)",
+		TransactionID: subBalanceID,
+		AccountID:     req.AccountID,
+		Amount:        req.Amount,
+		Type:          req.Type,
+		Status:        "PENDING",
+		Timestamp:     time.Now(),
 	}, nil
 }
 
-func (s *transactionService) processWithDatabaseFallback(ctx context.Context, req *repository.TransactionRequest) (*repository.TransactionResponse, error) {
-	// 1. Lock account balance
-	balance, err := s.accountBalanceRepo.GetByIDForUpdate(ctx, req.AccountID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to lock account balance: %w", err)
-	}
+// processWithLocalCounter mirrors processWithRedis against the process-local
+// counter, so short Redis outages do not put every request on a row lock
+func (s *transactionService) processWithLocalCounter(ctx context.Context, req *repository.TransactionRequest) (*repository.TransactionResponse, error) {
+	setTransactionPath(ctx, transactionPathLocalCounter)
 
-	// 2. Calculate total pending from sub-balance table
-	var totalPending decimal.Decimal
-	err = s.subBalanceRepo.GetTotalPendingByAccountID(ctx, req.AccountID, &totalPending)
+	// 1. Baca balance untuk max balance
+	done := timePhase(ctx, "balance_read")
+	balance, err := s.accountBalanceRepo.GetByID(ctx, req.AccountID)
+	done()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get pending amount: %w", err)
+		return nil, fmt.Errorf("failed to get account balance: %w", err)
 	}
 
-	// 3. Calculate actual available balance
-	actualAvailable := balance.SettledBalance.Sub(totalPending)
+	maxBalance := balance.SettledBalance.Add(balance.PendingCredit).Sub(balance.PendingDebit)
+
+	// 2. Pending yang diterima sebelum Redis down ikut dihitung
+	done = timePhase(ctx, "local_counter")
+	if !s.localCounter.Tracked(ctx, req.AccountID) {
+		pendingRows, err := s.subBalanceRepo.GetPendingByAccountID(ctx, req.AccountID)
+		if err != nil {
+			done()
+			return nil, fmt.Errorf("failed to get pending from DB: %w", err)
+		}
+		entries := make(map[string]PendingEntry, len(pendingRows))
+		for _, row := range pendingRows {
+			entries[row.ID] = PendingEntry{Type: row.Type, Amount: row.Amount}
+		}
+		s.localCounter.Seed(ctx, req.AccountID, entries)
+	}
 
-	if actualAvailable.LessThan(req.Amount) {
+	// 3. Atomic local counter update dengan validation
+	subBalanceID := uuid.New().String()
+	success, _, err := s.localCounter.AddPending(ctx, req.AccountID, subBalanceID, req.Type, req.Amount, maxBalance)
+	done()
+	if err != nil {
+		return nil, fmt.Errorf("failed to update local counter: %w", err)
+	}
+	if !success {
+		setRejectReason(ctx, RejectInsufficientFunds)
 		return &repository.TransactionResponse{
 			Success:   false,
-			Message:   "saldo tidak mencukupi",
+			Message:   "saldo tidak mencukupi (overspend protection)",
 			AccountID: req.AccountID,
 			Amount:    req.Amount,
 			Type:      req.Type,
@@ -183,37 +460,332 @@ func (s *transactionService) processWithDatabaseFallback(ctx context.Context, re
 		}, nil
 	}
 
-	// 4. Create sub-balance record
+	// 4. Insert ke sub_balance; Redis dibangun ulang dari tabel ini saat pulih
 	subBalance := &repository.SubBalance{
-		ID:        uuid.New().String(),
-		AccountID: req.AccountID,
-		Amount:    req.Amount,
-		Type:      req.Type,
-		Status:    "PENDING",
+		ID:          subBalanceID,
+		AccountID:   req.AccountID,
+		Amount:      req.Amount,
+		Type:        req.Type,
+		Status:      "PENDING",
+		TraceParent: traceParent(ctx),
+		Metadata:    repository.Metadata(req.Metadata),
 	}
 
-	err = s.subBalanceRepo.Create(ctx, subBalance)
+	done = timePhase(ctx, "db_insert")
+	err = s.createSubBalance(ctx, subBalance)
+	done()
 	if err != nil {
+		s.localCounter.RemovePending(ctx, req.AccountID, subBalanceID)
 		return nil, fmt.Errorf("failed to create sub balance: %w", err)
 	}
+	s.publishPending(ctx, req.AccountID)
+
+	return &repository.TransactionResponse{
+		Success:       true,
+		Message:       "Transaksi berhasil diproses (Local Counter)",
+		TransactionID: subBalanceID,
+		AccountID:     req.AccountID,
+		Amount:        req.Amount,
+		Type:          req.Type,
+		Status:        "PENDING",
+		Timestamp:     time.Now(),
+	}, nil
+}
+
+// publishPending announces a transaction accepted through a counter, which
+// leaves account_balances untouched, for balance streams. The hot path only
+// pays for it when the gRPC server is enabled.
+func (s *transactionService) publishPending(ctx context.Context, accountID string) {
+	if s.config.EnableGRPC {
+		s.invalidator.Publish(ctx, accountID, "pending")
+	}
+}
+
+// createSubBalance inserts a pending row and, when the outbox or the domain
+// event log is enabled, its accepted events in the same transaction
+func (s *transactionService) createSubBalance(ctx context.Context, subBalance *repository.SubBalance) error {
+	if s.outbox == nil && !s.domainEvents.Enabled() {
+		return s.subBalanceRepo.Create(ctx, subBalance)
+	}
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := s.subBalanceRepo.WithTx(tx).Create(ctx, subBalance)
+		if err != nil {
+			return err
+		}
+		err = s.domainEvents.Record(ctx, tx, acceptedDomainEvent(ctx, *subBalance))
+		if err != nil {
+			return err
+		}
+		return s.outbox.Write(ctx, tx, transactionEvent(eventstream.TransactionAccepted, "", *subBalance, "PENDING"))
+	})
+}
+
+// acceptedDomainEvent records which path accepted the transaction; anything
+// but Redis is a fallback
+func acceptedDomainEvent(ctx context.Context, txn repository.SubBalance) repository.DomainEvent {
+	path := transactionPathRedis
+	if timing := transactionTimingFrom(ctx); timing != nil && timing.path != "" {
+		path = timing.path
+	}
+	eventType := DomainEventAcceptedFallback
+	if path == transactionPathRedis {
+		eventType = DomainEventAcceptedRedis
+	}
+	return NewDomainEvent(eventType, txn.AccountID, txn.ID, map[string]interface{}{
+		"path":   path,
+		"type":   txn.Type,
+		"amount": txn.Amount.String(),
+	})
+}
+
+// settledDomainEvent records one account's share of a settlement run
+func settledDomainEvent(settlementID string, accountID string, mode string, transactions int, delta decimal.Decimal, resultingBalance decimal.Decimal) repository.DomainEvent {
+	return NewDomainEvent(DomainEventSettled, accountID, settlementID, map[string]interface{}{
+		"mode":              mode,
+		"transactions":      transactions,
+		"delta":             delta.String(),
+		"resulting_balance": resultingBalance.String(),
+	})
+}
+
+// removePending drops settled or failed entries from Redis and, when enabled,
+// from the local counter
+func (s *transactionService) removePending(ctx context.Context, accountID string, transactionIDs ...string) error {
+	if s.localCounter != nil {
+		s.localCounter.RemovePending(ctx, accountID, transactionIDs...)
+	}
+	return s.redisCounter.RemovePending(ctx, accountID, transactionIDs...)
+}
+
+func (s *transactionService) processWithDatabaseFallback(ctx context.Context, req *repository.TransactionRequest) (*repository.TransactionResponse, error) {
+	setTransactionPath(ctx, transactionPathFallback)
+
+	var response *repository.TransactionResponse
+	lockWait := timePhase(ctx, "lock_wait")
+	err := s.accountLock.WithAccountLock(ctx, req.AccountID, func(ctx context.Context) error {
+		lockWait()
+		return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			accountRepo := s.accountBalanceRepo.WithTx(tx)
+			subBalanceRepo := s.subBalanceRepo.WithTx(tx)
+
+			// 1. Lock account (advisory lock, serialized dengan settlement dan repair)
+			done := timePhase(ctx, "db_lock")
+			err := accountRepo.LockAccount(ctx, req.AccountID)
+			if err != nil {
+				done()
+				return fmt.Errorf("failed to lock account balance: %w", err)
+			}
+
+			balance, err := accountRepo.GetByIDForUpdate(ctx, req.AccountID)
+			done()
+			if err != nil {
+				return fmt.Errorf("failed to get account balance: %w", err)
+			}
+
+			// 2. Calculate total pending from sub-balance table
+			var totalPending decimal.Decimal
+			done = timePhase(ctx, "validation")
+			err = subBalanceRepo.GetTotalPendingByAccountID(ctx, req.AccountID, &totalPending)
+			done()
+			if err != nil {
+				return fmt.Errorf("failed to get pending amount: %w", err)
+			}
+
+			// 3. Calculate actual available balance
+			actualAvailable := balance.SettledBalance.Sub(totalPending)
+
+			if actualAvailable.LessThan(req.Amount) {
+				setRejectReason(ctx, RejectInsufficientFunds)
+				response = &repository.TransactionResponse{
+					Success:   false,
+					Message:   "saldo tidak mencukupi",
+					AccountID: req.AccountID,
+					Amount:    req.Amount,
+					Type:      req.Type,
+					Status:    "REJECTED",
+					Timestamp: time.Now(),
+				}
+				return nil
+			}
+
+			// 4. Create sub-balance record
+			subBalance := &repository.SubBalance{
+				ID:          uuid.New().String(),
+				AccountID:   req.AccountID,
+				Amount:      req.Amount,
+				Type:        req.Type,
+				Status:      "PENDING",
+				TraceParent: traceParent(ctx),
+				Metadata:    repository.Metadata(req.Metadata),
+			}
+
+			done = timePhase(ctx, "db_insert")
+			defer done()
+			err = subBalanceRepo.Create(ctx, subBalance)
+			if err != nil {
+				return fmt.Errorf("failed to create sub balance: %w", err)
+			}
+
+			err = s.outbox.Write(ctx, tx, transactionEvent(eventstream.TransactionAccepted, "", *subBalance, "PENDING"))
+			if err != nil {
+				return err
+			}
+
+			err = s.domainEvents.Record(ctx, tx, acceptedDomainEvent(ctx, *subBalance))
+			if err != nil {
+				return err
+			}
+
+			// 5. Update account balance (temporary for consistency)
+			before := *balance
+			balance.PendingDebit = balance.PendingDebit.Add(req.Amount)
+			balance.AvailableBalance = balance.SettledBalance.Add(balance.PendingCredit).Sub(balance.PendingDebit)
+
+			err = accountRepo.UpdateBalance(ctx, balance)
+			if err != nil {
+				return fmt.Errorf("failed to update account balance: %w", err)
+			}
+
+			err = s.balanceAudit.Record(ctx, tx, BalanceSourceFallback, subBalance.ID, before, *balance)
+			if err != nil {
+				return err
+			}
+
+			response = &repository.TransactionResponse{
+				Success:       true,
+				Message:       "Transaksi berhasil diproses (Database Fallback)",
+				TransactionID: subBalance.ID,
+				AccountID:     req.AccountID,
+				Amount:        req.Amount,
+				Type:          req.Type,
+				Status:        "PENDING",
+				Timestamp:     time.Now(),
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if response.Success {
+		s.invalidator.Publish(ctx, req.AccountID, "fallback")
+	}
 
-	// 5. Update account balance (temporary for consistency)
-	balance.PendingDebit = balance.PendingDebit.Add(req.Amount)
-	balance.AvailableBalance = balance.SettledBalance.Add(balance.PendingCredit).Sub(balance.PendingDebit)
+	return response, nil
+}
 
-	err = s.accountBalanceRepo.UpdateBalance(ctx, balance)
+// shouldSettleImmediately reports whether a transaction takes the real-time path:
+// only credits up to the configured max, and only when the request did not opt out
+func (s *transactionService) shouldSettleImmediately(req *repository.TransactionRequest) bool {
+	if !s.config.EnableRealtimeSettlement || req.Type != "credit" {
+		return false
+	}
+	if req.SettleImmediately != nil && !*req.SettleImmediately {
+		return false
+	}
+	return req.Amount.LessThanOrEqual(s.realtimeMaxAmount)
+}
+
+// processRealtimeCredit records and settles a credit in a single DB transaction,
+// so deposits are spendable without waiting for the settlement worker
+func (s *transactionService) processRealtimeCredit(ctx context.Context, req *repository.TransactionRequest) (*repository.TransactionResponse, error) {
+	setTransactionPath(ctx, transactionPathRealtime)
+
+	settlementID := "realtime-" + uuid.New().String()
+	subBalanceID := uuid.New().String()
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		accountRepo := s.accountBalanceRepo.WithTx(tx)
+		subBalanceRepo := s.subBalanceRepo.WithTx(tx)
+
+		// 1. Lock account (advisory lock, serialized dengan settlement dan repair)
+		done := timePhase(ctx, "db_lock")
+		err := accountRepo.LockAccount(ctx, req.AccountID)
+		if err != nil {
+			done()
+			return fmt.Errorf("failed to lock account balance: %w", err)
+		}
+
+		balance, err := accountRepo.GetByIDForUpdate(ctx, req.AccountID)
+		done()
+		if err != nil {
+			return fmt.Errorf("failed to get account balance: %w", err)
+		}
+
+		done = timePhase(ctx, "db_insert")
+		defer done()
+
+		// 2. Create sub-balance record dan langsung stamp sebagai SETTLED
+		subBalance := &repository.SubBalance{
+			ID:          subBalanceID,
+			AccountID:   req.AccountID,
+			Amount:      req.Amount,
+			Type:        req.Type,
+			TraceParent: traceParent(ctx),
+			Metadata:    repository.Metadata(req.Metadata),
+		}
+		err = subBalanceRepo.Create(ctx, subBalance)
+		if err != nil {
+			return fmt.Errorf("failed to create sub balance: %w", err)
+		}
+
+		_, err = subBalanceRepo.StampSettlement(ctx, []string{subBalance.ID}, "SETTLED", settlementID)
+		if err != nil {
+			return fmt.Errorf("failed to stamp sub balance: %w", err)
+		}
+
+		// 3. Update balance utama
+		before := *balance
+		oldBalance := balance.SettledBalance
+		balance.SettledBalance = balance.SettledBalance.Add(req.Amount)
+		now := time.Now()
+		balance.LastSettlementAt = &now
+		balance.LastSettlementID = settlementID
+
+		err = accountRepo.UpdateBalance(ctx, balance)
+		if err != nil {
+			return fmt.Errorf("failed to update balance: %w", err)
+		}
+
+		err = s.balanceAudit.Record(ctx, tx, BalanceSourceRealtimeSettlement, settlementID, before, *balance)
+		if err != nil {
+			return err
+		}
+
+		// 4. Audit snapshot
+		err = s.settlementAudit.WithTx(tx).Create(ctx, &repository.SettlementAuditLog{
+			ID:               uuid.New().String(),
+			SettlementID:     settlementID,
+			AccountID:        req.AccountID,
+			PreviousBalance:  oldBalance,
+			Delta:            req.Amount,
+			ResultingBalance: balance.SettledBalance,
+			TransactionIDs:   repository.StringList{subBalance.ID},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to write settlement audit log: %w", err)
+		}
+
+		err = s.domainEvents.Record(ctx, tx, settledDomainEvent(settlementID, req.AccountID, "realtime", 1, req.Amount, balance.SettledBalance))
+		if err != nil {
+			return err
+		}
+
+		return s.outbox.Write(ctx, tx, transactionEvent(eventstream.TransactionSettled, settlementID, *subBalance, "SETTLED"))
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to update account balance: %w", err)
+		return nil, err
 	}
+	s.invalidator.Publish(ctx, req.AccountID, "realtime_settlement")
 
 	return &repository.TransactionResponse{
-		Success:   true,
-		Message:   "Transaksi berhasil diproses (Database Fallback)",
-		AccountID: req.AccountID,
-		Amount:    req.Amount,
-		Type:      req.Type,
-		Status:    "PENDING",
-		Timestamp: time.Now(),
+		Success:       true,
+		Message:       "Transaksi berhasil disettle (Real-time)",
+		TransactionID: subBalanceID,
+		AccountID:     req.AccountID,
+		Amount:        req.Amount,
+		Type:          req.Type,
+		Status:        "SETTLED",
+		Timestamp:     time.Now(),
 	}, nil
 }
 
@@ -221,54 +793,76 @@ func (s *transactionService) quickValidateBalance(ctx context.Context, accountID
 	// Baca balance (tanpa lock)
 	balance, err := s.accountBalanceRepo.GetByID(ctx, accountID)
 	if err != nil {
-		return fmt.Errorf("account not found")
+		return errAccountNotFound
 	}
 
 	// Hitung available balance
 	availableBalance := balance.SettledBalance.Add(balance.PendingCredit).Sub(balance.PendingDebit)
 
 	// Cek Redis counter untuk pending real-time
-	pendingAmount, err := s.redisCounter.GetPending(ctx, accountID)
+	pending, err := s.redisCounter.GetPending(ctx, accountID)
 	if err != nil {
 		return fmt.Errorf("failed to get pending amount")
 	}
 
-	// Hitung sisa saldo yang bisa digunakan
-	remainingBalance := availableBalance.Sub(pendingAmount)
+	// Hitung sisa saldo yang bisa digunakan; credit pending hanya dihitung jika
+	// dikonfigurasi bisa langsung dipakai
+	remainingBalance := availableBalance.Sub(pending.Debit)
+	if s.config.PendingCreditSpendable {
+		remainingBalance = remainingBalance.Add(pending.Credit)
+	}
 
 	// Validasi ketat: sisa saldo harus >= amount
 	if remainingBalance.LessThan(amount) {
-		return fmt.Errorf("saldo tidak mencukupi")
+		return errInsufficientFunds
 	}
 
 	return nil
 }
 
+// GetBalance reads through the balance cache (if enabled). Cache dilewati saat
+// Redis down supaya request tidak menunggu timeout Redis.
 func (s *transactionService) GetBalance(ctx context.Context, accountID string) (*repository.BalanceResponse, error) {
-	balance, err := s.accountBalanceRepo.GetByID(ctx, accountID)
+	useCache := s.balanceCache != nil && s.healthChecker.IsHealthy()
+	if useCache {
+		if cached, ok := s.balanceCache.Get(ctx, accountID); ok {
+			return cached, nil
+		}
+	}
+
+	balance, err := s.accountBalanceRepo.Reader().GetByID(ctx, accountID)
 	if err != nil {
 		return nil, fmt.Errorf("account not found")
 	}
 
-	return &repository.BalanceResponse{
+	response := &repository.BalanceResponse{
 		AccountID:        balance.ID,
 		SettledBalance:   balance.SettledBalance,
 		PendingDebit:     balance.PendingDebit,
 		PendingCredit:    balance.PendingCredit,
 		AvailableBalance: balance.AvailableBalance,
 		LastUpdated:      balance.UpdatedAt,
-	}, nil
+	}
+	if useCache {
+		s.balanceCache.Set(ctx, response)
+	}
+	return response, nil
 }
 
 func (s *transactionService) GetPendingTransactions(ctx context.Context, accountID string) (*repository.PendingTransactionsResponse, error) {
-	items, err := s.subBalanceRepo.GetPendingByAccountID(ctx, accountID)
+	items, err := s.subBalanceRepo.Reader().GetPendingByAccountID(ctx, accountID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get pending transactions")
 	}
 
 	total := decimal.Zero
-	for _, item := range items {
+	for i, item := range items {
 		total = total.Add(item.Amount)
+		metadata, err := s.metadata.Open(accountID, item.Metadata)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read metadata of transaction %s: %w", item.ID, err)
+		}
+		items[i].Metadata = metadata
 	}
 
 	return &repository.PendingTransactionsResponse{
@@ -280,6 +874,10 @@ func (s *transactionService) GetPendingTransactions(ctx context.Context, account
 }
 
 func (s *transactionService) StartSettlementWorker(ctx context.Context) {
+	defer close(s.settlementDone)
+	s.settlementRunning.Store(true)
+	defer s.settlementRunning.Store(false)
+
 	interval, err := time.ParseDuration(s.config.SettlementInterval)
 	if err != nil {
 		log.Printf("Invalid settlement interval, using default 5s: %v", err)
@@ -291,10 +889,14 @@ func (s *transactionService) StartSettlementWorker(ctx context.Context) {
 
 	log.Println("Settlement worker started")
 
+	// Context kerja tidak ikut di-cancel saat shutdown, supaya account yang sedang
+	// disettle selesai sampai status update; ctx hanya menghentikan account berikutnya
+	workCtx := context.WithoutCancel(ctx)
+
 	for {
 		select {
 		case <-ticker.C:
-			s.processSettlement(ctx)
+			s.processSettlement(workCtx, ctx.Done())
 		case <-ctx.Done():
 			log.Println("Settlement worker stopped")
 			return
@@ -302,48 +904,193 @@ func (s *transactionService) StartSettlementWorker(ctx context.Context) {
 	}
 }
 
-func (s *transactionService) processSettlement(ctx context.Context) error {
-	// 1. Ambil semua pending transactions dengan batch size
+// SettlementWorkerRunning reports whether the settlement worker loop has
+// started and not yet exited
+func (s *transactionService) SettlementWorkerRunning() bool {
+	return s.settlementRunning.Load()
+}
+
+// WaitForSettlement blocks until the settlement worker has finished its
+// in-flight account and exited, or ctx expires.
+func (s *transactionService) WaitForSettlement(ctx context.Context) error {
+	select {
+	case <-s.settlementDone:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *transactionService) GetSettlementStats() SettlementStats {
+	return s.settlementMetrics.snapshot()
+}
+
+func (s *transactionService) GetTransactionStats() TransactionStats {
+	return s.transactionMetrics.snapshot()
+}
+
+func (s *transactionService) GetSettlementAuditLogs(ctx context.Context, filter repository.SettlementAuditFilter) (pagination.Page[repository.SettlementAuditLog], error) {
+	return s.settlementAudit.List(ctx, filter)
+}
+
+func (s *transactionService) processSettlement(ctx context.Context, stop <-chan struct{}) error {
+	// 1. Ambil pending transactions per batch (keyset per account)
 	batchSize := s.config.SettlementBatchSize
 	if batchSize <= 0 {
 		batchSize = 100 // default batch size
 	}
 
-	pendingTransactions, err := s.subBalanceRepo.GetAllPending(ctx)
+	batch, cursor, err := s.nextSettlementBatch(ctx, "", batchSize)
 	if err != nil {
 		log.Printf("Failed to get pending transactions: %v", err)
+		s.settlementMetrics.recordError()
 		return err
 	}
-
-	if len(pendingTransactions) == 0 {
+	if len(batch) == 0 && cursor == "" {
+		s.settlementMetrics.recordIdle()
 		return nil // Tidak ada yang perlu disettlement
 	}
 
-	// 2. Process in batches
-	for i := 0; i < len(pendingTransactions); i += batchSize {
-		end := i + batchSize
-		if end > len(pendingTransactions) {
-			end = len(pendingTransactions)
-		}
+	// Setiap run punya settlement ID sendiri supaya delta tidak pernah diterapkan dua kali
+	settlementID := uuid.New().String()
+	log.Printf("Settlement run %s started", settlementID)
+
+	// Span hanya untuk run yang benar-benar punya pekerjaan
+	ctx, span := tracer.Start(ctx, "processSettlement", trace.WithAttributes(
+		attribute.String("settlement.id", settlementID),
+	))
+	defer span.End()
+
+	workers := s.config.SettlementWorkers
+	if workers <= 0 {
+		workers = 1
+	}
+	run := settlementRun{completed: true}
+	runStart := time.Now()
+	applied := make(map[repository.AccountKey]decimal.Decimal)
+	var appliedMutex sync.Mutex
 
-		batch := pendingTransactions[i:end]
+	// 2. Process in batches. Satu account tidak pernah terpotong ke dua batch:
+	// settlement ID per run hanya boleh diterapkan sekali per account
+	for ; ; batch, cursor, err = s.nextSettlementBatch(ctx, cursor, batchSize) {
+		if err != nil {
+			log.Printf("Settlement run %s stopped, failed to get pending transactions: %v", settlementID, err)
+			run.completed = false
+			break
+		}
+		run.batches++
+		batchStart := time.Now()
+		settledBefore, failedBefore, transactionsBefore := run.accountsSettled, run.accountsFailed, run.transactionsSettled
 
-		// Group by account for this batch
-		accountGroups := make(map[string][]repository.SubBalance)
+		// Group by account for this batch; account ID hanya unik per tenant
+		accountGroups := make(map[repository.AccountKey][]repository.SubBalance)
 		for _, txn := range batch {
-			accountGroups[txn.AccountID] = append(accountGroups[txn.AccountID], txn)
+			account := repository.AccountKey{TenantID: txn.TenantID, AccountID: txn.AccountID}
+			accountGroups[account] = append(accountGroups[account], txn)
 		}
 
-		// Process per account in this batch
-		for accountID, transactions := range accountGroups {
-			err := s.settleAccount(ctx, accountID, transactions)
-			if err != nil {
-				log.Printf("Failed to settle account %s: %v", accountID, err)
-				continue
+		// 3. Process accounts in this batch concurrently. Setiap account hanya muncul
+		// sekali per batch dan batch diproses berurutan, jadi satu account tidak
+		// pernah disettle paralel.
+		jobs := make(chan repository.AccountKey)
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for account := range jobs {
+					accountID := account.AccountID
+					accountCtx, accountSpan := tracer.Start(ctx, "settleAccount", trace.WithAttributes(
+						attribute.String("account.id", accountID),
+						attribute.Int("settlement.transactions", len(accountGroups[account])),
+					), trace.WithLinks(settlementLinks(accountGroups[account])...))
+					result, err := s.settle(accountCtx, settlementID, accountID, accountGroups[account])
+					endSpan(accountSpan, err)
+					if err != nil {
+						if ok, suppressed := s.settlementErrorLogs.allow(); ok {
+							if suppressed > 0 {
+								log.Printf("Settlement run %s: %d account failure logs suppressed", settlementID, suppressed)
+							}
+							log.Printf("Failed to settle account %s: %v", logmask.Account(accountID), err)
+						}
+						atomic.AddInt64(&run.accountsFailed, 1)
+						atomic.AddInt64(&run.transactionsRejected, int64(result.rejected))
+						// Rejection karena saldo kurang punya retry policy sendiri
+						if s.quarantine != nil && !errors.Is(err, errSettlementRejected) {
+							s.quarantine.RecordFailure(ctx, account, err)
+						}
+						continue
+					}
+					if s.quarantine != nil {
+						s.quarantine.RecordSuccess(account)
+					}
+					atomic.AddInt64(&run.accountsSettled, 1)
+					atomic.AddInt64(&run.transactionsSettled, int64(result.transactions))
+					if result.transactions > 0 {
+						appliedMutex.Lock()
+						applied[account] = result.appliedDelta
+						appliedMutex.Unlock()
+					}
+				}
+			}()
+		}
+
+		// Shutdown didahulukan: worker yang baru selesai tidak boleh mengambil
+		// account berikutnya setelah stop ditutup
+		interrupted := false
+		for account := range accountGroups {
+			select {
+			case <-stop:
+				interrupted = true
+			default:
+				select {
+				case <-stop:
+					interrupted = true
+				case jobs <- account:
+				}
+			}
+			if interrupted {
+				break
 			}
 		}
+		close(jobs)
+		wg.Wait()
+
+		// Ringkasan per batch menggantikan log per transaksi yang di-sample
+		log.Printf("Settlement run %s batch %d: accounts=%d, settled=%d, failed=%d, transactions=%d/%d, duration=%s",
+			settlementID, run.batches, len(accountGroups), run.accountsSettled-settledBefore, run.accountsFailed-failedBefore,
+			run.transactionsSettled-transactionsBefore, len(batch), time.Since(batchStart))
+
+		if interrupted {
+			log.Printf("Settlement run %s interrupted by shutdown, remaining accounts left PENDING", settlementID)
+			run.completed = false
+			run.duration = time.Since(runStart)
+			s.settlementMetrics.record(run)
+			s.reconcileSettlement(ctx, settlementID, applied)
+			return nil
+		}
+
+		if cursor == "" {
+			break
+		}
 	}
 
+	run.duration = time.Since(runStart)
+	s.settlementMetrics.record(run)
+	span.SetAttributes(
+		attribute.Int64("settlement.accounts_settled", run.accountsSettled),
+		attribute.Int64("settlement.accounts_failed", run.accountsFailed),
+		attribute.Int64("settlement.transactions_settled", run.transactionsSettled),
+		attribute.Int64("settlement.transactions_rejected", run.transactionsRejected),
+		attribute.Int("settlement.batches", run.batches),
+	)
+	log.Printf("Settlement run %s finished in %s: accounts=%d, failed=%d, transactions=%d, rejected=%d, batches=%d, workers=%d",
+		settlementID, run.duration, run.accountsSettled, run.accountsFailed, run.transactionsSettled, run.transactionsRejected, run.batches, workers)
+	s.alerts.CheckSettlementFailures(ctx, settlementID, run.accountsFailed, run.accountsSettled)
+
+	// Post-settlement reconciliation report
+	s.reconcileSettlement(ctx, settlementID, applied)
+
 	// 4. Redis Recovery: Sync Redis dengan database (if enabled)
 	if s.config.EnableAutoRecovery && s.healthChecker.IsHealthy() {
 		err := s.consistencyService.RecoverRedisFromDatabase(ctx)
@@ -355,95 +1102,492 @@ func (s *transactionService) processSettlement(ctx context.Context) error {
 	return nil
 }
 
-func (s *transactionService) settleAccount(ctx context.Context, accountID string, transactions []repository.SubBalance) error {
-	// 1. Lock account balance
-	balance, err := s.accountBalanceRepo.GetByIDForUpdate(ctx, accountID)
+// nextSettlementBatch reads the page of pending transactions after cursor and
+// drops those of quarantined accounts, so one poison account does not slow
+// every run. The returned cursor is empty after the last page.
+func (s *transactionService) nextSettlementBatch(ctx context.Context, cursor string, batchSize int) ([]repository.SubBalance, string, error) {
+	page, err := s.subBalanceRepo.GetPendingBatch(ctx, cursor, batchSize, time.Now())
 	if err != nil {
-		return fmt.Errorf("failed to lock account balance: %w", err)
+		return nil, "", err
+	}
+
+	batch := page.Items
+	if s.quarantine != nil {
+		batch = s.quarantine.Filter(ctx, batch)
+	}
+	return batch, page.NextCursor, nil
+}
+
+func (s *transactionService) reconcileSettlement(ctx context.Context, settlementID string, applied map[repository.AccountKey]decimal.Decimal) {
+	if s.reconciliation == nil {
+		return
+	}
+	err := s.reconciliation.Reconcile(ctx, settlementID, applied)
+	if err != nil {
+		log.Printf("Failed to reconcile settlement %s: %v", settlementID, err)
+	}
+}
+
+// settleAccount applies the PENDING transactions of one account to its
+// settled balance. Settlement IDs are random per run, so idempotency does not
+// rest on the account's last_settlement_id: only rows still PENDING are
+// stamped, and a batch settled by an earlier run stamps nothing.
+func (s *transactionService) settleAccount(ctx context.Context, settlementID string, accountID string, transactions []repository.SubBalance) (accountSettlement, error) {
+	// Worker berjalan lintas tenant; counter Redis account ada di namespace tenantnya
+	if len(transactions) > 0 {
+		ctx = tenant.WithID(ctx, transactions[0].TenantID)
+	}
+
+	// Hot account: satu statement SQL jauh lebih cepat daripada loop per transaksi
+	threshold := s.config.SettlementSetBasedThreshold
+	if threshold > 0 && len(transactions) >= threshold {
+		return s.settleAccountSetBased(ctx, settlementID, accountID, transactions)
 	}
 
-	// 2. Hitung total delta berdasarkan type (debit mengurangi, credit menambah)
-	totalDelta := decimal.Zero
 	var transactionIDs []string
 	for _, txn := range transactions {
-		log.Printf("Processing transaction: id=%s, type=%s, amount=%s", txn.ID, txn.Type, txn.Amount.String())
-		switch txn.Type {
-		case "debit":
-			totalDelta = totalDelta.Sub(txn.Amount) // Debit mengurangi balance
-			log.Printf("Debit transaction: amount=%s, new_totalDelta=%s", txn.Amount.String(), totalDelta.String())
-		case "credit":
-			totalDelta = totalDelta.Add(txn.Amount) // Credit menambah balance
-			log.Printf("Credit transaction: amount=%s, new_totalDelta=%s", txn.Amount.String(), totalDelta.String())
+		transactionIDs = append(transactionIDs, txn.ID)
+	}
+	logAccount := s.accountLogs.sample()
+
+	var settled []repository.SubBalance
+	var availableBalance, totalDelta, appliedDelta, resultingBalance decimal.Decimal
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		accountRepo := s.accountBalanceRepo.WithTx(tx)
+		subBalanceRepo := s.subBalanceRepo.WithTx(tx)
+
+		// 1. Lock account (advisory lock, serialized dengan fallback path dan repair)
+		err := accountRepo.LockAccount(ctx, accountID)
+		if err != nil {
+			return fmt.Errorf("failed to lock account balance: %w", err)
+		}
+
+		balance, err := accountRepo.GetByIDForUpdate(ctx, accountID)
+		if err != nil {
+			return fmt.Errorf("failed to get account balance: %w", err)
 		}
+
+		// 2. Stamp sub_balance yang masih PENDING dengan settlement ID. Filter
+		// status='PENDING' inilah guard idempotency: baris yang sudah disettle
+		// run lain (atau percobaan sebelumnya dari run ini) tidak ikut lagi
+		stamped, err := subBalanceRepo.StampSettlement(ctx, transactionIDs, "SETTLED", settlementID)
+		if err != nil {
+			return fmt.Errorf("failed to stamp sub balance: %w", err)
+		}
+		if stamped == 0 {
+			return nil // Sudah disettle oleh run lain
+		}
+
+		// 3. Hitung total delta hanya dari transaksi yang di-stamp oleh run ini
+		// (debit mengurangi, credit menambah)
+		settled, err = subBalanceRepo.GetBySettlementID(ctx, accountID, settlementID)
+		if err != nil {
+			return fmt.Errorf("failed to get stamped sub balance: %w", err)
+		}
+
+		for _, txn := range settled {
+			switch txn.Type {
+			case "debit":
+				totalDelta = totalDelta.Sub(txn.Amount) // Debit mengurangi balance
+			case "credit":
+				totalDelta = totalDelta.Add(txn.Amount) // Credit menambah balance
+			}
+			if s.transactionLogs.sample() {
+				log.Printf("Settling transaction (sampled): account=%s, id=%s, type=%s, amount=%s, running_delta=%s",
+					logmask.Account(accountID), txn.ID, txn.Type, logmask.Amount(txn.Amount), logmask.Amount(totalDelta))
+			}
+		}
+
+		// 4. Validasi ulang (double check) - untuk debit, pastikan balance tidak minus
+		availableBalance = balance.SettledBalance.Add(balance.PendingCredit).Sub(balance.PendingDebit)
+		newBalance := availableBalance.Add(totalDelta)
+		if newBalance.LessThan(decimal.Zero) {
+			return errSettlementRejected
+		}
+
+		// 5. Update balance utama
+		before := *balance
+		oldBalance := balance.SettledBalance
+		balance.SettledBalance = balance.SettledBalance.Add(totalDelta)
+		balance.PendingDebit = decimal.Zero
+		balance.PendingCredit = decimal.Zero
+		now := time.Now()
+		balance.LastSettlementAt = &now
+		balance.LastSettlementID = settlementID
+		appliedDelta = balance.SettledBalance.Sub(oldBalance)
+		resultingBalance = balance.SettledBalance
+
+		if logAccount {
+			log.Printf("Settlement (sampled): account=%s, settlement_id=%s, old_balance=%s, delta=%s, new_balance=%s, transactions=%d",
+				logmask.Account(accountID), settlementID, logmask.Amount(oldBalance), logmask.Amount(totalDelta), logmask.Amount(balance.SettledBalance), len(settled))
+		}
+
+		err = accountRepo.UpdateBalance(ctx, balance)
+		if err != nil {
+			return fmt.Errorf("failed to update balance: %w", err)
+		}
+
+		// 6. Audit snapshot, dalam transaksi yang sama dengan update balance
+		err = s.balanceAudit.Record(ctx, tx, BalanceSourceSettlement, settlementID, before, *balance)
+		if err != nil {
+			return err
+		}
+		settledIDs := make(repository.StringList, 0, len(settled))
+		for _, txn := range settled {
+			settledIDs = append(settledIDs, txn.ID)
+		}
+		err = s.settlementAudit.WithTx(tx).Create(ctx, &repository.SettlementAuditLog{
+			ID:               uuid.New().String(),
+			SettlementID:     settlementID,
+			AccountID:        accountID,
+			PreviousBalance:  oldBalance,
+			Delta:            appliedDelta,
+			ResultingBalance: balance.SettledBalance,
+			TransactionIDs:   settledIDs,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to write settlement audit log: %w", err)
+		}
+		err = s.domainEvents.Record(ctx, tx, settledDomainEvent(settlementID, accountID, "batch", len(settled), appliedDelta, balance.SettledBalance))
+		if err != nil {
+			return err
+		}
+
+		// 7. Event settled ke outbox, commit bersama update balance
+		events := make([]eventstream.Event, 0, len(settled)+1)
+		for _, txn := range settled {
+			events = append(events, transactionEvent(eventstream.TransactionSettled, settlementID, txn, "SETTLED"))
+		}
+		events = append(events, settlementCompletedEvent(settlementID, accountID, len(settled), appliedDelta, resultingBalance))
+		return s.outbox.Write(ctx, tx, events...)
+	})
+
+	if errors.Is(err, errSettlementRejected) {
+		// Jika akan minus, transaksi dicoba lagi atau ditandai FAILED
+		s.handleRejectedSettlement(ctx, settlementID, accountID, settled)
+		return accountSettlement{rejected: len(settled)}, fmt.Errorf("%w: settlement akan menyebabkan saldo minus: current=%s, delta=%s, new=%s",
+			errSettlementRejected, availableBalance.String(), totalDelta.String(), availableBalance.Add(totalDelta).String())
+	}
+	if err != nil {
+		return accountSettlement{}, err
+	}
+
+	if len(settled) == 0 {
+		return accountSettlement{}, nil
+	}
+
+	// 8. Hapus entry Redis untuk transaksi yang sudah disettle
+	settledIDs := make([]string, 0, len(settled))
+	for _, txn := range settled {
+		settledIDs = append(settledIDs, txn.ID)
+	}
+	err = s.removePending(ctx, accountID, settledIDs...)
+	if err != nil {
+		log.Printf("Failed to remove settled entries from redis counter for account %s: %v", logmask.Account(accountID), err)
+	}
+
+	s.invalidator.Publish(ctx, accountID, "settlement")
+	if s.outbox == nil {
+		for _, txn := range settled {
+			s.emitSettlementResult(ctx, eventstream.TransactionSettled, settlementID, txn, "SETTLED")
+		}
+		s.emit(ctx, settlementCompletedEvent(settlementID, accountID, len(settled), appliedDelta, resultingBalance))
+	}
+	for _, txn := range settled {
+		traceSettlementResult(ctx, settlementID, txn, "SETTLED")
+	}
+
+	if logAccount {
+		log.Printf("Successfully settled %d transactions for account %s", len(settled), logmask.Account(accountID))
+	}
+	return accountSettlement{transactions: len(settled), appliedDelta: appliedDelta}, nil
+}
+
+// settleAccountSetBased settles the batch of a hot account with a single
+// set-based statement instead of stamping and summing rows in Go
+func (s *transactionService) settleAccountSetBased(ctx context.Context, settlementID string, accountID string, transactions []repository.SubBalance) (accountSettlement, error) {
+	transactionIDs := make([]string, 0, len(transactions))
+	for _, txn := range transactions {
 		transactionIDs = append(transactionIDs, txn.ID)
 	}
+	var result *repository.SetBasedSettlement
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		accountRepo := s.accountBalanceRepo.WithTx(tx)
+
+		// 1. Lock account (advisory lock, serialized dengan fallback path dan repair)
+		err := accountRepo.LockAccount(ctx, accountID)
+		if err != nil {
+			return fmt.Errorf("failed to lock account balance: %w", err)
+		}
+
+		balance, err := accountRepo.GetByIDForUpdate(ctx, accountID)
+		if err != nil {
+			return fmt.Errorf("failed to get account balance: %w", err)
+		}
+
+		// 2. Stamp, sum, dan update balance dalam satu statement; hanya baris yang
+		// masih PENDING yang diklaim, jadi batch yang sama tidak pernah disettle dua kali
+		result, err = s.settlementRepo(tx).SettleAccount(ctx, accountID, settlementID, transactionIDs)
+		if err != nil {
+			return fmt.Errorf("failed to settle account: %w", err)
+		}
+		if result.Transactions == 0 {
+			return nil // Sudah disettle oleh run lain
+		}
+		if !result.Applied {
+			return errSettlementRejected
+		}
+
+		// 3. Audit snapshot, dalam transaksi yang sama dengan update balance;
+		// nilai sesudahnya mengikuti SET pada settleAccountSQL
+		after := *balance
+		after.SettledBalance = result.ResultingBalance
+		after.PendingDebit = decimal.Zero
+		after.PendingCredit = decimal.Zero
+		after.AvailableBalance = result.ResultingBalance
+		after.Version = balance.Version + 1
+		err = s.balanceAudit.Record(ctx, tx, BalanceSourceSettlement, settlementID, *balance, after)
+		if err != nil {
+			return err
+		}
+
+		err = s.settlementAudit.WithTx(tx).Create(ctx, &repository.SettlementAuditLog{
+			ID:               uuid.New().String(),
+			SettlementID:     settlementID,
+			AccountID:        accountID,
+			PreviousBalance:  result.ResultingBalance.Sub(result.Delta),
+			Delta:            result.Delta,
+			ResultingBalance: result.ResultingBalance,
+			TransactionIDs:   result.TransactionIDs,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to write settlement audit log: %w", err)
+		}
+		err = s.domainEvents.Record(ctx, tx, settledDomainEvent(settlementID, accountID, "set_based", int(result.Transactions), result.Delta, result.ResultingBalance))
+		if err != nil {
+			return err
+		}
+
+		// 4. Event settled ke outbox, commit bersama update balance
+		events := append(s.settledEvents(settlementID, transactions, result.TransactionIDs),
+			settlementCompletedEvent(settlementID, accountID, int(result.Transactions), result.Delta, result.ResultingBalance))
+		return s.outbox.Write(ctx, tx, events...)
+	})
+
+	if errors.Is(err, errSettlementRejected) {
+		// Hanya baris yang di-stamp statement (stamp-nya di-rollback), bukan baris
+		// batch yang sudah disettle run lain
+		rejected := pickTransactions(transactions, result.TransactionIDs)
+		s.handleRejectedSettlement(ctx, settlementID, accountID, rejected)
+		return accountSettlement{rejected: len(rejected)}, fmt.Errorf("%w: set-based settlement akan menyebabkan saldo minus: delta=%s",
+			errSettlementRejected, result.Delta.String())
+	}
+	if err != nil {
+		return accountSettlement{}, err
+	}
+	if result == nil || result.Transactions == 0 {
+		return accountSettlement{}, nil
+	}
+
+	// 5. Hapus entry Redis untuk transaksi yang sudah disettle
+	err = s.removePending(ctx, accountID, result.TransactionIDs...)
+	if err != nil {
+		log.Printf("Failed to remove settled entries from redis counter for account %s: %v", logmask.Account(accountID), err)
+	}
+
+	s.invalidator.Publish(ctx, accountID, "settlement")
+	if s.outbox == nil && s.events != nil {
+		for _, event := range s.settledEvents(settlementID, transactions, result.TransactionIDs) {
+			s.emit(ctx, event)
+		}
+		s.emit(ctx, settlementCompletedEvent(settlementID, accountID, int(result.Transactions), result.Delta, result.ResultingBalance))
+	}
+	// Baris batch yang sudah disettle run lain tidak ikut di-trace
+	settledIDs := make(map[string]bool, len(result.TransactionIDs))
+	for _, id := range result.TransactionIDs {
+		settledIDs[id] = true
+	}
+	for _, txn := range transactions {
+		if settledIDs[txn.ID] {
+			traceSettlementResult(ctx, settlementID, txn, "SETTLED")
+		}
+	}
+
+	if s.accountLogs.sample() {
+		log.Printf("Successfully settled %d transactions for account %s (set-based, sampled): delta=%s, new_balance=%s",
+			result.Transactions, logmask.Account(accountID), logmask.Amount(result.Delta), logmask.Amount(result.ResultingBalance))
+	}
+	return accountSettlement{transactions: int(result.Transactions), appliedDelta: result.Delta}, nil
+}
+
+// settledEvents builds the settled events of a set-based settlement, in the
+// order the statement settled them
+func (s *transactionService) settledEvents(settlementID string, transactions []repository.SubBalance, settledIDs []string) []eventstream.Event {
+	settled := pickTransactions(transactions, settledIDs)
+	events := make([]eventstream.Event, 0, len(settled))
+	for _, txn := range settled {
+		events = append(events, transactionEvent(eventstream.TransactionSettled, settlementID, txn, "SETTLED"))
+	}
+	return events
+}
+
+// pickTransactions returns the transactions with the given IDs, in the order
+// of ids
+func pickTransactions(transactions []repository.SubBalance, ids []string) []repository.SubBalance {
+	byID := make(map[string]repository.SubBalance, len(transactions))
+	for _, txn := range transactions {
+		byID[txn.ID] = txn
+	}
+
+	picked := make([]repository.SubBalance, 0, len(ids))
+	for _, id := range ids {
+		if txn, ok := byID[id]; ok {
+			picked = append(picked, txn)
+		}
+	}
+	return picked
+}
+
+func (s *transactionService) emitSettlementResult(ctx context.Context, eventType string, settlementID string, txn repository.SubBalance, status string) {
+	s.emit(ctx, transactionEvent(eventType, settlementID, txn, status))
+}
+
+// transactionEvent describes a sub_balance row on the event stream
+func transactionEvent(eventType string, settlementID string, txn repository.SubBalance, status string) eventstream.Event {
+	event := eventstream.Event{
+		Type:          eventType,
+		AccountID:     txn.AccountID,
+		TransactionID: txn.ID,
+		TxType:        txn.Type,
+		Status:        status,
+		SettlementID:  settlementID,
+	}
+	if txn.Type != "" {
+		event.Amount = txn.Amount.String()
+	}
+	return event
+}
+
+// settlementCompletedEvent summarizes what a settlement run applied to one
+// account
+func settlementCompletedEvent(settlementID string, accountID string, transactions int, delta decimal.Decimal, balance decimal.Decimal) eventstream.Event {
+	return eventstream.Event{
+		Type:         eventstream.SettlementCompleted,
+		AccountID:    accountID,
+		Amount:       delta.String(),
+		Status:       "SETTLED",
+		SettlementID: settlementID,
+		Transactions: transactions,
+		Balance:      balance.String(),
+	}
+}
+
+// handleRejectedSettlement keeps rejected transactions PENDING so a later run can
+// re-validate them (funds may still arrive via a credit), after a backoff
+// delay, and marks them FAILED once they have been rejected more than
+// SettlementMaxRetries times.
+func (s *transactionService) handleRejectedSettlement(ctx context.Context, settlementID string, accountID string, rejected []repository.SubBalance) {
+	// Transaksi dengan jumlah attempt yang sama mendapat delay yang sama
+	retryIDs := make(map[int][]string)
+	var failedIDs []string
+	var failed []repository.SubBalance
+	failedAmount := decimal.Zero
+	for _, txn := range rejected {
+		if txn.Attempts+1 > s.config.SettlementMaxRetries {
+			failedIDs = append(failedIDs, txn.ID)
+			failed = append(failed, txn)
+			failedAmount = failedAmount.Add(txn.Amount)
+		} else {
+			retryIDs[txn.Attempts+1] = append(retryIDs[txn.Attempts+1], txn.ID)
+		}
+	}
 
-	// 3. Validasi ulang (double check) - untuk debit, pastikan balance tidak minus
-	availableBalance := balance.SettledBalance.Add(balance.PendingCredit).Sub(balance.PendingDebit)
-	newBalance := availableBalance.Add(totalDelta)
-	if newBalance.LessThan(decimal.Zero) {
-		// Jika akan minus, reject semua transaksi
-		s.subBalanceRepo.UpdateStatusBatch(ctx, transactionIDs, "REJECTED")
-		s.redisCounter.RemovePending(ctx, accountID, totalDelta.Abs())
-		return fmt.Errorf("settlement akan menyebabkan saldo minus: current=%s, delta=%s, new=%s",
-			availableBalance.String(), totalDelta.String(), newBalance.String())
-	}
-
-	// 4. Update balance utama
-	oldBalance := balance.SettledBalance
-	balance.SettledBalance = balance.SettledBalance.Add(totalDelta)
-	balance.PendingDebit = decimal.Zero
-	balance.PendingCredit = decimal.Zero
 	now := time.Now()
-	balance.LastSettlementAt = &now
+	for attempts, ids := range retryIDs {
+		nextAttemptAt := now.Add(s.settlementRetryDelay(attempts))
+		err := s.subBalanceRepo.IncrementAttempts(ctx, ids, nextAttemptAt)
+		if err != nil {
+			log.Printf("Failed to re-queue rejected transactions for account %s: %v", logmask.Account(accountID), err)
+		} else {
+			log.Printf("Re-queued %d rejected transactions for account %s, retrying at %s",
+				len(ids), logmask.Account(accountID), nextAttemptAt.Format(time.RFC3339))
+		}
+	}
 
-	log.Printf("Settlement: account=%s, old_balance=%s, delta=%s, new_balance=%s, transactions=%d",
-		accountID, oldBalance.String(), totalDelta.String(), balance.SettledBalance.String(), len(transactions))
+	if len(failedIDs) == 0 {
+		return
+	}
 
-	// 5. Update balance
-	err = s.accountBalanceRepo.UpdateBalance(ctx, balance)
+	_, err := s.subBalanceRepo.StampSettlement(ctx, failedIDs, "FAILED", settlementID)
 	if err != nil {
-		return fmt.Errorf("failed to update balance: %w", err)
+		log.Printf("Failed to mark transactions FAILED for account %s: %v", logmask.Account(accountID), err)
+		return
 	}
 
-	// 6. Update status sub_balance
-	err = s.subBalanceRepo.UpdateStatusBatch(ctx, transactionIDs, "SETTLED")
+	err = s.removePending(ctx, accountID, failedIDs...)
 	if err != nil {
-		return fmt.Errorf("failed to update sub balance status: %w", err)
+		log.Printf("Failed to remove failed entries from redis counter for account %s: %v", logmask.Account(accountID), err)
 	}
 
-	// 7. Clear Redis counter
-	err = s.redisCounter.ClearPending(ctx, accountID)
+	log.Printf("Marked %d transactions FAILED for account %s after %d retries", len(failedIDs), logmask.Account(accountID), s.config.SettlementMaxRetries)
+	for _, txn := range failed {
+		s.emitSettlementResult(ctx, eventstream.TransactionRejected, settlementID, txn, "FAILED")
+		traceSettlementResult(ctx, settlementID, txn, "FAILED")
+	}
+
+	err = s.notifier.Notify(ctx, "settlement.failed", map[string]interface{}{
+		"account_id":      accountID,
+		"settlement_id":   settlementID,
+		"transaction_ids": failedIDs,
+		"amount":          failedAmount,
+	})
 	if err != nil {
-		log.Printf("Failed to clear redis counter for account %s: %v", accountID, err)
+		log.Printf("Failed to send settlement failure notification for account %s: %v", logmask.Account(accountID), err)
 	}
+}
 
-	log.Printf("Successfully settled %d transactions for account %s", len(transactions), accountID)
-	return nil
+// settlementRetryDelay is SettlementRetryBaseDelay doubled for every rejected
+// attempt after the first, capped at SettlementRetryMaxDelay
+func (s *transactionService) settlementRetryDelay(attempts int) time.Duration {
+	delay := s.retryBaseDelay
+	for i := 1; i < attempts && delay < s.retryMaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, s.retryMaxDelay)
 }
 
 func (s *transactionService) CreateAccount(ctx context.Context, accountID string, initialBalance decimal.Decimal) error {
-	// Check if account already exists
-	existingBalance, err := s.accountBalanceRepo.GetByID(ctx, accountID)
-	if err == nil && existingBalance != nil {
-		return fmt.Errorf("account already exists")
-	}
+	// Lock supaya dua instance tidak membuat account yang sama bersamaan
+	err := s.accountLock.WithAccountLock(ctx, accountID, func(ctx context.Context) error {
+		// Check if account already exists
+		existingBalance, err := s.accountBalanceRepo.GetByID(ctx, accountID)
+		if err == nil && existingBalance != nil {
+			return fmt.Errorf("account already exists")
+		}
 
-	// Create new account balance
-	accountBalance := &repository.AccountBalance{
-		ID:               accountID,
-		SettledBalance:   initialBalance,
-		PendingDebit:     decimal.Zero,
-		PendingCredit:    decimal.Zero,
-		AvailableBalance: initialBalance,
-		Version:          1,
-	}
+		// Create new account balance
+		accountBalance := &repository.AccountBalance{
+			ID:               accountID,
+			SettledBalance:   initialBalance,
+			PendingDebit:     decimal.Zero,
+			PendingCredit:    decimal.Zero,
+			AvailableBalance: initialBalance,
+			Version:          1,
+		}
 
-	err = s.accountBalanceRepo.Create(ctx, accountBalance)
+		return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			err := s.accountBalanceRepo.WithTx(tx).Create(ctx, accountBalance)
+			if err != nil {
+				return fmt.Errorf("failed to create account: %w", err)
+			}
+			return s.balanceAudit.Record(ctx, tx, BalanceSourceAccountCreate, "", repository.AccountBalance{}, *accountBalance)
+		})
+	})
 	if err != nil {
-		return fmt.Errorf("failed to create account: %w", err)
+		return err
 	}
 
-	log.Printf("Successfully created account %s with initial balance %s", accountID, initialBalance.String())
+	s.transactionMetrics.recordAccountCreated()
+	log.Printf("Successfully created account %s with initial balance %s", logmask.Account(accountID), logmask.Amount(initialBalance))
 	return nil
 }
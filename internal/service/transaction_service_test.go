@@ -0,0 +1,330 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"sub-balance-demo/internal/config"
+	"sub-balance-demo/internal/pagination"
+	"sub-balance-demo/internal/repository"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestSettlementRetryDelay(t *testing.T) {
+	s := &transactionService{retryBaseDelay: 30 * time.Second, retryMaxDelay: 5 * time.Minute}
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{4, 4 * time.Minute},
+		{5, 5 * time.Minute},
+		{50, 5 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := s.settlementRetryDelay(tt.attempts); got != tt.want {
+			t.Errorf("settlementRetryDelay(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+// fakePendingBatch returns its rows as a single page of pending transactions
+type fakePendingBatch struct {
+	repository.SubBalanceRepository
+	rows []repository.SubBalance
+}
+
+func (f *fakePendingBatch) GetPendingBatch(ctx context.Context, cursor string, limit int, now time.Time) (pagination.Page[repository.SubBalance], error) {
+	return pagination.Page[repository.SubBalance]{Items: f.rows}, nil
+}
+
+// newSettlementPool returns a service whose worker pool settles one pending
+// transaction for each of accounts with settle
+func newSettlementPool(workers int, accounts []string, settle func(ctx context.Context, settlementID string, accountID string, transactions []repository.SubBalance) (accountSettlement, error)) *transactionService {
+	rows := make([]repository.SubBalance, len(accounts))
+	for i, account := range accounts {
+		rows[i] = repository.SubBalance{ID: fmt.Sprintf("tx-%d", i), TenantID: "default", AccountID: account, Type: "credit", Amount: decimal.NewFromInt(10)}
+	}
+	return &transactionService{
+		config:            &config.Config{SettlementWorkers: workers, SettlementBatchSize: 100, SettlementInterval: "1ms"},
+		subBalanceRepo:    &fakePendingBatch{rows: rows},
+		settlementMetrics: newSettlementMetrics(workers, 100),
+		settlementDone:    make(chan struct{}),
+		settle:            settle,
+	}
+}
+
+func TestProcessSettlementCapsConcurrency(t *testing.T) {
+	tests := []struct {
+		name     string
+		workers  int
+		accounts int
+	}{
+		{"one worker", 1, 4},
+		{"fewer workers than accounts", 3, 12},
+		{"more workers than accounts", 8, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			accounts := make([]string, tt.accounts)
+			for i := range accounts {
+				accounts[i] = fmt.Sprintf("ACC%03d", i)
+			}
+			var mutex sync.Mutex
+			inFlight, peak := 0, 0
+			s := newSettlementPool(tt.workers, accounts, func(ctx context.Context, settlementID string, accountID string, transactions []repository.SubBalance) (accountSettlement, error) {
+				mutex.Lock()
+				inFlight++
+				peak = max(peak, inFlight)
+				mutex.Unlock()
+				time.Sleep(10 * time.Millisecond)
+				mutex.Lock()
+				inFlight--
+				mutex.Unlock()
+				return accountSettlement{transactions: len(transactions)}, nil
+			})
+
+			if err := s.processSettlement(context.Background(), nil); err != nil {
+				t.Fatalf("processSettlement: %v", err)
+			}
+			if want := min(tt.workers, tt.accounts); peak != want {
+				t.Fatalf("%d accounts settled at once, want %d", peak, want)
+			}
+			if stats := s.GetSettlementStats(); stats.AccountsSettled != int64(tt.accounts) {
+				t.Fatalf("accounts settled = %d, want %d", stats.AccountsSettled, tt.accounts)
+			}
+		})
+	}
+}
+
+func TestProcessSettlementIsolatesAccountErrors(t *testing.T) {
+	var mutex sync.Mutex
+	settled := make(map[string]bool)
+	s := newSettlementPool(2, []string{"ACC001", "ACC002", "ACC003", "ACC004"}, func(ctx context.Context, settlementID string, accountID string, transactions []repository.SubBalance) (accountSettlement, error) {
+		mutex.Lock()
+		settled[accountID] = true
+		mutex.Unlock()
+		switch accountID {
+		case "ACC002":
+			return accountSettlement{}, errors.New("deadlock detected")
+		case "ACC003":
+			return accountSettlement{rejected: 1}, errSettlementRejected
+		}
+		return accountSettlement{transactions: 1}, nil
+	})
+
+	if err := s.processSettlement(context.Background(), nil); err != nil {
+		t.Fatalf("processSettlement: %v", err)
+	}
+	if len(settled) != 4 {
+		t.Fatalf("settled accounts %v, want all 4 despite the failures", settled)
+	}
+	stats := s.GetSettlementStats()
+	if stats.AccountsSettled != 2 || stats.AccountsFailed != 2 || stats.TransactionsSettled != 2 || stats.TransactionsRejected != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+}
+
+func TestProcessSettlementDrainsOnShutdown(t *testing.T) {
+	stop := make(chan struct{})
+	var calls []string
+	finished := false
+	s := newSettlementPool(1, []string{"ACC001", "ACC002", "ACC003"}, func(ctx context.Context, settlementID string, accountID string, transactions []repository.SubBalance) (accountSettlement, error) {
+		calls = append(calls, accountID)
+		// SIGTERM datang saat account pertama sedang disettle
+		close(stop)
+		time.Sleep(20 * time.Millisecond)
+		finished = true
+		return accountSettlement{transactions: 1}, nil
+	})
+
+	if err := s.processSettlement(context.Background(), stop); err != nil {
+		t.Fatalf("processSettlement: %v", err)
+	}
+	if !finished {
+		t.Fatal("processSettlement returned before the in-flight account finished")
+	}
+	if len(calls) != 1 {
+		t.Fatalf("settled %v after shutdown, want only the in-flight account", calls)
+	}
+	if stats := s.GetSettlementStats(); stats.AccountsSettled != 1 || stats.Runs != 1 {
+		t.Fatalf("stats = %+v, want the in-flight account recorded", stats)
+	}
+}
+
+func TestSettlementWorkerFinishesInFlightAccountOnShutdown(t *testing.T) {
+	started := make(chan struct{})
+	var calls []string
+	var settleErr error
+	s := newSettlementPool(1, []string{"ACC001", "ACC002"}, func(ctx context.Context, settlementID string, accountID string, transactions []repository.SubBalance) (accountSettlement, error) {
+		calls = append(calls, accountID)
+		close(started)
+		time.Sleep(50 * time.Millisecond)
+		// SIGTERM tidak membatalkan account yang sedang disettle
+		settleErr = ctx.Err()
+		return accountSettlement{transactions: 1}, nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go s.StartSettlementWorker(ctx)
+	<-started
+	cancel() // SIGTERM
+
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer waitCancel()
+	if err := s.WaitForSettlement(waitCtx); err != nil {
+		t.Fatalf("WaitForSettlement: %v", err)
+	}
+	if settleErr != nil || len(calls) != 1 {
+		t.Fatalf("in-flight account ctx = %v, settled %v; want one account finished with a live ctx", settleErr, calls)
+	}
+	if stats := s.GetSettlementStats(); stats.AccountsSettled != 1 || s.SettlementWorkerRunning() {
+		t.Fatalf("stats = %+v, running = %v", stats, s.SettlementWorkerRunning())
+	}
+}
+
+// fakeCounter accepts every RemovePending
+type fakeCounter struct {
+	RedisCounter
+	removed []string
+}
+
+func (f *fakeCounter) RemovePending(ctx context.Context, accountID string, transactionIDs ...string) error {
+	f.removed = append(f.removed, transactionIDs...)
+	return nil
+}
+
+// newSettlementService returns a service that settles against an in-memory
+// SQLite database holding ACC001 with a settled balance of 100 and rows
+func newSettlementService(t *testing.T, rows ...repository.SubBalance) (*transactionService, *gorm.DB) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := db.AutoMigrate(repository.Models()...); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	accounts := repository.NewAccountBalanceRepository(db, nil)
+	err = accounts.Create(context.Background(), &repository.AccountBalance{ID: "ACC001", SettledBalance: decimal.NewFromInt(100)})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	for i := range rows {
+		rows[i].TenantID, rows[i].AccountID, rows[i].Status = "default", "ACC001", "PENDING"
+		if err := db.Create(&rows[i]).Error; err != nil {
+			t.Fatalf("create sub balance: %v", err)
+		}
+	}
+
+	s := &transactionService{
+		db:                 db,
+		accountBalanceRepo: accounts,
+		subBalanceRepo:     repository.NewSubBalanceRepository(db, nil),
+		settlementAudit:    repository.NewSettlementAuditRepository(db, nil),
+		balanceAudit:       NewBalanceAuditTrail(repository.NewBalanceAuditRepository(db, nil)),
+		redisCounter:       &fakeCounter{},
+		config:             &config.Config{SettlementWorkers: 1, SettlementBatchSize: 100, SettlementMaxRetries: 3},
+		settlementMetrics:  newSettlementMetrics(1, 100),
+	}
+	s.settle = s.settleAccount
+	s.settlementRepo = repository.NewSettlementRepository
+	return s, db
+}
+
+func settledBalance(t *testing.T, s *transactionService) decimal.Decimal {
+	t.Helper()
+	balance, err := s.accountBalanceRepo.GetByID(context.Background(), "ACC001")
+	if err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+	return balance.SettledBalance
+}
+
+func TestSettleAccountSettlesBatchOnce(t *testing.T) {
+	tests := []struct {
+		name          string
+		settlementIDs [2]string
+	}{
+		{"two runs", [2]string{"run-1", "run-2"}},
+		{"same run retried", [2]string{"run-1", "run-1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newSettlementService(t,
+				repository.SubBalance{ID: "tx-1", Type: "credit", Amount: decimal.NewFromInt(30)},
+				repository.SubBalance{ID: "tx-2", Type: "debit", Amount: decimal.NewFromInt(10)},
+			)
+			batch, _, err := s.nextSettlementBatch(context.Background(), "", 100)
+			if err != nil || len(batch) != 2 {
+				t.Fatalf("nextSettlementBatch = %d rows, %v", len(batch), err)
+			}
+
+			first, err := s.settleAccount(context.Background(), tt.settlementIDs[0], "ACC001", batch)
+			if err != nil || first.transactions != 2 {
+				t.Fatalf("first settleAccount = %+v, %v", first, err)
+			}
+			// Batch yang sama, mis. dibaca sebelum run pertama commit
+			second, err := s.settleAccount(context.Background(), tt.settlementIDs[1], "ACC001", batch)
+			if err != nil || second.transactions != 0 {
+				t.Fatalf("second settleAccount = %+v, %v; want nothing settled", second, err)
+			}
+			if got := settledBalance(t, s); !got.Equal(decimal.NewFromInt(120)) {
+				t.Fatalf("settled balance = %s, want 120 (batch applied once)", got)
+			}
+		})
+	}
+}
+
+// fakeSetBased stands in for the Postgres-only set-based statement
+type fakeSetBased struct {
+	result repository.SetBasedSettlement
+}
+
+func (f *fakeSetBased) SettleAccount(ctx context.Context, accountID string, settlementID string, ids []string) (*repository.SetBasedSettlement, error) {
+	result := f.result
+	return &result, nil
+}
+
+func TestSettleAccountSetBasedRejectsOnlyStampedRows(t *testing.T) {
+	// tx-2 ada di batch tetapi sudah disettle run lain sebelum statement berjalan
+	batch := []repository.SubBalance{
+		{ID: "tx-1", Type: "debit", Amount: decimal.NewFromInt(500), Attempts: 3},
+		{ID: "tx-2", Type: "debit", Amount: decimal.NewFromInt(5), Attempts: 3},
+	}
+	s, db := newSettlementService(t, batch...)
+	db.Model(&repository.SubBalance{}).Where("id = ?", "tx-2").Update("status", "SETTLED")
+	s.settlementRepo = func(tx *gorm.DB) repository.SettlementRepository {
+		return &fakeSetBased{result: repository.SetBasedSettlement{Transactions: 1, Delta: decimal.NewFromInt(-500), TransactionIDs: repository.StringList{"tx-1"}}}
+	}
+
+	result, err := s.settleAccountSetBased(context.Background(), "run-1", "ACC001", batch)
+	if !errors.Is(err, errSettlementRejected) {
+		t.Fatalf("settleAccountSetBased = %v, want errSettlementRejected", err)
+	}
+	if result.rejected != 1 {
+		t.Fatalf("rejected = %d, want 1", result.rejected)
+	}
+	if removed := s.redisCounter.(*fakeCounter).removed; len(removed) != 1 || removed[0] != "tx-1" {
+		t.Fatalf("removed from the counter %v, want only tx-1", removed)
+	}
+	var statuses []repository.SubBalance
+	db.Order("id").Find(&statuses)
+	if statuses[0].Status != "FAILED" || statuses[1].Status != "SETTLED" {
+		t.Fatalf("statuses = %s, %s; want FAILED, SETTLED", statuses[0].Status, statuses[1].Status)
+	}
+}
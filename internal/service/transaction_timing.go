@@ -0,0 +1,159 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"sub-balance-demo/internal/logmask"
+	"sub-balance-demo/internal/repository"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Jalur yang dipakai ProcessTransaction, dicatat di log transaksi lambat
+const (
+	transactionPathRedis        = "redis"
+	transactionPathLocalCounter = "local_counter"
+	transactionPathFallback     = "database_fallback"
+	transactionPathRealtime     = "realtime"
+)
+
+type transactionTimingKey struct{}
+
+// transactionTiming collects the path, the rejection reason and how long each
+// phase of one ProcessTransaction call took. It is only touched by the request
+// goroutine.
+type transactionTiming struct {
+	path         string
+	rejectReason string
+	phases       []transactionPhase
+}
+
+type transactionPhase struct {
+	name     string
+	duration time.Duration
+}
+
+func withTransactionTiming(ctx context.Context) (context.Context, *transactionTiming) {
+	timing := &transactionTiming{}
+	return context.WithValue(ctx, transactionTimingKey{}, timing), timing
+}
+
+func transactionTimingFrom(ctx context.Context) *transactionTiming {
+	timing, _ := ctx.Value(transactionTimingKey{}).(*transactionTiming)
+	return timing
+}
+
+// timePhase starts timing name; call the returned function when the phase
+// ends. Without a timing in ctx (settlement, repair) it does nothing.
+func timePhase(ctx context.Context, name string) func() {
+	timing := transactionTimingFrom(ctx)
+	if timing == nil {
+		return func() {}
+	}
+	start := time.Now()
+	return func() {
+		timing.phases = append(timing.phases, transactionPhase{name: name, duration: time.Since(start)})
+	}
+}
+
+// setTransactionPath records the strategy handling the transaction; a Redis
+// failure that falls back to the database ends up as database_fallback
+func setTransactionPath(ctx context.Context, path string) {
+	if timing := transactionTimingFrom(ctx); timing != nil {
+		timing.path = path
+	}
+}
+
+// setRejectReason records why the transaction was rejected, for the
+// rejection counters
+func setRejectReason(ctx context.Context, reason string) {
+	if timing := transactionTimingFrom(ctx); timing != nil {
+		timing.rejectReason = reason
+	}
+}
+
+// breakdown sums the phases by name in order of first appearance; time not
+// covered by any phase is reported as "other"
+func (t *transactionTiming) breakdown(total time.Duration) []transactionPhase {
+	var phases []transactionPhase
+	index := make(map[string]int)
+	var covered time.Duration
+	for _, phase := range t.phases {
+		covered += phase.duration
+		if i, ok := index[phase.name]; ok {
+			phases[i].duration += phase.duration
+			continue
+		}
+		index[phase.name] = len(phases)
+		phases = append(phases, phase)
+	}
+	if other := total - covered; other > 0 {
+		phases = append(phases, transactionPhase{name: "other", duration: other})
+	}
+	return phases
+}
+
+// flagSlowTransaction logs the per-phase breakdown of a transaction over the
+// latency budget and adds it to the ProcessTransaction span
+func (s *transactionService) flagSlowTransaction(span trace.Span, req *repository.TransactionRequest, response *repository.TransactionResponse, err error, timing *transactionTiming, elapsed time.Duration) {
+	phases := timing.breakdown(elapsed)
+	durationMs := float64(elapsed) / float64(time.Millisecond)
+	budgetMs := float64(s.latencyBudget) / float64(time.Millisecond)
+
+	status := "error"
+	if err == nil && response != nil {
+		status = response.Status
+	}
+
+	attrs := []attribute.KeyValue{
+		attribute.String("transaction.path", timing.path),
+		attribute.Float64("transaction.duration_ms", durationMs),
+		attribute.Float64("transaction.budget_ms", budgetMs),
+	}
+	phaseMs := make(map[string]float64, len(phases))
+	for _, phase := range phases {
+		ms := float64(phase.duration) / float64(time.Millisecond)
+		phaseMs[phase.name] = ms
+		attrs = append(attrs, attribute.Float64("phase."+phase.name+"_ms", ms))
+	}
+	span.SetAttributes(attribute.Bool("transaction.slow", true))
+	span.AddEvent("slow_transaction", trace.WithAttributes(attrs...))
+
+	if s.config.LogFormat != "json" {
+		parts := make([]string, 0, len(phases))
+		for _, phase := range phases {
+			parts = append(parts, fmt.Sprintf("%s_ms=%.2f", phase.name, phaseMs[phase.name]))
+		}
+		log.Printf("level=warn msg=\"slow transaction\" account_id=%s type=%s path=%s status=%s duration_ms=%.2f budget_ms=%.0f %s",
+			logmask.Account(req.AccountID), req.Type, timing.path, status, durationMs, budgetMs, strings.Join(parts, " "))
+		return
+	}
+
+	fields := map[string]interface{}{
+		"level":       "warn",
+		"msg":         "slow transaction",
+		"account_id":  logmask.Account(req.AccountID),
+		"type":        req.Type,
+		"path":        timing.path,
+		"status":      status,
+		"duration_ms": durationMs,
+		"budget_ms":   budgetMs,
+		"phases_ms":   phaseMs,
+	}
+	if spanContext := span.SpanContext(); spanContext.HasTraceID() {
+		fields["trace_id"] = spanContext.TraceID().String()
+	}
+	entry, jsonErr := json.Marshal(fields)
+	if jsonErr != nil {
+		log.Printf("Failed to encode slow transaction entry: %v", jsonErr)
+		return
+	}
+	fmt.Fprintln(os.Stdout, string(entry))
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// WebhookNotifier posts JSON event notifications to a configured URL.
+// A notifier without URL is a no-op.
+type WebhookNotifier struct {
+	url    string
+	slack  bool
+	client *http.Client
+}
+
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{
+		url:    url,
+		client: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// NewSlackNotifier posts to a Slack incoming webhook, which only accepts a
+// text message; the event data is rendered as a JSON code block
+func NewSlackNotifier(url string) *WebhookNotifier {
+	notifier := NewWebhookNotifier(url)
+	notifier.slack = true
+	return notifier
+}
+
+func (n *WebhookNotifier) Notify(ctx context.Context, event string, data interface{}) error {
+	if n == nil || n.url == "" {
+		return nil
+	}
+
+	body, err := n.payload(event, data)
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (n *WebhookNotifier) payload(event string, data interface{}) ([]byte, error) {
+	if !n.slack {
+		return json.Marshal(map[string]interface{}{
+			"event":     event,
+			"timestamp": time.Now(),
+			"data":      data,
+		})
+	}
+
+	detail, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]string{
+		"text": fmt.Sprintf(":rotating_light: *%s*\n```%s```", event, detail),
+	})
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// ErrWebhookTargetNotAllowed is returned for a subscription URL whose host
+// does not resolve, or resolves to an address webhooks must not reach
+var ErrWebhookTargetNotAllowed = errors.New("url host must resolve to a public address, not a loopback, link-local or private one")
+
+// webhookDialTimeout bounds connecting to an endpoint; the request as a whole
+// is bounded by WebhookOptions.Timeout
+const webhookDialTimeout = 5 * time.Second
+
+// forbiddenWebhookIP reports whether ip is loopback, link-local (including
+// the 169.254.169.254 metadata endpoint), private (RFC 1918, fc00::/7) or
+// unspecified; IPv4-mapped IPv6 addresses are checked as IPv4
+func forbiddenWebhookIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsPrivate() || ip.IsUnspecified()
+}
+
+// checkWebhookHost resolves host and rejects it when any of its addresses is
+// forbidden. It is the early check at subscription time; the dialer checks
+// again, since DNS can change after the subscription was created.
+func checkWebhookHost(ctx context.Context, resolver *net.Resolver, host string) error {
+	addresses, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrWebhookTargetNotAllowed, err)
+	}
+	for _, address := range addresses {
+		if forbiddenWebhookIP(address.IP) {
+			return fmt.Errorf("%w: %s resolves to %s", ErrWebhookTargetNotAllowed, host, address.IP)
+		}
+	}
+	return nil
+}
+
+// webhookDialControl runs after name resolution, on the address actually
+// dialed, so a host that resolved to a public address at creation and to a
+// private one now is still refused
+func webhookDialControl(network string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || forbiddenWebhookIP(ip) {
+		return fmt.Errorf("%w: refusing to dial %s", ErrWebhookTargetNotAllowed, address)
+	}
+	return nil
+}
+
+// newWebhookTransport dials endpoints directly, without HTTP_PROXY: behind a
+// proxy the dialer would only see the proxy address. With allowPrivate the
+// address check is skipped (local development).
+func newWebhookTransport(allowPrivate bool) *http.Transport {
+	dialer := &net.Dialer{Timeout: webhookDialTimeout}
+	if !allowPrivate {
+		dialer.Control = webhookDialControl
+	}
+	return &http.Transport{
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+}
@@ -0,0 +1,598 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"sub-balance-demo/internal/eventstream"
+	"sub-balance-demo/internal/pagination"
+	"sub-balance-demo/internal/repository"
+	"sub-balance-demo/internal/secrets"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrInvalidWebhook is returned for a subscription without an http(s) URL
+	// or with an unknown event type
+	ErrInvalidWebhook = errors.New("url must be an absolute http or https URL and event_types must be known event types")
+	// ErrWebhookSubscriptionNotFound is returned for an unknown or deleted
+	// subscription ID
+	ErrWebhookSubscriptionNotFound = errors.New("webhook subscription not found")
+	// ErrWebhookDeliveryNotFound is returned for an unknown delivery ID
+	ErrWebhookDeliveryNotFound = errors.New("webhook delivery not found")
+	// ErrWebhookDeliveryPending is returned when replaying a delivery that is
+	// still queued
+	ErrWebhookDeliveryPending = errors.New("webhook delivery is still pending")
+	// ErrWebhookDeliveryCancelled is returned when replaying a delivery of a
+	// deleted subscription
+	ErrWebhookDeliveryCancelled = errors.New("webhook subscription of the delivery was deleted")
+)
+
+// webhookEventTypes are the event types a subscription may select
+var webhookEventTypes = []string{
+	eventstream.TransactionAccepted,
+	eventstream.TransactionSettled,
+	eventstream.TransactionRejected,
+	eventstream.AccountRepaired,
+	eventstream.SettlementCompleted,
+}
+
+const (
+	// subscriptionCacheTTL bounds how long a subscription created or deleted
+	// on another instance goes unnoticed by this one
+	subscriptionCacheTTL = 10 * time.Second
+	// consumerRetryDelay is the pause before the event stream is read again
+	// after the consumer failed
+	consumerRetryDelay = 5 * time.Second
+	// webhookLeaseMargin is added to the request timeout to lease a claimed
+	// delivery, so it is not claimed again while its attempt is recorded
+	webhookLeaseMargin = 30 * time.Second
+)
+
+// WebhookOptions tune delivery; zero values get the defaults of NewWebhookService
+type WebhookOptions struct {
+	BatchSize      int
+	MaxAttempts    int
+	RetryBaseDelay time.Duration
+	RetryMaxDelay  time.Duration
+	Timeout        time.Duration
+	// SecretKey encrypts subscription secrets at rest like enc: config
+	// values (the master key); nil stores them in plain text
+	SecretKey []byte
+	// AllowPrivateTargets lets subscriptions reach loopback, link-local and
+	// private addresses, for local development only
+	AllowPrivateTargets bool
+	// SecretOverlap is how long the previous secret still signs deliveries
+	// after a rotation
+	SecretOverlap time.Duration
+}
+
+// WebhookStats are the counters exported as metrics
+type WebhookStats struct {
+	Delivered    int64 // deliveries acknowledged with a 2xx
+	FailedTries  int64 // attempts that failed and will be retried
+	DeadLettered int64 // deliveries given up after MaxAttempts
+}
+
+// WebhookService delivers the events of the event stream to the subscribed
+// HTTP endpoints. Each event is queued once per matching subscription in the
+// webhook_deliveries table, then sent by a worker that retries with
+// exponential backoff and dead-letters a delivery (status failed) after
+// MaxAttempts; failed deliveries can be replayed through the admin API. Every
+// HTTP call is recorded in webhook_delivery_attempts. Requests are signed with
+// the subscription secret:
+//
+//	X-Webhook-Signature: t=<unix time>,v1=<hex HMAC-SHA256(secret, "<t>.<body>")>
+//
+// For SecretOverlap after a rotation a second v1 signature is added, made
+// with the previous secret; receivers accept a request when any v1 matches.
+// Endpoints must resolve to public addresses, checked when a subscription is
+// created and again on every dial. A nil *WebhookService is disabled.
+type WebhookService struct {
+	db       *gorm.DB
+	repo     repository.WebhookRepository
+	consumer *eventstream.Consumer
+	client   *http.Client
+	options  WebhookOptions
+
+	mutex         sync.Mutex
+	subscriptions map[string]repository.WebhookSubscription
+	loadedAt      time.Time
+
+	delivered    atomic.Int64
+	failedTries  atomic.Int64
+	deadLettered atomic.Int64
+}
+
+func NewWebhookService(db *gorm.DB, repo repository.WebhookRepository, consumer *eventstream.Consumer, options WebhookOptions) *WebhookService {
+	if options.BatchSize <= 0 {
+		options.BatchSize = 50
+	}
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = 8
+	}
+	if options.RetryBaseDelay <= 0 {
+		options.RetryBaseDelay = 10 * time.Second
+	}
+	if options.RetryMaxDelay < options.RetryBaseDelay {
+		options.RetryMaxDelay = max(time.Hour, options.RetryBaseDelay)
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = 10 * time.Second
+	}
+	if options.SecretOverlap <= 0 {
+		options.SecretOverlap = 24 * time.Hour
+	}
+	return &WebhookService{
+		db:       db,
+		repo:     repo,
+		consumer: consumer,
+		client: &http.Client{
+			Timeout:   options.Timeout,
+			Transport: newWebhookTransport(options.AllowPrivateTargets),
+			// Redirect tidak diikuti: endpoint harus menjawab sendiri
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		options: options,
+	}
+}
+
+func (s *WebhookService) Enabled() bool {
+	return s != nil
+}
+
+// CreateSubscription validates and stores a subscription with a new secret.
+// The returned subscription is the only place the secret is shown.
+func (s *WebhookService) CreateSubscription(ctx context.Context, rawURL string, eventTypes []string) (*repository.WebhookSubscription, error) {
+	endpoint, err := url.Parse(rawURL)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, ErrInvalidWebhook
+	}
+	for _, eventType := range eventTypes {
+		if !slices.Contains(webhookEventTypes, eventType) {
+			return nil, ErrInvalidWebhook
+		}
+	}
+	if !s.options.AllowPrivateTargets {
+		if err := checkWebhookHost(ctx, net.DefaultResolver, endpoint.Hostname()); err != nil {
+			return nil, err
+		}
+	}
+
+	secret, err := newWebhookSecret()
+	if err != nil {
+		return nil, err
+	}
+	subscription := &repository.WebhookSubscription{
+		ID:         uuid.New().String(),
+		URL:        rawURL,
+		EventTypes: eventTypes,
+	}
+	if subscription.Secret, err = s.sealSecret(subscription.ID, secret); err != nil {
+		return nil, err
+	}
+	if err := s.repo.CreateSubscription(ctx, subscription); err != nil {
+		return nil, err
+	}
+	subscription.Secret = secret
+
+	s.invalidateSubscriptions()
+	log.Printf("Webhook subscription %s created for %s", subscription.ID, endpoint.Host)
+	return subscription, nil
+}
+
+// RotateSecret gives a subscription a new secret. Deliveries stay signed with
+// the old secret too for SecretOverlap, so the receiver can deploy the new one
+// in the meantime. The returned subscription is the only place the new secret
+// is shown.
+func (s *WebhookService) RotateSecret(ctx context.Context, id string) (*repository.WebhookSubscription, error) {
+	secret, err := newWebhookSecret()
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := s.sealSecret(id, secret)
+	if err != nil {
+		return nil, err
+	}
+	subscription, err := s.repo.RotateSecret(ctx, id, sealed, time.Now().Add(s.options.SecretOverlap))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrWebhookSubscriptionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	subscription.Secret = secret
+
+	s.invalidateSubscriptions()
+	log.Printf("Webhook subscription %s secret rotated, previous secret valid until %s",
+		id, subscription.PreviousSecretExpiresAt.Format(time.RFC3339))
+	return subscription, nil
+}
+
+func newWebhookSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
+	return "whsec_" + hex.EncodeToString(secret), nil
+}
+
+// webhookSecretName is the additional data of a sealed secret, so a secret
+// copied to another subscription does not decrypt
+func webhookSecretName(subscriptionID string) string {
+	return "webhook_subscription:" + subscriptionID
+}
+
+// sealSecret encrypts a secret for storage when SecretKey is set
+func (s *WebhookService) sealSecret(subscriptionID string, secret string) (string, error) {
+	if s.options.SecretKey == nil {
+		return secret, nil
+	}
+	sealed, err := secrets.Encrypt(s.options.SecretKey, webhookSecretName(subscriptionID), secret)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt webhook secret: %w", err)
+	}
+	return sealed, nil
+}
+
+// openSecret decrypts a stored secret; secrets stored before encryption was
+// enabled are used as they are
+func (s *WebhookService) openSecret(subscriptionID string, stored string) (string, error) {
+	if !strings.HasPrefix(stored, secrets.EncryptedPrefix) {
+		return stored, nil
+	}
+	if s.options.SecretKey == nil {
+		return "", errors.New("webhook secret is encrypted but no master key is configured")
+	}
+	return secrets.Decrypt(s.options.SecretKey, webhookSecretName(subscriptionID), stored)
+}
+
+func (s *WebhookService) ListSubscriptions(ctx context.Context) ([]repository.WebhookSubscription, error) {
+	return s.repo.ListSubscriptions(ctx)
+}
+
+// DeleteSubscription stops deliveries to a subscription and cancels its
+// queued deliveries
+func (s *WebhookService) DeleteSubscription(ctx context.Context, id string) (bool, error) {
+	deleted, err := s.repo.DeleteSubscription(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	s.invalidateSubscriptions()
+	if deleted {
+		log.Printf("Webhook subscription %s deleted", id)
+	}
+	return deleted, nil
+}
+
+func (s *WebhookService) ListDeliveries(ctx context.Context, filter repository.WebhookDeliveryFilter) (pagination.Page[repository.WebhookDelivery], error) {
+	return s.repo.ListDeliveries(ctx, filter)
+}
+
+// GetDelivery returns a delivery with its attempts, oldest first
+func (s *WebhookService) GetDelivery(ctx context.Context, id int64) (*repository.WebhookDelivery, []repository.WebhookDeliveryAttempt, error) {
+	delivery, attempts, err := s.repo.GetDelivery(ctx, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, ErrWebhookDeliveryNotFound
+	}
+	return delivery, attempts, err
+}
+
+// Replay queues a delivered or dead-lettered delivery again with a fresh
+// attempt budget. It keeps its place in ID order, so it goes out ahead of any
+// later delivery of the subscription that is still pending.
+func (s *WebhookService) Replay(ctx context.Context, id int64) error {
+	replayed, err := s.repo.Replay(ctx, id, time.Now())
+	if err != nil {
+		return err
+	}
+	if replayed {
+		log.Printf("Webhook delivery %d queued for replay", id)
+		return nil
+	}
+	delivery, _, err := s.GetDelivery(ctx, id)
+	if err != nil {
+		return err
+	}
+	if delivery.Status == repository.WebhookPending {
+		return ErrWebhookDeliveryPending
+	}
+	return ErrWebhookDeliveryCancelled
+}
+
+func (s *WebhookService) Stats() WebhookStats {
+	if s == nil {
+		return WebhookStats{}
+	}
+	return WebhookStats{
+		Delivered:    s.delivered.Load(),
+		FailedTries:  s.failedTries.Load(),
+		DeadLettered: s.deadLettered.Load(),
+	}
+}
+
+// Start queues deliveries from the event stream and sends due deliveries on
+// the given interval until ctx is cancelled
+func (s *WebhookService) Start(ctx context.Context, interval time.Duration) {
+	go s.consume(ctx)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	log.Println("Webhook delivery worker started")
+	for {
+		select {
+		case <-ticker.C:
+			for ctx.Err() == nil {
+				claimed, err := s.DeliverOnce(ctx)
+				if err != nil {
+					log.Printf("Webhook delivery failed: %v", err)
+					break
+				}
+				if claimed < s.options.BatchSize {
+					break
+				}
+			}
+		case <-ctx.Done():
+			log.Println("Webhook delivery worker stopped")
+			return
+		}
+	}
+}
+
+// consume reads the event stream as the "webhooks" consumer group, reading
+// again after a pause whenever the stream cannot be read
+func (s *WebhookService) consume(ctx context.Context) {
+	for {
+		err := s.consumer.Run(ctx, s.enqueue)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Webhook event consumer failed, retrying in %s: %v", consumerRetryDelay, err)
+		select {
+		case <-time.After(consumerRetryDelay):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// enqueue queues event for every subscription that selects its type. The
+// event_id set by the outbox relay, or else the stream entry ID, makes a
+// redelivered entry a no-op.
+func (s *WebhookService) enqueue(ctx context.Context, event eventstream.Event) error {
+	subscriptions, err := s.activeSubscriptions(ctx, false)
+	if err != nil {
+		return err
+	}
+
+	if event.EventID == "" {
+		event.EventID = event.ID
+	}
+	event.ID = ""
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s event: %w", event.Type, err)
+	}
+
+	now := time.Now()
+	var deliveries []repository.WebhookDelivery
+	for _, subscription := range subscriptions {
+		if len(subscription.EventTypes) > 0 && !slices.Contains(subscription.EventTypes, event.Type) {
+			continue
+		}
+		deliveries = append(deliveries, repository.WebhookDelivery{
+			SubscriptionID: subscription.ID,
+			EventID:        event.EventID,
+			EventType:      event.Type,
+			AccountID:      event.AccountID,
+			Payload:        string(payload),
+			Status:         repository.WebhookPending,
+			NextAttemptAt:  now,
+		})
+	}
+	return s.repo.Enqueue(ctx, deliveries)
+}
+
+// DeliverOnce claims one batch of due deliveries, at most one per
+// subscription, sends them concurrently and returns how many were claimed
+func (s *WebhookService) DeliverOnce(ctx context.Context) (int, error) {
+	var deliveries []repository.WebhookDelivery
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		deliveries, err = s.repo.WithTx(tx).ClaimDue(ctx, time.Now(), s.options.Timeout+webhookLeaseMargin, s.options.BatchSize)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to claim webhook deliveries: %w", err)
+	}
+	if len(deliveries) == 0 {
+		return 0, nil
+	}
+
+	subscriptions, err := s.activeSubscriptions(ctx, false)
+	if err != nil {
+		return 0, err
+	}
+	for _, delivery := range deliveries {
+		if _, ok := subscriptions[delivery.SubscriptionID]; !ok {
+			// Subscription dibuat di instance lain setelah cache dimuat
+			if subscriptions, err = s.activeSubscriptions(ctx, true); err != nil {
+				return 0, err
+			}
+			break
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := range deliveries {
+		subscription, ok := subscriptions[deliveries[i].SubscriptionID]
+		if !ok {
+			continue // dihapus setelah di-claim, lease habis dan tidak di-claim lagi
+		}
+		wg.Add(1)
+		go func(delivery *repository.WebhookDelivery) {
+			defer wg.Done()
+			s.deliver(ctx, subscription, delivery)
+		}(&deliveries[i])
+	}
+	wg.Wait()
+	return len(deliveries), nil
+}
+
+// deliver sends one delivery and records the attempt. An attempt cut short by
+// shutdown is not recorded; the delivery is retried once its lease runs out.
+func (s *WebhookService) deliver(ctx context.Context, subscription repository.WebhookSubscription, delivery *repository.WebhookDelivery) {
+	started := time.Now()
+	statusCode, err := s.send(ctx, subscription, delivery)
+	if ctx.Err() != nil {
+		return
+	}
+
+	now := time.Now()
+	delivery.Attempts++
+	delivery.LastStatusCode = statusCode
+	attempt := &repository.WebhookDeliveryAttempt{
+		DeliveryID: delivery.ID,
+		Attempt:    delivery.Attempts,
+		StatusCode: statusCode,
+		DurationMs: now.Sub(started).Milliseconds(),
+	}
+
+	switch {
+	case err == nil:
+		delivery.Status = repository.WebhookDelivered
+		delivery.LastError = ""
+		delivery.DeliveredAt = &now
+		s.delivered.Add(1)
+	case delivery.Attempts >= s.options.MaxAttempts:
+		delivery.Status = repository.WebhookFailed
+		delivery.LastError = err.Error()
+		attempt.Error = err.Error()
+		s.deadLettered.Add(1)
+		log.Printf("WARNING: webhook delivery %d (%s) to subscription %s dead-lettered after %d attempts: %v",
+			delivery.ID, delivery.EventType, delivery.SubscriptionID, delivery.Attempts, err)
+	default:
+		delivery.NextAttemptAt = now.Add(s.retryDelay(delivery.Attempts))
+		delivery.LastError = err.Error()
+		attempt.Error = err.Error()
+		s.failedTries.Add(1)
+		log.Printf("Webhook delivery %d (%s) attempt %d failed, retrying at %s: %v",
+			delivery.ID, delivery.EventType, delivery.Attempts, delivery.NextAttemptAt.Format(time.RFC3339), err)
+	}
+
+	if err := s.repo.RecordAttempt(ctx, delivery, attempt); err != nil {
+		log.Printf("Failed to record webhook delivery %d attempt %d: %v", delivery.ID, delivery.Attempts, err)
+	}
+}
+
+// send posts the payload and returns the response status; any status other
+// than 2xx is an error
+func (s *WebhookService) send(ctx context.Context, subscription repository.WebhookSubscription, delivery *repository.WebhookDelivery) (int, error) {
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", delivery.EventType)
+	req.Header.Set("X-Webhook-Delivery", strconv.FormatInt(delivery.ID, 10))
+	now := time.Now()
+	req.Header.Set("X-Webhook-Signature", signWebhook(subscription.SigningSecrets(now), now, body))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// Body dibuang supaya koneksi bisa dipakai ulang
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return resp.StatusCode, fmt.Errorf("endpoint returned status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// retryDelay is RetryBaseDelay doubled for every failed attempt after the
+// first, capped at RetryMaxDelay
+func (s *WebhookService) retryDelay(attempts int) time.Duration {
+	delay := s.options.RetryBaseDelay
+	for i := 1; i < attempts && delay < s.options.RetryMaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, s.options.RetryMaxDelay)
+}
+
+// signWebhook returns the X-Webhook-Signature value of body sent at t, with
+// one v1 signature per secret. The timestamp is signed too, so receivers can
+// reject replayed requests.
+func signWebhook(secrets []string, t time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	signature := "t=" + timestamp
+	for _, secret := range secrets {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(timestamp + "."))
+		mac.Write(body)
+		signature += ",v1=" + hex.EncodeToString(mac.Sum(nil))
+	}
+	return signature
+}
+
+// activeSubscriptions returns the subscriptions by ID, reloaded when the
+// cache is older than subscriptionCacheTTL or reload is set
+func (s *WebhookService) activeSubscriptions(ctx context.Context, reload bool) (map[string]repository.WebhookSubscription, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !reload && s.subscriptions != nil && time.Since(s.loadedAt) < subscriptionCacheTTL {
+		return s.subscriptions, nil
+	}
+
+	list, err := s.repo.ListSubscriptions(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load webhook subscriptions: %w", err)
+	}
+	s.subscriptions = make(map[string]repository.WebhookSubscription, len(list))
+	for _, subscription := range list {
+		// Subscription dengan secret yang tidak bisa dibuka tidak dikirimi,
+		// delivery-nya menunggu sampai master key yang benar dipasang
+		if subscription.Secret, err = s.openSecret(subscription.ID, subscription.Secret); err != nil {
+			log.Printf("WARNING: skipping webhook subscription %s: %v", subscription.ID, err)
+			continue
+		}
+		if subscription.PreviousSecret, err = s.openSecret(subscription.ID, subscription.PreviousSecret); err != nil {
+			subscription.PreviousSecret = ""
+		}
+		s.subscriptions[subscription.ID] = subscription
+	}
+	s.loadedAt = time.Now()
+	return s.subscriptions, nil
+}
+
+func (s *WebhookService) invalidateSubscriptions() {
+	s.mutex.Lock()
+	s.subscriptions = nil
+	s.mutex.Unlock()
+}
@@ -0,0 +1,184 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"sub-balance-demo/internal/repository"
+	"sub-balance-demo/internal/secrets"
+)
+
+// verifyWebhook is what a receiver does: accept when any v1 matches
+func verifyWebhook(header string, secret string, body []byte) bool {
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+	for _, signature := range signatures {
+		if hmac.Equal([]byte(signature), []byte(expected)) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSignWebhookDuringRotation(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	expires := now.Add(time.Hour)
+	subscription := repository.WebhookSubscription{
+		Secret:                  "whsec_new",
+		PreviousSecret:          "whsec_old",
+		PreviousSecretExpiresAt: &expires,
+	}
+	body := []byte(`{"type":"transaction.settled"}`)
+
+	tests := []struct {
+		name       string
+		at         time.Time
+		signatures int
+		oldValid   bool
+	}{
+		{"inside the overlap window", now, 2, true},
+		{"after the overlap window", expires, 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := signWebhook(subscription.SigningSecrets(tt.at), tt.at, body)
+			if !strings.HasPrefix(header, "t=") || strings.Count(header, "v1=") != tt.signatures {
+				t.Fatalf("header = %q, want t= and %d v1 signatures", header, tt.signatures)
+			}
+			if !verifyWebhook(header, "whsec_new", body) {
+				t.Fatal("new secret does not verify")
+			}
+			if verifyWebhook(header, "whsec_old", body) != tt.oldValid {
+				t.Fatalf("old secret verifies = %v, want %v", !tt.oldValid, tt.oldValid)
+			}
+			if verifyWebhook(header, "whsec_new", []byte(`{"type":"transaction.rejected"}`)) {
+				t.Fatal("signature verifies another body")
+			}
+		})
+	}
+}
+
+func TestSigningSecretsWithoutRotation(t *testing.T) {
+	subscription := repository.WebhookSubscription{Secret: "whsec_only"}
+	secrets := subscription.SigningSecrets(time.Now())
+	if len(secrets) != 1 || secrets[0] != "whsec_only" {
+		t.Fatalf("SigningSecrets = %v, want [whsec_only]", secrets)
+	}
+}
+
+func TestForbiddenWebhookIP(t *testing.T) {
+	tests := []struct {
+		ip        string
+		forbidden bool
+	}{
+		{"127.0.0.1", true},
+		{"::1", true},
+		{"169.254.169.254", true},
+		{"fe80::1", true},
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"172.31.255.255", true},
+		{"192.168.1.1", true},
+		{"fd00::1", true},
+		{"0.0.0.0", true},
+		{"::ffff:127.0.0.1", true},
+		{"::ffff:10.0.0.1", true},
+		{"8.8.8.8", false},
+		{"172.32.0.1", false},
+		{"2606:4700::1111", false},
+	}
+	for _, tt := range tests {
+		if got := forbiddenWebhookIP(net.ParseIP(tt.ip)); got != tt.forbidden {
+			t.Errorf("forbiddenWebhookIP(%s) = %v, want %v", tt.ip, got, tt.forbidden)
+		}
+	}
+}
+
+func TestCreateSubscriptionRejectsInternalHosts(t *testing.T) {
+	service := NewWebhookService(nil, nil, nil, WebhookOptions{})
+	for _, rawURL := range []string{
+		"http://127.0.0.1:8080/hook",
+		"http://localhost/hook",
+		"http://169.254.169.254/latest/meta-data/",
+		"https://10.0.0.5/hook",
+		"http://[::1]/hook",
+	} {
+		_, err := service.CreateSubscription(context.Background(), rawURL, nil)
+		if !errors.Is(err, ErrWebhookTargetNotAllowed) {
+			t.Errorf("CreateSubscription(%s) error = %v, want ErrWebhookTargetNotAllowed", rawURL, err)
+		}
+	}
+}
+
+func TestWebhookDialRefusesInternalAddress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request reached a loopback endpoint")
+	}))
+	defer server.Close()
+
+	// Subscription lolos saat dibuat (mis. DNS berubah sesudahnya); dial tetap ditolak
+	service := NewWebhookService(nil, nil, nil, WebhookOptions{})
+	subscription := repository.WebhookSubscription{URL: server.URL, Secret: "whsec_test"}
+	_, err := service.send(context.Background(), subscription, &repository.WebhookDelivery{ID: 1, Payload: "{}"})
+	if !errors.Is(err, ErrWebhookTargetNotAllowed) {
+		t.Fatalf("send error = %v, want ErrWebhookTargetNotAllowed", err)
+	}
+
+	allowed := NewWebhookService(nil, nil, nil, WebhookOptions{AllowPrivateTargets: true})
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	if status, err := allowed.send(context.Background(), subscription, &repository.WebhookDelivery{ID: 1, Payload: "{}"}); err != nil || status != http.StatusOK {
+		t.Fatalf("send with AllowPrivateTargets = %d, %v", status, err)
+	}
+}
+
+func TestWebhookSecretSealing(t *testing.T) {
+	service := NewWebhookService(nil, nil, nil, WebhookOptions{SecretKey: bytes.Repeat([]byte{7}, 32)})
+	sealed, err := service.sealSecret("sub-1", "whsec_plain")
+	if err != nil {
+		t.Fatalf("sealSecret: %v", err)
+	}
+	if !strings.HasPrefix(sealed, secrets.EncryptedPrefix) || strings.Contains(sealed, "whsec_plain") {
+		t.Fatalf("sealed secret = %q, want an enc: value", sealed)
+	}
+	if opened, err := service.openSecret("sub-1", sealed); err != nil || opened != "whsec_plain" {
+		t.Fatalf("openSecret = %q, %v", opened, err)
+	}
+	if _, err := service.openSecret("sub-2", sealed); err == nil {
+		t.Fatal("secret sealed for sub-1 opened for sub-2")
+	}
+	// Secret lama (sebelum enkripsi diaktifkan) tetap dipakai apa adanya
+	if opened, err := service.openSecret("sub-1", "whsec_legacy"); err != nil || opened != "whsec_legacy" {
+		t.Fatalf("openSecret(plain) = %q, %v", opened, err)
+	}
+
+	plain := NewWebhookService(nil, nil, nil, WebhookOptions{})
+	if stored, _ := plain.sealSecret("sub-1", "whsec_plain"); stored != "whsec_plain" {
+		t.Fatalf("sealSecret without key = %q, want the plain secret", stored)
+	}
+	if _, err := plain.openSecret("sub-1", sealed); err == nil {
+		t.Fatal("encrypted secret opened without a key")
+	}
+}
@@ -0,0 +1,51 @@
+// Package startup waits for the service's dependencies (database, Redis,
+// NATS) to become reachable while it starts.
+package startup
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"sub-balance-demo/internal/config"
+)
+
+// ConnectWithRetry calls connect until it succeeds, sleeping with exponential
+// backoff in between, so a rollout that starts before its database or Redis is
+// reachable waits for them instead of crash-looping. It gives up once the next
+// attempt would start after STARTUP_CONNECT_MAX_WAIT.
+func ConnectWithRetry(cfg *config.Config, name string, connect func() error) error {
+	maxWait, err := time.ParseDuration(cfg.StartupConnectMaxWait)
+	if err != nil {
+		log.Printf("Invalid startup connect max wait, using default 2m: %v", err)
+		maxWait = 2 * time.Minute
+	}
+	backoff, err := time.ParseDuration(cfg.StartupConnectInitialBackoff)
+	if err != nil || backoff <= 0 {
+		log.Printf("Invalid startup connect initial backoff, using default 1s: %v", err)
+		backoff = time.Second
+	}
+	maxBackoff, err := time.ParseDuration(cfg.StartupConnectMaxBackoff)
+	if err != nil || maxBackoff < backoff {
+		log.Printf("Invalid startup connect max backoff, using default 15s: %v", err)
+		maxBackoff = max(15*time.Second, backoff)
+	}
+
+	deadline := time.Now().Add(maxWait)
+	for attempt := 1; ; attempt++ {
+		err := connect()
+		if err == nil {
+			if attempt > 1 {
+				log.Printf("Connected to %s after %d attempts", name, attempt)
+			}
+			return nil
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			return fmt.Errorf("giving up on %s after %d attempts: %w", name, attempt, err)
+		}
+
+		log.Printf("Failed to connect to %s (attempt %d), retrying in %s: %v", name, attempt, backoff, err)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
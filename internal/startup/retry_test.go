@@ -0,0 +1,55 @@
+package startup
+
+import (
+	"errors"
+	"testing"
+
+	"sub-balance-demo/internal/config"
+)
+
+func retryConfig(maxWait string) *config.Config {
+	return &config.Config{
+		StartupConnectMaxWait:        maxWait,
+		StartupConnectInitialBackoff: "1ms",
+		StartupConnectMaxBackoff:     "4ms",
+	}
+}
+
+func TestConnectWithRetry(t *testing.T) {
+	errDown := errors.New("connection refused")
+	tests := []struct {
+		name         string
+		maxWait      string
+		failures     int
+		wantErr      bool
+		wantAttempts int
+	}{
+		{"first attempt", "1s", 0, false, 1},
+		{"after failures", "1s", 3, false, 4},
+		{"gives up", "10ms", 1000, true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			err := ConnectWithRetry(retryConfig(tt.maxWait), "test", func() error {
+				attempts++
+				if attempts <= tt.failures {
+					return errDown
+				}
+				return nil
+			})
+			if tt.wantErr {
+				if !errors.Is(err, errDown) {
+					t.Fatalf("err = %v, want it to wrap %v", err, errDown)
+				}
+				if attempts < 2 || attempts >= tt.failures {
+					t.Fatalf("gave up after %d attempts", attempts)
+				}
+				return
+			}
+			if err != nil || attempts != tt.wantAttempts {
+				t.Fatalf("err = %v after %d attempts, want nil after %d", err, attempts, tt.wantAttempts)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+// Package tenant carries the tenant (business unit) of a request through the
+// context. API requests are bound to exactly one tenant; background workers run
+// without one and see every tenant, switching to an account's tenant with
+// WithID before touching its Redis counter.
+package tenant
+
+import (
+	"context"
+	"errors"
+	"regexp"
+
+	"gorm.io/gorm"
+)
+
+// Default owns every row created before multi-tenancy and every request when
+// it is disabled
+const Default = "default"
+
+// ErrInvalidID is returned for tenant IDs that are not safe to embed in keys
+var ErrInvalidID = errors.New("invalid tenant id")
+
+var validID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,50}$`)
+
+type contextKey struct{}
+
+// Validate checks that id can be used as a tenant ID
+func Validate(id string) error {
+	if !validID.MatchString(id) {
+		return ErrInvalidID
+	}
+	return nil
+}
+
+// WithID returns a context bound to the given tenant
+func WithID(ctx context.Context, id string) context.Context {
+	if id == "" {
+		id = Default
+	}
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the tenant bound to ctx, if any
+func FromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(contextKey{}).(string)
+	return id, ok
+}
+
+// ID returns the tenant bound to ctx, or Default for unbound contexts
+func ID(ctx context.Context) string {
+	if id, ok := FromContext(ctx); ok {
+		return id
+	}
+	return Default
+}
+
+// Scope restricts a query to the tenant bound to ctx; unbound (worker)
+// contexts are not restricted
+func Scope(ctx context.Context) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		id, ok := FromContext(ctx)
+		if !ok {
+			return db
+		}
+		return db.Where("tenant_id = ?", id)
+	}
+}
+
+// Qualify prefixes name with the tenant bound to ctx, for lock names and
+// in-memory keys shared by every tenant. The default tenant keeps name as it
+// is, like its Redis keys.
+func Qualify(ctx context.Context, name string) string {
+	if id := ID(ctx); id != Default {
+		return "tenant:" + id + ":" + name
+	}
+	return name
+}
@@ -0,0 +1,156 @@
+// Package tlsreload serves a TLS certificate (and optional client CA bundle)
+// that is re-read from disk when the files change or on SIGHUP, so
+// certificates rotated by cert-manager are picked up without a restart. A
+// reload that fails keeps serving the previous certificate.
+package tlsreload
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Reloader holds the current certificate and client CA pool
+type Reloader struct {
+	certFile string
+	keyFile  string
+	caFile   string // kosong = tanpa client CA
+
+	cert      atomic.Pointer[tls.Certificate]
+	clientCAs atomic.Pointer[x509.CertPool]
+	version   string // mod time dan ukuran file saat terakhir dimuat
+	failed    string // versi file yang gagal dimuat; tidak dicoba ulang sampai berubah lagi
+}
+
+// New loads the certificate, key and (if caFile is set) client CA bundle
+func New(certFile string, keyFile string, caFile string) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile, caFile: caFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload re-reads the files; on error the current certificate stays in use
+func (r *Reloader) Reload() error {
+	version := r.fileVersion()
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load server certificate: %w", err)
+	}
+
+	var pool *x509.CertPool
+	if r.caFile != "" {
+		caCert, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return fmt.Errorf("failed to read client CA file: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return fmt.Errorf("no certificates found in %s", r.caFile)
+		}
+	}
+
+	r.cert.Store(&cert)
+	r.clientCAs.Store(pool)
+	r.version = version
+	return nil
+}
+
+// Config returns base with the certificate and client CAs served from the
+// reloader. base sets everything else (MinVersion, ClientAuth).
+func (r *Reloader) Config(base *tls.Config) *tls.Config {
+	config := base.Clone()
+	config.GetCertificate = r.getCertificate
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		if r.caFile == "" {
+			return nil, nil // pakai config ini apa adanya
+		}
+		perConnection := config.Clone()
+		perConnection.ClientCAs = r.clientCAs.Load()
+		return perConnection, nil
+	}
+	return config
+}
+
+func (r *Reloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.cert.Load(), nil
+}
+
+// Expiry is the NotAfter of the certificate being served
+func (r *Reloader) Expiry() time.Time {
+	cert := r.cert.Load()
+	if cert == nil || len(cert.Certificate) == 0 {
+		return time.Time{}
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return time.Time{}
+	}
+	return leaf.NotAfter
+}
+
+// Watch reloads on SIGHUP and, every interval (0 = never), when a file's mod
+// time or size changed, until ctx is done. Secrets mounted by Kubernetes are
+// swapped through a symlink, which os.Stat follows.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-hup:
+			r.reload("SIGHUP")
+		case <-tick:
+			if version := r.fileVersion(); version != r.version && version != r.failed {
+				r.reload("file change")
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (r *Reloader) reload(reason string) {
+	version := r.fileVersion()
+	if err := r.Reload(); err != nil {
+		r.failed = version
+		log.Printf("TLS certificate reload (%s) failed, keeping current certificate: %v", reason, err)
+		return
+	}
+	log.Printf("TLS certificate reloaded (%s), expires %s", reason, r.Expiry().Format(time.RFC3339))
+}
+
+// fileVersion summarizes the files' mod times and sizes; a missing file
+// (mid-rotation) yields a version that differs until it is back
+func (r *Reloader) fileVersion() string {
+	version := ""
+	for _, file := range []string{r.certFile, r.keyFile, r.caFile} {
+		if file == "" {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			version += "missing;"
+			continue
+		}
+		version += fmt.Sprintf("%d:%d;", info.ModTime().UnixNano(), info.Size())
+	}
+	return version
+}
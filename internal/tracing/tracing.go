@@ -0,0 +1,61 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+
+	"sub-balance-demo/internal/buildinfo"
+	"sub-balance-demo/internal/config"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// Setup installs the global tracer provider exporting spans over OTLP/HTTP to
+// cfg.TracingEndpoint (Jaeger >= 1.35 accepts OTLP on :4318). Until Setup is
+// called, or when tracing is disabled, the global provider is a no-op and the
+// spans opened by the services cost next to nothing.
+// The returned function flushes buffered spans; call it on shutdown.
+func Setup(ctx context.Context, cfg *config.Config) (func(context.Context) error, error) {
+	if !cfg.EnableTracing {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(cfg.TracingEndpoint))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace exporter: %w", err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(cfg.AppName),
+		semconv.ServiceVersion(buildinfo.Get(cfg.AppVersion).Version),
+		semconv.DeploymentEnvironment(cfg.AppEnv),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build trace resource: %w", err)
+	}
+
+	ratio, err := strconv.ParseFloat(cfg.TracingSampleRatio, 64)
+	if err != nil || ratio < 0 || ratio > 1 {
+		log.Printf("Invalid tracing sample ratio, using default 1.0: %v", err)
+		ratio = 1
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	log.Printf("Tracing enabled, exporting to %s (sample ratio %.2f)", cfg.TracingEndpoint, ratio)
+	return provider.Shutdown, nil
+}
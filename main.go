@@ -1,45 +1,145 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/http/pprof"
 	"os"
 	"os/signal"
+	"slices"
+	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"sub-balance-demo/internal/auth"
+	"sub-balance-demo/internal/bodylog"
+	"sub-balance-demo/internal/bootstrap"
+	"sub-balance-demo/internal/buildinfo"
 	"sub-balance-demo/internal/config"
+	"sub-balance-demo/internal/cors"
+	"sub-balance-demo/internal/database"
+	"sub-balance-demo/internal/eventstream"
+	"sub-balance-demo/internal/grpcapi"
 	"sub-balance-demo/internal/handler"
+	"sub-balance-demo/internal/logmask"
+	"sub-balance-demo/internal/metrics"
+	"sub-balance-demo/internal/recovery"
+	"sub-balance-demo/internal/redisclient"
 	"sub-balance-demo/internal/repository"
+	"sub-balance-demo/internal/secrets"
 	"sub-balance-demo/internal/service"
+	"sub-balance-demo/internal/tracing"
 
-	"github.com/go-redis/redis/v8"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
-	"gorm.io/driver/postgres"
+	"github.com/nats-io/nats.go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"gorm.io/gorm"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "encrypt-config" {
+		encryptConfig(os.Args[2:])
+		return
+	}
+
 	// Load configuration
 	cfg := config.Load()
+	build := buildinfo.Get(cfg.AppVersion)
+	log.Printf("Starting %s version=%s commit=%s build_date=%s", cfg.AppName, build.Version, build.Commit, build.BuildDate)
+
+	bootstrap.LogMasking(cfg)
+
+	// Tracing (OTLP); no-op provider when disabled
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg)
+	if err != nil {
+		log.Fatal("Failed to set up tracing:", err)
+	}
+
+	// Slow query logger shared by the primary and the replica
+	slowQueryThreshold, err := time.ParseDuration(cfg.DBSlowQueryThreshold)
+	if err != nil {
+		log.Printf("Invalid DB slow query threshold, using default 200ms: %v", err)
+		slowQueryThreshold = 200 * time.Millisecond
+	}
+	slowQueryLogger := service.NewSlowQueryLogger(slowQueryThreshold, cfg.LogFormat == "json")
 
 	// Initialize database
-	db, err := initDatabase(cfg)
+	db, err := database.Open(cfg, slowQueryLogger)
 	if err != nil {
 		log.Fatal("Failed to connect to database:", err)
 	}
 
+	replicaDB := database.OpenReplica(cfg, slowQueryLogger)
+
+	if cfg.EnableTracing {
+		for _, conn := range []*gorm.DB{db, replicaDB} {
+			if conn == nil {
+				continue
+			}
+			if err := conn.Use(tracing.GormPlugin{}); err != nil {
+				log.Fatal("Failed to register GORM tracing:", err)
+			}
+		}
+	}
+
 	// Initialize Redis
-	rdb := initRedis(cfg)
+	rdb, err := redisclient.Open(cfg)
+	if err != nil {
+		log.Fatal("Failed to connect to Redis:", err)
+	}
+	redisMetrics := service.NewRedisMetricsHook()
+	rdb.AddHook(redisMetrics)
+
+	// Tolak start jika namespace Redis sudah dipakai environment lain
+	if err := service.ClaimRedisNamespace(context.Background(), rdb, cfg.RedisNamespace(), cfg.AppEnv); err != nil {
+		log.Fatal("Refusing to start: ", err)
+	}
+	log.Printf("Using Redis key namespace %q", cfg.RedisNamespace())
 
 	// Initialize repositories
-	accountBalanceRepo := repository.NewAccountBalanceRepository(db)
-	subBalanceRepo := repository.NewSubBalanceRepository(db)
+	accountBalanceRepo := repository.NewAccountBalanceRepository(db, replicaDB)
+	subBalanceRepo := repository.NewSubBalanceRepository(db, replicaDB)
+	pgxPools := map[string]*pgxpool.Pool{}
+	if cfg.DBHotPathDriver == "pgx" {
+		pool, replicaPool := database.OpenPgxPools(cfg, replicaDB != nil)
+		if pool != nil {
+			log.Println("Using pgx for balance reads, balance updates and sub_balance inserts")
+			accountBalanceRepo = repository.NewPgxAccountBalanceRepository(accountBalanceRepo, pool, replicaPool)
+			subBalanceRepo = repository.NewPgxSubBalanceRepository(subBalanceRepo, pool)
+			pgxPools["primary"] = pool
+		}
+		if replicaPool != nil {
+			pgxPools["replica"] = replicaPool
+		}
+	}
+	reconciliationRepo := repository.NewReconciliationRepository(db, replicaDB)
+	settlementAuditRepo := repository.NewSettlementAuditRepository(db, replicaDB)
+	balanceAuditRepo := repository.NewBalanceAuditRepository(db, replicaDB)
+	adminAuditRepo := repository.NewAdminAuditRepository(db, replicaDB)
+	domainEventRepo := repository.NewDomainEventRepository(db, replicaDB)
+	quarantineRepo := repository.NewQuarantineRepository(db)
+	rateLimitRepo := repository.NewRateLimitRepository(db)
+	retentionRepo := repository.NewRetentionRepository(db)
+	balanceSnapshotRepo := repository.NewBalanceSnapshotRepository(db, replicaDB)
 
 	// Initialize services
-	redisCounter := service.NewRedisCounter(rdb, cfg)
+	redisReplicas := redisclient.OpenReplicas(cfg, rdb, redisMetrics)
+	redisCounter := service.NewInstrumentedCounter(service.NewRedisCounter(rdb, redisReplicas, cfg))
+
+	// Preload Lua scripts supaya hot path langsung memakai EVALSHA
+	if err := service.LoadScripts(context.Background(), rdb); err != nil {
+		log.Printf("Failed to preload Redis scripts, they will be loaded on first use: %v", err)
+	}
 
 	// Parse health check interval
 	healthCheckInterval, err := time.ParseDuration(cfg.HealthCheckInterval)
@@ -47,7 +147,14 @@ func main() {
 		log.Printf("Invalid health check interval, using default 5s: %v", err)
 		healthCheckInterval = 5 * time.Second
 	}
-	healthChecker := service.NewRedisHealthChecker(rdb, healthCheckInterval)
+	healthHistory := service.NewHealthHistory(cfg.HealthHistorySize)
+	healthChecker := service.NewRedisHealthChecker(rdb, healthCheckInterval, healthHistory)
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		log.Fatal("Failed to get database handle:", err)
+	}
+	dbHealthChecker := service.NewDBHealthChecker(sqlDB, healthCheckInterval, healthHistory)
 
 	// Parse circuit breaker timeout
 	circuitBreakerTimeout, err := time.ParseDuration(cfg.CircuitBreakerTimeout)
@@ -57,76 +164,463 @@ func main() {
 	}
 	circuitBreaker := service.NewCircuitBreaker(cfg.CircuitBreakerFailureThreshold, circuitBreakerTimeout)
 
-	consistencyService := service.NewDataConsistencyService(db, redisCounter, accountBalanceRepo, subBalanceRepo)
-	transactionService := service.NewTransactionService(accountBalanceRepo, subBalanceRepo, redisCounter, cfg, healthChecker, circuitBreaker, consistencyService)
+	// Setiap perpindahan state dikirim ke webhook alert: saat OPEN semua
+	// transaksi lewat database fallback yang jauh lebih lambat
+	alertNotifier := service.NewWebhookNotifier(cfg.AlertWebhookURL)
+
+	// Alert threshold: repair per run, settlement gagal per run, breaker terlalu lama OPEN
+	alerts := bootstrap.ThresholdAlerter(cfg, alertNotifier)
+	alerts.WatchCircuitBreaker(circuitBreaker)
+
+	// Domain event log untuk GET /admin/events; nil berarti nonaktif
+	var domainEvents *service.DomainEventLog
+	if cfg.EnableDomainEvents {
+		domainEvents = service.NewDomainEventLog(domainEventRepo)
+	}
+	circuitBreaker.OnStateChange(func(transition service.CircuitBreakerTransition) {
+		err := alertNotifier.Notify(context.Background(), "circuit_breaker.state_changed", transition)
+		if err != nil {
+			log.Printf("Failed to send circuit breaker notification: %v", err)
+		}
+
+		if transition.To != service.StateOpen {
+			return
+		}
+		err = domainEvents.Record(context.Background(), nil, service.NewDomainEvent(service.DomainEventBreakerOpened, "", "", map[string]interface{}{
+			"from":          string(transition.From),
+			"reason":        transition.Reason,
+			"failure_count": transition.FailureCount,
+		}))
+		if err != nil {
+			log.Printf("Failed to record circuit breaker event: %v", err)
+		}
+	})
+
+	// Distributed lock antar instance untuk create account, repair dan fallback
+	var accountLock *service.DistributedLock
+	if cfg.EnableDistributedLock {
+		lockTTL, err := time.ParseDuration(cfg.DistributedLockTTL)
+		if err != nil {
+			log.Printf("Invalid distributed lock TTL, using default 10s: %v", err)
+			lockTTL = 10 * time.Second
+		}
+		lockWaitTimeout, err := time.ParseDuration(cfg.DistributedLockWaitTimeout)
+		if err != nil {
+			log.Printf("Invalid distributed lock wait timeout, using default 5s: %v", err)
+			lockWaitTimeout = 5 * time.Second
+		}
+		accountLock = service.NewDistributedLock(rdb, cfg.RedisNamespace(), lockTTL, lockWaitTimeout)
+	}
+
+	// Read-through cache GET /balance; entry dihapus oleh invalidator
+	var balanceCache *service.BalanceCache
+	if cfg.EnableBalanceCache {
+		balanceCacheTTL, err := time.ParseDuration(cfg.BalanceCacheTTL)
+		if err != nil || balanceCacheTTL <= 0 {
+			log.Printf("Invalid balance cache TTL, using default 2s: %v", err)
+			balanceCacheTTL = 2 * time.Second
+		}
+		balanceCache = service.NewBalanceCache(rdb, cfg.RedisNamespace(), balanceCacheTTL)
+	}
+
+	// Pub/sub invalidation supaya instance lain bisa refresh balance cache
+	balanceInvalidator := service.NewBalanceInvalidator(rdb, cfg.RedisNamespace(), balanceCache)
+
+	// Stream WatchBalance gRPC mengikuti invalidation dari semua instance
+	var balanceWatchers *service.BalanceWatchers
+	if cfg.EnableGRPC {
+		balanceWatchers = service.NewBalanceWatchers(balanceInvalidator, cfg.GRPCMaxWatchers)
+	}
+
+	// Event stream untuk sistem internal lain (accepted, settled, rejected, repair),
+	// ke Redis Stream, NATS JetStream atau Kafka
+	var eventPublisher eventstream.Publisher
+	var natsConn *nats.Conn
+	var kafkaPublisher *eventstream.KafkaPublisher
+	if cfg.EnableEventStream {
+		switch cfg.EventStreamTransport {
+		case "nats":
+			eventPublisher, natsConn, err = bootstrap.JetStreamPublisher(cfg)
+			if err != nil {
+				log.Fatal(err)
+			}
+		case "kafka":
+			kafkaPublisher, err = bootstrap.KafkaPublisher(cfg)
+			if err != nil {
+				log.Fatal(err)
+			}
+			eventPublisher = kafkaPublisher
+		default:
+			if cfg.EventStreamTransport != "redis" {
+				log.Printf("Invalid event stream transport %q, using default redis", cfg.EventStreamTransport)
+			}
+			eventPublisher = eventstream.NewStreamPublisher(rdb, cfg.RedisNamespace()+":events", int64(cfg.EventStreamMaxLen))
+		}
+	}
+
+	// Outbox: event ditulis dalam transaksi yang sama dengan perubahan state,
+	// lalu relay yang mem-publish ke event stream
+	var outbox *service.Outbox
+	var outboxRelay *service.OutboxRelay
+	if cfg.EnableOutbox {
+		if eventPublisher == nil {
+			log.Println("WARNING: ENABLE_OUTBOX requires ENABLE_EVENT_STREAM, outbox disabled")
+		} else {
+			outboxRepo := repository.NewOutboxRepository(db)
+			outbox = service.NewOutbox(outboxRepo)
+			outboxRelay = service.NewOutboxRelay(db, outboxRepo, eventPublisher, cfg.OutboxRelayBatchSize)
+		}
+	}
+
+	// Webhook keluar: event stream dikirim ke endpoint subscriber dengan retry
+	var webhooks *service.WebhookService
+	if cfg.EnableWebhooks {
+		if !bootstrap.RedisEventStream(cfg) {
+			log.Println("WARNING: ENABLE_WEBHOOKS requires ENABLE_EVENT_STREAM with EVENT_STREAM_TRANSPORT=redis, webhooks disabled")
+		} else {
+			webhooks, err = bootstrap.Webhooks(cfg, db, rdb)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+
+	// Notifikasi settlement per account ke RabbitMQ, dari event stream
+	var settlementNotifier *service.SettlementNotifier
+	if cfg.EnableRabbitMQ {
+		if !bootstrap.RedisEventStream(cfg) {
+			log.Println("WARNING: ENABLE_RABBITMQ requires ENABLE_EVENT_STREAM with EVENT_STREAM_TRANSPORT=redis, RabbitMQ notifications disabled")
+		} else {
+			settlementNotifier = bootstrap.SettlementNotifier(cfg, rdb)
+		}
+	}
+
+	balanceAudit := service.NewBalanceAuditTrail(balanceAuditRepo)
+	adminAudit := service.NewAdminAuditLog(adminAuditRepo)
+	consistencyService := service.NewDataConsistencyService(db, redisCounter, accountBalanceRepo, subBalanceRepo, accountLock, balanceInvalidator, eventPublisher, outbox, balanceAudit, domainEvents, cfg)
+	reconciliationService := service.NewReconciliationService(reconciliationRepo)
+	quarantineService := service.NewQuarantineService(quarantineRepo, cfg.SettlementQuarantineThreshold)
+	retentionService := service.NewRetentionService(retentionRepo, cfg)
+	balanceSnapshotService := service.NewBalanceSnapshotService(balanceSnapshotRepo, cfg.BalanceSnapshotTime)
+	rateLimitWindow, err := time.ParseDuration(cfg.RateLimitWindow)
+	if err != nil {
+		log.Printf("Invalid rate limit window, using default 1m: %v", err)
+		rateLimitWindow = 1 * time.Minute
+	}
+	rateLimitAlgorithm := cfg.RateLimitAlgorithm
+	if rateLimitAlgorithm != service.RateLimitFixedWindow && rateLimitAlgorithm != service.RateLimitTokenBucket {
+		log.Printf("Invalid rate limit algorithm %q, using default %s", rateLimitAlgorithm, service.RateLimitFixedWindow)
+		rateLimitAlgorithm = service.RateLimitFixedWindow
+	}
+	// Tanpa autentikasi identitas API key/subject tidak pernah ada, jadi
+	// override hanya bisa per account
+	rateLimiter := service.NewRateLimiter(rateLimitRepo, rateLimitAlgorithm, cfg.RateLimitRequests, rateLimitWindow, cfg.RateLimitBurst, !bootstrap.AuthEnabled(cfg))
+	// Local counter fallback: menahan Redis blip tanpa row lock, single instance only
+	var localCounter *service.LocalCounter
+	if cfg.EnableLocalCounterFallback {
+		log.Println("WARNING: local counter fallback enabled; overspend protection is per instance while Redis is down, run a single instance only")
+		localCounter = service.NewLocalCounter(cfg)
+		healthChecker.OnRecovery(func() {
+			// Pending yang masuk selama Redis down ada di database; bangun ulang Redis dulu
+			err := consistencyService.RecoverRedisFromDatabase(context.Background())
+			if err != nil {
+				log.Printf("Failed to rebuild Redis after outage: %v", err)
+				return
+			}
+			localCounter.Reset()
+		})
+	}
+
+	// Memory guard: counter yang di-evict membuka celah overspend
+	var memoryGuard *service.RedisMemoryGuard
+	if cfg.EnableRedisMemoryGuard {
+		memoryCheckInterval, err := time.ParseDuration(cfg.RedisMemoryCheckInterval)
+		if err != nil {
+			log.Printf("Invalid Redis memory check interval, using default 10s: %v", err)
+			memoryCheckInterval = 10 * time.Second
+		}
+		memoryGuard = service.NewRedisMemoryGuard(rdb, cfg.RedisMemoryPressurePercent, memoryCheckInterval, alertNotifier)
+	}
+
+	metadataCipher, err := bootstrap.MetadataCipher(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	transactionService := service.NewTransactionService(db, accountBalanceRepo, subBalanceRepo, settlementAuditRepo, redisCounter, cfg, healthChecker, dbHealthChecker, circuitBreaker, consistencyService, reconciliationService, quarantineService, accountLock, balanceInvalidator, balanceCache, eventPublisher, outbox, balanceAudit, localCounter, memoryGuard, domainEvents, alerts, metadataCipher)
+
+	// Ingestion file settlement partner dari directory atau S3 (jika diaktifkan)
+	var ingestion *service.IngestionWorker
+	if cfg.EnableIngestion {
+		ingestion, err = bootstrap.Ingestion(cfg, db, transactionService)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Ingestion TransactionRequest dari topic Kafka (jika diaktifkan)
+	var kafkaIngestion *service.KafkaIngestion
+	if cfg.EnableKafkaIngestion {
+		kafkaIngestion, err = bootstrap.KafkaIngestion(cfg, db, transactionService)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 
 	// Initialize handlers
-	transactionHandler := handler.NewTransactionHandler(transactionService, cfg)
+	transactionHandler := handler.NewTransactionHandler(transactionService, repository.NewIngestRepository(db), cfg)
+	authFailures := bootstrap.AuthFailureTracker(cfg)
+	adminLockout := bootstrap.AdminLockout(cfg, rdb)
+	adminHandler := handler.NewAdminHandler(transactionService, reconciliationService, quarantineService, consistencyService, retentionService, balanceSnapshotService, balanceAudit, adminAudit, domainEvents, rateLimiter, adminLockout, authFailures, webhooks)
 
 	// Initialize Echo
 	e := echo.New()
+	// c.RealIP() hanya mempercayai X-Forwarded-For dari TRUSTED_PROXIES
+	e.IPExtractor, err = auth.ClientIPExtractor(cfg.TrustedProxies)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Admin dan test route di listener internal terpisah (ADMIN_PORT) supaya
+	// network policy bisa menutupnya dari internet walaupun auth salah
+	// konfigurasi; kosong atau sama dengan PORT = tetap di port utama
+	adminEcho := e
+	if cfg.AdminPort != "" && cfg.AdminPort != cfg.Port {
+		adminEcho = echo.New()
+		adminEcho.IPExtractor = e.IPExtractor
+	}
+	use := func(m echo.MiddlewareFunc) {
+		e.Use(m)
+		if adminEcho != e {
+			adminEcho.Use(m)
+		}
+	}
 
 	// Configure logging based on debug mode
+	accessLog := middleware.DefaultLoggerConfig
 	if cfg.DebugMode {
-		e.Use(middleware.LoggerWithConfig(middleware.LoggerConfig{
-			Format: "time=${time_rfc3339} method=${method} uri=${uri} status=${status} latency=${latency_human}\n",
+		accessLog.Format = "time=${time_rfc3339} method=${method} uri=${uri} status=${status} latency=${latency_human}\n"
+	}
+	if logmask.Enabled() {
+		// Account ID di path dan query dimasking seperti log aplikasi
+		accessLog.Format = strings.Replace(accessLog.Format, "${uri}", "${custom}", 1)
+		accessLog.CustomTagFunc = func(c echo.Context, buf *bytes.Buffer) (int, error) {
+			return buf.WriteString(logmask.URI(c.Request().URL, c.Param("account_id")))
+		}
+	}
+	use(middleware.LoggerWithConfig(accessLog))
+	// Panic di handler dicatat dengan stack dan konteks request, dihitung per
+	// route, dan dijawab dengan body error biasa
+	panicStats := recovery.NewStats()
+	use(recovery.Middleware(panicStats, cfg.LogFormat))
+	// Body di atas MAX_REQUEST_BODY_BYTES ditolak 413 sebelum dibaca handler;
+	// upload CSV import memakai TRANSACTION_IMPORT_MAX_BYTES
+	if cfg.MaxRequestBody > 0 || cfg.TransactionImportMaxBytes > 0 {
+		use(handler.RequestBodyLimit(int64(cfg.MaxRequestBody), map[string]int64{
+			"/api/v1/transactions/import": int64(cfg.TransactionImportMaxBytes),
 		}))
-	} else {
-		e.Use(middleware.Logger())
 	}
-	e.Use(middleware.Recover())
+	if cfg.EnableBodyLogging {
+		if slices.Contains(cfg.BodyLogEnvironments, cfg.AppEnv) {
+			log.Printf("WARNING: request/response body logging enabled (APP_ENV=%s), redacting %v and hashing %v",
+				cfg.AppEnv, cfg.BodyLogRedactFields, cfg.BodyLogHashFields)
+			redactor := bodylog.NewRedactor(cfg.BodyLogRedactFields, cfg.BodyLogHashFields)
+			use(bodylog.Middleware(redactor, cfg.BodyLogMaxBytes, cfg.LogFormat))
+		} else {
+			log.Printf("Body logging is not allowed in APP_ENV=%s (BODY_LOG_ENVIRONMENTS=%v), disabled", cfg.AppEnv, cfg.BodyLogEnvironments)
+		}
+	}
+	if cfg.EnableTracing {
+		use(tracing.Middleware())
+	}
 
 	// Configure concurrent request limiting (using custom middleware)
 	if cfg.MaxConcurrentReqs > 0 {
-		e.Use(concurrentRequestLimiter(cfg.MaxConcurrentReqs))
+		use(handler.ConcurrentRequestLimiter(cfg.MaxConcurrentReqs))
 	}
 
-	// Configure rate limiting (if enabled)
-	if cfg.EnableRateLimit && cfg.RateLimitRequests > 0 {
-		// Parse rate limit window
-		rateLimitWindow, err := time.ParseDuration(cfg.RateLimitWindow)
+	// Configure CORS if enabled
+	if cfg.EnableCORS {
+		corsRules, err := bootstrap.CORSRules(cfg)
 		if err != nil {
-			log.Printf("Invalid rate limit window, using default 1m: %v", err)
-			rateLimitWindow = 1 * time.Minute
+			log.Fatal(err)
 		}
-
-		e.Use(rateLimiter(cfg.RateLimitRequests, rateLimitWindow))
+		use(cors.Middleware(corsRules))
 	}
 
-	// Configure CORS if enabled
-	if cfg.EnableCORS {
-		e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
-			AllowOrigins: []string{cfg.CORSOrigins},
-			AllowMethods: []string{cfg.CORSMethods},
-			AllowHeaders: []string{cfg.CORSHeaders},
-		}))
+	// Probes: /health/live hanya memastikan proses melayani HTTP (database
+	// putus tidak membuat pod di-restart, pool reconnect sendiri); /health/ready
+	// 503 selama warm-up, saat shutdown, atau saat database, schema, Redis
+	// (tanpa fallback) atau settlement worker tidak siap. /ready tetap ada
+	// sebagai alias untuk manifest lama. /health/history menampilkan outage
+	// Redis dan database terakhir.
+	var ready atomic.Bool
+	healthHandler := handler.NewHealthHandler(db, transactionService, healthChecker, cfg.EnableRedisFallback, &ready, healthHistory)
+	e.GET("/health/live", healthHandler.Live)
+	e.GET("/health/ready", healthHandler.Ready)
+	e.GET("/health/history", healthHandler.History)
+	e.GET("/ready", healthHandler.Ready)
+
+	// Rate limiting dihitung per identitas (API key, subject JWT, account),
+	// bukan per IP: di belakang load balancer semua request berbagi IP
+	var requestLimiter *service.RateLimiter
+	if cfg.EnableRateLimit && cfg.RateLimitRequests > 0 {
+		requestLimiter = rateLimiter
 	}
+	rateLimited := handler.RateLimit(requestLimiter)
 
 	// Setup routes
-	setupRoutes(e, transactionHandler)
+	authenticator, err := bootstrap.Authenticator(cfg, authFailures, adminLockout, auth.NewNonceCache(rdb, cfg.RedisNamespace()))
+	if err != nil {
+		log.Fatal(err)
+	}
+	adminAuditor := handler.AdminAuditor(adminAudit, cfg.AdminActorHeader)
+	adminAllowlist, err := auth.IPAllowlist("admin", cfg.AdminAllowedCIDRs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	handler.RegisterRoutes(e, cfg, transactionHandler, authenticator, rateLimited)
+	handler.RegisterAdminRoutes(adminEcho, adminHandler, adminAuditor, adminAllowlist, authenticator, rateLimited)
 
 	// Setup monitoring (if enabled)
 	if cfg.EnableMetrics {
-		setupMonitoring(e, cfg)
+		handler.RegisterMonitoring(e, adminEcho, cfg, metrics.Sources{
+			Transactions:   transactionService,
+			RedisCommands:  redisMetrics,
+			RedisCounter:   redisCounter,
+			RedisHealth:    healthChecker,
+			RedisMemory:    memoryGuard,
+			DBHealth:       dbHealthChecker,
+			SlowQueries:    slowQueryLogger,
+			BalanceCache:   balanceCache,
+			CircuitBreaker: circuitBreaker,
+			Panics:         panicStats,
+			AuthFailures:   authFailures,
+			AdminLockout:   adminLockout,
+			Webhooks:       webhooks,
+			Ingestion:      ingestion,
+			KafkaIngestion: kafkaIngestion,
+			BalanceWatch:   balanceWatchers,
+			AccountLock:    accountLock,
+			Build:          build,
+			RedisPools:     redisclient.Pools(rdb, redisReplicas),
+			PgxPools:       pgxPools,
+		}, sqlDB, replicaDB)
 	}
 
 	// Setup test mode routes (if enabled)
 	if cfg.EnableTestMode {
-		setupTestRoutes(e, cfg, transactionHandler)
+		testAllowlist, err := auth.IPAllowlist("test", cfg.TestAllowedCIDRs)
+		if err != nil {
+			log.Fatal(err)
+		}
+		handler.RegisterTestRoutes(adminEcho, cfg, transactionHandler, adminAuditor, testAllowlist, authenticator, rateLimited)
+	}
+
+	// Start pprof server (if enabled)
+	if cfg.EnablePprof {
+		startPprof(cfg)
 	}
 
 	// Start background workers
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// Refresh secrets dari secret manager (if enabled)
+	if cfg.Secrets != nil {
+		secretsRefreshInterval, err := time.ParseDuration(cfg.SecretsRefreshInterval)
+		if err != nil {
+			log.Printf("Invalid secrets refresh interval, secrets are not refreshed: %v", err)
+			secretsRefreshInterval = 0
+		}
+		go cfg.Secrets.Watch(ctx, secretsRefreshInterval)
+	}
+
 	// Start Redis health checker (if enabled)
 	if cfg.EnableCircuitBreaker {
 		go healthChecker.StartHealthCheck(ctx)
 	}
 
+	// Start database health checker
+	go dbHealthChecker.StartHealthCheck(ctx)
+
+	// Start Redis memory guard (if enabled)
+	if memoryGuard != nil {
+		go memoryGuard.Start(ctx)
+	}
+
 	// Start settlement worker
 	go transactionService.StartSettlementWorker(ctx)
 
+	// Start rate limit override refresh (if enabled)
+	if requestLimiter != nil {
+		rateLimitRefreshInterval, err := time.ParseDuration(cfg.RateLimitRefreshInterval)
+		if err != nil {
+			log.Printf("Invalid rate limit refresh interval, using default 30s: %v", err)
+			rateLimitRefreshInterval = 30 * time.Second
+		}
+		go requestLimiter.Start(ctx, rateLimitRefreshInterval)
+	}
+
+	// Start outbox relay (if enabled)
+	if outboxRelay != nil {
+		outboxRelayInterval, err := time.ParseDuration(cfg.OutboxRelayInterval)
+		if err != nil {
+			log.Printf("Invalid outbox relay interval, using default 1s: %v", err)
+			outboxRelayInterval = time.Second
+		}
+		go outboxRelay.Start(ctx, outboxRelayInterval)
+	}
+
+	// Start balance watch subscription (if gRPC is enabled)
+	if balanceWatchers != nil {
+		go balanceWatchers.Start(ctx)
+	}
+
+	// Start webhook delivery worker (if enabled)
+	if webhooks != nil {
+		webhookPollInterval, err := time.ParseDuration(cfg.WebhookPollInterval)
+		if err != nil || webhookPollInterval <= 0 {
+			log.Printf("Invalid webhook poll interval, using default 1s: %v", err)
+			webhookPollInterval = time.Second
+		}
+		go webhooks.Start(ctx, webhookPollInterval)
+	}
+
+	// Start RabbitMQ settlement notifier (if enabled)
+	if settlementNotifier != nil {
+		go settlementNotifier.Start(ctx)
+	}
+
+	// Start batch file ingestion worker (if enabled)
+	if ingestion != nil {
+		ingestInterval, err := time.ParseDuration(cfg.IngestInterval)
+		if err != nil || ingestInterval <= 0 {
+			log.Printf("Invalid ingestion interval, using default 1m: %v", err)
+			ingestInterval = time.Minute
+		}
+		go ingestion.Start(ctx, ingestInterval)
+	}
+
+	// Start Kafka ingestion consumer (if enabled)
+	if kafkaIngestion != nil {
+		go kafkaIngestion.Start(ctx)
+	}
+
+	// Start retention worker (if enabled)
+	if cfg.EnableRetentionWorker {
+		retentionInterval, err := time.ParseDuration(cfg.RetentionInterval)
+		if err != nil {
+			log.Printf("Invalid retention interval, using default 1h: %v", err)
+			retentionInterval = time.Hour
+		}
+		go retentionService.Start(ctx, retentionInterval)
+	}
+
+	// Start daily balance snapshot worker (if enabled)
+	if cfg.EnableBalanceSnapshots {
+		go balanceSnapshotService.Start(ctx)
+	}
+
 	// Start data consistency checker (if enabled)
 	if cfg.EnableDataConsistencyCheck {
 		go func() {
@@ -142,10 +636,12 @@ func main() {
 			for {
 				select {
 				case <-ticker.C:
-					err := consistencyService.ValidateAndRepair(ctx)
+					repaired, err := consistencyService.ValidateAndRepair(ctx)
 					if err != nil {
 						log.Printf("Data consistency check failed: %v", err)
+						continue
 					}
+					alerts.CheckRepairs(ctx, repaired)
 				case <-ctx.Done():
 					return
 				}
@@ -153,33 +649,71 @@ func main() {
 		}()
 	}
 
-	// Start server with timeouts
-	go func() {
-		// Parse server timeouts
-		readTimeout, err := time.ParseDuration(cfg.ReadTimeout)
+	// Warm-up: counter kosong setelah deploy/flush membuat validasi lolos tanpa
+	// pending yang sudah ada, jadi Redis dibangun ulang sebelum listener dibuka
+	if cfg.EnableStartupWarmUp {
+		warmUpTimeout, err := time.ParseDuration(cfg.StartupWarmUpTimeout)
 		if err != nil {
-			log.Printf("Invalid read timeout, using default 10s: %v", err)
-			readTimeout = 10 * time.Second
+			log.Printf("Invalid startup warm-up timeout, using default 2m: %v", err)
+			warmUpTimeout = 2 * time.Minute
 		}
 
-		writeTimeout, err := time.ParseDuration(cfg.WriteTimeout)
+		warmUpCtx, warmUpCancel := context.WithTimeout(ctx, warmUpTimeout)
+		err = consistencyService.RecoverRedisFromDatabase(warmUpCtx)
+		warmUpCancel()
 		if err != nil {
-			log.Printf("Invalid write timeout, using default 10s: %v", err)
-			writeTimeout = 10 * time.Second
+			log.Fatal("Startup warm-up failed, refusing to serve with unverified Redis counters: ", err)
 		}
+	}
+	ready.Store(true)
+
+	// Parse server timeouts
+	readTimeout, err := time.ParseDuration(cfg.ReadTimeout)
+	if err != nil {
+		log.Printf("Invalid read timeout, using default 10s: %v", err)
+		readTimeout = 10 * time.Second
+	}
+
+	writeTimeout, err := time.ParseDuration(cfg.WriteTimeout)
+	if err != nil {
+		log.Printf("Invalid write timeout, using default 10s: %v", err)
+		writeTimeout = 10 * time.Second
+	}
+
+	tlsConfig, err := bootstrap.ServerTLSConfig(ctx, cfg)
+	if err != nil {
+		log.Fatal("Invalid server TLS configuration:", err)
+	}
 
-		// Configure server with timeouts
-		s := &http.Server{
-			Addr:         ":" + cfg.Port,
-			Handler:      e,
+	// Start server with timeouts
+	server := &http.Server{
+		Addr:         ":" + cfg.Port,
+		Handler:      e,
+		ReadTimeout:  readTimeout,
+		WriteTimeout: writeTimeout,
+		TLSConfig:    tlsConfig,
+	}
+	go serveHTTP(server, "server")
+
+	// Start admin server (if on its own port)
+	var adminServer *http.Server
+	if adminEcho != e {
+		adminServer = &http.Server{
+			Addr:         net.JoinHostPort(cfg.AdminHost, cfg.AdminPort),
+			Handler:      adminEcho,
 			ReadTimeout:  readTimeout,
 			WriteTimeout: writeTimeout,
+			TLSConfig:    tlsConfig,
 		}
+		log.Printf("Serving admin and test endpoints on %s", adminServer.Addr)
+		go serveHTTP(adminServer, "admin server")
+	}
 
-		if err := s.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatal("Failed to start server:", err)
-		}
-	}()
+	// Start gRPC server (if enabled)
+	var grpcServer *grpc.Server
+	if cfg.EnableGRPC {
+		grpcServer = startGRPC(ctx, cfg, transactionService, balanceWatchers, authenticator, tlsConfig)
+	}
 
 	// Wait for interrupt signal
 	quit := make(chan os.Signal, 1)
@@ -187,217 +721,176 @@ func main() {
 	<-quit
 
 	log.Println("Shutting down server...")
+	ready.Store(false)
 	cancel()
 
-	// Graceful shutdown (if enabled)
-	if cfg.EnableGracefulShutdown {
-		shutdownTimeout, err := time.ParseDuration(cfg.RequestTimeout)
-		if err != nil {
-			log.Printf("Invalid request timeout, using default 10s: %v", err)
-			shutdownTimeout = 10 * time.Second
-		}
-		ctx, cancel = context.WithTimeout(context.Background(), shutdownTimeout)
-		defer cancel()
+	shutdownTimeout, err := time.ParseDuration(cfg.RequestTimeout)
+	if err != nil {
+		log.Printf("Invalid request timeout, using default 10s: %v", err)
+		shutdownTimeout = 10 * time.Second
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
 
-		if err := e.Shutdown(ctx); err != nil {
-			log.Fatal("Server forced to shutdown:", err)
+	// Graceful shutdown menunggu request yang sedang berjalan; tanpa itu
+	// koneksi langsung ditutup
+	stopHTTP(ctx, server, "Server", cfg.EnableGracefulShutdown)
+	if adminServer != nil {
+		stopHTTP(ctx, adminServer, "Admin server", cfg.EnableGracefulShutdown)
+	}
+	if grpcServer != nil {
+		if cfg.EnableGracefulShutdown {
+			// Stream sudah diakhiri oleh cancel(), tinggal menunggu RPC selesai
+			grpcServer.GracefulStop()
+		} else {
+			grpcServer.Stop()
 		}
 	}
 
-	log.Println("Server exited")
-}
-
-func initDatabase(cfg *config.Config) (*gorm.DB, error) {
-	db, err := gorm.Open(postgres.Open(cfg.DatabaseURL), &gorm.Config{})
+	// Settlement yang sedang berjalan selalu ditunggu, juga tanpa graceful
+	// shutdown, supaya tidak ada account yang tertinggal di antara update
+	// balance dan update status. Timeout-nya terpisah: drain HTTP yang lambat
+	// tidak boleh menghabiskan waktu settlement
+	settlementTimeout, err := time.ParseDuration(cfg.SettlementShutdownTimeout)
 	if err != nil {
-		return nil, err
+		log.Printf("Invalid settlement shutdown timeout, using default 1m: %v", err)
+		settlementTimeout = time.Minute
 	}
-
-	// Configure connection pool
-	sqlDB, err := db.DB()
-	if err != nil {
-		return nil, err
+	settlementCtx, settlementCancel := context.WithTimeout(context.Background(), settlementTimeout)
+	defer settlementCancel()
+	log.Println("Waiting for in-flight settlement to finish...")
+	if err := transactionService.WaitForSettlement(settlementCtx); err != nil {
+		log.Printf("Settlement drain timed out: %v", err)
 	}
 
-	// Parse connection max lifetime
-	connMaxLifetime, err := time.ParseDuration(cfg.DBConnMaxLifetime)
-	if err != nil {
-		log.Printf("Invalid DB connection max lifetime, using default 5m: %v", err)
-		connMaxLifetime = 5 * time.Minute
+	// Publish yang masih menunggu ack JetStream atau Kafka diselesaikan dulu
+	if natsConn != nil {
+		if err := natsConn.Drain(); err != nil {
+			log.Printf("Failed to drain NATS connection: %v", err)
+		}
+	}
+	if kafkaPublisher != nil {
+		if err := kafkaPublisher.Close(); err != nil {
+			log.Printf("Failed to close Kafka publisher: %v", err)
+		}
 	}
 
-	sqlDB.SetMaxOpenConns(cfg.DBMaxOpenConns)
-	sqlDB.SetMaxIdleConns(cfg.DBMaxIdleConns)
-	sqlDB.SetConnMaxLifetime(connMaxLifetime)
-
-	// Auto migrate
-	err = db.AutoMigrate(
-		&repository.AccountBalance{},
-		&repository.SubBalance{},
-	)
-	if err != nil {
-		return nil, err
+	flushCtx, flushCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer flushCancel()
+	if err := shutdownTracing(flushCtx); err != nil {
+		log.Printf("Failed to flush traces: %v", err)
 	}
 
-	return db, nil
+	log.Println("Server exited")
 }
 
-func initRedis(cfg *config.Config) *redis.Client {
-	// Parse Redis timeouts
-	dialTimeout, err := time.ParseDuration(cfg.RedisDialTimeout)
-	if err != nil {
-		log.Printf("Invalid Redis dial timeout, using default 5s: %v", err)
-		dialTimeout = 5 * time.Second
+// stopHTTP shuts srv down, waiting for in-flight requests until ctx is done
+// when graceful, or closing every connection at once otherwise
+func stopHTTP(ctx context.Context, srv *http.Server, name string, graceful bool) {
+	var err error
+	if graceful {
+		err = srv.Shutdown(ctx)
+	} else {
+		err = srv.Close()
 	}
-
-	readTimeout, err := time.ParseDuration(cfg.RedisReadTimeout)
 	if err != nil {
-		log.Printf("Invalid Redis read timeout, using default 3s: %v", err)
-		readTimeout = 3 * time.Second
+		log.Printf("%s forced to shutdown: %v", name, err)
 	}
+}
 
-	writeTimeout, err := time.ParseDuration(cfg.RedisWriteTimeout)
-	if err != nil {
-		log.Printf("Invalid Redis write timeout, using default 3s: %v", err)
-		writeTimeout = 3 * time.Second
+// startGRPC serves the gRPC API on GRPC_PORT, with the HTTP listener's TLS
+// config when it has one; streams end when ctx is done
+func startGRPC(ctx context.Context, cfg *config.Config, transactionService service.TransactionService, watchers *service.BalanceWatchers, authenticator *auth.Authenticator, tlsConfig *tls.Config) *grpc.Server {
+	options := grpcapi.Options{Authenticator: authenticator}
+	if cfg.EnableMultiTenancy {
+		options.TenantHeader = cfg.TenantHeader
 	}
 
-	rdb := redis.NewClient(&redis.Options{
-		Addr:         cfg.RedisURL,
-		PoolSize:     cfg.RedisPoolSize,
-		MinIdleConns: cfg.RedisMinIdleConns,
-		MaxRetries:   cfg.RedisMaxRetries,
-		DialTimeout:  dialTimeout,
-		ReadTimeout:  readTimeout,
-		WriteTimeout: writeTimeout,
-	})
+	var creds credentials.TransportCredentials
+	if tlsConfig != nil {
+		creds = credentials.NewTLS(grpcTLSConfig(tlsConfig))
+	}
+	server := grpcapi.NewBalanceServer(ctx, transactionService, watchers, options).Register(creds)
 
-	// Test connection
-	ctx := context.Background()
-	_, err = rdb.Ping(ctx).Result()
+	listener, err := net.Listen("tcp", ":"+cfg.GRPCPort)
 	if err != nil {
-		log.Fatal("Failed to connect to Redis:", err)
+		log.Fatalf("Failed to listen for gRPC on :%s: %v", cfg.GRPCPort, err)
 	}
-
-	return rdb
-}
-
-func setupRoutes(e *echo.Echo, h *handler.TransactionHandler) {
-	api := e.Group("/api/v1")
-	api.POST("/transaction", h.ProcessTransaction)
-	api.GET("/balance/:account_id", h.GetBalance)
-	api.GET("/pending/:account_id", h.GetPendingTransactions)
-	api.GET("/health", h.HealthCheck)
-}
-
-func setupMonitoring(e *echo.Echo, cfg *config.Config) {
-	// Basic metrics endpoint
-	e.GET("/metrics", func(c echo.Context) error {
-		// Simple metrics response
-		metrics := map[string]interface{}{
-			"app_name":    cfg.AppName,
-			"app_version": cfg.AppVersion,
-			"app_env":     cfg.AppEnv,
-			"timestamp":   time.Now().Unix(),
-			"uptime":      time.Since(time.Now()).String(), // This would be better with actual uptime tracking
-		}
-		return c.JSON(http.StatusOK, metrics)
-	})
-
-	// Health check with more details
-	e.GET("/health/detailed", func(c echo.Context) error {
-		health := map[string]interface{}{
-			"status":    "healthy",
-			"timestamp": time.Now().Unix(),
-			"version":   cfg.AppVersion,
-			"features": map[string]bool{
-				"redis_fallback":    cfg.EnableRedisFallback,
-				"circuit_breaker":   cfg.EnableCircuitBreaker,
-				"data_consistency":  cfg.EnableDataConsistencyCheck,
-				"auto_recovery":     cfg.EnableAutoRecovery,
-				"graceful_shutdown": cfg.EnableGracefulShutdown,
-				"rate_limiting":     cfg.EnableRateLimit,
-				"monitoring":        cfg.EnableMetrics,
-			},
-		}
-		return c.JSON(http.StatusOK, health)
-	})
-}
-
-func setupTestRoutes(e *echo.Echo, cfg *config.Config, transactionHandler *handler.TransactionHandler) {
-	// Test routes for development/testing
-	test := e.Group("/test")
-
-	// Test account creation
-	test.POST("/accounts", transactionHandler.CreateAccount)
-
-	// Test data cleanup
-	test.DELETE("/cleanup", func(c echo.Context) error {
-		if cfg.TestDataCleanup {
-			// This would clean up test data
-			response := map[string]interface{}{
-				"message": "Test data cleanup enabled",
-				"prefix":  cfg.TestAccountPrefix,
-			}
-			return c.JSON(http.StatusOK, response)
+	log.Printf("Serving gRPC on :%s", cfg.GRPCPort)
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			log.Fatalf("Failed to start gRPC server: %v", err)
 		}
-		return c.JSON(http.StatusForbidden, map[string]string{
-			"error": "Test data cleanup is disabled",
-		})
-	})
+	}()
+	return server
 }
 
-// Custom middleware for concurrent request limiting
-func concurrentRequestLimiter(maxConcurrent int) echo.MiddlewareFunc {
-	semaphore := make(chan struct{}, maxConcurrent)
-
-	return func(next echo.HandlerFunc) echo.HandlerFunc {
-		return func(c echo.Context) error {
-			select {
-			case semaphore <- struct{}{}:
-				defer func() { <-semaphore }()
-				return next(c)
-			default:
-				return c.JSON(http.StatusTooManyRequests, map[string]string{
-					"error": "Too many concurrent requests",
-				})
+// grpcTLSConfig makes the HTTP listener's TLS config offer h2 via ALPN, also
+// in the per-connection configs it returns for mutual TLS, as gRPC requires
+func grpcTLSConfig(base *tls.Config) *tls.Config {
+	config := base.Clone()
+	config.NextProtos = []string{"h2"}
+	if base.GetConfigForClient != nil {
+		config.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			perConnection, err := base.GetConfigForClient(hello)
+			if perConnection != nil {
+				perConnection.NextProtos = []string{"h2"}
 			}
+			return perConnection, err
 		}
 	}
+	return config
 }
 
-// Custom middleware for rate limiting
-func rateLimiter(requests int, window time.Duration) echo.MiddlewareFunc {
-	// Simple in-memory rate limiter (in production, use Redis)
-	requestsPerWindow := make(map[string][]time.Time)
-
-	return func(next echo.HandlerFunc) echo.HandlerFunc {
-		return func(c echo.Context) error {
-			clientIP := c.RealIP()
-			now := time.Now()
-
-			// Clean old requests
-			if timestamps, exists := requestsPerWindow[clientIP]; exists {
-				var validTimestamps []time.Time
-				for _, ts := range timestamps {
-					if now.Sub(ts) < window {
-						validTimestamps = append(validTimestamps, ts)
-					}
-				}
-				requestsPerWindow[clientIP] = validTimestamps
-			}
-
-			// Check if limit exceeded
-			if len(requestsPerWindow[clientIP]) >= requests {
-				return c.JSON(http.StatusTooManyRequests, map[string]string{
-					"error": "Rate limit exceeded",
-				})
-			}
+// serveHTTP runs s until it is shut down, with TLS when s.TLSConfig is set
+func serveHTTP(s *http.Server, name string) {
+	var err error
+	if s.TLSConfig != nil {
+		err = s.ListenAndServeTLS("", "") // certificate sudah ada di TLSConfig
+	} else {
+		err = s.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
+		log.Fatalf("Failed to start %s: %v", name, err)
+	}
+}
 
-			// Add current request
-			requestsPerWindow[clientIP] = append(requestsPerWindow[clientIP], now)
+// encryptConfig prints the enc: value of the variable named in args, with
+// the value read from stdin (a trailing newline is dropped)
+func encryptConfig(args []string) {
+	if len(args) != 1 {
+		log.Fatal("Usage: encrypt-config NAME < value")
+	}
+	value, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		log.Fatal("Failed to read value:", err)
+	}
+	key, err := secrets.MasterKey(context.Background())
+	if err != nil {
+		log.Fatal("Failed to load master key:", err)
+	}
+	encrypted, err := secrets.Encrypt(key, args[0], strings.TrimSuffix(string(value), "\n"))
+	if err != nil {
+		log.Fatal("Failed to encrypt value:", err)
+	}
+	fmt.Println(encrypted)
+}
 
-			return next(c)
+// startPprof serves net/http/pprof on its own listener, bound to PprofHost
+// (localhost by default) so profiles never leak through the public port
+func startPprof(cfg *config.Config) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	addr := net.JoinHostPort(cfg.PprofHost, cfg.PprofPort)
+	go func() {
+		log.Printf("Serving pprof on http://%s/debug/pprof/", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Printf("pprof server stopped: %v", err)
 		}
-	}
+	}()
 }
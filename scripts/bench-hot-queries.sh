@@ -0,0 +1,70 @@
+#!/bin/bash
+
+# =====================================================
+# Hot Query Benchmark (statement caching)
+# =====================================================
+# Menjalankan query terpanas (baca balance, update balance,
+# insert sub_balance) dengan pgbench pada tiga protocol mode
+# untuk membandingkan efek statement caching:
+#   simple    ~ DB_QUERY_EXEC_MODE=simple_protocol
+#   extended  ~ DB_QUERY_EXEC_MODE=exec / describe_exec
+#   prepared  ~ DB_QUERY_EXEC_MODE=cache_statement
+# Insert dan update di-rollback sehingga data tidak berubah.
+# =====================================================
+
+set -e
+
+# Colors for output
+RED='\033[0;31m'
+GREEN='\033[0;32m'
+BLUE='\033[0;34m'
+NC='\033[0m' # No Color
+
+# Configuration
+DB_HOST="${DB_HOST:-localhost}"
+DB_PORT="${DB_PORT:-5432}"
+DB_NAME="${DB_NAME:-subbalance}"
+DB_USER="${DB_USER:-ahmadfadilah}"
+export PGPASSWORD="${DB_PASSWORD:-postgres}"
+
+ACCOUNT_ID="${1:-ACC001}"
+CLIENTS="${CLIENTS:-16}"
+DURATION="${DURATION:-30}"
+
+if ! command -v pgbench >/dev/null 2>&1; then
+    echo -e "${RED}❌ pgbench not found (install postgresql-contrib / postgresql-client)${NC}"
+    exit 1
+fi
+
+if ! pg_isready -h "$DB_HOST" -p "$DB_PORT" -U "$DB_USER" >/dev/null 2>&1; then
+    echo -e "${RED}❌ PostgreSQL is not running or not accessible${NC}"
+    exit 1
+fi
+
+SCRIPT_FILE="$(mktemp)"
+trap 'rm -f "$SCRIPT_FILE"' EXIT
+
+cat > "$SCRIPT_FILE" <<SQL
+\set amount random(1, 1000)
+BEGIN;
+SELECT id, tenant_id, created_at, updated_at, settled_balance, pending_debit,
+	pending_credit, available_balance, version, last_settlement_at, COALESCE(last_settlement_id, '')
+FROM account_balances WHERE id = '$ACCOUNT_ID';
+INSERT INTO sub_balances (id, account_id, tenant_id, amount, type, status, attempts, created_at, updated_at)
+VALUES (gen_random_uuid()::text, '$ACCOUNT_ID', 'default', :amount, 'credit', 'PENDING', 0, now(), now());
+UPDATE account_balances SET pending_credit = pending_credit + :amount, updated_at = now()
+WHERE id = '$ACCOUNT_ID';
+ROLLBACK;
+SQL
+
+echo -e "${BLUE}🚀 Hot query benchmark: account $ACCOUNT_ID, $CLIENTS clients, ${DURATION}s per mode${NC}"
+echo "=========================================="
+
+for mode in simple extended prepared; do
+    tps=$(pgbench -h "$DB_HOST" -p "$DB_PORT" -U "$DB_USER" -n -M "$mode" \
+        -c "$CLIENTS" -j "$CLIENTS" -T "$DURATION" -f "$SCRIPT_FILE" "$DB_NAME" 2>/dev/null |
+        awk '/^tps/ {print $3; exit}')
+    printf "  %-10s %s tps\n" "$mode" "${tps:-failed}"
+done
+
+echo -e "${GREEN}✅ Benchmark completed${NC}"
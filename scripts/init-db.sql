@@ -6,6 +6,7 @@
 -- Create account_balances table
 CREATE TABLE IF NOT EXISTS account_balances (
     id VARCHAR(50) PRIMARY KEY,
+    tenant_id VARCHAR(50) NOT NULL DEFAULT 'default',
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     settled_balance DECIMAL(20,2) NOT NULL DEFAULT 0,
@@ -20,6 +21,7 @@ CREATE TABLE IF NOT EXISTS account_balances (
 CREATE TABLE IF NOT EXISTS sub_balances (
     id VARCHAR(50) PRIMARY KEY,
     account_id VARCHAR(50) NOT NULL,
+    tenant_id VARCHAR(50) NOT NULL DEFAULT 'default',
     amount DECIMAL(20,2) NOT NULL,
     status VARCHAR(20) NOT NULL DEFAULT 'PENDING',
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
@@ -27,9 +29,11 @@ CREATE TABLE IF NOT EXISTS sub_balances (
 );
 
 -- Create indexes
-CREATE INDEX IF NOT EXISTS idx_sub_balance_account_status ON sub_balances(account_id, status);
+CREATE INDEX IF NOT EXISTS idx_sub_balances_account_status ON sub_balances(account_id, status);
+CREATE INDEX IF NOT EXISTS idx_sub_balances_status_account_created ON sub_balances(status, account_id, created_at);
 CREATE INDEX IF NOT EXISTS idx_sub_balance_created_at ON sub_balances(created_at);
 CREATE INDEX IF NOT EXISTS idx_account_balance_updated_at ON account_balances(updated_at);
+CREATE INDEX IF NOT EXISTS idx_account_balances_tenant_id ON account_balances(tenant_id);
 
 -- Insert sample data
 INSERT INTO account_balances (id, settled_balance, available_balance) 
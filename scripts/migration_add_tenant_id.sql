@@ -0,0 +1,10 @@
+-- Migration: Add tenant_id to account_balances and sub_balances
+-- Existing rows belong to the 'default' tenant, which keeps the original Redis
+-- key layout, so no counter needs to be rebuilt.
+
+-- On Postgres 11+ a constant default does not rewrite the table
+ALTER TABLE account_balances ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(50) NOT NULL DEFAULT 'default';
+ALTER TABLE sub_balances ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(50) NOT NULL DEFAULT 'default';
+
+-- Listing accounts per tenant; sub_balances queries always narrow by account_id first
+CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_account_balances_tenant_id ON account_balances(tenant_id);
@@ -0,0 +1,32 @@
+-- =====================================================
+-- Composite Indexes for Settlement and Pending Queries
+-- =====================================================
+-- GetAllPending (settlement worker) and the consistency SUM queries were doing
+-- full scans at scale. This migration creates the composite indexes they need
+-- and drops the single-column indexes the composites make redundant.
+-- Run outside a transaction (CONCURRENTLY):
+--   psql -d subbalance -f scripts/migration_composite_pending_indexes.sql
+-- =====================================================
+
+-- Settlement worker: WHERE status = 'PENDING' ORDER BY account_id, created_at
+CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_sub_balances_status_account_created
+ON sub_balances (status, account_id, created_at);
+
+-- Pending lists and consistency sums: WHERE account_id [= | IN] ? AND status = 'PENDING'
+CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_sub_balances_account_status
+ON sub_balances (account_id, status);
+
+-- Redundant: prefix of idx_sub_balances_account_status
+DROP INDEX CONCURRENTLY IF EXISTS idx_sub_balances_account_id;
+-- Redundant: prefix of idx_sub_balances_status_account_created
+DROP INDEX CONCURRENTLY IF EXISTS idx_sub_balances_status;
+-- Duplicate of idx_sub_balances_account_status created by init-db.sql
+DROP INDEX CONCURRENTLY IF EXISTS idx_sub_balance_account_status;
+
+ANALYZE sub_balances;
+
+-- Verify the settlement query uses the composite index:
+-- EXPLAIN (ANALYZE, BUFFERS)
+-- SELECT * FROM sub_balances
+-- WHERE status = 'PENDING' AND deleted_at IS NULL
+-- ORDER BY account_id, created_at ASC;
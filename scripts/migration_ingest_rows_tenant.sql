@@ -0,0 +1,14 @@
+-- Migration: claim ingest references per tenant
+-- The reference of an imported, ingested or Kafka transaction becomes unique
+-- per (tenant_id, source, file_name, reference), so the CSV import endpoint
+-- can reject a reference already imported for the tenant while another tenant
+-- may use the same one. Existing claims keep the 'default' tenant. AutoMigrate
+-- adds the column but does not rebuild the existing unique index.
+
+BEGIN;
+
+ALTER TABLE ingest_rows ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(50) NOT NULL DEFAULT 'default';
+DROP INDEX IF EXISTS idx_ingest_rows_reference;
+CREATE UNIQUE INDEX idx_ingest_rows_reference ON ingest_rows (tenant_id, source, file_name, reference);
+
+COMMIT;
@@ -0,0 +1,25 @@
+-- Migration: key accounts by (tenant_id, id)
+-- Account IDs become unique per tenant instead of globally, so two tenants can
+-- each have an ACC001. Tables keyed by account get tenant_id in their key as
+-- well. Run after migration_add_tenant_id.sql; existing rows keep the
+-- 'default' tenant. AutoMigrate does not change an existing primary key, so
+-- this must run before starting the new version on an existing database.
+
+BEGIN;
+
+ALTER TABLE account_balances DROP CONSTRAINT IF EXISTS account_balances_pkey;
+ALTER TABLE account_balances ADD PRIMARY KEY (tenant_id, id);
+-- Prefix of the new primary key
+DROP INDEX IF EXISTS idx_account_balances_tenant_id;
+
+ALTER TABLE settlement_quarantine ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(50) NOT NULL DEFAULT 'default';
+ALTER TABLE settlement_quarantine DROP CONSTRAINT IF EXISTS settlement_quarantine_pkey;
+ALTER TABLE settlement_quarantine ADD PRIMARY KEY (tenant_id, account_id);
+
+ALTER TABLE balance_snapshots ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(50) NOT NULL DEFAULT 'default';
+ALTER TABLE balance_snapshots DROP CONSTRAINT IF EXISTS balance_snapshots_pkey;
+ALTER TABLE balance_snapshots ADD PRIMARY KEY (tenant_id, account_id, snapshot_date);
+
+ALTER TABLE settlement_reconciliations ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(50) NOT NULL DEFAULT 'default';
+
+COMMIT;